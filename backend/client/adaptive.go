@@ -0,0 +1,251 @@
+package client
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// tuning is the adaptive batch size and flush interval. Batch size follows
+// latency: a full batch answered well within TargetLatency grows the next
+// one by MinBatch, and a request slower than TargetLatency halves it.
+// The flush interval follows pushback: a 429 or 503 doubles it, or sets it
+// to the server's Retry-After if that is longer, and every success shrinks
+// it back towards MinFlush.
+type tuning struct {
+	config *Config
+
+	mu       sync.Mutex
+	size     int
+	interval time.Duration
+}
+
+func newTuning(config *Config) tuning {
+	return tuning{config: config, size: config.MinBatch, interval: config.MinFlush}
+}
+
+func (t *tuning) current() (int, time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.size, t.interval
+}
+
+func (t *tuning) batchSize() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.size
+}
+
+// throttled reports whether the server pushed back and the interval has not
+// recovered yet, in which case full batches wait their turn too
+func (t *tuning) throttled() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.interval > t.config.MinFlush
+}
+
+// succeeded adapts to a request that stored n logs in latency
+func (t *tuning) succeeded(n int, latency time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	switch {
+	case latency > t.config.TargetLatency:
+		t.size = max(t.size/2, t.config.MinBatch)
+	case latency < t.config.TargetLatency/2 && n >= t.size:
+		t.size = min(t.size+t.config.MinBatch, t.config.MaxBatch)
+	}
+	t.interval = max(t.interval*4/5, t.config.MinFlush)
+}
+
+// pushedBack adapts to a 429 or 503, with the server's Retry-After if any
+func (t *tuning) pushedBack(retryAfter time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.interval = min(max(t.interval*2, retryAfter), t.config.MaxFlush)
+}
+
+// slowed adapts to a request that timed out or failed slower than
+// TargetLatency, which usually means the batch was too much for the server
+func (t *tuning) slowed() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.size = max(t.size/2, t.config.MinBatch)
+}
+
+// breaker is the circuit breaker. BreakerThreshold failed requests in a row
+// open it, and it holds requests for BreakerCooldown. The first request
+// after that is a probe: a success closes the breaker, a failure opens it
+// for another cooldown. The sender makes one request at a time, so nothing
+// else is sent while the probe is in flight.
+type breaker struct {
+	threshold int
+	cooldown  time.Duration
+
+	mu        sync.Mutex
+	failures  int
+	openUntil time.Time
+}
+
+// wait returns how long requests are held
+func (b *breaker) wait(now time.Time) time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return max(b.openUntil.Sub(now), 0)
+}
+
+func (b *breaker) isOpen(now time.Time) bool {
+	return b.wait(now) > 0
+}
+
+func (b *breaker) succeeded() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures = 0
+	b.openUntil = time.Time{}
+}
+
+func (b *breaker) failed(now time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures++
+	if b.failures >= b.threshold {
+		b.openUntil = now.Add(b.cooldown)
+	}
+}
+
+// StatusError is a response the server did not store a batch with
+type StatusError struct {
+	StatusCode int
+	Body       string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("logger responded %d: %s", e.StatusCode, e.Body)
+}
+
+// retryable reports whether a failed request may succeed if sent again
+func retryable(err error) bool {
+	status, ok := err.(*StatusError)
+	if !ok {
+		return true
+	}
+	return status.StatusCode == http.StatusTooManyRequests || status.StatusCode >= 500
+}
+
+// send posts a batch until it is stored, retrying with full-jitter
+// exponential backoff behind the breaker. Once Close is called, or when
+// closing is set, each batch gets one more attempt without waiting. It
+// returns false when the batch was dropped.
+func (c *Client) send(batch []Entry, closing bool) bool {
+	if len(batch) == 0 {
+		return true
+	}
+	body, err := json.Marshal(batch)
+	if err != nil {
+		c.drop(batch, err)
+		return false
+	}
+	var delay time.Duration
+	for attempt := 0; ; attempt++ {
+		delay = max(delay, c.breaker.wait(time.Now()))
+		if !closing && delay > 0 {
+			timer := time.NewTimer(delay)
+			select {
+			case <-timer.C:
+			case <-c.stop:
+				timer.Stop()
+				closing = true
+			}
+		}
+		retryAfter, err := c.post(body, len(batch))
+		if err == nil {
+			c.sent.Add(int64(len(batch)))
+			return true
+		}
+		if !retryable(err) || closing || attempt >= c.config.MaxRetries {
+			c.drop(batch, err)
+			return false
+		}
+		c.retries.Add(1)
+		delay = min(max(c.backoff(attempt), retryAfter), c.config.MaxBackoff)
+	}
+}
+
+// post makes one request and adapts the tuning and breaker to its outcome;
+// retryAfter is the server's Retry-After on a 429 or 503
+func (c *Client) post(body []byte, n int) (retryAfter time.Duration, err error) {
+	req, err := http.NewRequest(http.MethodPost, c.config.URL+"/api/logs", bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if c.config.Token != "" {
+		req.Header.Set("X-Ingest-Token", c.config.Token)
+	}
+	start := time.Now()
+	resp, err := c.config.HTTPClient.Do(req)
+	latency := time.Since(start)
+	if err != nil {
+		if latency > c.config.TargetLatency {
+			c.tuning.slowed()
+		}
+		c.breaker.failed(time.Now())
+		return 0, err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusCreated, http.StatusAccepted:
+		io.Copy(io.Discard, resp.Body)
+		c.tuning.succeeded(n, latency)
+		c.breaker.succeeded()
+		return 0, nil
+	case http.StatusTooManyRequests, http.StatusServiceUnavailable:
+		retryAfter = parseRetryAfter(resp.Header.Get("Retry-After"), time.Now())
+		c.tuning.pushedBack(retryAfter)
+	}
+	if resp.StatusCode >= 500 {
+		c.breaker.failed(time.Now())
+	}
+	text, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	return retryAfter, &StatusError{StatusCode: resp.StatusCode, Body: string(bytes.TrimSpace(text))}
+}
+
+// backoff is the full-jitter delay before retry attempt+1: a random
+// duration up to BaseBackoff doubled attempt times, capped at MaxBackoff
+func (c *Client) backoff(attempt int) time.Duration {
+	ceiling := c.config.MaxBackoff
+	if attempt < 32 {
+		ceiling = min(c.config.BaseBackoff<<attempt, c.config.MaxBackoff)
+	}
+	if ceiling <= 0 {
+		ceiling = c.config.MaxBackoff
+	}
+	return rand.N(ceiling) + 1
+}
+
+// parseRetryAfter reads a Retry-After header in seconds or as an HTTP date
+func parseRetryAfter(header string, now time.Time) time.Duration {
+	if header == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(header); err == nil {
+		return max(time.Duration(seconds)*time.Second, 0)
+	}
+	if at, err := http.ParseTime(header); err == nil {
+		return max(at.Sub(now), 0)
+	}
+	return 0
+}
+
+func (c *Client) drop(batch []Entry, err error) {
+	c.dropped.Add(int64(len(batch)))
+	if c.config.OnError != nil {
+		c.config.OnError(err, len(batch))
+	}
+}
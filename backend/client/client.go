@@ -0,0 +1,294 @@
+// Package client sends logs from Go programs to a logger server. Logs are
+// queued without blocking and posted in batches to POST /api/logs by a
+// background sender that adapts to the server: batches grow while requests
+// are fast and shrink when they slow down, the flush interval backs off on
+// 429 and 503 responses, failed batches are retried with jittered
+// exponential backoff, and a circuit breaker stops sending to a server that
+// keeps failing. A client with the default Config behaves well without
+// tuning:
+//
+//	c := client.New(client.Config{URL: "http://logger:8080", Token: "it_..."})
+//	defer c.Close(context.Background())
+//	c.Log(client.Entry{Level: "WARN", Rule: "Brute Force Login", SourceIP: "10.0.0.5", Event: "Failed login"})
+package client
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Entry mirrors the JSON fields of the server's LogEntry
+type Entry struct {
+	Timestamp       time.Time `json:"timestamp"`
+	Level           string    `json:"level"`
+	Rule            string    `json:"rule"`
+	SourceIP        string    `json:"sourceIP"`
+	DestinationIP   string    `json:"destinationIP"`
+	Event           string    `json:"event"`
+	Description     string    `json:"description"`
+	Urgency         int       `json:"urgency"`
+	User            string    `json:"user,omitempty"`
+	TraceID         string    `json:"traceId,omitempty"`
+	SourcePort      int       `json:"sourcePort,omitempty"`
+	DestinationPort int       `json:"destinationPort,omitempty"`
+	Tags            []string  `json:"tags,omitempty"`
+	// Fields are sent as extra top-level keys, which the server keeps in
+	// the log's raw payload; see WithField
+	Fields map[string]any `json:"-"`
+}
+
+// Config configures a Client. Only URL is required; zero fields take the
+// defaults in parentheses.
+type Config struct {
+	// URL is the server's base URL, such as http://logger:8080
+	URL string
+	// Token is an ingest token, sent as X-Ingest-Token
+	Token string
+	// HTTPClient sends the requests (a client with a 30s timeout)
+	HTTPClient *http.Client
+
+	// BufferSize is how many logs may wait to be sent; Log drops logs
+	// beyond it (10000)
+	BufferSize int
+	// MinBatch and MaxBatch bound the adaptive batch size (10, 1000)
+	MinBatch, MaxBatch int
+	// MinFlush and MaxFlush bound the adaptive flush interval, the longest
+	// a partial batch waits and the shortest gap between requests
+	// (100ms, 10s)
+	MinFlush, MaxFlush time.Duration
+	// TargetLatency is the request latency batches grow towards; a slower
+	// request halves the batch size (500ms)
+	TargetLatency time.Duration
+
+	// MaxRetries is how many times a failed batch is retried before it is
+	// dropped (5)
+	MaxRetries int
+	// BaseBackoff and MaxBackoff bound the jittered exponential backoff
+	// between retries (200ms, 30s)
+	BaseBackoff, MaxBackoff time.Duration
+	// BreakerThreshold failed requests in a row open the circuit breaker,
+	// which holds every request for BreakerCooldown before trying one
+	// again (5, 30s)
+	BreakerThreshold int
+	BreakerCooldown  time.Duration
+
+	// OnError, when set, is called with every batch that is dropped
+	OnError func(err error, dropped int)
+}
+
+func (c *Config) setDefaults() {
+	c.URL = strings.TrimRight(c.URL, "/")
+	if c.HTTPClient == nil {
+		c.HTTPClient = &http.Client{Timeout: 30 * time.Second}
+	}
+	setDefault(&c.BufferSize, 10000)
+	setDefault(&c.MinBatch, 10)
+	setDefault(&c.MaxBatch, 1000)
+	c.MaxBatch = max(c.MaxBatch, c.MinBatch)
+	setDefault(&c.MinFlush, 100*time.Millisecond)
+	setDefault(&c.MaxFlush, 10*time.Second)
+	c.MaxFlush = max(c.MaxFlush, c.MinFlush)
+	setDefault(&c.TargetLatency, 500*time.Millisecond)
+	setDefault(&c.MaxRetries, 5)
+	setDefault(&c.BaseBackoff, 200*time.Millisecond)
+	setDefault(&c.MaxBackoff, 30*time.Second)
+	setDefault(&c.BreakerThreshold, 5)
+	setDefault(&c.BreakerCooldown, 30*time.Second)
+}
+
+func setDefault[T int | time.Duration](field *T, value T) {
+	if *field <= 0 {
+		*field = value
+	}
+}
+
+// ErrClosed is returned for logs still queued when Close gives up
+var ErrClosed = errors.New("client closed")
+
+// Stats counts what a Client has done and shows its current tuning
+type Stats struct {
+	Sent    int64
+	Dropped int64
+	Retries int64
+	Queued  int
+	// Suppressed counts the records adapters did not send because of
+	// Sampling
+	Suppressed int64
+	// BatchSize and FlushInterval are the current adaptive settings
+	BatchSize     int
+	FlushInterval time.Duration
+	// BreakerOpen is set while the circuit breaker holds requests
+	BreakerOpen bool
+}
+
+// Client queues logs and sends them in the background
+type Client struct {
+	config Config
+
+	mu         sync.Mutex
+	queue      []Entry
+	suppressed map[summaryKey]int64
+	closed     bool
+	wake       chan struct{}
+	stop       chan struct{}
+	done       chan struct{}
+
+	tuning  tuning
+	breaker breaker
+
+	sent, dropped, retries, suppressedTotal atomic.Int64
+}
+
+// New starts a Client; call Close to send what is queued and stop it
+func New(config Config) *Client {
+	config.setDefaults()
+	c := &Client{
+		config: config,
+		wake:   make(chan struct{}, 1),
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	c.tuning = newTuning(&c.config)
+	c.breaker = breaker{threshold: config.BreakerThreshold, cooldown: config.BreakerCooldown}
+	go c.run()
+	return c
+}
+
+// Log queues an entry, stamping it with the current time if it has none.
+// It never blocks; it returns false when the entry was dropped because the
+// buffer is full or the client is closed.
+func (c *Client) Log(e Entry) bool {
+	if e.Timestamp.IsZero() {
+		e.Timestamp = time.Now()
+	}
+	c.mu.Lock()
+	if c.closed || len(c.queue) >= c.config.BufferSize {
+		c.mu.Unlock()
+		c.dropped.Add(1)
+		return false
+	}
+	c.queue = append(c.queue, e)
+	full := len(c.queue) >= c.tuning.batchSize()
+	c.mu.Unlock()
+	if full {
+		select {
+		case c.wake <- struct{}{}:
+		default:
+		}
+	}
+	return true
+}
+
+// Stats returns the client's counters and current tuning
+func (c *Client) Stats() Stats {
+	c.mu.Lock()
+	queued := len(c.queue)
+	c.mu.Unlock()
+	size, interval := c.tuning.current()
+	return Stats{
+		Sent:          c.sent.Load(),
+		Dropped:       c.dropped.Load(),
+		Retries:       c.retries.Load(),
+		Queued:        queued,
+		Suppressed:    c.suppressedTotal.Load(),
+		BatchSize:     size,
+		FlushInterval: interval,
+		BreakerOpen:   c.breaker.isOpen(time.Now()),
+	}
+}
+
+// Close stops accepting logs and sends the queued ones, giving each batch
+// one attempt without backoff or waiting on the breaker, until they are sent
+// or ctx is done. Logs still queued then are dropped and ErrClosed is
+// returned.
+func (c *Client) Close(ctx context.Context) error {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		<-c.done
+		return nil
+	}
+	c.closed = true
+	c.mu.Unlock()
+	close(c.stop)
+	select {
+	case <-c.done:
+	case <-ctx.Done():
+	}
+	c.mu.Lock()
+	left := len(c.queue)
+	c.queue = nil
+	c.mu.Unlock()
+	if left > 0 {
+		c.dropped.Add(int64(left))
+		return ErrClosed
+	}
+	return nil
+}
+
+// take removes up to n queued entries
+func (c *Client) take(n int) []Entry {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	n = min(n, len(c.queue))
+	batch := make([]Entry, n)
+	copy(batch, c.queue)
+	c.queue = append(c.queue[:0], c.queue[n:]...)
+	return batch
+}
+
+func (c *Client) queued() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.queue)
+}
+
+// run sends a batch as soon as one is full, or once the flush interval has
+// passed since the last request. While the server is pushing back, full
+// batches wait out the flush interval too. Suppressed-record counts go out
+// after each batch that is sent, or on their own when there is nothing to
+// send.
+func (c *Client) run() {
+	defer close(c.done)
+	last := time.Now()
+	for {
+		size, interval := c.tuning.current()
+		wait := time.Until(last.Add(interval))
+		if c.queued() >= size && !c.tuning.throttled() {
+			wait = 0
+		}
+		timer := time.NewTimer(max(wait, 0))
+		select {
+		case <-c.stop:
+			timer.Stop()
+			c.drain()
+			return
+		case <-c.wake:
+			timer.Stop()
+			continue
+		case <-timer.C:
+		}
+		if c.queued() > 0 && !c.send(c.take(size), false) {
+			last = time.Now()
+			continue
+		}
+		c.sendSummaries()
+		last = time.Now()
+	}
+}
+
+// drain sends what is queued once Close is called
+func (c *Client) drain() {
+	for c.queued() > 0 {
+		size, _ := c.tuning.current()
+		if !c.send(c.take(size), true) {
+			return
+		}
+	}
+	c.sendSummaries()
+}
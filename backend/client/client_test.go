@@ -0,0 +1,346 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func expect(t *testing.T, what string, got, want interface{}) {
+	t.Helper()
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("%s: got %v, want %v", what, got, want)
+	}
+}
+
+// newTestClient starts a Client sending to handler; the test's cleanup
+// closes both
+func newTestClient(t *testing.T, config Config, handler http.HandlerFunc) *Client {
+	t.Helper()
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+	config.URL = server.URL
+	c := New(config)
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		c.Close(ctx)
+	})
+	return c
+}
+
+// waitFor polls until done reports true, failing the test after 5s
+func waitFor(t *testing.T, what string, done func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !done() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func logEntries(c *Client, n int) {
+	for i := 0; i < n; i++ {
+		c.Log(Entry{Level: "WARN", Rule: "Brute Force Login", SourceIP: "10.0.0.5", Event: "Failed login"})
+	}
+}
+
+func TestTuning(t *testing.T) {
+	config := Config{MinBatch: 10, MaxBatch: 25, MinFlush: 100 * time.Millisecond, MaxFlush: 2 * time.Second}
+	config.setDefaults()
+	tuning := newTuning(&config)
+	size := func() int { return tuning.batchSize() }
+
+	// Full batches answered well within TargetLatency grow by MinBatch, up to
+	// MaxBatch
+	tuning.succeeded(10, 100*time.Millisecond)
+	expect(t, "size after a fast full batch", size(), 20)
+	tuning.succeeded(20, 100*time.Millisecond)
+	expect(t, "size capped at MaxBatch", size(), 25)
+
+	// Partial batches, and ones near the target, leave it alone
+	tuning.succeeded(5, 100*time.Millisecond)
+	expect(t, "size after a partial batch", size(), 25)
+	tuning.succeeded(25, 400*time.Millisecond)
+	expect(t, "size after a batch near the target", size(), 25)
+
+	// Slower requests halve it, down to MinBatch
+	tuning.succeeded(25, time.Second)
+	expect(t, "size after a slow batch", size(), 12)
+	tuning.slowed()
+	expect(t, "size after a slow failure", size(), 10)
+	tuning.slowed()
+	expect(t, "size floored at MinBatch", size(), 10)
+
+	// Pushback doubles the interval, or takes a longer Retry-After, up to
+	// MaxFlush
+	interval := func() time.Duration { _, interval := tuning.current(); return interval }
+	expect(t, "throttled before pushback", tuning.throttled(), false)
+	tuning.pushedBack(0)
+	expect(t, "interval after pushback", interval(), 200*time.Millisecond)
+	tuning.pushedBack(time.Second)
+	expect(t, "interval after a Retry-After", interval(), time.Second)
+	tuning.pushedBack(time.Minute)
+	expect(t, "interval capped at MaxFlush", interval(), 2*time.Second)
+	expect(t, "throttled after pushback", tuning.throttled(), true)
+
+	// Successes shrink it back by a fifth each, down to MinFlush
+	tuning.succeeded(1, 100*time.Millisecond)
+	expect(t, "interval after a success", interval(), 1600*time.Millisecond)
+	for i := 0; i < 20; i++ {
+		tuning.succeeded(1, 100*time.Millisecond)
+	}
+	expect(t, "interval recovered", interval(), 100*time.Millisecond)
+	expect(t, "throttled once recovered", tuning.throttled(), false)
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2025, 3, 10, 12, 0, 0, 0, time.UTC)
+	for header, want := range map[string]time.Duration{
+		"":                              0,
+		"3":                             3 * time.Second,
+		"0":                             0,
+		"-5":                            0,
+		"soon":                          0,
+		"Mon, 10 Mar 2025 12:00:30 GMT": 30 * time.Second,
+		"Mon, 10 Mar 2025 11:59:00 GMT": 0,
+	} {
+		expect(t, "Retry-After "+header, parseRetryAfter(header, now), want)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	c := &Client{config: Config{BaseBackoff: 100 * time.Millisecond, MaxBackoff: 2 * time.Second}}
+	for attempt := 0; attempt < 70; attempt++ {
+		ceiling := 2 * time.Second
+		if attempt < 5 {
+			ceiling = 100 * time.Millisecond << attempt
+		}
+		// Full jitter: anything up to the ceiling, so retries spread out
+		seen := map[time.Duration]bool{}
+		for i := 0; i < 50; i++ {
+			delay := c.backoff(attempt)
+			if delay <= 0 || delay > ceiling {
+				t.Fatalf("attempt %d: delay %v outside (0, %v]", attempt, delay, ceiling)
+			}
+			seen[delay] = true
+		}
+		if len(seen) < 2 {
+			t.Errorf("attempt %d: delays are not jittered", attempt)
+		}
+	}
+}
+
+func TestBreaker(t *testing.T) {
+	now := time.Date(2025, 3, 10, 12, 0, 0, 0, time.UTC)
+	b := breaker{threshold: 3, cooldown: time.Minute}
+	b.failed(now)
+	b.failed(now)
+	expect(t, "open below the threshold", b.isOpen(now), false)
+
+	// The threshold opens it for the cooldown
+	b.failed(now)
+	expect(t, "wait once open", b.wait(now), time.Minute)
+	expect(t, "wait part way", b.wait(now.Add(40*time.Second)), 20*time.Second)
+
+	// After the cooldown a probe goes out; its failure opens it again
+	probe := now.Add(time.Minute)
+	expect(t, "open after the cooldown", b.isOpen(probe), false)
+	b.failed(probe)
+	expect(t, "wait after a failed probe", b.wait(probe), time.Minute)
+
+	// A successful probe closes it, and the count starts over
+	probe = probe.Add(time.Minute)
+	b.succeeded()
+	expect(t, "open after a successful probe", b.isOpen(probe), false)
+	b.failed(probe)
+	b.failed(probe)
+	expect(t, "open after fewer failures than the threshold", b.isOpen(probe), false)
+}
+
+// A batch pushed back with 429 and 503 is retried until it is stored, and
+// the pushback slows the flush interval
+func TestClientPushback(t *testing.T) {
+	var mu sync.Mutex
+	var requests int
+	c := newTestClient(t, Config{
+		Token:    "it_test",
+		MinBatch: 10, MinFlush: 100 * time.Millisecond, MaxFlush: time.Second,
+		BaseBackoff: time.Millisecond, MaxBackoff: 50 * time.Millisecond,
+	}, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/logs" || r.Header.Get("X-Ingest-Token") != "it_test" {
+			http.Error(w, "unexpected request", http.StatusBadRequest)
+			return
+		}
+		mu.Lock()
+		requests++
+		n := requests
+		mu.Unlock()
+		switch n {
+		case 1:
+			w.Header().Set("Retry-After", "1")
+			http.Error(w, "slow down", http.StatusTooManyRequests)
+		case 2:
+			http.Error(w, "overloaded", http.StatusServiceUnavailable)
+		default:
+			w.WriteHeader(http.StatusCreated)
+		}
+	})
+	logEntries(c, 10)
+	waitFor(t, "the batch to be stored", func() bool { return c.Stats().Sent == 10 })
+
+	stats := c.Stats()
+	expect(t, "retries", stats.Retries, int64(2))
+	expect(t, "dropped", stats.Dropped, int64(0))
+	// The Retry-After took the interval to MaxFlush, and one success took a
+	// fifth off
+	expect(t, "flush interval", stats.FlushInterval, 800*time.Millisecond)
+	expect(t, "breaker open", stats.BreakerOpen, false)
+}
+
+// A batch the server refuses outright is dropped without retries
+func TestClientRejected(t *testing.T) {
+	var mu sync.Mutex
+	var dropped int
+	var reported error
+	c := newTestClient(t, Config{
+		MinBatch: 10, MinFlush: 100 * time.Millisecond,
+		OnError: func(err error, n int) {
+			mu.Lock()
+			defer mu.Unlock()
+			reported, dropped = err, n
+		},
+	}, func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "bad log", http.StatusBadRequest)
+	})
+	logEntries(c, 10)
+	waitFor(t, "the batch to be dropped", func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return dropped > 0
+	})
+
+	mu.Lock()
+	defer mu.Unlock()
+	var status *StatusError
+	if !errors.As(reported, &status) || status.StatusCode != http.StatusBadRequest || status.Body != "bad log" {
+		t.Fatalf("reported error %v", reported)
+	}
+	expect(t, "reported drop", dropped, 10)
+	expect(t, "dropped", c.Stats().Dropped, int64(10))
+	expect(t, "retries", c.Stats().Retries, int64(0))
+}
+
+// A server that keeps failing opens the breaker, which holds requests for
+// the cooldown before a probe; a failed probe holds them for another, and a
+// successful one closes it
+func TestClientBreaker(t *testing.T) {
+	const cooldown = 100 * time.Millisecond
+	var mu sync.Mutex
+	var arrivals []time.Time
+	c := newTestClient(t, Config{
+		MinBatch: 10, MinFlush: 100 * time.Millisecond,
+		MaxRetries: 10, BaseBackoff: time.Millisecond, MaxBackoff: 2 * time.Millisecond,
+		BreakerThreshold: 2, BreakerCooldown: cooldown,
+	}, func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		arrivals = append(arrivals, time.Now())
+		n := len(arrivals)
+		mu.Unlock()
+		if n <= 3 {
+			http.Error(w, "down", http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+	})
+	logEntries(c, 10)
+	waitFor(t, "the breaker to open", func() bool { return c.Stats().BreakerOpen })
+	waitFor(t, "the batch to be stored", func() bool { return c.Stats().Sent == 10 })
+
+	mu.Lock()
+	defer mu.Unlock()
+	expect(t, "requests", len(arrivals), 4)
+	// The two failures that opened it went out without waiting on it, and
+	// the probes each waited out a cooldown
+	if gap := arrivals[1].Sub(arrivals[0]); gap >= cooldown {
+		t.Errorf("second request waited %v", gap)
+	}
+	for _, probe := range []int{2, 3} {
+		if gap := arrivals[probe].Sub(arrivals[probe-1]); gap < cooldown {
+			t.Errorf("probe %d went out %v after the last failure", probe-1, gap)
+		}
+	}
+	expect(t, "breaker open", c.Stats().BreakerOpen, false)
+}
+
+// Close sends what is queued in batches of the current size
+func TestClientCloseDrains(t *testing.T) {
+	var mu sync.Mutex
+	var requests int
+	c := newTestClient(t, Config{MinBatch: 10, MaxBatch: 10, MinFlush: time.Hour}, func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requests++
+		mu.Unlock()
+		w.WriteHeader(http.StatusCreated)
+	})
+	// Nine logs do not fill a batch, and the flush interval is an hour away,
+	// so Close sends them; then it refuses more
+	logEntries(c, 9)
+	if err := c.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	expect(t, "sent", c.Stats().Sent, int64(9))
+	mu.Lock()
+	expect(t, "requests", requests, 1)
+	mu.Unlock()
+	if c.Log(Entry{Event: "late"}) {
+		t.Error("log accepted after Close")
+	}
+	expect(t, "dropped", c.Stats().Dropped, int64(1))
+	if err := c.Close(context.Background()); err != nil {
+		t.Fatalf("second Close: %v", err)
+	}
+}
+
+// Close gives up on a server that does not answer when its context is done,
+// dropping what is still queued
+func TestClientCloseDeadline(t *testing.T) {
+	arrived := make(chan struct{}, 1)
+	release := make(chan struct{})
+	c := newTestClient(t, Config{MinBatch: 10, MaxBatch: 10}, func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case arrived <- struct{}{}:
+		default:
+		}
+		<-release
+		w.WriteHeader(http.StatusCreated)
+	})
+	// Cleanups run last first, so the stuck request is released before the
+	// client and server are closed
+	t.Cleanup(func() { close(release) })
+
+	logEntries(c, 25)
+	<-arrived
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if err := c.Close(ctx); !errors.Is(err, ErrClosed) {
+		t.Fatalf("Close: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Close took %v past its deadline", elapsed)
+	}
+	// The batch in flight is still being sent; the 15 logs behind it are
+	// dropped
+	stats := c.Stats()
+	expect(t, "dropped", stats.Dropped, int64(15))
+	expect(t, "queued", stats.Queued, 0)
+	expect(t, "sent", stats.Sent, int64(0))
+}
@@ -0,0 +1,115 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+)
+
+// Request-scoped fields. A request handler attaches its request ID, user
+// and tenant to the request's context once, and every log sent with that
+// context carries them: through LogContext, or a slog Handler given the
+// context by the slog.Logger *Context methods. The server keeps fields it
+// has no column for in the log's raw payload, where expressions read them
+// as meta, such as meta.requestId.
+
+// Field names of the request-scoped fields
+const (
+	FieldRequestID = "requestId"
+	FieldTenant    = "tenant"
+)
+
+type fieldsKey struct{}
+
+// WithField returns a copy of ctx whose logs carry key set to value
+func WithField(ctx context.Context, key string, value any) context.Context {
+	parent := FieldsFrom(ctx)
+	fields := make(map[string]any, len(parent)+1)
+	for k, v := range parent {
+		fields[k] = v
+	}
+	fields[key] = value
+	return context.WithValue(ctx, fieldsKey{}, fields)
+}
+
+// WithRequestID returns a copy of ctx whose logs carry the request ID
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return WithField(ctx, FieldRequestID, id)
+}
+
+// WithTenant returns a copy of ctx whose logs carry the tenant
+func WithTenant(ctx context.Context, tenant string) context.Context {
+	return WithField(ctx, FieldTenant, tenant)
+}
+
+type userKey struct{}
+
+// WithUser returns a copy of ctx whose logs have the user, unless a log
+// names its own
+func WithUser(ctx context.Context, user string) context.Context {
+	return context.WithValue(ctx, userKey{}, user)
+}
+
+// FieldsFrom returns the fields attached to ctx; it must not be modified
+func FieldsFrom(ctx context.Context) map[string]any {
+	fields, _ := ctx.Value(fieldsKey{}).(map[string]any)
+	return fields
+}
+
+// withContext adds ctx's fields to e; fields e already has are kept
+func withContext(ctx context.Context, e Entry) Entry {
+	if e.User == "" {
+		e.User, _ = ctx.Value(userKey{}).(string)
+	}
+	fields := FieldsFrom(ctx)
+	if len(fields) == 0 {
+		return e
+	}
+	merged := make(map[string]any, len(fields)+len(e.Fields))
+	for k, v := range fields {
+		merged[k] = v
+	}
+	for k, v := range e.Fields {
+		merged[k] = v
+	}
+	e.Fields = merged
+	return e
+}
+
+// LogContext queues an entry like Log, with the fields attached to ctx
+func (c *Client) LogContext(ctx context.Context, e Entry) bool {
+	return c.Log(withContext(ctx, e))
+}
+
+// MarshalJSON sends Fields as top-level keys next to the log's own. The
+// server matches keys to log fields ignoring case, so a field named like
+// one of them in any case is left out.
+func (e Entry) MarshalJSON() ([]byte, error) {
+	type entry Entry
+	data, err := json.Marshal(entry(e))
+	if err != nil || len(e.Fields) == 0 {
+		return data, err
+	}
+	var object map[string]json.RawMessage
+	if err := json.Unmarshal(data, &object); err != nil {
+		return nil, err
+	}
+	for key, value := range e.Fields {
+		if logFields[strings.ToLower(key)] {
+			continue
+		}
+		raw, err := json.Marshal(value)
+		if err != nil {
+			return nil, err
+		}
+		object[key] = raw
+	}
+	return json.Marshal(object)
+}
+
+// logFields are the server's log fields, in lower case
+var logFields = map[string]bool{
+	"id": true, "timestamp": true, "level": true, "rule": true, "sourceip": true, "destinationip": true,
+	"event": true, "description": true, "urgency": true, "user": true, "traceid": true, "sourceport": true,
+	"destinationport": true, "category": true, "tags": true, "annotations": true, "archive": true,
+}
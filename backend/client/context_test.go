@@ -0,0 +1,91 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestEntryMarshalJSON(t *testing.T) {
+	at := time.Date(2025, 3, 10, 12, 0, 0, 0, time.UTC)
+	e := Entry{Timestamp: at, Level: "WARN", Rule: "Brute Force Login", Event: "Failed login"}
+	plain, err := json.Marshal(e)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect(t, "without fields", string(plain),
+		`{"timestamp":"2025-03-10T12:00:00Z","level":"WARN","rule":"Brute Force Login","sourceIP":"","destinationIP":"","event":"Failed login","description":"","urgency":0}`)
+
+	// Fields become top-level keys, except ones named like a log field in
+	// any case
+	e.Fields = map[string]any{"requestId": "r-1", "retry": 2, "client": map[string]any{"os": "linux"}, "LEVEL": "DEBUG", "tags": "x"}
+	data, err := json.Marshal(e)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got map[string]any
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatal(err)
+	}
+	expect(t, "with fields", got, map[string]any{
+		"timestamp": "2025-03-10T12:00:00Z", "level": "WARN", "rule": "Brute Force Login", "sourceIP": "", "destinationIP": "",
+		"event": "Failed login", "description": "", "urgency": float64(0),
+		"requestId": "r-1", "retry": float64(2), "client": map[string]any{"os": "linux"},
+	})
+
+	// A field that cannot be sent fails the marshalling
+	e.Fields = map[string]any{"bad": make(chan int)}
+	if _, err := json.Marshal(e); err == nil {
+		t.Error("unmarshallable field marshalled")
+	}
+}
+
+func TestWithContext(t *testing.T) {
+	parent := WithTenant(WithRequestID(context.Background(), "r-1"), "acme")
+	child := WithUser(WithField(parent, FieldRequestID, "r-2"), "alice")
+	expect(t, "parent fields", FieldsFrom(parent), map[string]any{FieldRequestID: "r-1", FieldTenant: "acme"})
+	expect(t, "child fields", FieldsFrom(child), map[string]any{FieldRequestID: "r-2", FieldTenant: "acme"})
+	expect(t, "fields of a bare context", FieldsFrom(context.Background()), map[string]any(nil))
+
+	// The entry's own user and fields win over the context's
+	e := withContext(child, Entry{Event: "x", Fields: map[string]any{FieldTenant: "other", "step": 1}})
+	expect(t, "user", e.User, "alice")
+	expect(t, "fields", e.Fields, map[string]any{FieldRequestID: "r-2", FieldTenant: "other", "step": 1})
+	expect(t, "named user", withContext(child, Entry{User: "bob"}).User, "bob")
+	expect(t, "context fields untouched", FieldsFrom(child), map[string]any{FieldRequestID: "r-2", FieldTenant: "acme"})
+	expect(t, "bare context", withContext(context.Background(), Entry{Event: "x"}), Entry{Event: "x"})
+}
+
+// Logs sent with LogContext, or through a Handler with the slog *Context
+// methods, reach the server with the context's fields as top-level keys
+func TestContextFieldsSent(t *testing.T) {
+	var mu sync.Mutex
+	var stored []map[string]any
+	c := newTestClient(t, Config{MinBatch: 10, MinFlush: time.Hour}, func(w http.ResponseWriter, r *http.Request) {
+		var batch []map[string]any
+		json.NewDecoder(r.Body).Decode(&batch)
+		mu.Lock()
+		stored = append(stored, batch...)
+		mu.Unlock()
+		w.WriteHeader(http.StatusCreated)
+	})
+	ctx := WithUser(WithRequestID(context.Background(), "r-1"), "alice")
+	c.LogContext(ctx, Entry{Level: "WARN", Event: "Failed login"})
+	slog.New(NewHandler(c, nil)).InfoContext(ctx, "Logged in", "user", "root")
+	if err := c.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	expect(t, "logs", len(stored), 2)
+	for i, want := range [][2]string{{"Failed login", "alice"}, {"Logged in", "root"}} {
+		expect(t, "event", stored[i]["event"], want[0])
+		expect(t, "user", stored[i]["user"], want[1])
+		expect(t, "request ID", stored[i][FieldRequestID], "r-1")
+	}
+}
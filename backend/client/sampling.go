@@ -0,0 +1,72 @@
+package client
+
+import (
+	"math/rand/v2"
+	"sync"
+	"time"
+)
+
+// Sampling limits how many records of one level an adapter sends. Records
+// it suppresses are not lost without trace: they are counted per minute,
+// level and rule, and the counts go to POST /api/ingest/summaries with the
+// client's next batch.
+type Sampling struct {
+	// Rate is the fraction of records that are sent, between 0 and 1; zero
+	// sends them all
+	Rate float64
+	// PerSecond caps how many records are sent each second; zero is no cap
+	PerSecond int
+}
+
+// sampler applies Sampling per level; levels without one are always sent
+type sampler struct {
+	levels map[string]Sampling
+
+	mu      sync.Mutex
+	windows map[string]*sampleWindow
+}
+
+// sampleWindow counts the records of a level sent in the current second
+type sampleWindow struct {
+	second int64
+	sent   int
+}
+
+func newSampler(levels map[string]Sampling) *sampler {
+	if len(levels) == 0 {
+		return nil
+	}
+	return &sampler{levels: levels, windows: make(map[string]*sampleWindow)}
+}
+
+// allow reports whether a record of level should be sent
+func (s *sampler) allow(level string, now time.Time) bool {
+	if s == nil {
+		return true
+	}
+	rule, ok := s.levels[level]
+	if !ok {
+		return true
+	}
+	if rule.Rate > 0 && rule.Rate < 1 && rand.Float64() >= rule.Rate {
+		return false
+	}
+	if rule.PerSecond <= 0 {
+		return true
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	w := s.windows[level]
+	if w == nil {
+		w = &sampleWindow{}
+		s.windows[level] = w
+	}
+	if second := now.Unix(); w.second != second {
+		w.second, w.sent = second, 0
+	}
+	if w.sent >= rule.PerSecond {
+		return false
+	}
+	w.sent++
+	return true
+}
@@ -0,0 +1,204 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// HandlerOptions configures a Handler
+type HandlerOptions struct {
+	// Level is the lowest level handled (slog.LevelInfo)
+	Level slog.Leveler
+	// Rule is the rule of records without a "rule" attribute
+	Rule string
+	// Sampling limits the records sent per level, by the names the server
+	// uses: DEBUG, INFO, WARN and ERROR. Levels without an entry are always
+	// sent, so {"DEBUG": {PerSecond: 100}} sends at most 100 DEBUG records a
+	// second and every ERROR.
+	Sampling map[string]Sampling
+}
+
+// Handler is a slog.Handler that sends records through a Client. The
+// message becomes the log's event. Top-level attributes named like the
+// fields of Entry (rule, sourceIP, destinationIP, description, urgency,
+// user, traceId, sourcePort, destinationPort and tags) fill those fields;
+// other attributes are appended to the description as key=value. Fields
+// attached to the record's context with WithField and its kin are added.
+type Handler struct {
+	client  *Client
+	opts    HandlerOptions
+	sampler *sampler
+	attrs   []groupedAttr
+	group   string
+}
+
+// groupedAttr is an attribute added with WithAttrs under the groups open
+// at the time
+type groupedAttr struct {
+	group string
+	attr  slog.Attr
+}
+
+// NewHandler returns a Handler sending through c; opts may be nil
+func NewHandler(c *Client, opts *HandlerOptions) *Handler {
+	h := &Handler{client: c}
+	if opts != nil {
+		h.opts = *opts
+	}
+	if h.opts.Level == nil {
+		h.opts.Level = slog.LevelInfo
+	}
+	h.sampler = newSampler(h.opts.Sampling)
+	return h
+}
+
+func (h *Handler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= h.opts.Level.Level()
+}
+
+func (h *Handler) Handle(ctx context.Context, r slog.Record) error {
+	e := Entry{Timestamp: r.Time, Level: levelName(r.Level), Rule: h.opts.Rule, Event: r.Message}
+	var extra []string
+	for _, a := range h.attrs {
+		setAttr(&e, &extra, a.group, a.attr)
+	}
+	r.Attrs(func(a slog.Attr) bool {
+		setAttr(&e, &extra, h.group, a)
+		return true
+	})
+	if len(extra) > 0 {
+		e.Description = strings.TrimSpace(e.Description + " " + strings.Join(extra, " "))
+	}
+	if ctx != nil {
+		e = withContext(ctx, e)
+	}
+	if !h.sampler.allow(e.Level, time.Now()) {
+		h.client.suppress(e)
+		return nil
+	}
+	h.client.Log(e)
+	return nil
+}
+
+func (h *Handler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	if len(attrs) == 0 {
+		return h
+	}
+	clone := *h
+	clone.attrs = make([]groupedAttr, len(h.attrs), len(h.attrs)+len(attrs))
+	copy(clone.attrs, h.attrs)
+	for _, a := range attrs {
+		clone.attrs = append(clone.attrs, groupedAttr{group: h.group, attr: a})
+	}
+	return &clone
+}
+
+func (h *Handler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	clone := *h
+	clone.group = h.group + name + "."
+	return &clone
+}
+
+// levelName maps a slog level to the server's level names
+func levelName(level slog.Level) string {
+	switch {
+	case level < slog.LevelInfo:
+		return "DEBUG"
+	case level < slog.LevelWarn:
+		return "INFO"
+	case level < slog.LevelError:
+		return "WARN"
+	default:
+		return "ERROR"
+	}
+}
+
+// setAttr fills the field a names, or adds it to extra; group is the
+// dotted prefix of the groups a is in, and only ungrouped attributes fill
+// fields
+func setAttr(e *Entry, extra *[]string, group string, a slog.Attr) {
+	a.Value = a.Value.Resolve()
+	if a.Equal(slog.Attr{}) {
+		return
+	}
+	if a.Value.Kind() == slog.KindGroup {
+		if a.Key != "" {
+			group += a.Key + "."
+		}
+		for _, sub := range a.Value.Group() {
+			setAttr(e, extra, group, sub)
+		}
+		return
+	}
+	if group == "" && setField(e, a) {
+		return
+	}
+	value := a.Value.String()
+	if value == "" || strings.ContainsAny(value, " \"=") {
+		value = strconv.Quote(value)
+	}
+	*extra = append(*extra, group+a.Key+"="+value)
+}
+
+// setField sets the Entry field named a.Key, reporting whether there is one
+func setField(e *Entry, a slog.Attr) bool {
+	switch a.Key {
+	case "rule":
+		e.Rule = a.Value.String()
+	case "sourceIP":
+		e.SourceIP = a.Value.String()
+	case "destinationIP":
+		e.DestinationIP = a.Value.String()
+	case "description":
+		e.Description = a.Value.String()
+	case "user":
+		e.User = a.Value.String()
+	case "traceId":
+		e.TraceID = a.Value.String()
+	case "urgency":
+		return intValue(a.Value, &e.Urgency)
+	case "sourcePort":
+		return intValue(a.Value, &e.SourcePort)
+	case "destinationPort":
+		return intValue(a.Value, &e.DestinationPort)
+	case "tags":
+		switch tags := a.Value.Any().(type) {
+		case []string:
+			e.Tags = append(e.Tags, tags...)
+		case string:
+			e.Tags = append(e.Tags, tags)
+		default:
+			e.Tags = append(e.Tags, fmt.Sprint(tags))
+		}
+	default:
+		return false
+	}
+	return true
+}
+
+// intValue sets *field from an integer attribute, reporting whether it was
+// one
+func intValue(v slog.Value, field *int) bool {
+	switch v.Kind() {
+	case slog.KindInt64:
+		*field = int(v.Int64())
+	case slog.KindUint64:
+		*field = int(v.Uint64())
+	case slog.KindString:
+		n, err := strconv.Atoi(v.String())
+		if err != nil {
+			return false
+		}
+		*field = n
+	default:
+		return false
+	}
+	return true
+}
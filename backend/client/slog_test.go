@@ -0,0 +1,156 @@
+package client
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+)
+
+// newIdleClient returns a Client that sends nothing until it is closed, so
+// a test can take what was queued
+func newIdleClient(t *testing.T) *Client {
+	return newTestClient(t, Config{MinBatch: 1000, MinFlush: time.Hour}, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+	})
+}
+
+func TestHandlerRecord(t *testing.T) {
+	c := newIdleClient(t)
+	logger := slog.New(NewHandler(c, &HandlerOptions{Rule: "api"}))
+	logger.With("sourceIP", "10.0.0.5").Warn("Failed login", "description", "Failed password",
+		slog.Group("", "user", "root"), "urgency", 3, "tags", []string{"auth"}, "note", "two words", "empty", "")
+	logger.WithGroup("req").With("path", "/login").Info("Request", "rule", "Scan", slog.Group("client", "ip", "10.0.0.9"))
+	logger.Info("Port scan", "rule", "Scan", "sourcePort", "4444", "destinationPort", "oops", "traceId", "abc")
+	logger.Debug("not handled")
+
+	got := c.take(10)
+	expect(t, "logs", len(got), 3)
+	for i := range got {
+		got[i].Timestamp = time.Time{}
+	}
+	// Attributes that name no field are appended to the description
+	expect(t, "fields", got[0], Entry{
+		Level: "WARN", Rule: "api", SourceIP: "10.0.0.5", Event: "Failed login", User: "root", Urgency: 3,
+		Tags: []string{"auth"}, Description: `Failed password note="two words" empty=""`,
+	})
+	// Grouped attributes never fill fields
+	expect(t, "groups", got[1], Entry{
+		Level: "INFO", Rule: "api", Event: "Request", Description: "req.path=/login req.rule=Scan req.client.ip=10.0.0.9",
+	})
+	// Ports that are not numbers are kept as text
+	expect(t, "ports", got[2], Entry{
+		Level: "INFO", Rule: "Scan", Event: "Port scan", SourcePort: 4444, TraceID: "abc",
+		Description: "destinationPort=oops",
+	})
+}
+
+func TestLevelName(t *testing.T) {
+	for level, want := range map[slog.Level]string{
+		slog.LevelDebug - 4: "DEBUG", slog.LevelDebug: "DEBUG", slog.LevelInfo: "INFO", slog.LevelInfo + 2: "INFO",
+		slog.LevelWarn: "WARN", slog.LevelError: "ERROR", slog.LevelError + 4: "ERROR",
+	} {
+		expect(t, level.String(), levelName(level), want)
+	}
+}
+
+func TestSampler(t *testing.T) {
+	if !(*sampler)(nil).allow("DEBUG", time.Now()) {
+		t.Fatal("a nil sampler suppressed a record")
+	}
+	now := time.Unix(1700000000, 0)
+	s := newSampler(map[string]Sampling{"DEBUG": {PerSecond: 3}, "INFO": {Rate: 0.5}, "WARN": {Rate: 1}})
+
+	// PerSecond counts per second, and other levels are not capped
+	allowed := 0
+	for i := 0; i < 10; i++ {
+		if s.allow("DEBUG", now.Add(time.Duration(i)*time.Millisecond)) {
+			allowed++
+		}
+		if !s.allow("ERROR", now) || !s.allow("WARN", now) {
+			t.Fatal("a level without a cap was suppressed")
+		}
+	}
+	expect(t, "DEBUG allowed in one second", allowed, 3)
+	expect(t, "DEBUG allowed in the next second", s.allow("DEBUG", now.Add(time.Second)), true)
+
+	// Rate sends about that fraction
+	allowed = 0
+	for i := 0; i < 10000; i++ {
+		if s.allow("INFO", now) {
+			allowed++
+		}
+	}
+	if allowed < 4000 || allowed > 6000 {
+		t.Errorf("rate 0.5 allowed %d of 10000", allowed)
+	}
+}
+
+// Records suppressed by Sampling are counted, and the counts reach the
+// server even when its first answer is 503
+func TestHandlerSuppressedSummaries(t *testing.T) {
+	var mu sync.Mutex
+	var summaryRequests int
+	var stored []summary
+	var sent int
+	c := newTestClient(t, Config{MinBatch: 10, MinFlush: 20 * time.Millisecond}, func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		switch r.URL.Path {
+		case "/api/logs":
+			var batch []map[string]any
+			json.NewDecoder(r.Body).Decode(&batch)
+			sent += len(batch)
+			w.WriteHeader(http.StatusCreated)
+		case "/api/ingest/summaries":
+			if summaryRequests++; summaryRequests == 1 {
+				http.Error(w, "overloaded", http.StatusServiceUnavailable)
+				return
+			}
+			var summaries []summary
+			json.NewDecoder(r.Body).Decode(&summaries)
+			stored = append(stored, summaries...)
+			w.WriteHeader(http.StatusCreated)
+		}
+	})
+	logger := slog.New(NewHandler(c, &HandlerOptions{
+		Level: slog.LevelDebug, Rule: "worker",
+		Sampling: map[string]Sampling{"DEBUG": {PerSecond: 1}},
+	}))
+	// The five DEBUG records span at most two seconds, so three or four are
+	// suppressed; ERROR is never sampled
+	for i := 0; i < 5; i++ {
+		logger.Debug("tick")
+	}
+	logger.Error("failed")
+
+	suppressed := c.Stats().Suppressed
+	if suppressed < 3 || suppressed > 4 {
+		t.Fatalf("suppressed %d of 5 DEBUG records at 1 a second", suppressed)
+	}
+	total := func() int64 {
+		mu.Lock()
+		defer mu.Unlock()
+		var n int64
+		for _, s := range stored {
+			n += s.Count
+		}
+		return n
+	}
+	waitFor(t, "the suppressed counts", func() bool { return total() == suppressed })
+	waitFor(t, "the sent logs", func() bool { return c.Stats().Sent == 6-suppressed })
+
+	mu.Lock()
+	defer mu.Unlock()
+	expect(t, "logs the server got", int64(sent), 6-suppressed)
+	if summaryRequests < 2 {
+		t.Errorf("counts sent in %d requests despite a 503", summaryRequests)
+	}
+	for _, s := range stored {
+		if s.Level != "DEBUG" || s.Rule != "worker" || !s.Minute.Equal(s.Minute.Truncate(time.Minute)) {
+			t.Errorf("summary %+v", s)
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package client
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"time"
+)
+
+// summary mirrors the server's LogSummary
+type summary struct {
+	Minute time.Time `json:"minute"`
+	Level  string    `json:"level"`
+	Rule   string    `json:"rule"`
+	Count  int64     `json:"count"`
+}
+
+type summaryKey struct {
+	minute      time.Time
+	level, rule string
+}
+
+// suppress counts an entry an adapter's Sampling did not send
+func (c *Client) suppress(e Entry) {
+	if e.Timestamp.IsZero() {
+		e.Timestamp = time.Now()
+	}
+	key := summaryKey{minute: e.Timestamp.UTC().Truncate(time.Minute), level: e.Level, rule: e.Rule}
+	c.mu.Lock()
+	if c.suppressed == nil {
+		c.suppressed = make(map[summaryKey]int64)
+	}
+	c.suppressed[key]++
+	c.mu.Unlock()
+	c.suppressedTotal.Add(1)
+}
+
+// sendSummaries ships the suppressed counts to POST /api/ingest/summaries.
+// Counts that could not be shipped for a reason that may pass are kept for
+// the next time; the server adds up counts for the same minute.
+func (c *Client) sendSummaries() {
+	c.mu.Lock()
+	counts := c.suppressed
+	c.suppressed = nil
+	c.mu.Unlock()
+	if len(counts) == 0 {
+		return
+	}
+	if !c.breaker.isOpen(time.Now()) {
+		err := c.postSummaries(counts)
+		if err == nil || !retryable(err) {
+			return
+		}
+	}
+	c.mu.Lock()
+	if c.suppressed == nil {
+		c.suppressed = make(map[summaryKey]int64)
+	}
+	for key, n := range counts {
+		c.suppressed[key] += n
+	}
+	c.mu.Unlock()
+}
+
+func (c *Client) postSummaries(counts map[summaryKey]int64) error {
+	summaries := make([]summary, 0, len(counts))
+	for key, n := range counts {
+		summaries = append(summaries, summary{Minute: key.minute, Level: key.level, Rule: key.rule, Count: n})
+	}
+	body, err := json.Marshal(summaries)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, c.config.URL+"/api/ingest/summaries", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if c.config.Token != "" {
+		req.Header.Set("X-Ingest-Token", c.config.Token)
+	}
+	resp, err := c.config.HTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		text, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return &StatusError{StatusCode: resp.StatusCode, Body: string(bytes.TrimSpace(text))}
+	}
+	return nil
+}
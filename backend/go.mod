@@ -1,5 +1,8 @@
 module logger-backend
 
-go 1.21
+go 1.22
 
-require github.com/mattn/go-sqlite3 v1.14.17
+require (
+	github.com/klauspost/compress v1.18.0
+	github.com/mattn/go-sqlite3 v1.14.17
+)
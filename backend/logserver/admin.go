@@ -0,0 +1,73 @@
+package logserver
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// adminToken is read once at startup; admin-only features are disabled when it is empty
+var adminToken = os.Getenv("ADMIN_TOKEN")
+
+// requestRole returns the caller's role, and its API key ID if it presented
+// one. The configured admin token and admin API keys are admin, presented
+// either as X-Admin-Token or as a Bearer Authorization header. Otherwise the
+// registered auth providers are asked; callers none of them recognise are
+// anonymous.
+func (d *Database) requestRole(r *http.Request) (string, int64) {
+	role, id, _ := d.requestCredential(r)
+	return role, id
+}
+
+// requestActor names the credential behind a request, for the records it
+// makes: an API key's name, "admin" for the admin token or the name of the
+// auth provider that recognised the caller. Anonymous callers have none.
+func (d *Database) requestActor(r *http.Request) string {
+	_, _, actor := d.requestCredential(r)
+	return actor
+}
+
+// requestCredential is requestRole with the actor of requestActor
+func (d *Database) requestCredential(r *http.Request) (string, int64, string) {
+	token := r.Header.Get("X-Admin-Token")
+	if token == "" {
+		token = strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	}
+	if token != "" {
+		if id, role, ok := d.lookupAPIKey(token); ok {
+			return role, id, d.apiKeyName(id)
+		}
+		if adminToken != "" && subtle.ConstantTimeCompare([]byte(token), []byte(adminToken)) == 1 {
+			return roleAdmin, 0, roleAdmin
+		}
+	}
+	if name, role, ok := providerRole(r); ok {
+		return role, 0, name
+	}
+	return roleAnonymous, 0, ""
+}
+
+// requireAdmin writes a 403 and returns false when the request is not from
+// an admin, or a 429 while the client is locked out for repeated failures
+func (d *Database) requireAdmin(w http.ResponseWriter, r *http.Request) bool {
+	ip, now := clientIP(r), d.now()
+	if wait := d.authLockedFor(ip, now); wait > 0 {
+		writeLockedOut(w, wait)
+		return false
+	}
+	role, _ := d.requestRole(r)
+	if role == roleAdmin {
+		d.authSucceeded(ip, r)
+		return true
+	}
+	// Requests without any credential, or with a valid viewer key, are not
+	// login attempts
+	if presentedCredential(r) && role == roleAnonymous {
+		d.authFailed(ip, r, now)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusForbidden)
+	w.Write([]byte(`{"error":"Admin token required"}`))
+	return false
+}
@@ -0,0 +1,196 @@
+package logserver
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// In air-gapped mode the server makes no connections outside the local
+// network: outbound HTTP is refused unless the destination resolves to a
+// loopback, private or link-local address, and configured features that
+// point elsewhere are reported at startup and on /api/admin/airgap.
+var (
+	airGapped = airGappedBuild || os.Getenv("AIR_GAPPED") == "true"
+	// airGappedStrict refuses to start while anything is reported
+	airGappedStrict = os.Getenv("AIR_GAPPED_STRICT") == "true"
+)
+
+// airGapLookupTimeout bounds the DNS lookups of the configuration check
+const airGapLookupTimeout = 2 * time.Second
+
+// AirGapFinding is a configured feature that needs outbound access
+type AirGapFinding struct {
+	Feature     string `json:"feature"`
+	Setting     string `json:"setting"`
+	Destination string `json:"destination"`
+	Problem     string `json:"problem"`
+}
+
+func internalIP(ip net.IP) bool {
+	return ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast()
+}
+
+// lookupInternal resolves host and returns an error unless all of its
+// addresses are internal
+func lookupInternal(ctx context.Context, host string) ([]net.IPAddr, error) {
+	if ip := net.ParseIP(host); ip != nil {
+		if !internalIP(ip) {
+			return nil, fmt.Errorf("%s is a public address", host)
+		}
+		return []net.IPAddr{{IP: ip}}, nil
+	}
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil, fmt.Errorf("%s does not resolve", host)
+	}
+	for _, addr := range addrs {
+		if !internalIP(addr.IP) {
+			return nil, fmt.Errorf("%s resolves to public address %s", host, addr.IP)
+		}
+	}
+	return addrs, nil
+}
+
+// airGapDial only connects to internal addresses
+func airGapDial(ctx context.Context, network, address string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+	addrs, err := lookupInternal(ctx, host)
+	if err != nil {
+		return nil, fmt.Errorf("air-gapped mode: %v", err)
+	}
+	var dialer net.Dialer
+	return dialer.DialContext(ctx, network, net.JoinHostPort(addrs[0].IP.String(), port))
+}
+
+// outboundProblem describes why rawURL would leave the local network, or
+// returns "" when it stays inside
+func outboundProblem(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Hostname() == "" {
+		return "invalid URL"
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), airGapLookupTimeout)
+	defer cancel()
+	if _, err := lookupInternal(ctx, u.Hostname()); err != nil {
+		return err.Error()
+	}
+	return ""
+}
+
+// AirGapFindings lists every configured outbound destination outside the
+// local network
+func (d *Database) AirGapFindings() ([]AirGapFinding, error) {
+	findings := []AirGapFinding{}
+	check := func(feature, setting, destination string) {
+		if problem := outboundProblem(destination); problem != "" {
+			findings = append(findings, AirGapFinding{feature, setting, destination, problem})
+		}
+	}
+
+	if base := os.Getenv("ARCHIVE_URL"); base != "" && (strings.HasPrefix(base, "http://") || strings.HasPrefix(base, "https://")) {
+		check("archive", "ARCHIVE_URL", base)
+	}
+	if llmURL != "" {
+		check("nl_query", "NLQ_LLM_URL", llmURL)
+	}
+	switch os.Getenv("TICKET_PROVIDER") {
+	case "jira":
+		check("tickets", "JIRA_URL", os.Getenv("JIRA_URL"))
+	case "servicenow":
+		check("tickets", "SERVICENOW_URL", os.Getenv("SERVICENOW_URL"))
+	}
+
+	rules, err := d.GetAlertRules()
+	if err != nil {
+		return nil, err
+	}
+	for _, rule := range rules {
+		if rule.WebhookURL != "" {
+			check("alert_rules", fmt.Sprintf("%s (id %d) webhookURL", rule.Name, rule.ID), rule.WebhookURL)
+		}
+	}
+	subs, err := d.GetWebhookSubscriptions()
+	if err != nil {
+		return nil, err
+	}
+	for _, sub := range subs {
+		check("webhooks", fmt.Sprintf("subscription %d url", sub.ID), sub.URL)
+	}
+	actions, err := d.GetResponseActions()
+	if err != nil {
+		return nil, err
+	}
+	for _, a := range actions {
+		if a.URL != "" {
+			check("response_actions", fmt.Sprintf("%s (id %d) url", a.Name, a.ID), a.URL)
+		}
+	}
+	outputs, err := d.GetOutputs()
+	if err != nil {
+		return nil, err
+	}
+	for _, o := range outputs {
+		check("outputs", fmt.Sprintf("%s (id %d) url", o.Name, o.ID), o.URL)
+	}
+	return findings, nil
+}
+
+// enforceAirGap restricts the outbound HTTP clients to the local network
+// and reports the configuration that needs outbound access
+func enforceAirGap(d *Database) error {
+	if !airGapped {
+		return nil
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = airGapDial
+	notifyClient.Transport = transport
+	archiveClient.Transport = transport
+	llmClient.Transport = transport
+
+	findings, err := d.AirGapFindings()
+	if err != nil {
+		return err
+	}
+	for _, f := range findings {
+		log.Printf("air-gapped mode: %s %s needs outbound access: %s", f.Feature, f.Setting, f.Problem)
+	}
+	if airGappedStrict && len(findings) > 0 {
+		return fmt.Errorf("%d configured features need outbound access", len(findings))
+	}
+	log.Printf("air-gapped mode: outbound connections are limited to the local network")
+	return nil
+}
+
+// GET /api/admin/airgap - report configured features that need outbound access (admin only)
+func airGapHandlerDB(w http.ResponseWriter, r *http.Request, db *Database) {
+	enableCORS(w)
+	w.Header().Set("Content-Type", "application/json")
+	if !db.requireAdmin(w, r) {
+		return
+	}
+	if r.Method != http.MethodGet {
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	findings, err := db.AirGapFindings()
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "Failed to check configuration")
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"airGapped": airGapped,
+		"findings":  findings,
+	})
+}
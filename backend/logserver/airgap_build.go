@@ -0,0 +1,6 @@
+//go:build airgapped
+
+package logserver
+
+// Built with -tags airgapped: air-gapped mode is always on
+const airGappedBuild = true
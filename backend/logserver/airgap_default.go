@@ -0,0 +1,7 @@
+//go:build !airgapped
+
+package logserver
+
+// airGappedBuild is set by the airgapped build tag; otherwise AIR_GAPPED
+// turns air-gapped mode on at runtime
+const airGappedBuild = false
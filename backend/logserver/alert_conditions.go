@@ -0,0 +1,347 @@
+package logserver
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Boolean operators for combining alert conditions
+const (
+	alertOperatorAnd = "and"
+	alertOperatorOr  = "or"
+)
+
+// alertExpressionScanLimit bounds how many logs of a condition's window its
+// expression is evaluated against, newest first
+var alertExpressionScanLimit = envInt("ALERT_EXPRESSION_SCAN_LIMIT", 100000)
+
+// alertExpressionPage is how many logs an expression condition reads at a time
+const alertExpressionPage = 500
+
+// AlertCondition compares the number of logs matching Filter in the last
+// WindowSeconds against Threshold. Comparator defaults to ">=".
+type AlertCondition struct {
+	Filter        LogFilter `json:"filter"`
+	Comparator    string    `json:"comparator,omitempty"`
+	Threshold     int       `json:"threshold"`
+	WindowSeconds int       `json:"windowSeconds"`
+	// ClearThreshold replaces Threshold while the rule is firing, so a
+	// borderline count doesn't flip the alert on and off every cycle
+	ClearThreshold *int `json:"clearThreshold,omitempty"`
+	// Expression, if set, is a log expression (see expr.go) that each log
+	// matching Filter must also satisfy to be counted
+	Expression string `json:"expression,omitempty"`
+}
+
+// ConditionResult records how one condition evaluated for an alert firing
+type ConditionResult struct {
+	Count   int  `json:"count"`
+	Matched bool `json:"matched"`
+}
+
+func (c AlertCondition) validate() error {
+	if c.WindowSeconds <= 0 {
+		return errors.New("each condition needs windowSeconds > 0")
+	}
+	switch c.Comparator {
+	case "", ">=", ">", "<", "<=", "==", "!=":
+	default:
+		return fmt.Errorf("unknown comparator %q", c.Comparator)
+	}
+	if err := validateClearThreshold(c.Comparator, c.Threshold, c.ClearThreshold); err != nil {
+		return err
+	}
+	return validateExpression(c.Expression)
+}
+
+// validateClearThreshold checks that a clear threshold, if any, makes a
+// firing alert harder to clear under the comparator: no higher than
+// threshold for > and >=, no lower for < and <=. Equality comparators have
+// no direction to hold the alert in, so they take none.
+func validateClearThreshold(comparator string, threshold int, clear *int) error {
+	if clear == nil {
+		return nil
+	}
+	switch comparator {
+	case "", ">=", ">":
+		if *clear > threshold {
+			return errors.New("clearThreshold must not be above threshold")
+		}
+	case "<", "<=":
+		if *clear < threshold {
+			return fmt.Errorf("clearThreshold must not be below threshold with comparator %s", comparator)
+		}
+	default:
+		return fmt.Errorf("clearThreshold does not apply to comparator %s", comparator)
+	}
+	return nil
+}
+
+// validateExpression checks that an alert expression, if any, parses
+func validateExpression(expression string) error {
+	if expression == "" {
+		return nil
+	}
+	if _, err := parseLogExpr(expression); err != nil {
+		return errors.New("Invalid expression: " + err.Error())
+	}
+	return nil
+}
+
+// compare applies the comparator, using ClearThreshold when the rule is already firing
+func (c AlertCondition) compare(count int, firing bool) bool {
+	threshold := c.Threshold
+	if firing && c.ClearThreshold != nil {
+		threshold = *c.ClearThreshold
+	}
+	switch c.Comparator {
+	case ">":
+		return count > threshold
+	case "<":
+		return count < threshold
+	case "<=":
+		return count <= threshold
+	case "==":
+		return count == threshold
+	case "!=":
+		return count != threshold
+	default:
+		return count >= threshold
+	}
+}
+
+// window returns the condition's filter restricted to its window ending at now
+func (c AlertCondition) window(now time.Time) LogFilter {
+	filter := c.Filter
+	filter.From = now.Add(-time.Duration(c.WindowSeconds) * time.Second)
+	filter.To = now
+	return filter
+}
+
+// conditions returns the rule's composite conditions, or its single legacy
+// filter/threshold/window condition when none are set. A single condition
+// with an expression and no threshold fires on the first matching log.
+func (rule AlertRule) conditions() []AlertCondition {
+	if len(rule.Conditions) > 0 {
+		return rule.Conditions
+	}
+	threshold := rule.Threshold
+	if rule.Expression != "" && threshold <= 0 {
+		threshold = 1
+	}
+	return []AlertCondition{{Filter: rule.Filter, Expression: rule.Expression, Threshold: threshold, WindowSeconds: rule.WindowSeconds,
+		ClearThreshold: rule.ClearThreshold}}
+}
+
+// threshold is the rule's Threshold, or that of its expression condition
+func (rule AlertRule) threshold() int {
+	if len(rule.Conditions) == 0 {
+		return rule.conditions()[0].Threshold
+	}
+	return rule.Threshold
+}
+
+func (rule AlertRule) validate() error {
+	if rule.Name == "" {
+		return errors.New("name is required")
+	}
+	switch rule.Operator {
+	case "", alertOperatorAnd, alertOperatorOr:
+	default:
+		return errors.New("operator must be 'and' or 'or'")
+	}
+	if len(rule.Conditions) == 0 && ((rule.Threshold <= 0 && rule.Expression == "") || rule.WindowSeconds <= 0) {
+		return errors.New("threshold and windowSeconds are required without conditions")
+	}
+	if len(rule.Conditions) > 0 && rule.Expression != "" {
+		return errors.New("expression applies to rules without conditions; set it on each condition instead")
+	}
+	if err := validateExpression(rule.Expression); err != nil {
+		return err
+	}
+	for _, c := range rule.Conditions {
+		if err := c.validate(); err != nil {
+			return err
+		}
+	}
+	if rule.ForSeconds < 0 || rule.KeepFiringSeconds < 0 || rule.RenotifySeconds < 0 {
+		return errors.New("forSeconds, keepFiringSeconds and renotifySeconds must not be negative")
+	}
+	if len(rule.Conditions) > 0 && rule.ClearThreshold != nil {
+		return errors.New("clearThreshold applies to rules without conditions; set it on each condition instead")
+	}
+	if len(rule.Conditions) == 0 {
+		if err := validateClearThreshold(">=", rule.threshold(), rule.ClearThreshold); err != nil {
+			return err
+		}
+	}
+	if rule.MessageTemplate != "" {
+		if _, err := parseNotificationTemplate(rule.MessageTemplate); err != nil {
+			return errors.New("Invalid messageTemplate: " + err.Error())
+		}
+	}
+	return nil
+}
+
+// evaluateConditions counts every condition and combines the results with
+// the rule's operator. Samples are taken from the first matched condition
+// with matching logs: samples holds them for an expression condition, and
+// samplesFrom is the windowed filter to read them from otherwise.
+func (d *Database) evaluateConditions(rule AlertRule, now time.Time, firing bool) (fired bool, results []ConditionResult, samplesFrom *LogFilter, samples []LogEntry, err error) {
+	conditions := rule.conditions()
+	fired = rule.Operator != alertOperatorOr
+	for _, c := range conditions {
+		filter := c.window(now)
+		var count int
+		var matches []LogEntry
+		if c.Expression != "" {
+			expr, err := parseLogExpr(c.Expression)
+			if err != nil {
+				return false, nil, nil, nil, fmt.Errorf("alert rule %d: %w", rule.ID, err)
+			}
+			m, err := d.countExpression(filter, expr)
+			if err != nil {
+				return false, nil, nil, nil, err
+			}
+			if m.Failed > 0 {
+				log.Printf("alert rule %d: expression failed on %d logs: %s", rule.ID, m.Failed, m.Error)
+			}
+			count, matches = m.Count, m.Samples
+		} else if count, err = d.CountLogs(filter); err != nil {
+			return false, nil, nil, nil, err
+		}
+		matched := c.compare(count, firing)
+		results = append(results, ConditionResult{Count: count, Matched: matched})
+		if rule.Operator == alertOperatorOr {
+			fired = fired || matched
+		} else {
+			fired = fired && matched
+		}
+		if matched && samplesFrom == nil && samples == nil && count > 0 {
+			if c.Expression != "" {
+				samples = matches
+			} else {
+				f := filter
+				samplesFrom = &f
+			}
+		}
+	}
+	return fired, results, samplesFrom, samples, nil
+}
+
+// ExpressionMatch is how many logs of a window satisfy an expression, with
+// the newest alertSampleSize of them. Failed counts the logs the expression
+// failed on, such as by adding a string to a number, and Error is the first
+// of those failures.
+type ExpressionMatch struct {
+	Count   int        `json:"count"`
+	Failed  int        `json:"failed"`
+	Error   string     `json:"error,omitempty"`
+	Samples []LogEntry `json:"samples"`
+}
+
+// countExpression evaluates expr against the logs matching filter, reading
+// at most alertExpressionScanLimit of them, newest first
+func (d *Database) countExpression(filter LogFilter, expr *logExpr) (ExpressionMatch, error) {
+	m := ExpressionMatch{Samples: []LogEntry{}}
+	clause, args := filter.where()
+	var before int64
+	for scanned := 0; scanned < alertExpressionScanLimit; {
+		query := `SELECT ` + logColumns + ` FROM logs WHERE 1=1` + clause
+		pageArgs := append([]interface{}{}, args...)
+		if before > 0 {
+			query += ` AND id < ?`
+			pageArgs = append(pageArgs, before)
+		}
+		pageArgs = append(pageArgs, min(alertExpressionPage, alertExpressionScanLimit-scanned))
+		rows, err := d.db.Query(query+` ORDER BY id DESC LIMIT ?`, pageArgs...)
+		if err != nil {
+			return m, err
+		}
+		logs, err := d.scanLogs(rows)
+		rows.Close()
+		if err != nil {
+			return m, err
+		}
+		if len(logs) == 0 {
+			break
+		}
+		if expr.uses("tags") {
+			if err := d.attachTags(logs); err != nil {
+				return m, err
+			}
+		}
+		if expr.uses("meta") {
+			if err := d.attachRaw(logs); err != nil {
+				return m, err
+			}
+		}
+		for _, l := range logs {
+			ok, err := expr.match(l)
+			if err != nil {
+				if m.Failed == 0 {
+					m.Error = err.Error()
+				}
+				m.Failed++
+				continue
+			}
+			if ok {
+				m.Count++
+				if len(m.Samples) < alertSampleSize {
+					l.Raw, l.RawFormat = nil, ""
+					m.Samples = append(m.Samples, l)
+				}
+			}
+		}
+		scanned += len(logs)
+		before = logs[len(logs)-1].ID
+	}
+	return m, nil
+}
+
+// expressionTest is the body of POST /api/alerts/expression
+type expressionTest struct {
+	Expression    string    `json:"expression"`
+	Filter        LogFilter `json:"filter"`
+	WindowSeconds int       `json:"windowSeconds"`
+}
+
+// POST /api/alerts/expression - count the logs of the last windowSeconds
+// (default one hour) that match filter and satisfy expression
+func alertExpressionHandlerDB(w http.ResponseWriter, r *http.Request, db *Database) {
+	enableCORS(w)
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != http.MethodPost {
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	var req expressionTest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "Invalid JSON")
+		return
+	}
+	if strings.TrimSpace(req.Expression) == "" {
+		writeJSONError(w, http.StatusBadRequest, "expression is required")
+		return
+	}
+	expr, err := parseLogExpr(req.Expression)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, "Invalid expression: "+err.Error())
+		return
+	}
+	if req.WindowSeconds <= 0 {
+		req.WindowSeconds = 3600
+	}
+	filter := AlertCondition{Filter: req.Filter, WindowSeconds: req.WindowSeconds}.window(db.now())
+	m, err := db.countExpression(filter, expr)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "Failed to evaluate expression")
+		return
+	}
+	json.NewEncoder(w).Encode(m)
+}
@@ -0,0 +1,56 @@
+package logserver
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestAlertClearThresholdValidation(t *testing.T) {
+	clear := func(n int) *int { return &n }
+	condition := func(comparator string, threshold int, clearThreshold *int) AlertRule {
+		return AlertRule{Name: "rule", Conditions: []AlertCondition{{Comparator: comparator, Threshold: threshold, WindowSeconds: 60, ClearThreshold: clearThreshold}}}
+	}
+	for _, tc := range []struct {
+		name string
+		rule AlertRule
+		want string
+	}{
+		{"clear below a >= threshold", AlertRule{Name: "rule", Threshold: 10, WindowSeconds: 60, ClearThreshold: clear(5)}, ""},
+		{"clear above a >= threshold", AlertRule{Name: "rule", Threshold: 10, WindowSeconds: 60, ClearThreshold: clear(11)}, "must not be above"},
+		{"clear at an expression's default threshold", AlertRule{Name: "rule", Expression: `level == "ERROR"`, WindowSeconds: 60, ClearThreshold: clear(1)}, ""},
+		{"clear below a > condition", condition(">", 10, clear(8)), ""},
+		{"clear above a > condition", condition(">", 10, clear(12)), "must not be above"},
+		{"clear above a < condition", condition("<", 5, clear(8)), ""},
+		{"clear below a < condition", condition("<", 5, clear(2)), "must not be below threshold with comparator <"},
+		{"clear below a <= condition", condition("<=", 5, clear(4)), "must not be below threshold with comparator <="},
+		{"clear on an == condition", condition("==", 5, clear(5)), "does not apply to comparator =="},
+		{"clear on a != condition", condition("!=", 0, clear(1)), "does not apply to comparator !="},
+		{"rule clear with conditions", AlertRule{Name: "rule", Conditions: condition(">=", 1, nil).Conditions, ClearThreshold: clear(0)}, "set it on each condition"},
+	} {
+		err := tc.rule.validate()
+		switch {
+		case tc.want == "" && err != nil:
+			t.Errorf("%s: %v", tc.name, err)
+		case tc.want != "" && (err == nil || !strings.Contains(err.Error(), tc.want)):
+			t.Errorf("%s: err = %v, want %q", tc.name, err, tc.want)
+		}
+	}
+}
+
+func TestAlertClearThresholdHysteresis(t *testing.T) {
+	clear := 8
+	// Fires under 5 and, once firing, stays firing until 8
+	c := AlertCondition{Comparator: "<", Threshold: 5, ClearThreshold: &clear}
+	for _, tc := range []struct {
+		count  int
+		firing bool
+		want   bool
+	}{
+		{4, false, true},
+		{6, false, false},
+		{6, true, true},
+		{8, true, false},
+	} {
+		expect(t, "compare", c.compare(tc.count, tc.firing), tc.want)
+	}
+}
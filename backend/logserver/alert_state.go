@@ -0,0 +1,140 @@
+package logserver
+
+import (
+	"database/sql"
+	"time"
+)
+
+// Alert rule evaluation states
+const (
+	ruleStateInactive = "inactive"
+	ruleStatePending  = "pending"
+	ruleStateFiring   = "firing"
+)
+
+// alertRuleState is the persisted evaluation state of one rule, used for
+// for/keep-firing durations, re-notification and hysteresis
+type alertRuleState struct {
+	RuleID         int64
+	State          string
+	PendingSince   sql.NullTime
+	LastTrueAt     sql.NullTime
+	LastNotifiedAt sql.NullTime
+	InstanceID     sql.NullInt64
+}
+
+func createAlertStateTables(db *sql.DB) error {
+	_, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS alert_state (
+			rule_id INTEGER PRIMARY KEY,
+			state TEXT NOT NULL,
+			pending_since DATETIME,
+			last_true_at DATETIME,
+			last_notified_at DATETIME,
+			instance_id INTEGER
+		)
+	`)
+	if err != nil {
+		return err
+	}
+	for column, definition := range map[string]string{
+		"for_seconds":         `INTEGER NOT NULL DEFAULT 0`,
+		"keep_firing_seconds": `INTEGER NOT NULL DEFAULT 0`,
+		"renotify_seconds":    `INTEGER NOT NULL DEFAULT 0`,
+		"clear_threshold":     `INTEGER`,
+	} {
+		if err := addColumnIfMissing(db, "alert_rules", column, definition); err != nil {
+			return err
+		}
+	}
+	return addColumnIfMissing(db, "alert_history", "resolved_at", `DATETIME`)
+}
+
+func (d *Database) getAlertRuleState(ruleID int64) (alertRuleState, error) {
+	state := alertRuleState{RuleID: ruleID, State: ruleStateInactive}
+	err := d.db.QueryRow(`
+		SELECT state, pending_since, last_true_at, last_notified_at, instance_id
+		FROM alert_state WHERE rule_id = ?
+	`, ruleID).Scan(&state.State, &state.PendingSince, &state.LastTrueAt, &state.LastNotifiedAt, &state.InstanceID)
+	if err == sql.ErrNoRows {
+		return state, nil
+	}
+	return state, err
+}
+
+func (d *Database) saveAlertRuleState(state alertRuleState) error {
+	_, err := d.db.Exec(`
+		INSERT INTO alert_state (rule_id, state, pending_since, last_true_at, last_notified_at, instance_id)
+		VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT(rule_id) DO UPDATE SET
+			state = excluded.state,
+			pending_since = excluded.pending_since,
+			last_true_at = excluded.last_true_at,
+			last_notified_at = excluded.last_notified_at,
+			instance_id = excluded.instance_id
+	`, state.RuleID, state.State, state.PendingSince, state.LastTrueAt, state.LastNotifiedAt, state.InstanceID)
+	return err
+}
+
+func (d *Database) resolveAlertInstance(id int64, at time.Time) error {
+	if _, err := d.db.Exec(`UPDATE alert_history SET resolved_at = ? WHERE id = ?`, at.UTC(), id); err != nil {
+		return err
+	}
+	d.recordAlertChange(id, changeResolved)
+	return nil
+}
+
+// alertTransition is what EvaluateAlerts should do after a state update
+type alertTransition int
+
+const (
+	transitionNone alertTransition = iota
+	transitionFire
+	transitionRenotify
+	transitionResolve
+)
+
+// alertTimingSlack absorbs ticker jitter so a 60s re-notify interval
+// evaluated every minute isn't missed by a few microseconds
+const alertTimingSlack = time.Second
+
+// advance moves the rule's state machine given whether its conditions
+// currently hold, and reports what action is due
+func (s *alertRuleState) advance(rule AlertRule, conditionTrue bool, now time.Time) alertTransition {
+	forDuration := time.Duration(rule.ForSeconds)*time.Second - alertTimingSlack
+	keepFiring := time.Duration(rule.KeepFiringSeconds)*time.Second - alertTimingSlack
+	renotify := time.Duration(rule.RenotifySeconds) * time.Second
+	if conditionTrue {
+		s.LastTrueAt = sql.NullTime{Time: now, Valid: true}
+	}
+
+	switch s.State {
+	case ruleStateFiring:
+		if !conditionTrue && now.Sub(s.LastTrueAt.Time) >= keepFiring {
+			s.State = ruleStateInactive
+			s.PendingSince = sql.NullTime{}
+			return transitionResolve
+		}
+		if renotify > 0 && s.LastNotifiedAt.Valid && now.Sub(s.LastNotifiedAt.Time) >= renotify-alertTimingSlack {
+			return transitionRenotify
+		}
+		return transitionNone
+	case ruleStatePending:
+		if !conditionTrue {
+			s.State = ruleStateInactive
+			s.PendingSince = sql.NullTime{}
+			return transitionNone
+		}
+	default:
+		if !conditionTrue {
+			return transitionNone
+		}
+		s.State = ruleStatePending
+		s.PendingSince = sql.NullTime{Time: now, Valid: true}
+	}
+	if now.Sub(s.PendingSince.Time) < forDuration {
+		return transitionNone
+	}
+	s.State = ruleStateFiring
+	return transitionFire
+}
@@ -0,0 +1,760 @@
+package logserver
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const alertEvaluationInterval = time.Minute
+
+// alertSampleSize is how many matching logs are stored with each firing
+const alertSampleSize = 5
+
+// Alert instance statuses
+const (
+	alertStatusFiring       = "firing"
+	alertStatusAcknowledged = "acknowledged"
+	alertStatusSnoozed      = "snoozed"
+)
+
+// AlertRule fires when at least Threshold logs match Filter within the last WindowSeconds
+type AlertRule struct {
+	ID            int64     `json:"id"`
+	Name          string    `json:"name"`
+	Filter        LogFilter `json:"filter"`
+	Threshold     int       `json:"threshold"`
+	WindowSeconds int       `json:"windowSeconds"`
+	WebhookURL    string    `json:"webhookURL,omitempty"`
+	// MessageTemplate is a Go text/template rendered against NotificationData
+	MessageTemplate string `json:"messageTemplate,omitempty"`
+	// Expression, if set, is a log expression that logs matching Filter must
+	// also satisfy to be counted. Threshold then defaults to 1.
+	Expression string `json:"expression,omitempty"`
+	// Conditions replace Filter/Threshold/WindowSeconds when set and are
+	// combined with Operator ("and" by default, or "or")
+	Conditions []AlertCondition `json:"conditions,omitempty"`
+	Operator   string           `json:"operator,omitempty"`
+	// ForSeconds is how long conditions must hold before firing,
+	// KeepFiringSeconds how long a firing alert survives once they stop
+	// holding, and RenotifySeconds how often a still-firing alert notifies
+	// again (0 = only once). ClearThreshold, if set, is the threshold a
+	// firing single-condition rule must drop below to clear.
+	ForSeconds        int       `json:"forSeconds,omitempty"`
+	KeepFiringSeconds int       `json:"keepFiringSeconds,omitempty"`
+	RenotifySeconds   int       `json:"renotifySeconds,omitempty"`
+	ClearThreshold    *int      `json:"clearThreshold,omitempty"`
+	Enabled           bool      `json:"enabled"`
+	CreatedAt         time.Time `json:"createdAt"`
+}
+
+// AlertInstance is one persisted firing of an alert rule
+type AlertInstance struct {
+	ID             int64      `json:"id"`
+	RuleID         int64      `json:"ruleId"`
+	RuleName       string     `json:"ruleName"`
+	FiredAt        time.Time  `json:"firedAt"`
+	Count          int        `json:"count"`
+	Threshold      int        `json:"threshold"`
+	WindowSeconds  int        `json:"windowSeconds"`
+	Samples        []LogEntry `json:"samples"`
+	Status         string     `json:"status"`
+	AcknowledgedBy string     `json:"acknowledgedBy,omitempty"`
+	AcknowledgedAt *time.Time `json:"acknowledgedAt,omitempty"`
+	SnoozedBy      string     `json:"snoozedBy,omitempty"`
+	SnoozedUntil   *time.Time `json:"snoozedUntil,omitempty"`
+	Muted          bool       `json:"muted"`
+	MuteWindowID   *int64     `json:"muteWindowId,omitempty"`
+	// Conditions holds per-condition counts for composite rules
+	Conditions []ConditionResult `json:"conditions,omitempty"`
+	ResolvedAt *time.Time        `json:"resolvedAt,omitempty"`
+	Details    string            `json:"details,omitempty"`
+}
+
+// AlertHistoryFilter narrows /api/alerts/history results
+type AlertHistoryFilter struct {
+	RuleID int64
+	Status string
+	From   time.Time
+	To     time.Time
+	Limit  int
+}
+
+func createAlertTables(db *sql.DB) error {
+	_, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS alert_rules (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			name TEXT NOT NULL,
+			filter TEXT NOT NULL,
+			threshold INTEGER NOT NULL,
+			window_seconds INTEGER NOT NULL,
+			webhook_url TEXT NOT NULL DEFAULT '',
+			message_template TEXT NOT NULL DEFAULT '',
+			enabled INTEGER NOT NULL DEFAULT 1,
+			created_at DATETIME NOT NULL
+		)
+	`)
+	if err != nil {
+		return err
+	}
+	if err := addColumnIfMissing(db, "alert_rules", "message_template", `TEXT NOT NULL DEFAULT ''`); err != nil {
+		return err
+	}
+	if err := addColumnIfMissing(db, "alert_rules", "conditions", `TEXT NOT NULL DEFAULT '[]'`); err != nil {
+		return err
+	}
+	if err := addColumnIfMissing(db, "alert_rules", "operator", `TEXT NOT NULL DEFAULT ''`); err != nil {
+		return err
+	}
+	if err := addColumnIfMissing(db, "alert_rules", "expression", `TEXT NOT NULL DEFAULT ''`); err != nil {
+		return err
+	}
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS alert_history (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			rule_id INTEGER NOT NULL,
+			rule_name TEXT NOT NULL,
+			fired_at DATETIME NOT NULL,
+			count INTEGER NOT NULL,
+			threshold INTEGER NOT NULL,
+			window_seconds INTEGER NOT NULL,
+			samples TEXT NOT NULL,
+			status TEXT NOT NULL,
+			acknowledged_by TEXT NOT NULL DEFAULT '',
+			acknowledged_at DATETIME,
+			snoozed_by TEXT NOT NULL DEFAULT '',
+			snoozed_until DATETIME
+		)
+	`)
+	if err != nil {
+		return err
+	}
+	if err := addColumnIfMissing(db, "alert_history", "conditions", `TEXT NOT NULL DEFAULT '[]'`); err != nil {
+		return err
+	}
+	if err := addColumnIfMissing(db, "alert_history", "details", `TEXT NOT NULL DEFAULT ''`); err != nil {
+		return err
+	}
+	_, err = db.Exec(`CREATE INDEX IF NOT EXISTS idx_alert_history_rule_fired ON alert_history(rule_id, fired_at)`)
+	return err
+}
+
+func (d *Database) CreateAlertRule(rule AlertRule) (AlertRule, error) {
+	return d.createAlertRule(d.db, rule)
+}
+
+func (d *Database) createAlertRule(ex execer, rule AlertRule) (AlertRule, error) {
+	filter, err := json.Marshal(rule.Filter)
+	if err != nil {
+		return rule, err
+	}
+	conditions, err := json.Marshal(rule.Conditions)
+	if err != nil {
+		return rule, err
+	}
+	rule.CreatedAt = d.now().UTC()
+	res, err := ex.Exec(`
+		INSERT INTO alert_rules (name, filter, threshold, window_seconds, webhook_url, message_template, conditions, operator,
+			expression, for_seconds, keep_firing_seconds, renotify_seconds, clear_threshold, enabled, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, rule.Name, string(filter), rule.Threshold, rule.WindowSeconds, rule.WebhookURL, rule.MessageTemplate, string(conditions), rule.Operator,
+		rule.Expression, rule.ForSeconds, rule.KeepFiringSeconds, rule.RenotifySeconds, rule.ClearThreshold, rule.Enabled, rule.CreatedAt)
+	if err != nil {
+		return rule, err
+	}
+	rule.ID, err = res.LastInsertId()
+	return rule, err
+}
+
+// UpdateAlertRule replaces every setting of an existing rule, keeping its
+// id so that alert state and history stay attached
+func (d *Database) UpdateAlertRule(rule AlertRule) error {
+	return updateAlertRule(d.db, rule)
+}
+
+func updateAlertRule(ex execer, rule AlertRule) error {
+	filter, err := json.Marshal(rule.Filter)
+	if err != nil {
+		return err
+	}
+	conditions, err := json.Marshal(rule.Conditions)
+	if err != nil {
+		return err
+	}
+	res, err := ex.Exec(`
+		UPDATE alert_rules SET name = ?, filter = ?, threshold = ?, window_seconds = ?, webhook_url = ?, message_template = ?,
+			conditions = ?, operator = ?, expression = ?, for_seconds = ?, keep_firing_seconds = ?, renotify_seconds = ?, clear_threshold = ?,
+			enabled = ?
+		WHERE id = ?
+	`, rule.Name, string(filter), rule.Threshold, rule.WindowSeconds, rule.WebhookURL, rule.MessageTemplate, string(conditions), rule.Operator,
+		rule.Expression, rule.ForSeconds, rule.KeepFiringSeconds, rule.RenotifySeconds, rule.ClearThreshold, rule.Enabled, rule.ID)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+func (d *Database) DeleteAlertRule(id int64) error {
+	return deleteAlertRule(d.db, id)
+}
+
+func deleteAlertRule(ex execer, id int64) error {
+	_, err := ex.Exec(`DELETE FROM alert_rules WHERE id = ?`, id)
+	return err
+}
+
+const alertRuleColumns = `id, name, filter, threshold, window_seconds, webhook_url, message_template, conditions, operator,
+	expression, for_seconds, keep_firing_seconds, renotify_seconds, clear_threshold, enabled, created_at`
+
+func scanAlertRule(scan func(dest ...interface{}) error) (AlertRule, error) {
+	var rule AlertRule
+	var filter, conditions string
+	var clearThreshold sql.NullInt64
+	err := scan(&rule.ID, &rule.Name, &filter, &rule.Threshold, &rule.WindowSeconds, &rule.WebhookURL, &rule.MessageTemplate,
+		&conditions, &rule.Operator, &rule.Expression, &rule.ForSeconds, &rule.KeepFiringSeconds, &rule.RenotifySeconds, &clearThreshold,
+		&rule.Enabled, &rule.CreatedAt)
+	if err != nil {
+		return rule, err
+	}
+	if clearThreshold.Valid {
+		t := int(clearThreshold.Int64)
+		rule.ClearThreshold = &t
+	}
+	if err := json.Unmarshal([]byte(filter), &rule.Filter); err != nil {
+		return rule, err
+	}
+	err = json.Unmarshal([]byte(conditions), &rule.Conditions)
+	return rule, err
+}
+
+func (d *Database) GetAlertRule(id int64) (AlertRule, error) {
+	return scanAlertRule(d.db.QueryRow(`SELECT `+alertRuleColumns+` FROM alert_rules WHERE id = ?`, id).Scan)
+}
+
+func (d *Database) GetAlertRules() ([]AlertRule, error) {
+	rows, err := d.db.Query(`SELECT ` + alertRuleColumns + ` FROM alert_rules ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	rules := []AlertRule{}
+	for rows.Next() {
+		rule, err := scanAlertRule(rows.Scan)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, rule)
+	}
+	return rules, rows.Err()
+}
+
+func (d *Database) InsertAlertInstance(instance AlertInstance) (AlertInstance, error) {
+	samples, err := json.Marshal(instance.Samples)
+	if err != nil {
+		return instance, err
+	}
+	conditions, err := json.Marshal(instance.Conditions)
+	if err != nil {
+		return instance, err
+	}
+	res, err := d.db.Exec(`
+		INSERT INTO alert_history (rule_id, rule_name, fired_at, count, threshold, window_seconds, samples, status, muted, mute_window_id, conditions, details)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, instance.RuleID, instance.RuleName, instance.FiredAt.UTC(), instance.Count, instance.Threshold, instance.WindowSeconds, string(samples), instance.Status,
+		instance.Muted, instance.MuteWindowID, string(conditions), instance.Details)
+	if err != nil {
+		return instance, err
+	}
+	if instance.ID, err = res.LastInsertId(); err == nil {
+		d.recordChange(changeAlert, strconv.FormatInt(instance.ID, 10), changeFired, instance)
+	}
+	return instance, err
+}
+
+const alertInstanceColumns = `id, rule_id, rule_name, fired_at, count, threshold, window_seconds, samples, status,
+	acknowledged_by, acknowledged_at, snoozed_by, snoozed_until, muted, mute_window_id, conditions, resolved_at, details`
+
+func scanAlertInstance(scan func(dest ...interface{}) error) (AlertInstance, error) {
+	var instance AlertInstance
+	var samples, conditions string
+	var ackAt, snoozedUntil, resolvedAt sql.NullTime
+	var muteWindowID sql.NullInt64
+	err := scan(&instance.ID, &instance.RuleID, &instance.RuleName, &instance.FiredAt, &instance.Count, &instance.Threshold,
+		&instance.WindowSeconds, &samples, &instance.Status, &instance.AcknowledgedBy, &ackAt, &instance.SnoozedBy, &snoozedUntil,
+		&instance.Muted, &muteWindowID, &conditions, &resolvedAt, &instance.Details)
+	if err != nil {
+		return instance, err
+	}
+	if err := json.Unmarshal([]byte(samples), &instance.Samples); err != nil {
+		return instance, err
+	}
+	if err := json.Unmarshal([]byte(conditions), &instance.Conditions); err != nil {
+		return instance, err
+	}
+	if ackAt.Valid {
+		instance.AcknowledgedAt = &ackAt.Time
+	}
+	if snoozedUntil.Valid {
+		instance.SnoozedUntil = &snoozedUntil.Time
+	}
+	if muteWindowID.Valid {
+		instance.MuteWindowID = &muteWindowID.Int64
+	}
+	if resolvedAt.Valid {
+		instance.ResolvedAt = &resolvedAt.Time
+	}
+	return instance, nil
+}
+
+func (d *Database) GetAlertInstance(id int64) (AlertInstance, error) {
+	row := d.db.QueryRow(`SELECT `+alertInstanceColumns+` FROM alert_history WHERE id = ?`, id)
+	return scanAlertInstance(row.Scan)
+}
+
+func (d *Database) GetAlertHistory(filter AlertHistoryFilter) ([]AlertInstance, error) {
+	query := `SELECT ` + alertInstanceColumns + ` FROM alert_history WHERE 1=1`
+	args := []interface{}{}
+	if filter.RuleID != 0 {
+		query += ` AND rule_id = ?`
+		args = append(args, filter.RuleID)
+	}
+	if filter.Status != "" {
+		query += ` AND status = ?`
+		args = append(args, filter.Status)
+	}
+	if !filter.From.IsZero() {
+		query += ` AND fired_at >= ?`
+		args = append(args, filter.From.UTC())
+	}
+	if !filter.To.IsZero() {
+		query += ` AND fired_at < ?`
+		args = append(args, filter.To.UTC())
+	}
+	query += ` ORDER BY fired_at DESC LIMIT ?`
+	args = append(args, filter.Limit)
+
+	rows, err := d.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	history := []AlertInstance{}
+	for rows.Next() {
+		instance, err := scanAlertInstance(rows.Scan)
+		if err != nil {
+			return nil, err
+		}
+		history = append(history, instance)
+	}
+	return history, rows.Err()
+}
+
+func (d *Database) AcknowledgeAlert(id int64, actor string) error {
+	res, err := d.db.Exec(`
+		UPDATE alert_history SET status = ?, acknowledged_by = ?, acknowledged_at = ?
+		WHERE id = ?
+	`, alertStatusAcknowledged, actor, d.now().UTC(), id)
+	if err := requireOneRow(res, err); err != nil {
+		return err
+	}
+	d.recordAlertChange(id, changeAcknowledged)
+	return nil
+}
+
+func (d *Database) SnoozeAlert(id int64, actor string, until time.Time) error {
+	res, err := d.db.Exec(`
+		UPDATE alert_history SET status = ?, snoozed_by = ?, snoozed_until = ?
+		WHERE id = ?
+	`, alertStatusSnoozed, actor, until.UTC(), id)
+	if err := requireOneRow(res, err); err != nil {
+		return err
+	}
+	d.recordAlertChange(id, changeSnoozed)
+	return nil
+}
+
+// requireOneRow turns an UPDATE/DELETE that matched nothing into sql.ErrNoRows
+func requireOneRow(res sql.Result, err error) error {
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// isRuleSnoozed reports whether any instance of the rule is snoozed past now
+func (d *Database) isRuleSnoozed(ruleID int64, now time.Time) (bool, error) {
+	var n int
+	err := d.db.QueryRow(`
+		SELECT COUNT(*) FROM alert_history
+		WHERE rule_id = ? AND status = ? AND snoozed_until > ?
+	`, ruleID, alertStatusSnoozed, now.UTC()).Scan(&n)
+	return n > 0, err
+}
+
+// EvaluateAlerts advances every enabled rule's state machine, recording a
+// firing when a rule starts firing and resolving it when the rule clears.
+// A rule that fails to evaluate is logged and skipped, so that it holds up
+// neither the other rules nor the builtin alerts.
+func (d *Database) EvaluateAlerts(now time.Time) error {
+	rules, err := d.GetAlertRules()
+	for _, rule := range rules {
+		if !rule.Enabled {
+			continue
+		}
+		if err := d.evaluateAlertRule(rule, now); err != nil {
+			log.Printf("alert rule %d (%s): evaluation failed: %v", rule.ID, rule.Name, err)
+		}
+	}
+	return errors.Join(err, d.evaluateBuiltinAlerts(now))
+}
+
+// evaluateAlertRule advances one rule's state machine and saves its state
+func (d *Database) evaluateAlertRule(rule AlertRule, now time.Time) error {
+	state, err := d.getAlertRuleState(rule.ID)
+	if err != nil {
+		return err
+	}
+	fired, results, samplesFrom, samples, err := d.evaluateConditions(rule, now, state.State == ruleStateFiring)
+	if err != nil {
+		return err
+	}
+	if err := d.stepAlertRule(rule, &state, fired, results, samplesFrom, samples, "", now); err != nil {
+		return err
+	}
+	return d.saveAlertRuleState(state)
+}
+
+// stepAlertRule advances the rule's state and fires, re-notifies or resolves as due.
+// details is a free-text explanation stored with a new firing.
+func (d *Database) stepAlertRule(rule AlertRule, state *alertRuleState, fired bool, results []ConditionResult, samplesFrom *LogFilter, samples []LogEntry,
+	details string, now time.Time) error {
+	switch state.advance(rule, fired, now) {
+	case transitionFire:
+		snoozed, err := d.isRuleSnoozed(rule.ID, now)
+		if err != nil {
+			return err
+		}
+		if snoozed {
+			// Stay pending so the rule fires as soon as the snooze ends
+			state.State = ruleStatePending
+			break
+		}
+		instance, err := d.fireAlert(rule, results, samplesFrom, samples, details, now)
+		if err != nil {
+			return err
+		}
+		state.InstanceID = sql.NullInt64{Int64: instance.ID, Valid: true}
+		state.LastNotifiedAt = sql.NullTime{Time: now, Valid: true}
+	case transitionRenotify:
+		if !state.InstanceID.Valid {
+			break
+		}
+		instance, err := d.GetAlertInstance(state.InstanceID.Int64)
+		if err != nil {
+			return err
+		}
+		snoozed, err := d.isRuleSnoozed(rule.ID, now)
+		if err != nil {
+			return err
+		}
+		mute, err := d.activeMuteWindow(rule.ID, instance.Samples, now)
+		if err != nil {
+			return err
+		}
+		if !snoozed && mute == nil && instance.Status == alertStatusFiring {
+			if rule.WebhookURL != "" {
+				go notifyWebhook(rule, instance)
+			}
+			go notifyChannels(rule, instance)
+		}
+		state.LastNotifiedAt = sql.NullTime{Time: now, Valid: true}
+	case transitionResolve:
+		if state.InstanceID.Valid {
+			if err := d.resolveAlertInstance(state.InstanceID.Int64, now); err != nil {
+				return err
+			}
+		}
+		state.InstanceID = sql.NullInt64{}
+	}
+	return nil
+}
+
+// fireAlert records a new alert instance and sends its notification unless
+// muted. Its samples are read from samplesFrom unless they are given.
+func (d *Database) fireAlert(rule AlertRule, results []ConditionResult, samplesFrom *LogFilter, samples []LogEntry, details string, now time.Time) (AlertInstance, error) {
+	if samples == nil {
+		samples = []LogEntry{}
+	}
+	if samplesFrom != nil {
+		var err error
+		if samples, err = d.FilterLogs(*samplesFrom, alertSampleSize); err != nil {
+			return AlertInstance{}, err
+		}
+	}
+	instance := AlertInstance{
+		RuleID:        rule.ID,
+		RuleName:      rule.Name,
+		FiredAt:       now,
+		Count:         results[0].Count,
+		Threshold:     rule.threshold(),
+		WindowSeconds: rule.WindowSeconds,
+		Samples:       samples,
+		Status:        alertStatusFiring,
+		Details:       details,
+	}
+	if len(rule.Conditions) > 0 {
+		instance.Conditions = results
+	}
+	// Muted firings are still recorded, only the notification is skipped
+	mute, err := d.activeMuteWindow(rule.ID, samples, now)
+	if err != nil {
+		return instance, err
+	}
+	if mute != nil {
+		instance.Muted = true
+		instance.MuteWindowID = &mute.ID
+	}
+	instance, err = d.InsertAlertInstance(instance)
+	if err != nil {
+		return instance, err
+	}
+	if !instance.Muted {
+		if rule.WebhookURL != "" {
+			go notifyWebhook(rule, instance)
+		}
+		go notifyChannels(rule, instance)
+	}
+	return instance, nil
+}
+
+var notifyClient = &http.Client{Timeout: 10 * time.Second}
+
+// alertNotification is the webhook payload: the alert instance plus the rendered message
+type alertNotification struct {
+	AlertInstance
+	Message string `json:"message"`
+}
+
+func notifyWebhook(rule AlertRule, instance AlertInstance) {
+	message, err := renderNotification(rule, instance)
+	if err != nil {
+		log.Printf("alert %d: failed to render message template: %v", instance.ID, err)
+	}
+	body, err := json.Marshal(alertNotification{AlertInstance: instance, Message: message})
+	if err != nil {
+		log.Printf("alert %d: failed to encode notification: %v", instance.ID, err)
+		return
+	}
+	resp, err := notifyClient.Post(rule.WebhookURL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		log.Printf("alert %d: notification failed: %v", instance.ID, err)
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		log.Printf("alert %d: notification returned %s", instance.ID, resp.Status)
+	}
+}
+
+// startAlertEvaluator evaluates the alert rules every alertEvaluationInterval
+// until ctx is done
+func startAlertEvaluator(ctx context.Context, db *Database) {
+	ticker := time.NewTicker(alertEvaluationInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := db.EvaluateAlerts(db.now()); err != nil {
+				log.Printf("alert evaluation failed: %v", err)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// GET/POST/DELETE /api/alerts/rules - list alert rules, or create or delete
+// one (admin only). Webhook URLs are only trusted from admins.
+func alertRulesHandlerDB(w http.ResponseWriter, r *http.Request, db *Database) {
+	enableCORS(w)
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method == http.MethodOptions {
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		rules, err := db.GetAlertRules()
+		if err != nil {
+			writeJSONError(w, http.StatusInternalServerError, "Failed to fetch alert rules")
+			return
+		}
+		json.NewEncoder(w).Encode(rules)
+	case http.MethodPost:
+		if !db.requireAdmin(w, r) {
+			return
+		}
+		rule := AlertRule{Enabled: true}
+		if err := json.NewDecoder(r.Body).Decode(&rule); err != nil {
+			writeJSONError(w, http.StatusBadRequest, "Invalid JSON")
+			return
+		}
+		if err := rule.validate(); err != nil {
+			writeJSONError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		rule, err := db.CreateAlertRule(rule)
+		if err != nil {
+			writeJSONError(w, http.StatusInternalServerError, "Failed to create alert rule")
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(rule)
+	case http.MethodDelete:
+		if !db.requireAdmin(w, r) {
+			return
+		}
+		id, err := strconv.ParseInt(r.URL.Query().Get("id"), 10, 64)
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, "Invalid id")
+			return
+		}
+		if err := db.DeleteAlertRule(id); err != nil {
+			writeJSONError(w, http.StatusInternalServerError, "Failed to delete alert rule")
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+	}
+}
+
+// GET /api/alerts/history?rule_id=&status=&from=&to=&since=&range=&limit= - list alert firings
+func alertHistoryHandlerDB(w http.ResponseWriter, r *http.Request, db *Database) {
+	enableCORS(w)
+	w.Header().Set("Content-Type", "application/json")
+	q := r.URL.Query()
+	filter := AlertHistoryFilter{Status: q.Get("status"), Limit: 100}
+	if ruleID := q.Get("rule_id"); ruleID != "" {
+		id, err := strconv.ParseInt(ruleID, 10, 64)
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, "Invalid rule_id")
+			return
+		}
+		filter.RuleID = id
+	}
+	from, to, ok, err := relativeRange(q, db.now())
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	filter.From, filter.To = from, to
+	for name, dest := range map[string]*time.Time{"from": &filter.From, "to": &filter.To} {
+		if ok {
+			break
+		}
+		if v := q.Get(name); v != "" {
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				writeJSONError(w, http.StatusBadRequest, "Invalid '"+name+"' timestamp")
+				return
+			}
+			*dest = t
+		}
+	}
+	if l, err := strconv.Atoi(q.Get("limit")); err == nil && l > 0 && l <= 1000 {
+		filter.Limit = l
+	}
+	history, err := db.GetAlertHistory(filter)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "Failed to fetch alert history")
+		return
+	}
+	json.NewEncoder(w).Encode(history)
+}
+
+// alertActionRequest is the body of the snooze action
+type alertActionRequest struct {
+	Duration string `json:"duration"`
+}
+
+// POST /api/alerts/history/{id}/ack and /api/alerts/history/{id}/snooze (admin
+// only). The actor recorded is the caller's credential.
+func alertActionHandlerDB(w http.ResponseWriter, r *http.Request, db *Database) {
+	enableCORS(w)
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method == http.MethodOptions {
+		return
+	}
+	if r.Method != http.MethodPost {
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	if !db.requireAdmin(w, r) {
+		return
+	}
+	actor := db.requestActor(r)
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/api/alerts/history/"), "/")
+	if len(parts) != 2 {
+		writeJSONError(w, http.StatusNotFound, "Not found")
+		return
+	}
+	id, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, "Invalid alert id")
+		return
+	}
+	var req alertActionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		writeJSONError(w, http.StatusBadRequest, "Invalid JSON")
+		return
+	}
+
+	switch parts[1] {
+	case "ack":
+		err = db.AcknowledgeAlert(id, actor)
+	case "snooze":
+		duration, perr := time.ParseDuration(req.Duration)
+		if perr != nil || duration <= 0 {
+			writeJSONError(w, http.StatusBadRequest, "duration must be a positive Go duration such as 30m")
+			return
+		}
+		err = db.SnoozeAlert(id, actor, db.now().Add(duration))
+	default:
+		writeJSONError(w, http.StatusNotFound, "Not found")
+		return
+	}
+	if errors.Is(err, sql.ErrNoRows) {
+		writeJSONError(w, http.StatusNotFound, "Alert not found")
+		return
+	}
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "Failed to update alert")
+		return
+	}
+	instance, err := db.GetAlertInstance(id)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "Failed to fetch alert")
+		return
+	}
+	json.NewEncoder(w).Encode(instance)
+}
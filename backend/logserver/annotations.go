@@ -0,0 +1,217 @@
+package logserver
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Annotation is an analyst's bookmark, comment and/or tags on a log entry
+type Annotation struct {
+	ID         int64     `json:"id"`
+	LogID      int64     `json:"logId"`
+	Author     string    `json:"author"`
+	Comment    string    `json:"comment,omitempty"`
+	Tags       []string  `json:"tags"`
+	Bookmarked bool      `json:"bookmarked"`
+	CreatedAt  time.Time `json:"createdAt"`
+}
+
+// AnnotationFilter selects annotations; zero values match everything
+type AnnotationFilter struct {
+	LogID      int64
+	Author     string
+	Tag        string
+	Bookmarked bool
+	Limit      int
+}
+
+func createAnnotationTables(db *sql.DB) error {
+	_, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS annotations (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			log_id INTEGER NOT NULL,
+			author TEXT NOT NULL,
+			comment TEXT NOT NULL DEFAULT '',
+			tags TEXT NOT NULL,
+			bookmarked BOOLEAN NOT NULL DEFAULT 0,
+			created_at DATETIME NOT NULL
+		)
+	`)
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec(`CREATE INDEX IF NOT EXISTS idx_annotations_log_id ON annotations(log_id)`)
+	return err
+}
+
+func (d *Database) CreateAnnotation(a Annotation) (Annotation, error) {
+	if a.Tags == nil {
+		a.Tags = []string{}
+	}
+	tags, _ := json.Marshal(a.Tags)
+	a.CreatedAt = d.now().UTC()
+	res, err := d.db.Exec(`
+		INSERT INTO annotations (log_id, author, comment, tags, bookmarked, created_at) VALUES (?, ?, ?, ?, ?, ?)
+	`, a.LogID, a.Author, a.Comment, string(tags), a.Bookmarked, a.CreatedAt)
+	if err != nil {
+		return a, err
+	}
+	a.ID, err = res.LastInsertId()
+	return a, err
+}
+
+func (d *Database) DeleteAnnotation(id int64) error {
+	res, err := d.db.Exec(`DELETE FROM annotations WHERE id = ?`, id)
+	return requireOneRow(res, err)
+}
+
+func (d *Database) GetAnnotations(filter AnnotationFilter) ([]Annotation, error) {
+	query := `SELECT id, log_id, author, comment, tags, bookmarked, created_at FROM annotations WHERE 1=1`
+	args := []interface{}{}
+	if filter.LogID != 0 {
+		query += ` AND log_id = ?`
+		args = append(args, filter.LogID)
+	}
+	if filter.Author != "" {
+		query += ` AND author = ?`
+		args = append(args, filter.Author)
+	}
+	if filter.Tag != "" {
+		query += ` AND EXISTS (SELECT 1 FROM json_each(annotations.tags) WHERE value = ?)`
+		args = append(args, filter.Tag)
+	}
+	if filter.Bookmarked {
+		query += ` AND bookmarked = 1`
+	}
+	query += ` ORDER BY created_at DESC LIMIT ?`
+	args = append(args, filter.Limit)
+	return d.queryAnnotations(query, args...)
+}
+
+func (d *Database) queryAnnotations(query string, args ...interface{}) ([]Annotation, error) {
+	rows, err := d.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	annotations := []Annotation{}
+	for rows.Next() {
+		var a Annotation
+		var tags string
+		if err := rows.Scan(&a.ID, &a.LogID, &a.Author, &a.Comment, &tags, &a.Bookmarked, &a.CreatedAt); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal([]byte(tags), &a.Tags); err != nil {
+			return nil, err
+		}
+		annotations = append(annotations, a)
+	}
+	return annotations, rows.Err()
+}
+
+// attachAnnotations fills in the annotations of each log so every viewer sees them
+func (d *Database) attachAnnotations(logs []LogEntry) error {
+	if len(logs) == 0 {
+		return nil
+	}
+	index := make(map[int64]int, len(logs))
+	placeholders := make([]string, len(logs))
+	args := make([]interface{}, len(logs))
+	for i, l := range logs {
+		index[l.ID] = i
+		placeholders[i] = "?"
+		args[i] = l.ID
+	}
+	annotations, err := d.queryAnnotations(`
+		SELECT id, log_id, author, comment, tags, bookmarked, created_at FROM annotations
+		WHERE log_id IN (`+strings.Join(placeholders, ", ")+`) ORDER BY created_at`, args...)
+	if err != nil {
+		return err
+	}
+	for _, a := range annotations {
+		i := index[a.LogID]
+		logs[i].Annotations = append(logs[i].Annotations, a)
+	}
+	return nil
+}
+
+// GET/POST/DELETE /api/annotations - bookmarks, comments and tags on log entries
+func annotationsHandlerDB(w http.ResponseWriter, r *http.Request, db *Database) {
+	enableCORS(w)
+	w.Header().Set("Content-Type", "application/json")
+	switch r.Method {
+	case http.MethodGet:
+		q := r.URL.Query()
+		filter := AnnotationFilter{Author: q.Get("author"), Tag: q.Get("tag"), Bookmarked: q.Get("bookmarked") == "true", Limit: 100}
+		if l, err := strconv.Atoi(q.Get("limit")); err == nil && l > 0 && l <= 1000 {
+			filter.Limit = l
+		}
+		if v := q.Get("log"); v != "" {
+			id, err := strconv.ParseInt(v, 10, 64)
+			if err != nil {
+				writeJSONError(w, http.StatusBadRequest, "Invalid log id")
+				return
+			}
+			filter.LogID = id
+		}
+		annotations, err := db.GetAnnotations(filter)
+		if err != nil {
+			writeJSONError(w, http.StatusInternalServerError, "Failed to fetch annotations")
+			return
+		}
+		json.NewEncoder(w).Encode(annotations)
+	case http.MethodPost:
+		var a Annotation
+		if err := json.NewDecoder(r.Body).Decode(&a); err != nil {
+			writeJSONError(w, http.StatusBadRequest, "Invalid JSON")
+			return
+		}
+		if a.Author == "" {
+			a.Author = r.Header.Get("X-Actor")
+		}
+		if a.Author == "" {
+			writeJSONError(w, http.StatusBadRequest, "author is required")
+			return
+		}
+		if a.Comment == "" && len(a.Tags) == 0 && !a.Bookmarked {
+			writeJSONError(w, http.StatusBadRequest, "comment, tags or bookmarked is required")
+			return
+		}
+		if _, err := db.GetLog(a.LogID); errors.Is(err, sql.ErrNoRows) {
+			writeJSONError(w, http.StatusNotFound, "Log not found")
+			return
+		} else if err != nil {
+			writeJSONError(w, http.StatusInternalServerError, "Failed to fetch log")
+			return
+		}
+		a, err := db.CreateAnnotation(a)
+		if err != nil {
+			writeJSONError(w, http.StatusInternalServerError, "Failed to create annotation")
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(a)
+	case http.MethodDelete:
+		id, err := strconv.ParseInt(r.URL.Query().Get("id"), 10, 64)
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, "Invalid id")
+			return
+		}
+		if err := db.DeleteAnnotation(id); errors.Is(err, sql.ErrNoRows) {
+			writeJSONError(w, http.StatusNotFound, "Annotation not found")
+			return
+		} else if err != nil {
+			writeJSONError(w, http.StatusInternalServerError, "Failed to delete annotation")
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+	}
+}
@@ -0,0 +1,205 @@
+package logserver
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+)
+
+// APIKey is a named credential. An admin key is accepted wherever the admin
+// token is; a viewer key can only read, within the data scopes attached to
+// it or its role. The secret is shown once when the key is created; only
+// its hash is stored.
+type APIKey struct {
+	ID        int64     `json:"id"`
+	Name      string    `json:"name"`
+	Role      string    `json:"role,omitempty"`
+	Prefix    string    `json:"prefix"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// Caller roles. Callers without a credential are anonymous.
+const (
+	roleAdmin     = "admin"
+	roleViewer    = "viewer"
+	roleAnonymous = "anonymous"
+)
+
+const (
+	apiKeyPrefix = "lg_"
+	apiKeyBytes  = 20
+	// apiKeyShownPrefix is how much of the secret is kept to tell keys apart
+	apiKeyShownPrefix = 8
+)
+
+// apiKeyCache holds the accepted secret hashes so requestRole does not query per request
+type apiKeyCache struct {
+	mu     sync.RWMutex
+	hashes map[string]credentialSecret
+	// roles and names map a key ID to its role and name
+	roles map[int64]string
+	names map[int64]string
+}
+
+func createAPIKeyTables(db *sql.DB) error {
+	_, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS api_keys (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			name TEXT NOT NULL,
+			prefix TEXT NOT NULL,
+			key_hash TEXT NOT NULL UNIQUE,
+			created_at DATETIME NOT NULL
+		)
+	`)
+	if err != nil {
+		return err
+	}
+	// Keys created before roles existed keep the admin access they had
+	if err := addColumnIfMissing(db, "api_keys", "role", `TEXT NOT NULL DEFAULT 'admin'`); err != nil {
+		return err
+	}
+	return addCredentialColumns(db, "api_keys")
+}
+
+func hashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// validAPIKey reports whether key is a current API key, or a rotated-out
+// one still inside its overlap period, and records its use
+func (d *Database) validAPIKey(key string) bool {
+	_, _, ok := d.lookupAPIKey(key)
+	return ok
+}
+
+// lookupAPIKey returns the ID and role of a valid API key, recording its use
+func (d *Database) lookupAPIKey(key string) (int64, string, bool) {
+	if !strings.HasPrefix(key, apiKeyPrefix) {
+		return 0, "", false
+	}
+	now := d.now()
+	d.apiKeys.mu.RLock()
+	secret, ok := d.apiKeys.hashes[hashAPIKey(key)]
+	role := d.apiKeys.roles[secret.id]
+	d.apiKeys.mu.RUnlock()
+	if !ok || !secret.validAt(now) {
+		return 0, "", false
+	}
+	d.recordCredentialUse("api_keys", secret.id, now)
+	return secret.id, role, true
+}
+
+func (d *Database) loadAPIKeys() error {
+	hashes, err := d.loadCredentialSecrets("api_keys", "key_hash")
+	if err != nil {
+		return err
+	}
+	rows, err := d.db.Query(`SELECT id, role, name FROM api_keys`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	roles, names := make(map[int64]string), make(map[int64]string)
+	for rows.Next() {
+		var id int64
+		var role, name string
+		if err := rows.Scan(&id, &role, &name); err != nil {
+			return err
+		}
+		roles[id], names[id] = role, name
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	d.apiKeys.mu.Lock()
+	d.apiKeys.hashes = hashes
+	d.apiKeys.roles = roles
+	d.apiKeys.names = names
+	d.apiKeys.mu.Unlock()
+	return nil
+}
+
+// apiKeyName returns the name of the API key with the given ID
+func (d *Database) apiKeyName(id int64) string {
+	d.apiKeys.mu.RLock()
+	defer d.apiKeys.mu.RUnlock()
+	return d.apiKeys.names[id]
+}
+
+func (k *APIKey) validate() error {
+	k.Name = strings.TrimSpace(k.Name)
+	if k.Name == "" {
+		return errors.New("name is required")
+	}
+	k.Role = apiKeyRole(k.Role)
+	switch k.Role {
+	case roleAdmin, roleViewer:
+	default:
+		return errors.New("role must be admin or viewer")
+	}
+	return nil
+}
+
+// apiKeyRole defaults a key's role to viewer, so admin access is only
+// given when asked for
+func apiKeyRole(role string) string {
+	if role == "" {
+		return roleViewer
+	}
+	return role
+}
+
+// CreateAPIKey stores a new key and returns it along with its secret
+func (d *Database) CreateAPIKey(k APIKey) (APIKey, string, error) {
+	secret := apiKeyPrefix + randomHex(apiKeyBytes)
+	k.Prefix = secret[:len(apiKeyPrefix)+apiKeyShownPrefix]
+	k.Role = apiKeyRole(k.Role)
+	k.CreatedAt = d.now().UTC()
+	res, err := d.db.Exec(`INSERT INTO api_keys (name, role, prefix, key_hash, created_at) VALUES (?, ?, ?, ?, ?)`,
+		k.Name, k.Role, k.Prefix, hashAPIKey(secret), k.CreatedAt)
+	if err != nil {
+		return k, "", err
+	}
+	if k.ID, err = res.LastInsertId(); err != nil {
+		return k, "", err
+	}
+	return k, secret, d.loadAPIKeys()
+}
+
+func (d *Database) GetAPIKey(id int64) (APIKey, error) {
+	var k APIKey
+	err := d.db.QueryRow(`SELECT id, name, role, prefix, created_at FROM api_keys WHERE id = ?`, id).
+		Scan(&k.ID, &k.Name, &k.Role, &k.Prefix, &k.CreatedAt)
+	return k, err
+}
+
+// UpdateAPIKey renames a key or changes its role; the secret never changes
+func (d *Database) UpdateAPIKey(k APIKey) error {
+	res, err := d.db.Exec(`UPDATE api_keys SET name = ?, role = ? WHERE id = ?`, k.Name, apiKeyRole(k.Role), k.ID)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return d.loadAPIKeys()
+}
+
+// DeleteAPIKey removes a key along with its data scopes
+func (d *Database) DeleteAPIKey(id int64) error {
+	if _, err := d.db.Exec(`DELETE FROM api_keys WHERE id = ?`, id); err != nil {
+		return err
+	}
+	if _, err := d.db.Exec(`DELETE FROM data_scopes WHERE api_key_id = ?`, id); err != nil {
+		return err
+	}
+	if err := d.loadDataScopes(); err != nil {
+		return err
+	}
+	return d.loadAPIKeys()
+}
@@ -0,0 +1,80 @@
+package logserver
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+)
+
+// statusWithKey returns the status of a GET of path sent with key
+func (h *harness) statusWithKey(path, key string) int {
+	h.t.Helper()
+	req, err := http.NewRequest(http.MethodGet, h.url+path, nil)
+	if err != nil {
+		h.t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer "+key)
+	resp, err := h.server.Client().Do(req)
+	if err != nil {
+		h.t.Fatal(err)
+	}
+	resp.Body.Close()
+	return resp.StatusCode
+}
+
+func TestAPIKeyRoleDefaultsToViewer(t *testing.T) {
+	h := newHarness(t)
+	var created IaCResource
+	expect(t, "create status", h.do(http.MethodPut, "/api/iac/api_keys/ci", map[string]string{"name": "ci"}, &created), http.StatusCreated)
+	expect(t, "role", created.Spec["role"], roleViewer)
+	expect(t, "search with a viewer key", h.statusWithKey("/api/logs", created.Key), http.StatusOK)
+	expect(t, "export with a viewer key", h.statusWithKey("/api/admin/export", created.Key), http.StatusForbidden)
+
+	// Leaving the role out again is no change
+	var again IaCResource
+	expect(t, "repeat status", h.do(http.MethodPut, "/api/iac/api_keys/ci", map[string]string{"name": "ci"}, &again), http.StatusOK)
+	expect(t, "etag", again.ETag, created.ETag)
+
+	var admin IaCResource
+	h.do(http.MethodPut, "/api/iac/api_keys/ops", map[string]string{"name": "ops", "role": roleAdmin}, &admin)
+	expect(t, "export with an admin key", h.statusWithKey("/api/admin/export", admin.Key), http.StatusOK)
+}
+
+// A rotated-out key's overlap and a lockout both run on the database's
+// clock, so they end when the clock passes them and not before
+func TestCredentialWindowsOnClock(t *testing.T) {
+	h := newHarness(t)
+	key, old, err := h.db.CreateAPIKey(APIKey{Name: "ci", Role: roleAdmin})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var rotated struct {
+		Secret string `json:"secret"`
+	}
+	h.do(http.MethodPost, fmt.Sprintf("/api/credentials/api_keys/%d/rotate?overlap=1h", key.ID), nil, &rotated)
+	h.clock.Advance(59 * time.Minute)
+	expect(t, "old key inside the overlap", h.statusWithKey("/api/admin/export", old), http.StatusOK)
+	h.clock.Advance(2 * time.Minute)
+	expect(t, "old key past the overlap", h.statusWithKey("/api/admin/export", old), http.StatusForbidden)
+	expect(t, "new key", h.statusWithKey("/api/admin/export", rotated.Secret), http.StatusOK)
+
+	// Failures lock the client out until the clock passes AUTH_LOCKOUT
+	for i := 0; i < authMaxFailures; i++ {
+		h.statusWithKey("/api/admin/export", old)
+	}
+	expect(t, "locked out", h.statusWithKey("/api/admin/export", rotated.Secret), http.StatusTooManyRequests)
+	h.clock.Advance(authLockout - time.Second)
+	expect(t, "before the lockout ends", h.statusWithKey("/api/admin/export", rotated.Secret), http.StatusTooManyRequests)
+	h.clock.Advance(time.Second)
+	expect(t, "after the lockout ends", h.statusWithKey("/api/admin/export", rotated.Secret), http.StatusOK)
+
+	// The failures are stored at the clock's time
+	var failures []LogEntry
+	h.waitFor("the auth events", 5*time.Second, func() bool {
+		failures = h.search(url.Values{"rule": {"Admin Login Failure"}})
+		return len(failures) == authMaxFailures+1
+	})
+	expect(t, "failure time", failures[0].Timestamp.UTC(), harnessStart.Add(61*time.Minute))
+}
@@ -0,0 +1,380 @@
+package logserver
+
+import (
+	"bytes"
+	"compress/gzip"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ArchiveSegment is one entry of the archive manifest (index.json): a
+// gzip-compressed NDJSON file of LogEntry objects covering From to To
+type ArchiveSegment struct {
+	Key  string    `json:"key"`
+	From time.Time `json:"from"`
+	To   time.Time `json:"to"`
+}
+
+// archiveManifestTTL is how long the manifest is cached before it is fetched again
+const archiveManifestTTL = time.Minute
+
+var archiveClient = &http.Client{Timeout: 60 * time.Second}
+
+// archiveStore reads archived segments from an HTTP(S) object storage
+// prefix or a local directory, keeping the most recently used decoded
+// segments in memory
+type archiveStore struct {
+	base      string
+	cacheSize int
+	// parallel is how many segments one search reads at once
+	parallel int
+
+	mu         sync.Mutex
+	manifest   []ArchiveSegment
+	manifestAt time.Time
+	segments   map[string][]LogEntry
+	recent     []string // segment keys, least recently used first
+	// blooms index every segment searched so far; see segmentBloom
+	blooms map[string]*bloomFilter
+	db     *sql.DB
+}
+
+// newArchiveStore returns nil unless ARCHIVE_URL is configured
+func newArchiveStore() *archiveStore {
+	base := os.Getenv("ARCHIVE_URL")
+	if base == "" {
+		return nil
+	}
+	cacheSize, err := strconv.Atoi(envOr("ARCHIVE_CACHE_SEGMENTS", "8"))
+	if err != nil || cacheSize < 1 {
+		cacheSize = 8
+	}
+	parallel, err := strconv.Atoi(envOr("ARCHIVE_SEARCH_PARALLEL", "4"))
+	if err != nil || parallel < 1 {
+		parallel = 4
+	}
+	return &archiveStore{
+		base:      strings.TrimRight(strings.TrimPrefix(base, "file://"), "/"),
+		cacheSize: cacheSize,
+		parallel:  parallel,
+		segments:  make(map[string][]LogEntry),
+		blooms:    make(map[string]*bloomFilter),
+	}
+}
+
+func (a *archiveStore) fetch(key string) ([]byte, error) {
+	if !strings.HasPrefix(a.base, "http://") && !strings.HasPrefix(a.base, "https://") {
+		if strings.Contains(key, "..") {
+			return nil, fmt.Errorf("invalid segment key %q", key)
+		}
+		return os.ReadFile(filepath.Join(a.base, key))
+	}
+	resp, err := archiveClient.Get(a.base + "/" + key)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching %s: %s", key, resp.Status)
+	}
+	return io.ReadAll(resp.Body)
+}
+
+// overlapping returns the segments covering any part of [from, to); zero
+// bounds are open
+func (a *archiveStore) overlapping(from, to time.Time) ([]ArchiveSegment, error) {
+	a.mu.Lock()
+	manifest, fresh := a.manifest, time.Since(a.manifestAt) < archiveManifestTTL
+	a.mu.Unlock()
+	if !fresh {
+		body, err := a.fetch("index.json")
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(body, &manifest); err != nil {
+			return nil, fmt.Errorf("decoding archive manifest: %w", err)
+		}
+		a.mu.Lock()
+		a.manifest, a.manifestAt = manifest, time.Now()
+		a.mu.Unlock()
+	}
+	var segments []ArchiveSegment
+	for _, s := range manifest {
+		if (to.IsZero() || s.From.Before(to)) && (from.IsZero() || !s.To.Before(from)) {
+			segments = append(segments, s)
+		}
+	}
+	return segments, nil
+}
+
+// load returns the decoded entries of a segment, each labelled with its key
+func (a *archiveStore) load(key string) ([]LogEntry, error) {
+	a.mu.Lock()
+	if logs, ok := a.segments[key]; ok {
+		a.touch(key)
+		a.mu.Unlock()
+		return logs, nil
+	}
+	a.mu.Unlock()
+
+	body, err := a.fetch(key)
+	if err != nil {
+		return nil, err
+	}
+	gz, err := gzip.NewReader(bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("decompressing %s: %w", key, err)
+	}
+	defer gz.Close()
+	var logs []LogEntry
+	dec := json.NewDecoder(gz)
+	for {
+		var log LogEntry
+		if err := dec.Decode(&log); errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("decoding %s: %w", key, err)
+		}
+		log.Archive = key
+		logs = append(logs, log)
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if _, ok := a.segments[key]; !ok {
+		a.segments[key] = logs
+		a.recent = append(a.recent, key)
+		if len(a.recent) > a.cacheSize {
+			delete(a.segments, a.recent[0])
+			a.recent = a.recent[1:]
+		}
+	}
+	return logs, nil
+}
+
+// touch marks key as most recently used; the caller holds a.mu
+func (a *archiveStore) touch(key string) {
+	for i, k := range a.recent {
+		if k == key {
+			a.recent = append(append(a.recent[:i:i], a.recent[i+1:]...), key)
+			return
+		}
+	}
+}
+
+// search scans the segments covering the filter's time range and returns up
+// to limit matches in sort order, along with the keys of the segments read
+// and how many segments their bloom filters ruled out. Up to a.parallel
+// segments are fetched and scanned at once, each keeping its own top limit
+// matches, and the per-segment results are merged.
+func (a *archiveStore) search(filter LogFilter, order logSort, limit int) ([]LogEntry, []string, int, error) {
+	segments, err := a.overlapping(filter.From, filter.To)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	// Archived entries carry their own tags, so tags are matched here
+	tags := filter.Tag
+	filter.Tag = nil
+
+	candidates := segments[:0]
+	a.mu.Lock()
+	for _, s := range segments {
+		if bloom := a.blooms[s.Key]; bloom == nil || bloomMayMatch(bloom, filter) {
+			candidates = append(candidates, s)
+		}
+	}
+	a.mu.Unlock()
+	skipped := len(segments) - len(candidates)
+	segments = candidates
+
+	results := make([][]LogEntry, len(segments))
+	errs := make([]error, len(segments))
+	var failed atomic.Bool
+	var wg sync.WaitGroup
+	slots := make(chan struct{}, a.parallel)
+	for i, s := range segments {
+		slots <- struct{}{}
+		if failed.Load() {
+			<-slots
+			break
+		}
+		wg.Add(1)
+		go func(i int, key string) {
+			defer func() { <-slots; wg.Done() }()
+			results[i], errs[i] = a.searchSegment(key, filter, tags, order, limit)
+			if errs[i] != nil {
+				failed.Store(true)
+			}
+		}(i, s.Key)
+	}
+	wg.Wait()
+
+	var matches []LogEntry
+	keys := make([]string, 0, len(segments))
+	for i, s := range segments {
+		if errs[i] != nil {
+			return nil, nil, 0, errs[i]
+		}
+		matches = append(matches, results[i]...)
+		keys = append(keys, s.Key)
+	}
+	return topLogs(matches, order, limit), keys, skipped, nil
+}
+
+// searchSegment returns the top limit matches in one segment, indexing the
+// segment first if it has no bloom filter yet
+func (a *archiveStore) searchSegment(key string, filter LogFilter, tags stringList, order logSort, limit int) ([]LogEntry, error) {
+	logs, err := a.load(key)
+	if err != nil {
+		return nil, err
+	}
+	a.mu.Lock()
+	_, indexed := a.blooms[key]
+	a.mu.Unlock()
+	if !indexed {
+		a.saveBloom(key, segmentBloom(logs))
+	}
+	var matches []LogEntry
+	for _, l := range logs {
+		if (!filter.From.IsZero() && l.Timestamp.Before(filter.From)) || (!filter.To.IsZero() && !l.Timestamp.Before(filter.To)) {
+			continue
+		}
+		if filter.matches(l) && (len(tags) == 0 || hasAnyTag(l.Tags, tags)) {
+			matches = append(matches, l)
+		}
+	}
+	return topLogs(matches, order, limit), nil
+}
+
+// Segment bloom filters hold each log's source IP, user and trace ID, plus
+// both IPs lowercased for exact ip= searches. Segments are assumed never to
+// change once archived, so a filter is built the first time its segment is
+// searched and kept in archive_blooms from then on.
+
+func createArchiveTables(db *sql.DB) error {
+	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS archive_blooms (
+		segment_key TEXT PRIMARY KEY,
+		bloom BLOB NOT NULL,
+		created_at DATETIME NOT NULL
+	)`)
+	return err
+}
+
+func (a *archiveStore) loadBlooms(db *sql.DB) error {
+	a.db = db
+	rows, err := db.Query(`SELECT segment_key, bloom FROM archive_blooms`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var key string
+		var data []byte
+		if err := rows.Scan(&key, &data); err != nil {
+			return err
+		}
+		bloom, err := bloomFilterFromBytes(data)
+		if err != nil {
+			log.Printf("archive: ignoring bloom filter for %s: %v", key, err)
+			continue
+		}
+		a.blooms[key] = bloom
+	}
+	return rows.Err()
+}
+
+// saveBloom keeps a segment's bloom filter in memory and in archive_blooms.
+// A failed write only costs rebuilding the filter after a restart.
+func (a *archiveStore) saveBloom(key string, bloom *bloomFilter) {
+	a.mu.Lock()
+	a.blooms[key] = bloom
+	a.mu.Unlock()
+	_, err := a.db.Exec(`INSERT OR REPLACE INTO archive_blooms (segment_key, bloom, created_at) VALUES (?, ?, ?)`,
+		key, bloom.Bytes(), time.Now().UTC())
+	if err != nil {
+		log.Printf("archive: failed to store bloom filter for %s: %v", key, err)
+	}
+}
+
+func segmentBloom(logs []LogEntry) *bloomFilter {
+	values := make(map[string]struct{})
+	add := func(prefix, value string) {
+		if value != "" {
+			values[prefix+value] = struct{}{}
+		}
+	}
+	for _, l := range logs {
+		add("src:", l.SourceIP)
+		add("ip:", strings.ToLower(l.SourceIP))
+		add("ip:", strings.ToLower(l.DestinationIP))
+		add("user:", l.User)
+		add("trace:", l.TraceID)
+	}
+	bloom := newBloomFilter(len(values))
+	for value := range values {
+		bloom.Add(value)
+	}
+	return bloom
+}
+
+// bloomMayMatch reports whether a segment with this bloom filter could hold
+// a log matching f. Only exact conditions on indexed fields can rule it out.
+func bloomMayMatch(bloom *bloomFilter, f LogFilter) bool {
+	anyOf := func(prefix string, values stringList) bool {
+		if len(values) == 0 {
+			return true
+		}
+		for _, v := range values {
+			if bloom.MayContain(prefix + v) {
+				return true
+			}
+		}
+		return false
+	}
+	if f.IP != "" && f.Match == matchExact && !bloom.MayContain("ip:"+strings.ToLower(f.IP)) {
+		return false
+	}
+	return anyOf("src:", f.Source) && anyOf("user:", f.User) && anyOf("trace:", f.TraceID)
+}
+
+func hasAnyTag(tags []string, wanted stringList) bool {
+	for _, tag := range tags {
+		if wanted.contains(tag) {
+			return true
+		}
+	}
+	return false
+}
+
+// topLogs sorts logs and keeps the first limit
+func topLogs(logs []LogEntry, order logSort, limit int) []LogEntry {
+	sort.SliceStable(logs, func(i, j int) bool { return order.less(logs[i], logs[j]) })
+	if len(logs) > limit {
+		logs = logs[:limit]
+	}
+	return logs
+}
+
+// mergeArchive adds matching archived entries to live search results,
+// returning the merged page, the segments that were read and the number
+// skipped by their bloom filters
+func (d *Database) mergeArchive(logs []LogEntry, filter LogFilter, order logSort, limit int) ([]LogEntry, []string, int, error) {
+	archived, keys, skipped, err := d.archive.search(filter, order, limit)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	return topLogs(append(logs, archived...), order, limit), keys, skipped, nil
+}
@@ -0,0 +1,403 @@
+package logserver
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Request audit. Every request that changes something, and every request
+// to /api/admin/, is recorded with who sent it, the route it matched and
+// how it ended, so a broken detection can be traced back to the change
+// that broke it. Log ingest is left out. With AUDIT_CAPTURE_BODIES=true the
+// request and response bodies are kept too, capped at AUDIT_BODY_LIMIT
+// bytes each and with secrets redacted; AUDIT_CAPTURE_ROUTES narrows that
+// to some route patterns.
+
+var (
+	auditRetention    = envDuration("AUDIT_RETENTION", 90*24*time.Hour)
+	auditCaptureBody  = envOr("AUDIT_CAPTURE_BODIES", "false") == "true"
+	auditCaptureRoute = splitValues([]string{envOr("AUDIT_CAPTURE_ROUTES", "")})
+	auditBodyLimit    = envInt("AUDIT_BODY_LIMIT", 16<<10)
+	// auditRedact matches the names of fields whose values are redacted,
+	// the defaults plus AUDIT_REDACT_FIELDS
+	auditRedact = auditRedactPattern(append([]string{"password", "passwd", "secret", "token", "apikey", "api_key",
+		"authorization", "credential", "signature", "privatekey", "private_key"}, splitValues([]string{envOr("AUDIT_REDACT_FIELDS", "")})...))
+)
+
+const auditPruneInterval = time.Hour
+
+// auditRedacted replaces a redacted value
+const auditRedacted = "[REDACTED]"
+
+// auditSkipRoutes are the ingest routes, whose POSTs are not audited
+var auditSkipRoutes = map[string]bool{
+	"/api/logs":             true,
+	"/api/ingest/batches/":  true,
+	"/api/ingest/gelf":      true,
+	"/api/ingest/webhooks/": true,
+}
+
+// AuditEntry is one audited request. RequestBytes and ResponseBytes are the
+// full body sizes; the bodies themselves are only kept in capture mode.
+type AuditEntry struct {
+	ID            int64     `json:"id"`
+	At            time.Time `json:"at"`
+	Method        string    `json:"method"`
+	Path          string    `json:"path"`
+	Route         string    `json:"route"`
+	Client        string    `json:"client"`
+	Actor         string    `json:"actor,omitempty"`
+	IP            string    `json:"ip"`
+	Status        int       `json:"status"`
+	DurationMs    float64   `json:"durationMs"`
+	RequestBytes  int64     `json:"requestBytes"`
+	ResponseBytes int64     `json:"responseBytes"`
+	RequestBody   string    `json:"requestBody,omitempty"`
+	ResponseBody  string    `json:"responseBody,omitempty"`
+	// Truncated is set when a kept body was cut at AUDIT_BODY_LIMIT
+	Truncated bool `json:"truncated,omitempty"`
+}
+
+// AuditFilter narrows /api/admin/audit results
+type AuditFilter struct {
+	Route  string
+	Client string
+	Method string
+	From   time.Time
+	To     time.Time
+	Limit  int
+}
+
+func createAuditTables(db *sql.DB) error {
+	for _, stmt := range []string{
+		`CREATE TABLE IF NOT EXISTS audit_log (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			at DATETIME NOT NULL,
+			method TEXT NOT NULL,
+			path TEXT NOT NULL,
+			route TEXT NOT NULL,
+			client TEXT NOT NULL,
+			actor TEXT NOT NULL DEFAULT '',
+			ip TEXT NOT NULL,
+			status INTEGER NOT NULL,
+			duration_ms REAL NOT NULL,
+			request_bytes INTEGER NOT NULL,
+			response_bytes INTEGER NOT NULL,
+			request_body TEXT NOT NULL DEFAULT '',
+			response_body TEXT NOT NULL DEFAULT '',
+			truncated INTEGER NOT NULL DEFAULT 0
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_audit_log_at ON audit_log(at)`,
+	} {
+		if _, err := db.Exec(stmt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// auditRedactPattern matches "name": value, name: value and name=value for
+// every field name containing one of names
+func auditRedactPattern(names []string) *regexp.Regexp {
+	quoted := make([]string, len(names))
+	for i, n := range names {
+		quoted[i] = regexp.QuoteMeta(n)
+	}
+	return regexp.MustCompile(`(?i)("?[\w.-]*(?:` + strings.Join(quoted, "|") + `)[\w.-]*"?\s*[:=]\s*)("(?:[^"\\]|\\.)*"|[^\s,&}\]]+)`)
+}
+
+// auditSensitive reports whether a JSON field's value is redacted
+func auditSensitive(name string) bool {
+	return auditRedact.MatchString(name + "=x")
+}
+
+// redactAuditBody returns body with its secrets replaced. A complete JSON
+// body is redacted field by field, anything else by auditRedact.
+func redactAuditBody(body []byte, complete bool) string {
+	if !utf8.Valid(body) {
+		return fmt.Sprintf("(%d bytes of binary data)", len(body))
+	}
+	if complete {
+		dec := json.NewDecoder(bytes.NewReader(body))
+		dec.UseNumber()
+		var v interface{}
+		if dec.Decode(&v) == nil && !dec.More() {
+			if redacted, err := json.Marshal(redactAuditValue(v)); err == nil {
+				return string(redacted)
+			}
+		}
+	}
+	return redactAuditText(string(body))
+}
+
+func redactAuditValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for k, field := range v {
+			if auditSensitive(k) {
+				v[k] = auditRedacted
+			} else {
+				v[k] = redactAuditValue(field)
+			}
+		}
+	case []interface{}:
+		for i, item := range v {
+			v[i] = redactAuditValue(item)
+		}
+	}
+	return v
+}
+
+func redactAuditText(s string) string {
+	return auditRedact.ReplaceAllStringFunc(s, func(m string) string {
+		sub := auditRedact.FindStringSubmatch(m)
+		if strings.HasPrefix(sub[2], `"`) {
+			return sub[1] + `"` + auditRedacted + `"`
+		}
+		return sub[1] + auditRedacted
+	})
+}
+
+// auditCapture keeps the first auditBodyLimit bytes of a body and counts the rest
+type auditCapture struct {
+	buf   []byte
+	total int64
+}
+
+func (c *auditCapture) add(b []byte) {
+	c.total += int64(len(b))
+	if room := auditBodyLimit - len(c.buf); room > 0 {
+		c.buf = append(c.buf, b[:min(room, len(b))]...)
+	}
+}
+
+func (c *auditCapture) truncated() bool {
+	return c.total > int64(len(c.buf))
+}
+
+// auditBodyReader captures the request body as the handler reads it
+type auditBodyReader struct {
+	io.ReadCloser
+	capture *auditCapture
+}
+
+func (r auditBodyReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.capture.add(p[:n])
+	return n, err
+}
+
+// auditRecorder captures the status and the response body
+type auditRecorder struct {
+	*statusRecorder
+	capture *auditCapture
+}
+
+func (w auditRecorder) Write(b []byte) (int, error) {
+	n, err := w.statusRecorder.Write(b)
+	w.capture.add(b[:n])
+	return n, err
+}
+
+// audited reports whether a request to route is recorded
+func audited(r *http.Request, route string) bool {
+	switch {
+	case r.Method == http.MethodOptions || route == "/api/admin/audit":
+		return false
+	case r.Method == http.MethodGet || r.Method == http.MethodHead:
+		return strings.HasPrefix(route, "/api/admin/")
+	case isNDJSONIngest(r):
+		return false
+	}
+	return r.Method != http.MethodPost || !auditSkipRoutes[route]
+}
+
+// captureBodies reports whether the bodies of requests to route are kept
+func captureBodies(route string) bool {
+	if !auditCaptureBody {
+		return false
+	}
+	if len(auditCaptureRoute) == 0 {
+		return true
+	}
+	for _, prefix := range auditCaptureRoute {
+		if strings.HasPrefix(route, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// auditRequests records the audited requests next handles
+func (d *Database) auditRequests(mux *http.ServeMux, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, route := mux.Handler(r)
+		if !audited(r, route) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		start := time.Now()
+		var request, response auditCapture
+		if r.Body != nil {
+			r.Body = auditBodyReader{ReadCloser: r.Body, capture: &request}
+		}
+		rec := auditRecorder{statusRecorder: &statusRecorder{ResponseWriter: w}, capture: &response}
+		next.ServeHTTP(rec, r)
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+		path := r.URL.Path
+		if r.URL.RawQuery != "" {
+			path += "?" + redactAuditText(r.URL.RawQuery)
+		}
+		e := AuditEntry{
+			At:            d.now().UTC(),
+			Method:        r.Method,
+			Path:          path,
+			Route:         route,
+			Client:        d.usageClient(r),
+			Actor:         r.Header.Get("X-Actor"),
+			IP:            clientIP(r),
+			Status:        rec.status,
+			DurationMs:    float64(time.Since(start)) / float64(time.Millisecond),
+			RequestBytes:  request.total,
+			ResponseBytes: response.total,
+		}
+		if captureBodies(route) {
+			e.RequestBody = redactAuditBody(request.buf, !request.truncated())
+			e.ResponseBody = redactAuditBody(response.buf, !response.truncated())
+			e.Truncated = request.truncated() || response.truncated()
+		}
+		d.recordAudit(e)
+	})
+}
+
+// recordAudit stores an audit entry. Like recordChange it never fails the
+// request; errors are logged.
+func (d *Database) recordAudit(e AuditEntry) {
+	_, err := d.db.Exec(`
+		INSERT INTO audit_log (at, method, path, route, client, actor, ip, status, duration_ms, request_bytes, response_bytes,
+			request_body, response_body, truncated)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, e.At, e.Method, e.Path, e.Route, e.Client, e.Actor, e.IP, e.Status, e.DurationMs, e.RequestBytes, e.ResponseBytes,
+		e.RequestBody, e.ResponseBody, e.Truncated)
+	if err != nil {
+		log.Printf("audit: failed to record %s %s: %v", e.Method, e.Path, err)
+	}
+}
+
+// GetAuditLog returns audit entries matching filter, newest first
+func (d *Database) GetAuditLog(filter AuditFilter) ([]AuditEntry, error) {
+	query := `SELECT id, at, method, path, route, client, actor, ip, status, duration_ms, request_bytes, response_bytes,
+		request_body, response_body, truncated FROM audit_log WHERE 1=1`
+	args := []interface{}{}
+	if filter.Route != "" {
+		query += ` AND instr(route, ?) = 1`
+		args = append(args, filter.Route)
+	}
+	if filter.Client != "" {
+		query += ` AND client = ?`
+		args = append(args, filter.Client)
+	}
+	if filter.Method != "" {
+		query += ` AND method = ?`
+		args = append(args, strings.ToUpper(filter.Method))
+	}
+	if !filter.From.IsZero() {
+		query += ` AND at >= ?`
+		args = append(args, filter.From.UTC())
+	}
+	if !filter.To.IsZero() {
+		query += ` AND at < ?`
+		args = append(args, filter.To.UTC())
+	}
+	query += ` ORDER BY id DESC LIMIT ?`
+	args = append(args, filter.Limit)
+
+	rows, err := d.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	entries := []AuditEntry{}
+	for rows.Next() {
+		var e AuditEntry
+		err := rows.Scan(&e.ID, &e.At, &e.Method, &e.Path, &e.Route, &e.Client, &e.Actor, &e.IP, &e.Status, &e.DurationMs,
+			&e.RequestBytes, &e.ResponseBytes, &e.RequestBody, &e.ResponseBody, &e.Truncated)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
+
+func (d *Database) pruneAuditLoop() {
+	ticker := time.NewTicker(auditPruneInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			cutoff := d.now().Add(-auditRetention).UTC()
+			if _, err := d.db.Exec(`DELETE FROM audit_log WHERE at < ?`, cutoff); err != nil {
+				log.Printf("audit: prune failed: %v", err)
+			}
+		case <-d.done:
+			return
+		}
+	}
+}
+
+// GET /api/admin/audit?route=&client=&method=&from=&to=&since=&range=&limit= -
+// audited requests, newest first (admin only)
+func auditHandlerDB(w http.ResponseWriter, r *http.Request, db *Database) {
+	enableCORS(w)
+	w.Header().Set("Content-Type", "application/json")
+	if !db.requireAdmin(w, r) {
+		return
+	}
+	if r.Method != http.MethodGet {
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	q := r.URL.Query()
+	filter := AuditFilter{Route: q.Get("route"), Client: q.Get("client"), Method: q.Get("method"), Limit: 100}
+	from, to, ok, err := relativeRange(q, db.now())
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	filter.From, filter.To = from, to
+	for name, dest := range map[string]*time.Time{"from": &filter.From, "to": &filter.To} {
+		if ok {
+			break
+		}
+		if v := q.Get(name); v != "" {
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				writeJSONError(w, http.StatusBadRequest, "Invalid '"+name+"' timestamp")
+				return
+			}
+			*dest = t
+		}
+	}
+	if l, err := strconv.Atoi(q.Get("limit")); err == nil && l > 0 && l <= 1000 {
+		filter.Limit = l
+	}
+	entries, err := db.GetAuditLog(filter)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "Failed to fetch audit log")
+		return
+	}
+	json.NewEncoder(w).Encode(entries)
+}
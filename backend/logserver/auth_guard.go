@@ -0,0 +1,193 @@
+package logserver
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Brute force protection for the admin token and API keys, which are this
+// server's logins. Failed attempts are counted per client IP; after
+// AUTH_MAX_FAILURES failures within AUTH_FAILURE_WINDOW the client is
+// locked out for AUTH_LOCKOUT, doubling with every further lockout. Every
+// failure and lockout is written to the log store as an access event.
+var (
+	authMaxFailures   = int(envFloat("AUTH_MAX_FAILURES", 5))
+	authFailureWindow = envDuration("AUTH_FAILURE_WINDOW", 15*time.Minute)
+	authLockout       = envDuration("AUTH_LOCKOUT", 15*time.Minute)
+	// trustProxy takes the client IP from X-Real-IP or X-Forwarded-For,
+	// which the bundled nginx sets; only enable it behind a proxy
+	trustProxy = os.Getenv("TRUST_PROXY") == "true"
+)
+
+const (
+	authMaxLockout = 24 * time.Hour
+	// authMaxClients bounds the tracked clients; idle ones are dropped beyond it
+	authMaxClients = 10000
+)
+
+// AuthLockout is the data of an auth.lockout webhook event
+type AuthLockout struct {
+	SourceIP    string    `json:"sourceIP"`
+	Failures    int       `json:"failures"`
+	LockedUntil time.Time `json:"lockedUntil"`
+}
+
+type authClient struct {
+	failures     int
+	firstFailure time.Time
+	lockouts     int
+	lockedUntil  time.Time
+}
+
+// authGuard counts failed admin authentications per client IP
+type authGuard struct {
+	mu      sync.Mutex
+	clients map[string]*authClient
+}
+
+// authEvent is an access log entry and, for lockouts, the webhook event data
+type authEvent struct {
+	entry   LogEntry
+	lockout *AuthLockout
+}
+
+// authEventBuffer is how many authentication events wait for authEventsLoop
+const authEventBuffer = 256
+
+func clientIP(r *http.Request) string {
+	if trustProxy {
+		if ip := r.Header.Get("X-Real-IP"); ip != "" {
+			return ip
+		}
+		if fwd := r.Header.Get("X-Forwarded-For"); fwd != "" {
+			return strings.TrimSpace(strings.Split(fwd, ",")[0])
+		}
+	}
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		return host
+	}
+	return r.RemoteAddr
+}
+
+// presentedCredential reports whether the request tried to authenticate
+func presentedCredential(r *http.Request) bool {
+	return r.Header.Get("X-Admin-Token") != "" || strings.HasPrefix(r.Header.Get("Authorization"), "Bearer ")
+}
+
+// authLockedFor returns how long ip remains locked out
+func (d *Database) authLockedFor(ip string, now time.Time) time.Duration {
+	d.authGuard.mu.Lock()
+	defer d.authGuard.mu.Unlock()
+	if c := d.authGuard.clients[ip]; c != nil && now.Before(c.lockedUntil) {
+		return c.lockedUntil.Sub(now)
+	}
+	return 0
+}
+
+// authSucceeded clears ip's failures, logging the success if it follows failures
+func (d *Database) authSucceeded(ip string, r *http.Request) {
+	d.authGuard.mu.Lock()
+	c := d.authGuard.clients[ip]
+	delete(d.authGuard.clients, ip)
+	d.authGuard.mu.Unlock()
+	if c != nil && c.failures > 0 {
+		d.sendAuthEvent(LogEntry{
+			Level: "INFO", Rule: "Admin Login Success", Event: "auth_success", Urgency: 1, SourceIP: ip,
+			Description: fmt.Sprintf("Admin authentication succeeded for %s %s after %d failed attempts", r.Method, r.URL.Path, c.failures),
+		}, nil)
+	}
+}
+
+// authFailed records a failed attempt and locks ip out once it has failed too often
+func (d *Database) authFailed(ip string, r *http.Request, now time.Time) {
+	d.authGuard.mu.Lock()
+	if d.authGuard.clients == nil {
+		d.authGuard.clients = make(map[string]*authClient)
+	}
+	if len(d.authGuard.clients) >= authMaxClients {
+		for key, c := range d.authGuard.clients {
+			if now.After(c.lockedUntil) && now.Sub(c.firstFailure) > authFailureWindow {
+				delete(d.authGuard.clients, key)
+			}
+		}
+	}
+	c := d.authGuard.clients[ip]
+	if c == nil {
+		c = &authClient{}
+		d.authGuard.clients[ip] = c
+	}
+	if c.failures == 0 || now.Sub(c.firstFailure) > authFailureWindow {
+		c.failures, c.firstFailure = 0, now
+	}
+	c.failures++
+	failures := c.failures
+	var lockout *AuthLockout
+	if c.failures >= authMaxFailures {
+		duration := authLockout << c.lockouts
+		if duration > authMaxLockout || duration <= 0 {
+			duration = authMaxLockout
+		}
+		c.lockouts++
+		c.failures = 0
+		c.lockedUntil = now.Add(duration)
+		lockout = &AuthLockout{SourceIP: ip, Failures: failures, LockedUntil: c.lockedUntil.UTC()}
+	}
+	d.authGuard.mu.Unlock()
+
+	d.sendAuthEvent(LogEntry{
+		Level: "WARN", Rule: "Admin Login Failure", Event: "auth_failure", Urgency: 2, SourceIP: ip,
+		Description: fmt.Sprintf("Failed admin authentication for %s %s (%d of %d)", r.Method, r.URL.Path, failures, authMaxFailures),
+	}, nil)
+	if lockout != nil {
+		log.Printf("auth: locked out %s until %s after %d failed attempts", ip, lockout.LockedUntil.Format(time.RFC3339), failures)
+		d.sendAuthEvent(LogEntry{
+			Level: "ERROR", Rule: "Admin Login Lockout", Event: "auth_lockout", Urgency: 4, SourceIP: ip,
+			Description: fmt.Sprintf("Locked out after %d failed admin authentications until %s", failures, lockout.LockedUntil.Format(time.RFC3339)),
+		}, lockout)
+	}
+}
+
+// sendAuthEvent hands an event to authEventsLoop, which stores it; sends
+// never block, so events are dropped when it falls behind
+func (d *Database) sendAuthEvent(entry LogEntry, lockout *AuthLockout) {
+	entry.Timestamp = d.now()
+	entry.Category = "access"
+	select {
+	case d.authEvents <- authEvent{entry, lockout}:
+	default:
+		log.Printf("auth: dropped %s event for %s", entry.Event, entry.SourceIP)
+	}
+}
+
+// authEventsLoop stores authentication events and notifies webhook
+// subscribers of lockouts
+func (d *Database) authEventsLoop() {
+	for {
+		select {
+		case e := <-d.authEvents:
+			if err := d.InsertLog(e.entry); err != nil {
+				log.Printf("auth: failed to store %s event: %v", e.entry.Event, err)
+			}
+			if e.lockout != nil {
+				d.emitEvent(eventAuthLockout, *e.lockout)
+			}
+		case <-d.done:
+			return
+		}
+	}
+}
+
+// writeLockedOut answers a locked-out client with 429 and Retry-After
+func writeLockedOut(w http.ResponseWriter, wait time.Duration) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Retry-After", strconv.Itoa(int(wait.Seconds())+1))
+	w.WriteHeader(http.StatusTooManyRequests)
+	w.Write([]byte(`{"error":"Too many failed attempts; try again later"}`))
+}
@@ -0,0 +1,218 @@
+package logserver
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Benchmarks. They measure ingest throughput, search latency and the
+// aggregation endpoints against databases seeded with a given number of
+// synthetic logs, through the same handlers the server routes to. -rows
+// picks the database sizes; benchstat compares two runs.
+
+var benchRows = flag.String("rows", "10k", "comma-separated database sizes to search and aggregate, with k and m suffixes")
+
+// benchNow is the time the benchmarks run at. Seeded logs cover the week
+// before it, so the dashboards' 24 hour window holds a seventh of them.
+var benchNow = time.Date(2025, 1, 8, 12, 0, 0, 0, time.UTC)
+
+// benchIngestBatch is the number of logs in each ingest request
+const benchIngestBatch = 500
+
+// benchSearches are GET /api/logs queries timed at every database size
+var benchSearches = []struct{ name, query string }{
+	{"latest", ""},
+	{"ip", "ip=10.0.3.7"},
+	{"level", "level=ERROR"},
+	{"rule_and_user", "rule=Brute+Force+Login&user=admin"},
+	{"event_text", "event=password"},
+	{"last_hour", "since=1h&limit=1000"},
+	{"count_level", "count_only=true&level=WARN"},
+}
+
+// benchAggregations are the dashboard and group-by endpoints timed at
+// every database size
+var benchAggregations = []struct {
+	name, target string
+	handler      func(http.ResponseWriter, *http.Request, *Database)
+}{
+	{"summary", "/api/summary", summaryStatsHandlerDB},
+	{"urgency", "/api/urgency", urgencyDataHandlerDB},
+	{"timeline", "/api/timeline", timelineDataHandlerDB},
+	{"top_events", "/api/top-events", topEventsHandlerDB},
+	{"top_sources", "/api/top-sources", topSourcesHandlerDB},
+	{"group_by_rule", "/api/logs?group_by=rule&since=1d", logSearchHandlerDB},
+	{"group_by_source", "/api/logs?group_by=source&limit=10", logSearchHandlerDB},
+}
+
+// BenchmarkIngest posts JSON batches of benchIngestBatch logs to
+// POST /api/logs, reporting logs per second
+func BenchmarkIngest(b *testing.B) {
+	db, err := openDatabase(filepath.Join(b.TempDir(), "ingest.db"), NewManualClock(benchNow))
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer db.Close()
+	body, _ := json.Marshal(benchLogs(benchIngestBatch, 2))
+	b.Run(fmt.Sprintf("json_batch=%d", benchIngestBatch), func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodPost, "/api/logs", bytes.NewReader(body))
+			r.Header.Set("Content-Type", "application/json")
+			logIngestHandlerDB(w, r, db)
+			if w.Code != http.StatusCreated {
+				b.Fatalf("ingest: status %d: %s", w.Code, w.Body.String())
+			}
+		}
+		b.ReportMetric(float64(b.N*benchIngestBatch)/b.Elapsed().Seconds(), "logs/s")
+	})
+}
+
+// BenchmarkSearch runs GET /api/logs queries at every -rows size
+func BenchmarkSearch(b *testing.B) {
+	for _, n := range benchSizes(b) {
+		db := seedBenchDatabase(b, n)
+		b.Run(fmt.Sprintf("rows=%d", n), func(b *testing.B) {
+			for _, s := range benchSearches {
+				b.Run(s.name, benchHandler(db, "/api/logs?"+s.query, logSearchHandlerDB))
+			}
+		})
+		db.Close()
+	}
+}
+
+// BenchmarkAggregate runs the dashboard and group-by endpoints at every
+// -rows size
+func BenchmarkAggregate(b *testing.B) {
+	for _, n := range benchSizes(b) {
+		db := seedBenchDatabase(b, n)
+		b.Run(fmt.Sprintf("rows=%d", n), func(b *testing.B) {
+			for _, a := range benchAggregations {
+				b.Run(a.name, benchHandler(db, a.target, a.handler))
+			}
+		})
+		db.Close()
+	}
+}
+
+// benchHandler times one GET request per op against handler
+func benchHandler(db *Database, target string, handler func(http.ResponseWriter, *http.Request, *Database)) func(b *testing.B) {
+	return func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			w := httptest.NewRecorder()
+			handler(w, httptest.NewRequest(http.MethodGet, target, nil), db)
+			if w.Code != http.StatusOK {
+				b.Fatalf("%s: status %d: %s", target, w.Code, w.Body.String())
+			}
+		}
+	}
+}
+
+// benchLogs generates n logs from a fixed seed, newest first, spread evenly
+// over the week before benchNow
+func benchLogs(n int, seed int64) []LogEntry {
+	rng := rand.New(rand.NewSource(seed))
+	rules := []struct {
+		rule, level, event string
+		urgency            int
+	}{
+		{"Brute Force Login", "WARN", "Failed password", 2},
+		{"Brute Force Login", "ERROR", "Successful login after failures", 4},
+		{"Outbound Network Traffic", "INFO", "Outbound transfer", 1},
+		{"Internal Network Traffic", "INFO", "SMB connection", 1},
+		{"Malware Threat Detected", "ERROR", "Malware signature match", 4},
+		{"Data Exfiltration Threat", "ERROR", "Large outbound transfer", 3},
+		{"Lateral Movement Behavior", "WARN", "Remote service login", 3},
+	}
+	users := []string{"admin", "root", "jsmith", "svc-backup", "mchen", "apatel", "rlopez", "kwong"}
+	step := 7 * 24 * time.Hour / time.Duration(max(n, 1))
+	logs := make([]LogEntry, n)
+	for i := range logs {
+		r := rules[rng.Intn(len(rules))]
+		src := fmt.Sprintf("10.0.%d.%d", rng.Intn(16), rng.Intn(64))
+		dst := fmt.Sprintf("192.168.%d.%d", rng.Intn(4), rng.Intn(256))
+		user := users[rng.Intn(len(users))]
+		logs[i] = LogEntry{
+			Timestamp:       benchNow.Add(-time.Duration(i) * step),
+			Level:           r.level,
+			Rule:            r.rule,
+			SourceIP:        src,
+			DestinationIP:   dst,
+			Event:           r.event,
+			Description:     fmt.Sprintf("%s for %s from %s to %s", r.event, user, src, dst),
+			Urgency:         r.urgency,
+			User:            user,
+			SourcePort:      1024 + rng.Intn(64000),
+			DestinationPort: []int{22, 443, 445, 3389}[rng.Intn(4)],
+		}
+	}
+	return logs
+}
+
+// seedBenchDatabase creates a database holding n logs. They are inserted in
+// one transaction rather than through ingest, which would take most of the
+// run at a million logs; the database is then reopened so the dashboard
+// counters and top-N trackers are seeded from them.
+func seedBenchDatabase(b *testing.B, n int) *Database {
+	path := filepath.Join(b.TempDir(), fmt.Sprintf("rows-%d.db", n))
+	d, err := openDatabase(path, NewManualClock(benchNow))
+	if err != nil {
+		b.Fatal(err)
+	}
+	tx, err := d.db.Begin()
+	if err != nil {
+		b.Fatal(err)
+	}
+	stmt := tx.Stmt(d.insertLog)
+	for _, l := range benchLogs(n, 1) {
+		_, err = stmt.Exec(l.Timestamp, l.Level, l.Rule, l.SourceIP, l.DestinationIP, l.Event, l.Description,
+			l.Urgency, l.User, l.SourcePort, l.DestinationPort, l.TraceID)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		b.Fatal(err)
+	}
+	if err := d.Close(); err != nil {
+		b.Fatal(err)
+	}
+	if d, err = openDatabase(path, NewManualClock(benchNow)); err != nil {
+		b.Fatal(err)
+	}
+	return d
+}
+
+// benchSizes reads -rows, a comma-separated list of row counts with k and m
+// suffixes
+func benchSizes(b *testing.B) []int {
+	var sizes []int
+	for _, s := range strings.Split(*benchRows, ",") {
+		s = strings.ToLower(strings.TrimSpace(s))
+		multiplier := 1
+		switch {
+		case strings.HasSuffix(s, "k"):
+			s, multiplier = strings.TrimSuffix(s, "k"), 1000
+		case strings.HasSuffix(s, "m"):
+			s, multiplier = strings.TrimSuffix(s, "m"), 1000000
+		}
+		n, err := strconv.Atoi(s)
+		if err != nil || n <= 0 {
+			b.Fatalf("invalid -rows count %q", s)
+		}
+		sizes = append(sizes, n*multiplier)
+	}
+	return sizes
+}
@@ -0,0 +1,79 @@
+package logserver
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash/fnv"
+	"math"
+)
+
+// bloomFalsePositive is the false positive rate bloom filters are sized for
+const bloomFalsePositive = 0.01
+
+// bloomFilter answers "might this value have been added?" with no false
+// negatives; its bits are stored as-is in archive_blooms
+type bloomFilter struct {
+	bits   []uint64
+	hashes int
+}
+
+// newBloomFilter sizes a filter for n values at bloomFalsePositive
+func newBloomFilter(n int) *bloomFilter {
+	if n < 1 {
+		n = 1
+	}
+	m := math.Ceil(-float64(n) * math.Log(bloomFalsePositive) / (math.Ln2 * math.Ln2))
+	k := int(math.Round(m / float64(n) * math.Ln2))
+	return &bloomFilter{bits: make([]uint64, (int(m)+63)/64), hashes: max(k, 1)}
+}
+
+// bloomFilterFromBytes restores a filter persisted with Bytes. It needs at
+// least one hash and one word of bits, or positions would divide by zero.
+func bloomFilterFromBytes(b []byte) (*bloomFilter, error) {
+	if len(b) < 9 || (len(b)-1)%8 != 0 || b[0] == 0 {
+		return nil, errors.New("invalid bloom filter")
+	}
+	f := &bloomFilter{bits: make([]uint64, (len(b)-1)/8), hashes: int(b[0])}
+	for i := range f.bits {
+		f.bits[i] = binary.LittleEndian.Uint64(b[1+i*8:])
+	}
+	return f, nil
+}
+
+// Bytes is the hash count followed by the bits, little endian
+func (f *bloomFilter) Bytes() []byte {
+	b := make([]byte, 1, 1+len(f.bits)*8)
+	b[0] = byte(f.hashes)
+	for _, word := range f.bits {
+		b = binary.LittleEndian.AppendUint64(b, word)
+	}
+	return b
+}
+
+// positions derives the filter's bit positions for value by double hashing
+func (f *bloomFilter) positions(value string, fn func(bit uint64) bool) bool {
+	hasher := fnv.New64a()
+	hasher.Write([]byte(value))
+	h1 := mix64(hasher.Sum64())
+	h2 := mix64(h1) | 1
+	m := uint64(len(f.bits) * 64)
+	for i := 0; i < f.hashes; i++ {
+		if !fn((h1 + uint64(i)*h2) % m) {
+			return false
+		}
+	}
+	return true
+}
+
+func (f *bloomFilter) Add(value string) {
+	f.positions(value, func(bit uint64) bool {
+		f.bits[bit/64] |= 1 << (bit % 64)
+		return true
+	})
+}
+
+func (f *bloomFilter) MayContain(value string) bool {
+	return f.positions(value, func(bit uint64) bool {
+		return f.bits[bit/64]&(1<<(bit%64)) != 0
+	})
+}
@@ -0,0 +1,37 @@
+package logserver
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestBloomFilterBytes(t *testing.T) {
+	f := newBloomFilter(100)
+	for i := 0; i < 100; i++ {
+		f.Add(fmt.Sprintf("10.0.0.%d", i))
+	}
+	restored, err := bloomFilterFromBytes(f.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect(t, "restored", restored, f)
+	for i := 0; i < 100; i++ {
+		if !restored.MayContain(fmt.Sprintf("10.0.0.%d", i)) {
+			t.Fatalf("10.0.0.%d missing after a round trip", i)
+		}
+	}
+
+	// A filter without bits or hashes would divide by zero or match
+	// everything, so it is refused rather than restored
+	for name, b := range map[string][]byte{
+		"empty":           nil,
+		"no bits":         {7},
+		"no hashes":       {0, 1, 2, 3, 4, 5, 6, 7, 8},
+		"partial word":    {7, 1, 2, 3},
+		"word and a half": append([]byte{7, 1, 2, 3, 4, 5, 6, 7, 8}, 9, 10, 11),
+	} {
+		if _, err := bloomFilterFromBytes(b); err == nil {
+			t.Errorf("%s: restored", name)
+		}
+	}
+}
@@ -0,0 +1,503 @@
+package logserver
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Bulk job actions and statuses
+const (
+	bulkUpdate  = "update"
+	bulkDelete  = "delete"
+	bulkReparse = "reparse"
+
+	bulkRunning  = "running"
+	bulkDone     = "done"
+	bulkFailed   = "failed"
+	bulkCanceled = "canceled"
+)
+
+// bulkBatchSize is how many logs each bulk job transaction touches, so a
+// large job never holds the write lock for long
+const bulkBatchSize = 500
+
+// bulkPreviewSize is how many matching logs a dry run returns
+const bulkPreviewSize = 10
+
+// BulkChange is the re-classification applied by a bulk update; unset fields are left alone
+type BulkChange struct {
+	Category   string   `json:"category,omitempty"`
+	Urgency    int      `json:"urgency,omitempty"`
+	AddTags    []string `json:"addTags,omitempty"`
+	RemoveTags []string `json:"removeTags,omitempty"`
+}
+
+// BulkRequest is the body of POST /api/logs/bulk; the logs are selected by
+// the search parameters in the query string
+type BulkRequest struct {
+	Action string     `json:"action"`
+	Set    BulkChange `json:"set"`
+	DryRun bool       `json:"dryRun"`
+	// All must be set to act on every log when the query has no filters
+	All   bool   `json:"all"`
+	Actor string `json:"actor"`
+}
+
+// BulkJob tracks a bulk update, delete or reparse running in the background
+type BulkJob struct {
+	ID        string      `json:"id"`
+	Action    string      `json:"action"`
+	Query     string      `json:"query"`
+	Set       *BulkChange `json:"set,omitempty"`
+	Actor     string      `json:"actor,omitempty"`
+	Status    string      `json:"status"`
+	Matched   int         `json:"matched"`
+	Processed int         `json:"processed"`
+	// Skipped counts the processed logs a reparse left alone
+	Skipped    int        `json:"skipped,omitempty"`
+	Error      string     `json:"error,omitempty"`
+	StartedAt  time.Time  `json:"startedAt"`
+	FinishedAt *time.Time `json:"finishedAt,omitempty"`
+}
+
+// BulkPreview is the response to a dry run
+type BulkPreview struct {
+	Action  string      `json:"action"`
+	Query   string      `json:"query"`
+	Set     *BulkChange `json:"set,omitempty"`
+	Matched int         `json:"matched"`
+	Sample  []LogEntry  `json:"sample"`
+}
+
+func createBulkTables(db *sql.DB) error {
+	_, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS bulk_jobs (
+			id TEXT PRIMARY KEY,
+			action TEXT NOT NULL,
+			query TEXT NOT NULL,
+			change TEXT,
+			actor TEXT NOT NULL DEFAULT '',
+			status TEXT NOT NULL,
+			matched INTEGER NOT NULL,
+			processed INTEGER NOT NULL DEFAULT 0,
+			error TEXT NOT NULL DEFAULT '',
+			started_at DATETIME NOT NULL,
+			finished_at DATETIME
+		)
+	`)
+	if err != nil {
+		return err
+	}
+	return addColumnIfMissing(db, "bulk_jobs", "skipped", `INTEGER NOT NULL DEFAULT 0`)
+}
+
+// validate checks the request and normalizes its tags
+func (req *BulkRequest) validate(filter LogFilter, levels *levelConfig) error {
+	switch req.Action {
+	case bulkDelete, bulkReparse:
+	case bulkUpdate:
+		switch req.Set.Category {
+		case "", "access", "network", "threat", "uba":
+		default:
+			return errors.New("category must be one of access, network, threat, uba")
+		}
+		if req.Set.Urgency != 0 && !levels.knownUrgency(req.Set.Urgency) {
+			return errors.New("urgency must be one of " + levels.urgencyNames() + ", or 0 to leave it unchanged")
+		}
+		var err error
+		if req.Set.AddTags, err = normalizeTags(req.Set.AddTags); err != nil {
+			return err
+		}
+		if req.Set.RemoveTags, err = normalizeTags(req.Set.RemoveTags); err != nil {
+			return err
+		}
+		if req.Set.Category == "" && req.Set.Urgency == 0 && len(req.Set.AddTags) == 0 && len(req.Set.RemoveTags) == 0 {
+			return errors.New("set must change category, urgency or tags")
+		}
+	default:
+		return errors.New("action must be update, delete or reparse")
+	}
+	if len(filter.values()) == 0 && !req.All {
+		return errors.New("no filters given; set all to act on every log")
+	}
+	return nil
+}
+
+// PreviewBulk counts the logs a bulk request would touch and returns the newest of them
+func (d *Database) PreviewBulk(req BulkRequest, filter LogFilter) (BulkPreview, error) {
+	preview := BulkPreview{Action: req.Action, Query: filter.values().Encode()}
+	if req.Action == bulkUpdate {
+		preview.Set = &req.Set
+	}
+	var err error
+	if preview.Matched, err = d.CountLogs(filter); err != nil {
+		return preview, err
+	}
+	if preview.Sample, err = d.FilterLogs(filter, bulkPreviewSize); err != nil {
+		return preview, err
+	}
+	if preview.Sample == nil {
+		preview.Sample = []LogEntry{}
+	}
+	return preview, nil
+}
+
+// StartBulkJob records a job for req and runs it in the background. The job
+// covers the logs stored when it starts; logs ingested while it runs are
+// left alone.
+func (d *Database) StartBulkJob(req BulkRequest, filter LogFilter) (BulkJob, error) {
+	job := BulkJob{
+		ID:        randomHex(8),
+		Action:    req.Action,
+		Query:     filter.values().Encode(),
+		Actor:     req.Actor,
+		Status:    bulkRunning,
+		StartedAt: d.now().UTC(),
+	}
+	var change sql.NullString
+	if req.Action == bulkUpdate {
+		job.Set = &req.Set
+		b, _ := json.Marshal(req.Set)
+		change = sql.NullString{String: string(b), Valid: true}
+	}
+	var maxID int64
+	if err := d.db.QueryRow(`SELECT COALESCE(MAX(id), 0) FROM logs`).Scan(&maxID); err != nil {
+		return job, err
+	}
+	clause, args := filter.where()
+	err := d.db.QueryRow(`SELECT COUNT(*) FROM logs WHERE id <= ?`+clause, append([]interface{}{maxID}, args...)...).Scan(&job.Matched)
+	if err != nil {
+		return job, err
+	}
+	_, err = d.db.Exec(`
+		INSERT INTO bulk_jobs (id, action, query, change, actor, status, matched, started_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+	`, job.ID, job.Action, job.Query, change, job.Actor, job.Status, job.Matched, job.StartedAt)
+	if err != nil {
+		return job, err
+	}
+	go d.runBulkJob(job, filter, maxID, d.bulkCancels.start(job.ID))
+	return job, nil
+}
+
+// runBulkJob walks the matching logs in id order up to maxID, one
+// transaction per batch, recording progress after each batch. Walking by id
+// means logs an update moves out of the filter are not revisited. A
+// canceled job stops between batches; the batches already done stay done.
+func (d *Database) runBulkJob(job BulkJob, filter LogFilter, maxID int64, cancel chan struct{}) {
+	// Unique counts cannot forget a value, so the hours a delete touched
+	// are counted again from the logs left once the job ends
+	deletedHours := map[int64]bool{}
+	defer func() {
+		d.bulkCancels.finish(job.ID)
+		if err := d.recountUniques(deletedHours); err != nil {
+			log.Printf("bulk: job %s: failed to recount unique counts: %v", job.ID, err)
+		}
+	}()
+	clause, args := filter.where()
+	var lastID int64
+	for {
+		select {
+		case <-cancel:
+			d.finishBulkJob(job.ID, bulkCanceled, "")
+			return
+		case <-d.done:
+			d.finishBulkJob(job.ID, bulkFailed, "Interrupted by shutdown")
+			return
+		default:
+		}
+		ids, err := d.nextBulkBatch(clause, args, lastID, maxID)
+		if err == nil && len(ids) > 0 {
+			if job.Action == bulkReparse {
+				var skipped int
+				skipped, err = d.reparseBatch(ids)
+				job.Skipped += skipped
+			} else {
+				err = d.applyBulkBatch(job, ids, deletedHours)
+			}
+		}
+		if err != nil {
+			log.Printf("bulk: job %s failed: %v", job.ID, err)
+			d.finishBulkJob(job.ID, bulkFailed, err.Error())
+			return
+		}
+		if len(ids) == 0 {
+			d.finishBulkJob(job.ID, bulkDone, "")
+			return
+		}
+		lastID = ids[len(ids)-1]
+		job.Processed += len(ids)
+		if _, err := d.db.Exec(`UPDATE bulk_jobs SET processed = ?, skipped = ? WHERE id = ?`, job.Processed, job.Skipped, job.ID); err != nil {
+			log.Printf("bulk: job %s: failed to record progress: %v", job.ID, err)
+		}
+	}
+}
+
+// CancelBulkJob stops a running bulk job after its current batch
+func (d *Database) CancelBulkJob(id string) bool {
+	return d.bulkCancels.cancel(id)
+}
+
+// failInterruptedBulkJobs marks bulk jobs that were running when the server
+// stopped; they are not resumed
+func (d *Database) failInterruptedBulkJobs() error {
+	_, err := d.db.Exec(`UPDATE bulk_jobs SET status = ?, error = ?, finished_at = ? WHERE status = ?`,
+		bulkFailed, "Interrupted by a restart", d.now().UTC(), bulkRunning)
+	return err
+}
+
+func (d *Database) nextBulkBatch(clause string, args []interface{}, after, maxID int64) ([]int64, error) {
+	args = append([]interface{}{after, maxID}, args...)
+	rows, err := d.db.Query(`SELECT id FROM logs WHERE id > ? AND id <= ?`+clause+` ORDER BY id LIMIT ?`, append(args, bulkBatchSize)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// applyBulkBatch updates or deletes the logs in ids in a single transaction.
+// A delete adds the hours of the deleted logs to deletedHours.
+func (d *Database) applyBulkBatch(job BulkJob, ids []int64, deletedHours map[int64]bool) error {
+	in := `(?` + strings.Repeat(`, ?`, len(ids)-1) + `)`
+	idArgs := make([]interface{}, len(ids))
+	for i, id := range ids {
+		idArgs[i] = id
+	}
+
+	tx, err := d.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var stmts []string
+	var stmtArgs [][]interface{}
+	add := func(stmt string, args ...interface{}) {
+		stmts = append(stmts, stmt)
+		stmtArgs = append(stmtArgs, append(args, idArgs...))
+	}
+	if job.Action == bulkDelete {
+		add(`DELETE FROM log_tags WHERE log_id IN ` + in)
+		add(`DELETE FROM annotations WHERE log_id IN ` + in)
+		add(`DELETE FROM log_raw WHERE log_id IN ` + in)
+		add(`DELETE FROM logs WHERE id IN ` + in)
+	} else {
+		if job.Set.Category != "" {
+			add(`UPDATE logs SET category = ? WHERE id IN `+in, job.Set.Category)
+		}
+		if job.Set.Urgency != 0 {
+			add(`UPDATE logs SET urgency = ? WHERE id IN `+in, job.Set.Urgency)
+		}
+		for _, tag := range job.Set.AddTags {
+			add(`INSERT OR IGNORE INTO log_tags (log_id, tag) SELECT id, ? FROM logs WHERE id IN `+in, tag)
+		}
+		for _, tag := range job.Set.RemoveTags {
+			add(`DELETE FROM log_tags WHERE tag = ? AND log_id IN `+in, tag)
+		}
+	}
+	// The dashboard counters are moved off the logs' old values and onto
+	// their new ones once the batch commits
+	var before []LogEntry
+	if job.Action == bulkDelete || job.Set.Category != "" || job.Set.Urgency != 0 {
+		if before, err = countedLogs(tx, in, idArgs); err != nil {
+			return err
+		}
+	}
+	var deleted int64
+	for i, stmt := range stmts {
+		res, err := tx.Exec(stmt, stmtArgs[i]...)
+		if err != nil {
+			return err
+		}
+		if strings.HasPrefix(stmt, `DELETE FROM logs`) {
+			deleted, _ = res.RowsAffected()
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	d.rowCount.Add(-deleted)
+	for _, l := range before {
+		d.counters.add(l, -1)
+		if job.Action == bulkDelete {
+			d.topEvents.Remove(l.Event, 1)
+			d.topSources.Remove(l.SourceIP, 1)
+			d.topDestinations.Remove(l.DestinationIP, 1)
+			deletedHours[l.Timestamp.UTC().Truncate(time.Hour).Unix()] = true
+			continue
+		}
+		if job.Set.Category != "" {
+			l.Category = job.Set.Category
+		}
+		if job.Set.Urgency != 0 {
+			l.Urgency = job.Set.Urgency
+		}
+		d.counters.add(l, 1)
+	}
+	d.logsChanged()
+	return nil
+}
+
+// countedLogs reads the fields of the logs in ids that the dashboard counters
+// and top-k trackers use
+func countedLogs(tx *sql.Tx, in string, ids []interface{}) ([]LogEntry, error) {
+	rows, err := tx.Query(`SELECT id, timestamp, level, rule, category, urgency, event, source_ip, destination_ip FROM logs WHERE id IN `+in, ids...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var logs []LogEntry
+	for rows.Next() {
+		var l LogEntry
+		if err := rows.Scan(&l.ID, &l.Timestamp, &l.Level, &l.Rule, &l.Category, &l.Urgency, &l.Event, &l.SourceIP, &l.DestinationIP); err != nil {
+			return nil, err
+		}
+		logs = append(logs, l)
+	}
+	return logs, rows.Err()
+}
+
+func (d *Database) finishBulkJob(id, status, message string) {
+	_, err := d.db.Exec(`UPDATE bulk_jobs SET status = ?, error = ?, finished_at = ? WHERE id = ?`,
+		status, message, d.now().UTC(), id)
+	if err != nil {
+		log.Printf("bulk: job %s: failed to record %s: %v", id, status, err)
+	}
+}
+
+const bulkJobColumns = `id, action, query, change, actor, status, matched, processed, skipped, error, started_at, finished_at`
+
+func scanBulkJob(scan func(...interface{}) error) (BulkJob, error) {
+	var job BulkJob
+	var change sql.NullString
+	var finished sql.NullTime
+	err := scan(&job.ID, &job.Action, &job.Query, &change, &job.Actor, &job.Status, &job.Matched, &job.Processed,
+		&job.Skipped, &job.Error, &job.StartedAt, &finished)
+	if err != nil {
+		return job, err
+	}
+	if change.Valid {
+		job.Set = &BulkChange{}
+		if err := json.Unmarshal([]byte(change.String), job.Set); err != nil {
+			return job, err
+		}
+	}
+	if finished.Valid {
+		job.FinishedAt = &finished.Time
+	}
+	return job, nil
+}
+
+func (d *Database) GetBulkJob(id string) (BulkJob, error) {
+	return scanBulkJob(d.db.QueryRow(`SELECT `+bulkJobColumns+` FROM bulk_jobs WHERE id = ?`, id).Scan)
+}
+
+func (d *Database) GetBulkJobs(limit int) ([]BulkJob, error) {
+	rows, err := d.db.Query(`SELECT `+bulkJobColumns+` FROM bulk_jobs ORDER BY started_at DESC LIMIT ?`, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	jobs := []BulkJob{}
+	for rows.Next() {
+		job, err := scanBulkJob(rows.Scan)
+		if err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, job)
+	}
+	return jobs, rows.Err()
+}
+
+// GET/POST /api/logs/bulk?<search filters> and GET/DELETE /api/logs/bulk/{id} -
+// bulk update, delete or reparse logs matching a search (admin only). A
+// POST with an NDJSON body ingests logs instead; see bulkIngestHandlerDB.
+func bulkHandlerDB(w http.ResponseWriter, r *http.Request, db *Database) {
+	if isNDJSONIngest(r) {
+		bulkIngestHandlerDB(w, r, db)
+		return
+	}
+	enableCORS(w)
+	w.Header().Set("Content-Type", "application/json")
+	if !db.requireAdmin(w, r) {
+		return
+	}
+	id := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/logs/bulk"), "/")
+	switch {
+	case id != "" && r.Method == http.MethodGet:
+		job, err := db.GetBulkJob(id)
+		if errors.Is(err, sql.ErrNoRows) {
+			writeJSONError(w, http.StatusNotFound, "Bulk job not found")
+			return
+		}
+		if err != nil {
+			writeJSONError(w, http.StatusInternalServerError, "Failed to fetch bulk job")
+			return
+		}
+		json.NewEncoder(w).Encode(job)
+	case id != "" && r.Method == http.MethodDelete:
+		if !db.CancelBulkJob(id) {
+			writeJSONError(w, http.StatusNotFound, "No running bulk job "+id)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	case id == "" && r.Method == http.MethodGet:
+		jobs, err := db.GetBulkJobs(100)
+		if err != nil {
+			writeJSONError(w, http.StatusInternalServerError, "Failed to fetch bulk jobs")
+			return
+		}
+		json.NewEncoder(w).Encode(jobs)
+	case id == "" && r.Method == http.MethodPost:
+		filter, err := parseLogFilter(r.URL.Query(), db.now())
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		var req BulkRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeJSONError(w, http.StatusBadRequest, "Invalid JSON")
+			return
+		}
+		if err := req.validate(filter, &db.levels); err != nil {
+			writeJSONError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if req.Actor == "" {
+			req.Actor = r.Header.Get("X-Actor")
+		}
+		if req.DryRun {
+			preview, err := db.PreviewBulk(req, filter)
+			if err != nil {
+				writeJSONError(w, http.StatusInternalServerError, "Failed to preview bulk job")
+				return
+			}
+			json.NewEncoder(w).Encode(preview)
+			return
+		}
+		job, err := db.StartBulkJob(req, filter)
+		if err != nil {
+			writeJSONError(w, http.StatusInternalServerError, "Failed to start bulk job")
+			return
+		}
+		w.Header().Set("Location", "/api/logs/bulk/"+url.PathEscape(job.ID))
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(job)
+	default:
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+	}
+}
@@ -0,0 +1,103 @@
+package logserver
+
+import (
+	"log"
+	"sync"
+	"sync/atomic"
+)
+
+// topicLogs carries every ingested log as an *IngestedLog. Subscribers
+// must not modify it, except for the store, which sets the entry's ID.
+const topicLogs = "logs"
+
+// Bus is the in-process publish/subscribe layer that ingest fans out
+// through. Synchronous subscribers run in the publisher's goroutine in the
+// order they subscribed; the first error stops delivery and is returned to
+// the publisher, so they are for steps ingest must not acknowledge without,
+// such as the store write. Asynchronous subscribers get the message
+// afterwards on their own buffered queue and goroutine; when a queue is
+// full the message is dropped for that subscriber, so a slow consumer
+// never holds up ingest.
+type Bus struct {
+	mu    sync.RWMutex
+	sync  map[string][]syncSubscriber
+	async map[string][]*asyncSubscriber
+}
+
+type syncSubscriber struct {
+	name   string
+	handle func(msg interface{}) error
+}
+
+type asyncSubscriber struct {
+	name    string
+	queue   chan interface{}
+	dropped atomic.Int64
+}
+
+func NewBus() *Bus {
+	return &Bus{
+		sync:  make(map[string][]syncSubscriber),
+		async: make(map[string][]*asyncSubscriber),
+	}
+}
+
+// Subscribe adds a synchronous subscriber to topic
+func (b *Bus) Subscribe(topic, name string, handle func(msg interface{}) error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.sync[topic] = append(b.sync[topic], syncSubscriber{name, handle})
+}
+
+// SubscribeAsync adds an asynchronous subscriber to topic with a queue of
+// buffer messages; the returned function unsubscribes it
+func (b *Bus) SubscribeAsync(topic, name string, buffer int, handle func(msg interface{})) (unsubscribe func()) {
+	sub := &asyncSubscriber{name: name, queue: make(chan interface{}, buffer)}
+	b.mu.Lock()
+	b.async[topic] = append(b.async[topic], sub)
+	b.mu.Unlock()
+	go func() {
+		for msg := range sub.queue {
+			handle(msg)
+		}
+	}()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			b.mu.Lock()
+			defer b.mu.Unlock()
+			subs := b.async[topic]
+			for i, s := range subs {
+				if s == sub {
+					b.async[topic] = append(subs[:i:i], subs[i+1:]...)
+					break
+				}
+			}
+			close(sub.queue)
+		})
+	}
+}
+
+// Publish delivers msg to the synchronous subscribers of topic, then
+// queues it for the asynchronous ones if they all succeeded
+func (b *Bus) Publish(topic string, msg interface{}) error {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	for _, sub := range b.sync[topic] {
+		if err := sub.handle(msg); err != nil {
+			return err
+		}
+	}
+	for _, sub := range b.async[topic] {
+		select {
+		case sub.queue <- msg:
+		default:
+			// Log the first drop and every thousandth after it
+			if n := sub.dropped.Add(1); n%1000 == 1 {
+				log.Printf("bus: %s subscriber %s is behind, dropped %d messages", topic, sub.name, n)
+			}
+		}
+	}
+	return nil
+}
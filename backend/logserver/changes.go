@@ -0,0 +1,229 @@
+package logserver
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Change is one mutation of a notable or an alert, carrying the object as
+// it was right after the mutation. Changes are numbered in the order they
+// happened, so a mirror can apply them in Seq order and resume after the
+// last one it applied.
+type Change struct {
+	Seq    int64           `json:"seq"`
+	Kind   string          `json:"kind"`
+	ID     string          `json:"id"`
+	Action string          `json:"action"`
+	At     time.Time       `json:"at"`
+	Data   json.RawMessage `json:"data"`
+}
+
+// Change kinds and actions
+const (
+	changeNotable = "notable"
+	changeAlert   = "alert"
+
+	changeCreated      = "created"
+	changeAssigned     = "assigned"
+	changeResolved     = "resolved"
+	changeTagged       = "tagged"
+	changeUntagged     = "untagged"
+	changeTicketLinked = "ticket_linked"
+	changeRescored     = "rescored"
+	changeFired        = "fired"
+	changeAcknowledged = "acknowledged"
+	changeSnoozed      = "snoozed"
+)
+
+// changesRetention is how long changes are kept; a mirror further behind
+// than that has to resynchronise from the list endpoints
+var changesRetention = envDuration("CHANGES_RETENTION", 7*24*time.Hour)
+
+const changesPruneInterval = time.Hour
+
+func createChangeTables(db *sql.DB) error {
+	for _, stmt := range []string{
+		`CREATE TABLE IF NOT EXISTS changes (
+			seq INTEGER PRIMARY KEY AUTOINCREMENT,
+			kind TEXT NOT NULL,
+			object_id TEXT NOT NULL,
+			action TEXT NOT NULL,
+			at DATETIME NOT NULL,
+			data TEXT NOT NULL
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_changes_at ON changes(at)`,
+	} {
+		if _, err := db.Exec(stmt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// recordChange appends a change to the feed. Like webhook delivery it never
+// fails the mutation itself; errors are logged.
+func (d *Database) recordChange(kind, id, action string, data interface{}) {
+	raw, err := json.Marshal(data)
+	if err == nil {
+		_, err = d.db.Exec(`INSERT INTO changes (kind, object_id, action, at, data) VALUES (?, ?, ?, ?, ?)`,
+			kind, id, action, d.now().UTC(), string(raw))
+	}
+	if err != nil {
+		log.Printf("changes: failed to record %s %s %s: %v", kind, id, action, err)
+		return
+	}
+	d.newChanges.notify()
+}
+
+// recordNotableChange records a change with the notable's current state
+func (d *Database) recordNotableChange(id int64, action string) {
+	n, err := d.GetNotable(id)
+	if err != nil {
+		log.Printf("changes: failed to load notable %d: %v", id, err)
+		return
+	}
+	d.recordChange(changeNotable, n.ID, action, n)
+}
+
+// recordAlertChange records a change with the alert instance's current state
+func (d *Database) recordAlertChange(id int64, action string) {
+	instance, err := d.GetAlertInstance(id)
+	if err != nil {
+		log.Printf("changes: failed to load alert %d: %v", id, err)
+		return
+	}
+	d.recordChange(changeAlert, strconv.FormatInt(id, 10), action, instance)
+}
+
+// GetChanges returns up to limit changes after seq, oldest first; kind
+// narrows them to notables or alerts
+func (d *Database) GetChanges(after int64, kind string, limit int) ([]Change, error) {
+	query := `SELECT seq, kind, object_id, action, at, data FROM changes WHERE seq > ?`
+	args := []interface{}{after}
+	if kind != "" {
+		query += ` AND kind = ?`
+		args = append(args, kind)
+	}
+	rows, err := d.db.Query(query+` ORDER BY seq LIMIT ?`, append(args, limit)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	changes := []Change{}
+	for rows.Next() {
+		var c Change
+		var data string
+		if err := rows.Scan(&c.Seq, &c.Kind, &c.ID, &c.Action, &c.At, &data); err != nil {
+			return nil, err
+		}
+		c.Data = json.RawMessage(data)
+		changes = append(changes, c)
+	}
+	return changes, rows.Err()
+}
+
+// changesBounds returns the lowest retained and the highest seq, 0 when empty
+func (d *Database) changesBounds() (oldest, latest int64, err error) {
+	err = d.db.QueryRow(`SELECT COALESCE(MIN(seq), 0), COALESCE(MAX(seq), 0) FROM changes`).Scan(&oldest, &latest)
+	return oldest, latest, err
+}
+
+func (d *Database) pruneChangesLoop() {
+	ticker := time.NewTicker(changesPruneInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			cutoff := d.now().Add(-changesRetention).UTC()
+			if _, err := d.db.Exec(`DELETE FROM changes WHERE at < ?`, cutoff); err != nil {
+				log.Printf("changes: prune failed: %v", err)
+			}
+		case <-d.done:
+			return
+		}
+	}
+}
+
+// GET /api/changes?since=&kind=&limit=&wait= - the notable and alert change
+// feed after seq since. The response's next is the since of the next request.
+func changesHandlerDB(w http.ResponseWriter, r *http.Request, db *Database) {
+	enableCORS(w)
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if r.Method != http.MethodGet {
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	q := r.URL.Query()
+	var since int64
+	var err error
+	if v := q.Get("since"); v != "" {
+		if since, err = strconv.ParseInt(v, 10, 64); err != nil || since < 0 {
+			writeJSONError(w, http.StatusBadRequest, "Invalid since")
+			return
+		}
+	}
+	kind := q.Get("kind")
+	if kind != "" && kind != changeNotable && kind != changeAlert {
+		writeJSONError(w, http.StatusBadRequest, "kind must be notable or alert")
+		return
+	}
+	limit, err := parsePollLimit(q.Get("limit"))
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	// Fetching does not block by default; wait= turns it into a long poll
+	var wait time.Duration
+	if q.Get("wait") != "" {
+		if wait, err = parsePollWait(q.Get("wait")); err != nil {
+			writeJSONError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+
+	timeout := time.NewTimer(wait)
+	defer timeout.Stop()
+	for {
+		recorded := db.newChanges.wait()
+		oldest, latest, err := db.changesBounds()
+		if err != nil {
+			writeJSONError(w, http.StatusInternalServerError, "Failed to fetch changes")
+			return
+		}
+		// Changes after since were pruned: the mirror must start over
+		if since > 0 && since < oldest-1 {
+			writeJSONError(w, http.StatusGone, "Changes after since are no longer retained; resynchronise and start from since=0")
+			return
+		}
+		changes, err := db.GetChanges(since, kind, limit)
+		if err != nil {
+			writeJSONError(w, http.StatusInternalServerError, "Failed to fetch changes")
+			return
+		}
+		// Without a kind filter next is the last change returned; with one,
+		// it may skip ahead past changes of the other kind
+		next := since
+		if len(changes) > 0 {
+			next = changes[len(changes)-1].Seq
+		} else if kind != "" && latest > since {
+			next = latest
+		}
+		if len(changes) > 0 || wait == 0 {
+			json.NewEncoder(w).Encode(map[string]interface{}{"changes": changes, "next": next})
+			return
+		}
+		select {
+		case <-recorded:
+		case <-timeout.C:
+			json.NewEncoder(w).Encode(map[string]interface{}{"changes": changes, "next": next})
+			return
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
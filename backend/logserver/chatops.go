@@ -0,0 +1,303 @@
+package logserver
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ChatOps. A chat command such as "/logs errors since 1h service=checkout"
+// runs a search and answers with a short summary and a link to the full
+// results. Slack slash commands post to /api/chatops/slack; any other bot
+// posts {"text": "..."} to /api/chatops.
+
+var (
+	slackSigningSecret = envOr("SLACK_SIGNING_SECRET", "")
+	chatOpsToken       = envOr("CHATOPS_TOKEN", "")
+	// publicURL makes the links in chat replies absolute
+	publicURL = strings.TrimRight(envOr("PUBLIC_URL", ""), "/")
+)
+
+const (
+	// slackTolerance is how old a Slack request timestamp may be
+	slackTolerance = 5 * time.Minute
+	// chatDefaultSince is the range of a search that names none
+	chatDefaultSince = "24h"
+	// chatSampleSize is how many of the newest matching logs a reply lists
+	chatSampleSize = 3
+	chatMaxBytes   = 64 << 10
+)
+
+var errInvalidChatCommand = errors.New("invalid command")
+
+const chatHelp = "Usage: /logs [overview | help | <search>]\n" +
+	"A search is any of: a level such as `errors`, `since 1h`, a range such as `today`, " +
+	"`key=value` search filters (other keys match the tag key=value), and words to find in the event. " +
+	"Searches cover the last " + chatDefaultSince + " unless they say otherwise."
+
+// ChatReply is the answer to a chat command
+type ChatReply struct {
+	Text     string      `json:"text"`
+	Query    string      `json:"query,omitempty"`
+	Count    int         `json:"count"`
+	TopRules []RuleCount `json:"topRules,omitempty"`
+	Logs     []LogEntry  `json:"logs,omitempty"`
+	Search   string      `json:"search,omitempty"`
+}
+
+// chatLink formats a link for Slack's mrkdwn or as plain text
+type chatLink func(url, label string) string
+
+func slackLink(url, label string) string { return "<" + url + "|" + label + ">" }
+
+func plainLink(url, label string) string { return label + ": " + url }
+
+// parseChatSearch turns the words of a search command into search
+// parameters, recognizing the names of levels
+func parseChatSearch(words []string, levels *levelConfig) url.Values {
+	q := url.Values{}
+	var event []string
+	for i := 0; i < len(words); i++ {
+		word := words[i]
+		lower := strings.ToLower(word)
+		// key=value terms set search filters, or match the tag "key=value"
+		if key, value, ok := strings.Cut(word, "="); ok && key != "" && value != "" {
+			if searchParamKeys[strings.ToLower(key)] {
+				q.Add(strings.ToLower(key), value)
+			} else {
+				q.Add("tag", word)
+			}
+			continue
+		}
+		if (lower == "since" || lower == "last") && i+1 < len(words) {
+			q.Set("since", words[i+1])
+			i++
+			continue
+		}
+		if _, ok := relativeRanges[lower]; ok {
+			q.Set("range", lower)
+			continue
+		}
+		if level, ok := levels.levelWord(word); ok {
+			q.Add("level", level)
+			continue
+		}
+		event = append(event, word)
+	}
+	if len(event) > 0 {
+		q.Set("event", strings.Join(event, " "))
+	}
+	if q.Get("since") == "" && q.Get("range") == "" {
+		q.Set("since", chatDefaultSince)
+	}
+	return q
+}
+
+// chatTopRules counts the logs matching filter per rule, busiest first
+func (d *Database) chatTopRules(filter LogFilter, limit int) ([]RuleCount, error) {
+	clause, args := filter.where()
+	rows, err := d.db.Query(`SELECT rule, COUNT(*) AS n FROM logs WHERE 1=1`+clause+` GROUP BY rule ORDER BY n DESC, rule LIMIT ?`,
+		append(args, limit)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var counts []RuleCount
+	for rows.Next() {
+		var c RuleCount
+		if err := rows.Scan(&c.Rule, &c.Count); err != nil {
+			return nil, err
+		}
+		counts = append(counts, c)
+	}
+	return counts, rows.Err()
+}
+
+// chatSearch runs a search command within the request's data scope and
+// summarizes what it found, with the request's masks applied
+func (d *Database) chatSearch(r *http.Request, words []string, link chatLink) (ChatReply, error) {
+	q := parseChatSearch(words, &d.levels)
+	filter, err := parseLogFilter(q, d.now())
+	if err != nil {
+		return ChatReply{}, fmt.Errorf("%w: %v", errInvalidChatCommand, err)
+	}
+	filter = scopedFilter(r, filter)
+	masks := requestMasks(r)
+	if param, ok := masks.revealingParam(q); ok {
+		return ChatReply{}, fmt.Errorf("%w: searching by %s would reveal a masked field", errInvalidChatCommand, param)
+	}
+	reply := ChatReply{Query: q.Encode(), Search: publicURL + "/api/logs?" + filter.values().Encode()}
+	if reply.Count, err = d.CountLogs(filter); err != nil {
+		return reply, err
+	}
+	if reply.TopRules, err = d.chatTopRules(filter, 3); err != nil {
+		return reply, err
+	}
+	if reply.Logs, err = d.FilterLogs(filter, chatSampleSize); err != nil {
+		return reply, err
+	}
+	masks.apply(reply.Logs)
+
+	var b strings.Builder
+	noun := "logs"
+	if reply.Count == 1 {
+		noun = "log"
+	}
+	fmt.Fprintf(&b, "*%d %s* match `%s`\n", reply.Count, noun, strings.Join(words, " "))
+	if len(reply.TopRules) > 0 {
+		rules := make([]string, len(reply.TopRules))
+		for i, c := range reply.TopRules {
+			rules[i] = fmt.Sprintf("%s (%d)", c.Rule, c.Count)
+		}
+		b.WriteString("Top rules: " + strings.Join(rules, ", ") + "\n")
+	}
+	for _, l := range reply.Logs {
+		description := l.Description
+		if runes := []rune(description); len(runes) > 80 {
+			description = string(runes[:77]) + "..."
+		}
+		fmt.Fprintf(&b, "• %s %s %s %s %s\n", l.Timestamp.UTC().Format("Jan 2 15:04:05"), l.Level, l.Rule, l.SourceIP, description)
+	}
+	b.WriteString(link(reply.Search, "Open the full results"))
+	reply.Text = b.String()
+	return reply, nil
+}
+
+// chatOverview formats /api/overview for chat
+func (d *Database) chatOverview() (ChatReply, error) {
+	overview, err := d.GetOverview()
+	if err != nil {
+		return ChatReply{}, err
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "*%d open alerts*, %.1f logs/min over the last %d minutes\n", overview.OpenAlerts, overview.LogsPerMinute, overviewRateMinutes)
+	notables := make([]string, 0, len(counterCategories))
+	for _, category := range counterCategories {
+		notables = append(notables, fmt.Sprintf("%s %d", category, overview.Notables[category]))
+	}
+	b.WriteString("Notables: " + strings.Join(notables, ", "))
+	for i, c := range overview.TopRules {
+		fmt.Fprintf(&b, "\n%d. %s (%d)", i+1, c.Rule, c.Count)
+	}
+	return ChatReply{Text: b.String(), TopRules: overview.TopRules}, nil
+}
+
+// runChatCommand runs the text of a chat command, with or without a
+// leading "/logs" or "logs". The overview reads unscoped counters, so it is
+// not available to a scoped request.
+func (d *Database) runChatCommand(r *http.Request, text string, link chatLink) (ChatReply, error) {
+	words := strings.Fields(text)
+	if len(words) > 0 && strings.EqualFold(strings.TrimPrefix(words[0], "/"), "logs") {
+		words = words[1:]
+	}
+	if len(words) == 0 {
+		return ChatReply{Text: chatHelp}, nil
+	}
+	switch strings.ToLower(words[0]) {
+	case "help":
+		return ChatReply{Text: chatHelp}, nil
+	case "overview", "status":
+		if _, scoped := requestScope(r); scoped {
+			return ChatReply{}, fmt.Errorf("%w: the overview is not available to a scoped view", errInvalidChatCommand)
+		}
+		return d.chatOverview()
+	}
+	return d.chatSearch(r, words, link)
+}
+
+// verifySlackRequest checks Slack's v0 request signature
+func verifySlackRequest(r *http.Request, body []byte, now time.Time) error {
+	timestamp := r.Header.Get("X-Slack-Request-Timestamp")
+	unix, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return errBadSignature
+	}
+	if age := now.Sub(time.Unix(unix, 0)); age > slackTolerance || age < -slackTolerance {
+		return errors.New("signature timestamp is outside the tolerance")
+	}
+	sig := r.Header.Get("X-Slack-Signature")
+	want := signPayload(slackSigningSecret, append([]byte("v0:"+timestamp+":"), body...))
+	if strings.HasPrefix(sig, "v0=") && hexEqual(sig[len("v0="):], want) {
+		return nil
+	}
+	return errBadSignature
+}
+
+// POST /api/chatops/slack - Slack slash command. Errors in the command are
+// answered with 200 and a message only the caller sees, as Slack expects.
+func chatOpsSlackHandlerDB(w http.ResponseWriter, r *http.Request, db *Database) {
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != http.MethodPost {
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	body, err := io.ReadAll(io.LimitReader(r.Body, chatMaxBytes))
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, "Failed to read body")
+		return
+	}
+	if slackSigningSecret != "" {
+		if err := verifySlackRequest(r, body, time.Now()); err != nil {
+			writeJSONError(w, http.StatusUnauthorized, err.Error())
+			return
+		}
+	}
+	form, err := url.ParseQuery(string(body))
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, "Invalid form body")
+		return
+	}
+	reply, err := db.runChatCommand(r, form.Get("text"), slackLink)
+	if errors.Is(err, errInvalidChatCommand) {
+		reply = ChatReply{Text: err.Error() + "\n" + chatHelp}
+	} else if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "Failed to run command")
+		return
+	}
+	// Ephemeral replies are shown to the invoker only, not the channel
+	json.NewEncoder(w).Encode(map[string]string{"response_type": "ephemeral", "text": reply.Text})
+}
+
+// POST /api/chatops {"text": "/logs errors since 1h"} - generic chat bots
+func chatOpsHandlerDB(w http.ResponseWriter, r *http.Request, db *Database) {
+	enableCORS(w)
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method == http.MethodOptions {
+		return
+	}
+	if r.Method != http.MethodPost {
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	if chatOpsToken != "" {
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(token), []byte(chatOpsToken)) != 1 {
+			writeJSONError(w, http.StatusUnauthorized, "Invalid chat token")
+			return
+		}
+	}
+	var req struct {
+		Text string `json:"text"`
+	}
+	if err := json.NewDecoder(io.LimitReader(r.Body, chatMaxBytes)).Decode(&req); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "Invalid JSON")
+		return
+	}
+	reply, err := db.runChatCommand(r, req.Text, plainLink)
+	if errors.Is(err, errInvalidChatCommand) {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "Failed to run command")
+		return
+	}
+	json.NewEncoder(w).Encode(reply)
+}
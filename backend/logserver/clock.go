@@ -0,0 +1,104 @@
+package logserver
+
+import (
+	"sync"
+	"time"
+)
+
+// Clocks. Anything that stamps a record or works out a time window, such as
+// "the last 24 hours", "today's quota" or "is this scope still active",
+// asks the database's Clock for the time instead of calling time.Now, and
+// SQL compares against a bound time rather than datetime('now'). Tests can
+// then set the time with a ManualClock and step a replay through its waits
+// without sleeping. Durations that measure the server itself, such as
+// request latency, uptime and cache ages, stay on the system clock, as do
+// signature checks against other systems' clocks.
+
+// Clock tells the time and waits for it
+type Clock interface {
+	Now() time.Time
+	// After sends the time on the channel once d has passed on this clock
+	After(d time.Duration) <-chan time.Time
+}
+
+// systemClock is the real time
+type systemClock struct{}
+
+func (systemClock) Now() time.Time                         { return time.Now() }
+func (systemClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+
+// ManualClock only moves when Set or Advance move it; waits that come due
+// on the way fire then
+type ManualClock struct {
+	mu      sync.Mutex
+	now     time.Time
+	waiters []manualWaiter
+}
+
+type manualWaiter struct {
+	at time.Time
+	c  chan time.Time
+}
+
+// NewManualClock returns a ManualClock stopped at now
+func NewManualClock(now time.Time) *ManualClock {
+	return &ManualClock{now: now}
+}
+
+func (c *ManualClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *ManualClock) After(d time.Duration) <-chan time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	ch := make(chan time.Time, 1)
+	if d <= 0 {
+		ch <- c.now
+		return ch
+	}
+	c.waiters = append(c.waiters, manualWaiter{at: c.now.Add(d), c: ch})
+	return ch
+}
+
+// Waiting is the number of After waits that have not come due, so a caller
+// can tell a goroutine has started waiting before moving the clock
+func (c *ManualClock) Waiting() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.waiters)
+}
+
+// Advance moves the clock forward by d
+func (c *ManualClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.set(c.now.Add(d))
+}
+
+// Set moves the clock to now, which may be earlier
+func (c *ManualClock) Set(now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.set(now)
+}
+
+func (c *ManualClock) set(now time.Time) {
+	c.now = now
+	waiting := c.waiters[:0]
+	for _, w := range c.waiters {
+		if w.at.After(now) {
+			waiting = append(waiting, w)
+			continue
+		}
+		w.c <- now
+	}
+	c.waiters = waiting
+}
+
+// now is the time on the database's clock
+func (d *Database) now() time.Time {
+	return d.clock.Now()
+}
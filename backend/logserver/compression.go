@@ -0,0 +1,247 @@
+package logserver
+
+import (
+	"database/sql"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/klauspost/compress/dict"
+	"github.com/klauspost/compress/zstd"
+)
+
+// Description compression. Descriptions are most of a verbose log's size,
+// so once a day of logs is COMPRESS_DESCRIPTIONS_AFTER old (default 24h) a
+// background job trains a zstd dictionary on that day's descriptions and
+// moves them, compressed with it, into description_z. Every other column,
+// and so every index, is left as it is. scanLogs decompresses on read.
+
+var (
+	compressDescriptions      = envOr("COMPRESS_DESCRIPTIONS", "true") == "true"
+	compressDescriptionsAfter = envDuration("COMPRESS_DESCRIPTIONS_AFTER", 24*time.Hour)
+)
+
+const (
+	compressInterval = 10 * time.Minute
+	compressBatch    = 500
+	// A day needs minDictionarySamples descriptions to train a useful
+	// dictionary; training reads at most dictionarySamples of them
+	minDictionarySamples = 100
+	dictionarySamples    = 2000
+	maxDictionarySize    = 64 << 10
+	// dictionaryIDBase keeps dictionary IDs, base plus the Unix day, out of
+	// the range zstd reserves for registered dictionaries
+	dictionaryIDBase = 1 << 20
+)
+
+// descriptionCodec decodes description_z with the dictionaries seen so far
+type descriptionCodec struct {
+	mu      sync.RWMutex
+	dicts   [][]byte
+	decoder *zstd.Decoder
+}
+
+// add registers dictionaries; zstd decoders take theirs when created, so
+// the decoder is replaced
+func (c *descriptionCodec) add(dicts ...[]byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	all := append(append([][]byte{}, c.dicts...), dicts...)
+	decoder, err := zstd.NewReader(nil, zstd.WithDecoderDicts(all...), zstd.WithDecoderConcurrency(0))
+	if err != nil {
+		return err
+	}
+	if c.decoder != nil {
+		c.decoder.Close()
+	}
+	c.dicts, c.decoder = all, decoder
+	return nil
+}
+
+func (c *descriptionCodec) decode(z []byte) (string, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if c.decoder == nil {
+		return "", zstd.ErrUnknownDictionary
+	}
+	b, err := c.decoder.DecodeAll(z, nil)
+	return string(b), err
+}
+
+func createCompressionTables(db *sql.DB) error {
+	if err := addColumnIfMissing(db, "logs", "description_z", `BLOB`); err != nil {
+		return err
+	}
+	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS log_dictionaries (
+		day TEXT PRIMARY KEY,
+		dict BLOB NOT NULL,
+		created_at DATETIME NOT NULL
+	)`)
+	return err
+}
+
+// loadDictionaries registers every stored dictionary for decoding
+func (d *Database) loadDictionaries() error {
+	rows, err := d.db.Query(`SELECT dict FROM log_dictionaries`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	var dicts [][]byte
+	for rows.Next() {
+		var b []byte
+		if err := rows.Scan(&b); err != nil {
+			return err
+		}
+		dicts = append(dicts, b)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	return d.descriptionDicts.add(dicts...)
+}
+
+func (d *Database) compressDescriptionsLoop() {
+	if !compressDescriptions {
+		return
+	}
+	ticker := time.NewTicker(compressInterval)
+	defer ticker.Stop()
+	for {
+		d.compressOldDescriptions()
+		select {
+		case <-ticker.C:
+		case <-d.done:
+			return
+		}
+	}
+}
+
+// compressOldDescriptions compresses the plain descriptions of every day
+// wholly older than compressDescriptionsAfter
+func (d *Database) compressOldDescriptions() {
+	cutoff := d.now().Add(-compressDescriptionsAfter).UTC().Truncate(24 * time.Hour)
+	rows, err := d.db.Query(`SELECT DISTINCT date(timestamp) FROM logs
+		WHERE timestamp < ? AND description_z IS NULL AND description != ''`, cutoff)
+	if err != nil {
+		log.Printf("compression: %v", err)
+		return
+	}
+	var days []string
+	for rows.Next() {
+		var day string
+		if err := rows.Scan(&day); err != nil {
+			rows.Close()
+			log.Printf("compression: %v", err)
+			return
+		}
+		days = append(days, day)
+	}
+	rows.Close()
+	for _, day := range days {
+		if err := d.compressDay(day); err != nil {
+			log.Printf("compression: %s: %v", day, err)
+		}
+	}
+}
+
+// compressDay compresses a day's plain descriptions with its dictionary,
+// training one first if needed. Days with too few logs to train on are left
+// plain.
+func (d *Database) compressDay(day string) error {
+	start, err := time.Parse(time.DateOnly, day)
+	if err != nil {
+		return err
+	}
+	end := start.AddDate(0, 0, 1)
+	dictionary, err := d.dayDictionary(day, start, end)
+	if dictionary == nil || err != nil {
+		return err
+	}
+	encoder, err := zstd.NewWriter(nil, zstd.WithEncoderDict(dictionary), zstd.WithEncoderConcurrency(1))
+	if err != nil {
+		return err
+	}
+	defer encoder.Close()
+	for {
+		rows, err := d.db.Query(`SELECT id, description FROM logs
+			WHERE timestamp >= ? AND timestamp < ? AND description_z IS NULL AND description != '' LIMIT ?`,
+			start, end, compressBatch)
+		if err != nil {
+			return err
+		}
+		var ids []int64
+		var compressed [][]byte
+		for rows.Next() {
+			var id int64
+			var description string
+			if err := rows.Scan(&id, &description); err != nil {
+				rows.Close()
+				return err
+			}
+			ids = append(ids, id)
+			compressed = append(compressed, encoder.EncodeAll([]byte(description), nil))
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil || len(ids) == 0 {
+			return err
+		}
+		tx, err := d.db.Begin()
+		if err != nil {
+			return err
+		}
+		for i, id := range ids {
+			if _, err := tx.Exec(`UPDATE logs SET description = '', description_z = ? WHERE id = ?`, compressed[i], id); err != nil {
+				tx.Rollback()
+				return err
+			}
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+	}
+}
+
+// dayDictionary returns the day's dictionary, training and storing it if
+// there is none yet. It returns nil if the day has too few descriptions.
+func (d *Database) dayDictionary(day string, start, end time.Time) ([]byte, error) {
+	var dictionary []byte
+	err := d.db.QueryRow(`SELECT dict FROM log_dictionaries WHERE day = ?`, day).Scan(&dictionary)
+	if err != sql.ErrNoRows {
+		return dictionary, err
+	}
+	rows, err := d.db.Query(`SELECT description FROM logs
+		WHERE timestamp >= ? AND timestamp < ? AND description_z IS NULL AND description != '' LIMIT ?`,
+		start, end, dictionarySamples)
+	if err != nil {
+		return nil, err
+	}
+	var samples [][]byte
+	for rows.Next() {
+		var description string
+		if err := rows.Scan(&description); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		samples = append(samples, []byte(description))
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil || len(samples) < minDictionarySamples {
+		return nil, err
+	}
+	dictionary, err = dict.BuildZstdDict(samples, dict.Options{
+		MaxDictSize: maxDictionarySize,
+		HashBytes:   6,
+		ZstdDictID:  uint32(dictionaryIDBase + start.Unix()/86400),
+		ZstdLevel:   zstd.SpeedDefault,
+	})
+	if err != nil {
+		return nil, err
+	}
+	// Decoders must know the dictionary before any row uses it
+	if err := d.descriptionDicts.add(dictionary); err != nil {
+		return nil, err
+	}
+	_, err = d.db.Exec(`INSERT INTO log_dictionaries (day, dict, created_at) VALUES (?, ?, ?)`, day, dictionary, d.now().UTC())
+	return dictionary, err
+}
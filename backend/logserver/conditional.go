@@ -0,0 +1,71 @@
+package logserver
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Dashboard endpoints are polled constantly, so they answer conditional
+// requests: the ETag is the version of the stored logs, which moves with
+// every stored log and bulk change, and Last-Modified is when it last
+// moved. Unchanged data gets a 304 without running any query.
+
+// logsChanged moves the version that dashboard ETags derive from. The
+// version starts at the startup time so ETags never repeat across restarts.
+func (d *Database) logsChanged() {
+	now := time.Now().UnixNano()
+	d.logsVersion.CompareAndSwap(0, now)
+	d.logsVersion.Add(1)
+	d.logsChangedAt.Store(now)
+}
+
+// notModified sets ETag and Last-Modified for a dashboard response and
+// writes a 304 when the client's copy is current. Responses that also
+// depend on the clock, such as ones labelled by the current hour, pass
+// the period in which they stay the same as perPeriod.
+func (d *Database) notModified(w http.ResponseWriter, r *http.Request, perPeriod time.Duration) bool {
+	etag := strconv.FormatInt(d.logsVersion.Load(), 36)
+	modified := time.Unix(0, d.logsChangedAt.Load())
+	if perPeriod > 0 {
+		period := d.now().Truncate(perPeriod)
+		etag += "-" + strconv.FormatInt(period.Unix(), 36)
+		if period.After(modified) {
+			modified = period
+		}
+	}
+	if acceptsMsgpack(r) {
+		etag += "-mp"
+	}
+	etag = `"` + etag + `"`
+	modified = modified.UTC().Truncate(time.Second)
+
+	h := w.Header()
+	h.Set("ETag", etag)
+	h.Set("Last-Modified", modified.Format(http.TimeFormat))
+	h.Set("Cache-Control", "no-cache")
+	h.Set("Vary", "Accept")
+	if match := r.Header.Get("If-None-Match"); match != "" {
+		if !etagMatches(match, etag) {
+			return false
+		}
+	} else if since, err := http.ParseTime(r.Header.Get("If-Modified-Since")); err != nil || modified.After(since) {
+		return false
+	}
+	h.Del("Content-Type")
+	w.WriteHeader(http.StatusNotModified)
+	return true
+}
+
+// etagMatches reports whether an If-None-Match header lists etag; weak
+// validators match their strong form
+func etagMatches(header, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+	return false
+}
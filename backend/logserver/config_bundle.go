@@ -0,0 +1,566 @@
+package logserver
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+)
+
+// configBundleVersion is the bundle format written by export. Import
+// rejects bundles from newer versions.
+const configBundleVersion = 1
+
+// configBundleMaxBytes caps the size of an imported bundle
+const configBundleMaxBytes = 10 << 20
+
+// ConfigBundle is the JSON configuration document exchanged by
+// /api/admin/export and /api/admin/import. Mute windows refer to alert rules
+// by the rule IDs in the same bundle, and routes refer to outputs by name. A
+// section left out of an imported bundle is not touched.
+//
+// Routes are the only part of the ingest pipeline bundled: WASM processors
+// carry binaries and outputs carry credentials, so they are left out, as are
+// dashboards, which the server does not store.
+type ConfigBundle struct {
+	Version          int               `json:"version"`
+	ExportedAt       time.Time         `json:"exportedAt"`
+	AlertRules       []AlertRule       `json:"alertRules"`
+	MuteWindows      []MuteWindow      `json:"muteWindows"`
+	TagRules         []TagRule         `json:"tagRules"`
+	SavedSearches    []SavedSearch     `json:"savedSearches"`
+	Routes           []Route           `json:"routes"`
+	RiskWeights      *RiskWeights      `json:"riskWeights,omitempty"`
+	NotablePromotion *NotablePromotion `json:"notablePromotion,omitempty"`
+}
+
+// ImportCounts summarises what an import did, or would do, to one section
+type ImportCounts struct {
+	Created   int `json:"created"`
+	Updated   int `json:"updated"`
+	Unchanged int `json:"unchanged"`
+	Deleted   int `json:"deleted"`
+}
+
+var errInvalidBundle = errors.New("invalid bundle")
+
+type ConfigImportResult struct {
+	DryRun   bool                     `json:"dryRun"`
+	Replace  bool                     `json:"replace"`
+	Sections map[string]*ImportCounts `json:"sections"`
+}
+
+func (d *Database) ExportConfig() (ConfigBundle, error) {
+	b := ConfigBundle{Version: configBundleVersion, ExportedAt: d.now().UTC(), TagRules: d.GetTagRules(), Routes: d.GetRoutes()}
+	var err error
+	if b.AlertRules, err = d.GetAlertRules(); err != nil {
+		return b, err
+	}
+	if b.MuteWindows, err = d.GetMuteWindows(); err != nil {
+		return b, err
+	}
+	if b.SavedSearches, err = d.GetSavedSearches(); err != nil {
+		return b, err
+	}
+	promotion := d.GetNotablePromotion()
+	b.NotablePromotion = &promotion
+	weights, err := d.GetRiskWeights()
+	b.RiskWeights = &weights
+	return b, err
+}
+
+// sameSpec reports whether two resources have the same settings, ignoring
+// server-assigned fields
+func sameSpec(a, b interface{}) bool {
+	return iacETag(iacSpec(a)) == iacETag(iacSpec(b))
+}
+
+// validate checks every section before an import changes anything
+func (b *ConfigBundle) validate(d *Database) error {
+	if b.Version < 1 || b.Version > configBundleVersion {
+		return fmt.Errorf("unsupported bundle version %d (this server reads version %d)", b.Version, configBundleVersion)
+	}
+	ruleIDs := map[int64]bool{}
+	names := map[string]bool{}
+	for i := range b.AlertRules {
+		rule := &b.AlertRules[i]
+		if err := rule.validate(); err != nil {
+			return fmt.Errorf("alertRules[%d]: %v", i, err)
+		}
+		if names[rule.Name] {
+			return fmt.Errorf("alertRules[%d]: duplicate name %q", i, rule.Name)
+		}
+		names[rule.Name] = true
+		ruleIDs[rule.ID] = true
+	}
+	names = map[string]bool{}
+	for i := range b.MuteWindows {
+		m := &b.MuteWindows[i]
+		if err := m.validate(); err != nil {
+			return fmt.Errorf("muteWindows[%d]: %v", i, err)
+		}
+		if names[m.Name] {
+			return fmt.Errorf("muteWindows[%d]: duplicate name %q", i, m.Name)
+		}
+		names[m.Name] = true
+		for _, id := range m.RuleIDs {
+			if !ruleIDs[id] {
+				return fmt.Errorf("muteWindows[%d]: rule %d is not in alertRules", i, id)
+			}
+		}
+		if m.RuleIDs == nil {
+			m.RuleIDs = []int64{}
+		}
+		if m.Sources == nil {
+			m.Sources = []string{}
+		}
+		if m.StartsAt != nil {
+			t := m.StartsAt.UTC()
+			m.StartsAt = &t
+		}
+		if m.EndsAt != nil {
+			t := m.EndsAt.UTC()
+			m.EndsAt = &t
+		}
+	}
+	for i := range b.TagRules {
+		tags, err := normalizeTags([]string{b.TagRules[i].Tag})
+		if err != nil {
+			return fmt.Errorf("tagRules[%d]: %v", i, err)
+		}
+		b.TagRules[i].Tag = tags[0]
+	}
+	names = map[string]bool{}
+	for i := range b.SavedSearches {
+		s := &b.SavedSearches[i]
+		if err := s.validate(); err != nil {
+			return fmt.Errorf("savedSearches[%d]: %v", i, err)
+		}
+		if names[s.Name] {
+			return fmt.Errorf("savedSearches[%d]: duplicate name %q", i, s.Name)
+		}
+		names[s.Name] = true
+	}
+	names = map[string]bool{}
+	for i := range b.Routes {
+		rt := &b.Routes[i]
+		if err := rt.validate(d); err != nil {
+			return fmt.Errorf("routes[%d]: %v", i, err)
+		}
+		if names[rt.Name] {
+			return fmt.Errorf("routes[%d]: duplicate name %q", i, rt.Name)
+		}
+		names[rt.Name] = true
+	}
+	if b.RiskWeights != nil {
+		if err := b.RiskWeights.validate(); err != nil {
+			return fmt.Errorf("riskWeights: %v", err)
+		}
+	}
+	if b.NotablePromotion != nil {
+		if err := b.NotablePromotion.validate(&d.levels); err != nil {
+			return fmt.Errorf("notablePromotion: %v", err)
+		}
+	}
+	return nil
+}
+
+// ImportConfig applies a bundle in a single transaction, so a failure
+// leaves the configuration as it was. Alert rules, mute windows, saved
+// searches and routes are matched to existing ones by name and tag rules by
+// tag and filter; with replace, existing items missing from a section are
+// deleted. A dry run only counts the changes.
+func (d *Database) ImportConfig(b ConfigBundle, replace, dryRun bool) (ConfigImportResult, error) {
+	result := ConfigImportResult{DryRun: dryRun, Replace: replace, Sections: map[string]*ImportCounts{}}
+	if err := b.validate(d); err != nil {
+		return result, fmt.Errorf("%w: %v", errInvalidBundle, err)
+	}
+	// Share the IaC lock so imports and declarative updates do not interleave
+	iacMu.Lock()
+	defer iacMu.Unlock()
+	tx, err := d.db.Begin()
+	if err != nil {
+		return result, err
+	}
+	defer tx.Rollback()
+
+	// Bundle rule IDs mapped to the IDs of the matching rules here; a dry
+	// run maps new rules to negative placeholders
+	ruleIDs := map[int64]int64{}
+	if b.AlertRules != nil {
+		counts := &ImportCounts{}
+		result.Sections["alertRules"] = counts
+		existing, err := d.GetAlertRules()
+		if err != nil {
+			return result, err
+		}
+		byName := map[string]AlertRule{}
+		for i := len(existing) - 1; i >= 0; i-- {
+			byName[existing[i].Name] = existing[i]
+		}
+		wanted := map[string]bool{}
+		for i, rule := range b.AlertRules {
+			wanted[rule.Name] = true
+			bundleID := rule.ID
+			current, ok := byName[rule.Name]
+			switch {
+			case ok && sameSpec(current, rule):
+				counts.Unchanged++
+				rule.ID = current.ID
+			case ok:
+				counts.Updated++
+				rule.ID = current.ID
+				if !dryRun {
+					if err := updateAlertRule(tx, rule); err != nil {
+						return result, err
+					}
+				}
+			default:
+				counts.Created++
+				rule.ID = -int64(i + 1)
+				if !dryRun {
+					if rule, err = d.createAlertRule(tx, rule); err != nil {
+						return result, err
+					}
+				}
+			}
+			ruleIDs[bundleID] = rule.ID
+		}
+		if replace {
+			for _, rule := range existing {
+				if !wanted[rule.Name] {
+					counts.Deleted++
+					if !dryRun {
+						if err := deleteAlertRule(tx, rule.ID); err != nil {
+							return result, err
+						}
+					}
+				}
+			}
+		}
+	}
+
+	if b.MuteWindows != nil {
+		counts := &ImportCounts{}
+		result.Sections["muteWindows"] = counts
+		existing, err := d.GetMuteWindows()
+		if err != nil {
+			return result, err
+		}
+		byName := map[string]MuteWindow{}
+		for i := len(existing) - 1; i >= 0; i-- {
+			byName[existing[i].Name] = existing[i]
+		}
+		wanted := map[string]bool{}
+		for _, m := range b.MuteWindows {
+			wanted[m.Name] = true
+			remapped := make([]int64, len(m.RuleIDs))
+			for i, id := range m.RuleIDs {
+				remapped[i] = ruleIDs[id]
+			}
+			m.RuleIDs = remapped
+			current, ok := byName[m.Name]
+			switch {
+			case ok && sameSpec(current, m):
+				counts.Unchanged++
+			case ok:
+				counts.Updated++
+				m.ID = current.ID
+				if !dryRun {
+					if err := updateMuteWindow(tx, m); err != nil {
+						return result, err
+					}
+				}
+			default:
+				counts.Created++
+				if !dryRun {
+					if _, err := d.createMuteWindow(tx, m); err != nil {
+						return result, err
+					}
+				}
+			}
+		}
+		if replace {
+			for _, m := range existing {
+				if !wanted[m.Name] {
+					counts.Deleted++
+					if !dryRun {
+						if err := deleteMuteWindow(tx, m.ID); err != nil {
+							return result, err
+						}
+					}
+				}
+			}
+		}
+	}
+
+	if b.TagRules != nil {
+		counts := &ImportCounts{}
+		result.Sections["tagRules"] = counts
+		key := func(rule TagRule) string {
+			filter, _ := json.Marshal(rule.Filter)
+			return rule.Tag + "\x00" + string(filter)
+		}
+		existing := map[string]bool{}
+		for _, rule := range d.GetTagRules() {
+			existing[key(rule)] = true
+		}
+		wanted := map[string]bool{}
+		for _, rule := range b.TagRules {
+			k := key(rule)
+			if existing[k] || wanted[k] {
+				counts.Unchanged++
+			} else {
+				counts.Created++
+				if !dryRun {
+					if _, err := d.createTagRule(tx, rule); err != nil {
+						return result, err
+					}
+				}
+			}
+			wanted[k] = true
+		}
+		if replace {
+			for _, rule := range d.GetTagRules() {
+				if !wanted[key(rule)] {
+					counts.Deleted++
+					if !dryRun {
+						if err := deleteTagRule(tx, rule.ID); err != nil {
+							return result, err
+						}
+					}
+				}
+			}
+		}
+	}
+
+	if b.SavedSearches != nil {
+		counts := &ImportCounts{}
+		result.Sections["savedSearches"] = counts
+		existing, err := d.GetSavedSearches()
+		if err != nil {
+			return result, err
+		}
+		byName := map[string]SavedSearch{}
+		for i := len(existing) - 1; i >= 0; i-- {
+			byName[existing[i].Name] = existing[i]
+		}
+		wanted := map[string]bool{}
+		for _, s := range b.SavedSearches {
+			wanted[s.Name] = true
+			current, ok := byName[s.Name]
+			switch {
+			case ok && sameSpec(current, s):
+				counts.Unchanged++
+			case ok:
+				counts.Updated++
+				s.ID = current.ID
+				if !dryRun {
+					if err := updateSavedSearch(tx, s); err != nil {
+						return result, err
+					}
+				}
+			default:
+				counts.Created++
+				if !dryRun {
+					if _, err := d.createSavedSearch(tx, s); err != nil {
+						return result, err
+					}
+				}
+			}
+		}
+		if replace {
+			for _, s := range existing {
+				if !wanted[s.Name] {
+					counts.Deleted++
+					if !dryRun {
+						if err := deleteSavedSearch(tx, s.ID); err != nil {
+							return result, err
+						}
+					}
+				}
+			}
+		}
+	}
+
+	if b.Routes != nil {
+		counts := &ImportCounts{}
+		result.Sections["routes"] = counts
+		existing := d.GetRoutes()
+		byName := map[string]Route{}
+		for _, rt := range existing {
+			byName[rt.Name] = rt
+		}
+		wanted := map[string]bool{}
+		for _, rt := range b.Routes {
+			wanted[rt.Name] = true
+			current, ok := byName[rt.Name]
+			switch {
+			case ok && sameSpec(current, rt):
+				counts.Unchanged++
+			case ok:
+				counts.Updated++
+				rt.ID = current.ID
+				if !dryRun {
+					if err := updateRoute(tx, rt); err != nil {
+						return result, err
+					}
+				}
+			default:
+				counts.Created++
+				if !dryRun {
+					if _, err := d.createRoute(tx, rt); err != nil {
+						return result, err
+					}
+				}
+			}
+		}
+		if replace {
+			for _, rt := range existing {
+				if !wanted[rt.Name] {
+					counts.Deleted++
+					if !dryRun {
+						if err := deleteRoute(tx, rt.ID); err != nil {
+							return result, err
+						}
+					}
+				}
+			}
+		}
+	}
+
+	if b.RiskWeights != nil {
+		counts := &ImportCounts{}
+		result.Sections["riskWeights"] = counts
+		current, err := d.GetRiskWeights()
+		if err != nil {
+			return result, err
+		}
+		if current == *b.RiskWeights {
+			counts.Unchanged++
+		} else {
+			counts.Updated++
+			if !dryRun {
+				if err := storeRiskWeights(tx, *b.RiskWeights); err != nil {
+					return result, err
+				}
+			}
+		}
+	}
+
+	if b.NotablePromotion != nil {
+		counts := &ImportCounts{}
+		result.Sections["notablePromotion"] = counts
+		if sameSpec(d.GetNotablePromotion(), *b.NotablePromotion) {
+			counts.Unchanged++
+		} else {
+			counts.Updated++
+			if !dryRun {
+				if err := storeNotablePromotion(tx, *b.NotablePromotion); err != nil {
+					return result, err
+				}
+			}
+		}
+	}
+	if dryRun {
+		return result, nil
+	}
+	if err := tx.Commit(); err != nil {
+		return result, err
+	}
+
+	// Reload the caches ingest reads only once the changes are committed
+	if changed(result.Sections["tagRules"]) {
+		if err := d.loadTagRules(); err != nil {
+			return result, err
+		}
+	}
+	if changed(result.Sections["routes"]) {
+		if err := d.loadRoutes(); err != nil {
+			return result, err
+		}
+	}
+	if changed(result.Sections["notablePromotion"]) {
+		d.usePromotion(*b.NotablePromotion)
+	}
+	if changed(result.Sections["riskWeights"]) {
+		return result, d.rescoreNotables(*b.RiskWeights)
+	}
+	return result, nil
+}
+
+// changed reports whether an import changed a section it was given
+func changed(counts *ImportCounts) bool {
+	return counts != nil && counts.Created+counts.Updated+counts.Deleted > 0
+}
+
+// GET /api/admin/export - download the configuration bundle (admin only)
+func configExportHandlerDB(w http.ResponseWriter, r *http.Request, db *Database) {
+	enableCORS(w)
+	w.Header().Set("Content-Type", "application/json")
+	if !db.requireAdmin(w, r) {
+		return
+	}
+	if r.Method != http.MethodGet {
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	bundle, err := db.ExportConfig()
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "Failed to export configuration")
+		return
+	}
+	out, _ := json.MarshalIndent(bundle, "", "  ")
+	w.Header().Set("Content-Disposition", `attachment; filename="logger-config.json"`)
+	w.Write(out)
+}
+
+// POST /api/admin/import?mode=merge|replace&dry_run=true - apply a configuration bundle (admin only)
+func configImportHandlerDB(w http.ResponseWriter, r *http.Request, db *Database) {
+	enableCORS(w)
+	w.Header().Set("Content-Type", "application/json")
+	if !db.requireAdmin(w, r) {
+		return
+	}
+	if r.Method != http.MethodPost {
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	q := r.URL.Query()
+	mode := q.Get("mode")
+	if mode != "" && mode != "merge" && mode != "replace" {
+		writeJSONError(w, http.StatusBadRequest, "mode must be merge or replace")
+		return
+	}
+	body, err := io.ReadAll(io.LimitReader(r.Body, configBundleMaxBytes+1))
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, "Invalid body")
+		return
+	}
+	if len(body) > configBundleMaxBytes {
+		writeJSONError(w, http.StatusRequestEntityTooLarge, "Bundle is too large")
+		return
+	}
+	var bundle ConfigBundle
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&bundle); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "Invalid bundle: "+err.Error())
+		return
+	}
+	result, err := db.ImportConfig(bundle, mode == "replace", q.Get("dry_run") == "true")
+	if err != nil {
+		if errors.Is(err, errInvalidBundle) {
+			writeJSONError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		log.Printf("config import: %v", err)
+		writeJSONError(w, http.StatusInternalServerError, "Failed to import configuration")
+		return
+	}
+	json.NewEncoder(w).Encode(result)
+}
@@ -0,0 +1,73 @@
+package logserver
+
+import (
+	"net/http"
+	"testing"
+)
+
+// testBundle is a bundle with an item in each list section
+func testBundle() ConfigBundle {
+	return ConfigBundle{
+		Version: configBundleVersion,
+		AlertRules: []AlertRule{{
+			ID:            7,
+			Name:          "SSH brute force",
+			Filter:        LogFilter{Rule: stringList{"Brute Force Login"}},
+			Threshold:     3,
+			WindowSeconds: 300,
+			Enabled:       true,
+		}},
+		MuteWindows:   []MuteWindow{{Name: "Patching", Cron: "0 2 * * 6", DurationSeconds: 3600, Timezone: "UTC", RuleIDs: []int64{7}}},
+		TagRules:      []TagRule{{Tag: "auth", Filter: LogFilter{Rule: stringList{"Brute Force Login"}}}},
+		SavedSearches: []SavedSearch{{Name: "Errors", Query: "level:ERROR"}},
+		Routes:        []Route{{Name: "debug-drop", Filter: LogFilter{Level: stringList{"DEBUG"}}}},
+	}
+}
+
+func TestConfigBundleRoundTrip(t *testing.T) {
+	source := newHarness(t)
+	if _, err := source.db.ImportConfig(testBundle(), false, false); err != nil {
+		t.Fatal(err)
+	}
+	var bundle ConfigBundle
+	source.do(http.MethodGet, "/api/admin/export", nil, &bundle)
+
+	target := newHarness(t)
+	var result ConfigImportResult
+	target.do(http.MethodPost, "/api/admin/import", bundle, &result)
+	for _, section := range []string{"alertRules", "muteWindows", "tagRules", "savedSearches", "routes"} {
+		expect(t, section+" created", result.Sections[section].Created, 1)
+	}
+	if rules := target.db.GetRoutes(); len(rules) != 1 || rules[0].Name != "debug-drop" {
+		t.Errorf("routes in use after import = %+v, want debug-drop", rules)
+	}
+
+	// The mute window follows its rule to the rule's new ID
+	rules, _ := target.db.GetAlertRules()
+	mutes, _ := target.db.GetMuteWindows()
+	expect(t, "mute window rules", mutes[0].RuleIDs, []int64{rules[0].ID})
+
+	// Importing the export again changes nothing
+	target.do(http.MethodPost, "/api/admin/import?mode=replace", bundle, &result)
+	for section, counts := range result.Sections {
+		expect(t, section+" counts", *counts, ImportCounts{Unchanged: 1})
+	}
+}
+
+func TestConfigImportRollsBack(t *testing.T) {
+	h := newHarness(t)
+	// Saved searches are imported after alert rules, so failing them leaves
+	// the new rule uncommitted
+	if _, err := h.db.db.Exec(`DROP TABLE saved_searches`); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := h.db.ImportConfig(testBundle(), false, false); err == nil {
+		t.Fatal("import without a saved_searches table succeeded")
+	}
+	rules, err := h.db.GetAlertRules()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect(t, "alert rules after a failed import", len(rules), 0)
+	expect(t, "tag rules after a failed import", len(h.db.GetTagRules()), 0)
+}
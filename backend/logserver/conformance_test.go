@@ -0,0 +1,192 @@
+package logserver
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// Storage conformance. A LogStore is the part of the database that search
+// and the dashboards rely on. Every implementation must give the same
+// answers for the same logs, so the conformance suite ingests the fixture
+// logs in testdata/conformance/logs.json into an empty store, runs each
+// search in testdata/conformance/cases.json against it, and compares the
+// results with the golden files in testdata/conformance/golden. A new
+// backend passes when runConformance reports no failures for it.
+
+var updateGolden = flag.Bool("update", false, "rewrite the conformance golden files from the results")
+
+// LogStore stores logs and answers searches, counts and group-bys
+type LogStore interface {
+	InsertLog(entry LogEntry) error
+	SearchLogs(filter LogFilter, sort logSort, limit int) ([]LogEntry, error)
+	CountLogs(filter LogFilter) (int, error)
+	LogsExist(filter LogFilter) (bool, error)
+	GroupLogs(filter LogFilter, groupBy string, sort logSort, limit int) ([]GroupCount, error)
+}
+
+// conformanceNow is the time the suite runs at, so relative ranges such as
+// since=1d select the same fixture logs on every run
+var conformanceNow = time.Date(2025, 1, 3, 12, 0, 0, 0, time.UTC)
+
+// ConformanceCase is a search run against the fixture logs. Query takes the
+// parameters of GET /api/logs, including count_only, exists and group_by.
+type ConformanceCase struct {
+	Name  string `json:"name"`
+	Query string `json:"query"`
+}
+
+// ConformanceResult is the outcome of one case; Want and Got are set when
+// the result did not match its golden file
+type ConformanceResult struct {
+	Name   string
+	Passed bool
+	Want   []byte
+	Got    []byte
+	Err    error
+}
+
+// TestConformance runs the suite against a new SQLite database with its
+// clock stopped at conformanceNow. With -update it rewrites the golden
+// files instead.
+func TestConformance(t *testing.T) {
+	db, err := openDatabase(filepath.Join(t.TempDir(), "logs.db"), NewManualClock(conformanceNow))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	results, err := runConformance(db, filepath.Join("testdata", "conformance"), *updateGolden)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range results {
+		t.Run(r.Name, func(t *testing.T) {
+			switch {
+			case r.Err != nil:
+				t.Fatal(r.Err)
+			case !r.Passed:
+				t.Fatalf("--- want\n%s--- got\n%s", r.Want, r.Got)
+			}
+		})
+	}
+}
+
+// runConformance ingests the fixture logs into store, which must be empty,
+// and runs every case in dir against it. With update it writes each case's
+// result as its golden file instead of comparing.
+func runConformance(store LogStore, dir string, update bool) ([]ConformanceResult, error) {
+	var logs []LogEntry
+	if err := readConformanceFile(filepath.Join(dir, "logs.json"), &logs); err != nil {
+		return nil, err
+	}
+	for i := range logs {
+		if err := prepareLogEntry(&logs[i], conformanceNow); err != nil {
+			return nil, fmt.Errorf("fixture log %d: %w", i, err)
+		}
+		if err := store.InsertLog(logs[i]); err != nil {
+			return nil, fmt.Errorf("inserting fixture log %d: %w", i, err)
+		}
+	}
+	var cases []ConformanceCase
+	if err := readConformanceFile(filepath.Join(dir, "cases.json"), &cases); err != nil {
+		return nil, err
+	}
+
+	results := make([]ConformanceResult, 0, len(cases))
+	for _, c := range cases {
+		result := ConformanceResult{Name: c.Name}
+		got, err := conformanceAnswer(store, c.Query)
+		if err != nil {
+			result.Err = err
+			results = append(results, result)
+			continue
+		}
+		golden := filepath.Join(dir, "golden", c.Name+".json")
+		if update {
+			result.Err = os.WriteFile(golden, got, 0o644)
+			result.Passed = result.Err == nil
+			results = append(results, result)
+			continue
+		}
+		want, err := os.ReadFile(golden)
+		if err != nil {
+			result.Err = err
+		} else if result.Passed = bytes.Equal(want, got); !result.Passed {
+			result.Want, result.Got = want, got
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+// conformanceAnswer runs a case's query the way GET /api/logs does and
+// encodes the answer as indented JSON. Log IDs are left out, since stores
+// may number logs differently.
+func conformanceAnswer(store LogStore, query string) ([]byte, error) {
+	q, err := url.ParseQuery(query)
+	if err != nil {
+		return nil, err
+	}
+	filter, err := parseLogFilter(q, conformanceNow)
+	if err != nil {
+		return nil, err
+	}
+	limit := 100
+	if l, err := strconv.Atoi(q.Get("limit")); err == nil && l > 0 && l <= 1000 {
+		limit = l
+	}
+	groupBy := q.Get("group_by")
+	if _, ok := logGroupColumns[groupBy]; groupBy != "" && !ok {
+		return nil, errors.New("Unknown group_by " + groupBy)
+	}
+	sort, err := parseSort(q.Get("sort"), groupBy != "", defaultLevelConfig())
+	if err != nil {
+		return nil, err
+	}
+
+	var answer interface{}
+	switch {
+	case q.Get("count_only") == "true":
+		answer, err = store.CountLogs(filter)
+	case q.Get("exists") == "true":
+		answer, err = store.LogsExist(filter)
+	case groupBy != "":
+		answer, err = store.GroupLogs(filter, groupBy, sort, limit)
+	default:
+		var logs []LogEntry
+		logs, err = store.SearchLogs(filter, sort, limit)
+		for i := range logs {
+			logs[i].ID = 0
+			logs[i].Timestamp = logs[i].Timestamp.UTC()
+		}
+		if logs == nil {
+			logs = []LogEntry{}
+		}
+		answer = logs
+	}
+	if err != nil {
+		return nil, err
+	}
+	out, err := json.MarshalIndent(answer, "", "  ")
+	return append(out, '\n'), err
+}
+
+func readConformanceFile(path string, v interface{}) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("decoding %s: %w", path, err)
+	}
+	return nil
+}
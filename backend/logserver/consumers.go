@@ -0,0 +1,297 @@
+package logserver
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Consumer is a named cursor over the stored logs for an external system
+// that processes them in order. It fetches the logs after its checkpoint
+// and commits a new checkpoint once they are processed; a commit only
+// succeeds from the checkpoint it read, so a log is never skipped or
+// committed twice. Log IDs are never reused, so the checkpoint is a log ID.
+type Consumer struct {
+	Name       string    `json:"name"`
+	Filter     LogFilter `json:"filter"`
+	Checkpoint int64     `json:"checkpoint"`
+	// Lag counts the matching logs after the checkpoint; set when listing
+	Lag       *int      `json:"lag,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// errStaleCheckpoint is returned by a commit from a checkpoint that has moved on
+var errStaleCheckpoint = errors.New("checkpoint has moved on; fetch again")
+
+func createConsumerTables(db *sql.DB) error {
+	_, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS consumers (
+			name TEXT PRIMARY KEY,
+			filter TEXT NOT NULL,
+			checkpoint INTEGER NOT NULL,
+			created_at DATETIME NOT NULL,
+			updated_at DATETIME NOT NULL
+		)
+	`)
+	return err
+}
+
+func scanConsumer(scan func(...interface{}) error) (Consumer, error) {
+	var c Consumer
+	var filter string
+	if err := scan(&c.Name, &filter, &c.Checkpoint, &c.CreatedAt, &c.UpdatedAt); err != nil {
+		return c, err
+	}
+	return c, json.Unmarshal([]byte(filter), &c.Filter)
+}
+
+const consumerColumns = `name, filter, checkpoint, created_at, updated_at`
+
+func (d *Database) GetConsumers() ([]Consumer, error) {
+	rows, err := d.db.Query(`SELECT ` + consumerColumns + ` FROM consumers ORDER BY name`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	consumers := []Consumer{}
+	for rows.Next() {
+		c, err := scanConsumer(rows.Scan)
+		if err != nil {
+			return nil, err
+		}
+		consumers = append(consumers, c)
+	}
+	return consumers, rows.Err()
+}
+
+func (d *Database) GetConsumer(name string) (Consumer, error) {
+	return scanConsumer(d.db.QueryRow(`SELECT `+consumerColumns+` FROM consumers WHERE name = ?`, name).Scan)
+}
+
+func (d *Database) CreateConsumer(c Consumer) (Consumer, error) {
+	filter, _ := json.Marshal(c.Filter)
+	c.CreatedAt = d.now().UTC()
+	c.UpdatedAt = c.CreatedAt
+	_, err := d.db.Exec(`INSERT INTO consumers (`+consumerColumns+`) VALUES (?, ?, ?, ?, ?)`,
+		c.Name, string(filter), c.Checkpoint, c.CreatedAt, c.UpdatedAt)
+	return c, err
+}
+
+func (d *Database) DeleteConsumer(name string) error {
+	res, err := d.db.Exec(`DELETE FROM consumers WHERE name = ?`, name)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// CommitCheckpoint moves a consumer's checkpoint from one log ID to a later
+// one, failing with errStaleCheckpoint when it is no longer at from
+func (d *Database) CommitCheckpoint(name string, from, to int64) error {
+	res, err := d.db.Exec(`UPDATE consumers SET checkpoint = ?, updated_at = ? WHERE name = ? AND checkpoint = ?`,
+		to, d.now().UTC(), name, from)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 1 {
+		return nil
+	}
+	if _, err := d.GetConsumer(name); err != nil {
+		return err
+	}
+	return errStaleCheckpoint
+}
+
+// consumerLag counts the logs matching the consumer's filter after its checkpoint
+func (d *Database) consumerLag(c Consumer) (int, error) {
+	clause, args := c.Filter.where()
+	var n int
+	err := d.db.QueryRow(`SELECT COUNT(*) FROM logs WHERE id > ?`+clause, append([]interface{}{c.Checkpoint}, args...)...).Scan(&n)
+	return n, err
+}
+
+// GET/POST/DELETE /api/consumers - manage consumers (admin only)
+// GET /api/consumers/{name} - one consumer and its lag
+// GET /api/consumers/{name}/logs?limit=&wait= - logs after the checkpoint
+// POST /api/consumers/{name}/commit - move the checkpoint
+func consumersHandlerDB(w http.ResponseWriter, r *http.Request, db *Database) {
+	enableCORS(w)
+	w.Header().Set("Content-Type", "application/json")
+	if !db.requireAdmin(w, r) {
+		return
+	}
+	name, action, _ := strings.Cut(strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/consumers"), "/"), "/")
+	if name != "" {
+		consumerActionHandler(w, r, db, name, action)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		consumers, err := db.GetConsumers()
+		if err == nil {
+			for i := range consumers {
+				lag, lagErr := db.consumerLag(consumers[i])
+				consumers[i].Lag, err = &lag, lagErr
+				if err != nil {
+					break
+				}
+			}
+		}
+		if err != nil {
+			writeJSONError(w, http.StatusInternalServerError, "Failed to fetch consumers")
+			return
+		}
+		json.NewEncoder(w).Encode(consumers)
+	case http.MethodPost:
+		var req struct {
+			Name   string    `json:"name"`
+			Filter LogFilter `json:"filter"`
+			// Start is "earliest", "latest" (the default) or a log ID to start after
+			Start json.RawMessage `json:"start"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeJSONError(w, http.StatusBadRequest, "Invalid JSON")
+			return
+		}
+		if !iacExternalID.MatchString(req.Name) {
+			writeJSONError(w, http.StatusBadRequest, "name must be 1-128 letters, digits, '.', '_' or '-'")
+			return
+		}
+		if _, err := parseLogFilter(req.Filter.values(), db.now()); err != nil {
+			writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("filter: %v", err))
+			return
+		}
+		c := Consumer{Name: req.Name, Filter: req.Filter}
+		switch start := strings.Trim(string(req.Start), `"`); start {
+		case "earliest":
+		case "", "latest":
+			latest, err := db.LatestLogID()
+			if err != nil {
+				writeJSONError(w, http.StatusInternalServerError, "Failed to create consumer")
+				return
+			}
+			c.Checkpoint = latest
+		default:
+			if err := json.Unmarshal(req.Start, &c.Checkpoint); err != nil || c.Checkpoint < 0 {
+				writeJSONError(w, http.StatusBadRequest, `start must be "earliest", "latest" or a log ID`)
+				return
+			}
+		}
+		c, err := db.CreateConsumer(c)
+		if err != nil {
+			writeJSONError(w, http.StatusConflict, "A consumer with that name already exists")
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(c)
+	case http.MethodDelete:
+		err := db.DeleteConsumer(r.URL.Query().Get("name"))
+		if errors.Is(err, sql.ErrNoRows) {
+			writeJSONError(w, http.StatusNotFound, "Consumer not found")
+			return
+		}
+		if err != nil {
+			writeJSONError(w, http.StatusInternalServerError, "Failed to delete consumer")
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+	}
+}
+
+func consumerActionHandler(w http.ResponseWriter, r *http.Request, db *Database, name, action string) {
+	c, err := db.GetConsumer(name)
+	if errors.Is(err, sql.ErrNoRows) {
+		writeJSONError(w, http.StatusNotFound, "Consumer not found")
+		return
+	}
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "Failed to fetch consumer")
+		return
+	}
+
+	switch {
+	case action == "" && r.Method == http.MethodGet:
+		lag, err := db.consumerLag(c)
+		if err != nil {
+			writeJSONError(w, http.StatusInternalServerError, "Failed to fetch consumer")
+			return
+		}
+		c.Lag = &lag
+		json.NewEncoder(w).Encode(c)
+	case action == "logs" && r.Method == http.MethodGet:
+		q := r.URL.Query()
+		limit, err := parsePollLimit(q.Get("limit"))
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		// Fetching does not block by default; wait= turns it into a long poll
+		var wait time.Duration
+		if q.Get("wait") != "" {
+			if wait, err = parsePollWait(q.Get("wait")); err != nil {
+				writeJSONError(w, http.StatusBadRequest, err.Error())
+				return
+			}
+		}
+		logs, err := db.waitForLogs(r.Context(), c.Filter, c.Checkpoint, limit, wait)
+		if r.Context().Err() != nil {
+			return
+		}
+		if err != nil {
+			writeJSONError(w, http.StatusInternalServerError, "Failed to fetch logs")
+			return
+		}
+		next := c.Checkpoint
+		if len(logs) > 0 {
+			next = logs[len(logs)-1].ID
+		}
+		writeEncoded(w, r, map[string]interface{}{"checkpoint": c.Checkpoint, "next": next, "logs": logs})
+	case action == "commit" && r.Method == http.MethodPost:
+		var req struct {
+			Checkpoint *int64 `json:"checkpoint"`
+			Next       *int64 `json:"next"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Checkpoint == nil || req.Next == nil {
+			writeJSONError(w, http.StatusBadRequest, "checkpoint and next are required")
+			return
+		}
+		if *req.Next < *req.Checkpoint {
+			writeJSONError(w, http.StatusBadRequest, "next cannot be before checkpoint")
+			return
+		}
+		latest, err := db.LatestLogID()
+		if err != nil {
+			writeJSONError(w, http.StatusInternalServerError, "Failed to commit checkpoint")
+			return
+		}
+		if *req.Next > latest {
+			writeJSONError(w, http.StatusBadRequest, "next is beyond the latest log")
+			return
+		}
+		err = db.CommitCheckpoint(name, *req.Checkpoint, *req.Next)
+		if errors.Is(err, errStaleCheckpoint) {
+			writeJSONError(w, http.StatusConflict, err.Error())
+			return
+		}
+		if err != nil {
+			writeJSONError(w, http.StatusInternalServerError, "Failed to commit checkpoint")
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]int64{"checkpoint": *req.Next})
+	case action == "" || action == "logs" || action == "commit":
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+	default:
+		writeJSONError(w, http.StatusNotFound, "Unknown consumer action")
+	}
+}
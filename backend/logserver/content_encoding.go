@@ -0,0 +1,135 @@
+package logserver
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// Compressed ingest. Shippers compress their batches, often to a tenth of
+// the JSON, and say so with Content-Encoding: gzip or deflate. The ingest
+// endpoints decompress the body before decoding it. Request signing covers
+// the body as sent, so a request is authenticated before it is
+// decompressed, and the decompressed size is capped so a small body cannot
+// expand without bound.
+
+// ingestMaxDecompressed caps a decompressed POST /api/logs or staged batch
+// body
+var ingestMaxDecompressed = envInt("INGEST_MAX_DECOMPRESSED_BYTES", 64<<20)
+
+var (
+	errUnsupportedEncoding  = errors.New("Unsupported Content-Encoding; use gzip or deflate")
+	errDecompressedTooLarge = errors.New("Decompressed body is too large")
+)
+
+// corruptBodyError is a body that does not decompress as its encoding says
+type corruptBodyError string
+
+func (e corruptBodyError) Error() string {
+	return "Invalid " + string(e) + " body"
+}
+
+// ingestEncoding returns r's Content-Encoding, or "" when the body is not
+// encoded
+func ingestEncoding(r *http.Request) string {
+	encoding := strings.ToLower(strings.TrimSpace(r.Header.Get("Content-Encoding")))
+	if encoding == "identity" {
+		return ""
+	}
+	return encoding
+}
+
+// decompressingReader returns body decoded as encoding says. Reading more
+// than limit decompressed bytes fails with errDecompressedTooLarge.
+func decompressingReader(encoding string, body io.Reader, limit int) (io.Reader, error) {
+	var r io.Reader
+	switch encoding {
+	case "":
+		return body, nil
+	case "gzip", "x-gzip":
+		gz, err := gzip.NewReader(body)
+		if err != nil {
+			return nil, corruptBodyError(encoding)
+		}
+		r = gz
+	case "deflate":
+		// deflate is meant to be zlib framed, but some clients send bare
+		// deflate data, so the zlib header is sniffed as for GELF
+		buffered := bufio.NewReader(body)
+		head, _ := buffered.Peek(2)
+		if len(head) == 2 && head[0]&0x0f == 8 && (uint16(head[0])<<8|uint16(head[1]))%31 == 0 {
+			zr, err := zlib.NewReader(buffered)
+			if err != nil {
+				return nil, corruptBodyError(encoding)
+			}
+			r = zr
+		} else {
+			r = flate.NewReader(buffered)
+		}
+	default:
+		return nil, errUnsupportedEncoding
+	}
+	return &decompressedReader{r: r, encoding: encoding, left: int64(limit)}, nil
+}
+
+// decompressedReader stops a decompressor at a limit and names its format
+// in corruption errors
+type decompressedReader struct {
+	r        io.Reader
+	encoding string
+	left     int64
+}
+
+func (d *decompressedReader) Read(p []byte) (int, error) {
+	if d.left <= 0 {
+		// Only a body with more to give is too large
+		var probe [1]byte
+		if n, _ := io.ReadFull(d.r, probe[:]); n == 0 {
+			return 0, io.EOF
+		}
+		return 0, errDecompressedTooLarge
+	}
+	if int64(len(p)) > d.left {
+		p = p[:d.left]
+	}
+	n, err := d.r.Read(p)
+	d.left -= int64(n)
+	if err != nil && err != io.EOF {
+		var tooLarge *http.MaxBytesError
+		if !errors.As(err, &tooLarge) {
+			err = corruptBodyError(d.encoding)
+		}
+	}
+	return n, err
+}
+
+// decompressIngestBody decompresses a body read whole into dst, returning
+// the decompressed bytes
+func decompressIngestBody(encoding string, body []byte, dst *bytes.Buffer) ([]byte, error) {
+	r, err := decompressingReader(encoding, bytes.NewReader(body), ingestMaxDecompressed)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := dst.ReadFrom(r); err != nil {
+		return nil, err
+	}
+	return dst.Bytes(), nil
+}
+
+// decompressionStatus is the status to answer a decompression error with
+func decompressionStatus(err error) int {
+	switch {
+	case errors.Is(err, errUnsupportedEncoding):
+		return http.StatusUnsupportedMediaType
+	case errors.Is(err, errDecompressedTooLarge):
+		return http.StatusRequestEntityTooLarge
+	default:
+		return http.StatusBadRequest
+	}
+}
@@ -0,0 +1,183 @@
+package logserver
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// encodeBody compresses data as encoding says; "deflate" is zlib framed and
+// "raw deflate" is bare deflate data
+func encodeBody(t *testing.T, encoding string, data []byte) []byte {
+	t.Helper()
+	var b bytes.Buffer
+	var w io.WriteCloser
+	switch encoding {
+	case "gzip":
+		w = gzip.NewWriter(&b)
+	case "deflate":
+		w = zlib.NewWriter(&b)
+	case "raw deflate":
+		fw, err := flate.NewWriter(&b, flate.DefaultCompression)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w = fw
+	default:
+		t.Fatalf("unknown encoding %q", encoding)
+	}
+	w.Write(data)
+	w.Close()
+	return b.Bytes()
+}
+
+func TestDecompressingReader(t *testing.T) {
+	payload := []byte(strings.Repeat(`{"level":"INFO","event":"login"}`+"\n", 100))
+	for _, tc := range []struct{ name, encoding, sent string }{
+		{"gzip", "gzip", "gzip"},
+		{"x-gzip", "x-gzip", "gzip"},
+		{"zlib-framed deflate", "deflate", "deflate"},
+		{"bare deflate", "deflate", "raw deflate"},
+	} {
+		body := encodeBody(t, tc.sent, payload)
+		// A limit of exactly the decompressed size is enough...
+		r, err := decompressingReader(tc.encoding, bytes.NewReader(body), len(payload))
+		if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		got, err := io.ReadAll(r)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		expect(t, tc.name, string(got), string(payload))
+
+		// ...and one byte less is not
+		r, err = decompressingReader(tc.encoding, bytes.NewReader(body), len(payload)-1)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		if _, err := io.ReadAll(r); !errors.Is(err, errDecompressedTooLarge) {
+			t.Errorf("%s past the limit: %v", tc.name, err)
+		}
+	}
+
+	// An unencoded body is passed through without a limit
+	r, err := decompressingReader("", bytes.NewReader(payload), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect(t, "unencoded", string(got), string(payload))
+
+	if _, err := decompressingReader("br", bytes.NewReader(payload), len(payload)); !errors.Is(err, errUnsupportedEncoding) {
+		t.Errorf("br: %v", err)
+	}
+}
+
+func TestDecompressingReaderCorrupt(t *testing.T) {
+	payload := []byte(strings.Repeat(`{"level":"INFO","event":"login"}`+"\n", 100))
+	gz := encodeBody(t, "gzip", payload)
+	zl := encodeBody(t, "deflate", payload)
+	for _, tc := range []struct {
+		name, encoding string
+		body           []byte
+	}{
+		{"gzip header", "gzip", []byte("not gzip at all")},
+		{"truncated gzip", "gzip", gz[:len(gz)/2]},
+		{"gzip checksum", "gzip", append(append([]byte{}, gz[:len(gz)-8]...), 0, 0, 0, 0, 0, 0, 0, 0)},
+		{"truncated zlib", "deflate", zl[:len(zl)/2]},
+		{"zlib checksum", "deflate", append(append([]byte{}, zl[:len(zl)-4]...), 0, 0, 0, 0)},
+		{"bare deflate", "deflate", []byte{0xff, 0xff, 0xff, 0xff}},
+	} {
+		r, err := decompressingReader(tc.encoding, bytes.NewReader(tc.body), 1<<20)
+		if err == nil {
+			_, err = io.ReadAll(r)
+		}
+		var corrupt corruptBodyError
+		if !errors.As(err, &corrupt) {
+			t.Errorf("%s: %v", tc.name, err)
+			continue
+		}
+		expect(t, tc.name+": status", decompressionStatus(err), http.StatusBadRequest)
+		expect(t, tc.name+": message", err.Error(), "Invalid "+tc.encoding+" body")
+	}
+}
+
+func TestIngestEncoding(t *testing.T) {
+	for header, want := range map[string]string{"": "", "identity": "", " GZIP ": "gzip", "Deflate": "deflate", "br": "br"} {
+		r, _ := http.NewRequest(http.MethodPost, "/api/logs", nil)
+		r.Header.Set("Content-Encoding", header)
+		expect(t, "Content-Encoding "+header, ingestEncoding(r), want)
+	}
+	expect(t, "unsupported status", decompressionStatus(errUnsupportedEncoding), http.StatusUnsupportedMediaType)
+	expect(t, "too large status", decompressionStatus(errDecompressedTooLarge), http.StatusRequestEntityTooLarge)
+}
+
+// postEncoded posts body to path with Content-Type and Content-Encoding,
+// and returns the status and response body
+func (h *harness) postEncoded(path, contentType, encoding string, body []byte) (int, string) {
+	h.t.Helper()
+	req, err := http.NewRequest(http.MethodPost, h.url+path, bytes.NewReader(body))
+	if err != nil {
+		h.t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("Content-Encoding", encoding)
+	resp, err := h.server.Client().Do(req)
+	if err != nil {
+		h.t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		h.t.Fatal(err)
+	}
+	return resp.StatusCode, string(bytes.TrimSpace(data))
+}
+
+// Compressed bodies are stored as if sent plain, and refused with 413, 400
+// or 415 when they decompress too far, do not decompress or are encoded
+// some other way
+func TestE2ECompressedIngest(t *testing.T) {
+	defer func(max int) { ingestMaxDecompressed = max }(ingestMaxDecompressed)
+	defer func(max int) { bulkIngestMaxBytes = max }(bulkIngestMaxBytes)
+	h := newHarness(t)
+	batch := []byte(`[{"level":"WARN","event":"Failed login"},{"level":"INFO","event":"login"}]`)
+	lines := []byte(ndjsonLines(3))
+
+	for _, encoding := range []string{"gzip", "deflate", "raw deflate"} {
+		sent := strings.TrimPrefix(encoding, "raw ")
+		status, text := h.postEncoded("/api/logs", "application/json", sent, encodeBody(t, encoding, batch))
+		expect(t, encoding+": status "+text, status, http.StatusCreated)
+		header := http.Header{"Content-Encoding": {sent}}
+		status, _, res := h.bulkIngest("", encodeBody(t, encoding, lines), header)
+		expect(t, encoding+": bulk status", status, http.StatusOK)
+		expect(t, encoding+": bulk accepted", res.Accepted, 3)
+	}
+	expect(t, "stored logs", h.count(nil), 3*(2+3))
+
+	status, text := h.postEncoded("/api/logs", "application/json", "br", batch)
+	expect(t, "unknown encoding", []interface{}{status, text}, []interface{}{http.StatusUnsupportedMediaType, errUnsupportedEncoding.Error()})
+	status, text = h.postEncoded("/api/logs", "application/json", "gzip", batch)
+	expect(t, "corrupt body", []interface{}{status, text}, []interface{}{http.StatusBadRequest, "Invalid gzip body"})
+	status, _, res := h.bulkIngest("", []byte("garbage"), http.Header{"Content-Encoding": {"gzip"}})
+	expect(t, "corrupt bulk body", []interface{}{status, res.Error}, []interface{}{http.StatusBadRequest, "Invalid gzip body"})
+
+	// The caps apply to the decompressed size: INGEST_MAX_DECOMPRESSED_BYTES
+	// on POST /api/logs, and BULK_INGEST_MAX_BYTES on bulk ingest
+	ingestMaxDecompressed, bulkIngestMaxBytes = len(batch)-1, len(lines)+100
+	status, text = h.postEncoded("/api/logs", "application/json", "gzip", encodeBody(t, "gzip", batch))
+	expect(t, "past INGEST_MAX_DECOMPRESSED_BYTES", []interface{}{status, text}, []interface{}{http.StatusRequestEntityTooLarge, errDecompressedTooLarge.Error()})
+	status, _, res = h.bulkIngest("", encodeBody(t, "gzip", []byte(ndjsonLines(100))), http.Header{"Content-Encoding": {"gzip"}})
+	expect(t, "past BULK_INGEST_MAX_BYTES", []interface{}{status, res.Error}, []interface{}{http.StatusRequestEntityTooLarge, errDecompressedTooLarge.Error()})
+	expect(t, "stored logs after refusals", h.count(nil), 3*(2+3))
+}
@@ -0,0 +1,145 @@
+package logserver
+
+import (
+	"sync"
+	"time"
+)
+
+// Dashboard counters. The summary, urgency and timeline endpoints used to
+// read the logs table on every request; they now read these tallies, which
+// are seeded from the table at startup and kept current by ingest and bulk
+// jobs, so a dashboard read costs the same however many logs are stored.
+
+// counterMinutes is how far back the per-minute counts go: the urgency and
+// timeline endpoints both cover the last 24 hours
+const counterMinutes = 24 * 60
+
+// counterCategories are the categories the dashboard counts, in the order of
+// minuteCounts.categories
+var counterCategories = [...]string{"access", "network", "threat", "uba"}
+
+type dashboardCounters struct {
+	mu         sync.RWMutex
+	levels     map[string]int64
+	rules      map[string]int64
+	categories map[string]int64
+	// minutes holds the counts for one Unix minute per slot, at the minute
+	// modulo counterMinutes. A slot still holding an older minute is stale.
+	minutes [counterMinutes]minuteCounts
+	// clock decides which minutes are in the window
+	clock Clock
+}
+
+type minuteCounts struct {
+	minute     int64
+	urgency    [maxUrgency + 1]int64
+	categories [len(counterCategories)]int64
+}
+
+func newDashboardCounters(clock Clock) *dashboardCounters {
+	return &dashboardCounters{
+		clock:      clock,
+		levels:     make(map[string]int64),
+		rules:      make(map[string]int64),
+		categories: make(map[string]int64),
+	}
+}
+
+// add counts n logs like l; a negative n uncounts them
+func (c *dashboardCounters) add(l LogEntry, n int64) {
+	category := l.category()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.levels[l.Level] += n
+	c.rules[l.Rule] += n
+	c.categories[category] += n
+	c.addMinute(l.Timestamp.Unix()/60, l.Urgency, category, n)
+}
+
+// addMinute counts n logs in minute. Minutes older than the window are only
+// in the totals. Future minutes are left out too, since their slot would be
+// reused before they come round.
+func (c *dashboardCounters) addMinute(minute int64, urgency int, category string, n int64) {
+	now := c.clock.Now().Unix() / 60
+	if minute <= now-counterMinutes || minute > now {
+		return
+	}
+	slot := &c.minutes[minute%counterMinutes]
+	if slot.minute != minute {
+		if slot.minute > minute {
+			return
+		}
+		*slot = minuteCounts{minute: minute}
+	}
+	if urgency >= 0 && urgency < len(slot.urgency) {
+		slot.urgency[urgency] += n
+	}
+	for i, name := range counterCategories {
+		if name == category {
+			slot.categories[i] += n
+		}
+	}
+}
+
+// minute returns the counts for a Unix minute in the window
+func (c *dashboardCounters) minute(minute int64) minuteCounts {
+	if slot := c.minutes[minute%counterMinutes]; slot.minute == minute {
+		return slot
+	}
+	return minuteCounts{minute: minute}
+}
+
+// snapshot copies a totals map for callers outside the lock
+func (c *dashboardCounters) snapshot(totals map[string]int64) map[string]int64 {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	out := make(map[string]int64, len(totals))
+	for k, v := range totals {
+		if v != 0 {
+			out[k] = v
+		}
+	}
+	return out
+}
+
+// seedCounters loads the dashboard counters from the logs table
+func (d *Database) seedCounters() error {
+	c := d.counters
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	rows, err := d.db.Query(summaryStatsQuery)
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var l LogEntry
+		var count int64
+		if err := rows.Scan(&l.Level, &l.Rule, &l.Category, &count); err != nil {
+			rows.Close()
+			return err
+		}
+		category := l.category()
+		c.levels[l.Level] += count
+		c.rules[l.Rule] += count
+		c.categories[category] += count
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	rows, err = d.db.Query(recentCountsQuery, d.now().Add(-counterMinutes*time.Minute).UTC())
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var l LogEntry
+		var minute, count int64
+		if err := rows.Scan(&minute, &l.Rule, &l.Category, &l.Urgency, &count); err != nil {
+			return err
+		}
+		c.addMinute(minute, l.Urgency, l.category(), count)
+	}
+	return rows.Err()
+}
@@ -0,0 +1,327 @@
+package logserver
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// API keys and ingest tokens share rotation, revocation and last-use
+// tracking. Rotating issues a new secret while the previous one keeps
+// working until the end of an overlap period, so clients can be updated
+// without downtime.
+
+// defaultRotationOverlap is how long a rotated-out secret stays valid
+const defaultRotationOverlap = 24 * time.Hour
+
+// credentialUseFlushInterval is how often last-use times are written
+const credentialUseFlushInterval = time.Minute
+
+// credentialKind describes one table of credentials
+type credentialKind struct {
+	hashColumn string
+	prefix     string
+	reload     func(d *Database) error
+	revoke     func(d *Database, id int64) error
+}
+
+var credentialKinds = map[string]credentialKind{
+	"api_keys": {
+		hashColumn: "key_hash",
+		prefix:     apiKeyPrefix,
+		reload:     (*Database).loadAPIKeys,
+		revoke:     (*Database).DeleteAPIKey,
+	},
+	"ingest_tokens": {
+		hashColumn: "token_hash",
+		prefix:     ingestTokenPrefix,
+		reload:     (*Database).loadIngestTokens,
+		revoke:     (*Database).DeleteIngestToken,
+	},
+}
+
+// Credential is the listing view of an API key or ingest token
+type Credential struct {
+	Kind              string     `json:"kind"`
+	ID                int64      `json:"id"`
+	Name              string     `json:"name"`
+	Prefix            string     `json:"prefix"`
+	CreatedAt         time.Time  `json:"createdAt"`
+	RotatedAt         *time.Time `json:"rotatedAt,omitempty"`
+	PreviousExpiresAt *time.Time `json:"previousExpiresAt,omitempty"`
+	LastUsedAt        *time.Time `json:"lastUsedAt,omitempty"`
+}
+
+// credentialSecret is an accepted secret hash; expires is set for a
+// rotated-out secret during its overlap period
+type credentialSecret struct {
+	id      int64
+	expires time.Time
+}
+
+func (s credentialSecret) validAt(t time.Time) bool {
+	return s.expires.IsZero() || t.Before(s.expires)
+}
+
+type credentialRef struct {
+	table string
+	id    int64
+}
+
+// credentialUses holds last-use times not yet written to the database
+type credentialUses struct {
+	mu   sync.Mutex
+	uses map[credentialRef]time.Time
+}
+
+func addCredentialColumns(db *sql.DB, table string) error {
+	for _, col := range []struct{ name, definition string }{
+		{"previous_hash", `TEXT NOT NULL DEFAULT ''`},
+		{"previous_expires_at", `DATETIME`},
+		{"rotated_at", `DATETIME`},
+		{"last_used_at", `DATETIME`},
+	} {
+		if err := addColumnIfMissing(db, table, col.name, col.definition); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// loadCredentialSecrets returns the current and still-overlapping previous
+// secret hashes of a credential table
+func (d *Database) loadCredentialSecrets(table, hashColumn string) (map[string]credentialSecret, error) {
+	rows, err := d.db.Query(`SELECT id, ` + hashColumn + `, previous_hash, previous_expires_at FROM ` + table)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	now := d.now()
+	secrets := make(map[string]credentialSecret)
+	for rows.Next() {
+		var id int64
+		var hash, previous string
+		var previousExpires sql.NullTime
+		if err := rows.Scan(&id, &hash, &previous, &previousExpires); err != nil {
+			return nil, err
+		}
+		secrets[hash] = credentialSecret{id: id}
+		if previous != "" && previousExpires.Valid && now.Before(previousExpires.Time) {
+			secrets[previous] = credentialSecret{id: id, expires: previousExpires.Time}
+		}
+	}
+	return secrets, rows.Err()
+}
+
+func (d *Database) recordCredentialUse(table string, id int64, t time.Time) {
+	d.credentialUses.mu.Lock()
+	defer d.credentialUses.mu.Unlock()
+	if d.credentialUses.uses == nil {
+		d.credentialUses.uses = make(map[credentialRef]time.Time)
+	}
+	d.credentialUses.uses[credentialRef{table, id}] = t
+}
+
+// flushCredentialUses writes the recorded last-use times
+func (d *Database) flushCredentialUses() error {
+	d.credentialUses.mu.Lock()
+	uses := d.credentialUses.uses
+	d.credentialUses.uses = nil
+	d.credentialUses.mu.Unlock()
+	for ref, t := range uses {
+		if _, err := d.db.Exec(`UPDATE `+ref.table+` SET last_used_at = ? WHERE id = ?`, t.UTC(), ref.id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (d *Database) flushCredentialUsesLoop() {
+	ticker := time.NewTicker(credentialUseFlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := d.flushCredentialUses(); err != nil {
+				log.Printf("credentials: failed to record last use: %v", err)
+			}
+		case <-d.done:
+			return
+		}
+	}
+}
+
+// GetCredentials lists API keys and ingest tokens, least recently used
+// first; credentials that were never used come before all others
+func (d *Database) GetCredentials() ([]Credential, error) {
+	if err := d.flushCredentialUses(); err != nil {
+		return nil, err
+	}
+	creds := []Credential{}
+	for _, table := range []string{"api_keys", "ingest_tokens"} {
+		rows, err := d.db.Query(`SELECT id, name, prefix, created_at, rotated_at, previous_expires_at, last_used_at FROM ` + table)
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			c := Credential{Kind: table}
+			var rotated, previousExpires, lastUsed sql.NullTime
+			if err := rows.Scan(&c.ID, &c.Name, &c.Prefix, &c.CreatedAt, &rotated, &previousExpires, &lastUsed); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			c.RotatedAt = nullTimePtr(rotated)
+			c.LastUsedAt = nullTimePtr(lastUsed)
+			if previousExpires.Valid && d.now().Before(previousExpires.Time) {
+				c.PreviousExpiresAt = &previousExpires.Time
+			}
+			creds = append(creds, c)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+	}
+	sort.SliceStable(creds, func(i, j int) bool {
+		a, b := creds[i].LastUsedAt, creds[j].LastUsedAt
+		if a == nil || b == nil {
+			return a == nil && b != nil
+		}
+		return a.Before(*b)
+	})
+	return creds, nil
+}
+
+func nullTimePtr(t sql.NullTime) *time.Time {
+	if !t.Valid {
+		return nil
+	}
+	return &t.Time
+}
+
+// RotateCredential issues a new secret; the current one stays valid for
+// overlap (zero revokes it at once) and replaces any earlier rotated-out secret
+func (d *Database) RotateCredential(table string, id int64, overlap time.Duration) (string, error) {
+	kind := credentialKinds[table]
+	secret := kind.prefix + randomHex(apiKeyBytes)
+	now := d.now().UTC()
+	res, err := d.db.Exec(`
+		UPDATE `+table+` SET previous_hash = `+kind.hashColumn+`, previous_expires_at = ?, rotated_at = ?,
+			`+kind.hashColumn+` = ?, prefix = ?
+		WHERE id = ?
+	`, now.Add(overlap), now, hashAPIKey(secret), secret[:len(kind.prefix)+apiKeyShownPrefix], id)
+	if err != nil {
+		return "", err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return "", sql.ErrNoRows
+	}
+	return secret, kind.reload(d)
+}
+
+// RevokePreviousCredential ends the overlap period of a rotated-out secret now
+func (d *Database) RevokePreviousCredential(table string, id int64) error {
+	res, err := d.db.Exec(`UPDATE `+table+` SET previous_hash = '', previous_expires_at = NULL WHERE id = ?`, id)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return credentialKinds[table].reload(d)
+}
+
+// GET /api/credentials - list API keys and ingest tokens by last use (admin only)
+// POST /api/credentials/{kind}/{id}/rotate?overlap=24h - issue a new secret
+// POST /api/credentials/{kind}/{id}/revoke[?previous=true] - revoke a credential, or only its rotated-out secret
+func credentialsHandlerDB(w http.ResponseWriter, r *http.Request, db *Database) {
+	enableCORS(w)
+	w.Header().Set("Content-Type", "application/json")
+	if !db.requireAdmin(w, r) {
+		return
+	}
+	path := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/credentials"), "/")
+	if path == "" {
+		if r.Method != http.MethodGet {
+			writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+			return
+		}
+		creds, err := db.GetCredentials()
+		if err != nil {
+			writeJSONError(w, http.StatusInternalServerError, "Failed to fetch credentials")
+			return
+		}
+		json.NewEncoder(w).Encode(creds)
+		return
+	}
+
+	parts := strings.Split(path, "/")
+	if len(parts) != 3 {
+		writeJSONError(w, http.StatusNotFound, "Not found")
+		return
+	}
+	kind, ok := credentialKinds[parts[0]]
+	if !ok {
+		writeJSONError(w, http.StatusNotFound, "Unknown credential kind")
+		return
+	}
+	id, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, "Invalid id")
+		return
+	}
+	if r.Method != http.MethodPost {
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	switch parts[2] {
+	case "rotate":
+		overlap := defaultRotationOverlap
+		if v := r.URL.Query().Get("overlap"); v != "" {
+			if overlap, err = time.ParseDuration(v); err != nil || overlap < 0 {
+				writeJSONError(w, http.StatusBadRequest, "Invalid overlap duration")
+				return
+			}
+		}
+		secret, err := db.RotateCredential(parts[0], id, overlap)
+		if errors.Is(err, sql.ErrNoRows) {
+			writeJSONError(w, http.StatusNotFound, "Credential not found")
+			return
+		}
+		if err != nil {
+			writeJSONError(w, http.StatusInternalServerError, "Failed to rotate credential")
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"kind":              parts[0],
+			"id":                id,
+			"secret":            secret,
+			"previousExpiresAt": db.now().UTC().Add(overlap),
+		})
+	case "revoke":
+		if r.URL.Query().Get("previous") == "true" {
+			err = db.RevokePreviousCredential(parts[0], id)
+		} else {
+			err = kind.revoke(db, id)
+		}
+		if errors.Is(err, sql.ErrNoRows) {
+			writeJSONError(w, http.StatusNotFound, "Credential not found")
+			return
+		}
+		if err != nil {
+			writeJSONError(w, http.StatusInternalServerError, "Failed to revoke credential")
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeJSONError(w, http.StatusNotFound, "Not found")
+	}
+}
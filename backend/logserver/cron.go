@@ -0,0 +1,140 @@
+package logserver
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// cronSchedule is a parsed standard five-field cron expression
+// (minute hour day-of-month month day-of-week)
+type cronSchedule struct {
+	minute, hour, dom, month, dow []bool
+	domAny, dowAny                bool
+}
+
+func parseCron(expr string) (*cronSchedule, error) {
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("cron expression %q must have 5 fields", expr)
+	}
+	var s cronSchedule
+	var err error
+	if s.minute, err = parseCronField(fields[0], 0, 59); err != nil {
+		return nil, err
+	}
+	if s.hour, err = parseCronField(fields[1], 0, 23); err != nil {
+		return nil, err
+	}
+	if s.dom, err = parseCronField(fields[2], 1, 31); err != nil {
+		return nil, err
+	}
+	if s.month, err = parseCronField(fields[3], 1, 12); err != nil {
+		return nil, err
+	}
+	if s.dow, err = parseCronField(fields[4], 0, 7); err != nil {
+		return nil, err
+	}
+	// Both 0 and 7 mean Sunday
+	if s.dow[7] {
+		s.dow[0] = true
+	}
+	s.domAny = fields[2] == "*"
+	s.dowAny = fields[4] == "*"
+	return &s, nil
+}
+
+// parseCronField handles "*", "a", "a-b", "*/n", "a/n", "a-b/n" and comma
+// lists of those. As in standard cron, "a/n" is every nth value from a.
+func parseCronField(field string, min, max int) ([]bool, error) {
+	set := make([]bool, max+1)
+	for _, part := range strings.Split(field, ",") {
+		step, stepped := 1, false
+		if i := strings.Index(part, "/"); i >= 0 {
+			n, err := strconv.Atoi(part[i+1:])
+			if err != nil || n <= 0 {
+				return nil, fmt.Errorf("invalid cron step in %q", field)
+			}
+			step, stepped = n, true
+			part = part[:i]
+		}
+		lo, hi := min, max
+		if part != "*" {
+			bounds := strings.SplitN(part, "-", 2)
+			var err error
+			if lo, err = strconv.Atoi(bounds[0]); err != nil {
+				return nil, fmt.Errorf("invalid cron value in %q", field)
+			}
+			hi = lo
+			if stepped {
+				hi = max
+			}
+			if len(bounds) == 2 {
+				if hi, err = strconv.Atoi(bounds[1]); err != nil {
+					return nil, fmt.Errorf("invalid cron value in %q", field)
+				}
+			}
+		}
+		if lo < min || hi > max || lo > hi {
+			return nil, fmt.Errorf("cron value out of range in %q", field)
+		}
+		for v := lo; v <= hi; v += step {
+			set[v] = true
+		}
+	}
+	return set, nil
+}
+
+// matches reports whether t (truncated to the minute) is a scheduled time
+func (s *cronSchedule) matches(t time.Time) bool {
+	return s.minute[t.Minute()] && s.hour[t.Hour()] && s.month[int(t.Month())] && s.dayMatches(t)
+}
+
+// dayMatches reports whether t's day is a scheduled day. As in standard
+// cron, a restricted day-of-month and day-of-week are ORed.
+func (s *cronSchedule) dayMatches(t time.Time) bool {
+	domMatch := s.dom[t.Day()]
+	dowMatch := s.dow[int(t.Weekday())]
+	switch {
+	case s.domAny && s.dowAny:
+		return true
+	case s.domAny:
+		return dowMatch
+	case s.dowAny:
+		return domMatch
+	default:
+		return domMatch || dowMatch
+	}
+}
+
+// activeWithin reports whether the schedule fired at some minute in (t-d, t].
+// It walks back from t skipping whole months, days and hours that can't
+// match, so a long d costs a few steps per day rather than one per minute.
+func (s *cronSchedule) activeWithin(t time.Time, d time.Duration) bool {
+	loc := t.Location()
+	for m := t.Truncate(time.Minute); t.Sub(m) < d; {
+		year, month, day := m.Date()
+		var start time.Time
+		switch {
+		case !s.month[int(month)]:
+			start = time.Date(year, month, 1, 0, 0, 0, 0, loc)
+		case !s.dayMatches(m):
+			start = time.Date(year, month, day, 0, 0, 0, 0, loc)
+		case !s.hour[m.Hour()]:
+			start = time.Date(year, month, day, m.Hour(), 0, 0, 0, loc)
+		case !s.minute[m.Minute()]:
+			start = m
+		default:
+			return true
+		}
+		// Go on from the minute before the month, day or hour. A start
+		// moved by a daylight saving change never goes forwards.
+		prev := start.Add(-time.Minute)
+		if !prev.Before(m) {
+			prev = m.Add(-time.Minute)
+		}
+		m = prev
+	}
+	return false
+}
@@ -0,0 +1,99 @@
+package logserver
+
+import (
+	"testing"
+	"time"
+)
+
+// cronValues lists the values a parsed field is set for
+func cronValues(set []bool) []int {
+	var values []int
+	for v, ok := range set {
+		if ok {
+			values = append(values, v)
+		}
+	}
+	return values
+}
+
+func TestParseCronField(t *testing.T) {
+	for _, tc := range []struct {
+		field string
+		want  []int
+	}{
+		{"5", []int{5}},
+		{"5-8", []int{5, 6, 7, 8}},
+		{"*/15", []int{0, 15, 30, 45}},
+		{"10/20", []int{10, 30, 50}},
+		{"10-40/15", []int{10, 25, 40}},
+		{"1,3/25,58-59", []int{1, 3, 28, 53, 58, 59}},
+	} {
+		set, err := parseCronField(tc.field, 0, 59)
+		if err != nil {
+			t.Errorf("%s: %v", tc.field, err)
+			continue
+		}
+		expect(t, tc.field, cronValues(set), tc.want)
+	}
+	for _, field := range []string{"", "60", "5-1", "*/0", "a", "1/x", "-1"} {
+		if _, err := parseCronField(field, 0, 59); err == nil {
+			t.Errorf("%q parsed", field)
+		}
+	}
+}
+
+// activeWithinByMinute is activeWithin checking every minute of the window
+func activeWithinByMinute(s *cronSchedule, t time.Time, d time.Duration) bool {
+	for m := t.Truncate(time.Minute); t.Sub(m) < d; m = m.Add(-time.Minute) {
+		if s.matches(m) {
+			return true
+		}
+	}
+	return false
+}
+
+func TestCronActiveWithin(t *testing.T) {
+	ny, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skip(err)
+	}
+	var times []time.Time
+	for _, start := range []time.Time{
+		time.Date(2024, 2, 29, 1, 30, 20, 0, time.UTC),
+		// Across the daylight saving changes in New York
+		time.Date(2024, 3, 10, 4, 0, 0, 0, ny),
+		time.Date(2024, 11, 3, 2, 30, 0, 0, ny),
+	} {
+		for h := 0; h < 72; h += 5 {
+			times = append(times, start.Add(time.Duration(h)*time.Hour+time.Duration(h)*time.Minute))
+		}
+	}
+	for _, expr := range []string{"0 2 * * 6", "*/20 9-17 * * 1-5", "30 1 29 2 *", "15 3 1,15 * 0", "5/10 2 * * *"} {
+		s, err := parseCron(expr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, at := range times {
+			for _, d := range []time.Duration{time.Minute, 45 * time.Minute, 7 * time.Hour, 50 * time.Hour} {
+				if got, want := s.activeWithin(at, d), activeWithinByMinute(s, at, d); got != want {
+					t.Errorf("%s at %s within %s = %v, want %v", expr, at, d, got, want)
+				}
+			}
+		}
+	}
+}
+
+func TestCronActiveWithinLongDuration(t *testing.T) {
+	// A schedule that never fires, over a century, takes a few steps a day
+	s, err := parseCron("0 0 31 2 *")
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	if s.activeWithin(time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC), 100*365*24*time.Hour) {
+		t.Error("a schedule for 31 February fired")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("a century took %s", elapsed)
+	}
+}
@@ -0,0 +1,1073 @@
+package logserver
+
+import (
+	"database/sql"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"math/rand"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+type Database struct {
+	db   *sql.DB
+	path string
+	// insertLog is prepared once; Exec with the query text re-prepares it per log
+	insertLog *sql.Stmt
+	insertRaw *sql.Stmt
+	// readOnly serves ad-hoc SQL queries
+	readOnly *sql.DB
+
+	// Streaming top-N trackers updated on every insert
+	topEvents       *SpaceSaving
+	topSources      *SpaceSaving
+	topDestinations *SpaceSaving
+	rowCount        atomic.Int64
+	counters        *dashboardCounters
+	tagRules        tagRuleCache
+	dataScopes      dataScopeCache
+	fieldMasks      fieldMaskCache
+	schemas         schemaRegistry
+
+	uniques *uniqueRollups
+	done    chan struct{}
+	// loops tracks the background loops, which stop when done is closed
+	loops sync.WaitGroup
+
+	// tickets is nil unless TICKET_PROVIDER is configured
+	tickets      TicketProvider
+	ticketFields map[string]string
+
+	// archive is nil unless ARCHIVE_URL is configured
+	archive *archiveStore
+
+	// geoIP is nil unless GEOIP_DB is configured; see geoip.go
+	geoIP *geoIPDatabase
+
+	// bus fans out every ingested log; see subscribeIngest
+	bus *Bus
+
+	// logsVersion and logsChangedAt move whenever stored logs change; they
+	// validate conditional dashboard requests
+	logsVersion   atomic.Int64
+	logsChangedAt atomic.Int64
+
+	// newLogs wakes long polls; see logPollHandlerDB
+	newLogs logSignal
+	// newChanges wakes change feed requests waiting for a change
+	newChanges logSignal
+
+	// clock tells the time for timestamps and time windows; see clock.go
+	clock Clock
+
+	// ingestQueue holds logs acknowledged before they are stored; see
+	// ingest_queue.go
+	ingestQueue *ingestQueue
+	// batchCommits serializes staging and committing ingest batches; see
+	// ingest_batches.go
+	batchCommits sync.Mutex
+	// promotion counts severe logs toward notables; see notable_promotion.go
+	promotion notablePromoter
+	// replayCancels and bulkCancels stop running replays and bulk jobs; see
+	// replay.go and bulk.go
+	replayCancels jobCancels
+	bulkCancels   jobCancels
+
+	// apiKeys holds the accepted API key hashes; see apikeys.go
+	apiKeys apiKeyCache
+	// credentialUses holds last-use times not yet written; see credentials.go
+	credentialUses credentialUses
+	// authGuard locks out clients that fail admin authentication too often;
+	// see auth_guard.go
+	authGuard authGuard
+	// authEvents carries authentication events to authEventsLoop
+	authEvents chan authEvent
+	// queries caps concurrent heavy reads; see querylimit.go
+	queries *queryLimiter
+	// levels holds the configured log levels and urgencies; see levels.go
+	levels levelConfig
+	// ingestTokens and quotas hold the ingest tokens and their usage today;
+	// see ingest_tokens.go and quotas.go
+	ingestTokens ingestTokenCache
+	quotas       quotaCounter
+	// routes holds the ingest routes; see routes.go
+	routes routeCache
+	// descriptionDicts decodes compressed descriptions; see compression.go
+	descriptionDicts descriptionCodec
+	// wasmProcessors holds the loaded ingest processors; see
+	// wasm_processors.go
+	wasmProcessors wasmProcessorCache
+	// udpStats, syslogStats and gelfStats count the listeners' messages for
+	// /metrics; see udp_ingest.go, syslog_ingest.go and gelf_ingest.go
+	udpStats    udpStats
+	syslogStats syslogStats
+	gelfStats   gelfStats
+	// usage holds API usage not yet written; see usage.go
+	usage apiUsage
+	// outputs holds the running outputs; see outputs.go
+	outputs outputRunners
+}
+
+const databasePath = "./logs.db"
+
+// NewDatabase opens the database in logs.db in the working directory
+func NewDatabase() (*Database, error) {
+	return openDatabase(databasePath, systemClock{})
+}
+
+// OpenDatabase opens, and creates if need be, the database at path
+func OpenDatabase(path string) (*Database, error) {
+	return openDatabase(path, systemClock{})
+}
+
+// openDatabase opens, and creates if need be, the database at path, telling
+// the time by clock
+func openDatabase(path string, clock Clock) (*Database, error) {
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := db.Ping(); err != nil {
+		return nil, err
+	}
+
+	if err := createTables(db); err != nil {
+		return nil, err
+	}
+
+	readOnly, err := openReadOnly(path)
+	if err != nil {
+		return nil, err
+	}
+
+	insertLog, err := db.Prepare(`
+		INSERT INTO logs (timestamp, level, rule, source_ip, destination_ip, event, description, urgency, user_name, source_port, destination_port, trace_id)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`)
+	if err != nil {
+		return nil, err
+	}
+	insertRaw, err := db.Prepare(`INSERT OR REPLACE INTO log_raw (log_id, format, payload) VALUES (?, ?, ?)`)
+	if err != nil {
+		return nil, err
+	}
+
+	d := &Database{
+		db:              db,
+		insertLog:       insertLog,
+		insertRaw:       insertRaw,
+		readOnly:        readOnly,
+		path:            path,
+		topEvents:       NewSpaceSaving(topKCapacity),
+		topSources:      NewSpaceSaving(topKCapacity),
+		topDestinations: NewSpaceSaving(topKCapacity),
+		counters:        newDashboardCounters(clock),
+		uniques: &uniqueRollups{
+			sketches: make(map[rollupKey]*HyperLogLog),
+			dirty:    make(map[rollupKey]bool),
+		},
+		done:    make(chan struct{}),
+		archive: newArchiveStore(),
+		bus:     NewBus(),
+		clock:   clock,
+
+		authEvents:  make(chan authEvent, authEventBuffer),
+		ingestQueue: newIngestQueue(path),
+		queries:     newQueryLimiter(queryConcurrency, queryConcurrencyPerClient, queryQueueSize),
+	}
+	d.subscribeIngest()
+	if err := d.loadLevels(); err != nil {
+		return nil, err
+	}
+	if err := d.ensureLevelSeverityIndex(); err != nil {
+		return nil, err
+	}
+	if d.tickets, d.ticketFields, err = newTicketProvider(); err != nil {
+		return nil, err
+	}
+	if d.geoIP, err = openGeoIP(); err != nil {
+		return nil, err
+	}
+	if err := d.loadTagRules(); err != nil {
+		return nil, err
+	}
+	if err := d.loadSchemas(); err != nil {
+		return nil, err
+	}
+	if err := d.loadNotablePromotion(); err != nil {
+		return nil, err
+	}
+	if err := d.loadAPIKeys(); err != nil {
+		return nil, err
+	}
+	if err := d.loadDataScopes(); err != nil {
+		return nil, err
+	}
+	if err := d.loadFieldMasks(); err != nil {
+		return nil, err
+	}
+	if err := d.loadIngestTokens(); err != nil {
+		return nil, err
+	}
+	if err := d.loadQuotaUsage(); err != nil {
+		return nil, err
+	}
+	if err := d.failInterruptedReplays(); err != nil {
+		return nil, err
+	}
+	if err := d.failInterruptedBulkJobs(); err != nil {
+		return nil, err
+	}
+	if err := d.seedTopK(); err != nil {
+		return nil, err
+	}
+	if err := d.seedCounters(); err != nil {
+		return nil, err
+	}
+	if err := d.backfillRollups(); err != nil {
+		return nil, err
+	}
+	if err := d.loadRoutes(); err != nil {
+		return nil, err
+	}
+	if err := d.loadWasmProcessors(); err != nil {
+		return nil, err
+	}
+	if err := d.loadDictionaries(); err != nil {
+		return nil, err
+	}
+	if d.archive != nil {
+		if err := d.archive.loadBlooms(db); err != nil {
+			return nil, err
+		}
+	}
+	if err := d.startOutputs(); err != nil {
+		return nil, err
+	}
+	if err := d.replayIngestWAL(); err != nil {
+		return nil, err
+	}
+	d.background(d.ingestQueueLoop)
+	d.background(d.flushRollupsLoop)
+	d.background(d.flushCredentialUsesLoop)
+	d.background(d.flushUsageLoop)
+	d.background(d.flushQuotaUsageLoop)
+	d.background(d.authEventsLoop)
+	d.background(d.pruneChangesLoop)
+	d.background(d.pruneAuditLoop)
+	d.background(d.pruneIngestBatchesLoop)
+	d.background(d.compressDescriptionsLoop)
+	return d, nil
+}
+
+// background runs loop in a goroutine that Close waits for
+func (d *Database) background(loop func()) {
+	d.loops.Add(1)
+	go func() {
+		defer d.loops.Done()
+		loop()
+	}()
+}
+
+// seedTopK loads the existing per-event and per-source counts into the
+// streaming trackers so they are accurate across restarts
+func (d *Database) seedTopK() error {
+	var total int64
+	if err := d.db.QueryRow(`SELECT COUNT(*) FROM logs`).Scan(&total); err != nil {
+		return err
+	}
+	d.rowCount.Store(total)
+	d.logsChanged()
+	seeds := []struct {
+		query   string
+		tracker *SpaceSaving
+	}{
+		{`SELECT event, COUNT(*) FROM logs GROUP BY event`, d.topEvents},
+		{`SELECT source_ip, COUNT(*) FROM logs GROUP BY source_ip`, d.topSources},
+		{`SELECT destination_ip, COUNT(*) FROM logs GROUP BY destination_ip`, d.topDestinations},
+	}
+	for _, seed := range seeds {
+		rows, err := d.db.Query(seed.query)
+		if err != nil {
+			return err
+		}
+		for rows.Next() {
+			var key string
+			var count int
+			if err := rows.Scan(&key, &count); err != nil {
+				rows.Close()
+				return err
+			}
+			seed.tracker.Add(key, count)
+		}
+		rows.Close()
+	}
+	return nil
+}
+
+func createTables(db *sql.DB) error {
+	// Create logs table
+	_, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS logs (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			timestamp DATETIME NOT NULL,
+			level TEXT NOT NULL,
+			rule TEXT NOT NULL,
+			source_ip TEXT NOT NULL,
+			destination_ip TEXT NOT NULL,
+			event TEXT NOT NULL,
+			description TEXT NOT NULL,
+			urgency INTEGER NOT NULL,
+			user_name TEXT NOT NULL DEFAULT '',
+			source_port INTEGER NOT NULL DEFAULT 0,
+			destination_port INTEGER NOT NULL DEFAULT 0,
+			category TEXT NOT NULL DEFAULT '',
+			trace_id TEXT NOT NULL DEFAULT '',
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		)
+	`)
+	if err != nil {
+		return err
+	}
+
+	// Columns added after the initial schema; older databases are migrated in place
+	if err := addColumnIfMissing(db, "logs", "user_name", `TEXT NOT NULL DEFAULT ''`); err != nil {
+		return err
+	}
+	if err := addColumnIfMissing(db, "logs", "source_port", `INTEGER NOT NULL DEFAULT 0`); err != nil {
+		return err
+	}
+	if err := addColumnIfMissing(db, "logs", "destination_port", `INTEGER NOT NULL DEFAULT 0`); err != nil {
+		return err
+	}
+	if err := addColumnIfMissing(db, "logs", "category", `TEXT NOT NULL DEFAULT ''`); err != nil {
+		return err
+	}
+	if err := addColumnIfMissing(db, "logs", "trace_id", `TEXT NOT NULL DEFAULT ''`); err != nil {
+		return err
+	}
+
+	if err := createRollupTables(db); err != nil {
+		return err
+	}
+
+	if err := createAlertTables(db); err != nil {
+		return err
+	}
+
+	if err := createMuteTables(db); err != nil {
+		return err
+	}
+
+	if err := createAlertStateTables(db); err != nil {
+		return err
+	}
+
+	if err := createStorageTables(db); err != nil {
+		return err
+	}
+
+	if err := createNotableTables(db); err != nil {
+		return err
+	}
+
+	if err := createNotablePromotionTables(db); err != nil {
+		return err
+	}
+
+	if err := createWebhookTables(db); err != nil {
+		return err
+	}
+	if err := createResponseTables(db); err != nil {
+		return err
+	}
+	if err := createRiskTables(db); err != nil {
+		return err
+	}
+	if err := createAnnotationTables(db); err != nil {
+		return err
+	}
+	if err := createTimelineAnnotationTables(db); err != nil {
+		return err
+	}
+	if err := createShareTables(db); err != nil {
+		return err
+	}
+	if err := createTagTables(db); err != nil {
+		return err
+	}
+	if err := createBulkTables(db); err != nil {
+		return err
+	}
+	if err := createSavedSearchTables(db); err != nil {
+		return err
+	}
+	if err := createQueryHistoryTables(db); err != nil {
+		return err
+	}
+	if err := createAPIKeyTables(db); err != nil {
+		return err
+	}
+	if err := createDataScopeTables(db); err != nil {
+		return err
+	}
+	if err := createFieldMaskTables(db); err != nil {
+		return err
+	}
+	if err := createUsageTables(db); err != nil {
+		return err
+	}
+	if err := createQuotaTables(db); err != nil {
+		return err
+	}
+	if err := createReplayTables(db); err != nil {
+		return err
+	}
+	if err := createIaCTables(db); err != nil {
+		return err
+	}
+	if err := createInboundWebhookTables(db); err != nil {
+		return err
+	}
+	if err := createIngestTokenTables(db); err != nil {
+		return err
+	}
+	if err := createOutputTables(db); err != nil {
+		return err
+	}
+	if err := createRouteTables(db); err != nil {
+		return err
+	}
+	if err := createWasmProcessorTables(db); err != nil {
+		return err
+	}
+	if err := createSummaryTables(db); err != nil {
+		return err
+	}
+	if err := createConsumerTables(db); err != nil {
+		return err
+	}
+	if err := createChangeTables(db); err != nil {
+		return err
+	}
+	if err := createAuditTables(db); err != nil {
+		return err
+	}
+	if err := createIngestBatchTables(db); err != nil {
+		return err
+	}
+	if err := createArchiveTables(db); err != nil {
+		return err
+	}
+	if err := createCompressionTables(db); err != nil {
+		return err
+	}
+	if err := createRawTables(db); err != nil {
+		return err
+	}
+	if err := createSchemaTables(db); err != nil {
+		return err
+	}
+	if err := createLevelTables(db); err != nil {
+		return err
+	}
+
+	// Create indexes for better performance
+	_, err = db.Exec(`CREATE INDEX IF NOT EXISTS idx_logs_timestamp ON logs(timestamp)`)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec(`CREATE INDEX IF NOT EXISTS idx_logs_level ON logs(level)`)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec(`CREATE INDEX IF NOT EXISTS idx_logs_rule ON logs(rule)`)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec(`CREATE INDEX IF NOT EXISTS idx_logs_source_ip ON logs(source_ip)`)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec(`CREATE INDEX IF NOT EXISTS idx_logs_event ON logs(event)`)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec(`CREATE INDEX IF NOT EXISTS idx_logs_destination_ip ON logs(destination_ip)`)
+	if err != nil {
+		return err
+	}
+
+	// Back user and trace ID point lookups
+	_, err = db.Exec(`CREATE INDEX IF NOT EXISTS idx_logs_user_name ON logs(user_name)`)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec(`CREATE INDEX IF NOT EXISTS idx_logs_trace_id ON logs(trace_id)`)
+	if err != nil {
+		return err
+	}
+
+	// Back the urgency and level-severity sort orders
+	_, err = db.Exec(`CREATE INDEX IF NOT EXISTS idx_logs_urgency_timestamp ON logs(urgency, timestamp)`)
+	return err
+}
+
+// addColumnIfMissing adds column to table unless PRAGMA table_info already lists it
+func addColumnIfMissing(db *sql.DB, table, column, definition string) error {
+	rows, err := db.Query(`PRAGMA table_info(` + table + `)`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var cid, notNull, pk int
+		var name, colType string
+		var dflt sql.NullString
+		if err := rows.Scan(&cid, &name, &colType, &notNull, &dflt, &pk); err != nil {
+			return err
+		}
+		if name == column {
+			return nil
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	rows.Close()
+	_, err = db.Exec(`ALTER TABLE ` + table + ` ADD COLUMN ` + column + ` ` + definition)
+	return err
+}
+
+// InsertLog routes a log and publishes it on the bus, where the
+// synchronous subscribers registered in subscribeIngest store and count it
+func (d *Database) InsertLog(log LogEntry) error {
+	return d.publishLog(log, nil)
+}
+
+// publishLog is InsertLog for a log that batch, when not nil, is committing
+func (d *Database) publishLog(log LogEntry, batch *batchPosition) error {
+	if keep, err := processIngest(&log, d.wasmIngestProcessors()...); err != nil || !keep {
+		return err
+	}
+	m := &IngestedLog{Entry: log, batch: batch}
+	d.route(m)
+	return d.bus.Publish(topicLogs, m)
+}
+
+// subscribeIngest registers the ingest steps, in order. Logs their route
+// keeps out of the local store are not counted either.
+func (d *Database) subscribeIngest() {
+	d.bus.Subscribe(topicLogs, "store", func(msg interface{}) error {
+		m := msg.(*IngestedLog)
+		if !m.Store {
+			return nil
+		}
+		return d.storeLog(&m.Entry, m.batch)
+	})
+	d.bus.Subscribe(topicLogs, "topk", func(msg interface{}) error {
+		m := msg.(*IngestedLog)
+		if m.Store {
+			d.topEvents.Add(m.Entry.Event, 1)
+			d.topSources.Add(m.Entry.SourceIP, 1)
+			d.topDestinations.Add(m.Entry.DestinationIP, 1)
+			d.rowCount.Add(1)
+		}
+		return nil
+	})
+	d.bus.Subscribe(topicLogs, "counters", func(msg interface{}) error {
+		if m := msg.(*IngestedLog); m.Store {
+			// Category is not stored at ingest, so the log counts under its rule's category
+			entry := m.Entry
+			entry.Category = ""
+			d.counters.add(entry, 1)
+		}
+		return nil
+	})
+	d.bus.Subscribe(topicLogs, "schemas", func(msg interface{}) error {
+		d.checkSchemas(msg.(*IngestedLog).Entry)
+		return nil
+	})
+	d.bus.Subscribe(topicLogs, "rollups", func(msg interface{}) error {
+		if m := msg.(*IngestedLog); m.Store {
+			return d.recordUniques(m.Entry)
+		}
+		return nil
+	})
+	d.bus.Subscribe(topicLogs, "notify", func(msg interface{}) error {
+		if m := msg.(*IngestedLog); m.Store {
+			d.logsChanged()
+			d.newLogs.notify()
+		}
+		return nil
+	})
+	// Creating a notable queries the database, so promotion runs off the
+	// ingest path
+	d.bus.SubscribeAsync(topicLogs, "notables", notablePromotionBuffer, d.promoteNotable)
+}
+
+// storeLog writes the log, setting its ID. Its ingest tags and raw payload,
+// and the progress of the batch committing it, go in the same transaction.
+// Timestamps are stored in UTC so range filters can compare them as text.
+func (d *Database) storeLog(log *LogEntry, batch *batchPosition) error {
+	tags := d.ingestTags(*log)
+	if len(tags) == 0 && log.Raw == nil && batch == nil {
+		// A lone insert commits by itself, without a transaction's overhead
+		return insertLogRow(d.insertLog, log)
+	}
+	tx, err := d.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err := insertLogRow(tx.Stmt(d.insertLog), log); err != nil {
+		return err
+	}
+	if err := tagLog(tx, log.ID, tags); err != nil {
+		return err
+	}
+	if log.Raw != nil {
+		if _, err := tx.Stmt(d.insertRaw).Exec(log.ID, log.RawFormat, log.Raw); err != nil {
+			return err
+		}
+	}
+	if batch != nil {
+		if _, err := tx.Exec(`UPDATE ingest_batches SET stored = ? WHERE id = ?`, batch.seq, batch.id); err != nil {
+			return err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	if batch != nil {
+		batch.recorded = true
+	}
+	return nil
+}
+
+// execer runs statements on the database or inside a transaction
+type execer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+func insertLogRow(insert *sql.Stmt, log *LogEntry) error {
+	res, err := insert.Exec(log.Timestamp.UTC(), log.Level, log.Rule, log.SourceIP, log.DestinationIP, log.Event, log.Description, log.Urgency, log.User,
+		log.SourcePort, log.DestinationPort, log.TraceID)
+	if err != nil {
+		return err
+	}
+	log.ID, err = res.LastInsertId()
+	return err
+}
+
+// logColumns is the column list every LogEntry query selects, in scanLogs order
+const logColumns = `id, timestamp, level, rule, source_ip, destination_ip, event, description, urgency, user_name,
+	source_port, destination_port, category, trace_id, description_z`
+
+func (d *Database) scanLogs(rows *sql.Rows) ([]LogEntry, error) {
+	var logs []LogEntry
+	var compressed []byte
+	for rows.Next() {
+		var log LogEntry
+		err := rows.Scan(&log.ID, &log.Timestamp, &log.Level, &log.Rule, &log.SourceIP, &log.DestinationIP, &log.Event, &log.Description, &log.Urgency, &log.User,
+			&log.SourcePort, &log.DestinationPort, &log.Category, &log.TraceID, &compressed)
+		if err != nil {
+			return nil, err
+		}
+		if compressed != nil {
+			if log.Description, err = d.descriptionDicts.decode(compressed); err != nil {
+				return nil, err
+			}
+		}
+		logs = append(logs, log)
+	}
+	return logs, rows.Err()
+}
+
+func (d *Database) GetLogs(limit int) ([]LogEntry, error) {
+	rows, err := d.db.Query(`
+		SELECT `+logColumns+`
+		FROM logs
+		ORDER BY timestamp DESC
+		LIMIT ?
+	`, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return d.scanLogs(rows)
+}
+
+// buildSearchQuery returns the SQL and arguments used by SearchLogs
+func buildSearchQuery(filter LogFilter, sort logSort, limit int) (string, []interface{}) {
+	clause, args := filter.where()
+	query := `
+		SELECT ` + logColumns + `
+		FROM logs
+		WHERE 1=1` + clause + sort.orderBy() + ` LIMIT ?`
+	return query, append(args, limit)
+}
+
+func (d *Database) SearchLogs(filter LogFilter, sort logSort, limit int) ([]LogEntry, error) {
+	query, args := buildSearchQuery(filter, sort, limit)
+	rows, err := d.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return d.scanLogs(rows)
+}
+
+// GetLog returns a single log entry by ID
+func (d *Database) GetLog(id int64) (LogEntry, error) {
+	rows, err := d.db.Query(`SELECT `+logColumns+` FROM logs WHERE id = ?`, id)
+	if err != nil {
+		return LogEntry{}, err
+	}
+	defer rows.Close()
+	logs, err := d.scanLogs(rows)
+	if err != nil {
+		return LogEntry{}, err
+	}
+	if len(logs) == 0 {
+		return LogEntry{}, sql.ErrNoRows
+	}
+	return logs[0], nil
+}
+
+func (d *Database) GetLogsByEvent(event string, limit int) ([]LogEntry, error) {
+	rows, err := d.db.Query(`
+		SELECT `+logColumns+`
+		FROM logs
+		WHERE event = ?
+		ORDER BY timestamp DESC
+		LIMIT ?
+	`, event, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return d.scanLogs(rows)
+}
+
+// Aggregation queries, kept as constants so they can be explained
+const (
+	// summaryStatsQuery and recentCountsQuery seed the dashboard counters
+	// at startup; see counters.go. recentCountsQuery takes the start of the
+	// 24 hour window.
+	summaryStatsQuery = `
+		SELECT level, rule, category, COUNT(*)
+		FROM logs
+		GROUP BY level, rule, category
+	`
+	recentCountsQuery = `
+		SELECT CAST(strftime('%s', timestamp) AS INTEGER) / 60 AS minute, rule, category, urgency, COUNT(*)
+		FROM logs
+		WHERE timestamp >= ?
+		GROUP BY minute, rule, category, urgency
+	`
+	topEventsQuery = `
+		SELECT event, COUNT(*) as count
+		FROM logs
+		GROUP BY event
+		ORDER BY count DESC
+		LIMIT 10
+	`
+	topSourcesQuery = `
+		SELECT source_ip, COUNT(*) as count
+		FROM logs
+		GROUP BY source_ip
+		ORDER BY count DESC
+		LIMIT 10
+	`
+
+	topDestinationsQuery = `
+		SELECT destination_ip, COUNT(*) as count
+		FROM logs
+		WHERE destination_ip != ''
+		GROUP BY destination_ip
+		ORDER BY count DESC
+		LIMIT 10
+	`
+)
+
+// category is the log's category override, or the one derived from its rule
+func (l LogEntry) category() string {
+	if l.Category != "" {
+		return l.Category
+	}
+	return categorizeRule(l.Rule)
+}
+
+// categorizeRule maps a rule name to access, network, threat or uba (simplified logic)
+func categorizeRule(rule string) string {
+	rule = strings.ToLower(rule)
+	switch {
+	case strings.Contains(rule, "login") || strings.Contains(rule, "access"):
+		return "access"
+	case strings.Contains(rule, "network") || strings.Contains(rule, "traffic"):
+		return "network"
+	case strings.Contains(rule, "threat") || strings.Contains(rule, "malware"):
+		return "threat"
+	case strings.Contains(rule, "behavior") || strings.Contains(rule, "uba"):
+		return "uba"
+	default:
+		// Default to access for unknown rules
+		return "access"
+	}
+}
+
+func (d *Database) GetSummaryStats() (SummaryStats, error) {
+	categories := d.counters.snapshot(d.counters.categories)
+	tile := func(category string) StatTile {
+		return StatTile{Total: int(categories[category]), Search: searchURL(LogFilter{Category: stringList{category}})}
+	}
+	stats := SummaryStats{
+		AccessNotables:  tile("access"),
+		NetworkNotables: tile("network"),
+		ThreatNotables:  tile("threat"),
+		UBANotables:     tile("uba"),
+	}
+	return stats, nil
+}
+
+// GetUrgencyData counts the last 24 hours of logs by urgency
+func (d *Database) GetUrgencyData() (UrgencyData, error) {
+	var counts [maxUrgency + 1]int64
+	now := d.now().Unix() / 60
+	d.counters.mu.RLock()
+	for minute := now - counterMinutes + 1; minute <= now; minute++ {
+		slot := d.counters.minute(minute)
+		for urgency, n := range slot.urgency {
+			counts[urgency] += n
+		}
+	}
+	d.counters.mu.RUnlock()
+	data := UrgencyData{
+		Critical:  int(counts[4]),
+		High:      int(counts[3]),
+		Medium:    int(counts[2]),
+		Low:       int(counts[1]),
+		Urgencies: []UrgencyCount{},
+	}
+	// The counted minutes
+	from, to := time.Unix((now-counterMinutes+1)*60, 0), time.Unix((now+1)*60, 0)
+	urgencies := d.levels.urgencyList()
+	for i := len(urgencies) - 1; i >= 0; i-- {
+		search := searchURL(LogFilter{Urgency: stringList{strconv.Itoa(urgencies[i].Value)}, From: from, To: to})
+		data.Urgencies = append(data.Urgencies, UrgencyCount{UrgencyLevel: urgencies[i], Count: int(counts[urgencies[i].Value]), Search: search})
+	}
+	return data, nil
+}
+
+// GetTimelineData counts the logs per category in the minute one, two, ...
+// 23 hours ago and now, labelled with the local time in loc. UBA logs are
+// shown as access, as the chart has no UBA series.
+func (d *Database) GetTimelineData(loc *time.Location) (TimelineData, error) {
+	labels := []string{}
+	times := []time.Time{}
+	accessData := []int{}
+	networkData := []int{}
+	threatData := []int{}
+	var accessSearches, networkSearches, threatSearches []string
+
+	now := d.now()
+	d.counters.mu.RLock()
+	for i := 23; i >= 0; i-- {
+		hour := now.Add(-time.Duration(i) * time.Hour)
+		labels = append(labels, hour.In(loc).Format("15:04"))
+		times = append(times, hour.Truncate(time.Minute).UTC())
+		slot := d.counters.minute(hour.Unix() / 60)
+		accessData = append(accessData, int(slot.categories[0]+slot.categories[3]))
+		networkData = append(networkData, int(slot.categories[1]))
+		threatData = append(threatData, int(slot.categories[2]))
+		from := times[len(times)-1]
+		search := func(categories ...string) string {
+			return searchURL(LogFilter{Category: categories, From: from, To: from.Add(time.Minute)})
+		}
+		accessSearches = append(accessSearches, search("access", "uba"))
+		networkSearches = append(networkSearches, search("network"))
+		threatSearches = append(threatSearches, search("threat"))
+	}
+	d.counters.mu.RUnlock()
+
+	data := TimelineData{
+		Labels: labels,
+		Times:  times,
+		TZ:     loc.String(),
+		Series: []TimelineSeries{
+			{Name: "Access", Data: accessData, Color: "#3B82F6", Searches: accessSearches},
+			{Name: "Network", Data: networkData, Color: "#10B981", Searches: networkSearches},
+			{Name: "Threat", Data: threatData, Color: "#EF4444", Searches: threatSearches},
+		},
+	}
+	var err error
+	data.Annotations, err = d.chartAnnotations(times[0], times[len(times)-1].Add(time.Minute))
+	return data, err
+}
+
+// mockSparkline generates placeholder sparkline points around count/10
+func mockSparkline(count int) []int {
+	sparkline := []int{}
+	for i := 0; i < 10; i++ {
+		sparkline = append(sparkline, count/10+rand.Intn(5))
+	}
+	return sparkline
+}
+
+func (d *Database) GetTopEvents() ([]TopEvent, error) {
+	// Large stores answer from the streaming tracker instead of a full GROUP BY
+	if d.rowCount.Load() >= topKExactThreshold {
+		var events []TopEvent
+		for _, c := range d.topEvents.Top(10) {
+			events = append(events, TopEvent{
+				RuleName:  c.Key,
+				Sparkline: mockSparkline(c.Count),
+				Count:     c.Count,
+				Urgency:   "medium",
+				Search:    eventSearch(c.Key),
+			})
+		}
+		return events, nil
+	}
+
+	rows, err := d.db.Query(topEventsQuery)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var events []TopEvent
+	for rows.Next() {
+		var event TopEvent
+		err := rows.Scan(&event.RuleName, &event.Count)
+		if err != nil {
+			return nil, err
+		}
+		event.Sparkline = mockSparkline(event.Count)
+		event.Urgency = "medium" // Default urgency
+		event.Search = eventSearch(event.RuleName)
+		events = append(events, event)
+	}
+	return events, nil
+}
+
+func (d *Database) GetTopSources() ([]TopSource, error) {
+	if d.rowCount.Load() >= topKExactThreshold {
+		var sources []TopSource
+		for _, c := range d.topSources.Top(10) {
+			sources = append(sources, TopSource{SourceIP: c.Key, Count: c.Count, Search: sourceSearch(c.Key)})
+		}
+		return sources, nil
+	}
+
+	rows, err := d.db.Query(topSourcesQuery)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var sources []TopSource
+	for rows.Next() {
+		var source TopSource
+		err := rows.Scan(&source.SourceIP, &source.Count)
+		if err != nil {
+			return nil, err
+		}
+		source.Search = sourceSearch(source.SourceIP)
+		sources = append(sources, source)
+	}
+	return sources, nil
+}
+
+func (d *Database) GetTopDestinations() ([]TopDestination, error) {
+	destinations := []TopDestination{}
+	if d.rowCount.Load() >= topKExactThreshold {
+		// Logs without a destination are tracked too, so take one extra and skip them
+		for _, c := range d.topDestinations.Top(11) {
+			if c.Key != "" && len(destinations) < 10 {
+				destinations = append(destinations, TopDestination{DestinationIP: c.Key, Count: c.Count, Search: destinationSearch(c.Key)})
+			}
+		}
+		return destinations, nil
+	}
+
+	rows, err := d.db.Query(topDestinationsQuery)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var destination TopDestination
+		if err := rows.Scan(&destination.DestinationIP, &destination.Count); err != nil {
+			return nil, err
+		}
+		destination.Search = destinationSearch(destination.DestinationIP)
+		destinations = append(destinations, destination)
+	}
+	return destinations, rows.Err()
+}
+
+// QueryPlanStep is a single row of SQLite's EXPLAIN QUERY PLAN output
+type QueryPlanStep struct {
+	ID     int    `json:"id"`
+	Parent int    `json:"parent"`
+	Detail string `json:"detail"`
+}
+
+// QueryExplanation describes the SQL behind a request and how SQLite plans to run it
+type QueryExplanation struct {
+	SQL  string          `json:"sql"`
+	Args []interface{}   `json:"args"`
+	Plan []QueryPlanStep `json:"plan"`
+}
+
+func (d *Database) Explain(query string, args ...interface{}) (QueryExplanation, error) {
+	explanation := QueryExplanation{SQL: strings.TrimSpace(query), Args: args}
+	if explanation.Args == nil {
+		explanation.Args = []interface{}{}
+	}
+	rows, err := d.db.Query("EXPLAIN QUERY PLAN "+query, args...)
+	if err != nil {
+		return explanation, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var step QueryPlanStep
+		var notUsed int
+		if err := rows.Scan(&step.ID, &step.Parent, &notUsed, &step.Detail); err != nil {
+			return explanation, err
+		}
+		explanation.Plan = append(explanation.Plan, step)
+	}
+	return explanation, rows.Err()
+}
+
+func (d *Database) Close() error {
+	d.closeIngestQueue()
+	close(d.done)
+	// The loops write to the database, so they must stop before it closes
+	d.loops.Wait()
+	d.stopOutputs()
+	d.flushRollups()
+	d.flushCredentialUses()
+	d.flushUsage()
+	d.flushQuotaUsage()
+	d.readOnly.Close()
+	d.insertLog.Close()
+	d.insertRaw.Close()
+	return d.db.Close()
+}
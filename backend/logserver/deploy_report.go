@@ -0,0 +1,374 @@
+package logserver
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Deploy correlation. Each deploy annotation is judged by the logs around
+// it: the window after the deploy is compared with the window of the same
+// length before it, by the rate of errors and warnings and by the event
+// patterns that appear. An event's pattern is the event with its numbers,
+// addresses, IDs and quoted values blanked, so "timeout after 31ms" and
+// "timeout after 4ms" are one pattern. A pattern is new when the baseline
+// before the deploy never had it. A deploy is flagged as degraded when its
+// error or warning rate grows by the factor, or it brings new patterns at
+// warning level or worse, in each case with at least min logs.
+
+// DeployReport is the deploy correlation of a time range, newest deploy
+// first
+type DeployReport struct {
+	From            time.Time          `json:"from"`
+	To              time.Time          `json:"to"`
+	WindowSeconds   int64              `json:"windowSeconds"`
+	BaselineSeconds int64              `json:"baselineSeconds"`
+	Deploys         []DeployComparison `json:"deploys"`
+}
+
+// DeployComparison compares the logs before and after one deploy
+type DeployComparison struct {
+	Annotation  TimelineAnnotation `json:"annotation"`
+	Before      DeployWindow       `json:"before"`
+	After       DeployWindow       `json:"after"`
+	NewPatterns []DeployPattern    `json:"newPatterns"`
+	Degraded    bool               `json:"degraded"`
+	Reasons     []string           `json:"reasons"`
+}
+
+// DeployWindow counts the logs of a window beside a deploy. Partial is set
+// while the window after a recent deploy has not ended yet; its rates are
+// over the part that has passed.
+type DeployWindow struct {
+	From              time.Time `json:"from"`
+	To                time.Time `json:"to"`
+	Partial           bool      `json:"partial,omitempty"`
+	Logs              int       `json:"logs"`
+	Errors            int       `json:"errors"`
+	Warnings          int       `json:"warnings"`
+	ErrorsPerMinute   float64   `json:"errorsPerMinute"`
+	WarningsPerMinute float64   `json:"warningsPerMinute"`
+	Search            string    `json:"search"`
+	ErrorSearch       string    `json:"errorSearch,omitempty"`
+}
+
+// DeployPattern is an event pattern first seen after a deploy. Level is
+// the most severe level it was logged at, and Example one of its events.
+type DeployPattern struct {
+	Pattern string `json:"pattern"`
+	Example string `json:"example"`
+	Level   string `json:"level"`
+	Count   int    `json:"count"`
+	Search  string `json:"search,omitempty"`
+}
+
+// deployReportOptions are the knobs of a deploy report
+type deployReportOptions struct {
+	window, baseline time.Duration
+	factor           float64
+	min              int
+	// filter selects the logs compared; its time range is ignored
+	filter LogFilter
+}
+
+const (
+	// maxDeployReports caps the deploys of one report
+	maxDeployReports = 50
+	// maxDeployPatterns caps the new patterns listed per deploy
+	maxDeployPatterns = 20
+	// maxDeployBaseline caps how far back new patterns are looked for
+	maxDeployBaseline = 7 * 24 * time.Hour
+)
+
+var defaultDeployReportOptions = deployReportOptions{
+	window:   30 * time.Minute,
+	baseline: 24 * time.Hour,
+	factor:   2,
+	min:      5,
+}
+
+// eventPatternRules blank the variable parts of an event, in order
+var eventPatternRules = []struct {
+	pattern     *regexp.Regexp
+	placeholder string
+}{
+	{regexp.MustCompile(`"[^"]*"|'[^']*'`), "<str>"},
+	{regexp.MustCompile(`\b[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}\b`), "<uuid>"},
+	{regexp.MustCompile(`\b\d{1,3}(?:\.\d{1,3}){3}(?::\d+)?\b`), "<ip>"},
+	{regexp.MustCompile(`\b0x[0-9a-fA-F]+\b|\b[0-9a-fA-F]{8,}\b`), "<hex>"},
+	{regexp.MustCompile(`\d+(?:\.\d+)?`), "<num>"},
+}
+
+// eventPattern returns the pattern of an event
+func eventPattern(event string) string {
+	for _, rule := range eventPatternRules {
+		event = rule.pattern.ReplaceAllString(event, rule.placeholder)
+	}
+	return event
+}
+
+// windowStats holds the logs of a window by event and level
+type windowStats struct {
+	logs, errors, warnings int
+	patterns               map[string]*DeployPattern
+}
+
+// GetDeployReport compares the logs around the deploys starting within
+// from..to, newest first
+func (d *Database) GetDeployReport(from, to time.Time, deployTag string, opts deployReportOptions) (DeployReport, error) {
+	report := DeployReport{
+		From:            from,
+		To:              to,
+		WindowSeconds:   int64(opts.window / time.Second),
+		BaselineSeconds: int64(opts.baseline / time.Second),
+		Deploys:         []DeployComparison{},
+	}
+	deploys, err := d.GetTimelineAnnotations(TimelineAnnotationFilter{From: from, To: to, Kind: annotationDeploy, Tag: deployTag, Limit: maxDeployReports})
+	if err != nil {
+		return report, err
+	}
+	now := d.now()
+	for _, deploy := range deploys {
+		if deploy.Start.Before(from) || deploy.Start.After(now) {
+			continue
+		}
+		comparison, err := d.compareDeploy(deploy, now, opts)
+		if err != nil {
+			return report, err
+		}
+		report.Deploys = append(report.Deploys, comparison)
+	}
+	return report, nil
+}
+
+// compareDeploy compares the logs before and after deploy
+func (d *Database) compareDeploy(deploy TimelineAnnotation, now time.Time, opts deployReportOptions) (DeployComparison, error) {
+	start := deploy.Start
+	c := DeployComparison{
+		Annotation:  deploy,
+		Before:      DeployWindow{From: start.Add(-opts.window), To: start},
+		After:       DeployWindow{From: start, To: start.Add(opts.window)},
+		NewPatterns: []DeployPattern{},
+		Reasons:     []string{},
+	}
+	if c.After.To.After(now) {
+		c.After.To, c.After.Partial = now, true
+	}
+	before, err := d.windowStats(opts.filter, c.Before.From, c.Before.To)
+	if err != nil {
+		return c, err
+	}
+	after, err := d.windowStats(opts.filter, c.After.From, c.After.To)
+	if err != nil {
+		return c, err
+	}
+	c.Before.fill(before, opts.filter, &d.levels)
+	c.After.fill(after, opts.filter, &d.levels)
+
+	// Patterns seen in the baseline are not new, wherever in it they were
+	seen := before.patterns
+	if opts.baseline > opts.window {
+		earlier, err := d.windowStats(opts.filter, start.Add(-opts.baseline), c.Before.From)
+		if err != nil {
+			return c, err
+		}
+		for pattern := range earlier.patterns {
+			seen[pattern] = nil
+		}
+	}
+	for pattern, p := range after.patterns {
+		if _, ok := seen[pattern]; ok {
+			continue
+		}
+		f := opts.filter
+		f.Event, f.Match, f.CaseSensitive, f.From, f.To = p.Example, matchExact, true, c.After.From, c.After.To
+		p.Search = searchURL(f)
+		c.NewPatterns = append(c.NewPatterns, *p)
+	}
+	sort.Slice(c.NewPatterns, func(i, j int) bool {
+		a, b := c.NewPatterns[i], c.NewPatterns[j]
+		if a.Count != b.Count {
+			return a.Count > b.Count
+		}
+		return a.Pattern < b.Pattern
+	})
+
+	warnSeverity := d.levels.severity("WARN")
+	if opts.grew(after.errors, c.Before.ErrorsPerMinute, c.After.ErrorsPerMinute) {
+		c.Reasons = append(c.Reasons, fmt.Sprintf("errors rose from %.2f to %.2f a minute", c.Before.ErrorsPerMinute, c.After.ErrorsPerMinute))
+	}
+	if opts.grew(after.warnings, c.Before.WarningsPerMinute, c.After.WarningsPerMinute) {
+		c.Reasons = append(c.Reasons, fmt.Sprintf("warnings rose from %.2f to %.2f a minute", c.Before.WarningsPerMinute, c.After.WarningsPerMinute))
+	}
+	severe := 0
+	for _, p := range c.NewPatterns {
+		if d.levels.severity(p.Level) >= warnSeverity && p.Count >= opts.min {
+			severe++
+		}
+	}
+	switch {
+	case severe == 1:
+		c.Reasons = append(c.Reasons, "1 new warning or error pattern")
+	case severe > 1:
+		c.Reasons = append(c.Reasons, fmt.Sprintf("%d new warning or error patterns", severe))
+	}
+	c.Degraded = len(c.Reasons) > 0
+	if len(c.NewPatterns) > maxDeployPatterns {
+		c.NewPatterns = c.NewPatterns[:maxDeployPatterns]
+	}
+	return c, nil
+}
+
+// grew reports whether a rate grew enough to flag a deploy. A rate that was
+// zero before grows by any factor.
+func (o deployReportOptions) grew(afterCount int, beforeRate, afterRate float64) bool {
+	return afterCount >= o.min && afterRate > beforeRate && afterRate >= beforeRate*o.factor
+}
+
+// fill sets the counts, rates and searches of w from stats. The error search
+// takes the levels at least as severe as ERROR from levels.
+func (w *DeployWindow) fill(stats windowStats, filter LogFilter, levels *levelConfig) {
+	w.Logs, w.Errors, w.Warnings = stats.logs, stats.errors, stats.warnings
+	if minutes := w.To.Sub(w.From).Minutes(); minutes > 0 {
+		w.ErrorsPerMinute = math.Round(float64(stats.errors)/minutes*100) / 100
+		w.WarningsPerMinute = math.Round(float64(stats.warnings)/minutes*100) / 100
+	}
+	filter.From, filter.To = w.From, w.To
+	w.Search = searchURL(filter)
+	if len(filter.Level) == 0 {
+		filter.Level = levels.severeLevels(levels.severity("ERROR"))
+		w.ErrorSearch = searchURL(filter)
+	}
+}
+
+// severeLevels returns the configured levels at least as severe as severity
+func (c *levelConfig) severeLevels(severity int) stringList {
+	var levels stringList
+	for _, l := range c.levelList() {
+		if l.Severity >= severity {
+			levels = append(levels, l.Name)
+		}
+	}
+	return levels
+}
+
+// windowStats counts the logs filter matches within from..to by level and
+// event pattern
+func (d *Database) windowStats(filter LogFilter, from, to time.Time) (windowStats, error) {
+	stats := windowStats{patterns: map[string]*DeployPattern{}}
+	filter.From, filter.To = from, to
+	clause, args := filter.where()
+	rows, err := d.db.Query(`SELECT event, upper(level), COUNT(*) FROM logs WHERE 1=1`+clause+` GROUP BY event, upper(level)`, args...)
+	if err != nil {
+		return stats, err
+	}
+	defer rows.Close()
+	errorSeverity, warnSeverity := d.levels.severity("ERROR"), d.levels.severity("WARN")
+	for rows.Next() {
+		var event, level string
+		var count int
+		if err := rows.Scan(&event, &level, &count); err != nil {
+			return stats, err
+		}
+		severity := d.levels.severity(level)
+		stats.logs += count
+		switch {
+		case severity >= errorSeverity:
+			stats.errors += count
+		case severity >= warnSeverity:
+			stats.warnings += count
+		}
+		pattern := eventPattern(event)
+		p := stats.patterns[pattern]
+		if p == nil {
+			p = &DeployPattern{Pattern: pattern, Example: event, Level: level}
+			stats.patterns[pattern] = p
+		}
+		p.Count += count
+		if severity > d.levels.severity(p.Level) {
+			p.Level, p.Example = level, event
+		}
+	}
+	return stats, rows.Err()
+}
+
+// parseDeployReportOptions reads the window, baseline, factor and min
+// parameters and the log filter
+func parseDeployReportOptions(r *http.Request, now time.Time) (deployReportOptions, error) {
+	q := r.URL.Query()
+	opts := defaultDeployReportOptions
+	for key, target := range map[string]*time.Duration{"window": &opts.window, "baseline": &opts.baseline} {
+		if v := q.Get(key); v != "" {
+			d, err := parseRelativeDuration(v)
+			if err != nil {
+				return opts, fmt.Errorf("Invalid %s %q", key, v)
+			}
+			*target = d
+		}
+	}
+	switch {
+	case opts.window < time.Minute:
+		return opts, errors.New("window must be at least 1m")
+	case opts.baseline > maxDeployBaseline:
+		return opts, errors.New("baseline must be at most 7d")
+	}
+	if v := q.Get("factor"); v != "" {
+		factor, err := strconv.ParseFloat(v, 64)
+		if err != nil || factor < 1 || math.IsInf(factor, 0) {
+			return opts, errors.New("factor must be a number of at least 1")
+		}
+		opts.factor = factor
+	}
+	if v := q.Get("min"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return opts, errors.New("min must be a positive integer")
+		}
+		opts.min = n
+	}
+	// The log search parameters scope the logs compared. from, to, since
+	// and range select the deploys instead, and tag is a log tag.
+	logParams := r.URL.Query()
+	for _, key := range []string{"from", "to", "since", "range"} {
+		logParams.Del(key)
+	}
+	filter, err := parseLogFilter(logParams, now)
+	if err != nil {
+		return opts, err
+	}
+	opts.filter = filter
+	return opts, nil
+}
+
+// GET /api/timeline/deploys?from=&to=&deploy_tag=&window=30m&baseline=24h&factor=2&min=5 - compare the logs before and after each deploy
+func deployReportHandlerDB(w http.ResponseWriter, r *http.Request, db *Database) {
+	enableCORS(w)
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != http.MethodGet {
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	now := db.now()
+	from, to, err := parseTimeRange(r, now, 7*24*time.Hour)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	opts, err := parseDeployReportOptions(r, now)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	report, err := db.GetDeployReport(from, to, strings.TrimSpace(r.URL.Query().Get("deploy_tag")), opts)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "Failed to build deploy report")
+		return
+	}
+	writeEncoded(w, r, report)
+}
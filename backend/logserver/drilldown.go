@@ -0,0 +1,68 @@
+package logserver
+
+import (
+	"strings"
+	"time"
+)
+
+// Drill-down. The buckets, bars and points of the dashboard aggregations
+// carry the /api/logs search that lists the logs they count, so the UI or a
+// Grafana panel turns a click into that exact search instead of rebuilding
+// it. The query string is the canonical filter: parameters are sorted and
+// time ranges are absolute. A bucket no search can express, such as logs
+// without a source, has none.
+
+// searchURL returns the /api/logs URL listing the logs f matches. Search
+// URLs carry RFC3339 seconds, so the time range is widened to whole seconds.
+func searchURL(f LogFilter) string {
+	f.From = f.From.Truncate(time.Second)
+	if to := f.To.Truncate(time.Second); to.Before(f.To) {
+		f.To = to.Add(time.Second)
+	}
+	return "/api/logs?" + f.values().Encode()
+}
+
+// exactValue returns value as an exact-match list, or false when a list
+// parameter cannot carry it: they drop empty values, split on commas and
+// trim spaces
+func exactValue(value string) (stringList, bool) {
+	if value == "" || strings.Contains(value, ",") || strings.TrimSpace(value) != value {
+		return nil, false
+	}
+	return stringList{value}, true
+}
+
+// eventSearch returns the search for logs whose event is exactly event
+func eventSearch(event string) string {
+	if event == "" {
+		return ""
+	}
+	return searchURL(LogFilter{Event: event, Match: matchExact, CaseSensitive: true})
+}
+
+// sourceSearch returns the search for logs from source
+func sourceSearch(source string) string {
+	list, ok := exactValue(source)
+	if !ok {
+		return ""
+	}
+	return searchURL(LogFilter{Source: list})
+}
+
+// destinationSearch returns the search for logs to destination
+func destinationSearch(destination string) string {
+	list, ok := exactValue(destination)
+	if !ok {
+		return ""
+	}
+	return searchURL(LogFilter{Destination: list})
+}
+
+// ruleSearch returns the search for the logs of rule
+func ruleSearch(rule string) string {
+	list, ok := exactValue(rule)
+	if !ok {
+		return ""
+	}
+	return searchURL(LogFilter{Rule: list})
+}
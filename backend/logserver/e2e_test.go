@@ -0,0 +1,592 @@
+package logserver
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// End-to-end flows. Each test starts a harness on an empty database and
+// drives it over HTTP the way a client would: ingest, then search, the
+// dashboards, alerts and replays.
+
+// harness runs the whole server in process: the router with every endpoint,
+// a database in a temporary directory and the ingest pipeline, behind an
+// httptest server. Its clock is a ManualClock, so a test can ingest logs,
+// move time on and evaluate alerts without sleeping. Background loops that
+// only run on a timer, such as the alert evaluator, are not started; call
+// evaluateAlerts instead. Each harness has its own server state, so
+// harnesses do not share ingest tokens, quotas or levels.
+type harness struct {
+	t     *testing.T
+	url   string
+	clock *ManualClock
+	// adminKey is an admin API key that do sends with every request
+	adminKey string
+
+	db     *Database
+	server *httptest.Server
+}
+
+// harnessStart is the time a harness's clock starts at
+var harnessStart = time.Date(2025, 1, 6, 9, 0, 0, 0, time.UTC)
+
+// newHarness starts a server on an empty database, stopped when the test ends
+func newHarness(t *testing.T) *harness {
+	t.Helper()
+	if err := loadMessageCatalogs(); err != nil {
+		t.Fatal(err)
+	}
+	clock := NewManualClock(harnessStart)
+	db, err := openDatabase(filepath.Join(t.TempDir(), "logs.db"), clock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	_, key, err := db.CreateAPIKey(APIKey{Name: "harness", Role: roleAdmin})
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(NewRouter(db))
+	t.Cleanup(server.Close)
+	return &harness{t: t, url: server.URL, clock: clock, adminKey: key, db: db, server: server}
+}
+
+// do sends a request with in, unless nil, as its JSON body, and decodes a
+// JSON response into out, unless nil. A status of 400 and above fails the
+// test with the response body.
+func (h *harness) do(method, path string, in, out interface{}) int {
+	h.t.Helper()
+	var body io.Reader
+	if in != nil {
+		data, err := json.Marshal(in)
+		if err != nil {
+			h.t.Fatal(err)
+		}
+		body = bytes.NewReader(data)
+	}
+	req, err := http.NewRequest(method, h.url+path, body)
+	if err != nil {
+		h.t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+h.adminKey)
+	resp, err := h.server.Client().Do(req)
+	if err != nil {
+		h.t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		h.t.Fatal(err)
+	}
+	if resp.StatusCode >= 400 {
+		h.t.Fatalf("%s %s: %d %s", method, path, resp.StatusCode, bytes.TrimSpace(data))
+	}
+	if out != nil && len(data) > 0 {
+		if err := json.Unmarshal(data, out); err != nil {
+			h.t.Fatalf("%s %s: decoding response: %v", method, path, err)
+		}
+	}
+	return resp.StatusCode
+}
+
+// anonymous sends a request with in, unless nil, as its JSON body and no
+// credential, and returns the response status
+func (h *harness) anonymous(method, path string, in interface{}) int {
+	h.t.Helper()
+	data, err := json.Marshal(in)
+	if err != nil {
+		h.t.Fatal(err)
+	}
+	req, err := http.NewRequest(method, h.url+path, bytes.NewReader(data))
+	if err != nil {
+		h.t.Fatal(err)
+	}
+	resp, err := h.server.Client().Do(req)
+	if err != nil {
+		h.t.Fatal(err)
+	}
+	resp.Body.Close()
+	return resp.StatusCode
+}
+
+// ingest posts logs as one JSON batch to POST /api/logs. Logs without a
+// timestamp are stamped with the harness clock's time.
+func (h *harness) ingest(logs ...LogEntry) {
+	h.t.Helper()
+	if status := h.do(http.MethodPost, "/api/logs", logs, nil); status != http.StatusCreated {
+		h.t.Fatalf("POST /api/logs: status %d", status)
+	}
+}
+
+// search runs GET /api/logs with the query parameters in query
+func (h *harness) search(query url.Values) []LogEntry {
+	h.t.Helper()
+	var logs []LogEntry
+	h.do(http.MethodGet, "/api/logs?"+query.Encode(), nil, &logs)
+	return logs
+}
+
+// count runs GET /api/logs with count_only for the query parameters in query
+func (h *harness) count(query url.Values) int {
+	h.t.Helper()
+	q := url.Values{"count_only": {"true"}}
+	for k, v := range query {
+		q[k] = v
+	}
+	var count int
+	h.do(http.MethodGet, "/api/logs?"+q.Encode(), nil, &count)
+	return count
+}
+
+// evaluateAlerts runs one alert evaluation at the harness clock's time, as
+// the server's evaluator does every alertEvaluationInterval
+func (h *harness) evaluateAlerts() {
+	h.t.Helper()
+	if err := h.db.EvaluateAlerts(h.clock.Now()); err != nil {
+		h.t.Fatal(err)
+	}
+}
+
+// waitFor calls done until it reports true, or fails the test after
+// timeout of real time; it is for work the server finishes in the
+// background
+func (h *harness) waitFor(what string, timeout time.Duration, done func() bool) {
+	h.t.Helper()
+	deadline := time.Now().Add(timeout)
+	for !done() {
+		if time.Now().After(deadline) {
+			h.t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// expect fails the test when got is not want
+func expect(t *testing.T, what string, got, want interface{}) {
+	t.Helper()
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("%s: got %v, want %v", what, got, want)
+	}
+}
+
+func TestE2EIngestSearch(t *testing.T) {
+	h := newHarness(t)
+	h.ingest(
+		LogEntry{Level: "ERROR", Rule: "Brute Force Login", SourceIP: "10.0.0.5", Event: "Failed login", Description: "Failed password for root", Urgency: 3, User: "root"},
+		LogEntry{Level: "INFO", Rule: "Outbound Network Traffic", SourceIP: "10.0.0.5", DestinationIP: "52.96.0.10", Event: "Outbound transfer", Description: "Uploaded 20 KB", Urgency: 1},
+		LogEntry{Level: "WARN", Rule: "Brute Force Login", SourceIP: "10.0.0.9", Event: "Failed login", Description: "Failed password for admin", Urgency: 2, User: "admin"},
+	)
+	logs := h.search(url.Values{"ip": {"10.0.0.5"}})
+	expect(t, "logs from 10.0.0.5", len(logs), 2)
+	expect(t, "logs stamped on receipt", logs[0].Timestamp.UTC(), h.clock.Now())
+	count := h.count(url.Values{"rule": {"Brute Force Login"}, "level": {"ERROR,WARN"}})
+	expect(t, "brute force logs", count, 2)
+
+	// A log ingested ten minutes later is the only one in the last five
+	h.clock.Advance(10 * time.Minute)
+	h.ingest(LogEntry{Level: "INFO", Rule: "Internal Network Traffic", SourceIP: "10.0.0.7", Event: "SMB connection", Description: "Connection to 10.0.1.4:445", Urgency: 1})
+	// Relative ranges end at now, exclusive
+	h.clock.Advance(time.Second)
+	logs = h.search(url.Values{"since": {"5m"}})
+	expect(t, "logs in the last 5 minutes", len(logs), 1)
+	expect(t, "newest log", logs[0].SourceIP, "10.0.0.7")
+}
+
+func TestE2ESearchIPMatch(t *testing.T) {
+	h := newHarness(t)
+	h.ingest(
+		LogEntry{Level: "INFO", SourceIP: "10.0.0.1", Event: "Login"},
+		LogEntry{Level: "INFO", SourceIP: "10.0.0.10", Event: "Login"},
+		LogEntry{Level: "INFO", SourceIP: "10.0.0.2", DestinationIP: "10.0.0.1", Event: "Connection"},
+	)
+	// ip is a substring of either IP unless match=exact
+	expect(t, "ip", h.count(url.Values{"ip": {"10.0.0.1"}}), 3)
+	expect(t, "ip with match=exact", h.count(url.Values{"ip": {"10.0.0.1"}, "match": {"exact"}}), 2)
+	expect(t, "source", h.count(url.Values{"source": {"10.0.0.1"}}), 1)
+}
+
+func TestE2EAggregate(t *testing.T) {
+	h := newHarness(t)
+	var logs []LogEntry
+	for i := 0; i < 3; i++ {
+		logs = append(logs, LogEntry{Level: "WARN", Rule: "Brute Force Login", SourceIP: "203.0.113.7", Event: "Failed login", Description: "Failed password", Urgency: 2})
+	}
+	logs = append(logs,
+		LogEntry{Level: "INFO", Rule: "Outbound Network Traffic", SourceIP: "10.0.4.2", Event: "Outbound transfer", Description: "Uploaded 40 KB", Urgency: 1},
+		LogEntry{Level: "INFO", Rule: "Outbound Network Traffic", SourceIP: "10.0.4.2", Event: "Outbound transfer", Description: "Uploaded 60 KB", Urgency: 1},
+		LogEntry{Level: "ERROR", Rule: "Malware Threat Detected", SourceIP: "10.0.4.3", Event: "Signature match", Description: "Trojan found", Urgency: 4},
+	)
+	h.ingest(logs...)
+	var summary SummaryStats
+	h.do(http.MethodGet, "/api/summary", nil, &summary)
+	tiles := []int{summary.AccessNotables.Total, summary.NetworkNotables.Total, summary.ThreatNotables.Total, summary.UBANotables.Total}
+	expect(t, "access, network, threat and UBA tiles", tiles, []int{3, 2, 1, 0})
+	var urgency UrgencyData
+	h.do(http.MethodGet, "/api/urgency", nil, &urgency)
+	counts := []int{urgency.Critical, urgency.High, urgency.Medium, urgency.Low}
+	expect(t, "critical, high, medium and low counts", counts, []int{1, 0, 3, 2})
+	var groups []GroupCount
+	h.do(http.MethodGet, "/api/logs?group_by=source", nil, &groups)
+	expect(t, "logs per source", groups, []GroupCount{{"203.0.113.7", 3}, {"10.0.4.2", 2}, {"10.0.4.3", 1}})
+
+	// A day later the urgency window is empty but the totals remain
+	h.clock.Advance(25 * time.Hour)
+	h.do(http.MethodGet, "/api/urgency", nil, &urgency)
+	expect(t, "critical logs in the last 24 hours", urgency.Critical, 0)
+	h.do(http.MethodGet, "/api/summary", nil, &summary)
+	expect(t, "access tile", summary.AccessNotables.Total, 3)
+}
+
+func TestE2EAlertFireResolve(t *testing.T) {
+	h := newHarness(t)
+	var rule AlertRule
+	h.do(http.MethodPost, "/api/alerts/rules", AlertRule{
+		Name:          "SSH brute force",
+		Filter:        LogFilter{Rule: stringList{"Brute Force Login"}},
+		Threshold:     3,
+		WindowSeconds: 300,
+		Enabled:       true,
+	}, &rule)
+	failedLogin := LogEntry{Level: "WARN", Rule: "Brute Force Login", SourceIP: "203.0.113.9", Event: "Failed login", Description: "Failed password", Urgency: 2}
+	history := func() []AlertInstance {
+		var instances []AlertInstance
+		h.do(http.MethodGet, fmt.Sprintf("/api/alerts/history?rule_id=%d", rule.ID), nil, &instances)
+		return instances
+	}
+
+	// Two failures stay under the threshold. Windows end at the evaluation
+	// time, exclusive, so time moves on before each evaluation.
+	h.ingest(failedLogin, failedLogin)
+	h.clock.Advance(time.Second)
+	h.evaluateAlerts()
+	expect(t, "firings below the threshold", len(history()), 0)
+
+	// The third fires the rule once
+	h.ingest(failedLogin)
+	h.clock.Advance(time.Second)
+	h.evaluateAlerts()
+	instances := history()
+	expect(t, "firings at the threshold", len(instances), 1)
+	expect(t, "firing", []interface{}{instances[0].Status, instances[0].Count, instances[0].ResolvedAt == nil}, []interface{}{alertStatusFiring, 3, true})
+
+	// Once the failures leave the window the firing resolves
+	h.clock.Advance(10 * time.Minute)
+	h.evaluateAlerts()
+	instances = history()
+	if len(instances) != 1 || instances[0].ResolvedAt == nil {
+		t.Fatal("firing did not resolve after its window passed")
+	}
+	expect(t, "resolved at", instances[0].ResolvedAt.UTC(), h.clock.Now())
+}
+
+func TestE2EAlertChangesNeedAdmin(t *testing.T) {
+	h := newHarness(t)
+	rule := AlertRule{Name: "Malware", Filter: LogFilter{Rule: stringList{"Malware"}}, Threshold: 1, WindowSeconds: 300, Enabled: true,
+		WebhookURL: "http://169.254.169.254/latest"}
+	expect(t, "anonymous create", h.anonymous(http.MethodPost, "/api/alerts/rules", rule), http.StatusForbidden)
+	rule.WebhookURL = ""
+	h.do(http.MethodPost, "/api/alerts/rules", rule, &rule)
+	expect(t, "anonymous delete", h.anonymous(http.MethodDelete, fmt.Sprintf("/api/alerts/rules?id=%d", rule.ID), nil), http.StatusForbidden)
+
+	h.ingest(LogEntry{Level: "ERROR", Rule: "Malware", SourceIP: "10.0.0.3", Event: "Trojan found"})
+	h.clock.Advance(time.Second)
+	h.evaluateAlerts()
+	var instances []AlertInstance
+	h.do(http.MethodGet, "/api/alerts/history", nil, &instances)
+	ack := fmt.Sprintf("/api/alerts/history/%d/ack", instances[0].ID)
+	expect(t, "anonymous ack", h.anonymous(http.MethodPost, ack, map[string]string{"actor": "mallory"}), http.StatusForbidden)
+
+	// The actor is the credential's, whatever the body claims
+	var instance AlertInstance
+	h.do(http.MethodPost, ack, map[string]string{"actor": "mallory"}, &instance)
+	expect(t, "acknowledged by", instance.AcknowledgedBy, "harness")
+}
+
+func TestE2EMuteChangesNeedAdmin(t *testing.T) {
+	h := newHarness(t)
+	window := MuteWindow{Name: "Everything", Cron: "* * * * *", DurationSeconds: 3600, Timezone: "UTC"}
+	expect(t, "anonymous create", h.anonymous(http.MethodPost, "/api/alerts/mutes", window), http.StatusForbidden)
+	expect(t, "admin create", h.do(http.MethodPost, "/api/alerts/mutes", window, &window), http.StatusCreated)
+	expect(t, "anonymous delete", h.anonymous(http.MethodDelete, fmt.Sprintf("/api/alerts/mutes?id=%d", window.ID), nil), http.StatusForbidden)
+	expect(t, "anonymous list", h.anonymous(http.MethodGet, "/api/alerts/mutes", nil), http.StatusOK)
+}
+
+func TestE2ENotableActionsNeedAdmin(t *testing.T) {
+	h := newHarness(t)
+	var n NotableEvent
+	h.do(http.MethodPost, "/api/notables", NotableEvent{RuleName: "Malware", Urgency: "high"}, &n)
+	for _, action := range []struct {
+		method, path string
+		body         interface{}
+	}{
+		{http.MethodPost, "assign", map[string]string{"assignee": "mallory"}},
+		{http.MethodPost, "resolve", nil},
+		{http.MethodPost, "ticket", nil},
+		{http.MethodPost, "tags", map[string][]string{"tags": {"fp"}}},
+		{http.MethodDelete, "tags", map[string][]string{"tags": {"fp"}}},
+	} {
+		path := fmt.Sprintf("/api/notables/%s/%s", n.ID, action.path)
+		expect(t, "anonymous "+action.method+" "+action.path, h.anonymous(action.method, path, action.body), http.StatusForbidden)
+	}
+	expect(t, "anonymous fetch", h.anonymous(http.MethodGet, fmt.Sprintf("/api/notables/%s", n.ID), nil), http.StatusOK)
+	h.do(http.MethodPost, fmt.Sprintf("/api/notables/%s/assign", n.ID), map[string]string{"assignee": "alice"}, &n)
+	expect(t, "assignee", n.Assignee, "alice")
+}
+
+func TestE2EAlertRuleFailureIsolated(t *testing.T) {
+	h := newHarness(t)
+	h.ingest(LogEntry{Level: "ERROR", Rule: "Malware", SourceIP: "10.0.0.3", Event: "Trojan found"})
+	h.clock.Advance(time.Second)
+	// The first rule reads log_tags, which is gone by the time it runs
+	for _, rule := range []AlertRule{
+		{Name: "Tagged malware", Filter: LogFilter{Tag: stringList{"malware"}}, Threshold: 1, WindowSeconds: 300, Enabled: true},
+		{Name: "Malware", Filter: LogFilter{Rule: stringList{"Malware"}}, Threshold: 1, WindowSeconds: 300, Enabled: true},
+	} {
+		h.do(http.MethodPost, "/api/alerts/rules", rule, nil)
+	}
+	if _, err := h.db.db.Exec(`DROP TABLE log_tags`); err != nil {
+		t.Fatal(err)
+	}
+	h.evaluateAlerts()
+
+	var instances []AlertInstance
+	h.do(http.MethodGet, "/api/alerts/history", nil, &instances)
+	if len(instances) != 1 || instances[0].RuleName != "Malware" {
+		t.Fatalf("firings after a failing rule = %+v, want Malware", instances)
+	}
+	var samples int
+	if err := h.db.db.QueryRow(`SELECT COUNT(*) FROM storage_samples`).Scan(&samples); err != nil {
+		t.Fatal(err)
+	}
+	expect(t, "storage samples taken by the builtin alerts", samples, 1)
+}
+
+func TestE2EAlertExpression(t *testing.T) {
+	h := newHarness(t)
+	var rule AlertRule
+	h.do(http.MethodPost, "/api/alerts/rules", AlertRule{
+		Name:          "Internal 5xx errors",
+		Expression:    `level == "ERROR" && meta.status >= 500 && source.startsWith("10.")`,
+		WindowSeconds: 300,
+		Enabled:       true,
+	}, &rule)
+	// Only the first log satisfies every part of the expression. meta reads
+	// the fields of the raw payload that LogEntry has no place for.
+	h.do(http.MethodPost, "/api/logs", []map[string]interface{}{
+		{"level": "ERROR", "sourceIP": "10.0.7.1", "event": "Upstream failed", "status": 503},
+		{"level": "ERROR", "sourceIP": "10.0.7.2", "event": "Not found", "status": 404},
+		{"level": "ERROR", "sourceIP": "198.51.100.7", "event": "Upstream failed", "status": 502},
+		{"level": "INFO", "sourceIP": "10.0.7.3", "event": "Upstream failed", "status": 500},
+		{"level": "ERROR", "sourceIP": "10.0.7.4", "event": "Upstream failed"},
+	}, nil)
+	h.clock.Advance(time.Second)
+	var match ExpressionMatch
+	h.do(http.MethodPost, "/api/alerts/expression", expressionTest{Expression: rule.Expression, WindowSeconds: 300}, &match)
+	expect(t, "logs matching the expression", match.Count, 1)
+	h.evaluateAlerts()
+	var instances []AlertInstance
+	h.do(http.MethodGet, fmt.Sprintf("/api/alerts/history?rule_id=%d", rule.ID), nil, &instances)
+	expect(t, "firings", len(instances), 1)
+	instance := instances[0]
+	expect(t, "firing count and threshold", []int{instance.Count, instance.Threshold}, []int{1, 1})
+	expect(t, "samples", len(instance.Samples) == 1 && instance.Samples[0].SourceIP == "10.0.7.1", true)
+}
+
+func TestE2EReplayPacedByClock(t *testing.T) {
+	h := newHarness(t)
+	// Three logs a minute apart, from an hour ago
+	start := h.clock.Now().Add(-time.Hour)
+	var logs []LogEntry
+	for i := 0; i < 3; i++ {
+		logs = append(logs, LogEntry{Timestamp: start.Add(time.Duration(i) * time.Minute), Level: "WARN", Rule: "Brute Force Login",
+			SourceIP: "198.51.100.4", Event: "Failed login", Description: fmt.Sprintf("Attempt %d", i+1), Urgency: 2})
+	}
+	h.ingest(logs...)
+	var job ReplayJob
+	h.do(http.MethodPost, "/api/replays?ip=198.51.100.4&since=2h", ReplayRequest{Speed: 1, Tag: "e2e-replay"}, &job)
+	replayed := func() int {
+		return h.count(url.Values{"tag": {"e2e-replay"}})
+	}
+
+	// The first log replays at once; each later one only once the clock has
+	// moved another minute
+	for want := 1; want <= 3; want++ {
+		if want > 1 {
+			h.waitFor(fmt.Sprintf("the replay to wait for log %d", want), 5*time.Second, func() bool { return h.clock.Waiting() > 0 })
+			h.clock.Advance(time.Minute)
+		}
+		h.waitFor(fmt.Sprintf("log %d to replay", want), 5*time.Second, func() bool { return replayed() >= want })
+		expect(t, "replayed logs", replayed(), want)
+	}
+	h.waitFor("the replay to finish", 5*time.Second, func() bool {
+		h.do(http.MethodGet, "/api/replays/"+url.PathEscape(job.ID), nil, &job)
+		return job.Status == replayDone
+	})
+}
+
+// e2eDropDebugModule is a WASM processor that drops DEBUG logs and tags
+// the rest:
+//
+//	(module
+//	  (import "logger" "field_get" (func $get (param i32 i32 i32 i32) (result i32)))
+//	  (import "logger" "field_set" (func $set (param i32 i32 i32 i32) (result i32)))
+//	  (memory (export "memory") 1)
+//	  (data (i32.const 0) "level")
+//	  (data (i32.const 8) "tags")
+//	  (data (i32.const 16) "wasm")
+//	  (func (export "process") (result i32)
+//	    (if (i32.and
+//	          (i32.eq (call $get (i32.const 0) (i32.const 5) (i32.const 64) (i32.const 16)) (i32.const 5))
+//	          (i32.eq (i32.load8_u (i32.const 64)) (i32.const 68))) ;; 'D'
+//	      (then (return (i32.const 1))))
+//	    (drop (call $set (i32.const 8) (i32.const 4) (i32.const 16) (i32.const 4)))
+//	    (i32.const 0)))
+var e2eDropDebugModule = []byte("\x00asm\x01\x00\x00\x00" +
+	"\x01\x0d\x02\x60\x04\x7f\x7f\x7f\x7f\x01\x7f\x60\x00\x01\x7f" +
+	"\x02\x27\x02\x06logger\x09field_get\x00\x00\x06logger\x09field_set\x00\x00" +
+	"\x03\x02\x01\x01" +
+	"\x05\x03\x01\x00\x01" +
+	"\x07\x14\x02\x07process\x00\x02\x06memory\x02\x00" +
+	"\x0a\x30\x01\x2e\x00\x41\x00\x41\x05\x41\xc0\x00\x41\x10\x10\x00\x41\x05\x46\x41\xc0\x00\x2d\x00\x00" +
+	"\x41\xc4\x00\x46\x71\x04\x40\x41\x01\x0f\x0b\x41\x08\x41\x04\x41\x10\x41\x04\x10\x01\x1a\x41\x00\x0b" +
+	"\x0b\x1d\x03\x00\x41\x00\x0b\x05level\x00\x41\x08\x0b\x04tags\x00\x41\x10\x0b\x04wasm")
+
+// e2eSpinModule loops forever: (func (export "process") (result i32) (loop (br 0)) (i32.const 0))
+var e2eSpinModule = []byte("\x00asm\x01\x00\x00\x00" +
+	"\x01\x05\x01\x60\x00\x01\x7f" +
+	"\x03\x02\x01\x00" +
+	"\x07\x0b\x01\x07process\x00\x00" +
+	"\x0a\x0b\x01\x09\x00\x03\x40\x0c\x00\x0b\x41\x00\x0b")
+
+func TestE2EWasmProcessor(t *testing.T) {
+	h := newHarness(t)
+	var processor WasmProcessor
+	h.do(http.MethodPost, "/api/processors", WasmProcessor{Name: "drop-debug", Enabled: true, Module: e2eDropDebugModule}, &processor)
+	h.ingest(
+		LogEntry{Level: "DEBUG", Rule: "Internal Network Traffic", SourceIP: "10.0.6.1", Event: "Heartbeat", Urgency: 1},
+		LogEntry{Level: "ERROR", Rule: "Malware Threat Detected", SourceIP: "10.0.6.2", Event: "Signature match", Urgency: 4},
+	)
+	expect(t, "logs kept", len(h.search(url.Values{})), 1)
+	expect(t, "logs tagged by the module", h.count(url.Values{"tag": {"wasm"}}), 1)
+	var processors []WasmProcessor
+	h.do(http.MethodGet, "/api/processors", nil, &processors)
+	stats := processors[0].Stats
+	expect(t, "processed, dropped and failed", []int64{stats.Processed, stats.Dropped, stats.Failed}, []int64{2, 1, 0})
+
+	// A module that never returns is stopped when its fuel runs out
+	var outcome struct {
+		Result string `json:"result"`
+		Error  string `json:"error"`
+	}
+	h.do(http.MethodPost, "/api/processors/test", wasmProcessorTest{Module: e2eSpinModule, Entry: LogEntry{Level: "INFO"}}, &outcome)
+	expect(t, "endless module", []string{outcome.Result, outcome.Error}, []string{"error", errWasmFuel.Error()})
+}
+
+func TestE2EIngestBatchOnce(t *testing.T) {
+	h := newHarness(t)
+	logs := []LogEntry{
+		{Level: "WARN", Rule: "Brute Force Login", SourceIP: "10.0.8.1", Event: "Failed login", Urgency: 2},
+		{Level: "WARN", Rule: "Brute Force Login", SourceIP: "10.0.8.2", Event: "Failed login", Urgency: 2},
+	}
+	// An agent that lost the responses stages and commits the batch twice
+	var batch IngestBatch
+	for i := 0; i < 2; i++ {
+		h.do(http.MethodPost, "/api/ingest/batches/agent-7.42", logs, &batch)
+		h.do(http.MethodPost, "/api/ingest/batches/agent-7.42/commit", nil, &batch)
+	}
+	expect(t, "second commit", []interface{}{batch.State, batch.Stored, batch.AlreadyCommitted}, []interface{}{batchCommitted, 2, true})
+	expect(t, "logs stored", h.count(url.Values{}), 2)
+}
+
+func TestE2EBulkDelete(t *testing.T) {
+	h := newHarness(t)
+	h.ingest(
+		LogEntry{Level: "WARN", Rule: "Brute Force Login", SourceIP: "10.0.9.1", Event: "Failed login", Urgency: 2, User: "scanner"},
+		LogEntry{Level: "WARN", Rule: "Brute Force Login", SourceIP: "10.0.9.1", Event: "Failed login", Urgency: 2, User: "scanner"},
+		LogEntry{Level: "ERROR", Rule: "Malware Threat Detected", SourceIP: "10.0.9.2", Event: "Signature match", Urgency: 4, User: "alice"},
+	)
+	var job BulkJob
+	h.do(http.MethodPost, "/api/logs/bulk?ip=10.0.9.1", BulkRequest{Action: bulkDelete}, &job)
+	expect(t, "matched", job.Matched, 2)
+	h.waitFor("the bulk job to finish", 5*time.Second, func() bool {
+		h.do(http.MethodGet, "/api/logs/bulk/"+url.PathEscape(job.ID), nil, &job)
+		return job.Status != bulkRunning
+	})
+	expect(t, "status and processed", []interface{}{job.Status, job.Processed}, []interface{}{bulkDone, 2})
+	expect(t, "logs left", h.count(url.Values{}), 1)
+
+	// The dashboards forget the deleted logs
+	var summary SummaryStats
+	h.do(http.MethodGet, "/api/summary", nil, &summary)
+	expect(t, "access and threat tiles", []int{summary.AccessNotables.Total, summary.ThreatNotables.Total}, []int{0, 1})
+	// Unique counts end at now, exclusive, and the logs are stamped at the
+	// start of an hour
+	h.clock.Advance(time.Minute)
+	var unique UniqueCounts
+	h.do(http.MethodGet, "/api/unique", nil, &unique)
+	expect(t, "unique sources and users", []int{unique.Total.SourceIPs, unique.Total.Users}, []int{1, 1})
+}
+
+func TestE2ECORSPreflight(t *testing.T) {
+	h := newHarness(t)
+	// Browsers send preflights without credentials
+	for _, path := range []string{"/api/notables", "/api/notables/1/assign", "/api/webhooks"} {
+		req, err := http.NewRequest(http.MethodOptions, h.url+path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := h.server.Client().Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		expect(t, path+" preflight status", resp.StatusCode, http.StatusOK)
+		if resp.Header.Get("Access-Control-Allow-Origin") == "" {
+			t.Errorf("%s preflight has no Access-Control-Allow-Origin", path)
+		}
+	}
+}
+
+func TestE2EServersIsolated(t *testing.T) {
+	a, b := newHarness(t), newHarness(t)
+	var created struct {
+		Token string `json:"token"`
+	}
+	a.do(http.MethodPost, "/api/ingest/tokens", IngestToken{Name: "edge"}, &created)
+	a.do(http.MethodPut, "/api/levels", []LogLevel{{"INFO", 1, "#3B82F6"}, {"NOTICE", 2, "#22C55E"}}, nil)
+
+	// Neither the token nor the levels reach the other server
+	var tokens []IngestToken
+	b.do(http.MethodGet, "/api/ingest/tokens", nil, &tokens)
+	expect(t, "tokens on the other server", len(tokens), 0)
+	var levels []LogLevel
+	b.do(http.MethodGet, "/api/levels", nil, &levels)
+	expect(t, "levels on the other server", len(levels), len(defaultLevels))
+
+	data, err := json.Marshal([]LogEntry{{Level: "INFO", Event: "login"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, err := http.NewRequest(http.MethodPost, b.url+"/api/logs", bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Ingest-Token", created.Token)
+	resp, err := b.server.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	expect(t, "ingest with the other server's token", resp.StatusCode, http.StatusUnauthorized)
+}
@@ -0,0 +1,182 @@
+package logserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// entityFields lists, per entity type, the log columns, notable columns and
+// alert sample JSON fields that can refer to the entity
+var entityFields = map[string]struct {
+	logs, notables, samples []string
+}{
+	"ip":   {[]string{"source_ip", "destination_ip"}, []string{"source_ip", "destination"}, []string{"$.sourceIP", "$.destinationIP"}},
+	"host": {[]string{"source_ip", "destination_ip"}, []string{"source_ip", "destination"}, []string{"$.sourceIP", "$.destinationIP"}},
+	"user": {[]string{"user_name"}, []string{"user_name"}, []string{"$.user"}},
+}
+
+// TimelineItem is one log, notable or alert on an entity timeline
+type TimelineItem struct {
+	Kind      string      `json:"kind"` // log, notable, alert
+	Timestamp time.Time   `json:"timestamp"`
+	Summary   string      `json:"summary"`
+	Data      interface{} `json:"data"`
+}
+
+// EntityTimeline is the merged chronological view of an entity
+type EntityTimeline struct {
+	Type      string         `json:"type"`
+	Value     string         `json:"value"`
+	From      time.Time      `json:"from"`
+	To        time.Time      `json:"to"`
+	Items     []TimelineItem `json:"items"`
+	Counts    map[string]int `json:"counts"`
+	Truncated bool           `json:"truncated"`
+	// Geo locates ip and host entities when GEOIP_DB is configured
+	Geo *GeoLocation `json:"geo,omitempty"`
+}
+
+// entityMatch builds "(a = ? OR b = ?)" over columns with one arg per column
+func entityMatch(columns []string, value string) (string, []interface{}) {
+	clauses := make([]string, len(columns))
+	args := make([]interface{}, len(columns))
+	for i, c := range columns {
+		clauses[i] = c + " = ?"
+		args[i] = value
+	}
+	return "(" + strings.Join(clauses, " OR ") + ")", args
+}
+
+// GetEntityTimeline merges logs, notables and alerts involving the entity in
+// [from, to), oldest first. Each source returns at most limit items.
+func (d *Database) GetEntityTimeline(entityType, value string, from, to time.Time, limit int) (EntityTimeline, error) {
+	fields := entityFields[entityType]
+	timeline := EntityTimeline{Type: entityType, Value: value, From: from, To: to, Items: []TimelineItem{}, Counts: map[string]int{}}
+	if entityType != "user" {
+		timeline.Geo = d.locate(value)
+	}
+	count := func(kind string, n int) {
+		timeline.Counts[kind] = n
+		if n >= limit {
+			timeline.Truncated = true
+		}
+	}
+
+	match, args := entityMatch(fields.logs, value)
+	rows, err := d.db.Query(`SELECT `+logColumns+` FROM logs WHERE `+match+` AND timestamp >= ? AND timestamp < ?
+		ORDER BY timestamp DESC LIMIT ?`, append(args, from.UTC(), to.UTC(), limit)...)
+	if err != nil {
+		return timeline, err
+	}
+	logs, err := d.scanLogs(rows)
+	rows.Close()
+	if err != nil {
+		return timeline, err
+	}
+	for _, l := range logs {
+		timeline.Items = append(timeline.Items, TimelineItem{
+			Kind:      "log",
+			Timestamp: l.Timestamp,
+			Summary:   fmt.Sprintf("%s %s: %s -> %s", l.Level, l.Event, l.SourceIP, l.DestinationIP),
+			Data:      l,
+		})
+	}
+	count("logs", len(logs))
+
+	match, args = entityMatch(fields.notables, value)
+	rows, err = d.db.Query(`SELECT `+notableColumns+` FROM notables WHERE `+match+` AND created_at >= ? AND created_at < ?
+		ORDER BY created_at DESC LIMIT ?`, append(args, from.UTC(), to.UTC(), limit)...)
+	if err != nil {
+		return timeline, err
+	}
+	notables := 0
+	for rows.Next() {
+		n, err := scanNotable(rows.Scan)
+		if err != nil {
+			rows.Close()
+			return timeline, err
+		}
+		notables++
+		timeline.Items = append(timeline.Items, TimelineItem{
+			Kind:      "notable",
+			Timestamp: n.Timestamp,
+			Summary:   fmt.Sprintf("[%s] %s (%s)", n.Urgency, n.RuleName, n.Status),
+			Data:      n,
+		})
+	}
+	rows.Close()
+	count("notables", notables)
+
+	// Alerts have no entity columns; match the sampled log entries instead
+	clauses := make([]string, len(fields.samples))
+	args = make([]interface{}, len(fields.samples))
+	for i, path := range fields.samples {
+		clauses[i] = "json_extract(s.value, '" + path + "') = ?"
+		args[i] = value
+	}
+	rows, err = d.db.Query(`SELECT `+alertInstanceColumns+` FROM alert_history
+		WHERE EXISTS (SELECT 1 FROM json_each(alert_history.samples) s WHERE `+strings.Join(clauses, " OR ")+`)
+		AND fired_at >= ? AND fired_at < ?
+		ORDER BY fired_at DESC LIMIT ?`, append(args, from.UTC(), to.UTC(), limit)...)
+	if err != nil {
+		return timeline, err
+	}
+	alerts := 0
+	for rows.Next() {
+		a, err := scanAlertInstance(rows.Scan)
+		if err != nil {
+			rows.Close()
+			return timeline, err
+		}
+		alerts++
+		timeline.Items = append(timeline.Items, TimelineItem{
+			Kind:      "alert",
+			Timestamp: a.FiredAt,
+			Summary:   fmt.Sprintf("%s fired with %d matches (%s)", a.RuleName, a.Count, a.Status),
+			Data:      a,
+		})
+	}
+	rows.Close()
+	count("alerts", alerts)
+
+	sort.SliceStable(timeline.Items, func(i, j int) bool {
+		return timeline.Items[i].Timestamp.Before(timeline.Items[j].Timestamp)
+	})
+	return timeline, rows.Err()
+}
+
+// GET /api/entities/{type}/{value}/timeline?from=&to=&limit= - type is ip, user or host
+func entityTimelineHandlerDB(w http.ResponseWriter, r *http.Request, db *Database) {
+	enableCORS(w)
+	w.Header().Set("Content-Type", "application/json")
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/api/entities/"), "/")
+	if len(parts) != 3 || parts[2] != "timeline" || parts[1] == "" {
+		writeJSONError(w, http.StatusNotFound, "Not found")
+		return
+	}
+	entityType := parts[0]
+	if _, ok := entityFields[entityType]; !ok {
+		writeJSONError(w, http.StatusBadRequest, "type must be ip, user or host")
+		return
+	}
+	from, to, err := parseTimeRange(r, db.now(), 7*24*time.Hour)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	limit := 500
+	if l, err := strconv.Atoi(r.URL.Query().Get("limit")); err == nil && l > 0 && l <= 5000 {
+		limit = l
+	}
+	timeline, err := db.GetEntityTimeline(entityType, parts[1], from, to, limit)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "Failed to build entity timeline")
+		return
+	}
+	json.NewEncoder(w).Encode(timeline)
+}
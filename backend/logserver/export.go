@@ -0,0 +1,170 @@
+package logserver
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"time"
+)
+
+// exportRowGroupSize is how many logs go into each parquet row group
+const exportRowGroupSize = 50000
+
+// exportBatchSize is how many logs are read per query; tags are loaded per batch
+const exportBatchSize = 500
+
+// ExportParquet writes every log matching filter to w as a parquet file in
+// id (ingestion) order, with masks applied, and returns the number of rows
+// written
+func (d *Database) ExportParquet(w io.Writer, filter LogFilter, masks fieldMasks) (int64, error) {
+	pw, err := newParquetWriter(w, logParquetColumns)
+	if err != nil {
+		return 0, err
+	}
+	clause, args := filter.where()
+	var total, lastID int64
+	group := make([]LogEntry, 0, exportRowGroupSize)
+	for {
+		rows, err := d.db.Query(`SELECT `+logColumns+` FROM logs WHERE id > ?`+clause+` ORDER BY id LIMIT ?`,
+			append(append([]interface{}{lastID}, args...), exportBatchSize)...)
+		if err != nil {
+			return total, err
+		}
+		batch, err := d.scanLogs(rows)
+		rows.Close()
+		if err == nil {
+			err = d.attachTags(batch)
+		}
+		if err != nil {
+			return total, err
+		}
+		masks.apply(batch)
+		group = append(group, batch...)
+		if len(group) >= exportRowGroupSize || len(batch) < exportBatchSize {
+			if err := pw.writeRowGroup(group); err != nil {
+				return total, err
+			}
+			total += int64(len(group))
+			group = group[:0]
+		}
+		if len(batch) < exportBatchSize {
+			break
+		}
+		lastID = batch[len(batch)-1].ID
+	}
+	return total, pw.close()
+}
+
+// GET /api/logs/export?format=parquet&<search filters> - download matching logs as a file
+func exportHandlerDB(w http.ResponseWriter, r *http.Request, db *Database) {
+	enableCORS(w)
+	if r.Method != http.MethodGet {
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	if format := r.URL.Query().Get("format"); format != "parquet" {
+		writeJSONError(w, http.StatusBadRequest, "format must be parquet")
+		return
+	}
+	filter, err := parseLogFilter(r.URL.Query(), db.now())
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	filter = scopedFilter(r, filter)
+	masks := requestMasks(r)
+	if param, ok := masks.revealingParam(r.URL.Query()); ok {
+		writeMaskedParam(w, param)
+		return
+	}
+	// The file is spooled to disk before it is sent, so an export that fails
+	// part way is answered with an error rather than a truncated file
+	var size int64
+	spool, err := os.CreateTemp("", "logger-export-*.parquet")
+	if err == nil {
+		defer os.Remove(spool.Name())
+		defer spool.Close()
+		size, err = spoolParquet(db, spool, filter, masks)
+	}
+	if err != nil {
+		log.Printf("export: %v", err)
+		w.Header().Set("Content-Type", "application/json")
+		writeJSONError(w, http.StatusInternalServerError, "Failed to export logs")
+		return
+	}
+	w.Header().Set("Content-Type", "application/vnd.apache.parquet")
+	w.Header().Set("Content-Disposition", `attachment; filename="logs.parquet"`)
+	w.Header().Set("Content-Length", strconv.FormatInt(size, 10))
+	io.Copy(w, spool)
+}
+
+// spoolParquet exports to spool and rewinds it, returning the file's size
+func spoolParquet(db *Database, spool *os.File, filter LogFilter, masks fieldMasks) (int64, error) {
+	buf := bufio.NewWriterSize(spool, 1<<20)
+	if _, err := db.ExportParquet(buf, filter, masks); err != nil {
+		return 0, err
+	}
+	if err := buf.Flush(); err != nil {
+		return 0, err
+	}
+	size, err := spool.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return 0, err
+	}
+	_, err = spool.Seek(0, io.SeekStart)
+	return size, err
+}
+
+// RunExportCommand implements `logger-backend export`, the command-line
+// equivalent of /api/logs/export
+func RunExportCommand(args []string) error {
+	flags := flag.NewFlagSet("export", flag.ContinueOnError)
+	format := flags.String("format", "parquet", "output format (parquet)")
+	output := flags.String("o", "", "output file (default stdout)")
+	query := flags.String("query", "", "search filters as a query string, e.g. 'level=ERROR&since=7d'")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *format != "parquet" {
+		return errors.New("format must be parquet")
+	}
+	q, err := url.ParseQuery(*query)
+	if err != nil {
+		return fmt.Errorf("invalid -query: %w", err)
+	}
+	filter, err := parseLogFilter(q, time.Now())
+	if err != nil {
+		return err
+	}
+
+	db, err := NewDatabase()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	out := os.Stdout
+	if *output != "" {
+		if out, err = os.Create(*output); err != nil {
+			return err
+		}
+		defer out.Close()
+	}
+	buf := bufio.NewWriterSize(out, 1<<20)
+	n, err := db.ExportParquet(buf, filter, nil)
+	if err != nil {
+		return err
+	}
+	if err := buf.Flush(); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "exported %d logs\n", n)
+	return nil
+}
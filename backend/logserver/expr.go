@@ -0,0 +1,908 @@
+package logserver
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Log expressions. An alert condition's Expression is a boolean expression
+// over one log, in the syntax of expr-lang:
+//
+//	level == "ERROR" && meta.status >= 500 && source.startsWith("10.")
+//
+// Identifiers are the log's JSON field names (see logFields), source and
+// destination for sourceIP and destinationIP, and meta for the fields of the
+// log's raw JSON payload. The operators are ! not - * / % + - == != < <= > >=
+// in, not in, contains, startsWith, endsWith, matches, && and, || or; the
+// functions, which can also be called as methods of their first argument,
+// are len, lower, upper, trim, startsWith, endsWith, contains and matches.
+//
+// Missing fields are nil. Ordering a nil or comparing values of different
+// types is false rather than an error, so that a payload without meta.status
+// just doesn't match meta.status >= 500.
+
+// exprMaxDepth bounds how deeply expressions nest
+const exprMaxDepth = 64
+
+// exprAliases are identifiers that name a log field under another name
+var exprAliases = map[string]string{
+	"source":      "sourceIP",
+	"destination": "destinationIP",
+}
+
+// exprFunctions maps each function to its number of arguments
+var exprFunctions = map[string]int{
+	"len":        1,
+	"lower":      1,
+	"upper":      1,
+	"trim":       1,
+	"startsWith": 2,
+	"endsWith":   2,
+	"contains":   2,
+	"matches":    2,
+}
+
+// logExpr is a parsed log expression
+type logExpr struct {
+	root exprNode
+	// fields holds the log fields the expression reads, by JSON name, and
+	// "meta" when it reads the raw payload
+	fields map[string]bool
+}
+
+// exprEnv is the log an expression is evaluated against
+type exprEnv struct {
+	entry      LogEntry
+	meta       interface{}
+	metaParsed bool
+}
+
+type exprNode interface {
+	eval(env *exprEnv) (interface{}, error)
+}
+
+type (
+	exprLiteral struct{ value interface{} }
+	exprField   struct{ name string }
+	exprMeta    struct{}
+	exprMember  struct {
+		x    exprNode
+		name string
+	}
+	exprIndex struct{ x, index exprNode }
+	exprList  struct{ items []exprNode }
+	exprUnary struct {
+		op string
+		x  exprNode
+	}
+	exprBinary struct {
+		op   string
+		l, r exprNode
+	}
+	exprCall struct {
+		name string
+		args []exprNode
+		// re is the compiled pattern of matches with a literal pattern
+		re *regexp.Regexp
+	}
+)
+
+// parseLogExpr parses and checks a log expression
+func parseLogExpr(source string) (*logExpr, error) {
+	tokens, err := lexExpr(source)
+	if err != nil {
+		return nil, err
+	}
+	p := &exprParser{tokens: tokens, fields: map[string]bool{}}
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind != exprEOF {
+		return nil, fmt.Errorf("unexpected %s at offset %d", t, t.pos)
+	}
+	return &logExpr{root: root, fields: p.fields}, nil
+}
+
+// match reports whether the expression holds for entry. A result other
+// than a boolean or nil is an error.
+func (e *logExpr) match(entry LogEntry) (bool, error) {
+	v, err := e.root.eval(&exprEnv{entry: entry})
+	if err != nil {
+		return false, err
+	}
+	return exprTruth(v)
+}
+
+// uses reports whether the expression reads the named field
+func (e *logExpr) uses(field string) bool {
+	return e.fields[field]
+}
+
+// Lexer
+
+const (
+	exprEOF = iota
+	exprIdent
+	exprNumber
+	exprString
+	exprPunct
+)
+
+type exprToken struct {
+	kind  int
+	text  string
+	value interface{}
+	pos   int
+}
+
+func (t exprToken) String() string {
+	if t.kind == exprEOF {
+		return "end of expression"
+	}
+	return strconv.Quote(t.text)
+}
+
+// exprPunctuation lists the operators, longest first
+var exprPunctuation = []string{"==", "!=", "<=", ">=", "&&", "||", "<", ">", "!", "+", "-", "*", "/", "%", "(", ")", "[", "]", ",", "."}
+
+func lexExpr(s string) ([]exprToken, error) {
+	var tokens []exprToken
+	i := 0
+	for i < len(s) {
+		r, size := utf8.DecodeRuneInString(s[i:])
+		switch {
+		case unicode.IsSpace(r):
+			i += size
+		case r == '_' || unicode.IsLetter(r):
+			start := i
+			for i < len(s) {
+				r, size := utf8.DecodeRuneInString(s[i:])
+				if r != '_' && !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+					break
+				}
+				i += size
+			}
+			tokens = append(tokens, exprToken{kind: exprIdent, text: s[start:i], pos: start})
+		case r >= '0' && r <= '9':
+			start := i
+			for i < len(s) && (s[i] >= '0' && s[i] <= '9' || s[i] == '.' || s[i] == '_' || s[i] == 'e' || s[i] == 'E' ||
+				(s[i] == '+' || s[i] == '-') && (s[i-1] == 'e' || s[i-1] == 'E')) {
+				i++
+			}
+			n, err := strconv.ParseFloat(strings.ReplaceAll(s[start:i], "_", ""), 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid number %q at offset %d", s[start:i], start)
+			}
+			tokens = append(tokens, exprToken{kind: exprNumber, text: s[start:i], value: n, pos: start})
+		case r == '"' || r == '\'':
+			start := i
+			i++
+			for i < len(s) && s[i] != byte(r) {
+				if s[i] == '\\' {
+					i++
+				}
+				i++
+			}
+			if i >= len(s) {
+				return nil, fmt.Errorf("unterminated string at offset %d", start)
+			}
+			i++
+			text := s[start:i]
+			if r == '\'' {
+				// Requote so that strconv handles the escapes
+				text = `"` + strings.ReplaceAll(strings.ReplaceAll(text[1:len(text)-1], `\'`, `'`), `"`, `\"`) + `"`
+			}
+			value, err := strconv.Unquote(text)
+			if err != nil {
+				return nil, fmt.Errorf("invalid string at offset %d", start)
+			}
+			tokens = append(tokens, exprToken{kind: exprString, text: s[start:i], value: value, pos: start})
+		default:
+			matched := false
+			for _, p := range exprPunctuation {
+				if strings.HasPrefix(s[i:], p) {
+					tokens = append(tokens, exprToken{kind: exprPunct, text: p, pos: i})
+					i += len(p)
+					matched = true
+					break
+				}
+			}
+			if !matched {
+				return nil, fmt.Errorf("unexpected %q at offset %d", r, i)
+			}
+		}
+	}
+	return append(tokens, exprToken{kind: exprEOF, pos: len(s)}), nil
+}
+
+// Parser
+
+type exprParser struct {
+	tokens []exprToken
+	pos    int
+	depth  int
+	fields map[string]bool
+}
+
+func (p *exprParser) peek() exprToken {
+	return p.tokens[p.pos]
+}
+
+func (p *exprParser) next() exprToken {
+	t := p.tokens[p.pos]
+	if t.kind != exprEOF {
+		p.pos++
+	}
+	return t
+}
+
+// is reports whether the next token is the operator or keyword text
+func (p *exprParser) is(text string) bool {
+	t := p.peek()
+	return (t.kind == exprPunct || t.kind == exprIdent) && t.text == text
+}
+
+func (p *exprParser) expect(text string) error {
+	if !p.is(text) {
+		t := p.peek()
+		return fmt.Errorf("expected %q, found %s at offset %d", text, t, t.pos)
+	}
+	p.next()
+	return nil
+}
+
+func (p *exprParser) parseOr() (exprNode, error) {
+	p.depth++
+	defer func() { p.depth-- }()
+	if p.depth > exprMaxDepth {
+		return nil, errors.New("expression is nested too deeply")
+	}
+	l, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.is("||") || p.is("or") {
+		p.next()
+		r, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		l = exprBinary{op: "||", l: l, r: r}
+	}
+	return l, nil
+}
+
+func (p *exprParser) parseAnd() (exprNode, error) {
+	l, err := p.parseCompare()
+	if err != nil {
+		return nil, err
+	}
+	for p.is("&&") || p.is("and") {
+		p.next()
+		r, err := p.parseCompare()
+		if err != nil {
+			return nil, err
+		}
+		l = exprBinary{op: "&&", l: l, r: r}
+	}
+	return l, nil
+}
+
+func (p *exprParser) parseCompare() (exprNode, error) {
+	l, err := p.parseAdd()
+	if err != nil {
+		return nil, err
+	}
+	var op string
+	switch t := p.peek(); {
+	case t.kind == exprPunct && (t.text == "==" || t.text == "!=" || t.text == "<" || t.text == "<=" || t.text == ">" || t.text == ">="):
+		op = t.text
+	case t.kind == exprIdent && (t.text == "in" || t.text == "contains" || t.text == "startsWith" || t.text == "endsWith" || t.text == "matches"):
+		op = t.text
+	case t.kind == exprIdent && t.text == "not" && p.tokens[p.pos+1].text == "in":
+		p.next()
+		op = "not in"
+	default:
+		return l, nil
+	}
+	p.next()
+	r, err := p.parseAdd()
+	if err != nil {
+		return nil, err
+	}
+	switch op {
+	case "contains", "startsWith", "endsWith", "matches":
+		return p.call(op, []exprNode{l, r})
+	}
+	return exprBinary{op: op, l: l, r: r}, nil
+}
+
+func (p *exprParser) parseAdd() (exprNode, error) {
+	l, err := p.parseMul()
+	if err != nil {
+		return nil, err
+	}
+	for p.is("+") || p.is("-") {
+		op := p.next().text
+		r, err := p.parseMul()
+		if err != nil {
+			return nil, err
+		}
+		l = exprBinary{op: op, l: l, r: r}
+	}
+	return l, nil
+}
+
+func (p *exprParser) parseMul() (exprNode, error) {
+	l, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.is("*") || p.is("/") || p.is("%") {
+		op := p.next().text
+		r, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		l = exprBinary{op: op, l: l, r: r}
+	}
+	return l, nil
+}
+
+func (p *exprParser) parseUnary() (exprNode, error) {
+	if p.is("!") || p.is("not") || p.is("-") {
+		op := p.next().text
+		if op == "not" {
+			op = "!"
+		}
+		p.depth++
+		defer func() { p.depth-- }()
+		if p.depth > exprMaxDepth {
+			return nil, errors.New("expression is nested too deeply")
+		}
+		x, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return exprUnary{op: op, x: x}, nil
+	}
+	return p.parsePostfix()
+}
+
+func (p *exprParser) parsePostfix() (exprNode, error) {
+	x, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		switch {
+		case p.is("."):
+			p.next()
+			t := p.next()
+			if t.kind != exprIdent {
+				return nil, fmt.Errorf("expected a name after '.', found %s at offset %d", t, t.pos)
+			}
+			if !p.is("(") {
+				x = exprMember{x: x, name: t.text}
+				continue
+			}
+			args, err := p.parseArgs()
+			if err != nil {
+				return nil, err
+			}
+			if x, err = p.call(t.text, append([]exprNode{x}, args...)); err != nil {
+				return nil, err
+			}
+		case p.is("["):
+			p.next()
+			index, err := p.parseOr()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expect("]"); err != nil {
+				return nil, err
+			}
+			x = exprIndex{x: x, index: index}
+		default:
+			return x, nil
+		}
+	}
+}
+
+func (p *exprParser) parsePrimary() (exprNode, error) {
+	t := p.next()
+	switch t.kind {
+	case exprNumber, exprString:
+		return exprLiteral{t.value}, nil
+	case exprIdent:
+		switch t.text {
+		case "true":
+			return exprLiteral{true}, nil
+		case "false":
+			return exprLiteral{false}, nil
+		case "nil":
+			return exprLiteral{nil}, nil
+		}
+		if p.is("(") {
+			args, err := p.parseArgs()
+			if err != nil {
+				return nil, err
+			}
+			return p.call(t.text, args)
+		}
+		if t.text == "meta" {
+			p.fields["meta"] = true
+			return exprMeta{}, nil
+		}
+		name := t.text
+		if alias, ok := exprAliases[name]; ok {
+			name = alias
+		}
+		if _, ok := logFields[name]; !ok {
+			return nil, fmt.Errorf("unknown field %q at offset %d", t.text, t.pos)
+		}
+		p.fields[name] = true
+		return exprField{name}, nil
+	case exprPunct:
+		switch t.text {
+		case "(":
+			x, err := p.parseOr()
+			if err != nil {
+				return nil, err
+			}
+			return x, p.expect(")")
+		case "[":
+			list := exprList{}
+			for !p.is("]") {
+				item, err := p.parseOr()
+				if err != nil {
+					return nil, err
+				}
+				list.items = append(list.items, item)
+				if !p.is(",") {
+					break
+				}
+				p.next()
+			}
+			return list, p.expect("]")
+		}
+	}
+	return nil, fmt.Errorf("unexpected %s at offset %d", t, t.pos)
+}
+
+func (p *exprParser) parseArgs() ([]exprNode, error) {
+	if err := p.expect("("); err != nil {
+		return nil, err
+	}
+	var args []exprNode
+	for !p.is(")") {
+		arg, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, arg)
+		if !p.is(",") {
+			break
+		}
+		p.next()
+	}
+	return args, p.expect(")")
+}
+
+// call checks a function call, compiling a literal matches pattern
+func (p *exprParser) call(name string, args []exprNode) (exprNode, error) {
+	arity, ok := exprFunctions[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown function %q", name)
+	}
+	if len(args) != arity {
+		return nil, fmt.Errorf("%s takes %d arguments, got %d", name, arity, len(args))
+	}
+	c := exprCall{name: name, args: args}
+	if lit, ok := args[len(args)-1].(exprLiteral); ok && name == "matches" {
+		pattern, ok := lit.value.(string)
+		if !ok {
+			return nil, errors.New("matches needs a string pattern")
+		}
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern %q: %v", pattern, err)
+		}
+		c.re = re
+	}
+	return c, nil
+}
+
+// Evaluation. Values are nil, bool, float64, string, []interface{} and
+// map[string]interface{}, the types encoding/json decodes into.
+
+// exprValue converts a log field to an expression value
+func exprValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case int:
+		return float64(v)
+	case int64:
+		return float64(v)
+	case time.Time:
+		return v.UTC().Format(time.RFC3339Nano)
+	case []string:
+		list := make([]interface{}, len(v))
+		for i, s := range v {
+			list[i] = s
+		}
+		return list
+	case nil, bool, float64, string, []interface{}, map[string]interface{}:
+		return v
+	}
+	return nil
+}
+
+// exprType names a value's type for error messages
+func exprType(v interface{}) string {
+	switch v.(type) {
+	case nil:
+		return "nil"
+	case bool:
+		return "bool"
+	case float64:
+		return "number"
+	case string:
+		return "string"
+	case []interface{}:
+		return "list"
+	}
+	return "map"
+}
+
+// exprTruth is a condition's value: nil is false, other non-booleans are errors
+func exprTruth(v interface{}) (bool, error) {
+	switch v := v.(type) {
+	case bool:
+		return v, nil
+	case nil:
+		return false, nil
+	}
+	return false, fmt.Errorf("expected a bool, got %s", exprType(v))
+}
+
+func (n exprLiteral) eval(*exprEnv) (interface{}, error) { return n.value, nil }
+
+func (n exprField) eval(env *exprEnv) (interface{}, error) {
+	return exprValue(logFields[n.name](env.entry)), nil
+}
+
+func (exprMeta) eval(env *exprEnv) (interface{}, error) {
+	if !env.metaParsed {
+		env.metaParsed = true
+		switch {
+		case env.entry.RawFormat == rawSyslog:
+			if m, err := parseSyslog(env.entry.Raw, env.entry.Timestamp); err == nil {
+				env.meta = m.meta()
+			}
+		case len(env.entry.Raw) == 0 && isSyslogRule(env.entry.Rule):
+			env.meta = syslogEntryMeta(env.entry)
+		case env.entry.RawFormat != rawProtobuf && len(env.entry.Raw) > 0:
+			var meta map[string]interface{}
+			if json.Unmarshal(env.entry.Raw, &meta) == nil {
+				env.meta = meta
+			}
+		}
+	}
+	return env.meta, nil
+}
+
+func (n exprMember) eval(env *exprEnv) (interface{}, error) {
+	x, err := n.x.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	switch x := x.(type) {
+	case map[string]interface{}:
+		return x[n.name], nil
+	case nil:
+		return nil, nil
+	}
+	return nil, fmt.Errorf("%s has no field %q", exprType(x), n.name)
+}
+
+func (n exprIndex) eval(env *exprEnv) (interface{}, error) {
+	x, err := n.x.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	index, err := n.index.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	switch x := x.(type) {
+	case map[string]interface{}:
+		if key, ok := index.(string); ok {
+			return x[key], nil
+		}
+	case []interface{}:
+		if i, ok := index.(float64); ok {
+			if i < 0 {
+				i += float64(len(x))
+			}
+			if i >= 0 && i < float64(len(x)) && i == math.Trunc(i) {
+				return x[int(i)], nil
+			}
+			return nil, nil
+		}
+	case nil:
+		return nil, nil
+	}
+	return nil, fmt.Errorf("cannot index %s with %s", exprType(x), exprType(index))
+}
+
+func (n exprList) eval(env *exprEnv) (interface{}, error) {
+	list := make([]interface{}, len(n.items))
+	for i, item := range n.items {
+		v, err := item.eval(env)
+		if err != nil {
+			return nil, err
+		}
+		list[i] = v
+	}
+	return list, nil
+}
+
+func (n exprUnary) eval(env *exprEnv) (interface{}, error) {
+	x, err := n.x.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	if n.op == "!" {
+		b, err := exprTruth(x)
+		return !b, err
+	}
+	switch x := x.(type) {
+	case float64:
+		return -x, nil
+	case nil:
+		return nil, nil
+	}
+	return nil, fmt.Errorf("cannot negate %s", exprType(x))
+}
+
+func (n exprBinary) eval(env *exprEnv) (interface{}, error) {
+	l, err := n.l.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	if n.op == "&&" || n.op == "||" {
+		b, err := exprTruth(l)
+		if err != nil || b == (n.op == "||") {
+			return b, err
+		}
+		r, err := n.r.eval(env)
+		if err != nil {
+			return nil, err
+		}
+		return exprTruth(r)
+	}
+	r, err := n.r.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	switch n.op {
+	case "==":
+		return reflect.DeepEqual(l, r), nil
+	case "!=":
+		return !reflect.DeepEqual(l, r), nil
+	case "<", "<=", ">", ">=":
+		c, ok := exprCompare(l, r)
+		if !ok {
+			return false, nil
+		}
+		switch n.op {
+		case "<":
+			return c < 0, nil
+		case "<=":
+			return c <= 0, nil
+		case ">":
+			return c > 0, nil
+		}
+		return c >= 0, nil
+	case "in", "not in":
+		in, err := exprIn(l, r)
+		return in == (n.op == "in"), err
+	}
+	return exprArithmetic(n.op, l, r)
+}
+
+// exprCompare orders two numbers or two strings
+func exprCompare(l, r interface{}) (int, bool) {
+	switch l := l.(type) {
+	case float64:
+		if r, ok := r.(float64); ok {
+			switch {
+			case l < r:
+				return -1, true
+			case l > r:
+				return 1, true
+			}
+			return 0, l == r
+		}
+	case string:
+		if r, ok := r.(string); ok {
+			return strings.Compare(l, r), true
+		}
+	}
+	return 0, false
+}
+
+// exprIn reports whether l is an element of a list, a key of a map or a
+// substring of a string
+func exprIn(l, r interface{}) (bool, error) {
+	switch r := r.(type) {
+	case []interface{}:
+		for _, v := range r {
+			if reflect.DeepEqual(l, v) {
+				return true, nil
+			}
+		}
+		return false, nil
+	case map[string]interface{}:
+		key, ok := l.(string)
+		if !ok {
+			return false, nil
+		}
+		_, ok = r[key]
+		return ok, nil
+	case string:
+		s, ok := l.(string)
+		return ok && strings.Contains(r, s), nil
+	case nil:
+		return false, nil
+	}
+	return false, fmt.Errorf("cannot look for a value in %s", exprType(r))
+}
+
+func exprArithmetic(op string, l, r interface{}) (interface{}, error) {
+	if l == nil || r == nil {
+		return nil, nil
+	}
+	if ls, ok := l.(string); ok && op == "+" {
+		if rs, ok := r.(string); ok {
+			return ls + rs, nil
+		}
+	}
+	a, aok := l.(float64)
+	b, bok := r.(float64)
+	if !aok || !bok {
+		return nil, fmt.Errorf("cannot apply %s to %s and %s", op, exprType(l), exprType(r))
+	}
+	switch op {
+	case "+":
+		return a + b, nil
+	case "-":
+		return a - b, nil
+	case "*":
+		return a * b, nil
+	case "/":
+		if b == 0 {
+			return nil, errors.New("division by zero")
+		}
+		return a / b, nil
+	}
+	if b == 0 {
+		return nil, errors.New("modulo by zero")
+	}
+	return math.Mod(a, b), nil
+}
+
+func (n exprCall) eval(env *exprEnv) (interface{}, error) {
+	args := make([]interface{}, len(n.args))
+	for i, arg := range n.args {
+		v, err := arg.eval(env)
+		if err != nil {
+			return nil, err
+		}
+		args[i] = v
+	}
+	x := args[0]
+	switch n.name {
+	case "len":
+		switch x := x.(type) {
+		case string:
+			return float64(utf8.RuneCountInString(x)), nil
+		case []interface{}:
+			return float64(len(x)), nil
+		case map[string]interface{}:
+			return float64(len(x)), nil
+		case nil:
+			return float64(0), nil
+		}
+		return nil, fmt.Errorf("len of %s", exprType(x))
+	case "contains":
+		if _, ok := x.(string); !ok && x != nil {
+			// tags contains "vpn" looks for an element
+			return exprIn(args[1], x)
+		}
+	}
+	if x == nil {
+		return nil, nil
+	}
+	s, ok := x.(string)
+	if !ok {
+		return nil, fmt.Errorf("%s needs a string, got %s", n.name, exprType(x))
+	}
+	switch n.name {
+	case "lower":
+		return strings.ToLower(s), nil
+	case "upper":
+		return strings.ToUpper(s), nil
+	case "trim":
+		return strings.TrimSpace(s), nil
+	}
+	arg, ok := args[1].(string)
+	if !ok {
+		if args[1] == nil {
+			return false, nil
+		}
+		return nil, fmt.Errorf("%s needs a string argument, got %s", n.name, exprType(args[1]))
+	}
+	switch n.name {
+	case "startsWith":
+		return strings.HasPrefix(s, arg), nil
+	case "endsWith":
+		return strings.HasSuffix(s, arg), nil
+	case "contains":
+		return strings.Contains(s, arg), nil
+	}
+	re := n.re
+	if re == nil {
+		var err error
+		if re, err = exprPatterns.compile(arg); err != nil {
+			return nil, fmt.Errorf("invalid pattern %q: %v", arg, err)
+		}
+	}
+	return re.MatchString(s), nil
+}
+
+// exprPatternCacheSize bounds the patterns of matches kept compiled
+const exprPatternCacheSize = 256
+
+// exprPatternCache holds the compiled patterns of matches calls whose pattern
+// is computed, like message.matches(meta.pattern), so that evaluating one
+// against every log doesn't compile it each time. Invalid patterns are kept
+// too. When it is full it starts over, which bounds it when every log has a
+// different pattern.
+type exprPatternCache struct {
+	mu       sync.Mutex
+	patterns map[string]exprPattern
+}
+
+type exprPattern struct {
+	re  *regexp.Regexp
+	err error
+}
+
+var exprPatterns exprPatternCache
+
+// compile returns the compiled pattern, compiling it on first use
+func (c *exprPatternCache) compile(pattern string) (*regexp.Regexp, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if p, ok := c.patterns[pattern]; ok {
+		return p.re, p.err
+	}
+	if c.patterns == nil || len(c.patterns) >= exprPatternCacheSize {
+		c.patterns = make(map[string]exprPattern)
+	}
+	re, err := regexp.Compile(pattern)
+	c.patterns[pattern] = exprPattern{re: re, err: err}
+	return re, err
+}
@@ -0,0 +1,165 @@
+package logserver
+
+import (
+	"strings"
+	"testing"
+)
+
+// exprTestEntry is the log the expression tests match against
+var exprTestEntry = LogEntry{Level: "ERROR", SourceIP: "10.0.0.1", Event: "Failed login", User: "alice", Tags: []string{"vpn"},
+	Raw: []byte(`{"status":503,"user":{"name":"Alice"},"ports":[22,443],"pattern":"^Fail","bad":"("}`), RawFormat: rawJSON}
+
+// FuzzExpr parses alert rule expressions and matches them against one log
+func FuzzExpr(f *testing.F) {
+	addCorpus(f, "expr", func(data []byte) { f.Add(string(data)) })
+	f.Fuzz(func(t *testing.T, input string) {
+		expr, err := parseLogExpr(input)
+		if err != nil {
+			return
+		}
+		expr.match(exprTestEntry)
+	})
+}
+
+func TestExprMatch(t *testing.T) {
+	for _, tc := range []struct {
+		expr string
+		want bool
+	}{
+		{`level == "ERROR" && meta.status >= 500 && source.startsWith("10.")`, true},
+		{`level == "INFO" || user == "alice"`, true},
+		{`not (level == "ERROR") or false`, false},
+		{`!true`, false},
+		{`meta.status - 3 == 500 && meta.status % 2 == 1 && -meta.status < 0`, true},
+		{`meta.status / 2 > 251 && meta.status * 2 == 1_006`, true},
+		{`1e2 == 100`, true},
+		{`"a" < "b" && "b" <= "b"`, true},
+		{`"ab" + "c" == "abc"`, true},
+		{`"vpn" in tags && "ssh" not in tags`, true},
+		{`tags contains "vpn"`, true},
+		{`"log" in event && "user" in meta && !("x" in meta)`, true},
+		{`meta.status in [500, 503]`, true},
+		{`meta.ports[0] == 22 && meta.ports[-1] == 443 && meta.ports[2] == nil`, true},
+		{`meta["user"].name == "Alice"`, true},
+		{`len(meta.ports) == 2 && len(event) == 12 && len(meta.user) == 1 && len(meta.missing) == 0`, true},
+		{`meta.user.name.lower() == "alice" && upper(user) == "ALICE" && trim("  x ") == "x"`, true},
+		{`event startsWith "Failed" && event endsWith "login" && event contains "ed lo"`, true},
+		{`event.startsWith("login")`, false},
+		{`event matches "(?i)failed\\s+LOGIN"`, true},
+		{`event.matches(meta.pattern)`, true},
+		{`user.matches(meta.pattern)`, false},
+		{`event matches meta.missing`, false},
+
+		// Missing fields are nil, and nil doesn't order
+		{`meta.missing == nil && meta.missing.deeper == nil && meta.missing[0] == nil`, true},
+		{`meta.missing >= 500`, false},
+		{`meta.missing < 500`, false},
+		{`meta.missing + 1 == nil`, true},
+		{`meta.missing.startsWith("x")`, false},
+		{`meta.missing`, false},
+
+		// Comparing different types is false rather than an error
+		{`meta.status == "503"`, false},
+		{`meta.status < "6"`, false},
+	} {
+		e, err := parseLogExpr(tc.expr)
+		if err != nil {
+			t.Errorf("%s: %v", tc.expr, err)
+			continue
+		}
+		got, err := e.match(exprTestEntry)
+		if err != nil {
+			t.Errorf("%s: %v", tc.expr, err)
+			continue
+		}
+		if got != tc.want {
+			t.Errorf("%s = %v, want %v", tc.expr, got, tc.want)
+		}
+	}
+}
+
+func TestExprParseErrors(t *testing.T) {
+	for _, tc := range []struct {
+		expr string
+		want string
+	}{
+		{`level ==`, "unexpected"},
+		{`level == "ERROR" )`, "unexpected"},
+		{`nosuchfield == 1`, "nosuchfield"},
+		{`shout(level)`, `unknown function "shout"`},
+		{`len(level, user)`, "len takes 1 arguments, got 2"},
+		{`event matches "("`, `invalid pattern "("`},
+		{`event matches 1`, "matches needs a string pattern"},
+		{`"unterminated`, "unterminated"},
+		{strings.Repeat("(", exprMaxDepth+1) + "true" + strings.Repeat(")", exprMaxDepth+1), "deep"},
+	} {
+		_, err := parseLogExpr(tc.expr)
+		if err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%s: err = %v, want %q", tc.expr, err, tc.want)
+		}
+	}
+}
+
+func TestExprEvalErrors(t *testing.T) {
+	for _, tc := range []struct {
+		expr string
+		want string
+	}{
+		{`level`, "expected a bool, got string"},
+		{`meta.status / 0 == 1`, "division by zero"},
+		{`meta.status % 0 == 1`, "modulo by zero"},
+		{`level - 1 == 0`, "cannot apply - to string and number"},
+		{`-level == nil`, "cannot negate string"},
+		{`level.name == nil`, `string has no field "name"`},
+		{`meta.status.lower() == nil`, "lower needs a string, got number"},
+		{`event.startsWith(1)`, "startsWith needs a string argument, got number"},
+		{`event.matches(meta.bad)`, `invalid pattern "("`},
+		{`1 in meta.status`, "cannot look for a value in number"},
+	} {
+		e, err := parseLogExpr(tc.expr)
+		if err != nil {
+			t.Errorf("%s: %v", tc.expr, err)
+			continue
+		}
+		_, err = e.match(exprTestEntry)
+		if err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%s: err = %v, want %q", tc.expr, err, tc.want)
+		}
+	}
+}
+
+func TestExprUses(t *testing.T) {
+	e, err := parseLogExpr(`source == "10.0.0.1" && level == "ERROR"`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect(t, "uses sourceIP", e.uses("sourceIP"), true)
+	expect(t, "uses level", e.uses("level"), true)
+	expect(t, "uses user", e.uses("user"), false)
+}
+
+func TestExprPatternCache(t *testing.T) {
+	var c exprPatternCache
+	re, err := c.compile("^a+$")
+	if err != nil {
+		t.Fatal(err)
+	}
+	again, _ := c.compile("^a+$")
+	if again != re {
+		t.Error("compiling a pattern again did not reuse it")
+	}
+	if _, err := c.compile("("); err == nil {
+		t.Error("invalid pattern compiled")
+	}
+	if _, err := c.compile("("); err == nil {
+		t.Error("cached invalid pattern compiled")
+	}
+
+	// A cache full of patterns starts over rather than growing
+	for i := 0; i < 2*exprPatternCacheSize; i++ {
+		c.compile(strings.Repeat("a", i))
+	}
+	if n := len(c.patterns); n > exprPatternCacheSize {
+		t.Errorf("cache holds %d patterns, want at most %d", n, exprPatternCacheSize)
+	}
+}
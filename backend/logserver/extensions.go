@@ -0,0 +1,243 @@
+package logserver
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"plugin"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Extension points. Programs that embed the server register their own
+// ingest processors, enrichers, notification channels and auth providers
+// before calling Start; Go plugins in PLUGIN_DIR register theirs when Start
+// loads them.
+
+// Roles an AuthProvider may grant
+const (
+	RoleAdmin  = roleAdmin
+	RoleViewer = roleViewer
+)
+
+// ErrDropLog, returned by an IngestProcessor, drops the log without failing
+// the ingest request
+var ErrDropLog = errors.New("log dropped by ingest processor")
+
+// IngestProcessor sees every log before it is routed and stored, in
+// registration order. It may rewrite the entry in place, drop it with
+// ErrDropLog or reject it with any other error, which fails the ingest.
+type IngestProcessor interface {
+	Name() string
+	Process(entry *LogEntry) error
+}
+
+// Enricher adds fields to every log after the ingest processors accepted
+// it. An enricher's error is logged and the log stored as it is.
+type Enricher interface {
+	Name() string
+	Enrich(entry *LogEntry) error
+}
+
+// NotificationChannel is sent every alert notification that is not muted or
+// snoozed, next to the rule's webhook
+type NotificationChannel interface {
+	Name() string
+	Notify(ctx context.Context, rule AlertRule, instance AlertInstance, message string) error
+}
+
+// AuthProvider authenticates requests the built-in admin token and API keys
+// do not, such as those carrying an SSO proxy's headers. It returns
+// RoleAdmin or RoleViewer and true for callers it recognises.
+type AuthProvider interface {
+	Name() string
+	Authenticate(r *http.Request) (role string, ok bool)
+}
+
+// pluginDir holds Go plugins (*.so) to load at startup. Each must export
+// `func Register()`, which calls the Register functions below.
+var pluginDir = os.Getenv("PLUGIN_DIR")
+
+// notifyChannelTimeout bounds one NotificationChannel.Notify call
+const notifyChannelTimeout = 10 * time.Second
+
+var extensions struct {
+	sync.RWMutex
+	processors []IngestProcessor
+	enrichers  []Enricher
+	channels   []NotificationChannel
+	auth       []AuthProvider
+	plugins    []string
+}
+
+// RegisterIngestProcessor adds p after the processors already registered
+func RegisterIngestProcessor(p IngestProcessor) {
+	extensions.Lock()
+	defer extensions.Unlock()
+	extensions.processors = append(extensions.processors, p)
+}
+
+// RegisterEnricher adds e after the enrichers already registered
+func RegisterEnricher(e Enricher) {
+	extensions.Lock()
+	defer extensions.Unlock()
+	extensions.enrichers = append(extensions.enrichers, e)
+}
+
+// RegisterNotificationChannel adds c to the channels alerts notify
+func RegisterNotificationChannel(c NotificationChannel) {
+	extensions.Lock()
+	defer extensions.Unlock()
+	extensions.channels = append(extensions.channels, c)
+}
+
+// RegisterAuthProvider adds a to the providers consulted, in order, for
+// requests without a built-in credential
+func RegisterAuthProvider(a AuthProvider) {
+	extensions.Lock()
+	defer extensions.Unlock()
+	extensions.auth = append(extensions.auth, a)
+}
+
+// processIngest runs the ingest processors, then the extra ones, and then
+// the enrichers on entry. It reports false when a processor dropped the log.
+func processIngest(entry *LogEntry, extra ...IngestProcessor) (bool, error) {
+	extensions.RLock()
+	processors, enrichers := extensions.processors, extensions.enrichers
+	extensions.RUnlock()
+	if len(extra) > 0 {
+		processors = append(processors[:len(processors):len(processors)], extra...)
+	}
+	for _, p := range processors {
+		if err := p.Process(entry); err != nil {
+			if errors.Is(err, ErrDropLog) {
+				return false, nil
+			}
+			return false, fmt.Errorf("ingest processor %s: %w", p.Name(), err)
+		}
+	}
+	for _, e := range enrichers {
+		if err := e.Enrich(entry); err != nil {
+			log.Printf("enricher %s: %v", e.Name(), err)
+		}
+	}
+	return true, nil
+}
+
+// notifyChannels sends the notification to every registered channel
+func notifyChannels(rule AlertRule, instance AlertInstance) {
+	extensions.RLock()
+	channels := extensions.channels
+	extensions.RUnlock()
+	if len(channels) == 0 {
+		return
+	}
+	message, err := renderNotification(rule, instance)
+	if err != nil {
+		log.Printf("alert %d: failed to render message template: %v", instance.ID, err)
+	}
+	for _, c := range channels {
+		ctx, cancel := context.WithTimeout(context.Background(), notifyChannelTimeout)
+		if err := c.Notify(ctx, rule, instance, message); err != nil {
+			log.Printf("alert %d: channel %s failed: %v", instance.ID, c.Name(), err)
+		}
+		cancel()
+	}
+}
+
+// providerRole asks the auth providers, in order, for the request's role.
+// name is the provider that recognised the caller.
+func providerRole(r *http.Request) (name, role string, ok bool) {
+	extensions.RLock()
+	providers := extensions.auth
+	extensions.RUnlock()
+	for _, a := range providers {
+		role, ok := a.Authenticate(r)
+		if !ok {
+			continue
+		}
+		if role != roleAdmin && role != roleViewer {
+			log.Printf("auth provider %s: unknown role %q", a.Name(), role)
+			continue
+		}
+		return a.Name(), role, true
+	}
+	return "", "", false
+}
+
+// loadPlugins opens every Go plugin in dir and calls its Register function
+func loadPlugins(dir string) error {
+	if dir == "" {
+		return nil
+	}
+	paths, err := filepath.Glob(filepath.Join(dir, "*.so"))
+	if err != nil {
+		return err
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		p, err := plugin.Open(path)
+		if err != nil {
+			return fmt.Errorf("plugin %s: %w", path, err)
+		}
+		sym, err := p.Lookup("Register")
+		if err != nil {
+			return fmt.Errorf("plugin %s: %w", path, err)
+		}
+		register, ok := sym.(func())
+		if !ok {
+			return fmt.Errorf("plugin %s: Register is %T, want func()", path, sym)
+		}
+		register()
+		extensions.Lock()
+		extensions.plugins = append(extensions.plugins, filepath.Base(path))
+		extensions.Unlock()
+		log.Printf("Loaded plugin %s", filepath.Base(path))
+	}
+	return nil
+}
+
+// extensionsHandlerDB lists the registered extensions by name
+func extensionsHandlerDB(w http.ResponseWriter, r *http.Request, db *Database) {
+	enableCORS(w)
+	w.Header().Set("Content-Type", "application/json")
+	if !db.requireAdmin(w, r) {
+		return
+	}
+	if r.Method != http.MethodGet {
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	extensions.RLock()
+	defer extensions.RUnlock()
+	processors := make([]string, 0, len(extensions.processors))
+	for _, p := range extensions.processors {
+		processors = append(processors, p.Name())
+	}
+	enrichers := make([]string, 0, len(extensions.enrichers))
+	for _, e := range extensions.enrichers {
+		enrichers = append(enrichers, e.Name())
+	}
+	channels := make([]string, 0, len(extensions.channels))
+	for _, c := range extensions.channels {
+		channels = append(channels, c.Name())
+	}
+	auth := make([]string, 0, len(extensions.auth))
+	for _, a := range extensions.auth {
+		auth = append(auth, a.Name())
+	}
+	plugins := append([]string{}, extensions.plugins...)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"ingestProcessors":     processors,
+		"enrichers":            enrichers,
+		"notificationChannels": channels,
+		"authProviders":        auth,
+		"plugins":              plugins,
+	})
+}
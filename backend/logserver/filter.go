@@ -0,0 +1,355 @@
+package logserver
+
+import (
+	"encoding/json"
+	"errors"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// LogFilter is a set of conditions on the logs table shared by search,
+// alert evaluation and other features that select a subset of logs
+type LogFilter struct {
+	IP    string `json:"ip,omitempty"`
+	Event string `json:"event,omitempty"`
+	// Match (contains, exact or prefix; default contains) and CaseSensitive
+	// control how IP and Event are compared
+	Match         string `json:"match,omitempty"`
+	CaseSensitive bool   `json:"caseSensitive,omitempty"`
+	// The remaining fields match any of their values exactly, unlike the
+	// substring IP and Event filters
+	Level           stringList `json:"level,omitempty"`
+	Source          stringList `json:"source,omitempty"`
+	Destination     stringList `json:"destination,omitempty"`
+	Rule            stringList `json:"rule,omitempty"`
+	Category        stringList `json:"category,omitempty"`
+	SourcePort      stringList `json:"sourcePort,omitempty"`
+	DestinationPort stringList `json:"destinationPort,omitempty"`
+	User            stringList `json:"user,omitempty"`
+	TraceID         stringList `json:"traceId,omitempty"`
+	Urgency         stringList `json:"urgency,omitempty"`
+	// Tag matches logs carrying any of the tags
+	Tag stringList `json:"tag,omitempty"`
+	// The time range is supplied per query, never persisted with a filter
+	From time.Time `json:"-"`
+	To   time.Time `json:"-"`
+	// Require and Exclude hold the caller's data scopes (see scopes.go):
+	// every Require filter must match and no Exclude filter may
+	Require []LogFilter `json:"-"`
+	Exclude []LogFilter `json:"-"`
+}
+
+// where returns SQL conditions (each prefixed with AND) and their arguments
+func (f LogFilter) where() (string, []interface{}) {
+	clause := ""
+	args := []interface{}{}
+	if f.IP != "" {
+		src, srcArgs := f.textCondition("source_ip", f.IP)
+		dst, dstArgs := f.textCondition("destination_ip", f.IP)
+		clause += ` AND (` + src + ` OR ` + dst + `)`
+		args = append(append(args, srcArgs...), dstArgs...)
+	}
+	if f.Event != "" {
+		cond, condArgs := f.textCondition("event", f.Event)
+		clause += ` AND ` + cond
+		args = append(args, condArgs...)
+	}
+	clause, args = f.Level.in(clause, args, "level")
+	clause, args = f.Source.in(clause, args, "source_ip")
+	clause, args = f.Destination.in(clause, args, "destination_ip")
+	clause, args = f.Rule.in(clause, args, "rule")
+	clause, args = f.Category.in(clause, args, ruleCategoryExpr)
+	clause, args = f.SourcePort.in(clause, args, "source_port")
+	clause, args = f.DestinationPort.in(clause, args, "destination_port")
+	clause, args = f.User.in(clause, args, "user_name")
+	clause, args = f.TraceID.in(clause, args, "trace_id")
+	clause, args = f.Urgency.in(clause, args, "urgency")
+	if len(f.Tag) > 0 {
+		clause, args = f.Tag.in(clause+` AND id IN (SELECT log_id FROM log_tags WHERE 1=1`, args, "tag")
+		clause += `)`
+	}
+	if !f.From.IsZero() {
+		clause += ` AND timestamp >= ?`
+		args = append(args, f.From.UTC())
+	}
+	if !f.To.IsZero() {
+		clause += ` AND timestamp < ?`
+		args = append(args, f.To.UTC())
+	}
+	for _, sub := range f.Require {
+		cond, condArgs := sub.where()
+		clause += ` AND (1=1` + cond + `)`
+		args = append(args, condArgs...)
+	}
+	// A NULL column must not let a log escape an exclusion
+	for _, sub := range f.Exclude {
+		cond, condArgs := sub.where()
+		clause += ` AND NOT COALESCE(1=1` + cond + `, 0)`
+		args = append(args, condArgs...)
+	}
+	return clause, args
+}
+
+// Text match modes for the IP and Event filters
+const (
+	matchContains = "contains"
+	matchExact    = "exact"
+	matchPrefix   = "prefix"
+)
+
+// likeEscaper escapes LIKE wildcards so user input matches literally
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// textCondition compares column with value using the filter's match mode and
+// case sensitivity. NOCASE and LIKE fold ASCII letters only, so "é" and "É"
+// differ, and instr is used for case-sensitive substring and prefix tests.
+func (f LogFilter) textCondition(column, value string) (string, []interface{}) {
+	switch {
+	case f.Match == matchExact && f.CaseSensitive:
+		return column + ` = ?`, []interface{}{value}
+	case f.Match == matchExact:
+		return column + ` = ? COLLATE NOCASE`, []interface{}{value}
+	case f.Match == matchPrefix && f.CaseSensitive:
+		return `instr(` + column + `, ?) = 1`, []interface{}{value}
+	case f.Match == matchPrefix:
+		return column + ` LIKE ? ESCAPE '\'`, []interface{}{likeEscaper.Replace(value) + "%"}
+	case f.CaseSensitive:
+		return `instr(` + column + `, ?) > 0`, []interface{}{value}
+	default:
+		return column + ` LIKE ? ESCAPE '\'`, []interface{}{"%" + likeEscaper.Replace(value) + "%"}
+	}
+}
+
+// matchText applies the same comparison as textCondition in Go, folding
+// case the way SQLite does
+func (f LogFilter) matchText(text, value string) bool {
+	if !f.CaseSensitive {
+		text, value = asciiLower(text), asciiLower(value)
+	}
+	switch f.Match {
+	case matchExact:
+		return text == value
+	case matchPrefix:
+		return strings.HasPrefix(text, value)
+	default:
+		return strings.Contains(text, value)
+	}
+}
+
+// asciiLower lowers ASCII letters and leaves every other byte alone, as
+// SQLite's NOCASE collation and LIKE do
+func asciiLower(s string) string {
+	var b []byte
+	for i := 0; i < len(s); i++ {
+		if c := s[i]; 'A' <= c && c <= 'Z' {
+			if b == nil {
+				b = []byte(s)
+			}
+			b[i] = c + 'a' - 'A'
+		}
+	}
+	if b == nil {
+		return s
+	}
+	return string(b)
+}
+
+// stringList is a multi-value filter. In JSON it is a string when it holds a
+// single value, so filters saved before it held several values still load.
+type stringList []string
+
+func (l stringList) MarshalJSON() ([]byte, error) {
+	if len(l) == 1 {
+		return json.Marshal(l[0])
+	}
+	return json.Marshal([]string(l))
+}
+
+func (l *stringList) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*l = splitValues([]string{single})
+		return nil
+	}
+	var list []string
+	if err := json.Unmarshal(data, &list); err != nil {
+		return err
+	}
+	*l = splitValues(list)
+	return nil
+}
+
+// in appends an IN condition on expr when the list is non-empty
+func (l stringList) in(clause string, args []interface{}, expr string) (string, []interface{}) {
+	if len(l) == 0 {
+		return clause, args
+	}
+	clause += ` AND ` + expr + ` IN (?` + strings.Repeat(`, ?`, len(l)-1) + `)`
+	for _, v := range l {
+		args = append(args, v)
+	}
+	return clause, args
+}
+
+// splitValues flattens repeated and comma-separated parameter values, dropping empty ones
+func splitValues(values []string) stringList {
+	var list stringList
+	for _, v := range values {
+		for _, part := range strings.Split(v, ",") {
+			if part = strings.TrimSpace(part); part != "" {
+				list = append(list, part)
+			}
+		}
+	}
+	return list
+}
+
+// ruleCategoryExpr is LogEntry.category in SQL
+const ruleCategoryExpr = `(CASE
+	WHEN category != '' THEN category
+	WHEN rule LIKE '%login%' OR rule LIKE '%access%' THEN 'access'
+	WHEN rule LIKE '%network%' OR rule LIKE '%traffic%' THEN 'network'
+	WHEN rule LIKE '%threat%' OR rule LIKE '%malware%' THEN 'threat'
+	WHEN rule LIKE '%behavior%' OR rule LIKE '%uba%' THEN 'uba'
+	ELSE 'access' END)`
+
+// searchParamKeys are the query parameters parseLogFilter reads, less the
+// absolute from/to bounds and case_sensitive
+var searchParamKeys = map[string]bool{
+	"ip": true, "event": true, "level": true, "source": true, "destination": true, "rule": true,
+	"category": true, "src_port": true, "dst_port": true, "tag": true, "user": true, "trace_id": true,
+	"urgency": true, "since": true, "range": true, "tz": true, "match": true,
+}
+
+// parseLogFilter reads a LogFilter from search query parameters; the time
+// range is optional RFC3339 from/to bounds or a since=/range= relative range
+// ending at now
+func parseLogFilter(q url.Values, now time.Time) (LogFilter, error) {
+	f := LogFilter{
+		IP:          q.Get("ip"),
+		Event:       q.Get("event"),
+		Level:       splitValues(q["level"]),
+		Source:      splitValues(q["source"]),
+		Destination: splitValues(q["destination"]),
+		Rule:        splitValues(q["rule"]),
+		Category:    splitValues(q["category"]),
+		Match:       q.Get("match"),
+
+		SourcePort:      splitValues(q["src_port"]),
+		DestinationPort: splitValues(q["dst_port"]),
+		Tag:             splitValues(q["tag"]),
+		User:            splitValues(q["user"]),
+		TraceID:         splitValues(q["trace_id"]),
+		Urgency:         splitValues(q["urgency"]),
+	}
+	for _, port := range append(f.SourcePort, f.DestinationPort...) {
+		if p, err := strconv.Atoi(port); err != nil || !validPort(p) {
+			return f, errors.New("Invalid port " + port)
+		}
+	}
+	for i, urgency := range f.Urgency {
+		value, err := strconv.Atoi(urgency)
+		if err != nil {
+			return f, errors.New("Invalid urgency " + urgency)
+		}
+		f.Urgency[i] = strconv.Itoa(value)
+	}
+	switch f.Match {
+	case "", matchContains, matchExact, matchPrefix:
+	default:
+		return f, errors.New("match must be exact, contains or prefix")
+	}
+	if v := q.Get("case_sensitive"); v != "" {
+		caseSensitive, err := strconv.ParseBool(v)
+		if err != nil {
+			return f, errors.New("case_sensitive must be true or false")
+		}
+		f.CaseSensitive = caseSensitive
+	}
+	from, to, ok, err := relativeRange(q, now)
+	if ok || err != nil {
+		f.From, f.To = from, to
+		return f, err
+	}
+	if v := q.Get("from"); v != "" {
+		if f.From, err = time.Parse(time.RFC3339, v); err != nil {
+			return f, errors.New("Invalid 'from' timestamp")
+		}
+	}
+	if v := q.Get("to"); v != "" {
+		if f.To, err = time.Parse(time.RFC3339, v); err != nil {
+			return f, errors.New("Invalid 'to' timestamp")
+		}
+	}
+	return f, nil
+}
+
+// values is the inverse of parseLogFilter
+func (f LogFilter) values() url.Values {
+	q := url.Values{}
+	for key, v := range map[string]string{"ip": f.IP, "event": f.Event, "match": f.Match} {
+		if v != "" {
+			q.Set(key, v)
+		}
+	}
+	for key, list := range map[string]stringList{
+		"level": f.Level, "source": f.Source, "destination": f.Destination, "rule": f.Rule, "category": f.Category,
+		"src_port": f.SourcePort, "dst_port": f.DestinationPort, "tag": f.Tag, "user": f.User, "trace_id": f.TraceID,
+		"urgency": f.Urgency,
+	} {
+		if len(list) > 0 {
+			q[key] = list
+		}
+	}
+	if f.CaseSensitive {
+		q.Set("case_sensitive", "true")
+	}
+	if !f.From.IsZero() {
+		q.Set("from", f.From.UTC().Format(time.RFC3339))
+	}
+	if !f.To.IsZero() {
+		q.Set("to", f.To.UTC().Format(time.RFC3339))
+	}
+	return q
+}
+
+// buildCountQuery returns the SQL and arguments used by CountLogs
+func buildCountQuery(filter LogFilter) (string, []interface{}) {
+	clause, args := filter.where()
+	return `SELECT COUNT(*) FROM logs WHERE 1=1` + clause, args
+}
+
+// buildExistsQuery returns the SQL and arguments used by LogsExist
+func buildExistsQuery(filter LogFilter) (string, []interface{}) {
+	clause, args := filter.where()
+	return `SELECT EXISTS (SELECT 1 FROM logs WHERE 1=1` + clause + `)`, args
+}
+
+func (d *Database) CountLogs(filter LogFilter) (int, error) {
+	query, args := buildCountQuery(filter)
+	var count int
+	err := d.db.QueryRow(query, args...).Scan(&count)
+	return count, err
+}
+
+// LogsExist reports whether any log matches filter, stopping at the first match
+func (d *Database) LogsExist(filter LogFilter) (bool, error) {
+	query, args := buildExistsQuery(filter)
+	var exists bool
+	err := d.db.QueryRow(query, args...).Scan(&exists)
+	return exists, err
+}
+
+// FilterLogs returns the newest logs matching filter
+func (d *Database) FilterLogs(filter LogFilter, limit int) ([]LogEntry, error) {
+	clause, args := filter.where()
+	args = append(args, limit)
+	rows, err := d.db.Query(`SELECT `+logColumns+` FROM logs WHERE 1=1`+clause+` ORDER BY timestamp DESC LIMIT ?`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return d.scanLogs(rows)
+}
@@ -0,0 +1,68 @@
+package logserver
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// The JSON, protobuf, syslog and GELF ingest decoders, the query and alert
+// expression parsers and the WASM processor loader take untrusted network
+// input, so each has a fuzz target.
+// A target feeds one input to its parser; errors are expected and a panic is
+// a crash. Seeds come from testdata/corpus/<target>, and go test saves the
+// inputs that crash under testdata/fuzz/<FuzzName>, where every later run
+// replays them.
+
+// addCorpus adds the seed inputs in testdata/corpus/<target> to f
+func addCorpus(f *testing.F, target string, add func(data []byte)) {
+	dir := filepath.Join("testdata", "corpus", target)
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		f.Fatal(err)
+	}
+	for _, file := range files {
+		data, err := os.ReadFile(filepath.Join(dir, file.Name()))
+		if err != nil {
+			f.Fatal(err)
+		}
+		add(data)
+	}
+}
+
+// defaultLevelConfig returns the levels and urgencies a new database starts with
+func defaultLevelConfig() *levelConfig {
+	c := &levelConfig{}
+	c.set(defaultLevels, defaultUrgencies)
+	return c
+}
+
+// FuzzJSONIngest feeds JSON ingest bodies, single logs and batches, through
+// validation
+func FuzzJSONIngest(f *testing.F) {
+	addCorpus(f, "json", func(data []byte) { f.Add(data) })
+	f.Fuzz(func(t *testing.T, input []byte) {
+		entries, err := decodeIngestBody("application/json", input, nil)
+		if err != nil {
+			return
+		}
+		for i := range entries {
+			prepareLogEntry(&entries[i], time.Now())
+		}
+	})
+}
+
+// FuzzProtobufIngest feeds protobuf LogBatch ingest bodies through validation
+func FuzzProtobufIngest(f *testing.F) {
+	addCorpus(f, "protobuf", func(data []byte) { f.Add(data) })
+	f.Fuzz(func(t *testing.T, input []byte) {
+		entries, err := decodeIngestBody(contentTypeProtobuf, input, nil)
+		if err != nil {
+			return
+		}
+		for i := range entries {
+			prepareLogEntry(&entries[i], time.Now())
+		}
+	})
+}
@@ -0,0 +1,214 @@
+package logserver
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"encoding/json"
+	"errors"
+	"io"
+	"math"
+	"net"
+	"strings"
+	"time"
+)
+
+// GELF, Graylog's log format, as Docker's gelf log driver sends it: one JSON
+// object per message, optionally gzip or zlib compressed, and over UDP split
+// into chunks when it does not fit one datagram. See
+// https://go2docs.graylog.org/current/getting_in_log_data/gelf.html
+
+// rawGELF is the raw payload format of logs received as GELF; the payload
+// is the decompressed JSON message
+const rawGELF = "gelf"
+
+// gelfMaxBytes is the largest message accepted once decompressed
+var gelfMaxBytes = envInt("GELF_MAX_BYTES", 1<<20)
+
+// GELF chunking: each chunk starts with the magic bytes, an 8-byte message
+// ID, its sequence number and the chunk count
+const (
+	gelfChunkHeader  = 12
+	gelfMaxChunks    = 128
+	gelfChunkTimeout = 5 * time.Second
+	// gelfPendingBytes caps the chunks held for incomplete messages
+	gelfPendingBytes = 32 << 20
+)
+
+var (
+	errGELFTooLarge   = errors.New("GELF message is too large")
+	errGELFIncomplete = errors.New("GELF chunks are incomplete")
+)
+
+// gelfMessage holds the GELF fields a log is made of; the rest, such as
+// Docker's _container_id and _image_name, stay in the raw payload
+type gelfMessage struct {
+	Version       string   `json:"version"`
+	Host          string   `json:"host"`
+	ShortMessage  string   `json:"short_message"`
+	FullMessage   string   `json:"full_message"`
+	Timestamp     *float64 `json:"timestamp"`
+	Level         *int     `json:"level"`
+	ContainerName string   `json:"_container_name"`
+}
+
+// decompressGELF returns a message, decompressing it if it starts with the
+// gzip or zlib magic bytes as GELF allows
+func decompressGELF(data []byte) ([]byte, error) {
+	var r io.Reader
+	switch {
+	case len(data) >= 2 && data[0] == 0x1f && data[1] == 0x8b:
+		gz, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		r = gz
+	case len(data) >= 2 && data[0]&0x0f == 8 && (uint16(data[0])<<8|uint16(data[1]))%31 == 0:
+		zr, err := zlib.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		r = zr
+	default:
+		if len(data) > gelfMaxBytes {
+			return nil, errGELFTooLarge
+		}
+		return data, nil
+	}
+	message, err := io.ReadAll(io.LimitReader(r, int64(gelfMaxBytes)+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(message) > gelfMaxBytes {
+		return nil, errGELFTooLarge
+	}
+	return message, nil
+}
+
+// parseGELF parses a decompressed message into a log. The source is the
+// host when it is an address, and otherwise remote.
+func parseGELF(message []byte, remote string) (LogEntry, error) {
+	var m gelfMessage
+	if err := json.Unmarshal(message, &m); err != nil {
+		return LogEntry{}, errors.New("GELF message is not valid JSON")
+	}
+	if m.ShortMessage == "" {
+		return LogEntry{}, errors.New("GELF message needs a short_message")
+	}
+	entry := LogEntry{
+		Rule:        "gelf",
+		Event:       m.ShortMessage,
+		Description: m.FullMessage,
+		SourceIP:    remote,
+		Tags:        []string{"gelf"},
+	}
+	if name := strings.TrimPrefix(m.ContainerName, "/"); name != "" {
+		entry.Rule = "gelf:" + name
+	}
+	// GELF levels are syslog severities, ALERT when absent
+	level := 1
+	if m.Level != nil {
+		if *m.Level < 0 || *m.Level >= len(syslogLevels) {
+			return LogEntry{}, errors.New("GELF level must be a syslog severity, 0 to 7")
+		}
+		level = *m.Level
+	}
+	entry.Level = syslogLevels[level]
+	if m.Timestamp != nil {
+		sec, frac := math.Modf(*m.Timestamp)
+		entry.Timestamp = time.Unix(int64(sec), int64(math.Round(frac*1e6))*1e3).UTC()
+	}
+	if net.ParseIP(m.Host) != nil {
+		entry.SourceIP = m.Host
+	}
+	return entry, nil
+}
+
+// gelfChunks reassembles chunked messages. It is used by one goroutine.
+type gelfChunks struct {
+	pending   map[[8]byte]*gelfPartial
+	size      int
+	lastSweep time.Time
+	// expired is called for each message given up on
+	expired func()
+}
+
+// gelfPartial is a message still missing chunks
+type gelfPartial struct {
+	chunks   [][]byte
+	received int
+	size     int
+	started  time.Time
+}
+
+// isGELFChunk reports whether a datagram is a chunk rather than a message
+func isGELFChunk(datagram []byte) bool {
+	return len(datagram) >= 2 && datagram[0] == 0x1e && datagram[1] == 0x0f
+}
+
+// add takes a chunk and returns the message once all its chunks are in
+func (c *gelfChunks) add(datagram []byte, now time.Time) ([]byte, error) {
+	c.sweep(now)
+	if len(datagram) < gelfChunkHeader {
+		return nil, errors.New("GELF chunk is truncated")
+	}
+	var id [8]byte
+	copy(id[:], datagram[2:10])
+	seq, count := int(datagram[10]), int(datagram[11])
+	if count < 1 || count > gelfMaxChunks || seq >= count {
+		return nil, errors.New("GELF chunk has an invalid sequence number or count")
+	}
+	if c.pending == nil {
+		c.pending = make(map[[8]byte]*gelfPartial)
+	}
+	p := c.pending[id]
+	if p == nil {
+		p = &gelfPartial{chunks: make([][]byte, count), started: now}
+		c.pending[id] = p
+	}
+	if len(p.chunks) != count {
+		c.drop(id)
+		return nil, errors.New("GELF chunks disagree on their count")
+	}
+	if p.chunks[seq] != nil {
+		return nil, nil
+	}
+	data := datagram[gelfChunkHeader:]
+	if c.size+len(data) > gelfPendingBytes {
+		return nil, errGELFIncomplete
+	}
+	p.chunks[seq] = bytes.Clone(data)
+	p.received++
+	p.size += len(data)
+	c.size += len(data)
+	if p.received < count {
+		return nil, nil
+	}
+	c.drop(id)
+	return bytes.Join(p.chunks, nil), nil
+}
+
+// drop forgets a pending message
+func (c *gelfChunks) drop(id [8]byte) {
+	if p := c.pending[id]; p != nil {
+		c.size -= p.size
+		delete(c.pending, id)
+	}
+}
+
+// sweep gives up on messages whose chunks have not all arrived within
+// gelfChunkTimeout, looking at most once a second
+func (c *gelfChunks) sweep(now time.Time) {
+	if now.Sub(c.lastSweep) < time.Second {
+		return
+	}
+	c.lastSweep = now
+	for id, p := range c.pending {
+		if now.Sub(p.started) > gelfChunkTimeout {
+			c.drop(id)
+			if c.expired != nil {
+				c.expired()
+			}
+		}
+	}
+}
@@ -0,0 +1,229 @@
+package logserver
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// GELF ingest, over UDP for Docker's gelf log driver
+// (--log-opt gelf-address=udp://logger:12201) and over HTTP at
+// POST /api/ingest/gelf for other GELF senders. Like UDP and syslog
+// ingest, GELF datagrams carry no ingest token and are dropped, and
+// counted, when they cannot be stored; the HTTP endpoint takes tokens and
+// quotas like POST /api/logs.
+
+var (
+	// gelfUDPAddr is where Server listens for GELF datagrams when
+	// Config.GELFAddr is not set; empty turns GELF over UDP off
+	gelfUDPAddr = os.Getenv("GELF_UDP_ADDR")
+	// gelfQueueSize is how many messages may wait for the worker
+	gelfQueueSize = envInt("GELF_QUEUE", 4096)
+)
+
+// dropIncomplete counts chunked messages whose chunks did not all arrive
+const dropIncomplete = "incomplete"
+
+var gelfDropReasons = []string{dropOversize, dropInvalid, dropIncomplete, dropQueueFull, dropStoreFailed}
+
+// gelfStats counts GELF datagrams for /metrics
+type gelfStats struct {
+	received atomic.Int64
+	stored   atomic.Int64
+	dropped  dropCounter
+}
+
+// gelfPacket is a whole, possibly compressed, message and its sender
+type gelfPacket struct {
+	data   []byte
+	remote string
+}
+
+// gelfListener reads GELF datagrams from conn and stores them in db
+type gelfListener struct {
+	conn     net.PacketConn
+	db       *Database
+	messages chan gelfPacket
+	done     chan struct{}
+}
+
+// listenGELF starts accepting GELF datagrams on addr
+func listenGELF(addr string, db *Database) (*gelfListener, error) {
+	if ingestTokensRequired {
+		return nil, errors.New("GELF datagrams cannot carry ingest tokens; unset GELF_UDP_ADDR or INGEST_TOKENS_REQUIRED")
+	}
+	conn, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	if udp, ok := conn.(*net.UDPConn); ok {
+		udp.SetReadBuffer(udpReadBuffer)
+	}
+	l := &gelfListener{
+		conn:     conn,
+		db:       db,
+		messages: make(chan gelfPacket, gelfQueueSize),
+		done:     make(chan struct{}),
+	}
+	go l.read()
+	go l.store()
+	return l, nil
+}
+
+// read reassembles chunks and queues whole messages until the connection
+// is closed
+func (l *gelfListener) read() {
+	defer close(l.messages)
+	chunks := gelfChunks{expired: func() { l.db.gelfStats.dropped.add(dropIncomplete) }}
+	buf := make([]byte, 65536)
+	for {
+		n, addr, err := l.conn.ReadFrom(buf)
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				log.Printf("gelf: read failed: %v", err)
+			}
+			return
+		}
+		l.db.gelfStats.received.Add(1)
+		data := buf[:n]
+		if isGELFChunk(data) {
+			message, err := chunks.add(data, l.db.now())
+			switch {
+			case errors.Is(err, errGELFIncomplete):
+				l.db.gelfStats.dropped.add(dropQueueFull)
+				continue
+			case err != nil:
+				l.db.gelfStats.dropped.add(dropInvalid)
+				continue
+			case message == nil:
+				continue
+			}
+			data = message
+		} else {
+			chunks.sweep(l.db.now())
+			data = bytes.Clone(data)
+		}
+		select {
+		case l.messages <- gelfPacket{data: data, remote: hostOf(addr)}:
+		default:
+			l.db.gelfStats.dropped.add(dropQueueFull)
+		}
+	}
+}
+
+// store decompresses, parses and stores queued messages until read stops
+// and the queue is drained
+func (l *gelfListener) store() {
+	defer close(l.done)
+	for packet := range l.messages {
+		message, err := decompressGELF(packet.data)
+		if err != nil {
+			if errors.Is(err, errGELFTooLarge) {
+				l.db.gelfStats.dropped.add(dropOversize)
+			} else {
+				l.db.gelfStats.dropped.add(dropInvalid)
+			}
+			continue
+		}
+		entry, err := gelfEntry(message, packet.remote, l.db.now())
+		if err != nil {
+			l.db.gelfStats.dropped.add(dropInvalid)
+			continue
+		}
+		if err := l.db.InsertLog(entry); err != nil {
+			l.db.gelfStats.dropped.add(dropStoreFailed)
+			log.Printf("gelf: failed to store log: %v", err)
+			continue
+		}
+		l.db.gelfStats.stored.Add(1)
+	}
+}
+
+// Close stops reading and waits for the queued messages to be stored
+func (l *gelfListener) Close() error {
+	err := l.conn.Close()
+	<-l.done
+	return err
+}
+
+// gelfEntry parses a decompressed message into a log ready to store
+func gelfEntry(message []byte, remote string, now time.Time) (LogEntry, error) {
+	entry, err := parseGELF(message, remote)
+	if err != nil {
+		return entry, err
+	}
+	if storeRawPayloads {
+		entry.Raw, entry.RawFormat = message, rawGELF
+	}
+	return entry, prepareLogEntry(&entry, now)
+}
+
+// POST /api/ingest/gelf - store one GELF message, plain or gzip or zlib
+// compressed, with the same tokens and quotas as POST /api/logs
+func gelfIngestHandlerDB(w http.ResponseWriter, r *http.Request, db *Database) {
+	enableCORS(w)
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		w.Write([]byte("Method not allowed"))
+		return
+	}
+	body, err := io.ReadAll(io.LimitReader(r.Body, int64(gelfMaxBytes)+1))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Invalid body"))
+		return
+	}
+	if len(body) > gelfMaxBytes {
+		w.WriteHeader(http.StatusRequestEntityTooLarge)
+		w.Write([]byte(errGELFTooLarge.Error()))
+		return
+	}
+	now := db.now()
+	token, err := db.authenticateIngest(r, body, now)
+	if err != nil {
+		status := http.StatusUnauthorized
+		if errors.Is(err, errReplay) {
+			status = http.StatusConflict
+		}
+		w.WriteHeader(status)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	message, err := decompressGELF(body)
+	if err != nil {
+		status := http.StatusBadRequest
+		if errors.Is(err, errGELFTooLarge) {
+			status = http.StatusRequestEntityTooLarge
+		}
+		w.WriteHeader(status)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	remote, _, _ := net.SplitHostPort(r.RemoteAddr)
+	entry, err := gelfEntry(message, remote, now)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	if err := db.admitIngest(token, 1, now); err != nil {
+		w.Header().Set("Retry-After", strconv.Itoa(int(untilNextQuotaDay(now).Seconds())+1))
+		w.WriteHeader(http.StatusTooManyRequests)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	if err := db.InsertLog(entry); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("Failed to insert log"))
+		return
+	}
+	// Graylog answers GELF over HTTP with 202
+	w.WriteHeader(http.StatusAccepted)
+}
@@ -0,0 +1,235 @@
+package logserver
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"testing"
+	"time"
+)
+
+// FuzzGELF feeds GELF datagrams, chunks or whole messages, plain or
+// compressed, through reassembly, decompression and parsing
+func FuzzGELF(f *testing.F) {
+	addCorpus(f, "gelf", func(data []byte) { f.Add(data) })
+	f.Fuzz(func(t *testing.T, input []byte) {
+		message := input
+		if isGELFChunk(input) {
+			var chunks gelfChunks
+			var err error
+			if message, err = chunks.add(input, time.Unix(0, 0)); message == nil || err != nil {
+				return
+			}
+		}
+		message, err := decompressGELF(message)
+		if err != nil {
+			return
+		}
+		parseGELF(message, "10.0.0.1")
+	})
+}
+
+// gelfChunk builds chunk seq of count of message id
+func gelfChunk(id byte, seq, count int, data []byte) []byte {
+	chunk := []byte{0x1e, 0x0f, id, 0, 0, 0, 0, 0, 0, 0, byte(seq), byte(count)}
+	return append(chunk, data...)
+}
+
+func TestGELFChunksReassemble(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	var c gelfChunks
+	// Chunks may arrive in any order, and repeated chunks are ignored
+	for _, chunk := range [][]byte{
+		gelfChunk(1, 2, 3, []byte(`"}`)),
+		gelfChunk(1, 0, 3, []byte(`{"short_message":`)),
+		gelfChunk(1, 2, 3, []byte(`ignored`)),
+	} {
+		if message, err := c.add(chunk, now); message != nil || err != nil {
+			t.Fatalf("message before every chunk arrived: %q, %v", message, err)
+		}
+	}
+	// Another message's chunks in between are kept apart
+	if message, err := c.add(gelfChunk(2, 0, 2, []byte("other ")), now); message != nil || err != nil {
+		t.Fatalf("message before every chunk arrived: %q, %v", message, err)
+	}
+	message, err := c.add(gelfChunk(1, 1, 3, []byte(`"disk full`)), now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect(t, "reassembled message", string(message), `{"short_message":"disk full"}`)
+	expect(t, "pending messages", len(c.pending), 1)
+	expect(t, "pending bytes", c.size, len("other "))
+
+	message, err = c.add(gelfChunk(2, 1, 2, []byte("message")), now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect(t, "second message", string(message), "other message")
+	expect(t, "pending bytes once complete", c.size, 0)
+
+	// A single chunk is a whole message
+	message, err = c.add(gelfChunk(3, 0, 1, []byte("alone")), now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect(t, "one-chunk message", string(message), "alone")
+}
+
+func TestGELFChunksInvalid(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	for _, tc := range []struct {
+		name  string
+		chunk []byte
+	}{
+		{"truncated header", gelfChunk(1, 0, 2, nil)[:11]},
+		{"no chunks", gelfChunk(1, 0, 0, []byte("x"))},
+		{"too many chunks", gelfChunk(1, 0, gelfMaxChunks+1, []byte("x"))},
+		{"sequence past the count", gelfChunk(1, 2, 2, []byte("x"))},
+	} {
+		var c gelfChunks
+		if _, err := c.add(tc.chunk, now); err == nil {
+			t.Errorf("%s: accepted", tc.name)
+		}
+		expect(t, tc.name+": pending messages", len(c.pending), 0)
+	}
+
+	// Chunks that disagree on the count drop the message
+	var c gelfChunks
+	if _, err := c.add(gelfChunk(1, 0, 3, []byte("abc")), now); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.add(gelfChunk(1, 1, 2, []byte("de")), now); err == nil {
+		t.Error("chunk with another count accepted")
+	}
+	expect(t, "pending messages after a count mismatch", len(c.pending), 0)
+	expect(t, "pending bytes after a count mismatch", c.size, 0)
+}
+
+func TestGELFChunksTimeout(t *testing.T) {
+	start := time.Unix(1700000000, 0)
+	expired := 0
+	c := gelfChunks{expired: func() { expired++ }}
+	if _, err := c.add(gelfChunk(1, 0, 2, []byte("late")), start); err != nil {
+		t.Fatal(err)
+	}
+	// Within the timeout the message is kept
+	if _, err := c.add(gelfChunk(2, 0, 2, []byte("x")), start.Add(gelfChunkTimeout)); err != nil {
+		t.Fatal(err)
+	}
+	expect(t, "expired within the timeout", expired, 0)
+
+	// Past it, the next chunk sweeps it away, and its last chunk starts over
+	// rather than completing it
+	message, err := c.add(gelfChunk(1, 1, 2, []byte("chunk")), start.Add(gelfChunkTimeout+time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if message != nil {
+		t.Fatalf("expired message completed: %q", message)
+	}
+	expect(t, "expired", expired, 1)
+	expect(t, "pending messages", len(c.pending), 2)
+	expect(t, "pending bytes", c.size, len("x")+len("chunk"))
+
+	// Sweeps happen at most once a second, so a message may outlive the
+	// timeout by up to a second
+	expired = 0
+	c = gelfChunks{expired: func() { expired++ }}
+	c.add(gelfChunk(1, 0, 2, []byte("a")), start)
+	c.add(gelfChunk(2, 0, 2, []byte("b")), start.Add(gelfChunkTimeout-200*time.Millisecond))
+	c.add(gelfChunk(3, 0, 2, []byte("c")), start.Add(gelfChunkTimeout+300*time.Millisecond))
+	expect(t, "expired before the next sweep", expired, 0)
+	c.add(gelfChunk(3, 1, 2, []byte("c")), start.Add(gelfChunkTimeout+800*time.Millisecond))
+	expect(t, "expired after the next sweep", expired, 1)
+}
+
+func TestGELFChunksPendingCap(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	var c gelfChunks
+	data := make([]byte, 64<<10)
+	n := 0
+	for ; c.size+len(data) <= gelfPendingBytes; n++ {
+		chunk := gelfChunk(0, 0, 2, data)
+		chunk[2], chunk[3] = byte(n), byte(n>>8)
+		if _, err := c.add(chunk, now); err != nil {
+			t.Fatalf("chunk %d: %v", n, err)
+		}
+	}
+	expect(t, "pending bytes at the cap", c.size, n*len(data))
+	chunk := gelfChunk(0, 0, 2, data)
+	chunk[2], chunk[3] = 0xff, 0xff
+	if _, err := c.add(chunk, now); !errors.Is(err, errGELFIncomplete) {
+		t.Fatalf("chunk past the cap: %v", err)
+	}
+	expect(t, "pending bytes past the cap", c.size, n*len(data))
+}
+
+func TestDecompressGELF(t *testing.T) {
+	message := []byte(`{"version":"1.1","host":"web","short_message":"disk full"}`)
+	var gz, zl bytes.Buffer
+	gw := gzip.NewWriter(&gz)
+	gw.Write(message)
+	gw.Close()
+	zw := zlib.NewWriter(&zl)
+	zw.Write(message)
+	zw.Close()
+	for name, data := range map[string][]byte{"plain": message, "gzip": gz.Bytes(), "zlib": zl.Bytes()} {
+		got, err := decompressGELF(data)
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		expect(t, name, string(got), string(message))
+	}
+
+	// The cap applies to the decompressed size, so small bombs are refused
+	bomb := bytes.Repeat([]byte(" "), gelfMaxBytes+1)
+	var gzBomb, zlBomb bytes.Buffer
+	gw = gzip.NewWriter(&gzBomb)
+	gw.Write(bomb)
+	gw.Close()
+	zw = zlib.NewWriter(&zlBomb)
+	zw.Write(bomb)
+	zw.Close()
+	for name, data := range map[string][]byte{"plain": bomb, "gzip": gzBomb.Bytes(), "zlib": zlBomb.Bytes()} {
+		if _, err := decompressGELF(data); !errors.Is(err, errGELFTooLarge) {
+			t.Errorf("%s past the cap: %v", name, err)
+		}
+	}
+
+	for name, data := range map[string][]byte{
+		"corrupt gzip":   {0x1f, 0x8b, 0x08, 0x00, 0x01},
+		"truncated gzip": gz.Bytes()[:gz.Len()-4],
+		"corrupt zlib":   {0x78, 0x9c, 0xff, 0xff},
+	} {
+		if _, err := decompressGELF(data); err == nil {
+			t.Errorf("%s decompressed", name)
+		}
+	}
+}
+
+func TestParseGELF(t *testing.T) {
+	entry, err := parseGELF([]byte(`{"version":"1.1","host":"10.0.0.5","short_message":"disk full","full_message":"/var is full",
+		"timestamp":1700000000.25,"level":3,"_container_name":"/web"}`), "192.0.2.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect(t, "log", entry, LogEntry{
+		Timestamp: time.Unix(1700000000, 250e6).UTC(), Level: "ERROR", Rule: "gelf:web", SourceIP: "10.0.0.5",
+		Event: "disk full", Description: "/var is full", Tags: []string{"gelf"},
+	})
+
+	// A missing level is alert, and a named host leaves the sender as the source
+	entry, err = parseGELF([]byte(`{"host":"web","short_message":"x"}`), "192.0.2.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect(t, "level and source", []string{entry.Level, entry.Rule, entry.SourceIP}, []string{"CRITICAL", "gelf", "192.0.2.1"})
+
+	for _, message := range []string{``, `[]`, `{"short_message":""}`, `{"short_message":"x","level":8}`, `{"short_message":"x","level":-1}`} {
+		if _, err := parseGELF([]byte(message), "192.0.2.1"); err == nil {
+			t.Errorf("%q parsed", message)
+		}
+	}
+}
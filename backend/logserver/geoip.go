@@ -0,0 +1,385 @@
+package logserver
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"net/netip"
+	"os"
+	"strings"
+)
+
+// GeoIP. With GEOIP_DB set to a MaxMind DB file, such as GeoLite2-City.mmdb
+// or GeoLite2-ASN.mmdb, IP addresses are located from that file alone, so
+// lookups work in air-gapped mode. The file is read once at startup and
+// searched in memory; the reader follows the MaxMind DB 2.0 format and
+// needs no library. The server never downloads or updates the file.
+
+// GeoLocation is what the GeoIP database knows about an address. City
+// databases fill the place, ASN databases the network.
+type GeoLocation struct {
+	// Country is the ISO 3166-1 code
+	Country      string  `json:"country,omitempty"`
+	CountryName  string  `json:"countryName,omitempty"`
+	City         string  `json:"city,omitempty"`
+	Latitude     float64 `json:"latitude,omitempty"`
+	Longitude    float64 `json:"longitude,omitempty"`
+	ASN          uint64  `json:"asn,omitempty"`
+	Organization string  `json:"organization,omitempty"`
+}
+
+var (
+	errGeoIPDisabled = errors.New("GeoIP is not configured; set GEOIP_DB")
+	errGeoIPFormat   = errors.New("not a MaxMind DB file")
+	errGeoIPData     = errors.New("corrupt MaxMind DB data")
+)
+
+// geoIPMetadataMarker precedes the metadata at the end of the file
+var geoIPMetadataMarker = []byte("\xab\xcd\xefMaxMind.com")
+
+const (
+	// geoIPMaxDepth bounds the nesting of decoded values, so pointers that
+	// loop in a corrupt file cannot recurse without end
+	geoIPMaxDepth = 32
+	// geoIPDataSeparator is the gap of zero bytes between the search tree
+	// and the data section
+	geoIPDataSeparator = 16
+)
+
+// geoIPDatabase is a MaxMind DB file held in memory. The search tree is a
+// binary trie over the address bits; each node has a left and a right
+// record, which hold the next node, nodeCount for "not found", or a
+// pointer past nodeCount into the data section.
+type geoIPDatabase struct {
+	tree       []byte
+	data       []byte
+	nodeCount  uint
+	recordSize uint
+	ipVersion  uint
+	// ipv4Start is the node IPv4 lookups start at: in an IPv6 tree, the
+	// node reached by 96 zero bits
+	ipv4Start uint
+}
+
+// openGeoIP loads the database GEOIP_DB names, or returns nil when it is
+// not set
+func openGeoIP() (*geoIPDatabase, error) {
+	path := os.Getenv("GEOIP_DB")
+	if path == "" {
+		return nil, nil
+	}
+	file, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("GEOIP_DB: %w", err)
+	}
+	g, err := parseGeoIP(file)
+	if err != nil {
+		return nil, fmt.Errorf("GEOIP_DB %s: %w", path, err)
+	}
+	return g, nil
+}
+
+// parseGeoIP reads the metadata of a MaxMind DB file and splits it into the
+// search tree and data section
+func parseGeoIP(file []byte) (*geoIPDatabase, error) {
+	end := bytes.LastIndex(file, geoIPMetadataMarker)
+	if end < 0 {
+		return nil, errGeoIPFormat
+	}
+	value, _, err := geoIPDecoder(file[end+len(geoIPMetadataMarker):]).decode(0, 0)
+	if err != nil {
+		return nil, err
+	}
+	metadata, ok := value.(map[string]any)
+	if !ok {
+		return nil, errGeoIPFormat
+	}
+	field := func(name string) uint {
+		n, _ := metadata[name].(uint64)
+		return uint(n)
+	}
+	if major := field("binary_format_major_version"); major != 2 {
+		return nil, fmt.Errorf("unsupported MaxMind DB format version %d", major)
+	}
+	g := &geoIPDatabase{nodeCount: field("node_count"), recordSize: field("record_size"), ipVersion: field("ip_version")}
+	if g.recordSize != 24 && g.recordSize != 28 && g.recordSize != 32 {
+		return nil, fmt.Errorf("unsupported record size %d", g.recordSize)
+	}
+	if g.ipVersion != 4 && g.ipVersion != 6 {
+		return nil, fmt.Errorf("unsupported IP version %d", g.ipVersion)
+	}
+	treeSize := uint64(g.nodeCount) * uint64(g.recordSize) / 4
+	if treeSize+geoIPDataSeparator > uint64(end) {
+		return nil, errGeoIPData
+	}
+	g.tree, g.data = file[:treeSize], file[treeSize+geoIPDataSeparator:end]
+	if g.ipVersion == 6 {
+		for i := 0; i < 96 && g.ipv4Start < g.nodeCount; i++ {
+			g.ipv4Start = g.record(g.ipv4Start, 0)
+		}
+	}
+	return g, nil
+}
+
+// record returns the left (bit 0) or right (bit 1) record of node
+func (g *geoIPDatabase) record(node, bit uint) uint {
+	b := g.tree[node*g.recordSize/4:]
+	switch g.recordSize {
+	case 24:
+		b = b[bit*3:]
+		return uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2])
+	case 28:
+		if bit == 0 {
+			return uint(b[3]&0xf0)<<20 | uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2])
+		}
+		return uint(b[3]&0x0f)<<24 | uint(b[4])<<16 | uint(b[5])<<8 | uint(b[6])
+	default:
+		return uint(binary.BigEndian.Uint32(b[bit*4:]))
+	}
+}
+
+// lookup locates addr, reporting whether the database has a record for it
+func (g *geoIPDatabase) lookup(addr netip.Addr) (GeoLocation, bool) {
+	addr = addr.Unmap()
+	var bits []byte
+	node := uint(0)
+	switch {
+	case addr.Is4():
+		b := addr.As4()
+		bits = b[:]
+		node = g.ipv4Start
+	case g.ipVersion == 6:
+		b := addr.As16()
+		bits = b[:]
+	default:
+		return GeoLocation{}, false
+	}
+	for i := 0; i < len(bits)*8 && node < g.nodeCount; i++ {
+		node = g.record(node, uint(bits[i/8]>>(7-i%8)&1))
+	}
+	if node <= g.nodeCount {
+		return GeoLocation{}, false
+	}
+	value, _, err := geoIPDecoder(g.data).decode(node-g.nodeCount-geoIPDataSeparator, 0)
+	if err != nil {
+		return GeoLocation{}, false
+	}
+	return geoLocation(value), true
+}
+
+// geoLocation picks the fields of GeoLocation out of a GeoIP2 or GeoLite2
+// record
+func geoLocation(record any) GeoLocation {
+	var g GeoLocation
+	g.Country, _ = geoIPField(record, "country", "iso_code").(string)
+	g.CountryName, _ = geoIPField(record, "country", "names", "en").(string)
+	g.City, _ = geoIPField(record, "city", "names", "en").(string)
+	g.Latitude, _ = geoIPField(record, "location", "latitude").(float64)
+	g.Longitude, _ = geoIPField(record, "location", "longitude").(float64)
+	g.ASN, _ = geoIPField(record, "autonomous_system_number").(uint64)
+	g.Organization, _ = geoIPField(record, "autonomous_system_organization").(string)
+	return g
+}
+
+// geoIPField follows path through nested maps
+func geoIPField(value any, path ...string) any {
+	for _, key := range path {
+		m, ok := value.(map[string]any)
+		if !ok {
+			return nil
+		}
+		value = m[key]
+	}
+	return value
+}
+
+// geoIPDecoder decodes values of the MaxMind DB data format; pointers are
+// offsets into the decoder's bytes
+type geoIPDecoder []byte
+
+// MaxMind DB data types
+const (
+	geoIPExtended = iota
+	geoIPPointer
+	geoIPString
+	geoIPDouble
+	geoIPBytes
+	geoIPUint16
+	geoIPUint32
+	geoIPMap
+	geoIPInt32
+	geoIPUint64
+	geoIPUint128
+	geoIPArray
+	geoIPContainer
+	geoIPEndMarker
+	geoIPBool
+	geoIPFloat
+)
+
+// decode returns the value at offset and the offset after it. Maps are
+// map[string]any, arrays []any, unsigned integers uint64, int32 int,
+// doubles and floats float64, and uint128 and bytes []byte.
+func (d geoIPDecoder) decode(offset uint, depth int) (any, uint, error) {
+	if depth > geoIPMaxDepth || offset >= uint(len(d)) {
+		return nil, 0, errGeoIPData
+	}
+	control := d[offset]
+	offset++
+	kind := uint(control >> 5)
+	if kind == geoIPPointer {
+		n := uint(control>>3)&3 + 1
+		if offset+n > uint(len(d)) {
+			return nil, 0, errGeoIPData
+		}
+		b := d[offset : offset+n]
+		var target uint
+		switch n {
+		case 1:
+			target = uint(control&7)<<8 | uint(b[0])
+		case 2:
+			target = uint(control&7)<<16 | uint(b[0])<<8 | uint(b[1]) + 2048
+		case 3:
+			target = uint(control&7)<<24 | uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2]) + 526336
+		default:
+			target = uint(binary.BigEndian.Uint32(b))
+		}
+		value, _, err := d.decode(target, depth+1)
+		return value, offset + n, err
+	}
+	if kind == geoIPExtended {
+		if offset >= uint(len(d)) {
+			return nil, 0, errGeoIPData
+		}
+		kind = 7 + uint(d[offset])
+		offset++
+	}
+	size := uint(control & 0x1f)
+	if size >= 29 {
+		n := size - 28
+		if offset+n > uint(len(d)) {
+			return nil, 0, errGeoIPData
+		}
+		var extra uint
+		for _, c := range d[offset : offset+n] {
+			extra = extra<<8 | uint(c)
+		}
+		size = []uint{29, 285, 65821}[n-1] + extra
+		offset += n
+	}
+
+	switch kind {
+	case geoIPMap:
+		m := make(map[string]any, min(size, 64))
+		for i := uint(0); i < size; i++ {
+			key, next, err := d.decode(offset, depth+1)
+			if err != nil {
+				return nil, 0, err
+			}
+			name, ok := key.(string)
+			if !ok {
+				return nil, 0, errGeoIPData
+			}
+			if m[name], offset, err = d.decode(next, depth+1); err != nil {
+				return nil, 0, err
+			}
+		}
+		return m, offset, nil
+	case geoIPArray:
+		a := make([]any, 0, min(size, 64))
+		for i := uint(0); i < size; i++ {
+			value, next, err := d.decode(offset, depth+1)
+			if err != nil {
+				return nil, 0, err
+			}
+			a, offset = append(a, value), next
+		}
+		return a, offset, nil
+	case geoIPBool:
+		return size != 0, offset, nil
+	}
+
+	if offset+size > uint(len(d)) {
+		return nil, 0, errGeoIPData
+	}
+	b := d[offset : offset+size]
+	offset += size
+	switch kind {
+	case geoIPString:
+		return string(b), offset, nil
+	case geoIPBytes, geoIPUint128:
+		return bytes.Clone(b), offset, nil
+	case geoIPDouble:
+		if size != 8 {
+			return nil, 0, errGeoIPData
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(b)), offset, nil
+	case geoIPFloat:
+		if size != 4 {
+			return nil, 0, errGeoIPData
+		}
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(b))), offset, nil
+	case geoIPUint16, geoIPUint32, geoIPUint64, geoIPInt32:
+		if size > 8 || (kind == geoIPInt32 && size > 4) {
+			return nil, 0, errGeoIPData
+		}
+		var n uint64
+		for _, c := range b {
+			n = n<<8 | uint64(c)
+		}
+		if kind == geoIPInt32 {
+			return int(int32(uint32(n))), offset, nil
+		}
+		return n, offset, nil
+	}
+	return nil, 0, errGeoIPData
+}
+
+// locate returns where ip is, or nil when there is no GeoIP database or it
+// has nothing on ip
+func (d *Database) locate(ip string) *GeoLocation {
+	if d.geoIP == nil {
+		return nil
+	}
+	addr, err := netip.ParseAddr(strings.TrimSpace(ip))
+	if err != nil {
+		return nil
+	}
+	location, ok := d.geoIP.lookup(addr)
+	if !ok {
+		return nil
+	}
+	return &location
+}
+
+// GET /api/geoip?ip= - locate an address in the GEOIP_DB database
+func geoIPHandlerDB(w http.ResponseWriter, r *http.Request, db *Database) {
+	enableCORS(w)
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method == http.MethodOptions {
+		return
+	}
+	if r.Method != http.MethodGet {
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	if db.geoIP == nil {
+		writeJSONError(w, http.StatusNotImplemented, errGeoIPDisabled.Error())
+		return
+	}
+	ip := r.URL.Query().Get("ip")
+	if _, err := netip.ParseAddr(ip); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "ip must be an IP address")
+		return
+	}
+	location := db.locate(ip)
+	if location == nil {
+		writeJSONError(w, http.StatusNotFound, "Address not in the GeoIP database")
+		return
+	}
+	json.NewEncoder(w).Encode(location)
+}
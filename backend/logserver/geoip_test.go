@@ -0,0 +1,263 @@
+package logserver
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"net/http"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+)
+
+// mmdbValue encodes v in the MaxMind DB data format
+func mmdbValue(v any) []byte {
+	head := func(kind int, size int) []byte {
+		var b []byte
+		control := byte(0)
+		if kind > 7 {
+			control, b = 0, []byte{0, byte(kind - 7)}
+		} else {
+			control, b = byte(kind)<<5, []byte{0}
+		}
+		switch {
+		case size < 29:
+			control |= byte(size)
+		case size < 285:
+			control |= 29
+			b = append(b, byte(size-29))
+		default:
+			control |= 30
+			b = append(b, byte((size-285)>>8), byte(size-285))
+		}
+		b[0] |= control
+		return b
+	}
+	switch v := v.(type) {
+	case string:
+		return append(head(geoIPString, len(v)), v...)
+	case float64:
+		return binary.BigEndian.AppendUint64(head(geoIPDouble, 8), math.Float64bits(v))
+	case uint32:
+		return binary.BigEndian.AppendUint32(head(geoIPUint32, 4), v)
+	case uint16:
+		return binary.BigEndian.AppendUint16(head(geoIPUint16, 2), v)
+	case map[string]any:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		b := head(geoIPMap, len(v))
+		for _, k := range keys {
+			b = append(b, mmdbValue(k)...)
+			b = append(b, mmdbValue(v[k])...)
+		}
+		return b
+	}
+	panic("mmdbValue: unsupported type")
+}
+
+// buildMMDB writes a MaxMind DB file with each network in records mapped to
+// its record
+func buildMMDB(t *testing.T, ipVersion, recordSize int, records map[string]map[string]any) []byte {
+	t.Helper()
+	type node struct{ children [2]int }
+	// children are -1 for empty, a node index, or -(2+data index)
+	nodes := []node{{[2]int{-1, -1}}}
+	var data []byte
+	var offsets []int
+	for prefix, record := range records {
+		p := netip.MustParsePrefix(prefix)
+		addr, bitCount := p.Addr(), p.Bits()
+		if ipVersion == 6 && addr.Is4() {
+			addr, bitCount = netip.AddrFrom16(addr.As16()), bitCount+96
+			// As16 of an IPv4 address is the mapped form; the tree wants
+			// ::a.b.c.d
+			b := addr.As16()
+			b[10], b[11] = 0, 0
+			addr = netip.AddrFrom16(b)
+		}
+		bits := addr.AsSlice()
+		offsets = append(offsets, len(data))
+		data = append(data, mmdbValue(record)...)
+		n := 0
+		for i := 0; i < bitCount; i++ {
+			bit := bits[i/8] >> (7 - i%8) & 1
+			if i == bitCount-1 {
+				nodes[n].children[bit] = -(2 + len(offsets) - 1)
+				break
+			}
+			if nodes[n].children[bit] < 0 {
+				nodes = append(nodes, node{[2]int{-1, -1}})
+				nodes[n].children[bit] = len(nodes) - 1
+			}
+			n = nodes[n].children[bit]
+		}
+	}
+
+	nodeCount := len(nodes)
+	var tree []byte
+	value := func(child int) uint32 {
+		switch {
+		case child == -1:
+			return uint32(nodeCount)
+		case child < -1:
+			return uint32(nodeCount + geoIPDataSeparator + offsets[-child-2])
+		}
+		return uint32(child)
+	}
+	for _, n := range nodes {
+		left, right := value(n.children[0]), value(n.children[1])
+		switch recordSize {
+		case 24:
+			tree = append(tree, byte(left>>16), byte(left>>8), byte(left), byte(right>>16), byte(right>>8), byte(right))
+		case 28:
+			tree = append(tree, byte(left>>16), byte(left>>8), byte(left), byte(left>>20&0xf0|right>>24&0x0f), byte(right>>16), byte(right>>8), byte(right))
+		case 32:
+			tree = binary.BigEndian.AppendUint32(tree, left)
+			tree = binary.BigEndian.AppendUint32(tree, right)
+		}
+	}
+
+	file := append(tree, make([]byte, geoIPDataSeparator)...)
+	file = append(file, data...)
+	file = append(file, geoIPMetadataMarker...)
+	return append(file, mmdbValue(map[string]any{
+		"binary_format_major_version": uint16(2),
+		"binary_format_minor_version": uint16(0),
+		"database_type":               "Test-City",
+		"ip_version":                  uint16(ipVersion),
+		"node_count":                  uint32(nodeCount),
+		"record_size":                 uint16(recordSize),
+	})...)
+}
+
+var geoIPRecords = map[string]map[string]any{
+	"81.2.69.0/24": {
+		"city":     map[string]any{"names": map[string]any{"en": "London"}},
+		"country":  map[string]any{"iso_code": "GB", "names": map[string]any{"en": "United Kingdom"}},
+		"location": map[string]any{"latitude": 51.5142, "longitude": -0.0931},
+	},
+	"1.128.0.0/11": {
+		"autonomous_system_number":       uint32(1221),
+		"autonomous_system_organization": "Telstra Pty Ltd",
+	},
+	"2001:db8::/32": {
+		"country": map[string]any{"iso_code": "NL"},
+	},
+}
+
+func TestGeoIPLookup(t *testing.T) {
+	london := GeoLocation{Country: "GB", CountryName: "United Kingdom", City: "London", Latitude: 51.5142, Longitude: -0.0931}
+	telstra := GeoLocation{ASN: 1221, Organization: "Telstra Pty Ltd"}
+	for _, ipVersion := range []int{4, 6} {
+		for _, recordSize := range []int{24, 28, 32} {
+			records := geoIPRecords
+			if ipVersion == 4 {
+				records = map[string]map[string]any{"81.2.69.0/24": geoIPRecords["81.2.69.0/24"], "1.128.0.0/11": geoIPRecords["1.128.0.0/11"]}
+			}
+			g, err := parseGeoIP(buildMMDB(t, ipVersion, recordSize, records))
+			if err != nil {
+				t.Fatalf("IPv%d, %d-bit records: %v", ipVersion, recordSize, err)
+			}
+			cases := map[string]*GeoLocation{
+				"81.2.69.142":          &london,
+				"::ffff:81.2.69.142":   &london,
+				"1.159.255.255":        &telstra,
+				"1.160.0.0":            nil,
+				"81.2.70.1":            nil,
+				"2001:db8::1":          nil,
+				"2001:db9::1":          nil,
+				"::1":                  nil,
+				"2001:db8:ffff::abcd":  nil,
+				"2001:0db8:0000::0001": nil,
+			}
+			if ipVersion == 6 {
+				nl := GeoLocation{Country: "NL"}
+				cases["2001:db8::1"], cases["2001:db8:ffff::abcd"], cases["2001:0db8:0000::0001"] = &nl, &nl, &nl
+			}
+			for ip, want := range cases {
+				got, ok := g.lookup(netip.MustParseAddr(ip))
+				what := fmt.Sprintf("%s in IPv%d with %d-bit records", ip, ipVersion, recordSize)
+				if want == nil {
+					expect(t, what+" found", ok, false)
+					continue
+				}
+				expect(t, what, got, *want)
+			}
+		}
+	}
+}
+
+func TestGeoIPCorrupt(t *testing.T) {
+	file := buildMMDB(t, 6, 28, geoIPRecords)
+	if _, err := parseGeoIP(file[:len(file)/2]); err == nil {
+		t.Error("parsed a file without metadata")
+	}
+	if _, err := parseGeoIP([]byte("not a database")); err == nil {
+		t.Error("parsed a file that is not a database")
+	}
+
+	// A node count past the end of the file is refused rather than read
+	metadata := append(append(make([]byte, 64), geoIPMetadataMarker...), mmdbValue(map[string]any{
+		"binary_format_major_version": uint16(2), "ip_version": uint16(6), "node_count": uint32(1 << 30), "record_size": uint16(28),
+	})...)
+	if _, err := parseGeoIP(metadata); err == nil {
+		t.Error("parsed a file with too many nodes")
+	}
+
+	// A pointer to itself is not followed forever
+	if _, _, err := (geoIPDecoder{0x20, 0x00}).decode(0, 0); err == nil {
+		t.Error("decoded a pointer loop")
+	}
+	if _, _, err := (geoIPDecoder{0x5f}).decode(0, 0); err == nil {
+		t.Error("decoded a string past the end of the data")
+	}
+}
+
+// With GEOIP_DB set, entity timelines and /api/geoip locate addresses from
+// the file alone; without it /api/geoip answers 501
+func TestE2EGeoIP(t *testing.T) {
+	h := newHarness(t)
+	status := h.anonymous(http.MethodGet, "/api/geoip?ip=81.2.69.142", nil)
+	expect(t, "status without GEOIP_DB", status, http.StatusNotImplemented)
+
+	path := filepath.Join(t.TempDir(), "test.mmdb")
+	if err := os.WriteFile(path, buildMMDB(t, 6, 24, geoIPRecords), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("GEOIP_DB", path)
+	g, err := openGeoIP()
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.db.geoIP = g
+
+	var location GeoLocation
+	h.do(http.MethodGet, "/api/geoip?ip=81.2.69.142", nil, &location)
+	expect(t, "located", location.City, "London")
+	status = h.anonymous(http.MethodGet, "/api/geoip?ip=10.0.0.1", nil)
+	expect(t, "status of an unknown address", status, http.StatusNotFound)
+	status = h.anonymous(http.MethodGet, "/api/geoip?ip=nope", nil)
+	expect(t, "status of a bad address", status, http.StatusBadRequest)
+
+	h.ingest(LogEntry{Level: "WARN", Event: "Failed login", SourceIP: "1.130.0.7"})
+	var timeline EntityTimeline
+	h.do(http.MethodGet, "/api/entities/ip/1.130.0.7/timeline", nil, &timeline)
+	if timeline.Geo == nil || timeline.Geo.ASN != 1221 {
+		t.Errorf("timeline geo: %+v", timeline.Geo)
+	}
+	var userTimeline EntityTimeline
+	h.do(http.MethodGet, "/api/entities/user/1.130.0.7/timeline", nil, &userTimeline)
+	if userTimeline.Geo != nil {
+		t.Errorf("user timeline geo: %+v", userTimeline.Geo)
+	}
+
+	t.Setenv("GEOIP_DB", filepath.Join(t.TempDir(), "missing.mmdb"))
+	if _, err := openGeoIP(); err == nil {
+		t.Error("opened a missing GEOIP_DB")
+	}
+}
@@ -0,0 +1,87 @@
+package logserver
+
+import (
+	"sort"
+	"time"
+)
+
+// GraphNode is a host seen as a source and/or destination
+type GraphNode struct {
+	ID       string `json:"id"`
+	Outbound int    `json:"outbound"`
+	Inbound  int    `json:"inbound"`
+}
+
+// GraphEdge is the number of events from Source to Target
+type GraphEdge struct {
+	Source string `json:"source"`
+	Target string `json:"target"`
+	Weight int    `json:"weight"`
+	Search string `json:"search,omitempty"`
+}
+
+// Graph is the source/destination communication graph for a time window
+type Graph struct {
+	From  time.Time   `json:"from"`
+	To    time.Time   `json:"to"`
+	Nodes []GraphNode `json:"nodes"`
+	Edges []GraphEdge `json:"edges"`
+}
+
+// GetGraph returns the heaviest maxEdges source->destination pairs and the nodes they touch
+func (d *Database) GetGraph(from, to time.Time, maxEdges int) (Graph, error) {
+	graph := Graph{From: from, To: to, Nodes: []GraphNode{}, Edges: []GraphEdge{}}
+	rows, err := d.db.Query(`
+		SELECT source_ip, destination_ip, COUNT(*) AS weight
+		FROM logs
+		WHERE timestamp >= ? AND timestamp < ?
+			AND source_ip != '' AND destination_ip != ''
+		GROUP BY source_ip, destination_ip
+		ORDER BY weight DESC
+		LIMIT ?
+	`, from.UTC(), to.UTC(), maxEdges)
+	if err != nil {
+		return graph, err
+	}
+	defer rows.Close()
+
+	nodes := make(map[string]*GraphNode)
+	node := func(id string) *GraphNode {
+		if n, ok := nodes[id]; ok {
+			return n
+		}
+		n := &GraphNode{ID: id}
+		nodes[id] = n
+		return n
+	}
+	for rows.Next() {
+		var edge GraphEdge
+		if err := rows.Scan(&edge.Source, &edge.Target, &edge.Weight); err != nil {
+			return graph, err
+		}
+		node(edge.Source).Outbound += edge.Weight
+		node(edge.Target).Inbound += edge.Weight
+		source, sourceOK := exactValue(edge.Source)
+		target, targetOK := exactValue(edge.Target)
+		if sourceOK && targetOK {
+			edge.Search = searchURL(LogFilter{Source: source, Destination: target, From: from, To: to})
+		}
+		graph.Edges = append(graph.Edges, edge)
+	}
+	if err := rows.Err(); err != nil {
+		return graph, err
+	}
+
+	for _, n := range nodes {
+		graph.Nodes = append(graph.Nodes, *n)
+	}
+	sort.Slice(graph.Nodes, func(i, j int) bool {
+		wi := graph.Nodes[i].Outbound + graph.Nodes[i].Inbound
+		wj := graph.Nodes[j].Outbound + graph.Nodes[j].Inbound
+		if wi != wj {
+			return wi > wj
+		}
+		return graph.Nodes[i].ID < graph.Nodes[j].ID
+	})
+	return graph, nil
+}
@@ -0,0 +1,927 @@
+package logserver
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"mime"
+	"net/http"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// NotableEvent represents a security notable event
+type NotableEvent struct {
+	ID          string       `json:"id"`
+	RuleName    string       `json:"ruleName"`
+	Urgency     string       `json:"urgency"`  // critical, high, medium, low
+	Category    string       `json:"category"` // access, network, threat, uba
+	SourceIP    string       `json:"sourceIP"`
+	Destination string       `json:"destination"`
+	User        string       `json:"user,omitempty"`
+	Count       int          `json:"count"`
+	Timestamp   time.Time    `json:"timestamp"`
+	Description string       `json:"description"`
+	Status      string       `json:"status,omitempty"`
+	Assignee    string       `json:"assignee,omitempty"`
+	UpdatedAt   time.Time    `json:"updatedAt"`
+	TicketKey   string       `json:"ticketKey,omitempty"`
+	TicketURL   string       `json:"ticketURL,omitempty"`
+	Tags        []string     `json:"tags,omitempty"`
+	RiskScore   float64      `json:"riskScore"`
+	RiskFactors *RiskFactors `json:"riskFactors,omitempty"`
+}
+
+// SummaryStats represents dashboard summary statistics
+type SummaryStats struct {
+	AccessNotables  StatTile `json:"accessNotables"`
+	NetworkNotables StatTile `json:"networkNotables"`
+	ThreatNotables  StatTile `json:"threatNotables"`
+	UBANotables     StatTile `json:"ubaNotables"`
+}
+
+// StatTile represents a dashboard statistic tile
+type StatTile struct {
+	Total int `json:"total"`
+	Delta int `json:"delta"`
+	// Search is the /api/logs URL listing the logs counted; see drilldown.go
+	Search string `json:"search"`
+}
+
+// UrgencyData represents bar chart data for urgency levels
+type UrgencyData struct {
+	// The counts of urgency 4 to 1, whatever those are named
+	Critical int `json:"critical"`
+	High     int `json:"high"`
+	Medium   int `json:"medium"`
+	Low      int `json:"low"`
+	// Urgencies counts every configured urgency, most urgent first
+	Urgencies []UrgencyCount `json:"urgencies"`
+}
+
+// UrgencyCount is a configured urgency and its count
+type UrgencyCount struct {
+	UrgencyLevel
+	Count  int    `json:"count"`
+	Search string `json:"search"`
+}
+
+// TimelineData represents line chart time series data. Labels are for
+// display in TZ; Times are the same points as RFC 3339 UTC timestamps.
+type TimelineData struct {
+	Labels []string         `json:"labels"`
+	Times  []time.Time      `json:"times"`
+	TZ     string           `json:"tz"`
+	Series []TimelineSeries `json:"series"`
+	// Annotations are the deploys, maintenance and incidents within the
+	// chart, to overlay as markers
+	Annotations []TimelineAnnotation `json:"annotations"`
+}
+
+// TimelineSeries represents a data series for timeline chart
+type TimelineSeries struct {
+	Name  string `json:"name"`
+	Data  []int  `json:"data"`
+	Color string `json:"color"`
+	// Searches holds the search of each point of Data
+	Searches []string `json:"searches"`
+}
+
+// TopEvent represents a top notable event for table display
+type TopEvent struct {
+	RuleName  string `json:"ruleName"`
+	Sparkline []int  `json:"sparkline"`
+	Count     int    `json:"count"`
+	Urgency   string `json:"urgency"`
+	Search    string `json:"search,omitempty"`
+}
+
+// TopSource represents a top event source for table display
+type TopSource struct {
+	SourceIP  string `json:"sourceIP"`
+	Sparkline []int  `json:"sparkline"`
+	Count     int    `json:"count"`
+	Category  string `json:"category"`
+	Search    string `json:"search,omitempty"`
+}
+
+// TopDestination represents a top destination entry
+type TopDestination struct {
+	DestinationIP string `json:"destinationIP"`
+	Count         int    `json:"count"`
+	Search        string `json:"search,omitempty"`
+}
+
+// LogEntry represents a single log entry
+type LogEntry struct {
+	ID            int64     `json:"id,omitempty"`
+	Timestamp     time.Time `json:"timestamp"`
+	Level         string    `json:"level"`
+	Rule          string    `json:"rule"`
+	SourceIP      string    `json:"sourceIP"`
+	DestinationIP string    `json:"destinationIP"`
+	Event         string    `json:"event"`
+	Description   string    `json:"description"`
+	Urgency       int       `json:"urgency"`
+	User          string    `json:"user,omitempty"`
+	// TraceID links the log to a distributed trace
+	TraceID string `json:"traceId,omitempty"`
+	// Ports are optional; zero means unknown
+	SourcePort      int `json:"sourcePort,omitempty"`
+	DestinationPort int `json:"destinationPort,omitempty"`
+	// Category overrides the category derived from Rule; set by bulk updates
+	Category string `json:"category,omitempty"`
+	// Tags can be set at ingest; tag rules add more
+	Tags []string `json:"tags,omitempty"`
+	// Annotations is filled in by search so that every viewer sees them
+	Annotations []Annotation `json:"annotations,omitempty"`
+	// Archive names the archived segment a search result was read from
+	Archive string `json:"archive,omitempty"`
+	// Raw is the payload the log was parsed from, in RawFormat; it is stored
+	// in log_raw and not returned by search
+	Raw       []byte `json:"-"`
+	RawFormat string `json:"-"`
+}
+
+var startTime = time.Now()
+
+func enableCORS(w http.ResponseWriter) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-Admin-Token, If-None-Match, If-Modified-Since, X-Ingest-Ack, Content-Encoding")
+	w.Header().Set("Access-Control-Expose-Headers", "ETag, Last-Modified, X-Ingest-Ack")
+}
+
+func handleOptions(w http.ResponseWriter, r *http.Request) {
+	enableCORS(w)
+	w.WriteHeader(http.StatusOK)
+}
+
+func metricsHandlerDB(w http.ResponseWriter, r *http.Request, db *Database) {
+	enableCORS(w)
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	total := db.rowCount.Load()
+	levelCounts := db.counters.snapshot(db.counters.levels)
+	ruleCounts := db.counters.snapshot(db.counters.rules)
+	uptime := int(time.Since(startTime).Seconds())
+	w.Write([]byte("# HELP logger_logs_total Total number of logs ingested\n"))
+	w.Write([]byte("# TYPE logger_logs_total counter\n"))
+	w.Write([]byte("logger_logs_total " + strconv.FormatInt(total, 10) + "\n"))
+	w.Write([]byte("# HELP logger_logs_by_level Number of logs by level\n"))
+	w.Write([]byte("# TYPE logger_logs_by_level counter\n"))
+	for level, count := range levelCounts {
+		w.Write([]byte("logger_logs_by_level{level=\"" + level + "\"} " + strconv.FormatInt(count, 10) + "\n"))
+	}
+	w.Write([]byte("# HELP logger_logs_by_rule Number of logs by rule name\n"))
+	w.Write([]byte("# TYPE logger_logs_by_rule counter\n"))
+	for rule, count := range ruleCounts {
+		w.Write([]byte("logger_logs_by_rule{rule=\"" + rule + "\"} " + strconv.FormatInt(count, 10) + "\n"))
+	}
+	w.Write([]byte("# HELP logger_uptime_seconds Uptime in seconds\n"))
+	w.Write([]byte("# TYPE logger_uptime_seconds gauge\n"))
+	w.Write([]byte("logger_uptime_seconds " + strconv.Itoa(uptime) + "\n"))
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	w.Write([]byte("# HELP logger_go_mallocs_total Heap objects allocated since startup\n"))
+	w.Write([]byte("# TYPE logger_go_mallocs_total counter\n"))
+	w.Write([]byte("logger_go_mallocs_total " + strconv.FormatUint(mem.Mallocs, 10) + "\n"))
+	running, waiting, rejected := db.queries.stats()
+	w.Write([]byte("# HELP logger_queries_running Heavy queries running under the concurrency limit\n"))
+	w.Write([]byte("# TYPE logger_queries_running gauge\n"))
+	w.Write([]byte("logger_queries_running " + strconv.Itoa(running) + "\n"))
+	w.Write([]byte("# HELP logger_queries_waiting Heavy queries waiting for a slot\n"))
+	w.Write([]byte("# TYPE logger_queries_waiting gauge\n"))
+	w.Write([]byte("logger_queries_waiting " + strconv.Itoa(waiting) + "\n"))
+	w.Write([]byte("# HELP logger_queries_rejected_total Heavy queries rejected with a 503\n"))
+	w.Write([]byte("# TYPE logger_queries_rejected_total counter\n"))
+	w.Write([]byte("logger_queries_rejected_total " + strconv.FormatInt(rejected, 10) + "\n"))
+	w.Write([]byte("# HELP logger_ingest_queued_logs Logs acknowledged with X-Ingest-Ack: queued and not yet stored\n"))
+	w.Write([]byte("# TYPE logger_ingest_queued_logs gauge\n"))
+	w.Write([]byte("logger_ingest_queued_logs " + strconv.FormatInt(db.ingestQueue.queued.Load(), 10) + "\n"))
+	w.Write([]byte("# HELP logger_ingest_queue_failures_total Queued logs the writer failed to store\n"))
+	w.Write([]byte("# TYPE logger_ingest_queue_failures_total counter\n"))
+	w.Write([]byte("logger_ingest_queue_failures_total " + strconv.FormatInt(db.ingestQueue.failed.Load(), 10) + "\n"))
+	w.Write([]byte("# HELP logger_udp_datagrams_total Datagrams received by the UDP ingest listener\n"))
+	w.Write([]byte("# TYPE logger_udp_datagrams_total counter\n"))
+	w.Write([]byte("logger_udp_datagrams_total " + strconv.FormatInt(db.udpStats.received.Load(), 10) + "\n"))
+	w.Write([]byte("# HELP logger_udp_logs_stored_total Logs stored from UDP datagrams\n"))
+	w.Write([]byte("# TYPE logger_udp_logs_stored_total counter\n"))
+	w.Write([]byte("logger_udp_logs_stored_total " + strconv.FormatInt(db.udpStats.stored.Load(), 10) + "\n"))
+	w.Write([]byte("# HELP logger_udp_datagrams_dropped_total UDP datagrams dropped, by reason\n"))
+	w.Write([]byte("# TYPE logger_udp_datagrams_dropped_total counter\n"))
+	drops := db.udpStats.dropped.snapshot(udpDropReasons)
+	for _, reason := range udpDropReasons {
+		w.Write([]byte("logger_udp_datagrams_dropped_total{reason=\"" + reason + "\"} " + strconv.FormatInt(drops[reason], 10) + "\n"))
+	}
+	w.Write([]byte("# HELP logger_syslog_messages_total Syslog messages received, by transport\n"))
+	w.Write([]byte("# TYPE logger_syslog_messages_total counter\n"))
+	w.Write([]byte("logger_syslog_messages_total{transport=\"udp\"} " + strconv.FormatInt(db.syslogStats.udp.Load(), 10) + "\n"))
+	w.Write([]byte("logger_syslog_messages_total{transport=\"tcp\"} " + strconv.FormatInt(db.syslogStats.tcp.Load(), 10) + "\n"))
+	w.Write([]byte("# HELP logger_syslog_logs_stored_total Logs stored from syslog messages\n"))
+	w.Write([]byte("# TYPE logger_syslog_logs_stored_total counter\n"))
+	w.Write([]byte("logger_syslog_logs_stored_total " + strconv.FormatInt(db.syslogStats.stored.Load(), 10) + "\n"))
+	w.Write([]byte("# HELP logger_syslog_messages_dropped_total Syslog messages dropped, by reason\n"))
+	w.Write([]byte("# TYPE logger_syslog_messages_dropped_total counter\n"))
+	drops = db.syslogStats.dropped.snapshot(syslogDropReasons)
+	for _, reason := range syslogDropReasons {
+		w.Write([]byte("logger_syslog_messages_dropped_total{reason=\"" + reason + "\"} " + strconv.FormatInt(drops[reason], 10) + "\n"))
+	}
+	w.Write([]byte("# HELP logger_gelf_datagrams_total Datagrams received by the GELF listener\n"))
+	w.Write([]byte("# TYPE logger_gelf_datagrams_total counter\n"))
+	w.Write([]byte("logger_gelf_datagrams_total " + strconv.FormatInt(db.gelfStats.received.Load(), 10) + "\n"))
+	w.Write([]byte("# HELP logger_gelf_logs_stored_total Logs stored from GELF datagrams\n"))
+	w.Write([]byte("# TYPE logger_gelf_logs_stored_total counter\n"))
+	w.Write([]byte("logger_gelf_logs_stored_total " + strconv.FormatInt(db.gelfStats.stored.Load(), 10) + "\n"))
+	w.Write([]byte("# HELP logger_gelf_messages_dropped_total GELF messages received over UDP and dropped, by reason\n"))
+	w.Write([]byte("# TYPE logger_gelf_messages_dropped_total counter\n"))
+	drops = db.gelfStats.dropped.snapshot(gelfDropReasons)
+	for _, reason := range gelfDropReasons {
+		w.Write([]byte("logger_gelf_messages_dropped_total{reason=\"" + reason + "\"} " + strconv.FormatInt(drops[reason], 10) + "\n"))
+	}
+	reports := db.SchemaReports()
+	w.Write([]byte("# HELP logger_schema_logs_checked_total Ingested logs checked against each schema\n"))
+	w.Write([]byte("# TYPE logger_schema_logs_checked_total counter\n"))
+	for _, report := range reports {
+		w.Write([]byte("logger_schema_logs_checked_total{schema=\"" + report.Schema.Name + "\"} " + strconv.FormatInt(report.Checked, 10) + "\n"))
+	}
+	w.Write([]byte("# HELP logger_schema_logs_deviating_total Checked logs with missing or unexpected fields\n"))
+	w.Write([]byte("# TYPE logger_schema_logs_deviating_total counter\n"))
+	for _, report := range reports {
+		w.Write([]byte("logger_schema_logs_deviating_total{schema=\"" + report.Schema.Name + "\"} " + strconv.FormatInt(report.Deviating, 10) + "\n"))
+	}
+	w.Write([]byte("# HELP logger_schema_missing_fields_total Checked logs missing a required field\n"))
+	w.Write([]byte("# TYPE logger_schema_missing_fields_total counter\n"))
+	for _, report := range reports {
+		for field, count := range report.Missing {
+			w.Write([]byte("logger_schema_missing_fields_total{schema=\"" + report.Schema.Name + "\",field=" + strconv.Quote(field) + "} " + strconv.FormatInt(count, 10) + "\n"))
+		}
+	}
+	w.Write([]byte("# HELP logger_schema_unexpected_fields_total Checked logs carrying a field their schema does not list\n"))
+	w.Write([]byte("# TYPE logger_schema_unexpected_fields_total counter\n"))
+	for _, report := range reports {
+		for field, count := range report.Unexpected {
+			w.Write([]byte("logger_schema_unexpected_fields_total{schema=\"" + report.Schema.Name + "\",field=" + strconv.Quote(field) + "} " + strconv.FormatInt(count, 10) + "\n"))
+		}
+	}
+}
+
+// explainRequested reports whether the caller asked for ?explain=true.
+// Non-admin callers get a 403 written for them and the handler should stop.
+func explainRequested(w http.ResponseWriter, r *http.Request, db *Database) (explain bool, ok bool) {
+	if r.URL.Query().Get("explain") != "true" {
+		return false, true
+	}
+	if !db.requireAdmin(w, r) {
+		return true, false
+	}
+	return true, true
+}
+
+// writeExplanation responds with the SQL and query plan instead of the query results
+func writeExplanation(w http.ResponseWriter, db *Database, query string, args ...interface{}) {
+	explanation, err := db.Explain(query, args...)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(`{"error":"Failed to explain query"}`))
+		return
+	}
+	json.NewEncoder(w).Encode(explanation)
+}
+
+// DB-backed summary stats handler
+func summaryStatsHandlerDB(w http.ResponseWriter, r *http.Request, db *Database) {
+	enableCORS(w)
+	w.Header().Set("Content-Type", "application/json")
+	if explain, ok := explainRequested(w, r, db); !ok {
+		return
+	} else if explain {
+		writeExplanation(w, db, summaryStatsQuery)
+		return
+	}
+	if db.notModified(w, r, 0) {
+		return
+	}
+	stats, err := db.GetSummaryStats()
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(`{"error":"Failed to fetch summary stats"}`))
+		return
+	}
+	writeEncoded(w, r, stats)
+}
+
+// DB-backed urgency data handler
+func urgencyDataHandlerDB(w http.ResponseWriter, r *http.Request, db *Database) {
+	enableCORS(w)
+	w.Header().Set("Content-Type", "application/json")
+	if explain, ok := explainRequested(w, r, db); !ok {
+		return
+	} else if explain {
+		writeExplanation(w, db, recentCountsQuery, db.now().Add(-counterMinutes*time.Minute).UTC())
+		return
+	}
+	if db.notModified(w, r, time.Minute) {
+		return
+	}
+	data, err := db.GetUrgencyData()
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(`{"error":"Failed to fetch urgency data"}`))
+		return
+	}
+	writeEncoded(w, r, data)
+}
+
+// DB-backed timeline data handler
+func timelineDataHandlerDB(w http.ResponseWriter, r *http.Request, db *Database) {
+	enableCORS(w)
+	w.Header().Set("Content-Type", "application/json")
+	if explain, ok := explainRequested(w, r, db); !ok {
+		return
+	} else if explain {
+		writeExplanation(w, db, recentCountsQuery, db.now().Add(-counterMinutes*time.Minute).UTC())
+		return
+	}
+	loc, err := requestLocation(r.URL.Query())
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if db.notModified(w, r, time.Minute) {
+		return
+	}
+	data, err := db.GetTimelineData(loc)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(`{"error":"Failed to fetch timeline data"}`))
+		return
+	}
+	writeEncoded(w, r, data)
+}
+
+// DB-backed top events handler
+func topEventsHandlerDB(w http.ResponseWriter, r *http.Request, db *Database) {
+	enableCORS(w)
+	w.Header().Set("Content-Type", "application/json")
+	if explain, ok := explainRequested(w, r, db); !ok {
+		return
+	} else if explain {
+		writeExplanation(w, db, topEventsQuery)
+		return
+	}
+	if db.notModified(w, r, 0) {
+		return
+	}
+	events, err := db.GetTopEvents()
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(`{"error":"Failed to fetch top events"}`))
+		return
+	}
+	writeEncoded(w, r, events)
+}
+
+// DB-backed top sources handler
+func topSourcesHandlerDB(w http.ResponseWriter, r *http.Request, db *Database) {
+	enableCORS(w)
+	w.Header().Set("Content-Type", "application/json")
+	if explain, ok := explainRequested(w, r, db); !ok {
+		return
+	} else if explain {
+		writeExplanation(w, db, topSourcesQuery)
+		return
+	}
+	if db.notModified(w, r, 0) {
+		return
+	}
+	sources, err := db.GetTopSources()
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(`{"error":"Failed to fetch top sources"}`))
+		return
+	}
+	writeEncoded(w, r, sources)
+}
+
+// GET /api/top-destinations - most frequent destination IPs
+func topDestinationsHandlerDB(w http.ResponseWriter, r *http.Request, db *Database) {
+	enableCORS(w)
+	w.Header().Set("Content-Type", "application/json")
+	if explain, ok := explainRequested(w, r, db); !ok {
+		return
+	} else if explain {
+		writeExplanation(w, db, topDestinationsQuery)
+		return
+	}
+	if db.notModified(w, r, 0) {
+		return
+	}
+	destinations, err := db.GetTopDestinations()
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "Failed to fetch top destinations")
+		return
+	}
+	writeEncoded(w, r, destinations)
+}
+
+func validPort(port int) bool {
+	return port >= 0 && port <= 65535
+}
+
+// ingestMaxBytes caps a POST /api/logs or staged batch body as sent, which
+// is read whole before it is authenticated and decoded
+var ingestMaxBytes = envInt("INGEST_MAX_BYTES", 32<<20)
+
+// readIngestBody reads r's body into buf, reporting a body over
+// ingestMaxBytes as *http.MaxBytesError
+func readIngestBody(w http.ResponseWriter, r *http.Request, buf *bytes.Buffer) error {
+	if r.ContentLength > 0 && r.ContentLength <= maxPooledIngestBuffer {
+		buf.Grow(int(r.ContentLength) + bytes.MinRead)
+	}
+	_, err := buf.ReadFrom(http.MaxBytesReader(w, r.Body, int64(ingestMaxBytes)))
+	return err
+}
+
+// bodyReadStatus is the status to answer a failed body read with
+func bodyReadStatus(err error) (int, string) {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		return http.StatusRequestEntityTooLarge, "Body is too large"
+	}
+	return http.StatusBadRequest, "Invalid body"
+}
+
+// ingestBuffers holds request body buffers for reuse across ingest
+// requests. Decoding copies every string out of the body, so a buffer can go
+// back to the pool as soon as the handler returns.
+var ingestBuffers = sync.Pool{New: func() interface{} { return new(bytes.Buffer) }}
+
+// ingestBatches does the same for decoded batches. InsertLog copies each
+// entry, so nothing refers to a batch once the handler returns.
+var ingestBatches = sync.Pool{New: func() interface{} { return new([]LogEntry) }}
+
+// Oversized buffers and batches are left for the garbage collector so one
+// large request does not pin its memory in the pool
+const (
+	maxPooledIngestBuffer = 4 << 20
+	maxPooledIngestBatch  = 10000
+)
+
+func putIngestBuffer(buf *bytes.Buffer) {
+	if buf.Cap() > maxPooledIngestBuffer {
+		return
+	}
+	buf.Reset()
+	ingestBuffers.Put(buf)
+}
+
+// putIngestBatch zeroes the batch, which decodeIngestBody relies on, and
+// drops the strings it refers to
+func putIngestBatch(batch *[]LogEntry, entries []LogEntry) {
+	if cap(entries) > maxPooledIngestBatch {
+		return
+	}
+	clear(entries[:cap(entries)])
+	*batch = entries[:0]
+	ingestBatches.Put(batch)
+}
+
+// DB-backed log ingestion handler
+// decodeIngestBody reads one log or a batch: a JSON object, a JSON array,
+// or a protobuf LogBatch (see proto/logger.proto). The logs are appended to
+// entries, which must be zeroed.
+func decodeIngestBody(contentType string, body []byte, entries []LogEntry) ([]LogEntry, error) {
+	if mediaType, _, _ := mime.ParseMediaType(contentType); mediaType == contentTypeProtobuf {
+		entries, err := decodeLogBatch(body, entries)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid protobuf: %v", err)
+		}
+		return entries, nil
+	}
+	body = bytes.TrimSpace(body)
+	if len(body) > 0 && body[0] == '[' {
+		if storeRawPayloads {
+			return decodeRawJSONBatch(body, entries)
+		}
+		if err := json.Unmarshal(body, &entries); err != nil {
+			return nil, errors.New("Invalid JSON")
+		}
+		return entries, nil
+	}
+	entries = append(entries, LogEntry{})
+	if err := json.Unmarshal(body, &entries[len(entries)-1]); err != nil {
+		return nil, errors.New("Invalid JSON")
+	}
+	if storeRawPayloads {
+		entries[len(entries)-1].Raw, entries[len(entries)-1].RawFormat = bytes.Clone(body), rawJSON
+	}
+	return entries, nil
+}
+
+// decodeRawJSONBatch decodes a JSON array of logs, keeping each element as
+// its log's raw payload
+func decodeRawJSONBatch(body []byte, entries []LogEntry) ([]LogEntry, error) {
+	var elements []json.RawMessage
+	if err := json.Unmarshal(body, &elements); err != nil {
+		return nil, errors.New("Invalid JSON")
+	}
+	entries = slices.Grow(entries, len(elements))
+	for _, element := range elements {
+		entries = append(entries, LogEntry{})
+		entry := &entries[len(entries)-1]
+		if err := json.Unmarshal(element, entry); err != nil {
+			return nil, errors.New("Invalid JSON")
+		}
+		entry.Raw, entry.RawFormat = element, rawJSON
+	}
+	return entries, nil
+}
+
+// prepareLogEntry fills in ingest defaults, stamping entries without a
+// timestamp with now, and validates the entry
+func prepareLogEntry(entry *LogEntry, now time.Time) error {
+	if entry.Timestamp.IsZero() {
+		entry.Timestamp = now
+	}
+	if entry.Level == "" {
+		entry.Level = "INFO"
+	}
+	if !validPort(entry.SourcePort) || !validPort(entry.DestinationPort) {
+		return errors.New("Ports must be between 0 and 65535")
+	}
+	tags, err := normalizeTags(entry.Tags)
+	if err != nil {
+		return err
+	}
+	entry.Tags = tags
+	return nil
+}
+
+func logIngestHandlerDB(w http.ResponseWriter, r *http.Request, db *Database) {
+	enableCORS(w)
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		w.Write([]byte("Method not allowed"))
+		return
+	}
+	ack, err := ingestAckMode(r.Header.Get("X-Ingest-Ack"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	buf := ingestBuffers.Get().(*bytes.Buffer)
+	defer putIngestBuffer(buf)
+	if err := readIngestBody(w, r, buf); err != nil {
+		status, message := bodyReadStatus(err)
+		w.WriteHeader(status)
+		w.Write([]byte(message))
+		return
+	}
+	body := buf.Bytes()
+	now := db.now()
+	token, err := db.authenticateIngest(r, body, now)
+	if err != nil {
+		status := http.StatusUnauthorized
+		if errors.Is(err, errReplay) {
+			status = http.StatusConflict
+		}
+		w.WriteHeader(status)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	if encoding := ingestEncoding(r); encoding != "" {
+		decompressed := ingestBuffers.Get().(*bytes.Buffer)
+		defer putIngestBuffer(decompressed)
+		if body, err = decompressIngestBody(encoding, body, decompressed); err != nil {
+			w.WriteHeader(decompressionStatus(err))
+			w.Write([]byte(err.Error()))
+			return
+		}
+	}
+	batch := ingestBatches.Get().(*[]LogEntry)
+	entries, err := decodeIngestBody(r.Header.Get("Content-Type"), body, *batch)
+	defer putIngestBatch(batch, entries)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	for i := range entries {
+		if err := prepareLogEntry(&entries[i], now); err != nil {
+			if len(entries) > 1 {
+				err = fmt.Errorf("log %d: %w", i, err)
+			}
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(err.Error()))
+			return
+		}
+	}
+	if err := db.admitIngest(token, len(entries), now); err != nil {
+		w.Header().Set("Retry-After", strconv.Itoa(int(untilNextQuotaDay(now).Seconds())+1))
+		w.WriteHeader(http.StatusTooManyRequests)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	w.Header().Set("X-Ingest-Ack", ack)
+	if ack == ingestAckQueued {
+		if err := db.ingestQueue.enqueue(entries); err != nil {
+			status := http.StatusInternalServerError
+			if errors.Is(err, errIngestQueueFull) {
+				w.Header().Set("Retry-After", "1")
+				status = http.StatusServiceUnavailable
+			}
+			w.WriteHeader(status)
+			w.Write([]byte(err.Error()))
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+		w.Write([]byte("Accepted"))
+		return
+	}
+	for _, entry := range entries {
+		if err := db.InsertLog(entry); err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte("Failed to insert log"))
+			return
+		}
+	}
+	w.WriteHeader(http.StatusCreated)
+	w.Write([]byte("OK"))
+}
+
+// DB-backed log search handler
+func logSearchHandlerDB(w http.ResponseWriter, r *http.Request, db *Database) {
+	enableCORS(w)
+	w.Header().Set("Content-Type", "application/json")
+	filter, err := parseLogFilter(r.URL.Query(), db.now())
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	filter = scopedFilter(r, filter)
+	masks := requestMasks(r)
+	if param, ok := masks.revealingParam(r.URL.Query()); ok {
+		writeMaskedParam(w, param)
+		return
+	}
+	db.recordSearch(r, r.URL.Query())
+	fields, err := parseFields(r.URL.Query().Get("fields"))
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	limitStr := r.URL.Query().Get("limit")
+	limit := 100
+	if limitStr != "" {
+		if l, err := strconv.Atoi(limitStr); err == nil && l > 0 && l <= 1000 {
+			limit = l
+		}
+	}
+	countOnly, exists := r.URL.Query().Get("count_only") == "true", r.URL.Query().Get("exists") == "true"
+	includeArchive := r.URL.Query().Get("include_archive") == "true"
+	if includeArchive && db.archive == nil {
+		writeJSONError(w, http.StatusBadRequest, "include_archive requires ARCHIVE_URL to be configured")
+		return
+	}
+	if includeArchive && (countOnly || exists || r.URL.Query().Get("group_by") != "") {
+		writeJSONError(w, http.StatusBadRequest, "include_archive cannot be combined with count_only, exists or group_by")
+		return
+	}
+	if countOnly || exists {
+		writeCountOrExists(w, r, db, filter, exists)
+		return
+	}
+	groupBy := r.URL.Query().Get("group_by")
+	if _, ok := logGroupColumns[groupBy]; groupBy != "" && !ok {
+		writeJSONError(w, http.StatusBadRequest, "Unknown group_by "+groupBy)
+		return
+	}
+	sort, err := parseSort(r.URL.Query().Get("sort"), groupBy != "", &db.levels)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if explain, ok := explainRequested(w, r, db); !ok {
+		return
+	} else if explain {
+		query, args := buildSearchQuery(filter, sort, limit)
+		if groupBy != "" {
+			query, args = buildGroupQuery(filter, groupBy, sort, limit)
+		}
+		writeExplanation(w, db, query, args...)
+		return
+	}
+	if groupBy != "" {
+		groups, err := db.GroupLogs(filter, groupBy, sort, limit)
+		if err != nil {
+			writeJSONError(w, http.StatusInternalServerError, "Failed to search logs")
+			return
+		}
+		writeEncoded(w, r, groups)
+		return
+	}
+	logs, err := db.SearchLogs(filter, sort, limit)
+	if err == nil && hasField(fields, "annotations") {
+		err = db.attachAnnotations(logs)
+	}
+	if err == nil && hasField(fields, "tags") {
+		err = db.attachTags(logs)
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(`{"error":"Failed to search logs"}`))
+		return
+	}
+	if includeArchive {
+		var segments []string
+		var skipped int
+		if logs, segments, skipped, err = db.mergeArchive(logs, filter, sort, limit); err != nil {
+			log.Printf("archive search failed: %v", err)
+			writeJSONError(w, http.StatusBadGateway, "Failed to search archive")
+			return
+		}
+		w.Header().Set("X-Archive-Segments", strings.Join(segments, ","))
+		w.Header().Set("X-Archive-Skipped", strconv.Itoa(skipped))
+		// Keep archived results labelled when projecting
+		if fields != nil && !hasField(fields, "archive") {
+			fields = append(fields, "archive")
+		}
+	}
+	masks.apply(logs)
+	if fields != nil {
+		writeEncoded(w, r, projectLogs(logs, fields))
+		return
+	}
+	writeEncoded(w, r, logs)
+}
+
+// writeCountOrExists answers count_only=true with a bare number and
+// exists=true with a bare boolean, without transferring any rows
+func writeCountOrExists(w http.ResponseWriter, r *http.Request, db *Database, filter LogFilter, exists bool) {
+	query, args := buildCountQuery(filter)
+	if exists {
+		query, args = buildExistsQuery(filter)
+	}
+	if explain, ok := explainRequested(w, r, db); !ok {
+		return
+	} else if explain {
+		writeExplanation(w, db, query, args...)
+		return
+	}
+	var result interface{}
+	var err error
+	if exists {
+		result, err = db.LogsExist(filter)
+	} else {
+		result, err = db.CountLogs(filter)
+	}
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "Failed to search logs")
+		return
+	}
+	writeEncoded(w, r, result)
+}
+
+// parseTimeRange reads since=/range= or RFC3339 from/to query parameters,
+// defaulting to the window ending now when either is omitted
+func parseTimeRange(r *http.Request, now time.Time, defaultWindow time.Duration) (time.Time, time.Time, error) {
+	if from, to, ok, err := relativeRange(r.URL.Query(), now); ok || err != nil {
+		return from, to, err
+	}
+	to := now
+	if toStr := r.URL.Query().Get("to"); toStr != "" {
+		t, err := time.Parse(time.RFC3339, toStr)
+		if err != nil {
+			return time.Time{}, time.Time{}, errors.New("Invalid 'to' timestamp")
+		}
+		to = t
+	}
+	from := to.Add(-defaultWindow)
+	if fromStr := r.URL.Query().Get("from"); fromStr != "" {
+		t, err := time.Parse(time.RFC3339, fromStr)
+		if err != nil {
+			return time.Time{}, time.Time{}, errors.New("Invalid 'from' timestamp")
+		}
+		from = t
+	}
+	if !from.Before(to) {
+		return time.Time{}, time.Time{}, errors.New("'from' must be before 'to'")
+	}
+	return from, to, nil
+}
+
+// writeJSONError writes a {"error": ...} body with the given status
+func writeJSONError(w http.ResponseWriter, status int, message string) {
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"error": message})
+}
+
+// GET /api/unique?from=&to=&interval=hour|day - HLL cardinality estimates
+func uniqueCountsHandlerDB(w http.ResponseWriter, r *http.Request, db *Database) {
+	enableCORS(w)
+	w.Header().Set("Content-Type", "application/json")
+	from, to, err := parseTimeRange(r, db.now(), 24*time.Hour)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	interval := time.Hour
+	switch r.URL.Query().Get("interval") {
+	case "", "hour":
+	case "day":
+		interval = 24 * time.Hour
+	default:
+		writeJSONError(w, http.StatusBadRequest, "interval must be 'hour' or 'day'")
+		return
+	}
+	if db.notModified(w, r, interval) {
+		return
+	}
+	counts, err := db.GetUniqueCounts(from, to, interval)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "Failed to fetch unique counts")
+		return
+	}
+	writeEncoded(w, r, counts)
+}
+
+// GET /api/histogram?from=&to=&interval=auto - log volume per time bucket
+func histogramHandlerDB(w http.ResponseWriter, r *http.Request, db *Database) {
+	enableCORS(w)
+	w.Header().Set("Content-Type", "application/json")
+	from, to, err := parseTimeRange(r, db.now(), 24*time.Hour)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	interval, err := parseInterval(r.URL.Query().Get("interval"), to.Sub(from))
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	hist, err := db.GetHistogram(from, to, interval, r.URL.Query().Get("ip"), r.URL.Query().Get("event"))
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "Failed to fetch histogram")
+		return
+	}
+	writeEncoded(w, r, hist)
+}
+
+// GET /api/heatmap?from=&to=&rule=&category=&tz= - weekday x hour activity matrix
+func heatmapHandlerDB(w http.ResponseWriter, r *http.Request, db *Database) {
+	enableCORS(w)
+	w.Header().Set("Content-Type", "application/json")
+	from, to, err := parseTimeRange(r, db.now(), 28*24*time.Hour)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	category := r.URL.Query().Get("category")
+	switch category {
+	case "", "access", "network", "threat", "uba":
+	default:
+		writeJSONError(w, http.StatusBadRequest, "category must be one of access, network, threat, uba")
+		return
+	}
+	loc, err := requestLocation(r.URL.Query())
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	heatmap, err := db.GetHeatmap(from, to, r.URL.Query().Get("rule"), category, loc)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "Failed to fetch heatmap")
+		return
+	}
+	writeEncoded(w, r, heatmap)
+}
+
+// GET /api/graph?from=&to=&limit= - source/destination communication graph
+func graphHandlerDB(w http.ResponseWriter, r *http.Request, db *Database) {
+	enableCORS(w)
+	w.Header().Set("Content-Type", "application/json")
+	from, to, err := parseTimeRange(r, db.now(), 24*time.Hour)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	limit := 500
+	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+		if l, err := strconv.Atoi(limitStr); err == nil && l > 0 && l <= 5000 {
+			limit = l
+		}
+	}
+	graph, err := db.GetGraph(from, to, limit)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "Failed to fetch graph")
+		return
+	}
+	writeEncoded(w, r, graph)
+}
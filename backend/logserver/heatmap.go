@@ -0,0 +1,69 @@
+package logserver
+
+import "time"
+
+var heatmapDays = []string{"Sun", "Mon", "Tue", "Wed", "Thu", "Fri", "Sat"}
+
+// Heatmap is a day-of-week x hour-of-day matrix of event counts in the TZ
+// location. Matrix[0] is Sunday, matching time.Weekday.
+type Heatmap struct {
+	From   time.Time `json:"from"`
+	To     time.Time `json:"to"`
+	TZ     string    `json:"tz"`
+	Days   []string  `json:"days"`
+	Hours  []int     `json:"hours"`
+	Matrix [][]int   `json:"matrix"`
+	Max    int       `json:"max"`
+}
+
+// GetHeatmap counts logs per weekday and hour in loc, optionally limited to
+// rules containing rule and to rules in category
+func (d *Database) GetHeatmap(from, to time.Time, rule, category string, loc *time.Location) (Heatmap, error) {
+	heatmap := Heatmap{From: from, To: to, TZ: loc.String(), Days: heatmapDays, Hours: make([]int, 24), Matrix: make([][]int, 7)}
+	for h := range heatmap.Hours {
+		heatmap.Hours[h] = h
+	}
+	for day := range heatmap.Matrix {
+		heatmap.Matrix[day] = make([]int, 24)
+	}
+
+	// Counted per quarter hour and bucketed here, since every UTC offset in
+	// use is a whole number of quarter hours and SQLite knows no zones
+	query := `
+		SELECT
+			CAST(strftime('%s', timestamp) AS INTEGER) / 900 AS quarter,
+			COUNT(*) AS count
+		FROM logs
+		WHERE timestamp >= ? AND timestamp < ?
+	`
+	args := []interface{}{from.UTC(), to.UTC()}
+	if rule != "" {
+		query += ` AND rule LIKE ?`
+		args = append(args, "%"+rule+"%")
+	}
+	if category != "" {
+		query += ` AND ` + ruleCategoryExpr + ` = ?`
+		args = append(args, category)
+	}
+	query += ` GROUP BY quarter`
+
+	rows, err := d.db.Query(query, args...)
+	if err != nil {
+		return heatmap, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var quarter int64
+		var count int
+		if err := rows.Scan(&quarter, &count); err != nil {
+			return heatmap, err
+		}
+		local := time.Unix(quarter*900, 0).In(loc)
+		day, hour := int(local.Weekday()), local.Hour()
+		heatmap.Matrix[day][hour] += count
+		if heatmap.Matrix[day][hour] > heatmap.Max {
+			heatmap.Max = heatmap.Matrix[day][hour]
+		}
+	}
+	return heatmap, rows.Err()
+}
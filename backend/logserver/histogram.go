@@ -0,0 +1,150 @@
+package logserver
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// histogramTargetBuckets is how many buckets interval=auto aims for
+const histogramTargetBuckets = 100
+
+// histogramMaxBuckets caps explicit intervals that would produce huge responses
+const histogramMaxBuckets = 5000
+
+// niceIntervals are the bucket sizes interval=auto can choose from
+var niceIntervals = []time.Duration{
+	time.Second, 5 * time.Second, 10 * time.Second, 30 * time.Second,
+	time.Minute, 5 * time.Minute, 10 * time.Minute, 15 * time.Minute, 30 * time.Minute,
+	time.Hour, 3 * time.Hour, 6 * time.Hour, 12 * time.Hour,
+	24 * time.Hour, 7 * 24 * time.Hour,
+}
+
+// HistogramBucket is the number of logs whose timestamp falls in [Start, Start+interval)
+type HistogramBucket struct {
+	Start  time.Time `json:"start"`
+	Count  int       `json:"count"`
+	Search string    `json:"search"`
+}
+
+// Histogram is log volume over a time range
+type Histogram struct {
+	From            time.Time         `json:"from"`
+	To              time.Time         `json:"to"`
+	Interval        string            `json:"interval"`
+	IntervalSeconds int64             `json:"intervalSeconds"`
+	Buckets         []HistogramBucket `json:"buckets"`
+	// Annotations are the deploys, maintenance and incidents within the range
+	Annotations []TimelineAnnotation `json:"annotations"`
+}
+
+// autoInterval picks the smallest nice interval giving at most ~100 buckets
+func autoInterval(span time.Duration) time.Duration {
+	for _, interval := range niceIntervals {
+		if span/interval <= histogramTargetBuckets {
+			return interval
+		}
+	}
+	return niceIntervals[len(niceIntervals)-1]
+}
+
+// parseInterval accepts "auto", Go durations ("5m", "1h") and whole days ("1d")
+func parseInterval(value string, span time.Duration) (time.Duration, error) {
+	if value == "" || value == "auto" {
+		return autoInterval(span), nil
+	}
+	var interval time.Duration
+	if strings.HasSuffix(value, "d") {
+		days, err := strconv.Atoi(strings.TrimSuffix(value, "d"))
+		if err != nil {
+			return 0, fmt.Errorf("invalid interval %q", value)
+		}
+		interval = time.Duration(days) * 24 * time.Hour
+	} else {
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return 0, fmt.Errorf("invalid interval %q", value)
+		}
+		interval = d
+	}
+	if interval < time.Second {
+		return 0, fmt.Errorf("interval must be at least 1s")
+	}
+	if span/interval > histogramMaxBuckets {
+		return 0, fmt.Errorf("interval %s produces more than %d buckets", value, histogramMaxBuckets)
+	}
+	return interval, nil
+}
+
+// formatInterval renders an interval the way parseInterval accepts it
+func formatInterval(interval time.Duration) string {
+	if interval >= 24*time.Hour && interval%(24*time.Hour) == 0 {
+		return strconv.Itoa(int(interval/(24*time.Hour))) + "d"
+	}
+	s := interval.String()
+	s = strings.TrimSuffix(s, "0s")
+	s = strings.TrimSuffix(s, "0m")
+	return s
+}
+
+func (d *Database) GetHistogram(from, to time.Time, interval time.Duration, ip, event string) (Histogram, error) {
+	secs := int64(interval / time.Second)
+	first := from.Unix() / secs * secs
+	hist := Histogram{
+		From:            from,
+		To:              to,
+		Interval:        formatInterval(interval),
+		IntervalSeconds: secs,
+		Buckets:         []HistogramBucket{},
+	}
+	for start := first; start < to.Unix(); start += secs {
+		// The first and last buckets only count logs within the range
+		f := LogFilter{IP: ip, Event: event, From: time.Unix(start, 0), To: time.Unix(start+secs, 0)}
+		if f.From.Before(from) {
+			f.From = from
+		}
+		if f.To.After(to) {
+			f.To = to
+		}
+		hist.Buckets = append(hist.Buckets, HistogramBucket{Start: time.Unix(start, 0).UTC(), Search: searchURL(f)})
+	}
+
+	query := `
+		SELECT CAST(strftime('%s', timestamp) AS INTEGER) / ? * ? AS bucket, COUNT(*)
+		FROM logs
+		WHERE timestamp >= ? AND timestamp < ?
+	`
+	args := []interface{}{secs, secs, from.UTC(), to.UTC()}
+	if ip != "" {
+		query += ` AND (source_ip LIKE ? OR destination_ip LIKE ?)`
+		args = append(args, "%"+ip+"%", "%"+ip+"%")
+	}
+	if event != "" {
+		query += ` AND event LIKE ?`
+		args = append(args, "%"+event+"%")
+	}
+	query += ` GROUP BY bucket`
+
+	rows, err := d.db.Query(query, args...)
+	if err != nil {
+		return hist, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var bucket int64
+		var count int
+		if err := rows.Scan(&bucket, &count); err != nil {
+			return hist, err
+		}
+		idx := (bucket - first) / secs
+		if idx >= 0 && idx < int64(len(hist.Buckets)) {
+			hist.Buckets[idx].Count += count
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return hist, err
+	}
+	hist.Annotations, err = d.chartAnnotations(from, to)
+	return hist, err
+}
@@ -0,0 +1,83 @@
+package logserver
+
+import (
+	"hash/fnv"
+	"math"
+	"math/bits"
+)
+
+// hllPrecision gives 4096 registers, roughly 1.6% standard error
+const hllPrecision = 12
+
+const hllRegisters = 1 << hllPrecision
+
+// HyperLogLog is a cardinality sketch; its registers are stored as-is in rollups
+type HyperLogLog struct {
+	registers []uint8
+}
+
+func NewHyperLogLog() *HyperLogLog {
+	return &HyperLogLog{registers: make([]uint8, hllRegisters)}
+}
+
+// hyperLogLogFromBytes restores a sketch persisted with Bytes
+func hyperLogLogFromBytes(b []byte) *HyperLogLog {
+	h := NewHyperLogLog()
+	copy(h.registers, b)
+	return h
+}
+
+func (h *HyperLogLog) Bytes() []byte {
+	b := make([]byte, len(h.registers))
+	copy(b, h.registers)
+	return b
+}
+
+func (h *HyperLogLog) Add(value string) {
+	hasher := fnv.New64a()
+	hasher.Write([]byte(value))
+	x := mix64(hasher.Sum64())
+	idx := x >> (64 - hllPrecision)
+	rank := uint8(bits.LeadingZeros64(x<<hllPrecision|1<<(hllPrecision-1)) + 1)
+	if rank > h.registers[idx] {
+		h.registers[idx] = rank
+	}
+}
+
+// Merge folds other into h so h estimates the union of both sets
+func (h *HyperLogLog) Merge(other *HyperLogLog) {
+	for i, r := range other.registers {
+		if r > h.registers[i] {
+			h.registers[i] = r
+		}
+	}
+}
+
+func (h *HyperLogLog) Estimate() int {
+	m := float64(hllRegisters)
+	sum := 0.0
+	zeros := 0
+	for _, r := range h.registers {
+		sum += 1 / float64(uint64(1)<<r)
+		if r == 0 {
+			zeros++
+		}
+	}
+	alpha := 0.7213 / (1 + 1.079/m)
+	estimate := alpha * m * m / sum
+	// Small range correction via linear counting
+	if estimate <= 2.5*m && zeros > 0 {
+		estimate = m * math.Log(m/float64(zeros))
+	}
+	return int(estimate + 0.5)
+}
+
+// mix64 is the splitmix64 finalizer; FNV alone distributes short strings poorly
+func mix64(x uint64) uint64 {
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
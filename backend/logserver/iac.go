@@ -0,0 +1,421 @@
+package logserver
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// The /api/iac endpoints manage configuration declaratively for Terraform
+// providers and other infrastructure-as-code tools. Each resource is
+// addressed by a caller-chosen external ID, PUT creates or replaces it
+// idempotently, and ETags (a hash of the resource's spec) let callers
+// detect drift and make conditional updates.
+
+// IaCResource is the representation of a managed resource. Spec holds the
+// resource's settings without server-assigned fields; Key is only set in
+// the response that creates an API key.
+type IaCResource struct {
+	Kind       string                 `json:"kind"`
+	ExternalID string                 `json:"externalId"`
+	ID         int64                  `json:"id"`
+	ETag       string                 `json:"etag"`
+	Spec       map[string]interface{} `json:"spec"`
+	Key        string                 `json:"key,omitempty"`
+}
+
+// iacKind adapts one resource type. read returns sql.ErrNoRows when the
+// resource was deleted outside of IaC; create returns an optional secret.
+type iacKind struct {
+	decode func(body []byte) (interface{}, error)
+	read   func(d *Database, id int64) (interface{}, error)
+	create func(d *Database, spec interface{}) (int64, string, error)
+	update func(d *Database, id int64, spec interface{}) error
+	remove func(d *Database, id int64) error
+}
+
+// iacServerFields are assigned by the server and left out of specs
+var iacServerFields = []string{"id", "createdAt", "prefix"}
+
+var iacExternalID = regexp.MustCompile(`^[A-Za-z0-9._-]{1,128}$`)
+
+// iacMu serializes writes so conditional requests cannot interleave
+var iacMu sync.Mutex
+
+// decodeIaCSpec strictly decodes body into v so typos in a spec are errors
+func decodeIaCSpec(body []byte, v interface{}) error {
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		return errors.New("Invalid spec: " + err.Error())
+	}
+	return nil
+}
+
+var iacKinds = map[string]iacKind{
+	"alert_rules": {
+		decode: func(body []byte) (interface{}, error) {
+			rule := AlertRule{Enabled: true}
+			if err := decodeIaCSpec(body, &rule); err != nil {
+				return nil, err
+			}
+			return rule, rule.validate()
+		},
+		read: func(d *Database, id int64) (interface{}, error) { return d.GetAlertRule(id) },
+		create: func(d *Database, spec interface{}) (int64, string, error) {
+			rule, err := d.CreateAlertRule(spec.(AlertRule))
+			return rule.ID, "", err
+		},
+		update: func(d *Database, id int64, spec interface{}) error {
+			rule := spec.(AlertRule)
+			rule.ID = id
+			return d.UpdateAlertRule(rule)
+		},
+		remove: func(d *Database, id int64) error { return d.DeleteAlertRule(id) },
+	},
+	"tag_rules": {
+		decode: func(body []byte) (interface{}, error) {
+			var rule TagRule
+			if err := decodeIaCSpec(body, &rule); err != nil {
+				return nil, err
+			}
+			tags, err := normalizeTags([]string{rule.Tag})
+			if err != nil {
+				return nil, err
+			}
+			rule.Tag = tags[0]
+			return rule, nil
+		},
+		read: func(d *Database, id int64) (interface{}, error) { return d.GetTagRule(id) },
+		create: func(d *Database, spec interface{}) (int64, string, error) {
+			rule, err := d.CreateTagRule(spec.(TagRule))
+			return rule.ID, "", err
+		},
+		update: func(d *Database, id int64, spec interface{}) error {
+			rule := spec.(TagRule)
+			rule.ID = id
+			return d.UpdateTagRule(rule)
+		},
+		remove: func(d *Database, id int64) error { return d.DeleteTagRule(id) },
+	},
+	"saved_searches": {
+		decode: func(body []byte) (interface{}, error) {
+			var s SavedSearch
+			if err := decodeIaCSpec(body, &s); err != nil {
+				return nil, err
+			}
+			return s, s.validate()
+		},
+		read: func(d *Database, id int64) (interface{}, error) { return d.GetSavedSearch(id) },
+		create: func(d *Database, spec interface{}) (int64, string, error) {
+			s, err := d.CreateSavedSearch(spec.(SavedSearch))
+			return s.ID, "", err
+		},
+		update: func(d *Database, id int64, spec interface{}) error {
+			s := spec.(SavedSearch)
+			s.ID = id
+			return d.UpdateSavedSearch(s)
+		},
+		remove: func(d *Database, id int64) error { return d.DeleteSavedSearch(id) },
+	},
+	"api_keys": {
+		decode: func(body []byte) (interface{}, error) {
+			var k APIKey
+			if err := decodeIaCSpec(body, &k); err != nil {
+				return nil, err
+			}
+			return k, k.validate()
+		},
+		read: func(d *Database, id int64) (interface{}, error) { return d.GetAPIKey(id) },
+		create: func(d *Database, spec interface{}) (int64, string, error) {
+			k, secret, err := d.CreateAPIKey(spec.(APIKey))
+			return k.ID, secret, err
+		},
+		update: func(d *Database, id int64, spec interface{}) error {
+			k := spec.(APIKey)
+			k.ID = id
+			return d.UpdateAPIKey(k)
+		},
+		remove: func(d *Database, id int64) error { return d.DeleteAPIKey(id) },
+	},
+}
+
+func createIaCTables(db *sql.DB) error {
+	_, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS iac_resources (
+			kind TEXT NOT NULL,
+			external_id TEXT NOT NULL,
+			internal_id INTEGER NOT NULL,
+			PRIMARY KEY (kind, external_id)
+		)
+	`)
+	return err
+}
+
+// iacSpec returns v's JSON fields without the server-assigned ones
+func iacSpec(v interface{}) map[string]interface{} {
+	raw, _ := json.Marshal(v)
+	var spec map[string]interface{}
+	json.Unmarshal(raw, &spec)
+	for _, field := range iacServerFields {
+		delete(spec, field)
+	}
+	return spec
+}
+
+// iacETag hashes a spec; encoding/json sorts map keys, so equal specs hash equally
+func iacETag(spec map[string]interface{}) string {
+	raw, _ := json.Marshal(spec)
+	sum := sha256.Sum256(raw)
+	return `"` + hex.EncodeToString(sum[:8]) + `"`
+}
+
+func (d *Database) iacInternalID(kind, externalID string) (int64, error) {
+	var id int64
+	err := d.db.QueryRow(`SELECT internal_id FROM iac_resources WHERE kind = ? AND external_id = ?`, kind, externalID).Scan(&id)
+	return id, err
+}
+
+// iacResource reads a managed resource; sql.ErrNoRows means it does not exist
+func (d *Database) iacResource(name string, kind iacKind, externalID string) (IaCResource, error) {
+	id, err := d.iacInternalID(name, externalID)
+	if err != nil {
+		return IaCResource{}, err
+	}
+	v, err := kind.read(d, id)
+	if err != nil {
+		return IaCResource{}, err
+	}
+	spec := iacSpec(v)
+	return IaCResource{Kind: name, ExternalID: externalID, ID: id, ETag: iacETag(spec), Spec: spec}, nil
+}
+
+func (d *Database) iacResources(name string, kind iacKind) ([]IaCResource, error) {
+	rows, err := d.db.Query(`SELECT external_id FROM iac_resources WHERE kind = ? ORDER BY external_id`, name)
+	if err != nil {
+		return nil, err
+	}
+	var externalIDs []string
+	for rows.Next() {
+		var externalID string
+		if err := rows.Scan(&externalID); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		externalIDs = append(externalIDs, externalID)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	resources := []IaCResource{}
+	for _, externalID := range externalIDs {
+		res, err := d.iacResource(name, kind, externalID)
+		if errors.Is(err, sql.ErrNoRows) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		resources = append(resources, res)
+	}
+	return resources, nil
+}
+
+// PutIaCResource creates or replaces a resource and reports whether it was
+// created. ifMatch and ifNoneMatch are the request's precondition headers.
+func (d *Database) PutIaCResource(name string, kind iacKind, externalID string, spec interface{}, ifMatch, ifNoneMatch string) (IaCResource, bool, error) {
+	iacMu.Lock()
+	defer iacMu.Unlock()
+
+	current, err := d.iacResource(name, kind, externalID)
+	exists := err == nil
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return current, false, err
+	}
+	if !iacPreconditions(current, exists, ifMatch, ifNoneMatch) {
+		return current, false, errIaCPrecondition
+	}
+
+	if exists {
+		if iacETag(iacSpec(spec)) == current.ETag {
+			return current, false, nil
+		}
+		if err := kind.update(d, current.ID, spec); err != nil {
+			return current, false, err
+		}
+		res, err := d.iacResource(name, kind, externalID)
+		return res, false, err
+	}
+
+	// Either new, or deleted outside of IaC and recreated
+	id, secret, err := kind.create(d, spec)
+	if err != nil {
+		return current, false, err
+	}
+	_, err = d.db.Exec(`
+		INSERT INTO iac_resources (kind, external_id, internal_id) VALUES (?, ?, ?)
+		ON CONFLICT (kind, external_id) DO UPDATE SET internal_id = excluded.internal_id
+	`, name, externalID, id)
+	if err != nil {
+		return current, false, err
+	}
+	res, err := d.iacResource(name, kind, externalID)
+	res.Key = secret
+	return res, true, err
+}
+
+// DeleteIaCResource removes a resource; sql.ErrNoRows means it did not exist
+func (d *Database) DeleteIaCResource(name string, kind iacKind, externalID, ifMatch string) error {
+	iacMu.Lock()
+	defer iacMu.Unlock()
+
+	current, err := d.iacResource(name, kind, externalID)
+	if errors.Is(err, sql.ErrNoRows) {
+		// Forget a mapping whose resource was deleted elsewhere
+		d.db.Exec(`DELETE FROM iac_resources WHERE kind = ? AND external_id = ?`, name, externalID)
+		return err
+	}
+	if err != nil {
+		return err
+	}
+	if !iacPreconditions(current, true, ifMatch, "") {
+		return errIaCPrecondition
+	}
+	if err := kind.remove(d, current.ID); err != nil {
+		return err
+	}
+	_, err = d.db.Exec(`DELETE FROM iac_resources WHERE kind = ? AND external_id = ?`, name, externalID)
+	return err
+}
+
+var errIaCPrecondition = errors.New("precondition failed")
+
+// iacPreconditions evaluates If-Match and If-None-Match against the current resource
+func iacPreconditions(current IaCResource, exists bool, ifMatch, ifNoneMatch string) bool {
+	if ifMatch != "" && (!exists || (ifMatch != "*" && !etagListContains(ifMatch, current.ETag))) {
+		return false
+	}
+	if ifNoneMatch != "" && exists && (ifNoneMatch == "*" || etagListContains(ifNoneMatch, current.ETag)) {
+		return false
+	}
+	return true
+}
+
+func etagListContains(header, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		if strings.TrimPrefix(strings.TrimSpace(candidate), "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
+
+func writeIaCResource(w http.ResponseWriter, status int, res IaCResource) {
+	w.Header().Set("ETag", res.ETag)
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(res)
+}
+
+// /api/iac/{kind} and /api/iac/{kind}/{externalId} - declarative management
+// of alert rules, tag rules, saved searches and API keys (admin only)
+func iacHandlerDB(w http.ResponseWriter, r *http.Request, db *Database) {
+	enableCORS(w)
+	w.Header().Set("Content-Type", "application/json")
+	if !db.requireAdmin(w, r) {
+		return
+	}
+	name, externalID, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/api/iac/"), "/")
+	kind, ok := iacKinds[name]
+	if !ok {
+		writeJSONError(w, http.StatusNotFound, "Unknown resource kind")
+		return
+	}
+
+	if externalID == "" {
+		if r.Method != http.MethodGet {
+			writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+			return
+		}
+		resources, err := db.iacResources(name, kind)
+		if err != nil {
+			writeJSONError(w, http.StatusInternalServerError, "Failed to fetch resources")
+			return
+		}
+		json.NewEncoder(w).Encode(resources)
+		return
+	}
+	if !iacExternalID.MatchString(externalID) {
+		writeJSONError(w, http.StatusBadRequest, "externalId must be 1-128 letters, digits, '.', '_' or '-'")
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		res, err := db.iacResource(name, kind, externalID)
+		if errors.Is(err, sql.ErrNoRows) {
+			writeJSONError(w, http.StatusNotFound, "Resource not found")
+			return
+		}
+		if err != nil {
+			writeJSONError(w, http.StatusInternalServerError, "Failed to fetch resource")
+			return
+		}
+		if inm := r.Header.Get("If-None-Match"); inm != "" && etagListContains(inm, res.ETag) {
+			w.Header().Set("ETag", res.ETag)
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		writeIaCResource(w, http.StatusOK, res)
+	case http.MethodPut:
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, "Invalid body")
+			return
+		}
+		spec, err := kind.decode(body)
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		res, created, err := db.PutIaCResource(name, kind, externalID, spec, r.Header.Get("If-Match"), r.Header.Get("If-None-Match"))
+		if errors.Is(err, errIaCPrecondition) {
+			writeJSONError(w, http.StatusPreconditionFailed, "Resource does not match the given precondition")
+			return
+		}
+		if err != nil {
+			log.Printf("iac: put %s/%s: %v", name, externalID, err)
+			writeJSONError(w, http.StatusInternalServerError, "Failed to save resource")
+			return
+		}
+		status := http.StatusOK
+		if created {
+			status = http.StatusCreated
+		}
+		writeIaCResource(w, status, res)
+	case http.MethodDelete:
+		err := db.DeleteIaCResource(name, kind, externalID, r.Header.Get("If-Match"))
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			writeJSONError(w, http.StatusNotFound, "Resource not found")
+		case errors.Is(err, errIaCPrecondition):
+			writeJSONError(w, http.StatusPreconditionFailed, "Resource does not match the given precondition")
+		case err != nil:
+			log.Printf("iac: delete %s/%s: %v", name, externalID, err)
+			writeJSONError(w, http.StatusInternalServerError, "Failed to delete resource")
+		default:
+			w.WriteHeader(http.StatusNoContent)
+		}
+	default:
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+	}
+}
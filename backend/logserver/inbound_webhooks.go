@@ -0,0 +1,345 @@
+package logserver
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Inbound webhook signature schemes
+const (
+	providerGitHub = "github" // X-Hub-Signature-256 (or legacy X-Hub-Signature), HMAC of the body
+	providerStripe = "stripe" // Stripe-Signature: t=<unix>,v1=<HMAC of "t.body">
+	providerOkta   = "okta"   // a shared secret sent verbatim in Header (Authorization by default)
+	providerHMAC   = "hmac"   // hex HMAC-SHA256 of the body in Header (X-Signature by default), optionally "sha256=" prefixed
+	providerNone   = "none"   // unsigned; must be chosen explicitly
+)
+
+// stripeTolerance is how old a Stripe-style signature timestamp may be
+const stripeTolerance = 5 * time.Minute
+
+// inboundWebhookMaxBytes caps an inbound payload; inboundDescriptionLimit
+// caps how much of it is kept as the log description
+const (
+	inboundWebhookMaxBytes  = 1 << 20
+	inboundDescriptionLimit = 4096
+)
+
+// InboundWebhook configures POST /api/ingest/webhooks/{name}, which stores
+// each verified payload as a log
+type InboundWebhook struct {
+	ID       int64  `json:"id"`
+	Name     string `json:"name"`
+	Provider string `json:"provider"`
+	Secret   string `json:"secret,omitempty"`
+	// Header overrides the header carrying the signature or shared secret
+	Header string `json:"header,omitempty"`
+	// Level is the level of the stored logs, INFO by default
+	Level     string    `json:"level,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+var errBadSignature = errors.New("missing or invalid signature")
+
+func createInboundWebhookTables(db *sql.DB) error {
+	_, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS inbound_webhooks (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			name TEXT NOT NULL UNIQUE,
+			provider TEXT NOT NULL,
+			secret TEXT NOT NULL,
+			header TEXT NOT NULL DEFAULT '',
+			level TEXT NOT NULL DEFAULT '',
+			created_at DATETIME NOT NULL
+		)
+	`)
+	return err
+}
+
+func (h *InboundWebhook) validate() error {
+	if !iacExternalID.MatchString(h.Name) {
+		return errors.New("name must be 1-128 letters, digits, '.', '_' or '-'")
+	}
+	switch h.Provider {
+	case providerGitHub, providerStripe, providerOkta, providerHMAC:
+		if h.Secret == "" {
+			return errors.New("secret is required for provider " + h.Provider)
+		}
+	case providerNone:
+	default:
+		return errors.New("provider must be github, stripe, okta, hmac or none")
+	}
+	h.Level = strings.ToUpper(h.Level)
+	return nil
+}
+
+func (d *Database) CreateInboundWebhook(h InboundWebhook) (InboundWebhook, error) {
+	h.CreatedAt = d.now().UTC()
+	res, err := d.db.Exec(`
+		INSERT INTO inbound_webhooks (name, provider, secret, header, level, created_at) VALUES (?, ?, ?, ?, ?, ?)
+	`, h.Name, h.Provider, h.Secret, h.Header, h.Level, h.CreatedAt)
+	if err != nil {
+		return h, err
+	}
+	h.ID, err = res.LastInsertId()
+	return h, err
+}
+
+func (d *Database) DeleteInboundWebhook(id int64) error {
+	_, err := d.db.Exec(`DELETE FROM inbound_webhooks WHERE id = ?`, id)
+	return err
+}
+
+const inboundWebhookColumns = `id, name, provider, secret, header, level, created_at`
+
+func scanInboundWebhook(scan func(dest ...interface{}) error) (InboundWebhook, error) {
+	var h InboundWebhook
+	err := scan(&h.ID, &h.Name, &h.Provider, &h.Secret, &h.Header, &h.Level, &h.CreatedAt)
+	return h, err
+}
+
+func (d *Database) GetInboundWebhook(name string) (InboundWebhook, error) {
+	return scanInboundWebhook(d.db.QueryRow(`SELECT `+inboundWebhookColumns+` FROM inbound_webhooks WHERE name = ?`, name).Scan)
+}
+
+func (d *Database) GetInboundWebhooks() ([]InboundWebhook, error) {
+	rows, err := d.db.Query(`SELECT ` + inboundWebhookColumns + ` FROM inbound_webhooks ORDER BY name`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	hooks := []InboundWebhook{}
+	for rows.Next() {
+		h, err := scanInboundWebhook(rows.Scan)
+		if err != nil {
+			return nil, err
+		}
+		hooks = append(hooks, h)
+	}
+	return hooks, rows.Err()
+}
+
+// hexEqual compares a received hex signature with the expected one in constant time
+func hexEqual(got, want string) bool {
+	return subtle.ConstantTimeCompare([]byte(strings.ToLower(strings.TrimSpace(got))), []byte(want)) == 1
+}
+
+// verify checks the request's signature against the webhook's provider scheme
+func (h InboundWebhook) verify(r *http.Request, body []byte, now time.Time) error {
+	switch h.Provider {
+	case providerGitHub:
+		if sig := r.Header.Get("X-Hub-Signature-256"); sig != "" {
+			if strings.HasPrefix(sig, "sha256=") && hexEqual(sig[len("sha256="):], signPayload(h.Secret, body)) {
+				return nil
+			}
+			return errBadSignature
+		}
+		sig := r.Header.Get("X-Hub-Signature")
+		mac := hmac.New(sha1.New, []byte(h.Secret))
+		mac.Write(body)
+		if strings.HasPrefix(sig, "sha1=") && hexEqual(sig[len("sha1="):], hex.EncodeToString(mac.Sum(nil))) {
+			return nil
+		}
+		return errBadSignature
+	case providerStripe:
+		var timestamp string
+		var signatures []string
+		for _, part := range strings.Split(r.Header.Get("Stripe-Signature"), ",") {
+			key, value, _ := strings.Cut(strings.TrimSpace(part), "=")
+			switch key {
+			case "t":
+				timestamp = value
+			case "v1":
+				signatures = append(signatures, value)
+			}
+		}
+		unix, err := strconv.ParseInt(timestamp, 10, 64)
+		if err != nil {
+			return errBadSignature
+		}
+		if age := now.Sub(time.Unix(unix, 0)); age > stripeTolerance || age < -stripeTolerance {
+			return errors.New("signature timestamp is outside the tolerance")
+		}
+		want := signPayload(h.Secret, append([]byte(timestamp+"."), body...))
+		for _, sig := range signatures {
+			if hexEqual(sig, want) {
+				return nil
+			}
+		}
+		return errBadSignature
+	case providerOkta:
+		header := h.Header
+		if header == "" {
+			header = "Authorization"
+		}
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get(header)), []byte(h.Secret)) == 1 {
+			return nil
+		}
+		return errBadSignature
+	case providerHMAC:
+		header := h.Header
+		if header == "" {
+			header = "X-Signature"
+		}
+		if hexEqual(strings.TrimPrefix(r.Header.Get(header), "sha256="), signPayload(h.Secret, body)) {
+			return nil
+		}
+		return errBadSignature
+	case providerNone:
+		return nil
+	}
+	return errBadSignature
+}
+
+// webhookEventType names the event from the GitHub event header or a
+// top-level "type", "eventType" or "event" string in a JSON payload
+func webhookEventType(r *http.Request, body []byte) string {
+	if event := r.Header.Get("X-GitHub-Event"); event != "" {
+		return event
+	}
+	var payload map[string]interface{}
+	if json.Unmarshal(body, &payload) == nil {
+		for _, key := range []string{"type", "eventType", "event"} {
+			if s, ok := payload[key].(string); ok && s != "" {
+				return s
+			}
+		}
+	}
+	return "webhook"
+}
+
+// GET/POST/DELETE /api/webhooks/inbound - manage inbound webhook sources (admin only)
+func inboundWebhooksHandlerDB(w http.ResponseWriter, r *http.Request, db *Database) {
+	enableCORS(w)
+	w.Header().Set("Content-Type", "application/json")
+	if !db.requireAdmin(w, r) {
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		hooks, err := db.GetInboundWebhooks()
+		if err != nil {
+			writeJSONError(w, http.StatusInternalServerError, "Failed to fetch inbound webhooks")
+			return
+		}
+		// Secrets are write-only
+		for i := range hooks {
+			hooks[i].Secret = ""
+		}
+		json.NewEncoder(w).Encode(hooks)
+	case http.MethodPost:
+		var h InboundWebhook
+		if err := json.NewDecoder(r.Body).Decode(&h); err != nil {
+			writeJSONError(w, http.StatusBadRequest, "Invalid JSON")
+			return
+		}
+		if err := h.validate(); err != nil {
+			writeJSONError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		h, err := db.CreateInboundWebhook(h)
+		if err != nil {
+			writeJSONError(w, http.StatusConflict, "An inbound webhook with that name already exists")
+			return
+		}
+		h.Secret = ""
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(h)
+	case http.MethodDelete:
+		id, err := strconv.ParseInt(r.URL.Query().Get("id"), 10, 64)
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, "Invalid id")
+			return
+		}
+		if err := db.DeleteInboundWebhook(id); err != nil {
+			writeJSONError(w, http.StatusInternalServerError, "Failed to delete inbound webhook")
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+	}
+}
+
+// POST /api/ingest/webhooks/{name} - store a verified provider webhook as a log
+func inboundWebhookIngestHandlerDB(w http.ResponseWriter, r *http.Request, db *Database) {
+	enableCORS(w)
+	w.Header().Set("Content-Type", "application/json")
+	name := strings.TrimPrefix(r.URL.Path, "/api/ingest/webhooks/")
+	hook, err := db.GetInboundWebhook(name)
+	if errors.Is(err, sql.ErrNoRows) {
+		writeJSONError(w, http.StatusNotFound, "Unknown webhook")
+		return
+	}
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "Failed to load webhook")
+		return
+	}
+
+	// Okta verifies an event hook once with a GET carrying a challenge
+	if r.Method == http.MethodGet && hook.Provider == providerOkta {
+		challenge := r.Header.Get("X-Okta-Verification-Challenge")
+		if challenge == "" || hook.verify(r, nil, time.Now()) != nil {
+			writeJSONError(w, http.StatusUnauthorized, errBadSignature.Error())
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"verification": challenge})
+		return
+	}
+	if r.Method != http.MethodPost {
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, inboundWebhookMaxBytes+1))
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, "Invalid body")
+		return
+	}
+	if len(body) > inboundWebhookMaxBytes {
+		writeJSONError(w, http.StatusRequestEntityTooLarge, "Payload is too large")
+		return
+	}
+	if err := hook.verify(r, body, time.Now()); err != nil {
+		log.Printf("inbound webhook %s: rejected request from %s: %v", hook.Name, r.RemoteAddr, err)
+		writeJSONError(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+
+	entry := LogEntry{
+		Timestamp:   db.now(),
+		Level:       hook.Level,
+		Rule:        "webhook:" + hook.Name,
+		Event:       webhookEventType(r, body),
+		Description: string(body),
+	}
+	if storeRawPayloads {
+		entry.Raw, entry.RawFormat = body, rawWebhook
+	}
+	if entry.Level == "" {
+		entry.Level = "INFO"
+	}
+	if len(entry.Description) > inboundDescriptionLimit {
+		entry.Description = entry.Description[:inboundDescriptionLimit]
+	}
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		entry.SourceIP = host
+	}
+	if err := db.InsertLog(entry); err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "Failed to insert log")
+		return
+	}
+	w.WriteHeader(http.StatusCreated)
+	w.Write([]byte(`{"status":"ok"}`))
+}
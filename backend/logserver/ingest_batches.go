@@ -0,0 +1,343 @@
+package logserver
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Staged ingest batches. An agent stages a batch of logs under an ID of its
+// choosing, then commits it. Committing stores the logs exactly once: the
+// transaction storing each log also records how far the commit got, so a
+// commit retried after a network failure or a crash picks up where the
+// last one stopped, and committing or staging a committed batch again
+// changes nothing. Batches are kept, and their IDs remembered, for
+// ingestBatchRetention.
+
+// Ingest batch states
+const (
+	batchStaged     = "staged"
+	batchCommitting = "committing"
+	batchCommitted  = "committed"
+)
+
+// ingestBatchRetention is how long batch IDs are remembered; a batch
+// re-sent later than that is stored again
+var ingestBatchRetention = envDuration("INGEST_BATCH_RETENTION", 7*24*time.Hour)
+
+const ingestBatchPruneInterval = time.Hour
+
+var (
+	errBatchNotFound   = errors.New("Ingest batch not found")
+	errBatchIDTaken    = errors.New("Ingest batch ID is in use")
+	errBatchCommitting = errors.New("Ingest batch is partly committed; commit it again to finish")
+	errBatchCommitted  = errors.New("Ingest batch is already committed")
+)
+
+var batchIDPattern = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,128}$`)
+
+// IngestBatch is a staged or committed batch of logs. Stored counts the
+// logs a commit has got through; a batch left committing by an interrupted
+// commit is finished by committing it again.
+type IngestBatch struct {
+	ID          string     `json:"id"`
+	State       string     `json:"state"`
+	Logs        int        `json:"logs"`
+	Stored      int        `json:"stored"`
+	StagedAt    time.Time  `json:"stagedAt"`
+	CommittedAt *time.Time `json:"committedAt,omitempty"`
+	// AlreadyCommitted is set when a request found the batch committed and
+	// left it alone
+	AlreadyCommitted bool `json:"alreadyCommitted,omitempty"`
+
+	tokenID int64
+}
+
+// batchPosition marks a log published by a batch commit: the store
+// subscriber records seq as the batch's progress in the log's transaction
+// and sets recorded
+type batchPosition struct {
+	id       string
+	seq      int
+	recorded bool
+}
+
+func createIngestBatchTables(db *sql.DB) error {
+	for _, stmt := range []string{
+		`CREATE TABLE IF NOT EXISTS ingest_batches (
+			id TEXT PRIMARY KEY,
+			token_id INTEGER NOT NULL DEFAULT 0,
+			logs TEXT,
+			count INTEGER NOT NULL,
+			stored INTEGER NOT NULL DEFAULT 0,
+			staged_at DATETIME NOT NULL,
+			committed_at DATETIME
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_ingest_batches_staged_at ON ingest_batches(staged_at)`,
+	} {
+		if _, err := db.Exec(stmt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (b *IngestBatch) setState() {
+	switch {
+	case b.CommittedAt != nil:
+		b.State = batchCommitted
+	case b.Stored > 0:
+		b.State = batchCommitting
+	default:
+		b.State = batchStaged
+	}
+}
+
+// getIngestBatch returns the batch and, until it is committed, its logs. A
+// batch staged with another token is not found.
+func (d *Database) getIngestBatch(id string, tokenID int64) (IngestBatch, []byte, error) {
+	b := IngestBatch{ID: id}
+	var logs sql.NullString
+	var committed sql.NullTime
+	err := d.db.QueryRow(`SELECT token_id, logs, count, stored, staged_at, committed_at FROM ingest_batches WHERE id = ?`, id).
+		Scan(&b.tokenID, &logs, &b.Logs, &b.Stored, &b.StagedAt, &committed)
+	if errors.Is(err, sql.ErrNoRows) || err == nil && b.tokenID != tokenID {
+		return b, nil, errBatchNotFound
+	}
+	if err != nil {
+		return b, nil, err
+	}
+	if committed.Valid {
+		b.CommittedAt = &committed.Time
+	}
+	b.setState()
+	return b, []byte(logs.String), nil
+}
+
+// StageIngestBatch stages entries as batch id, replacing the logs of a batch
+// staged before under the same ID. It returns whether the batch is new.
+func (d *Database) StageIngestBatch(id string, token IngestToken, entries []LogEntry) (IngestBatch, bool, error) {
+	d.batchCommits.Lock()
+	defer d.batchCommits.Unlock()
+	b, _, err := d.getIngestBatch(id, token.ID)
+	switch {
+	case err == nil && b.State == batchCommitted:
+		b.AlreadyCommitted = true
+		return b, false, nil
+	case err == nil && b.State == batchCommitting:
+		return b, false, errBatchCommitting
+	case errors.Is(err, errBatchNotFound):
+		var taken bool
+		if err := d.db.QueryRow(`SELECT EXISTS (SELECT 1 FROM ingest_batches WHERE id = ?)`, id).Scan(&taken); err != nil {
+			return b, false, err
+		}
+		if taken {
+			return b, false, errBatchIDTaken
+		}
+	case err != nil:
+		return b, false, err
+	}
+	created := err != nil
+	logs, err := spoolLogs(entries)
+	if err != nil {
+		return b, false, err
+	}
+	b = IngestBatch{ID: id, State: batchStaged, Logs: len(entries), StagedAt: d.now().UTC()}
+	_, err = d.db.Exec(`
+		INSERT INTO ingest_batches (id, token_id, logs, count, staged_at) VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT(id) DO UPDATE SET logs = excluded.logs, count = excluded.count, staged_at = excluded.staged_at
+	`, id, token.ID, string(logs), b.Logs, b.StagedAt)
+	return b, created, err
+}
+
+// CommitIngestBatch stores the logs of batch id that are not stored yet.
+// The token's quota is charged when the commit starts.
+func (d *Database) CommitIngestBatch(id string, token IngestToken, now time.Time) (IngestBatch, error) {
+	d.batchCommits.Lock()
+	defer d.batchCommits.Unlock()
+	b, data, err := d.getIngestBatch(id, token.ID)
+	if err != nil {
+		return b, err
+	}
+	if b.State == batchCommitted {
+		b.AlreadyCommitted = true
+		return b, nil
+	}
+	entries, err := unspoolLogs(data)
+	if err != nil {
+		return b, err
+	}
+	if b.Stored == 0 {
+		if err := d.admitIngest(token, len(entries), now); err != nil {
+			return b, err
+		}
+	}
+	for i := b.Stored; i < len(entries); i++ {
+		pos := &batchPosition{id: id, seq: i + 1}
+		if err := d.publishLog(entries[i], pos); err != nil {
+			return b, err
+		}
+		// A log its route or a processor kept out of the store has no
+		// transaction to record it
+		if !pos.recorded {
+			if _, err := d.db.Exec(`UPDATE ingest_batches SET stored = ? WHERE id = ?`, pos.seq, id); err != nil {
+				return b, err
+			}
+		}
+		b.Stored = pos.seq
+	}
+	committed := d.now().UTC()
+	if _, err := d.db.Exec(`UPDATE ingest_batches SET logs = NULL, committed_at = ? WHERE id = ?`, committed, id); err != nil {
+		return b, err
+	}
+	b.CommittedAt = &committed
+	b.setState()
+	return b, nil
+}
+
+// DeleteIngestBatch drops a staged batch; one a commit has started on is kept
+func (d *Database) DeleteIngestBatch(id string, tokenID int64) error {
+	d.batchCommits.Lock()
+	defer d.batchCommits.Unlock()
+	b, _, err := d.getIngestBatch(id, tokenID)
+	if err != nil {
+		return err
+	}
+	switch b.State {
+	case batchCommitting:
+		return errBatchCommitting
+	case batchCommitted:
+		return errBatchCommitted
+	}
+	_, err = d.db.Exec(`DELETE FROM ingest_batches WHERE id = ?`, id)
+	return err
+}
+
+// pruneIngestBatchesLoop forgets batches staged longer ago than
+// ingestBatchRetention
+func (d *Database) pruneIngestBatchesLoop() {
+	ticker := time.NewTicker(ingestBatchPruneInterval)
+	defer ticker.Stop()
+	for {
+		d.batchCommits.Lock()
+		_, err := d.db.Exec(`DELETE FROM ingest_batches WHERE staged_at < ?`, d.now().UTC().Add(-ingestBatchRetention))
+		d.batchCommits.Unlock()
+		if err != nil {
+			log.Printf("ingest batches: failed to prune: %v", err)
+		}
+		select {
+		case <-ticker.C:
+		case <-d.done:
+			return
+		}
+	}
+}
+
+// POST /api/ingest/batches/{id} - stage a batch of logs, in any body
+// POST /api/logs takes
+// POST /api/ingest/batches/{id}/commit - store a staged batch exactly once
+// GET/DELETE /api/ingest/batches/{id} - check or drop a batch
+func ingestBatchHandlerDB(w http.ResponseWriter, r *http.Request, db *Database) {
+	enableCORS(w)
+	w.Header().Set("Content-Type", "application/json")
+	id, action, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/api/ingest/batches/"), "/")
+	if !batchIDPattern.MatchString(id) || action != "" && action != "commit" {
+		writeJSONError(w, http.StatusNotFound, "Not found")
+		return
+	}
+	if r.Method != http.MethodPost && (action != "" || r.Method != http.MethodGet && r.Method != http.MethodDelete) {
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	var buf bytes.Buffer
+	if err := readIngestBody(w, r, &buf); err != nil {
+		status, message := bodyReadStatus(err)
+		writeJSONError(w, status, message)
+		return
+	}
+	body := buf.Bytes()
+	now := db.now()
+	token, err := db.authenticateIngest(r, body, now)
+	if err != nil {
+		status := http.StatusUnauthorized
+		if errors.Is(err, errReplay) {
+			status = http.StatusConflict
+		}
+		writeJSONError(w, status, err.Error())
+		return
+	}
+
+	var batch IngestBatch
+	status := http.StatusOK
+	switch {
+	case r.Method == http.MethodGet:
+		batch, _, err = db.getIngestBatch(id, token.ID)
+	case r.Method == http.MethodDelete:
+		if err = db.DeleteIngestBatch(id, token.ID); err == nil {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+	case action == "commit":
+		batch, err = db.CommitIngestBatch(id, token, now)
+	default:
+		if encoding := ingestEncoding(r); encoding != "" {
+			if body, err = decompressIngestBody(encoding, body, new(bytes.Buffer)); err != nil {
+				writeJSONError(w, decompressionStatus(err), err.Error())
+				return
+			}
+		}
+		var entries []LogEntry
+		if entries, err = decodeIngestBody(r.Header.Get("Content-Type"), body, nil); err != nil {
+			writeJSONError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		for i := range entries {
+			if err := prepareLogEntry(&entries[i], now); err != nil {
+				writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("log %d: %v", i, err))
+				return
+			}
+		}
+		var created bool
+		if batch, created, err = db.StageIngestBatch(id, token, entries); created {
+			status = http.StatusCreated
+		}
+	}
+	switch {
+	case errors.Is(err, errBatchNotFound):
+		writeJSONError(w, http.StatusNotFound, err.Error())
+		return
+	case errors.Is(err, errBatchIDTaken), errors.Is(err, errBatchCommitting), errors.Is(err, errBatchCommitted):
+		writeJSONError(w, http.StatusConflict, err.Error())
+		return
+	case errors.Is(err, errQuotaExceeded):
+		w.Header().Set("Retry-After", strconv.Itoa(int(untilNextQuotaDay(now).Seconds())+1))
+		writeJSONError(w, http.StatusTooManyRequests, err.Error())
+		return
+	case err != nil:
+		log.Printf("ingest batches: %s %s: %v", r.Method, r.URL.Path, err)
+		writeJSONError(w, http.StatusInternalServerError, "Failed to "+ingestBatchAction(r.Method, action)+" ingest batch")
+		return
+	}
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(batch)
+}
+
+func ingestBatchAction(method, action string) string {
+	switch {
+	case method == http.MethodGet:
+		return "fetch"
+	case method == http.MethodDelete:
+		return "delete"
+	case action == "commit":
+		return "commit"
+	}
+	return "stage"
+}
@@ -0,0 +1,353 @@
+package logserver
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Ingest acknowledgment. A client picks, per request, when POST /api/logs
+// answers with the X-Ingest-Ack header:
+//
+//	durable  after the logs are committed to the database (the default): 201
+//	queued   once the logs are appended to the ingest WAL: 202
+//
+// Queued logs are stored in order by one writer. The WAL is a directory of
+// segment files next to the database, fsynced every ingestWALSyncInterval,
+// and whatever it still holds is stored on the next start. Delivery is
+// at least once: logs stored just before a crash are stored again. The
+// response's X-Ingest-Ack header names the mode the request got.
+
+// Ingest acknowledgment modes
+const (
+	ingestAckDurable = "durable"
+	ingestAckQueued  = "queued"
+)
+
+var (
+	// ingestQueueSize is how many queued requests may wait for the writer
+	ingestQueueSize = envInt("INGEST_QUEUE_SIZE", 1024)
+	// ingestWALSegmentSize is the size at which a new WAL segment is started
+	ingestWALSegmentSize = int64(envInt("INGEST_WAL_SEGMENT_BYTES", 64<<20))
+)
+
+const ingestWALSyncInterval = time.Second
+
+// errIngestQueueFull rejects a queued request while the writer is behind
+var errIngestQueueFull = errors.New("ingest queue is full")
+
+// spooledLog is a LogEntry with its raw payload, as the WAL and staged
+// ingest batches keep it
+type spooledLog struct {
+	LogEntry
+	Raw       []byte `json:"raw,omitempty"`
+	RawFormat string `json:"rawFormat,omitempty"`
+}
+
+func spoolLogs(entries []LogEntry) ([]byte, error) {
+	spooled := make([]spooledLog, len(entries))
+	for i, e := range entries {
+		spooled[i] = spooledLog{LogEntry: e, Raw: e.Raw, RawFormat: e.RawFormat}
+	}
+	return json.Marshal(spooled)
+}
+
+func unspoolLogs(data []byte) ([]LogEntry, error) {
+	var spooled []spooledLog
+	if err := json.Unmarshal(data, &spooled); err != nil {
+		return nil, err
+	}
+	entries := make([]LogEntry, len(spooled))
+	for i, s := range spooled {
+		entries[i] = s.LogEntry
+		entries[i].Raw, entries[i].RawFormat = s.Raw, s.RawFormat
+	}
+	return entries, nil
+}
+
+// queuedBatch is one queued request's logs and the WAL segment holding them
+type queuedBatch struct {
+	entries []LogEntry
+	segment int64
+}
+
+// ingestQueue holds the queued batches and the WAL they were written to.
+// pending counts each segment's batches not yet stored; a segment is
+// removed, or truncated if it is the current one, once none are left.
+type ingestQueue struct {
+	dir     string
+	batches chan queuedBatch
+	stopped chan struct{}
+
+	mu      sync.Mutex
+	closed  bool
+	segment int64
+	file    *os.File
+	size    int64
+	dirty   bool
+	pending map[int64]int
+
+	queued atomic.Int64
+	failed atomic.Int64
+}
+
+func newIngestQueue(dbPath string) *ingestQueue {
+	return &ingestQueue{
+		dir:     dbPath + ".ingest-wal",
+		batches: make(chan queuedBatch, ingestQueueSize),
+		stopped: make(chan struct{}),
+		pending: make(map[int64]int),
+	}
+}
+
+// ingestAckMode reads the X-Ingest-Ack request header
+func ingestAckMode(header string) (string, error) {
+	switch mode := strings.ToLower(strings.TrimSpace(header)); mode {
+	case "", ingestAckDurable:
+		return ingestAckDurable, nil
+	case ingestAckQueued:
+		return mode, nil
+	}
+	return "", fmt.Errorf("X-Ingest-Ack must be %q or %q", ingestAckDurable, ingestAckQueued)
+}
+
+func (q *ingestQueue) segmentPath(segment int64) string {
+	return filepath.Join(q.dir, fmt.Sprintf("%016d.wal", segment))
+}
+
+// segments lists the WAL's segment numbers in order
+func (q *ingestQueue) segments() ([]int64, error) {
+	paths, err := filepath.Glob(filepath.Join(q.dir, "*.wal"))
+	if err != nil {
+		return nil, err
+	}
+	var segments []int64
+	for _, path := range paths {
+		if n, err := strconv.ParseInt(strings.TrimSuffix(filepath.Base(path), ".wal"), 10, 64); err == nil {
+			segments = append(segments, n)
+		}
+	}
+	sort.Slice(segments, func(i, j int) bool { return segments[i] < segments[j] })
+	return segments, nil
+}
+
+// enqueue writes entries to the WAL and hands them to the writer
+func (q *ingestQueue) enqueue(entries []LogEntry) error {
+	line, err := spoolLogs(entries)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed {
+		return errors.New("ingest queue is closed")
+	}
+	if len(q.batches) == cap(q.batches) {
+		return errIngestQueueFull
+	}
+	if q.file == nil || q.size >= ingestWALSegmentSize {
+		if err := q.rotate(); err != nil {
+			return err
+		}
+	}
+	n, err := q.file.Write(line)
+	q.size += int64(n)
+	q.dirty = true
+	if err != nil {
+		// The partial line is skipped on replay
+		return err
+	}
+	// Entries reuse the request's pooled batch, so the writer gets copies
+	copied := make([]LogEntry, len(entries))
+	for i, e := range entries {
+		copied[i] = e
+		copied[i].Raw = append([]byte(nil), e.Raw...)
+		copied[i].Tags = append([]string(nil), e.Tags...)
+	}
+	q.pending[q.segment]++
+	q.queued.Add(int64(len(entries)))
+	q.batches <- queuedBatch{entries: copied, segment: q.segment}
+	return nil
+}
+
+// rotate starts the next segment; q.mu is held
+func (q *ingestQueue) rotate() error {
+	if q.file != nil {
+		if err := q.file.Close(); err != nil {
+			return err
+		}
+		if q.pending[q.segment] == 0 {
+			os.Remove(q.segmentPath(q.segment))
+		}
+	}
+	if err := os.MkdirAll(q.dir, 0o755); err != nil {
+		return err
+	}
+	segments, err := q.segments()
+	if err != nil {
+		return err
+	}
+	if len(segments) > 0 && segments[len(segments)-1] >= q.segment {
+		q.segment = segments[len(segments)-1]
+	}
+	q.segment++
+	file, err := os.OpenFile(q.segmentPath(q.segment), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		q.file = nil
+		return err
+	}
+	q.file, q.size = file, 0
+	return nil
+}
+
+// stored marks a batch of segment as stored, dropping the segment's WAL
+// lines once all of its batches are
+func (q *ingestQueue) stored(segment int64, logs int) {
+	q.queued.Add(-int64(logs))
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.pending[segment]--; q.pending[segment] > 0 {
+		return
+	}
+	delete(q.pending, segment)
+	if segment != q.segment || q.file == nil {
+		os.Remove(q.segmentPath(segment))
+		return
+	}
+	if err := q.file.Truncate(0); err != nil {
+		log.Printf("ingest queue: failed to truncate WAL: %v", err)
+		return
+	}
+	q.size, q.dirty = 0, false
+}
+
+// sync flushes the current segment to disk
+func (q *ingestQueue) sync() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.file != nil && q.dirty {
+		if err := q.file.Sync(); err != nil {
+			log.Printf("ingest queue: failed to sync WAL: %v", err)
+		}
+		q.dirty = false
+	}
+}
+
+// ingestQueueLoop stores queued batches in order until the queue is closed
+// and drained
+func (d *Database) ingestQueueLoop() {
+	q := d.ingestQueue
+	defer close(q.stopped)
+	ticker := time.NewTicker(ingestWALSyncInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case batch, ok := <-q.batches:
+			if !ok {
+				return
+			}
+			for _, entry := range batch.entries {
+				if err := d.InsertLog(entry); err != nil {
+					q.failed.Add(1)
+					log.Printf("ingest queue: failed to store log: %v", err)
+				}
+			}
+			q.stored(batch.segment, len(batch.entries))
+		case <-ticker.C:
+			q.sync()
+		}
+	}
+}
+
+// replayIngestWAL stores the logs a previous run queued but did not store
+func (d *Database) replayIngestWAL() error {
+	q := d.ingestQueue
+	segments, err := q.segments()
+	if err != nil {
+		return err
+	}
+	for _, segment := range segments {
+		stored, err := d.replayIngestSegment(q.segmentPath(segment))
+		if err != nil {
+			return err
+		}
+		if stored > 0 {
+			log.Printf("ingest queue: stored %d logs left in WAL segment %d", stored, segment)
+		}
+		if err := os.Remove(q.segmentPath(segment)); err != nil {
+			return err
+		}
+		q.segment = segment
+	}
+	return nil
+}
+
+func (d *Database) replayIngestSegment(path string) (int, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	stored := 0
+	reader := bufio.NewReader(f)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if len(line) > 0 && line[len(line)-1] == '\n' {
+			batch, jerr := unspoolLogs(line)
+			if jerr != nil {
+				log.Printf("ingest queue: skipping unreadable WAL line in %s: %v", path, jerr)
+			}
+			for _, entry := range batch {
+				if err := d.InsertLog(entry); err != nil {
+					log.Printf("ingest queue: failed to store log from WAL: %v", err)
+					continue
+				}
+				stored++
+			}
+		} else if len(line) > 0 {
+			// A write the crash interrupted; the request was not acknowledged
+			log.Printf("ingest queue: skipping incomplete WAL line in %s", path)
+		}
+		if err != nil {
+			break
+		}
+	}
+	return stored, nil
+}
+
+// closeIngestQueue stops accepting queued requests, waits for the writer to
+// store what is queued and removes the WAL
+func (d *Database) closeIngestQueue() {
+	q := d.ingestQueue
+	q.mu.Lock()
+	if q.closed {
+		q.mu.Unlock()
+		return
+	}
+	q.closed = true
+	close(q.batches)
+	q.mu.Unlock()
+	<-q.stopped
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.file != nil {
+		q.file.Close()
+		q.file = nil
+	}
+	if len(q.pending) == 0 {
+		os.RemoveAll(q.dir)
+	}
+}
@@ -0,0 +1,72 @@
+package logserver
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+)
+
+// Unix socket ingest. A logger running as a node-local daemon can take logs
+// from co-located applications on a Unix socket, without TCP, serving the
+// same ingest endpoints as the API. Who may connect is decided by the
+// socket file's permissions, so INGEST_TOKENS_REQUIRED does not apply on
+// the socket; a token that is sent must still be valid.
+
+var (
+	// ingestSocketPath is where Server listens when Config.SocketPath is not
+	// set; empty turns the socket off
+	ingestSocketPath = os.Getenv("INGEST_SOCKET")
+	// ingestSocketMode is the socket file's permissions, in octal
+	ingestSocketMode = envOr("INGEST_SOCKET_MODE", "0660")
+)
+
+// ingestSocketKey marks the context of requests that came in on the socket
+type ingestSocketKey struct{}
+
+// fromIngestSocket reports whether r came in on the ingest socket
+func fromIngestSocket(r *http.Request) bool {
+	on, _ := r.Context().Value(ingestSocketKey{}).(bool)
+	return on
+}
+
+// listenIngestSocket listens on a Unix socket at path with ingestSocketMode
+// permissions, replacing a socket a previous run left behind
+func listenIngestSocket(path string) (net.Listener, error) {
+	mode, err := strconv.ParseUint(ingestSocketMode, 8, 32)
+	if err != nil || mode > 0o777 {
+		return nil, fmt.Errorf("INGEST_SOCKET_MODE must be octal permissions such as 0660, not %q", ingestSocketMode)
+	}
+	if info, err := os.Lstat(path); err == nil {
+		if info.Mode()&os.ModeSocket == 0 {
+			return nil, fmt.Errorf("%s exists and is not a socket", path)
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, err
+		}
+	}
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, os.FileMode(mode)); err != nil {
+		listener.Close()
+		return nil, err
+	}
+	return listener, nil
+}
+
+// newIngestSocketServer serves the ingest endpoints on the socket
+func newIngestSocketServer(db *Database) *http.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/logs", func(w http.ResponseWriter, r *http.Request) { logIngestHandlerDB(w, r, db) })
+	mux.HandleFunc("/api/ingest/batches/", func(w http.ResponseWriter, r *http.Request) { ingestBatchHandlerDB(w, r, db) })
+	return &http.Server{
+		Handler: db.trackUsage(mux, mux),
+		BaseContext: func(net.Listener) context.Context {
+			return context.WithValue(context.Background(), ingestSocketKey{}, true)
+		},
+	}
+}
@@ -0,0 +1,350 @@
+package logserver
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// IngestToken authenticates log producers on POST /api/logs. Like API keys
+// the secret is shown once and only its hash is stored. With
+// ReplayProtection each request must also carry a fresh timestamp and
+// nonce, signed together with the body. DailyQuota, when set, caps the logs
+// the token may send per UTC day; see quotas.go.
+type IngestToken struct {
+	ID               int64     `json:"id"`
+	Name             string    `json:"name"`
+	Prefix           string    `json:"prefix"`
+	ReplayProtection bool      `json:"replayProtection"`
+	ReplayWindow     int       `json:"replayWindowSeconds,omitempty"`
+	DailyQuota       int64     `json:"dailyQuota,omitempty"`
+	UsedToday        int64     `json:"usedToday,omitempty"`
+	CreatedAt        time.Time `json:"createdAt"`
+}
+
+const (
+	ingestTokenPrefix = "it_"
+	// defaultReplayWindow is how far a request timestamp may be from the
+	// server's clock; nonces are remembered for twice as long
+	defaultReplayWindow = 300
+	maxNonceLength      = 128
+)
+
+// ingestTokensRequired rejects ingest without a token; otherwise a token is
+// optional, but must be valid when sent
+var ingestTokensRequired = os.Getenv("INGEST_TOKENS_REQUIRED") == "true"
+
+var (
+	errIngestToken = errors.New("missing or invalid ingest token")
+	errReplay      = errors.New("replayed request")
+)
+
+// ingestTokenCache holds the tokens and their accepted secret hashes so
+// ingest does not query them per request
+type ingestTokenCache struct {
+	mu       sync.RWMutex
+	byID     map[int64]IngestToken
+	secrets  map[string]credentialSecret
+	prunedAt time.Time
+}
+
+func createIngestTokenTables(db *sql.DB) error {
+	for _, stmt := range []string{
+		`CREATE TABLE IF NOT EXISTS ingest_tokens (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			name TEXT NOT NULL,
+			prefix TEXT NOT NULL,
+			token_hash TEXT NOT NULL UNIQUE,
+			replay_protection BOOLEAN NOT NULL DEFAULT 0,
+			replay_window_seconds INTEGER NOT NULL DEFAULT 0,
+			created_at DATETIME NOT NULL
+		)`,
+		`CREATE TABLE IF NOT EXISTS ingest_nonces (
+			token_id INTEGER NOT NULL,
+			nonce TEXT NOT NULL,
+			seen_at DATETIME NOT NULL,
+			PRIMARY KEY (token_id, nonce)
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_ingest_nonces_seen_at ON ingest_nonces(seen_at)`,
+	} {
+		if _, err := db.Exec(stmt); err != nil {
+			return err
+		}
+	}
+	if err := addColumnIfMissing(db, "ingest_tokens", "daily_quota", `INTEGER NOT NULL DEFAULT 0`); err != nil {
+		return err
+	}
+	return addCredentialColumns(db, "ingest_tokens")
+}
+
+func (t *IngestToken) validate() error {
+	t.Name = strings.TrimSpace(t.Name)
+	if t.Name == "" {
+		return errors.New("name is required")
+	}
+	if t.ReplayWindow < 0 {
+		return errors.New("replayWindowSeconds must not be negative")
+	}
+	if t.DailyQuota < 0 {
+		return errors.New("dailyQuota must not be negative")
+	}
+	if t.ReplayWindow == 0 && t.ReplayProtection {
+		t.ReplayWindow = defaultReplayWindow
+	}
+	return nil
+}
+
+func (d *Database) loadIngestTokens() error {
+	rows, err := d.db.Query(`SELECT id, name, prefix, replay_protection, replay_window_seconds, daily_quota, created_at FROM ingest_tokens`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	byID := make(map[int64]IngestToken)
+	for rows.Next() {
+		var t IngestToken
+		if err := rows.Scan(&t.ID, &t.Name, &t.Prefix, &t.ReplayProtection, &t.ReplayWindow, &t.DailyQuota, &t.CreatedAt); err != nil {
+			return err
+		}
+		byID[t.ID] = t
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	secrets, err := d.loadCredentialSecrets("ingest_tokens", "token_hash")
+	if err != nil {
+		return err
+	}
+	d.ingestTokens.mu.Lock()
+	d.ingestTokens.byID, d.ingestTokens.secrets = byID, secrets
+	d.ingestTokens.mu.Unlock()
+	return nil
+}
+
+// CreateIngestToken stores a new token and returns it along with its secret
+func (d *Database) CreateIngestToken(t IngestToken) (IngestToken, string, error) {
+	secret := ingestTokenPrefix + randomHex(apiKeyBytes)
+	t.Prefix = secret[:len(ingestTokenPrefix)+apiKeyShownPrefix]
+	t.CreatedAt = d.now().UTC()
+	res, err := d.db.Exec(`
+		INSERT INTO ingest_tokens (name, prefix, token_hash, replay_protection, replay_window_seconds, daily_quota, created_at) VALUES (?, ?, ?, ?, ?, ?, ?)
+	`, t.Name, t.Prefix, hashAPIKey(secret), t.ReplayProtection, t.ReplayWindow, t.DailyQuota, t.CreatedAt)
+	if err != nil {
+		return t, "", err
+	}
+	if t.ID, err = res.LastInsertId(); err != nil {
+		return t, "", err
+	}
+	return t, secret, d.loadIngestTokens()
+}
+
+// UpdateIngestToken changes a token's name, replay settings and quota
+func (d *Database) UpdateIngestToken(t IngestToken) (IngestToken, error) {
+	res, err := d.db.Exec(`
+		UPDATE ingest_tokens SET name = ?, replay_protection = ?, replay_window_seconds = ?, daily_quota = ? WHERE id = ?
+	`, t.Name, t.ReplayProtection, t.ReplayWindow, t.DailyQuota, t.ID)
+	if err != nil {
+		return t, err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return t, sql.ErrNoRows
+	}
+	if err := d.loadIngestTokens(); err != nil {
+		return t, err
+	}
+	d.ingestTokens.mu.RLock()
+	t = d.ingestTokens.byID[t.ID]
+	d.ingestTokens.mu.RUnlock()
+	t.UsedToday = d.quotaUsedToday(t.ID, d.now())
+	return t, nil
+}
+
+func (d *Database) DeleteIngestToken(id int64) error {
+	if _, err := d.db.Exec(`DELETE FROM ingest_tokens WHERE id = ?`, id); err != nil {
+		return err
+	}
+	if _, err := d.db.Exec(`DELETE FROM ingest_nonces WHERE token_id = ?`, id); err != nil {
+		return err
+	}
+	return d.loadIngestTokens()
+}
+
+func (d *Database) GetIngestTokens() []IngestToken {
+	d.ingestTokens.mu.RLock()
+	defer d.ingestTokens.mu.RUnlock()
+	tokens := []IngestToken{}
+	now := d.now()
+	for _, t := range d.ingestTokens.byID {
+		t.UsedToday = d.quotaUsedToday(t.ID, now)
+		tokens = append(tokens, t)
+	}
+	sort.Slice(tokens, func(i, j int) bool { return tokens[i].ID < tokens[j].ID })
+	return tokens
+}
+
+// authenticateIngest checks the request's ingest token and, for tokens with
+// replay protection, its timestamp, nonce and signature. It returns a zero
+// token when no token was sent and none is required.
+func (d *Database) authenticateIngest(r *http.Request, body []byte, now time.Time) (IngestToken, error) {
+	token, secret, err := d.ingestCredential(r, now)
+	if err != nil || !token.ReplayProtection {
+		return token, err
+	}
+	return token, d.checkReplay(token, secret, r, body, now)
+}
+
+// ingestCredential checks the request's ingest token, but not the replay
+// headers of a token with replay protection, which need the whole body
+func (d *Database) ingestCredential(r *http.Request, now time.Time) (IngestToken, string, error) {
+	secret := r.Header.Get("X-Ingest-Token")
+	// Other bearer credentials are left alone so existing clients keep working
+	if bearer := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer "); secret == "" && strings.HasPrefix(bearer, ingestTokenPrefix) {
+		secret = bearer
+	}
+	if secret == "" {
+		if ingestTokensRequired && !fromIngestSocket(r) {
+			return IngestToken{}, "", errIngestToken
+		}
+		return IngestToken{}, "", nil
+	}
+	d.ingestTokens.mu.RLock()
+	stored, ok := d.ingestTokens.secrets[hashAPIKey(secret)]
+	token := d.ingestTokens.byID[stored.id]
+	d.ingestTokens.mu.RUnlock()
+	if !ok || !stored.validAt(now) {
+		return IngestToken{}, "", errIngestToken
+	}
+	d.recordCredentialUse("ingest_tokens", token.ID, now)
+	return token, secret, nil
+}
+
+// checkReplay accepts a request whose X-Ingest-Timestamp is within the
+// token's window, whose X-Ingest-Nonce has not been seen, and whose
+// X-Ingest-Signature is the HMAC-SHA256, keyed with the token, of
+// "<timestamp>.<nonce>.<body>"
+func (d *Database) checkReplay(token IngestToken, secret string, r *http.Request, body []byte, now time.Time) error {
+	timestamp := r.Header.Get("X-Ingest-Timestamp")
+	nonce := r.Header.Get("X-Ingest-Nonce")
+	unix, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil || nonce == "" || len(nonce) > maxNonceLength {
+		return errors.New("X-Ingest-Timestamp and X-Ingest-Nonce are required")
+	}
+	window := time.Duration(token.ReplayWindow) * time.Second
+	if age := now.Sub(time.Unix(unix, 0)); age > window || age < -window {
+		return errors.New("X-Ingest-Timestamp is outside the replay window")
+	}
+	signed := append([]byte(timestamp+"."+nonce+"."), body...)
+	if !hexEqual(strings.TrimPrefix(r.Header.Get("X-Ingest-Signature"), "sha256="), signPayload(secret, signed)) {
+		return errors.New("invalid X-Ingest-Signature")
+	}
+
+	d.pruneNonces(now)
+	res, err := d.db.Exec(`INSERT OR IGNORE INTO ingest_nonces (token_id, nonce, seen_at) VALUES (?, ?, ?)`, token.ID, nonce, now.UTC())
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return errReplay
+	}
+	return nil
+}
+
+// pruneNonces forgets nonces that are older than any token's window allows
+// to be replayed, at most once a minute
+func (d *Database) pruneNonces(now time.Time) {
+	d.ingestTokens.mu.Lock()
+	if now.Sub(d.ingestTokens.prunedAt) < time.Minute {
+		d.ingestTokens.mu.Unlock()
+		return
+	}
+	d.ingestTokens.prunedAt = now
+	maxWindow := defaultReplayWindow
+	for _, t := range d.ingestTokens.byID {
+		if t.ReplayWindow > maxWindow {
+			maxWindow = t.ReplayWindow
+		}
+	}
+	d.ingestTokens.mu.Unlock()
+	// A timestamp may be up to a window in the future, so keep two windows
+	cutoff := now.Add(-2 * time.Duration(maxWindow) * time.Second).UTC()
+	d.db.Exec(`DELETE FROM ingest_nonces WHERE seen_at < ?`, cutoff)
+}
+
+// GET/POST/DELETE /api/ingest/tokens - manage ingest tokens (admin only)
+// PUT /api/ingest/tokens?id= - change a token's name, replay settings or quota
+func ingestTokensHandlerDB(w http.ResponseWriter, r *http.Request, db *Database) {
+	enableCORS(w)
+	w.Header().Set("Content-Type", "application/json")
+	if !db.requireAdmin(w, r) {
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		json.NewEncoder(w).Encode(db.GetIngestTokens())
+	case http.MethodPost:
+		var t IngestToken
+		if err := json.NewDecoder(r.Body).Decode(&t); err != nil {
+			writeJSONError(w, http.StatusBadRequest, "Invalid JSON")
+			return
+		}
+		if err := t.validate(); err != nil {
+			writeJSONError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		t, secret, err := db.CreateIngestToken(t)
+		if err != nil {
+			writeJSONError(w, http.StatusInternalServerError, "Failed to create ingest token")
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(struct {
+			IngestToken
+			Token string `json:"token"`
+		}{t, secret})
+	case http.MethodPut:
+		id, err := strconv.ParseInt(r.URL.Query().Get("id"), 10, 64)
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, "Invalid id")
+			return
+		}
+		var t IngestToken
+		if err := json.NewDecoder(r.Body).Decode(&t); err != nil {
+			writeJSONError(w, http.StatusBadRequest, "Invalid JSON")
+			return
+		}
+		if err := t.validate(); err != nil {
+			writeJSONError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		t.ID = id
+		t, err = db.UpdateIngestToken(t)
+		if errors.Is(err, sql.ErrNoRows) {
+			writeJSONError(w, http.StatusNotFound, "Ingest token not found")
+			return
+		} else if err != nil {
+			writeJSONError(w, http.StatusInternalServerError, "Failed to update ingest token")
+			return
+		}
+		json.NewEncoder(w).Encode(t)
+	case http.MethodDelete:
+		id, err := strconv.ParseInt(r.URL.Query().Get("id"), 10, 64)
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, "Invalid id")
+			return
+		}
+		if err := db.DeleteIngestToken(id); err != nil {
+			writeJSONError(w, http.StatusInternalServerError, "Failed to delete ingest token")
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+	}
+}
@@ -0,0 +1,443 @@
+package logserver
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Log levels and urgencies are configured in the database. A level's
+// severity orders sort=level, output aggregation and summaries; its color
+// is for charts. Urgencies name the numeric urgency of logs, in increasing
+// order of value, and give each a chart color.
+
+// LogLevel is a configured log level; names are matched case-insensitively
+type LogLevel struct {
+	Name     string `json:"name"`
+	Severity int    `json:"severity"`
+	Color    string `json:"color"`
+}
+
+// UrgencyLevel names a log urgency value
+type UrgencyLevel struct {
+	Value int    `json:"value"`
+	Name  string `json:"name"`
+	Color string `json:"color"`
+}
+
+// maxUrgency bounds urgency values so the dashboard counters can keep one
+// slot per value
+const maxUrgency = 9
+
+// unknownLevel is the level whose severity levels that are not configured get
+const unknownLevel = "INFO"
+
+var defaultLevels = []LogLevel{
+	{"DEBUG", 0, "#9CA3AF"},
+	{"INFO", 1, "#3B82F6"},
+	{"WARN", 2, "#F59E0B"},
+	{"WARNING", 2, "#F59E0B"},
+	{"ERROR", 3, "#EF4444"},
+	{"CRITICAL", 4, "#B91C1C"},
+	{"FATAL", 4, "#B91C1C"},
+}
+
+var defaultUrgencies = []UrgencyLevel{
+	{1, "low", "#22C55E"},
+	{2, "medium", "#3B82F6"},
+	{3, "high", "#F59E0B"},
+	{4, "critical", "#EF4444"},
+}
+
+var (
+	levelNamePattern   = regexp.MustCompile(`^[A-Z][A-Z0-9_-]{0,31}$`)
+	urgencyNamePattern = regexp.MustCompile(`^[a-z][a-z0-9_-]{0,31}$`)
+	colorPattern       = regexp.MustCompile(`^#[0-9A-Fa-f]{6}$`)
+)
+
+// levelConfig caches the configured levels and urgencies, sorted by
+// severity and value
+type levelConfig struct {
+	mu         sync.RWMutex
+	levels     []LogLevel
+	severities map[string]int
+	urgencies  []UrgencyLevel
+}
+
+func createLevelTables(db *sql.DB) error {
+	for _, stmt := range []string{
+		`CREATE TABLE IF NOT EXISTS log_levels (
+			name TEXT PRIMARY KEY,
+			severity INTEGER NOT NULL,
+			color TEXT NOT NULL
+		)`,
+		`CREATE TABLE IF NOT EXISTS urgency_levels (
+			value INTEGER PRIMARY KEY,
+			name TEXT NOT NULL UNIQUE,
+			color TEXT NOT NULL
+		)`,
+	} {
+		if _, err := db.Exec(stmt); err != nil {
+			return err
+		}
+	}
+	var n int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM log_levels`).Scan(&n); err != nil || n > 0 {
+		return err
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err := replaceLevels(tx, defaultLevels); err != nil {
+		return err
+	}
+	if err := replaceUrgencies(tx, defaultUrgencies); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func replaceLevels(tx *sql.Tx, levels []LogLevel) error {
+	if _, err := tx.Exec(`DELETE FROM log_levels`); err != nil {
+		return err
+	}
+	for _, l := range levels {
+		if _, err := tx.Exec(`INSERT INTO log_levels (name, severity, color) VALUES (?, ?, ?)`, l.Name, l.Severity, l.Color); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func replaceUrgencies(tx *sql.Tx, urgencies []UrgencyLevel) error {
+	if _, err := tx.Exec(`DELETE FROM urgency_levels`); err != nil {
+		return err
+	}
+	for _, u := range urgencies {
+		if _, err := tx.Exec(`INSERT INTO urgency_levels (value, name, color) VALUES (?, ?, ?)`, u.Value, u.Name, u.Color); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// loadLevels reads the configured levels and urgencies into d.levels
+func (d *Database) loadLevels() error {
+	rows, err := d.db.Query(`SELECT name, severity, color FROM log_levels ORDER BY severity, name`)
+	if err != nil {
+		return err
+	}
+	levels := []LogLevel{}
+	for rows.Next() {
+		var l LogLevel
+		if err := rows.Scan(&l.Name, &l.Severity, &l.Color); err != nil {
+			rows.Close()
+			return err
+		}
+		levels = append(levels, l)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	rows, err = d.db.Query(`SELECT value, name, color FROM urgency_levels ORDER BY value`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	urgencies := []UrgencyLevel{}
+	for rows.Next() {
+		var u UrgencyLevel
+		if err := rows.Scan(&u.Value, &u.Name, &u.Color); err != nil {
+			return err
+		}
+		urgencies = append(urgencies, u)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	d.levels.set(levels, urgencies)
+	return nil
+}
+
+// set replaces the levels and urgencies, which must be sorted
+func (c *levelConfig) set(levels []LogLevel, urgencies []UrgencyLevel) {
+	severities := make(map[string]int, len(levels))
+	for _, l := range levels {
+		severities[l.Name] = l.Severity
+	}
+	c.mu.Lock()
+	c.levels, c.severities, c.urgencies = levels, severities, urgencies
+	c.mu.Unlock()
+}
+
+func (c *levelConfig) levelList() []LogLevel {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return append([]LogLevel{}, c.levels...)
+}
+
+func (c *levelConfig) urgencyList() []UrgencyLevel {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return append([]UrgencyLevel{}, c.urgencies...)
+}
+
+// severity ranks a level the way severityExpr does in SQL
+func (c *levelConfig) severity(level string) int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if severity, ok := c.severities[strings.ToUpper(level)]; ok {
+		return severity
+	}
+	return c.severities[unknownLevel]
+}
+
+// knownLevel reports whether level, in any case, is configured
+func (c *levelConfig) knownLevel(level string) bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	_, ok := c.severities[strings.ToUpper(level)]
+	return ok
+}
+
+// levelWord returns the level a word names, singular or plural, so
+// "errors" and "Warnings" name ERROR and WARNING
+func (c *levelConfig) levelWord(word string) (string, bool) {
+	level := strings.ToUpper(word)
+	if c.knownLevel(level) {
+		return level, true
+	}
+	level = strings.TrimSuffix(level, "S")
+	return level, c.knownLevel(level)
+}
+
+// knownUrgency reports whether an urgency value is configured
+func (c *levelConfig) knownUrgency(value int) bool {
+	for _, u := range c.urgencyList() {
+		if u.Value == value {
+			return true
+		}
+	}
+	return false
+}
+
+// urgencyFactor maps a notable urgency name to a 0-1 risk factor: its value
+// over the highest configured value
+func (c *levelConfig) urgencyFactor(name string) float64 {
+	urgencies := c.urgencyList()
+	if len(urgencies) == 0 || urgencies[len(urgencies)-1].Value <= 0 {
+		return 0
+	}
+	for _, u := range urgencies {
+		if u.Name == strings.ToLower(name) {
+			return float64(max(u.Value, 0)) / float64(urgencies[len(urgencies)-1].Value)
+		}
+	}
+	return 0
+}
+
+// urgencyNames lists the configured urgency names, for error messages
+func (c *levelConfig) urgencyNames() string {
+	var names []string
+	for _, u := range c.urgencyList() {
+		names = append(names, u.Name+" ("+strconv.Itoa(u.Value)+")")
+	}
+	return strings.Join(names, ", ")
+}
+
+// severityExpr ranks log levels in SQL. idx_logs_level_severity is built on
+// it, and queries must use the same text for SQLite to use the index, so the
+// index is rebuilt whenever the levels change.
+func (c *levelConfig) severityExpr() string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	var b strings.Builder
+	b.WriteString(`(CASE upper(level)`)
+	for _, l := range c.levels {
+		// Names are validated to letters, digits, '_' and '-'
+		fmt.Fprintf(&b, ` WHEN '%s' THEN %d`, l.Name, l.Severity)
+	}
+	fmt.Fprintf(&b, ` ELSE %d END)`, c.severities[unknownLevel])
+	return b.String()
+}
+
+// ensureLevelSeverityIndex builds idx_logs_level_severity on the current
+// severityExpr, replacing an index built on an older one
+func (d *Database) ensureLevelSeverityIndex() error {
+	want := `CREATE INDEX idx_logs_level_severity ON logs(` + d.levels.severityExpr() + `, timestamp)`
+	var have string
+	err := d.db.QueryRow(`SELECT sql FROM sqlite_master WHERE type = 'index' AND name = 'idx_logs_level_severity'`).Scan(&have)
+	if err == nil && have == want {
+		return nil
+	}
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+	if _, err := d.db.Exec(`DROP INDEX IF EXISTS idx_logs_level_severity`); err != nil {
+		return err
+	}
+	_, err = d.db.Exec(want)
+	return err
+}
+
+func validateLevels(levels []LogLevel) error {
+	if len(levels) == 0 {
+		return errors.New("at least one level is required")
+	}
+	seen := map[string]bool{}
+	for i := range levels {
+		l := &levels[i]
+		l.Name = strings.ToUpper(strings.TrimSpace(l.Name))
+		if !levelNamePattern.MatchString(l.Name) {
+			return fmt.Errorf("level %q: names must be 1-32 letters, digits, '_' or '-', starting with a letter", l.Name)
+		}
+		if seen[l.Name] {
+			return fmt.Errorf("level %q is listed twice", l.Name)
+		}
+		seen[l.Name] = true
+		if l.Severity < -100 || l.Severity > 100 {
+			return fmt.Errorf("level %q: severity must be between -100 and 100", l.Name)
+		}
+		if !colorPattern.MatchString(l.Color) {
+			return fmt.Errorf("level %q: color must be #RRGGBB", l.Name)
+		}
+	}
+	if !seen[unknownLevel] {
+		return fmt.Errorf("the %s level is required; unknown levels sort with it", unknownLevel)
+	}
+	return nil
+}
+
+func validateUrgencies(urgencies []UrgencyLevel) error {
+	if len(urgencies) == 0 {
+		return errors.New("at least one urgency is required")
+	}
+	values, names := map[int]bool{}, map[string]bool{}
+	for i := range urgencies {
+		u := &urgencies[i]
+		u.Name = strings.ToLower(strings.TrimSpace(u.Name))
+		if u.Value < 0 || u.Value > maxUrgency {
+			return fmt.Errorf("urgency %q: value must be between 0 and %d", u.Name, maxUrgency)
+		}
+		if !urgencyNamePattern.MatchString(u.Name) {
+			return fmt.Errorf("urgency %q: names must be 1-32 letters, digits, '_' or '-', starting with a letter", u.Name)
+		}
+		if values[u.Value] || names[u.Name] {
+			return fmt.Errorf("urgency %q: values and names must be unique", u.Name)
+		}
+		values[u.Value], names[u.Name] = true, true
+		if !colorPattern.MatchString(u.Color) {
+			return fmt.Errorf("urgency %q: color must be #RRGGBB", u.Name)
+		}
+	}
+	return nil
+}
+
+// SetLevels replaces the configured levels and rebuilds the severity index
+func (d *Database) SetLevels(levels []LogLevel) error {
+	tx, err := d.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err := replaceLevels(tx, levels); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	if err := d.loadLevels(); err != nil {
+		return err
+	}
+	return d.ensureLevelSeverityIndex()
+}
+
+// SetUrgencies replaces the configured urgencies
+func (d *Database) SetUrgencies(urgencies []UrgencyLevel) error {
+	tx, err := d.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err := replaceUrgencies(tx, urgencies); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	if err := d.loadLevels(); err != nil {
+		return err
+	}
+	// The urgency chart's ETag must change with its labels
+	d.logsChanged()
+	return nil
+}
+
+// GET/PUT /api/levels - list, or replace (admin only), the log levels
+func levelsHandlerDB(w http.ResponseWriter, r *http.Request, db *Database) {
+	enableCORS(w)
+	w.Header().Set("Content-Type", "application/json")
+	switch r.Method {
+	case http.MethodGet:
+		json.NewEncoder(w).Encode(db.levels.levelList())
+	case http.MethodPut:
+		if !db.requireAdmin(w, r) {
+			return
+		}
+		var levels []LogLevel
+		if err := json.NewDecoder(r.Body).Decode(&levels); err != nil {
+			writeJSONError(w, http.StatusBadRequest, "Invalid JSON")
+			return
+		}
+		if err := validateLevels(levels); err != nil {
+			writeJSONError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if err := db.SetLevels(levels); err != nil {
+			writeJSONError(w, http.StatusInternalServerError, "Failed to save levels")
+			return
+		}
+		json.NewEncoder(w).Encode(db.levels.levelList())
+	default:
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+	}
+}
+
+// GET/PUT /api/urgencies - list, or replace (admin only), the urgencies
+func urgenciesHandlerDB(w http.ResponseWriter, r *http.Request, db *Database) {
+	enableCORS(w)
+	w.Header().Set("Content-Type", "application/json")
+	switch r.Method {
+	case http.MethodGet:
+		json.NewEncoder(w).Encode(db.levels.urgencyList())
+	case http.MethodPut:
+		if !db.requireAdmin(w, r) {
+			return
+		}
+		var urgencies []UrgencyLevel
+		if err := json.NewDecoder(r.Body).Decode(&urgencies); err != nil {
+			writeJSONError(w, http.StatusBadRequest, "Invalid JSON")
+			return
+		}
+		if err := validateUrgencies(urgencies); err != nil {
+			writeJSONError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if err := db.SetUrgencies(urgencies); err != nil {
+			writeJSONError(w, http.StatusInternalServerError, "Failed to save urgencies")
+			return
+		}
+		json.NewEncoder(w).Encode(db.levels.urgencyList())
+	default:
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+	}
+}
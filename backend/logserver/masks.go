@@ -0,0 +1,259 @@
+package logserver
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Field masks. Admins choose log fields, and keys of raw JSON payloads,
+// that callers with a role (viewer or anonymous) must not see. Their values
+// are replaced with maskedValue in every log those callers get back, and
+// they cannot search or group by them, which would reveal the values too.
+// Like data scopes, masks make a restricted view; see enforceScopes.
+
+// maskedValue replaces the value of a masked field
+const maskedValue = "[masked]"
+
+// rawMaskPrefix marks a mask on a top-level key of raw JSON payloads
+const rawMaskPrefix = "raw."
+
+// maskableFields maps the log fields that can be masked to the search
+// parameters (and group_by values) that would reveal them
+var maskableFields = map[string][]string{
+	"sourceIP":      {"ip", "source"},
+	"destinationIP": {"ip", "destination"},
+	"event":         {"event"},
+	"description":   nil,
+	"user":          {"user"},
+	"traceId":       {"trace_id"},
+}
+
+// fieldMasks are the fields masked for a caller
+type fieldMasks []string
+
+type fieldMaskCache struct {
+	mu     sync.RWMutex
+	byRole map[string]fieldMasks
+}
+
+func createFieldMaskTables(db *sql.DB) error {
+	_, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS field_masks (
+			role TEXT NOT NULL,
+			field TEXT NOT NULL,
+			PRIMARY KEY (role, field)
+		)
+	`)
+	return err
+}
+
+func (d *Database) loadFieldMasks() error {
+	rows, err := d.db.Query(`SELECT role, field FROM field_masks ORDER BY role, field`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	byRole := map[string]fieldMasks{}
+	for rows.Next() {
+		var role, field string
+		if err := rows.Scan(&role, &field); err != nil {
+			return err
+		}
+		byRole[role] = append(byRole[role], field)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	d.fieldMasks.mu.Lock()
+	d.fieldMasks.byRole = byRole
+	d.fieldMasks.mu.Unlock()
+	return nil
+}
+
+// GetFieldMasks returns the masked fields of every role that has any
+func (d *Database) GetFieldMasks() map[string]fieldMasks {
+	d.fieldMasks.mu.RLock()
+	defer d.fieldMasks.mu.RUnlock()
+	masks := make(map[string]fieldMasks, len(d.fieldMasks.byRole))
+	for role, fields := range d.fieldMasks.byRole {
+		masks[role] = append(fieldMasks{}, fields...)
+	}
+	return masks
+}
+
+// roleMasks returns the fields masked for a role
+func (d *Database) roleMasks(role string) fieldMasks {
+	d.fieldMasks.mu.RLock()
+	defer d.fieldMasks.mu.RUnlock()
+	return d.fieldMasks.byRole[role]
+}
+
+// validateFieldMasks checks a role-to-fields map and removes duplicates
+func validateFieldMasks(masks map[string]fieldMasks) error {
+	for role, fields := range masks {
+		if role != roleViewer && role != roleAnonymous {
+			return errors.New("Masks apply to the viewer and anonymous roles, not " + role)
+		}
+		seen := map[string]bool{}
+		unique := fieldMasks{}
+		for _, field := range fields {
+			if _, ok := maskableFields[field]; !ok && (!strings.HasPrefix(field, rawMaskPrefix) || field == rawMaskPrefix) {
+				return errors.New("Field " + field + " cannot be masked")
+			}
+			if !seen[field] {
+				seen[field] = true
+				unique = append(unique, field)
+			}
+		}
+		sort.Strings(unique)
+		masks[role] = unique
+	}
+	return nil
+}
+
+// SetFieldMasks replaces the masks of every role
+func (d *Database) SetFieldMasks(masks map[string]fieldMasks) error {
+	tx, err := d.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(`DELETE FROM field_masks`); err != nil {
+		return err
+	}
+	for role, fields := range masks {
+		for _, field := range fields {
+			if _, err := tx.Exec(`INSERT INTO field_masks (role, field) VALUES (?, ?)`, role, field); err != nil {
+				return err
+			}
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	return d.loadFieldMasks()
+}
+
+func (m fieldMasks) has(field string) bool {
+	for _, f := range m {
+		if f == field {
+			return true
+		}
+	}
+	return false
+}
+
+// apply masks the fields of logs in place; empty values stay empty
+func (m fieldMasks) apply(logs []LogEntry) {
+	if len(m) == 0 {
+		return
+	}
+	for i := range logs {
+		l := &logs[i]
+		for _, field := range []struct {
+			name  string
+			value *string
+		}{
+			{"sourceIP", &l.SourceIP},
+			{"destinationIP", &l.DestinationIP},
+			{"event", &l.Event},
+			{"description", &l.Description},
+			{"user", &l.User},
+			{"traceId", &l.TraceID},
+		} {
+			if *field.value != "" && m.has(field.name) {
+				*field.value = maskedValue
+			}
+		}
+	}
+}
+
+// revealingParam returns a search parameter in q that filters or groups by
+// a masked field, if there is one
+func (m fieldMasks) revealingParam(q url.Values) (string, bool) {
+	for _, field := range m {
+		for _, param := range maskableFields[field] {
+			if q.Get(param) != "" {
+				return param, true
+			}
+			if q.Get("group_by") == param {
+				return "group_by", true
+			}
+		}
+	}
+	return "", false
+}
+
+// maskRaw masks a raw payload: the raw.<key> masks and the masked log fields
+// as top-level keys of a JSON object, matched case-insensitively as ingest
+// does. Payloads that are not JSON objects cannot be masked.
+func (m fieldMasks) maskRaw(format string, payload []byte) ([]byte, bool) {
+	if len(m) == 0 {
+		return payload, true
+	}
+	if format != rawJSON {
+		return nil, false
+	}
+	var object map[string]json.RawMessage
+	if err := json.Unmarshal(payload, &object); err != nil {
+		return nil, false
+	}
+	masked, _ := json.Marshal(maskedValue)
+	for key := range object {
+		for _, field := range m {
+			if strings.EqualFold(key, strings.TrimPrefix(field, rawMaskPrefix)) {
+				object[key] = masked
+			}
+		}
+	}
+	out, err := json.Marshal(object)
+	return out, err == nil
+}
+
+// requestMasks returns the fields masked for a restricted request
+func requestMasks(r *http.Request) fieldMasks {
+	view, _ := requestView(r)
+	return view.masks
+}
+
+// writeMaskedParam refuses a search that reveals a masked field
+func writeMaskedParam(w http.ResponseWriter, param string) {
+	writeJSONError(w, http.StatusForbidden, "Searching by "+param+" would reveal a masked field")
+}
+
+// GET /api/masks - masked fields per role (admin only)
+// PUT /api/masks {"viewer": ["description", "raw.password"]} - replace them
+func fieldMasksHandlerDB(w http.ResponseWriter, r *http.Request, db *Database) {
+	enableCORS(w)
+	w.Header().Set("Content-Type", "application/json")
+	if !db.requireAdmin(w, r) {
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		json.NewEncoder(w).Encode(db.GetFieldMasks())
+	case http.MethodPut:
+		var masks map[string]fieldMasks
+		if err := json.NewDecoder(r.Body).Decode(&masks); err != nil {
+			writeJSONError(w, http.StatusBadRequest, "Invalid JSON")
+			return
+		}
+		if err := validateFieldMasks(masks); err != nil {
+			writeJSONError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if err := db.SetFieldMasks(masks); err != nil {
+			writeJSONError(w, http.StatusInternalServerError, "Failed to save masks")
+			return
+		}
+		json.NewEncoder(w).Encode(db.GetFieldMasks())
+	default:
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+	}
+}
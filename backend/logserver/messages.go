@@ -0,0 +1,121 @@
+package logserver
+
+import (
+	"embed"
+	"encoding/json"
+	"net/http"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// UI message catalogs. The dashboard's text lives in messages/<lang>.json,
+// keyed by message ID, and is served by GET /api/messages in the language
+// the client prefers. A language is added by adding its file.
+
+//go:embed messages/*.json
+var messageFiles embed.FS
+
+// defaultLanguage is the complete catalog others fall back to
+const defaultLanguage = "en"
+
+// messageCatalogs maps a language to its messages, with the messages it
+// lacks filled in from the default language
+var messageCatalogs map[string]map[string]string
+
+// MessageCatalog is the response of GET /api/messages
+type MessageCatalog struct {
+	Lang      string            `json:"lang"`
+	Languages []string          `json:"languages"`
+	Messages  map[string]string `json:"messages"`
+}
+
+func loadMessageCatalogs() error {
+	files, err := messageFiles.ReadDir("messages")
+	if err != nil {
+		return err
+	}
+	catalogs := make(map[string]map[string]string, len(files))
+	for _, f := range files {
+		data, err := messageFiles.ReadFile("messages/" + f.Name())
+		if err != nil {
+			return err
+		}
+		var messages map[string]string
+		if err := json.Unmarshal(data, &messages); err != nil {
+			return err
+		}
+		catalogs[strings.TrimSuffix(f.Name(), path.Ext(f.Name()))] = messages
+	}
+	for lang, messages := range catalogs {
+		if lang == defaultLanguage {
+			continue
+		}
+		for id, text := range catalogs[defaultLanguage] {
+			if _, ok := messages[id]; !ok {
+				messages[id] = text
+			}
+		}
+	}
+	messageCatalogs = catalogs
+	return nil
+}
+
+// messageLanguages lists the languages with a catalog
+func messageLanguages() []string {
+	langs := make([]string, 0, len(messageCatalogs))
+	for lang := range messageCatalogs {
+		langs = append(langs, lang)
+	}
+	sort.Strings(langs)
+	return langs
+}
+
+// catalogLanguage returns the catalog for a language tag such as "de-AT",
+// matching on its primary subtag
+func catalogLanguage(tag string) (string, bool) {
+	lang, _, _ := strings.Cut(strings.ToLower(strings.TrimSpace(tag)), "-")
+	_, ok := messageCatalogs[lang]
+	return lang, ok
+}
+
+// negotiateLanguage picks the lang= language, else the best supported one
+// in Accept-Language, else the default
+func negotiateLanguage(r *http.Request) string {
+	if lang, ok := catalogLanguage(r.URL.Query().Get("lang")); ok {
+		return lang
+	}
+	best, bestQ := defaultLanguage, 0.0
+	for _, part := range strings.Split(r.Header.Get("Accept-Language"), ",") {
+		tag, params, _ := strings.Cut(part, ";")
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if parsed, err := strconv.ParseFloat(v, 64); err == nil {
+				q = parsed
+			}
+		}
+		if lang, ok := catalogLanguage(tag); ok && q > bestQ {
+			best, bestQ = lang, q
+		}
+	}
+	return best
+}
+
+// GET /api/messages?lang= - the UI message catalog
+func messagesHandler(w http.ResponseWriter, r *http.Request) {
+	enableCORS(w)
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != http.MethodGet {
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	lang := negotiateLanguage(r)
+	w.Header().Set("Content-Language", lang)
+	w.Header().Set("Vary", "Accept-Language")
+	json.NewEncoder(w).Encode(MessageCatalog{
+		Lang:      lang,
+		Languages: messageLanguages(),
+		Messages:  messageCatalogs[lang],
+	})
+}
@@ -0,0 +1,294 @@
+package logserver
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// MuteWindow suppresses alert notifications either for a one-off range
+// (StartsAt..EndsAt) or for DurationSeconds after every Cron match.
+// Empty RuleIDs/Sources mean the window applies to every rule/source.
+type MuteWindow struct {
+	ID              int64      `json:"id"`
+	Name            string     `json:"name"`
+	StartsAt        *time.Time `json:"startsAt,omitempty"`
+	EndsAt          *time.Time `json:"endsAt,omitempty"`
+	Cron            string     `json:"cron,omitempty"`
+	DurationSeconds int        `json:"durationSeconds,omitempty"`
+	Timezone        string     `json:"timezone,omitempty"`
+	RuleIDs         []int64    `json:"ruleIds"`
+	Sources         []string   `json:"sources"`
+	CreatedAt       time.Time  `json:"createdAt"`
+}
+
+func createMuteTables(db *sql.DB) error {
+	_, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS mute_windows (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			name TEXT NOT NULL,
+			starts_at DATETIME,
+			ends_at DATETIME,
+			cron TEXT NOT NULL DEFAULT '',
+			duration_seconds INTEGER NOT NULL DEFAULT 0,
+			timezone TEXT NOT NULL DEFAULT '',
+			rule_ids TEXT NOT NULL,
+			sources TEXT NOT NULL,
+			created_at DATETIME NOT NULL
+		)
+	`)
+	if err != nil {
+		return err
+	}
+	if err := addColumnIfMissing(db, "alert_history", "muted", `INTEGER NOT NULL DEFAULT 0`); err != nil {
+		return err
+	}
+	return addColumnIfMissing(db, "alert_history", "mute_window_id", `INTEGER`)
+}
+
+// validate checks that exactly one of the one-off range or cron schedule is set
+func (m MuteWindow) validate() error {
+	if m.Name == "" {
+		return errors.New("name is required")
+	}
+	oneOff := m.StartsAt != nil || m.EndsAt != nil
+	if oneOff == (m.Cron != "") {
+		return errors.New("set either startsAt/endsAt or cron with durationSeconds")
+	}
+	if oneOff {
+		if m.StartsAt == nil || m.EndsAt == nil || !m.StartsAt.Before(*m.EndsAt) {
+			return errors.New("startsAt must be before endsAt")
+		}
+		return nil
+	}
+	if _, err := parseCron(m.Cron); err != nil {
+		return err
+	}
+	if m.DurationSeconds <= 0 {
+		return errors.New("durationSeconds is required with cron")
+	}
+	if _, err := time.LoadLocation(m.Timezone); err != nil {
+		return errors.New("unknown timezone " + m.Timezone)
+	}
+	return nil
+}
+
+// activeAt reports whether the window is muting at t
+func (m MuteWindow) activeAt(t time.Time) bool {
+	if m.Cron == "" {
+		return m.StartsAt != nil && m.EndsAt != nil && !t.Before(*m.StartsAt) && t.Before(*m.EndsAt)
+	}
+	schedule, err := parseCron(m.Cron)
+	if err != nil {
+		return false
+	}
+	loc, err := time.LoadLocation(m.Timezone)
+	if err != nil {
+		return false
+	}
+	return schedule.activeWithin(t.In(loc), time.Duration(m.DurationSeconds)*time.Second)
+}
+
+// appliesTo reports whether the window's scope covers the rule and any of the sample sources
+func (m MuteWindow) appliesTo(ruleID int64, samples []LogEntry) bool {
+	if len(m.RuleIDs) > 0 {
+		found := false
+		for _, id := range m.RuleIDs {
+			if id == ruleID {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if len(m.Sources) == 0 {
+		return true
+	}
+	for _, sample := range samples {
+		for _, source := range m.Sources {
+			if sample.SourceIP == source {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (d *Database) CreateMuteWindow(m MuteWindow) (MuteWindow, error) {
+	return d.createMuteWindow(d.db, m)
+}
+
+func (d *Database) createMuteWindow(ex execer, m MuteWindow) (MuteWindow, error) {
+	if m.RuleIDs == nil {
+		m.RuleIDs = []int64{}
+	}
+	if m.Sources == nil {
+		m.Sources = []string{}
+	}
+	ruleIDs, _ := json.Marshal(m.RuleIDs)
+	sources, _ := json.Marshal(m.Sources)
+	m.CreatedAt = d.now().UTC()
+	var startsAt, endsAt interface{}
+	if m.StartsAt != nil {
+		startsAt = m.StartsAt.UTC()
+	}
+	if m.EndsAt != nil {
+		endsAt = m.EndsAt.UTC()
+	}
+	res, err := ex.Exec(`
+		INSERT INTO mute_windows (name, starts_at, ends_at, cron, duration_seconds, timezone, rule_ids, sources, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, m.Name, startsAt, endsAt, m.Cron, m.DurationSeconds, m.Timezone, string(ruleIDs), string(sources), m.CreatedAt)
+	if err != nil {
+		return m, err
+	}
+	m.ID, err = res.LastInsertId()
+	return m, err
+}
+
+func (d *Database) UpdateMuteWindow(m MuteWindow) error {
+	return updateMuteWindow(d.db, m)
+}
+
+func updateMuteWindow(ex execer, m MuteWindow) error {
+	if m.RuleIDs == nil {
+		m.RuleIDs = []int64{}
+	}
+	if m.Sources == nil {
+		m.Sources = []string{}
+	}
+	ruleIDs, _ := json.Marshal(m.RuleIDs)
+	sources, _ := json.Marshal(m.Sources)
+	var startsAt, endsAt interface{}
+	if m.StartsAt != nil {
+		startsAt = m.StartsAt.UTC()
+	}
+	if m.EndsAt != nil {
+		endsAt = m.EndsAt.UTC()
+	}
+	_, err := ex.Exec(`
+		UPDATE mute_windows SET name = ?, starts_at = ?, ends_at = ?, cron = ?, duration_seconds = ?, timezone = ?, rule_ids = ?, sources = ?
+		WHERE id = ?
+	`, m.Name, startsAt, endsAt, m.Cron, m.DurationSeconds, m.Timezone, string(ruleIDs), string(sources), m.ID)
+	return err
+}
+
+func (d *Database) DeleteMuteWindow(id int64) error {
+	return deleteMuteWindow(d.db, id)
+}
+
+func deleteMuteWindow(ex execer, id int64) error {
+	_, err := ex.Exec(`DELETE FROM mute_windows WHERE id = ?`, id)
+	return err
+}
+
+func (d *Database) GetMuteWindows() ([]MuteWindow, error) {
+	rows, err := d.db.Query(`
+		SELECT id, name, starts_at, ends_at, cron, duration_seconds, timezone, rule_ids, sources, created_at
+		FROM mute_windows
+		ORDER BY id
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	windows := []MuteWindow{}
+	for rows.Next() {
+		var m MuteWindow
+		var startsAt, endsAt sql.NullTime
+		var ruleIDs, sources string
+		err := rows.Scan(&m.ID, &m.Name, &startsAt, &endsAt, &m.Cron, &m.DurationSeconds, &m.Timezone, &ruleIDs, &sources, &m.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		if startsAt.Valid {
+			m.StartsAt = &startsAt.Time
+		}
+		if endsAt.Valid {
+			m.EndsAt = &endsAt.Time
+		}
+		if err := json.Unmarshal([]byte(ruleIDs), &m.RuleIDs); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal([]byte(sources), &m.Sources); err != nil {
+			return nil, err
+		}
+		windows = append(windows, m)
+	}
+	return windows, rows.Err()
+}
+
+// activeMuteWindow returns the first window muting a firing of ruleID at now, if any
+func (d *Database) activeMuteWindow(ruleID int64, samples []LogEntry, now time.Time) (*MuteWindow, error) {
+	windows, err := d.GetMuteWindows()
+	if err != nil {
+		return nil, err
+	}
+	for _, m := range windows {
+		if m.activeAt(now) && m.appliesTo(ruleID, samples) {
+			return &m, nil
+		}
+	}
+	return nil, nil
+}
+
+// GET/POST/DELETE /api/alerts/mutes - list maintenance windows, or create or
+// delete one (admin only)
+func muteWindowsHandlerDB(w http.ResponseWriter, r *http.Request, db *Database) {
+	enableCORS(w)
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method == http.MethodOptions {
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		windows, err := db.GetMuteWindows()
+		if err != nil {
+			writeJSONError(w, http.StatusInternalServerError, "Failed to fetch mute windows")
+			return
+		}
+		json.NewEncoder(w).Encode(windows)
+	case http.MethodPost:
+		if !db.requireAdmin(w, r) {
+			return
+		}
+		var m MuteWindow
+		if err := json.NewDecoder(r.Body).Decode(&m); err != nil {
+			writeJSONError(w, http.StatusBadRequest, "Invalid JSON")
+			return
+		}
+		if err := m.validate(); err != nil {
+			writeJSONError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		m, err := db.CreateMuteWindow(m)
+		if err != nil {
+			writeJSONError(w, http.StatusInternalServerError, "Failed to create mute window")
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(m)
+	case http.MethodDelete:
+		if !db.requireAdmin(w, r) {
+			return
+		}
+		id, err := strconv.ParseInt(r.URL.Query().Get("id"), 10, 64)
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, "Invalid id")
+			return
+		}
+		if err := db.DeleteMuteWindow(id); err != nil {
+			writeJSONError(w, http.StatusInternalServerError, "Failed to delete mute window")
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+	}
+}
@@ -0,0 +1,240 @@
+package logserver
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// NDJSON bulk ingest. Agents ship thousands of logs in one request to
+// POST /api/logs/bulk with an NDJSON body, one JSON log object per line.
+// That path also starts admin bulk jobs, whose bodies are JSON, so the
+// Content-Type tells them apart. The body is decoded as it streams in and
+// stored in chunks, and each line is validated on its own: a bad line is
+// reported by number and does not keep the others out.
+
+var (
+	// bulkIngestMaxBytes caps an NDJSON body
+	bulkIngestMaxBytes = envInt("BULK_INGEST_MAX_BYTES", 256<<20)
+	// bulkIngestMaxLine caps one line
+	bulkIngestMaxLine = envInt("BULK_INGEST_MAX_LINE_BYTES", 1<<20)
+)
+
+const (
+	// bulkIngestChunk is how many valid lines are charged to the quota and
+	// stored at a time
+	bulkIngestChunk = 500
+	// maxBulkLineErrors is how many rejected lines are itemized
+	maxBulkLineErrors = 100
+)
+
+// ndjsonContentTypes are the media types of an NDJSON body
+var ndjsonContentTypes = map[string]bool{
+	"application/x-ndjson":    true,
+	"application/ndjson":      true,
+	"application/jsonl":       true,
+	"application/jsonlines":   true,
+	"application/x-jsonlines": true,
+}
+
+var (
+	errBulkLineTooLong  = errors.New("Line is too long")
+	errBulkBodyTooLarge = errors.New("Body is too large")
+)
+
+// isNDJSONIngest reports whether r ingests NDJSON rather than starting a
+// bulk job
+func isNDJSONIngest(r *http.Request) bool {
+	if r.Method != http.MethodPost || r.URL.Path != "/api/logs/bulk" {
+		return false
+	}
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return ndjsonContentTypes[mediaType]
+}
+
+// BulkIngestResult reports an NDJSON ingest line by line. Error is set
+// when the request stopped early; lines after the stop are not counted.
+type BulkIngestResult struct {
+	Accepted int             `json:"accepted"`
+	Rejected int             `json:"rejected"`
+	Errors   []BulkLineError `json:"errors"`
+	Error    string          `json:"error,omitempty"`
+}
+
+// BulkLineError is a rejected line, numbered from 1
+type BulkLineError struct {
+	Line  int    `json:"line"`
+	Error string `json:"error"`
+}
+
+func (res *BulkIngestResult) reject(line int, err error) {
+	res.Rejected++
+	if len(res.Errors) < maxBulkLineErrors {
+		res.Errors = append(res.Errors, BulkLineError{Line: line, Error: err.Error()})
+	}
+}
+
+// POST /api/logs/bulk with an NDJSON Content-Type - store one log per line
+func bulkIngestHandlerDB(w http.ResponseWriter, r *http.Request, db *Database) {
+	enableCORS(w)
+	w.Header().Set("Content-Type", "application/json")
+	now := db.now()
+	token, secret, err := db.ingestCredential(r, now)
+	if err != nil {
+		writeJSONError(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+	body := io.Reader(http.MaxBytesReader(w, r.Body, int64(bulkIngestMaxBytes)))
+	if token.ReplayProtection {
+		// The signature covers the whole body, so it is read before any of
+		// it is stored
+		data, err := io.ReadAll(body)
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			writeJSONError(w, http.StatusRequestEntityTooLarge, errBulkBodyTooLarge.Error())
+			return
+		}
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, "Invalid body")
+			return
+		}
+		if err := db.checkReplay(token, secret, r, data, now); err != nil {
+			status := http.StatusUnauthorized
+			if errors.Is(err, errReplay) {
+				status = http.StatusConflict
+			}
+			writeJSONError(w, status, err.Error())
+			return
+		}
+		body = bytes.NewReader(data)
+	}
+	// A compressed body is decompressed as it streams in, and the
+	// decompressed body is held to the same limit
+	if body, err = decompressingReader(ingestEncoding(r), body, bulkIngestMaxBytes); err != nil {
+		writeJSONError(w, decompressionStatus(err), err.Error())
+		return
+	}
+
+	res := BulkIngestResult{Errors: []BulkLineError{}}
+	pending := make([]LogEntry, 0, bulkIngestChunk)
+	// flush stores the pending logs, returning the status to stop with, or
+	// 0 to go on
+	flush := func() int {
+		if len(pending) == 0 {
+			return 0
+		}
+		if err := db.admitIngest(token, len(pending), now); err != nil {
+			w.Header().Set("Retry-After", strconv.Itoa(int(untilNextQuotaDay(now).Seconds())+1))
+			res.Error = err.Error()
+			return http.StatusTooManyRequests
+		}
+		for _, entry := range pending {
+			if err := db.InsertLog(entry); err != nil {
+				res.Error = "Failed to insert log"
+				return http.StatusInternalServerError
+			}
+			res.Accepted++
+		}
+		pending = pending[:0]
+		return 0
+	}
+	status := scanBulkLines(body, func(number int, line []byte) int {
+		entry, err := decodeBulkLine(line, now)
+		if err != nil {
+			res.reject(number, err)
+			return 0
+		}
+		if pending = append(pending, entry); len(pending) < bulkIngestChunk {
+			return 0
+		}
+		return flush()
+	}, &res)
+	if status == 0 {
+		status = flush()
+	}
+	if status == 0 {
+		status = http.StatusOK
+	}
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(res)
+}
+
+// scanBulkLines calls store with each non-blank line and its number until
+// the body ends or store returns a status to stop with, which it returns.
+// Lines too long are rejected into res.
+func scanBulkLines(body io.Reader, store func(number int, line []byte) int, res *BulkIngestResult) int {
+	reader := bufio.NewReaderSize(body, 64<<10)
+	var tooLarge *http.MaxBytesError
+	var corrupt corruptBodyError
+	for number := 1; ; number++ {
+		line, err := readBulkLine(reader)
+		switch {
+		case errors.Is(err, errBulkLineTooLong):
+			res.reject(number, err)
+			continue
+		case errors.As(err, &tooLarge):
+			res.Error = errBulkBodyTooLarge.Error()
+			return http.StatusRequestEntityTooLarge
+		case errors.Is(err, errDecompressedTooLarge):
+			res.Error = err.Error()
+			return http.StatusRequestEntityTooLarge
+		case errors.As(err, &corrupt):
+			res.Error = corrupt.Error()
+			return http.StatusBadRequest
+		case err != nil && err != io.EOF:
+			res.Error = "Invalid body"
+			return http.StatusBadRequest
+		}
+		if line = bytes.TrimSpace(line); len(line) > 0 {
+			if status := store(number, line); status != 0 {
+				return status
+			}
+		}
+		if err == io.EOF {
+			return 0
+		}
+	}
+}
+
+// readBulkLine reads one line without its newline. A line longer than
+// bulkIngestMaxLine is skipped and reported as errBulkLineTooLong.
+func readBulkLine(reader *bufio.Reader) ([]byte, error) {
+	var line []byte
+	for {
+		chunk, err := reader.ReadSlice('\n')
+		if len(line)+len(chunk) > bulkIngestMaxLine+1 {
+			for errors.Is(err, bufio.ErrBufferFull) {
+				_, err = reader.ReadSlice('\n')
+			}
+			if err != nil && err != io.EOF {
+				return nil, err
+			}
+			return nil, errBulkLineTooLong
+		}
+		line = append(line, chunk...)
+		if !errors.Is(err, bufio.ErrBufferFull) {
+			return bytes.TrimSuffix(line, []byte("\n")), err
+		}
+	}
+}
+
+// decodeBulkLine decodes and validates one line's log
+func decodeBulkLine(line []byte, now time.Time) (LogEntry, error) {
+	var entry LogEntry
+	if line[0] != '{' {
+		return entry, errors.New("Line is not a JSON object")
+	}
+	if err := json.Unmarshal(line, &entry); err != nil {
+		return entry, errors.New("Invalid JSON")
+	}
+	if storeRawPayloads {
+		entry.Raw, entry.RawFormat = bytes.Clone(line), rawJSON
+	}
+	return entry, prepareLogEntry(&entry, now)
+}
@@ -0,0 +1,146 @@
+package logserver
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// bulkIngest posts body to POST /api/logs/bulk as NDJSON with token, and
+// returns the status, the Retry-After header and the decoded result
+func (h *harness) bulkIngest(token string, body []byte, header http.Header) (int, string, BulkIngestResult) {
+	h.t.Helper()
+	req, err := http.NewRequest(http.MethodPost, h.url+"/api/logs/bulk", bytes.NewReader(body))
+	if err != nil {
+		h.t.Fatal(err)
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	req.Header.Set("Content-Type", "application/x-ndjson")
+	if token != "" {
+		req.Header.Set("X-Ingest-Token", token)
+	}
+	resp, err := h.server.Client().Do(req)
+	if err != nil {
+		h.t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var res BulkIngestResult
+	if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
+		h.t.Fatalf("POST /api/logs/bulk: decoding response: %v", err)
+	}
+	return resp.StatusCode, resp.Header.Get("Retry-After"), res
+}
+
+// createIngestToken creates an ingest token with a daily quota, or none
+// when quota is 0, and returns its secret
+func (h *harness) createIngestToken(name string, quota int64) string {
+	h.t.Helper()
+	var created struct {
+		Token string `json:"token"`
+	}
+	h.do(http.MethodPost, "/api/ingest/tokens", IngestToken{Name: name, DailyQuota: quota}, &created)
+	return created.Token
+}
+
+// ndjsonLines is n valid NDJSON lines, each a log with its number as the
+// event
+func ndjsonLines(n int) string {
+	var b strings.Builder
+	for i := 1; i <= n; i++ {
+		fmt.Fprintf(&b, `{"level":"INFO","rule":"Bulk","event":"log %d"}`+"\n", i)
+	}
+	return b.String()
+}
+
+func TestBulkIngestLines(t *testing.T) {
+	defer func(max int) { bulkIngestMaxLine = max }(bulkIngestMaxLine)
+	bulkIngestMaxLine = 100
+
+	h := newHarness(t)
+	body := strings.Join([]string{
+		`{"level":"WARN","rule":"Brute Force Login","sourceIP":"10.0.0.5","event":"Failed login"}`,
+		``,
+		`["not", "an", "object"]`,
+		`{"level":"INFO","event":`,
+		`{"event":"bad port","sourcePort":70000}`,
+		`{"event":"` + strings.Repeat("x", 100) + `"}`,
+		"  \t",
+		`{"event":"crlf"}` + "\r",
+		`{"event":"no newline at the end"}`,
+	}, "\n")
+	status, _, res := h.bulkIngest("", []byte(body), nil)
+	expect(t, "status", status, http.StatusOK)
+	// Blank lines are skipped but still numbered
+	expect(t, "result", res, BulkIngestResult{Accepted: 3, Rejected: 4, Errors: []BulkLineError{
+		{3, "Line is not a JSON object"},
+		{4, "Invalid JSON"},
+		{5, "Ports must be between 0 and 65535"},
+		{6, "Line is too long"},
+	}})
+	logs := h.search(url.Values{})
+	events := make([]string, len(logs))
+	for i, l := range logs {
+		events[i] = l.Event
+	}
+	sort.Strings(events)
+	expect(t, "stored events", events, []string{"Failed login", "crlf", "no newline at the end"})
+
+	// Only the first maxBulkLineErrors rejections are itemized, but every one
+	// is counted
+	status, _, res = h.bulkIngest("", []byte(strings.Repeat("nope\n", maxBulkLineErrors+5)+ndjsonLines(2)), nil)
+	expect(t, "status of mostly bad lines", status, http.StatusOK)
+	expect(t, "accepted and rejected", []int{res.Accepted, res.Rejected, len(res.Errors)}, []int{2, maxBulkLineErrors + 5, maxBulkLineErrors})
+	expect(t, "last itemized line", res.Errors[maxBulkLineErrors-1].Line, maxBulkLineErrors)
+}
+
+// A token's quota is charged per bulkIngestChunk of valid lines, so a body
+// past the quota keeps the chunks that fit and stops at the first that does
+// not
+func TestBulkIngestQuota(t *testing.T) {
+	h := newHarness(t)
+	token := h.createIngestToken("edge", 2*bulkIngestChunk+100)
+
+	// Rejected lines are not charged: two chunks of valid lines, with bad
+	// lines around them, leave 100 of the quota...
+	body := "{\n" + ndjsonLines(bulkIngestChunk) + "bad\n" + ndjsonLines(bulkIngestChunk) + "{}x\n"
+	status, _, res := h.bulkIngest(token, []byte(body), nil)
+	expect(t, "status within the quota", status, http.StatusOK)
+	expect(t, "accepted and rejected within the quota", []int{res.Accepted, res.Rejected}, []int{2 * bulkIngestChunk, 3})
+
+	// ...so of a full chunk and a partial one, the full chunk goes over and
+	// nothing more is stored
+	status, retryAfter, res := h.bulkIngest(token, []byte(ndjsonLines(bulkIngestChunk+50)), nil)
+	expect(t, "status over the quota", status, http.StatusTooManyRequests)
+	expect(t, "accepted over the quota", res.Accepted, 0)
+	expect(t, "error over the quota", res.Error, errQuotaExceeded.Error())
+	if retryAfter == "" {
+		t.Error("no Retry-After over the quota")
+	}
+
+	// A chunk that fits is stored
+	status, _, res = h.bulkIngest(token, []byte(ndjsonLines(100)), nil)
+	expect(t, "status filling the quota", status, http.StatusOK)
+	expect(t, "accepted filling the quota", res.Accepted, 100)
+	status, _, res = h.bulkIngest(token, []byte(ndjsonLines(1)), nil)
+	expect(t, "status once the quota is used", status, http.StatusTooManyRequests)
+
+	var tokens []IngestToken
+	h.do(http.MethodGet, "/api/ingest/tokens", nil, &tokens)
+	expect(t, "used today", tokens[0].UsedToday, int64(2*bulkIngestChunk+100))
+	expect(t, "stored logs", h.count(nil), 2*bulkIngestChunk+100)
+
+	// Without room for its second chunk, a body keeps its first
+	h = newHarness(t)
+	token = h.createIngestToken("edge", bulkIngestChunk+10)
+	status, _, res = h.bulkIngest(token, []byte(ndjsonLines(2*bulkIngestChunk)), nil)
+	expect(t, "status past the second chunk", status, http.StatusTooManyRequests)
+	expect(t, "accepted before the second chunk", res.Accepted, bulkIngestChunk)
+	expect(t, "stored before the second chunk", h.count(nil), bulkIngestChunk)
+}
@@ -0,0 +1,380 @@
+package logserver
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Natural language queries. GET /api/ask?q=errors from 10.0.0.5 yesterday
+// translates a question into search parameters with a QueryTranslator, runs
+// the search, and returns the interpretation with the results so the asker
+// can see what was understood. The rules translator runs locally; the llm
+// translator asks an OpenAI-compatible chat completions endpoint.
+
+var (
+	defaultTranslator = envOr("NLQ_TRANSLATOR", "rules")
+	llmURL            = envOr("NLQ_LLM_URL", "")
+	llmModel          = envOr("NLQ_LLM_MODEL", "gpt-4o-mini")
+	llmAPIKey         = envOr("NLQ_LLM_API_KEY", "")
+)
+
+var llmClient = &http.Client{Timeout: 30 * time.Second}
+
+// QueryTranslator turns a question into search parameters
+type QueryTranslator interface {
+	Translate(ctx context.Context, question string) (Interpretation, error)
+}
+
+// Interpretation is what a translator understood of a question
+type Interpretation struct {
+	Query url.Values
+	Terms []InterpretedTerm
+	// Ignored holds the words that meant nothing to the translator
+	Ignored []string
+}
+
+// InterpretedTerm is one search parameter and the words it came from
+type InterpretedTerm struct {
+	Text  string `json:"text,omitempty"`
+	Param string `json:"param"`
+	Value string `json:"value"`
+}
+
+func (in *Interpretation) add(text, param, value string) {
+	in.Query.Add(param, value)
+	in.Terms = append(in.Terms, InterpretedTerm{Text: text, Param: param, Value: value})
+}
+
+// queryTranslator returns the named translator, or the default one
+func (d *Database) queryTranslator(name string) (QueryTranslator, error) {
+	if name == "" {
+		name = defaultTranslator
+	}
+	switch name {
+	case "rules":
+		return rulesTranslator{rules: d.counters.snapshot(d.counters.rules), levels: &d.levels}, nil
+	case "llm":
+		if llmURL == "" {
+			return nil, errors.New("The llm translator requires NLQ_LLM_URL")
+		}
+		return llmTranslator{levels: &d.levels}, nil
+	}
+	return nil, fmt.Errorf("Unknown translator %q", name)
+}
+
+// rulesTranslator recognizes time phrases, levels, categories, addresses,
+// ports, users, tags, quoted event text and the names of known rules
+type rulesTranslator struct {
+	rules  map[string]int64
+	levels *levelConfig
+}
+
+var (
+	nlSincePattern = regexp.MustCompile(`\b(?:in the |during the )?(?:last|past) (\d+) ?(minutes?|mins?|m|hours?|hrs?|h|days?|d|weeks?|w)\b`)
+	nlUnitPattern  = regexp.MustCompile(`\b(?:in the |during the )?(?:last|past) (hour|day|24 hours)\b`)
+	nlRangePattern = regexp.MustCompile(`\b(today|yesterday|(?:this|last) (?:week|month))\b`)
+	nlShortSince   = regexp.MustCompile(`\bsince (\d+[mhdw])\b`)
+	nlQuoted       = regexp.MustCompile(`"([^"]+)"`)
+	nlWord         = regexp.MustCompile(`[\w.:/=-]+`)
+)
+
+// nlStopWords carry no meaning for a search
+var nlStopWords = map[string]bool{
+	"a": true, "all": true, "an": true, "and": true, "any": true, "are": true, "at": true, "did": true,
+	"during": true, "events": true, "find": true, "for": true, "get": true, "happened": true, "in": true,
+	"is": true, "list": true, "log": true, "logs": true, "me": true, "of": true, "on": true, "or": true,
+	"show": true, "the": true, "there": true, "were": true, "what": true, "which": true, "with": true,
+}
+
+// containsPhrase reports whether phrase occurs in text as whole words
+func containsPhrase(text, phrase string) bool {
+	wordByte := func(s string, i int) bool {
+		return i >= 0 && i < len(s) && (s[i] == '_' || s[i] >= 'a' && s[i] <= 'z' || s[i] >= '0' && s[i] <= '9')
+	}
+	for from := 0; phrase != ""; {
+		i := strings.Index(text[from:], phrase)
+		if i < 0 {
+			return false
+		}
+		i += from
+		if !wordByte(text, i-1) && !wordByte(text, i+len(phrase)) {
+			return true
+		}
+		from = i + 1
+	}
+	return false
+}
+
+func (t rulesTranslator) Translate(ctx context.Context, question string) (Interpretation, error) {
+	in := Interpretation{Query: url.Values{}}
+	text := strings.ToLower(question)
+
+	// Quoted text is event text; it is taken from the original casing
+	for _, m := range nlQuoted.FindAllStringSubmatchIndex(question, -1) {
+		in.add(question[m[0]:m[1]], "event", question[m[2]:m[3]])
+	}
+	text = nlQuoted.ReplaceAllString(text, " ")
+
+	if m := nlSincePattern.FindStringSubmatch(text); m != nil {
+		in.add(m[0], "since", m[1]+m[2][:1])
+		text = strings.Replace(text, m[0], " ", 1)
+	} else if m := nlUnitPattern.FindStringSubmatch(text); m != nil {
+		in.add(m[0], "since", map[string]string{"hour": "1h", "day": "24h", "24 hours": "24h"}[m[1]])
+		text = strings.Replace(text, m[0], " ", 1)
+	} else if m := nlRangePattern.FindStringSubmatch(text); m != nil {
+		in.add(m[0], "range", strings.ReplaceAll(m[1], " ", "_"))
+		text = strings.Replace(text, m[0], " ", 1)
+	} else if m := nlShortSince.FindStringSubmatch(text); m != nil {
+		in.add(m[0], "since", m[1])
+		text = strings.Replace(text, m[0], " ", 1)
+	}
+
+	// The longest known rule name in the question
+	var rule string
+	for name := range t.rules {
+		if len(name) > len(rule) && containsPhrase(text, strings.ToLower(name)) {
+			rule = name
+		}
+	}
+	if rule != "" {
+		in.add(rule, "rule", rule)
+		text = strings.Replace(text, strings.ToLower(rule), " ", 1)
+	}
+
+	words := nlWord.FindAllString(text, -1)
+	for i := 0; i < len(words); i++ {
+		word := strings.TrimRight(words[i], ".")
+		prev := ""
+		if i > 0 {
+			prev = words[i-1]
+		}
+		next := ""
+		if i+1 < len(words) {
+			next = words[i+1]
+		}
+		switch {
+		case net.ParseIP(word) != nil:
+			switch prev {
+			case "from", "source", "src":
+				in.add(prev+" "+word, "source", word)
+			case "to", "destination", "dst":
+				in.add(prev+" "+word, "destination", word)
+			default:
+				in.add(word, "ip", word)
+			}
+		case word == "port" && next != "":
+			if p, err := strconv.Atoi(next); err == nil && validPort(p) {
+				in.add(word+" "+next, "dst_port", next)
+				i++
+			} else {
+				in.Ignored = append(in.Ignored, word)
+			}
+		case (word == "user" || word == "by") && next != "" && !nlStopWords[next]:
+			in.add(word+" "+next, "user", next)
+			i++
+		case (word == "tag" || word == "tagged") && next != "":
+			in.add(word+" "+next, "tag", next)
+			i++
+		case strings.Contains(word, "="):
+			key, value, _ := strings.Cut(word, "=")
+			if searchParamKeys[key] && value != "" {
+				in.add(word, key, value)
+			} else {
+				in.Ignored = append(in.Ignored, word)
+			}
+		case word == "access" || word == "network" || strings.TrimSuffix(word, "s") == "threat" || word == "uba":
+			in.add(word, "category", strings.TrimSuffix(word, "s"))
+		default:
+			if level, ok := t.levels.levelWord(word); ok {
+				in.add(word, "level", level)
+			} else if !nlStopWords[word] && word != "from" && word != "to" && word != "source" && word != "destination" {
+				in.Ignored = append(in.Ignored, word)
+			}
+		}
+	}
+	return in, nil
+}
+
+// llmTranslator asks a chat completions endpoint for search parameters. Its
+// answer is kept to searchParamKeys, so it can only ever search.
+type llmTranslator struct {
+	levels *levelConfig
+}
+
+func (t llmTranslator) prompt() string {
+	keys := make([]string, 0, len(searchParamKeys))
+	for key := range searchParamKeys {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	levels := []string{}
+	for _, l := range t.levels.levelList() {
+		levels = append(levels, l.Name)
+	}
+	return "Translate the user's question about security logs into a URL query string for a log search API. " +
+		"Answer with the query string only, such as level=ERROR&ip=10.0.0.5&range=yesterday. " +
+		"Parameters: " + strings.Join(keys, ", ") + ". " +
+		"level is one of " + strings.Join(levels, ", ") + ". category is one of access, network, threat, uba. " +
+		"ip, source and destination are IP addresses; ip matches either. event is text found in the event name. " +
+		"since is a duration such as 15m, 24h, 7d or 2w; range is one of today, yesterday, this_week, last_week, this_month, last_month. " +
+		"Repeat a parameter to match any of several values."
+}
+
+func (t llmTranslator) Translate(ctx context.Context, question string) (Interpretation, error) {
+	body, err := json.Marshal(map[string]interface{}{
+		"model":       llmModel,
+		"temperature": 0,
+		"messages": []map[string]string{
+			{"role": "system", "content": t.prompt()},
+			{"role": "user", "content": question},
+		},
+	})
+	if err != nil {
+		return Interpretation{}, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, llmURL, bytes.NewReader(body))
+	if err != nil {
+		return Interpretation{}, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if llmAPIKey != "" {
+		req.Header.Set("Authorization", "Bearer "+llmAPIKey)
+	}
+	resp, err := llmClient.Do(req)
+	if err != nil {
+		return Interpretation{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return Interpretation{}, fmt.Errorf("translator returned %s", resp.Status)
+	}
+	var completion struct {
+		Choices []struct {
+			Message struct {
+				Content string `json:"content"`
+			} `json:"message"`
+		} `json:"choices"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&completion); err != nil || len(completion.Choices) == 0 {
+		return Interpretation{}, errors.New("translator returned no answer")
+	}
+	answer := strings.Trim(strings.TrimSpace(completion.Choices[0].Message.Content), "`?")
+	values, err := url.ParseQuery(answer)
+	if err != nil {
+		return Interpretation{}, fmt.Errorf("translator answered with an invalid query: %v", err)
+	}
+	in := Interpretation{Query: url.Values{}}
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		for _, value := range values[key] {
+			if searchParamKeys[key] && value != "" {
+				in.add("", key, value)
+			} else {
+				in.Ignored = append(in.Ignored, key+"="+value)
+			}
+		}
+	}
+	return in, nil
+}
+
+// AskResult is the response of /api/ask
+type AskResult struct {
+	Question   string            `json:"question"`
+	Translator string            `json:"translator"`
+	Query      string            `json:"query"`
+	Terms      []InterpretedTerm `json:"terms"`
+	Ignored    []string          `json:"ignored"`
+	Search     string            `json:"search"`
+	Count      int               `json:"count"`
+	Logs       []LogEntry        `json:"logs"`
+}
+
+// GET /api/ask?q=&translator=&tz=&limit= - answer a question with a search
+func askHandlerDB(w http.ResponseWriter, r *http.Request, db *Database) {
+	enableCORS(w)
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != http.MethodGet {
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	q := r.URL.Query()
+	question := strings.TrimSpace(q.Get("q"))
+	if question == "" {
+		writeJSONError(w, http.StatusBadRequest, "q is required")
+		return
+	}
+	limit := 50
+	if l, err := strconv.Atoi(q.Get("limit")); err == nil && l > 0 && l <= 1000 {
+		limit = l
+	}
+	name := q.Get("translator")
+	translator, err := db.queryTranslator(name)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if name == "" {
+		name = defaultTranslator
+	}
+	in, err := translator.Translate(r.Context(), question)
+	if err != nil {
+		writeJSONError(w, http.StatusBadGateway, "Translation failed: "+err.Error())
+		return
+	}
+	if len(in.Terms) == 0 {
+		writeJSONError(w, http.StatusUnprocessableEntity, "The question names nothing to search for")
+		return
+	}
+	// Named ranges resolve in the asker's timezone
+	if tz := q.Get("tz"); tz != "" && in.Query.Get("tz") == "" {
+		in.Query.Set("tz", tz)
+	}
+	filter, err := parseLogFilter(in.Query, db.now())
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, "The interpretation is not a valid search: "+err.Error())
+		return
+	}
+	filter = scopedFilter(r, filter)
+	masks := requestMasks(r)
+	if param, ok := masks.revealingParam(in.Query); ok {
+		writeMaskedParam(w, param)
+		return
+	}
+	db.recordSearch(r, in.Query)
+	result := AskResult{
+		Question:   question,
+		Translator: name,
+		Query:      in.Query.Encode(),
+		Terms:      in.Terms,
+		Ignored:    in.Ignored,
+		Search:     "/api/logs?" + filter.values().Encode(),
+	}
+	if result.Ignored == nil {
+		result.Ignored = []string{}
+	}
+	if result.Count, err = db.CountLogs(filter); err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "Failed to search logs")
+		return
+	}
+	if result.Logs, err = db.FilterLogs(filter, limit); err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "Failed to search logs")
+		return
+	}
+	masks.apply(result.Logs)
+	writeEncoded(w, r, result)
+}
@@ -0,0 +1,15 @@
+package logserver
+
+import (
+	"context"
+	"testing"
+)
+
+// FuzzAsk translates natural language questions to /api/ask
+func FuzzAsk(f *testing.F) {
+	addCorpus(f, "ask", func(data []byte) { f.Add(string(data)) })
+	translator := rulesTranslator{levels: defaultLevelConfig()}
+	f.Fuzz(func(t *testing.T, input string) {
+		translator.Translate(context.Background(), input)
+	})
+}
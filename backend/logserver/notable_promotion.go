@@ -0,0 +1,306 @@
+package logserver
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Notable promotion turns bursts of severe logs into notables, so plain
+// application logging reaches the triage queue without an alert rule per
+// case. A stored log qualifies when its level is one of the configured
+// levels or its urgency is at least MinUrgency. Threshold qualifying logs
+// with the same rule and source within the window make one notable, and
+// that rule and source make no other until the window has passed again.
+
+// NotablePromotion configures automatic notables
+type NotablePromotion struct {
+	Enabled bool `json:"enabled"`
+	// Levels qualify logs by level name
+	Levels []string `json:"levels"`
+	// MinUrgency qualifies logs by urgency value; 0 qualifies none
+	MinUrgency    int `json:"minUrgency"`
+	Threshold     int `json:"threshold"`
+	WindowSeconds int `json:"windowSeconds"`
+	// AutoRespond lets promoted notables trigger response actions. Their
+	// rule, source and user come from ingested logs, so it is off unless
+	// an admin turns it on.
+	AutoRespond bool `json:"autoRespond"`
+}
+
+var defaultNotablePromotion = NotablePromotion{
+	Levels:        []string{"CRITICAL", "FATAL"},
+	MinUrgency:    4,
+	Threshold:     1,
+	WindowSeconds: 300,
+}
+
+const (
+	// promotedTag marks notables made by promotion
+	promotedTag = "promoted"
+	// maxPromotionThreshold bounds the logs remembered per rule and source
+	maxPromotionThreshold = 10000
+	// notablePromotionBuffer is the queue of logs waiting to be counted
+	notablePromotionBuffer = 4096
+)
+
+// notablePromoter counts qualifying logs per rule and source
+type notablePromoter struct {
+	mu     sync.Mutex
+	config NotablePromotion
+	bursts map[promotionKey]*promotionBurst
+	swept  time.Time
+}
+
+type promotionKey struct {
+	rule, source string
+}
+
+// promotionBurst holds the qualifying logs of one rule and source within
+// the window, oldest first
+type promotionBurst struct {
+	seen      []time.Time
+	urgencies []int
+	// quietUntil is when the rule and source may be promoted again
+	quietUntil time.Time
+}
+
+func createNotablePromotionTables(db *sql.DB) error {
+	_, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS notable_promotion (
+			id INTEGER PRIMARY KEY CHECK (id = 1),
+			config TEXT NOT NULL
+		)
+	`)
+	return err
+}
+
+func (p NotablePromotion) validate(levels *levelConfig) error {
+	for _, level := range p.Levels {
+		if !levels.knownLevel(level) {
+			return fmt.Errorf("unknown level %q", level)
+		}
+	}
+	switch {
+	case p.MinUrgency < 0 || p.MinUrgency > maxUrgency:
+		return fmt.Errorf("minUrgency must be between 0 and %d", maxUrgency)
+	case p.Enabled && len(p.Levels) == 0 && p.MinUrgency == 0:
+		return errors.New("levels or minUrgency is required")
+	case p.Threshold < 1 || p.Threshold > maxPromotionThreshold:
+		return fmt.Errorf("threshold must be between 1 and %d", maxPromotionThreshold)
+	case p.WindowSeconds < 1 || p.WindowSeconds > 86400:
+		return errors.New("windowSeconds must be between 1 and 86400")
+	}
+	return nil
+}
+
+// qualifies reports whether entry counts toward a notable
+func (p NotablePromotion) qualifies(entry LogEntry) bool {
+	if p.MinUrgency > 0 && entry.Urgency >= p.MinUrgency {
+		return true
+	}
+	return slices.ContainsFunc(p.Levels, func(level string) bool { return strings.EqualFold(level, entry.Level) })
+}
+
+func (d *Database) GetNotablePromotion() NotablePromotion {
+	d.promotion.mu.Lock()
+	defer d.promotion.mu.Unlock()
+	p := d.promotion.config
+	p.Levels = slices.Clone(p.Levels)
+	return p
+}
+
+// SetNotablePromotion stores the configuration; logs counted so far are
+// forgotten
+func (d *Database) SetNotablePromotion(p NotablePromotion) error {
+	if err := storeNotablePromotion(d.db, p); err != nil {
+		return err
+	}
+	d.usePromotion(p)
+	return nil
+}
+
+// storeNotablePromotion writes the configuration, upper-casing its levels
+func storeNotablePromotion(ex execer, p NotablePromotion) error {
+	for i, level := range p.Levels {
+		p.Levels[i] = strings.ToUpper(level)
+	}
+	raw, _ := json.Marshal(p)
+	_, err := ex.Exec(`
+		INSERT INTO notable_promotion (id, config) VALUES (1, ?)
+		ON CONFLICT(id) DO UPDATE SET config = excluded.config
+	`, string(raw))
+	return err
+}
+
+// usePromotion makes a stored configuration the one ingest applies
+func (d *Database) usePromotion(p NotablePromotion) {
+	d.promotion.mu.Lock()
+	d.promotion.config, d.promotion.bursts = p, nil
+	d.promotion.mu.Unlock()
+}
+
+func (d *Database) loadNotablePromotion() error {
+	p := defaultNotablePromotion
+	var raw string
+	err := d.db.QueryRow(`SELECT config FROM notable_promotion WHERE id = 1`).Scan(&raw)
+	if err == nil {
+		err = json.Unmarshal([]byte(raw), &p)
+	}
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+	d.promotion.mu.Lock()
+	d.promotion.config = p
+	d.promotion.mu.Unlock()
+	return nil
+}
+
+// promoteNotable counts a stored log, creating a notable when its rule and
+// source reach the threshold
+func (d *Database) promoteNotable(msg interface{}) {
+	m := msg.(*IngestedLog)
+	if !m.Store {
+		return
+	}
+	n, respond, ok := d.promotion.add(m.Entry, d.now(), &d.levels)
+	if !ok {
+		return
+	}
+	n, err := d.CreateNotable(n)
+	if err == nil {
+		id, _ := strconv.ParseInt(n.ID, 10, 64)
+		err = d.TagNotable(id, []string{promotedTag})
+	}
+	if err == nil && respond {
+		go d.autoRespond(n)
+	}
+	if err != nil {
+		log.Printf("notable promotion: %s from %s: %v", n.RuleName, n.SourceIP, err)
+	}
+}
+
+// add counts entry at now, returning the notable to create when it
+// completes a burst and whether it may trigger response actions. The
+// notable's urgency is named from levels.
+func (p *notablePromoter) add(entry LogEntry, now time.Time, levels *levelConfig) (NotableEvent, bool, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !p.config.Enabled || !p.config.qualifies(entry) {
+		return NotableEvent{}, false, false
+	}
+	window := time.Duration(p.config.WindowSeconds) * time.Second
+	if p.bursts == nil {
+		p.bursts = make(map[promotionKey]*promotionBurst)
+	}
+	if now.Sub(p.swept) >= window {
+		p.sweep(now.Add(-window), now)
+		p.swept = now
+	}
+	key := promotionKey{entry.Rule, entry.SourceIP}
+	burst := p.bursts[key]
+	if burst == nil {
+		burst = &promotionBurst{}
+		p.bursts[key] = burst
+	}
+	if now.Before(burst.quietUntil) {
+		return NotableEvent{}, false, false
+	}
+	burst.trim(now.Add(-window))
+	burst.seen = append(burst.seen, now)
+	burst.urgencies = append(burst.urgencies, entry.Urgency)
+	if len(burst.seen) < p.config.Threshold {
+		return NotableEvent{}, false, false
+	}
+	count, urgency := len(burst.seen), slices.Max(burst.urgencies)
+	burst.seen, burst.urgencies, burst.quietUntil = nil, nil, now.Add(window)
+
+	rule := entry.Rule
+	if rule == "" {
+		rule = entry.Event
+	}
+	return NotableEvent{
+		RuleName:    rule,
+		Urgency:     levels.promotedUrgency(urgency),
+		SourceIP:    entry.SourceIP,
+		Destination: entry.DestinationIP,
+		User:        entry.User,
+		Count:       count,
+		Description: fmt.Sprintf("%d severe logs within %s; latest %s: %s", count, window, strings.ToUpper(entry.Level), entry.Event),
+	}, p.config.AutoRespond, true
+}
+
+// trim forgets the logs before since
+func (b *promotionBurst) trim(since time.Time) {
+	i := 0
+	for i < len(b.seen) && b.seen[i].Before(since) {
+		i++
+	}
+	b.seen, b.urgencies = b.seen[i:], b.urgencies[i:]
+}
+
+// sweep drops the rules and sources with no logs since since that are not
+// being kept quiet
+func (p *notablePromoter) sweep(since, now time.Time) {
+	for key, burst := range p.bursts {
+		burst.trim(since)
+		if len(burst.seen) == 0 && !now.Before(burst.quietUntil) {
+			delete(p.bursts, key)
+		}
+	}
+}
+
+// promotedUrgency names the notable urgency for the highest log urgency of
+// a burst; logs without a configured urgency make the highest one
+func (c *levelConfig) promotedUrgency(value int) string {
+	urgencies := c.urgencyList()
+	if len(urgencies) == 0 {
+		return "critical"
+	}
+	for _, u := range urgencies {
+		if u.Value == value {
+			return u.Name
+		}
+	}
+	return urgencies[len(urgencies)-1].Name
+}
+
+// GET/PUT /api/notables/promotion - read or replace the notable promotion settings (admin only)
+func notablePromotionHandlerDB(w http.ResponseWriter, r *http.Request, db *Database) {
+	enableCORS(w)
+	w.Header().Set("Content-Type", "application/json")
+	if !db.requireAdmin(w, r) {
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		json.NewEncoder(w).Encode(db.GetNotablePromotion())
+	case http.MethodPut:
+		// Settings left out keep their defaults
+		p := defaultNotablePromotion
+		p.Levels = slices.Clone(p.Levels)
+		if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
+			writeJSONError(w, http.StatusBadRequest, "Invalid JSON")
+			return
+		}
+		if err := p.validate(&db.levels); err != nil {
+			writeJSONError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if err := db.SetNotablePromotion(p); err != nil {
+			writeJSONError(w, http.StatusInternalServerError, "Failed to update notable promotion")
+			return
+		}
+		json.NewEncoder(w).Encode(db.GetNotablePromotion())
+	default:
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+	}
+}
@@ -0,0 +1,324 @@
+package logserver
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Notable statuses
+const (
+	notableStatusNew      = "new"
+	notableStatusAssigned = "assigned"
+	notableStatusResolved = "resolved"
+)
+
+func createNotableTables(db *sql.DB) error {
+	_, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS notables (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			rule_name TEXT NOT NULL,
+			urgency TEXT NOT NULL,
+			category TEXT NOT NULL,
+			source_ip TEXT NOT NULL,
+			destination TEXT NOT NULL,
+			count INTEGER NOT NULL,
+			description TEXT NOT NULL,
+			status TEXT NOT NULL,
+			assignee TEXT NOT NULL DEFAULT '',
+			created_at DATETIME NOT NULL,
+			updated_at DATETIME NOT NULL
+		)
+	`)
+	if err != nil {
+		return err
+	}
+	for column, definition := range map[string]string{
+		"ticket_key":    `TEXT NOT NULL DEFAULT ''`,
+		"ticket_url":    `TEXT NOT NULL DEFAULT ''`,
+		"ticket_synced": `INTEGER NOT NULL DEFAULT 0`,
+		"user_name":     `TEXT NOT NULL DEFAULT ''`,
+		"risk_score":    `REAL NOT NULL DEFAULT 0`,
+		"risk_factors":  `TEXT NOT NULL DEFAULT '{}'`,
+	} {
+		if err := addColumnIfMissing(db, "notables", column, definition); err != nil {
+			return err
+		}
+	}
+	_, err = db.Exec(`CREATE INDEX IF NOT EXISTS idx_notables_status ON notables(status)`)
+	return err
+}
+
+const notableColumns = `id, rule_name, urgency, category, source_ip, destination, count, description, status, assignee, created_at, updated_at, ticket_key, ticket_url, user_name,
+	risk_score, risk_factors`
+
+func scanNotable(scan func(dest ...interface{}) error) (NotableEvent, error) {
+	var n NotableEvent
+	var id int64
+	var factors string
+	err := scan(&id, &n.RuleName, &n.Urgency, &n.Category, &n.SourceIP, &n.Destination, &n.Count, &n.Description,
+		&n.Status, &n.Assignee, &n.Timestamp, &n.UpdatedAt, &n.TicketKey, &n.TicketURL, &n.User, &n.RiskScore, &factors)
+	if err != nil {
+		return n, err
+	}
+	n.ID = strconv.FormatInt(id, 10)
+	n.RiskFactors = &RiskFactors{}
+	return n, json.Unmarshal([]byte(factors), n.RiskFactors)
+}
+
+func (d *Database) CreateNotable(n NotableEvent) (NotableEvent, error) {
+	now := d.now().UTC()
+	if n.Timestamp.IsZero() {
+		n.Timestamp = now
+	}
+	if n.Category == "" {
+		n.Category = categorizeRule(n.RuleName)
+	}
+	if n.Urgency == "" {
+		n.Urgency = "medium"
+	}
+	n.Status = notableStatusNew
+	n.UpdatedAt = now
+	if err := d.scoreNotable(&n); err != nil {
+		return n, err
+	}
+	factors, _ := json.Marshal(n.RiskFactors)
+	res, err := d.db.Exec(`
+		INSERT INTO notables (rule_name, urgency, category, source_ip, destination, user_name, count, description, status, created_at, updated_at,
+			risk_score, risk_factors)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, n.RuleName, n.Urgency, n.Category, n.SourceIP, n.Destination, n.User, n.Count, n.Description, n.Status, n.Timestamp.UTC(), n.UpdatedAt,
+		n.RiskScore, string(factors))
+	if err != nil {
+		return n, err
+	}
+	id, err := res.LastInsertId()
+	n.ID = strconv.FormatInt(id, 10)
+	if err == nil {
+		d.emitEvent(eventNotableCreated, n)
+		d.recordChange(changeNotable, n.ID, changeCreated, n)
+	}
+	return n, err
+}
+
+func (d *Database) GetNotable(id int64) (NotableEvent, error) {
+	n, err := scanNotable(d.db.QueryRow(`SELECT `+notableColumns+` FROM notables WHERE id = ?`, id).Scan)
+	if err != nil {
+		return n, err
+	}
+	tags, err := d.notableTags([]int64{id})
+	n.Tags = tags[id]
+	return n, err
+}
+
+// GetNotables lists notables, newest first or, when byRisk is set, highest risk first
+func (d *Database) GetNotables(status, tag string, byRisk bool, limit int) ([]NotableEvent, error) {
+	query := `SELECT ` + notableColumns + ` FROM notables WHERE 1=1`
+	args := []interface{}{}
+	if status != "" {
+		query += ` AND status = ?`
+		args = append(args, status)
+	}
+	if tag != "" {
+		query += ` AND id IN (SELECT notable_id FROM notable_tags WHERE tag = ?)`
+		args = append(args, tag)
+	}
+	if byRisk {
+		query += ` ORDER BY risk_score DESC, created_at DESC LIMIT ?`
+	} else {
+		query += ` ORDER BY created_at DESC LIMIT ?`
+	}
+	args = append(args, limit)
+	rows, err := d.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	notables := []NotableEvent{}
+	for rows.Next() {
+		n, err := scanNotable(rows.Scan)
+		if err != nil {
+			return nil, err
+		}
+		notables = append(notables, n)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	ids := make([]int64, len(notables))
+	for i, n := range notables {
+		ids[i], _ = strconv.ParseInt(n.ID, 10, 64)
+	}
+	tags, err := d.notableTags(ids)
+	for i := range notables {
+		notables[i].Tags = tags[ids[i]]
+	}
+	return notables, err
+}
+
+// updateNotable sets status and assignee, emits eventType with the updated
+// notable and records action in the change feed
+func (d *Database) updateNotable(id int64, status, assignee, eventType, action string) (NotableEvent, error) {
+	res, err := d.db.Exec(`
+		UPDATE notables SET status = ?, assignee = ?, updated_at = ? WHERE id = ?
+	`, status, assignee, d.now().UTC(), id)
+	if err := requireOneRow(res, err); err != nil {
+		return NotableEvent{}, err
+	}
+	n, err := d.GetNotable(id)
+	if err == nil {
+		d.emitEvent(eventType, n)
+		d.recordChange(changeNotable, n.ID, action, n)
+	}
+	return n, err
+}
+
+func (d *Database) AssignNotable(id int64, assignee string) (NotableEvent, error) {
+	return d.updateNotable(id, notableStatusAssigned, assignee, eventNotableAssigned, changeAssigned)
+}
+
+func (d *Database) ResolveNotable(id int64) (NotableEvent, error) {
+	n, err := d.GetNotable(id)
+	if err != nil {
+		return n, err
+	}
+	return d.updateNotable(id, notableStatusResolved, n.Assignee, eventNotableResolved, changeResolved)
+}
+
+// GET/POST /api/notables - list notables, or create one (admin only)
+func notablesHandlerDB(w http.ResponseWriter, r *http.Request, db *Database) {
+	enableCORS(w)
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method == http.MethodOptions {
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		limit := 100
+		if l, err := strconv.Atoi(r.URL.Query().Get("limit")); err == nil && l > 0 && l <= 1000 {
+			limit = l
+		}
+		sort := r.URL.Query().Get("sort")
+		if sort != "" && sort != "time" && sort != "risk" {
+			writeJSONError(w, http.StatusBadRequest, "sort must be time or risk")
+			return
+		}
+		notables, err := db.GetNotables(r.URL.Query().Get("status"), r.URL.Query().Get("tag"), sort == "risk", limit)
+		if err != nil {
+			writeJSONError(w, http.StatusInternalServerError, "Failed to fetch notables")
+			return
+		}
+		json.NewEncoder(w).Encode(notables)
+	case http.MethodPost:
+		// A posted notable's rule, source and user are the caller's choice,
+		// so it never triggers response actions
+		if !db.requireAdmin(w, r) {
+			return
+		}
+		var n NotableEvent
+		if err := json.NewDecoder(r.Body).Decode(&n); err != nil {
+			writeJSONError(w, http.StatusBadRequest, "Invalid JSON")
+			return
+		}
+		if n.RuleName == "" {
+			writeJSONError(w, http.StatusBadRequest, "ruleName is required")
+			return
+		}
+		n, err := db.CreateNotable(n)
+		if err != nil {
+			writeJSONError(w, http.StatusInternalServerError, "Failed to create notable")
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(n)
+	default:
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+	}
+}
+
+// GET /api/notables/{id}, POST /api/notables/{id}/assign, /resolve, /ticket, /respond and POST/DELETE /tags
+func notableHandlerDB(w http.ResponseWriter, r *http.Request, db *Database) {
+	enableCORS(w)
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method == http.MethodOptions {
+		return
+	}
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/api/notables/"), "/")
+	id, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, "Invalid notable id")
+		return
+	}
+	action := ""
+	if len(parts) > 1 {
+		action = parts[1]
+	}
+	// Every action changes the notable, and tickets and webhooks go out
+	if r.Method != http.MethodGet && !db.requireAdmin(w, r) {
+		return
+	}
+
+	var n NotableEvent
+	switch {
+	case action == "" && r.Method == http.MethodGet:
+		n, err = db.GetNotable(id)
+	case action == "assign" && r.Method == http.MethodPost:
+		var req struct {
+			Assignee string `json:"assignee"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Assignee == "" {
+			writeJSONError(w, http.StatusBadRequest, "assignee is required")
+			return
+		}
+		n, err = db.AssignNotable(id, req.Assignee)
+	case action == "resolve" && r.Method == http.MethodPost:
+		n, err = db.ResolveNotable(id)
+	case action == "tags" && (r.Method == http.MethodPost || r.Method == http.MethodDelete):
+		tags, err := decodeTags(r)
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if _, err := db.GetNotable(id); errors.Is(err, sql.ErrNoRows) {
+			writeJSONError(w, http.StatusNotFound, "Notable not found")
+			return
+		}
+		if r.Method == http.MethodPost {
+			err = db.TagNotable(id, tags)
+		} else {
+			err = db.UntagNotable(id, tags)
+		}
+		if err == nil {
+			n, err = db.GetNotable(id)
+		}
+	case action == "respond" && r.Method == http.MethodPost:
+		respondToNotable(w, r, db, id)
+		return
+	case action == "ticket" && r.Method == http.MethodPost:
+		n, err = db.CreateTicket(id)
+		if errors.Is(err, errTicketsDisabled) {
+			writeJSONError(w, http.StatusNotImplemented, err.Error())
+			return
+		}
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			writeJSONError(w, http.StatusBadGateway, "Failed to create ticket: "+err.Error())
+			return
+		}
+	default:
+		writeJSONError(w, http.StatusNotFound, "Not found")
+		return
+	}
+	if errors.Is(err, sql.ErrNoRows) {
+		writeJSONError(w, http.StatusNotFound, "Notable not found")
+		return
+	}
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "Failed to update notable")
+		return
+	}
+	json.NewEncoder(w).Encode(n)
+}
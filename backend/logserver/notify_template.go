@@ -0,0 +1,78 @@
+package logserver
+
+import (
+	"bytes"
+	"os"
+	"strconv"
+	"strings"
+	"text/template"
+)
+
+// dashboardURL is the public base URL used for deep links in notifications
+var dashboardURL = strings.TrimRight(envOr("DASHBOARD_URL", "http://localhost:3000"), "/")
+
+// defaultAlertTemplate is used for rules without a MessageTemplate
+const defaultAlertTemplate = `[{{.Rule.Name}}] {{.Count}} matching logs in the last {{.Rule.WindowSeconds}}s (threshold {{.Rule.Threshold}}) - {{.Links.Search}}`
+
+var notificationFuncs = template.FuncMap{
+	"upper": strings.ToUpper,
+	"lower": strings.ToLower,
+	"join":  strings.Join,
+}
+
+// NotificationLinks are deep links into the dashboard and API for an alert
+type NotificationLinks struct {
+	Dashboard string
+	Search    string
+	History   string
+}
+
+// NotificationData is what notification templates are executed against
+type NotificationData struct {
+	Rule    AlertRule
+	Alert   AlertInstance
+	Samples []LogEntry
+	Count   int
+	Links   NotificationLinks
+}
+
+func envOr(key, fallback string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return fallback
+}
+
+func parseNotificationTemplate(text string) (*template.Template, error) {
+	return template.New("notification").Funcs(notificationFuncs).Option("missingkey=zero").Parse(text)
+}
+
+func notificationLinks(rule AlertRule) NotificationLinks {
+	params := rule.Filter.values()
+	return NotificationLinks{
+		Dashboard: dashboardURL + "/",
+		Search:    dashboardURL + "/?" + params.Encode(),
+		History:   dashboardURL + "/api/alerts/history?rule_id=" + strconv.FormatInt(rule.ID, 10),
+	}
+}
+
+// renderNotification executes the rule's message template, falling back to the default one
+func renderNotification(rule AlertRule, instance AlertInstance) (string, error) {
+	text := rule.MessageTemplate
+	if text == "" {
+		text = defaultAlertTemplate
+	}
+	tmpl, err := parseNotificationTemplate(text)
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	err = tmpl.Execute(&buf, NotificationData{
+		Rule:    rule,
+		Alert:   instance,
+		Samples: instance.Samples,
+		Count:   instance.Count,
+		Links:   notificationLinks(rule),
+	})
+	return buf.String(), err
+}
@@ -0,0 +1,167 @@
+package logserver
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// Output spools. An output with SpoolMaxBytes keeps the batches it cannot
+// send on disk instead of dropping them: a batch that fails every attempt,
+// logs that overflow the memory buffer and whatever is unsent at shutdown.
+// Spooled batches are sent first, oldest first, and survive a restart.
+// When the spool would grow past SpoolMaxBytes the oldest batches are
+// evicted, so an outage loses the oldest logs rather than the most recent
+// ones, and never grows the agent's memory.
+
+// outputSpool is one output's spool directory, one file per batch
+type outputSpool struct {
+	dir string
+	max int64
+
+	mu      sync.Mutex
+	batches []spooledBatch // oldest first
+	size    int64
+	seq     int64
+
+	evicted atomic.Int64
+}
+
+// spooledBatch is a spool file, named <seq>-<logs>.batch
+type spooledBatch struct {
+	seq  int64
+	logs int
+	size int64
+}
+
+func (s *outputSpool) path(b spooledBatch) string {
+	return filepath.Join(s.dir, fmt.Sprintf("%016d-%d.batch", b.seq, b.logs))
+}
+
+// spoolDir is where the output with the given ID spools
+func (d *Database) spoolDir(outputID int64) string {
+	return filepath.Join(d.path+".spool", strconv.FormatInt(outputID, 10))
+}
+
+// openOutputSpool opens the spool in dir, picking up the batches a previous
+// run left there
+func openOutputSpool(dir string, maxBytes int64) (*outputSpool, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	s := &outputSpool{dir: dir, max: maxBytes}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		name, ok := strings.CutSuffix(entry.Name(), ".batch")
+		seqPart, logsPart, found := strings.Cut(name, "-")
+		if !ok || !found {
+			// Leftover temporary files from an interrupted write
+			os.Remove(filepath.Join(dir, entry.Name()))
+			continue
+		}
+		seq, err1 := strconv.ParseInt(seqPart, 10, 64)
+		logs, err2 := strconv.Atoi(logsPart)
+		info, err3 := entry.Info()
+		if err1 != nil || err2 != nil || err3 != nil {
+			continue
+		}
+		s.batches = append(s.batches, spooledBatch{seq: seq, logs: logs, size: info.Size()})
+		s.size += info.Size()
+		s.seq = max(s.seq, seq)
+	}
+	sort.Slice(s.batches, func(i, j int) bool { return s.batches[i].seq < s.batches[j].seq })
+	s.mu.Lock()
+	s.evict()
+	s.mu.Unlock()
+	return s, nil
+}
+
+// push spools a batch. The file is written under a temporary name and
+// renamed, so a crash never leaves half a batch behind.
+func (s *outputSpool) push(batch []LogEntry) error {
+	data, err := spoolLogs(batch)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.seq++
+	b := spooledBatch{seq: s.seq, logs: len(batch), size: int64(len(data))}
+	tmp := s.path(b) + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, s.path(b)); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	s.batches = append(s.batches, b)
+	s.size += b.size
+	s.evict()
+	return nil
+}
+
+// evict removes the oldest batches until the spool fits; s.mu is held
+func (s *outputSpool) evict() {
+	for s.size > s.max && len(s.batches) > 0 {
+		b := s.batches[0]
+		s.batches = s.batches[1:]
+		s.size -= b.size
+		os.Remove(s.path(b))
+		s.evicted.Add(int64(b.logs))
+	}
+}
+
+// oldest reads the oldest spooled batch; ok is false when the spool is empty
+func (s *outputSpool) oldest() (b spooledBatch, batch []LogEntry, ok bool, err error) {
+	s.mu.Lock()
+	if len(s.batches) == 0 {
+		s.mu.Unlock()
+		return b, nil, false, nil
+	}
+	b = s.batches[0]
+	s.mu.Unlock()
+	data, err := os.ReadFile(s.path(b))
+	if err != nil {
+		if os.IsNotExist(err) {
+			// Evicted since; the next call reads the batch after it
+			return b, nil, true, nil
+		}
+		return b, nil, true, err
+	}
+	batch, err = unspoolLogs(data)
+	return b, batch, true, err
+}
+
+// remove drops a batch once it is sent, unless it was evicted meanwhile
+func (s *outputSpool) remove(b spooledBatch) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := range s.batches {
+		if s.batches[i].seq == b.seq {
+			s.batches = append(s.batches[:i], s.batches[i+1:]...)
+			s.size -= b.size
+			os.Remove(s.path(b))
+			return
+		}
+	}
+}
+
+// stats returns how many logs and bytes are spooled
+func (s *outputSpool) stats() (logs int, size int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, b := range s.batches {
+		logs += b.logs
+	}
+	return logs, s.size
+}
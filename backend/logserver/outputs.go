@@ -0,0 +1,712 @@
+package logserver
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Output kinds
+const (
+	outputLogger        = "logger"        // another instance's POST /api/logs, one request per log
+	outputHTTP          = "http"          // a JSON array of logs POSTed to URL
+	outputElasticsearch = "elasticsearch" // the _bulk API, into Index
+	outputKafka         = "kafka"         // a Kafka REST Proxy (v2 JSON), into Topic
+	outputS3            = "s3"            // one NDJSON object per batch, PUT with SigV4 under URL (bucket endpoint) and Prefix
+)
+
+// Batching and retry defaults; failed batches are retried with a doubling
+// backoff and dropped after outputMaxAttempts
+const (
+	defaultOutputBatchSize    = 100
+	maxOutputBatchSize        = 5000
+	defaultOutputFlushSeconds = 5
+	outputBufferSize          = 10000
+	outputMaxAttempts         = 5
+	outputInitialBackoff      = time.Second
+)
+
+// Output forwards ingested logs matching Filter to a downstream sink
+type Output struct {
+	ID   int64  `json:"id"`
+	Name string `json:"name"`
+	Kind string `json:"kind"`
+	outputConfig
+	CreatedAt time.Time `json:"createdAt"`
+	// Status is filled in by the listing
+	Status *OutputStatus `json:"status,omitempty"`
+}
+
+// outputConfig holds the kind-specific fields stored in the config column
+type outputConfig struct {
+	URL     string            `json:"url"`
+	Headers map[string]string `json:"headers,omitempty"`
+	Index   string            `json:"index,omitempty"`
+	Topic   string            `json:"topic,omitempty"`
+	Region  string            `json:"region,omitempty"`
+	Prefix  string            `json:"prefix,omitempty"`
+	// AccessKey and SecretKey sign S3 requests; SecretKey is write-only
+	AccessKey    string    `json:"accessKey,omitempty"`
+	SecretKey    string    `json:"secretKey,omitempty"`
+	Filter       LogFilter `json:"filter"`
+	BatchSize    int       `json:"batchSize,omitempty"`
+	FlushSeconds int       `json:"flushSeconds,omitempty"`
+	// AggregateBelow makes a logger output an edge agent: logs less severe
+	// than this level are shipped as per-minute counts instead
+	AggregateBelow string `json:"aggregateBelow,omitempty"`
+	// SpoolMaxBytes, when set, keeps unsent batches on disk up to this
+	// size rather than dropping them; see output_spool.go
+	SpoolMaxBytes int64 `json:"spoolMaxBytes,omitempty"`
+}
+
+// OutputStatus counts what an output has done since startup
+type OutputStatus struct {
+	Sent    int64 `json:"sent"`
+	Failed  int64 `json:"failed"`
+	Dropped int64 `json:"dropped"`
+	// Summarized counts logs shipped as summaries because of AggregateBelow
+	Summarized int64      `json:"summarized,omitempty"`
+	Pending    int        `json:"pending"`
+	LastSent   *time.Time `json:"lastSent,omitempty"`
+	LastError  string     `json:"lastError,omitempty"`
+	// Spooled and SpoolBytes measure the spool; Evicted counts the logs
+	// it dropped to stay within SpoolMaxBytes
+	Spooled    int   `json:"spooled,omitempty"`
+	SpoolBytes int64 `json:"spoolBytes,omitempty"`
+	Evicted    int64 `json:"evicted,omitempty"`
+}
+
+// outputRunner buffers the matching logs of one output and sends them in batches
+type outputRunner struct {
+	output      Output
+	unsubscribe func()
+	flush       chan struct{}
+	stop        chan struct{}
+	stopped     chan struct{}
+
+	sent, failed, dropped, summarized atomic.Int64
+
+	mu        sync.Mutex
+	pending   []LogEntry
+	lastSent  time.Time
+	lastError string
+
+	summaries summaryCounter
+	// levels ranks logs against AggregateBelow
+	levels *levelConfig
+
+	// spool is nil unless the output has SpoolMaxBytes
+	spool *outputSpool
+}
+
+// outputRunners holds the running outputs by ID
+type outputRunners struct {
+	mu   sync.Mutex
+	byID map[int64]*outputRunner
+}
+
+func createOutputTables(db *sql.DB) error {
+	_, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS outputs (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			name TEXT NOT NULL UNIQUE,
+			kind TEXT NOT NULL,
+			config TEXT NOT NULL,
+			created_at DATETIME NOT NULL
+		)
+	`)
+	return err
+}
+
+func (o *Output) validate(levels *levelConfig) error {
+	if !iacExternalID.MatchString(o.Name) {
+		return errors.New("name must be 1-128 letters, digits, '.', '_' or '-'")
+	}
+	if !strings.HasPrefix(o.URL, "http://") && !strings.HasPrefix(o.URL, "https://") {
+		return errors.New("url must be an http or https URL")
+	}
+	o.URL = strings.TrimRight(o.URL, "/")
+	switch o.Kind {
+	case outputLogger, outputHTTP:
+	case outputElasticsearch:
+		if o.Index == "" {
+			return errors.New("index is required for elasticsearch outputs")
+		}
+	case outputKafka:
+		if o.Topic == "" {
+			return errors.New("topic is required for kafka outputs")
+		}
+	case outputS3:
+		if o.Region == "" || o.AccessKey == "" || o.SecretKey == "" {
+			return errors.New("region, accessKey and secretKey are required for s3 outputs")
+		}
+	default:
+		return errors.New("kind must be logger, http, elasticsearch, kafka or s3")
+	}
+	if _, err := parseLogFilter(o.Filter.values(), time.Now()); err != nil {
+		return fmt.Errorf("filter: %v", err)
+	}
+	var err error
+	if o.AggregateBelow, err = levels.parseSummaryLevel(o.AggregateBelow); err != nil {
+		return err
+	}
+	if o.AggregateBelow != "" && o.Kind != outputLogger {
+		return errors.New("aggregateBelow is only supported by logger outputs")
+	}
+	if o.BatchSize == 0 {
+		o.BatchSize = defaultOutputBatchSize
+	}
+	if o.BatchSize < 1 || o.BatchSize > maxOutputBatchSize {
+		return fmt.Errorf("batchSize must be between 1 and %d", maxOutputBatchSize)
+	}
+	if o.FlushSeconds == 0 {
+		o.FlushSeconds = defaultOutputFlushSeconds
+	}
+	if o.FlushSeconds < 1 {
+		return errors.New("flushSeconds must be positive")
+	}
+	if o.SpoolMaxBytes < 0 {
+		return errors.New("spoolMaxBytes must not be negative")
+	}
+	return nil
+}
+
+const outputColumns = `id, name, kind, config, created_at`
+
+func scanOutput(scan func(dest ...interface{}) error) (Output, error) {
+	var o Output
+	var config string
+	if err := scan(&o.ID, &o.Name, &o.Kind, &config, &o.CreatedAt); err != nil {
+		return o, err
+	}
+	return o, json.Unmarshal([]byte(config), &o.outputConfig)
+}
+
+func (d *Database) GetOutputs() ([]Output, error) {
+	rows, err := d.db.Query(`SELECT ` + outputColumns + ` FROM outputs ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	outputs := []Output{}
+	for rows.Next() {
+		o, err := scanOutput(rows.Scan)
+		if err != nil {
+			return nil, err
+		}
+		outputs = append(outputs, o)
+	}
+	return outputs, rows.Err()
+}
+
+// CreateOutput stores an output and starts forwarding to it
+func (d *Database) CreateOutput(o Output) (Output, error) {
+	config, _ := json.Marshal(o.outputConfig)
+	o.CreatedAt = d.now().UTC()
+	res, err := d.db.Exec(`INSERT INTO outputs (name, kind, config, created_at) VALUES (?, ?, ?, ?)`,
+		o.Name, o.Kind, string(config), o.CreatedAt)
+	if err != nil {
+		return o, err
+	}
+	if o.ID, err = res.LastInsertId(); err != nil {
+		return o, err
+	}
+	d.startOutput(o)
+	return o, nil
+}
+
+// DeleteOutput stops an output, sending what it has buffered, and removes
+// it with its spool
+func (d *Database) DeleteOutput(id int64) error {
+	d.stopOutput(id)
+	if _, err := d.db.Exec(`DELETE FROM outputs WHERE id = ?`, id); err != nil {
+		return err
+	}
+	return os.RemoveAll(d.spoolDir(id))
+}
+
+// startOutputs starts forwarding to every stored output
+func (d *Database) startOutputs() error {
+	outputs, err := d.GetOutputs()
+	if err != nil {
+		return err
+	}
+	for _, o := range outputs {
+		d.startOutput(o)
+	}
+	return nil
+}
+
+func (d *Database) startOutput(o Output) {
+	r := &outputRunner{
+		output:  o,
+		levels:  &d.levels,
+		flush:   make(chan struct{}, 1),
+		stop:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+	if o.SpoolMaxBytes > 0 {
+		var err error
+		if r.spool, err = openOutputSpool(d.spoolDir(o.ID), o.SpoolMaxBytes); err != nil {
+			log.Printf("output %s: spooling disabled: %v", o.Name, err)
+		}
+	}
+	r.unsubscribe = d.bus.SubscribeAsync(topicLogs, "output:"+o.Name, outputBufferSize, r.receive)
+	d.outputs.mu.Lock()
+	if d.outputs.byID == nil {
+		d.outputs.byID = make(map[int64]*outputRunner)
+	}
+	d.outputs.byID[o.ID] = r
+	d.outputs.mu.Unlock()
+	go r.run()
+}
+
+func (d *Database) stopOutput(id int64) {
+	d.outputs.mu.Lock()
+	r := d.outputs.byID[id]
+	delete(d.outputs.byID, id)
+	d.outputs.mu.Unlock()
+	if r != nil {
+		r.unsubscribe()
+		close(r.stop)
+		<-r.stopped
+	}
+}
+
+// stopOutputs stops every output, sending what they have buffered
+func (d *Database) stopOutputs() {
+	d.outputs.mu.Lock()
+	var ids []int64
+	for id := range d.outputs.byID {
+		ids = append(ids, id)
+	}
+	d.outputs.mu.Unlock()
+	for _, id := range ids {
+		d.stopOutput(id)
+	}
+}
+
+func (d *Database) outputStatus(id int64) *OutputStatus {
+	d.outputs.mu.Lock()
+	r := d.outputs.byID[id]
+	d.outputs.mu.Unlock()
+	if r == nil {
+		return nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	status := &OutputStatus{
+		Sent:       r.sent.Load(),
+		Failed:     r.failed.Load(),
+		Dropped:    r.dropped.Load(),
+		Summarized: r.summarized.Load(),
+		Pending:    len(r.pending),
+		LastError:  r.lastError,
+	}
+	if r.spool != nil {
+		status.Spooled, status.SpoolBytes = r.spool.stats()
+		status.Evicted = r.spool.evicted.Load()
+	}
+	if !r.lastSent.IsZero() {
+		t := r.lastSent
+		status.LastSent = &t
+	}
+	return status
+}
+
+// receive buffers a log from the bus that its route and the output's
+// filter let through, dropping it when the buffer is full
+func (r *outputRunner) receive(msg interface{}) {
+	m := msg.(*IngestedLog)
+	if !m.forwardsTo(r.output.Name) || !r.output.Filter.matches(m.Entry) {
+		return
+	}
+	entry := m.Entry
+	if r.output.AggregateBelow != "" && r.levels.severity(entry.Level) < r.levels.severity(r.output.AggregateBelow) {
+		r.summaries.add(entry)
+		r.summarized.Add(1)
+		return
+	}
+	r.mu.Lock()
+	if len(r.pending) >= outputBufferSize {
+		if r.spool == nil {
+			r.mu.Unlock()
+			r.dropped.Add(1)
+			return
+		}
+		// Make room by spooling the oldest batch
+		n := r.output.BatchSize
+		if err := r.spool.push(r.pending[:n]); err != nil {
+			r.mu.Unlock()
+			r.dropped.Add(1)
+			log.Printf("output %s: failed to spool: %v", r.output.Name, err)
+			return
+		}
+		r.pending = append(r.pending[:0], r.pending[n:]...)
+	}
+	r.pending = append(r.pending, entry)
+	full := len(r.pending) >= r.output.BatchSize
+	r.mu.Unlock()
+	if full {
+		select {
+		case r.flush <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (r *outputRunner) run() {
+	defer close(r.stopped)
+	ticker := time.NewTicker(time.Duration(r.output.FlushSeconds) * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			r.sendPending(outputMaxAttempts)
+		case <-r.flush:
+			r.sendPending(outputMaxAttempts)
+		case <-r.stop:
+			r.sendPending(1)
+			return
+		}
+	}
+}
+
+// sendPending sends the spooled batches, then the buffered logs batch by
+// batch, then the summaries. With a spool, a batch that cannot be sent is
+// spooled rather than dropped, and so is the rest of the buffer when the
+// output is stopping.
+func (r *outputRunner) sendPending(attempts int) {
+	stopping := attempts == 1
+	if r.spool != nil && !r.sendSpooled(attempts) {
+		if stopping {
+			r.spoolPending()
+		}
+		return
+	}
+	for {
+		r.mu.Lock()
+		n := len(r.pending)
+		if n > r.output.BatchSize {
+			n = r.output.BatchSize
+		}
+		batch := r.pending[:n:n]
+		r.pending = r.pending[n:]
+		r.mu.Unlock()
+		if len(batch) == 0 {
+			break
+		}
+		if r.spool == nil {
+			if !r.deliver(len(batch), attempts, func() error { return r.output.send(batch) }) {
+				return
+			}
+			continue
+		}
+		if err := r.attempt(attempts, func() error { return r.output.send(batch) }); err != nil {
+			if err := r.spool.push(batch); err != nil {
+				r.failed.Add(int64(len(batch)))
+				log.Printf("output %s: dropped %d logs that could not be spooled: %v", r.output.Name, len(batch), err)
+			}
+			if stopping {
+				r.spoolPending()
+			}
+			return
+		}
+		r.sent.Add(int64(len(batch)))
+	}
+	if summaries := r.summaries.take(edgeHost); len(summaries) > 0 {
+		body, _ := json.Marshal(summaries)
+		r.deliver(0, attempts, func() error {
+			return r.output.post(r.output.URL+"/api/ingest/summaries", "application/json", body, nil)
+		})
+	}
+}
+
+// sendSpooled sends the spooled batches, oldest first, and reports whether
+// the spool was emptied
+func (r *outputRunner) sendSpooled(attempts int) bool {
+	for {
+		b, batch, ok, err := r.spool.oldest()
+		if !ok {
+			return true
+		}
+		if err != nil {
+			r.failed.Add(int64(b.logs))
+			log.Printf("output %s: dropped an unreadable spooled batch of %d logs: %v", r.output.Name, b.logs, err)
+			r.spool.remove(b)
+			continue
+		}
+		if len(batch) > 0 {
+			if err := r.attempt(attempts, func() error { return r.output.send(batch) }); err != nil {
+				return false
+			}
+			r.sent.Add(int64(len(batch)))
+		}
+		r.spool.remove(b)
+	}
+}
+
+// spoolPending spools the whole buffer
+func (r *outputRunner) spoolPending() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for len(r.pending) > 0 {
+		n := min(len(r.pending), r.output.BatchSize)
+		if err := r.spool.push(r.pending[:n]); err != nil {
+			r.failed.Add(int64(len(r.pending)))
+			log.Printf("output %s: dropped %d logs that could not be spooled: %v", r.output.Name, len(r.pending), err)
+			r.pending = nil
+			return
+		}
+		r.pending = r.pending[n:]
+	}
+}
+
+// attempt calls send until it succeeds or attempts run out, recording the
+// outcome
+func (r *outputRunner) attempt(attempts int, send func() error) error {
+	err := r.retry(attempts, send)
+	r.mu.Lock()
+	if err != nil {
+		r.lastError = err.Error()
+	} else {
+		r.lastSent = time.Now().UTC()
+	}
+	r.mu.Unlock()
+	return err
+}
+
+// deliver calls send until it succeeds or attempts run out, recording the
+// outcome for count logs; it reports whether send succeeded
+func (r *outputRunner) deliver(count, attempts int, send func() error) bool {
+	err := r.attempt(attempts, send)
+	if err != nil {
+		r.failed.Add(int64(count))
+		log.Printf("output %s: dropped %d logs after %d attempts: %v", r.output.Name, count, attempts, err)
+		return false
+	}
+	r.sent.Add(int64(count))
+	return true
+}
+
+func (r *outputRunner) retry(attempts int, send func() error) error {
+	backoff := outputInitialBackoff
+	var err error
+	for attempt := 1; attempt <= attempts; attempt++ {
+		if err = send(); err == nil {
+			return nil
+		}
+		if attempt == attempts {
+			break
+		}
+		select {
+		case <-time.After(backoff):
+		case <-r.stop:
+			// Stopping: make one last attempt without waiting
+			attempts = attempt + 1
+		}
+		backoff *= 2
+	}
+	return err
+}
+
+// send delivers one batch to the sink
+func (o Output) send(batch []LogEntry) error {
+	for i := range batch {
+		// The receiver assigns its own IDs
+		batch[i].ID = 0
+	}
+	switch o.Kind {
+	case outputLogger:
+		for _, entry := range batch {
+			body, _ := json.Marshal(entry)
+			if err := o.post(o.URL+"/api/logs", "application/json", body, nil); err != nil {
+				return err
+			}
+		}
+		return nil
+	case outputHTTP:
+		body, _ := json.Marshal(batch)
+		return o.post(o.URL, "application/json", body, nil)
+	case outputElasticsearch:
+		var buf bytes.Buffer
+		action, _ := json.Marshal(map[string]interface{}{"index": map[string]string{"_index": o.Index}})
+		for _, entry := range batch {
+			doc, _ := json.Marshal(entry)
+			buf.Write(action)
+			buf.WriteByte('\n')
+			buf.Write(doc)
+			buf.WriteByte('\n')
+		}
+		var result struct {
+			Errors bool `json:"errors"`
+		}
+		if err := o.post(o.URL+"/_bulk", "application/x-ndjson", buf.Bytes(), &result); err != nil {
+			return err
+		}
+		if result.Errors {
+			return errors.New("elasticsearch rejected some documents")
+		}
+		return nil
+	case outputKafka:
+		records := make([]map[string]LogEntry, len(batch))
+		for i, entry := range batch {
+			records[i] = map[string]LogEntry{"value": entry}
+		}
+		body, _ := json.Marshal(map[string]interface{}{"records": records})
+		return o.post(o.URL+"/topics/"+o.Topic, "application/vnd.kafka.json.v2+json", body, nil)
+	case outputS3:
+		var buf bytes.Buffer
+		enc := json.NewEncoder(&buf)
+		for _, entry := range batch {
+			enc.Encode(entry)
+		}
+		now := time.Now().UTC()
+		key := o.Prefix + now.Format("2006/01/02/150405") + "-" + randomHex(4) + ".ndjson"
+		req, err := http.NewRequest(http.MethodPut, o.URL+"/"+key, bytes.NewReader(buf.Bytes()))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "application/x-ndjson")
+		signS3(req, buf.Bytes(), o.Region, o.AccessKey, o.SecretKey, now)
+		return o.do(req, nil)
+	}
+	return fmt.Errorf("unknown output kind %q", o.Kind)
+}
+
+func (o Output) post(url, contentType string, body []byte, out interface{}) error {
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", contentType)
+	return o.do(req, out)
+}
+
+// do sends req with the output's headers and decodes a JSON response into out
+func (o Output) do(req *http.Request, out interface{}) error {
+	for k, v := range o.Headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := notifyClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	if out != nil {
+		return json.NewDecoder(resp.Body).Decode(out)
+	}
+	return nil
+}
+
+func sha256Hex(b []byte) string {
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// signS3 adds an AWS Signature Version 4 Authorization header to req
+func signS3(req *http.Request, body []byte, region, accessKey, secretKey string, now time.Time) {
+	payloadHash := sha256Hex(body)
+	amzDate := now.UTC().Format("20060102T150405Z")
+	date := amzDate[:8]
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	const signedHeaders = "host;x-amz-content-sha256;x-amz-date"
+	canonical := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		"host:" + req.URL.Host + "\nx-amz-content-sha256:" + payloadHash + "\nx-amz-date:" + amzDate + "\n",
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+	scope := date + "/" + region + "/s3/aws4_request"
+	toSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + sha256Hex([]byte(canonical))
+
+	key := hmacSHA256([]byte("AWS4"+secretKey), date)
+	for _, part := range []string{region, "s3", "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, toSign))
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+accessKey+"/"+scope+
+		", SignedHeaders="+signedHeaders+", Signature="+signature)
+}
+
+// GET/POST/DELETE /api/outputs - manage log forwarding outputs (admin only)
+func outputsHandlerDB(w http.ResponseWriter, r *http.Request, db *Database) {
+	enableCORS(w)
+	w.Header().Set("Content-Type", "application/json")
+	if !db.requireAdmin(w, r) {
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		outputs, err := db.GetOutputs()
+		if err != nil {
+			writeJSONError(w, http.StatusInternalServerError, "Failed to fetch outputs")
+			return
+		}
+		for i := range outputs {
+			// Secrets are write-only
+			outputs[i].SecretKey = ""
+			outputs[i].Status = db.outputStatus(outputs[i].ID)
+		}
+		json.NewEncoder(w).Encode(outputs)
+	case http.MethodPost:
+		var o Output
+		if err := json.NewDecoder(r.Body).Decode(&o); err != nil {
+			writeJSONError(w, http.StatusBadRequest, "Invalid JSON")
+			return
+		}
+		if err := o.validate(&db.levels); err != nil {
+			writeJSONError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		o, err := db.CreateOutput(o)
+		if err != nil {
+			writeJSONError(w, http.StatusConflict, "An output with that name already exists")
+			return
+		}
+		o.SecretKey = ""
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(o)
+	case http.MethodDelete:
+		id, err := strconv.ParseInt(r.URL.Query().Get("id"), 10, 64)
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, "Invalid id")
+			return
+		}
+		if err := db.DeleteOutput(id); err != nil {
+			writeJSONError(w, http.StatusInternalServerError, "Failed to delete output")
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+	}
+}
@@ -0,0 +1,89 @@
+package logserver
+
+import (
+	"net/http"
+	"sort"
+	"time"
+)
+
+// overviewRateMinutes is how many whole minutes the ingest rate averages
+const overviewRateMinutes = 5
+
+// Overview is everything a glance needs in one small payload, for mobile
+// clients and chat bots. It reads the dashboard counters and alert history,
+// never the logs table.
+type Overview struct {
+	Notables      map[string]int64 `json:"notables"`
+	OpenAlerts    int              `json:"openAlerts"`
+	TopRules      []RuleCount      `json:"topRules"`
+	LogsPerMinute float64          `json:"logsPerMinute"`
+	At            time.Time        `json:"at"`
+}
+
+// RuleCount is a rule and how many logs it has
+type RuleCount struct {
+	Rule   string `json:"rule"`
+	Count  int64  `json:"count"`
+	Search string `json:"search,omitempty"`
+}
+
+// GetOverview builds the overview. Open alerts are firing, unresolved and
+// not muted, so acknowledged and snoozed ones are left out. The rate is the
+// average over the last overviewRateMinutes whole minutes, by log timestamp.
+func (d *Database) GetOverview() (Overview, error) {
+	overview := Overview{Notables: make(map[string]int64, len(counterCategories)), At: d.now().UTC()}
+	err := d.db.QueryRow(`SELECT COUNT(*) FROM alert_history WHERE status = ? AND resolved_at IS NULL AND muted = 0`,
+		alertStatusFiring).Scan(&overview.OpenAlerts)
+	if err != nil {
+		return overview, err
+	}
+
+	categories := d.counters.snapshot(d.counters.categories)
+	for _, category := range counterCategories {
+		overview.Notables[category] = categories[category]
+	}
+	for rule, count := range d.counters.snapshot(d.counters.rules) {
+		overview.TopRules = append(overview.TopRules, RuleCount{Rule: rule, Count: count})
+	}
+	sort.Slice(overview.TopRules, func(i, j int) bool {
+		a, b := overview.TopRules[i], overview.TopRules[j]
+		return a.Count > b.Count || a.Count == b.Count && a.Rule < b.Rule
+	})
+	if len(overview.TopRules) > 3 {
+		overview.TopRules = overview.TopRules[:3]
+	}
+	for i := range overview.TopRules {
+		overview.TopRules[i].Search = ruleSearch(overview.TopRules[i].Rule)
+	}
+	if overview.TopRules == nil {
+		overview.TopRules = []RuleCount{}
+	}
+
+	var logs int64
+	now := overview.At.Unix() / 60
+	d.counters.mu.RLock()
+	for minute := now - overviewRateMinutes; minute < now; minute++ {
+		for _, n := range d.counters.minute(minute).categories {
+			logs += n
+		}
+	}
+	d.counters.mu.RUnlock()
+	overview.LogsPerMinute = float64(logs) / overviewRateMinutes
+	return overview, nil
+}
+
+// GET /api/overview - summary tiles, open alerts, top rules and ingest rate
+func overviewHandlerDB(w http.ResponseWriter, r *http.Request, db *Database) {
+	enableCORS(w)
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != http.MethodGet {
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	overview, err := db.GetOverview()
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "Failed to fetch overview")
+		return
+	}
+	writeEncoded(w, r, overview)
+}
@@ -0,0 +1,352 @@
+package logserver
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"io"
+	"strings"
+)
+
+// A minimal Parquet writer for flat schemas of required columns: PLAIN
+// encoded, gzip compressed, one data page per column chunk. The footer is
+// Thrift compact protocol, written by compactWriter below.
+
+// Parquet physical types, converted types and other enums used by the writer
+const (
+	parquetInt32     = 1
+	parquetInt64     = 2
+	parquetByteArray = 6
+
+	parquetConvertedNone            = -1
+	parquetConvertedUTF8            = 0
+	parquetConvertedTimestampMicros = 10
+
+	parquetEncodingPlain = 0
+	parquetEncodingRLE   = 3
+	parquetCodecGzip     = 2
+	parquetDataPage      = 0
+)
+
+var parquetMagic = []byte("PAR1")
+
+// parquetColumn is one column of a parquet schema; value appends a row's PLAIN-encoded value
+type parquetColumn struct {
+	name      string
+	kind      int32
+	converted int32
+	value     func(buf *bytes.Buffer, l LogEntry)
+}
+
+func parquetInt32Value(get func(LogEntry) int) func(*bytes.Buffer, LogEntry) {
+	return func(buf *bytes.Buffer, l LogEntry) {
+		binary.Write(buf, binary.LittleEndian, int32(get(l)))
+	}
+}
+
+func parquetStringValue(get func(LogEntry) string) func(*bytes.Buffer, LogEntry) {
+	return func(buf *bytes.Buffer, l LogEntry) {
+		s := get(l)
+		binary.Write(buf, binary.LittleEndian, uint32(len(s)))
+		buf.WriteString(s)
+	}
+}
+
+// logParquetColumns is the export schema. Timestamps are UTC microseconds and
+// tags are comma-separated, which is unambiguous since tags cannot contain commas.
+var logParquetColumns = []parquetColumn{
+	{"id", parquetInt64, parquetConvertedNone, func(buf *bytes.Buffer, l LogEntry) {
+		binary.Write(buf, binary.LittleEndian, l.ID)
+	}},
+	{"timestamp", parquetInt64, parquetConvertedTimestampMicros, func(buf *bytes.Buffer, l LogEntry) {
+		binary.Write(buf, binary.LittleEndian, l.Timestamp.UnixMicro())
+	}},
+	{"level", parquetByteArray, parquetConvertedUTF8, parquetStringValue(func(l LogEntry) string { return l.Level })},
+	{"urgency", parquetInt32, parquetConvertedNone, parquetInt32Value(func(l LogEntry) int { return l.Urgency })},
+	{"rule", parquetByteArray, parquetConvertedUTF8, parquetStringValue(func(l LogEntry) string { return l.Rule })},
+	{"category", parquetByteArray, parquetConvertedUTF8, parquetStringValue(LogEntry.category)},
+	{"source_ip", parquetByteArray, parquetConvertedUTF8, parquetStringValue(func(l LogEntry) string { return l.SourceIP })},
+	{"source_port", parquetInt32, parquetConvertedNone, parquetInt32Value(func(l LogEntry) int { return l.SourcePort })},
+	{"destination_ip", parquetByteArray, parquetConvertedUTF8, parquetStringValue(func(l LogEntry) string { return l.DestinationIP })},
+	{"destination_port", parquetInt32, parquetConvertedNone, parquetInt32Value(func(l LogEntry) int { return l.DestinationPort })},
+	{"user", parquetByteArray, parquetConvertedUTF8, parquetStringValue(func(l LogEntry) string { return l.User })},
+	{"trace_id", parquetByteArray, parquetConvertedUTF8, parquetStringValue(func(l LogEntry) string { return l.TraceID })},
+	{"event", parquetByteArray, parquetConvertedUTF8, parquetStringValue(func(l LogEntry) string { return l.Event })},
+	{"description", parquetByteArray, parquetConvertedUTF8, parquetStringValue(func(l LogEntry) string { return l.Description })},
+	{"tags", parquetByteArray, parquetConvertedUTF8, parquetStringValue(func(l LogEntry) string { return strings.Join(l.Tags, ",") })},
+}
+
+// parquetChunk records where a column chunk was written, for the footer
+type parquetChunk struct {
+	offset           int64
+	uncompressedSize int64
+	compressedSize   int64
+	numValues        int64
+}
+
+type parquetRowGroup struct {
+	chunks  []parquetChunk
+	numRows int64
+}
+
+// parquetWriter streams row groups to w and writes the footer on close
+type parquetWriter struct {
+	w         io.Writer
+	columns   []parquetColumn
+	offset    int64
+	rowGroups []parquetRowGroup
+}
+
+func newParquetWriter(w io.Writer, columns []parquetColumn) (*parquetWriter, error) {
+	p := &parquetWriter{w: w, columns: columns}
+	return p, p.write(parquetMagic)
+}
+
+func (p *parquetWriter) write(b []byte) error {
+	n, err := p.w.Write(b)
+	p.offset += int64(n)
+	return err
+}
+
+// writeRowGroup writes logs as one row group
+func (p *parquetWriter) writeRowGroup(logs []LogEntry) error {
+	if len(logs) == 0 {
+		return nil
+	}
+	group := parquetRowGroup{numRows: int64(len(logs))}
+	var values, compressed bytes.Buffer
+	for _, col := range p.columns {
+		values.Reset()
+		compressed.Reset()
+		for _, l := range logs {
+			col.value(&values, l)
+		}
+		gz := gzip.NewWriter(&compressed)
+		gz.Write(values.Bytes())
+		if err := gz.Close(); err != nil {
+			return err
+		}
+
+		header := newCompactWriter()
+		header.i32(1, parquetDataPage)
+		header.i32(2, int32(values.Len()))
+		header.i32(3, int32(compressed.Len()))
+		header.beginStruct(5)
+		header.i32(1, int32(len(logs)))
+		header.i32(2, parquetEncodingPlain)
+		header.i32(3, parquetEncodingRLE)
+		header.i32(4, parquetEncodingRLE)
+		header.endStruct()
+		header.end()
+
+		chunk := parquetChunk{
+			offset:           p.offset,
+			uncompressedSize: int64(header.buf.Len() + values.Len()),
+			compressedSize:   int64(header.buf.Len() + compressed.Len()),
+			numValues:        int64(len(logs)),
+		}
+		if err := p.write(header.buf.Bytes()); err != nil {
+			return err
+		}
+		if err := p.write(compressed.Bytes()); err != nil {
+			return err
+		}
+		group.chunks = append(group.chunks, chunk)
+	}
+	p.rowGroups = append(p.rowGroups, group)
+	return nil
+}
+
+// close writes the file metadata footer
+func (p *parquetWriter) close() error {
+	var numRows int64
+	for _, g := range p.rowGroups {
+		numRows += g.numRows
+	}
+	meta := newCompactWriter()
+	meta.i32(1, 1)
+	meta.beginList(2, compactStruct, len(p.columns)+1)
+	meta.beginElement()
+	meta.binary(4, []byte("schema"))
+	meta.i32(5, int32(len(p.columns)))
+	meta.endElement()
+	for _, col := range p.columns {
+		meta.beginElement()
+		meta.i32(1, col.kind)
+		meta.i32(3, 0) // REQUIRED
+		meta.binary(4, []byte(col.name))
+		if col.converted != parquetConvertedNone {
+			meta.i32(6, col.converted)
+		}
+		switch col.converted {
+		case parquetConvertedUTF8:
+			meta.beginStruct(10)
+			meta.beginStruct(1) // STRING
+			meta.endStruct()
+			meta.endStruct()
+		case parquetConvertedTimestampMicros:
+			meta.beginStruct(10)
+			meta.beginStruct(8) // TIMESTAMP
+			meta.boolean(1, true)
+			meta.beginStruct(2)
+			meta.beginStruct(2) // MICROS
+			meta.endStruct()
+			meta.endStruct()
+			meta.endStruct()
+			meta.endStruct()
+		}
+		meta.endElement()
+	}
+	meta.i64(3, numRows)
+	meta.beginList(4, compactStruct, len(p.rowGroups))
+	for _, g := range p.rowGroups {
+		var totalSize int64
+		meta.beginElement()
+		meta.beginList(1, compactStruct, len(g.chunks))
+		for i, c := range g.chunks {
+			totalSize += c.uncompressedSize
+			meta.beginElement()
+			meta.i64(2, c.offset)
+			meta.beginStruct(3)
+			meta.i32(1, p.columns[i].kind)
+			meta.beginList(2, compactI32, 2)
+			meta.listI32(parquetEncodingPlain)
+			meta.listI32(parquetEncodingRLE)
+			meta.beginList(3, compactBinary, 1)
+			meta.listBinary([]byte(p.columns[i].name))
+			meta.i32(4, parquetCodecGzip)
+			meta.i64(5, c.numValues)
+			meta.i64(6, c.uncompressedSize)
+			meta.i64(7, c.compressedSize)
+			meta.i64(9, c.offset)
+			meta.endStruct()
+			meta.endElement()
+		}
+		meta.i64(2, totalSize)
+		meta.i64(3, g.numRows)
+		meta.endElement()
+	}
+	meta.binary(6, []byte("logger-backend"))
+	meta.end()
+
+	if err := p.write(meta.buf.Bytes()); err != nil {
+		return err
+	}
+	var length [4]byte
+	binary.LittleEndian.PutUint32(length[:], uint32(meta.buf.Len()))
+	if err := p.write(length[:]); err != nil {
+		return err
+	}
+	return p.write(parquetMagic)
+}
+
+// Thrift compact protocol type ids
+const (
+	compactTrue   = 1
+	compactFalse  = 2
+	compactI32    = 5
+	compactI64    = 6
+	compactBinary = 8
+	compactList   = 9
+	compactStruct = 12
+)
+
+// compactWriter encodes a Thrift struct with the compact protocol. Fields
+// must be written in increasing id order within each struct; elements of a
+// struct list are bracketed by beginElement and endElement, and the
+// top-level struct is finished with end.
+type compactWriter struct {
+	buf bytes.Buffer
+	// lastField holds the previous field id of each open struct, innermost last
+	lastField []int16
+}
+
+func newCompactWriter() *compactWriter {
+	return &compactWriter{lastField: []int16{0}}
+}
+
+func (c *compactWriter) fieldHeader(id int16, kind byte) {
+	last := &c.lastField[len(c.lastField)-1]
+	if delta := id - *last; delta > 0 && delta <= 15 {
+		c.buf.WriteByte(byte(delta)<<4 | kind)
+	} else {
+		c.buf.WriteByte(kind)
+		c.zigzag(int64(id))
+	}
+	*last = id
+}
+
+func (c *compactWriter) varint(v uint64) {
+	var b [binary.MaxVarintLen64]byte
+	c.buf.Write(b[:binary.PutUvarint(b[:], v)])
+}
+
+func (c *compactWriter) zigzag(v int64) {
+	c.varint(uint64(v<<1) ^ uint64(v>>63))
+}
+
+func (c *compactWriter) i32(id int16, v int32) {
+	c.fieldHeader(id, compactI32)
+	c.zigzag(int64(v))
+}
+
+func (c *compactWriter) i64(id int16, v int64) {
+	c.fieldHeader(id, compactI64)
+	c.zigzag(v)
+}
+
+// boolean fields carry their value in the field header
+func (c *compactWriter) boolean(id int16, v bool) {
+	if v {
+		c.fieldHeader(id, compactTrue)
+	} else {
+		c.fieldHeader(id, compactFalse)
+	}
+}
+
+func (c *compactWriter) binary(id int16, b []byte) {
+	c.fieldHeader(id, compactBinary)
+	c.listBinary(b)
+}
+
+func (c *compactWriter) beginStruct(id int16) {
+	c.fieldHeader(id, compactStruct)
+	c.beginElement()
+}
+
+func (c *compactWriter) endStruct() {
+	c.endElement()
+}
+
+func (c *compactWriter) beginElement() {
+	c.lastField = append(c.lastField, 0)
+}
+
+func (c *compactWriter) endElement() {
+	c.buf.WriteByte(0)
+	c.lastField = c.lastField[:len(c.lastField)-1]
+}
+
+// end finishes the top-level struct
+func (c *compactWriter) end() {
+	c.buf.WriteByte(0)
+}
+
+func (c *compactWriter) beginList(id int16, elem byte, n int) {
+	c.fieldHeader(id, compactList)
+	if n < 15 {
+		c.buf.WriteByte(byte(n)<<4 | elem)
+	} else {
+		c.buf.WriteByte(0xf0 | elem)
+		c.varint(uint64(n))
+	}
+}
+
+func (c *compactWriter) listI32(v int32) {
+	c.zigzag(int64(v))
+}
+
+func (c *compactWriter) listBinary(b []byte) {
+	c.varint(uint64(len(b)))
+	c.buf.Write(b)
+}
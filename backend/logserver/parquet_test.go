@@ -0,0 +1,372 @@
+package logserver
+
+import (
+	"bytes"
+	"cmp"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// A minimal Parquet reader for the files parquetWriter produces, so the
+// writer is checked against the format rather than against itself. It
+// follows the footer's offsets and sizes the way a real reader does, and
+// decodes PLAIN pages of INT32, INT64 and BYTE_ARRAY columns.
+
+// thriftStruct is a decoded Thrift compact struct, by field id. Values are
+// int64, bool, []byte, []interface{} or thriftStruct.
+type thriftStruct map[int16]interface{}
+
+type compactReader struct {
+	b   []byte
+	pos int
+}
+
+var errCompactTruncated = errors.New("truncated thrift data")
+
+func (c *compactReader) byte() byte {
+	if c.pos >= len(c.b) {
+		panic(errCompactTruncated)
+	}
+	c.pos++
+	return c.b[c.pos-1]
+}
+
+func (c *compactReader) varint() uint64 {
+	v, n := binary.Uvarint(c.b[c.pos:])
+	if n <= 0 {
+		panic(errCompactTruncated)
+	}
+	c.pos += n
+	return v
+}
+
+func (c *compactReader) zigzag() int64 {
+	v := c.varint()
+	return int64(v>>1) ^ -int64(v&1)
+}
+
+func (c *compactReader) value(kind byte) interface{} {
+	switch kind {
+	case compactTrue:
+		return true
+	case compactFalse:
+		return false
+	case compactI32, compactI64:
+		return c.zigzag()
+	case compactBinary:
+		n := int(c.varint())
+		if n > len(c.b)-c.pos {
+			panic(errCompactTruncated)
+		}
+		c.pos += n
+		return c.b[c.pos-n : c.pos]
+	case compactList:
+		header := c.byte()
+		n, elem := int(header>>4), header&0x0f
+		if n == 15 {
+			n = int(c.varint())
+		}
+		list := []interface{}{}
+		for i := 0; i < n; i++ {
+			if elem == compactTrue || elem == compactFalse {
+				// Booleans in a list take a byte each
+				list = append(list, c.byte() == compactTrue)
+				continue
+			}
+			list = append(list, c.value(elem))
+		}
+		return list
+	case compactStruct:
+		return c.structure()
+	}
+	panic(fmt.Errorf("unsupported thrift type %d", kind))
+}
+
+func (c *compactReader) structure() thriftStruct {
+	s := thriftStruct{}
+	var last int16
+	for {
+		header := c.byte()
+		if header == 0 {
+			return s
+		}
+		kind := header & 0x0f
+		id := last + int16(header>>4)
+		if header>>4 == 0 {
+			id = int16(c.zigzag())
+		}
+		s[id] = c.value(kind)
+		last = id
+	}
+}
+
+// readCompactStruct decodes the struct at the start of b and returns it
+// with its encoded length
+func readCompactStruct(b []byte) (s thriftStruct, n int, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%v", r)
+		}
+	}()
+	c := &compactReader{b: b}
+	s = c.structure()
+	return s, c.pos, nil
+}
+
+// parquetFile is a read-back file: its column names in schema order and
+// every column's values across row groups
+type parquetFile struct {
+	columns []string
+	numRows int64
+	values  map[string][]interface{}
+}
+
+func readParquet(data []byte) (parquetFile, error) {
+	file := parquetFile{values: map[string][]interface{}{}}
+	if len(data) < 12 || !bytes.Equal(data[:4], parquetMagic) || !bytes.Equal(data[len(data)-4:], parquetMagic) {
+		return file, errors.New("missing PAR1 magic")
+	}
+	footerLen := int(binary.LittleEndian.Uint32(data[len(data)-8:]))
+	if footerLen > len(data)-12 {
+		return file, errors.New("footer length out of range")
+	}
+	meta, n, err := readCompactStruct(data[len(data)-8-footerLen : len(data)-8])
+	if err != nil {
+		return file, fmt.Errorf("footer: %v", err)
+	}
+	if n != footerLen {
+		return file, fmt.Errorf("footer is %d bytes, its length says %d", n, footerLen)
+	}
+	file.numRows = meta[3].(int64)
+	kinds := map[string]int64{}
+	for _, element := range meta[2].([]interface{})[1:] {
+		el := element.(thriftStruct)
+		name := string(el[4].([]byte))
+		file.columns = append(file.columns, name)
+		kinds[name] = el[1].(int64)
+	}
+	for _, group := range meta[4].([]interface{}) {
+		rg := group.(thriftStruct)
+		for _, chunk := range rg[1].([]interface{}) {
+			cm := chunk.(thriftStruct)[3].(thriftStruct)
+			name := string(cm[3].([]interface{})[0].([]byte))
+			if cm[4].(int64) != parquetCodecGzip {
+				return file, fmt.Errorf("%s: codec %d", name, cm[4])
+			}
+			offset, size := cm[9].(int64), cm[7].(int64)
+			if offset+size > int64(len(data)) {
+				return file, fmt.Errorf("%s: chunk out of range", name)
+			}
+			chunkData := data[offset : offset+size]
+			page, n, err := readCompactStruct(chunkData)
+			if err != nil {
+				return file, fmt.Errorf("%s: page header: %v", name, err)
+			}
+			if int64(n)+page[3].(int64) != size {
+				return file, fmt.Errorf("%s: page is %d bytes, its chunk %d", name, int64(n)+page[3].(int64), size)
+			}
+			gz, err := gzip.NewReader(bytes.NewReader(chunkData[n:]))
+			if err != nil {
+				return file, fmt.Errorf("%s: %v", name, err)
+			}
+			plain, err := io.ReadAll(gz)
+			if err != nil {
+				return file, fmt.Errorf("%s: %v", name, err)
+			}
+			if int64(len(plain)) != page[2].(int64) {
+				return file, fmt.Errorf("%s: %d bytes uncompressed, header says %d", name, len(plain), page[2])
+			}
+			count := page[5].(thriftStruct)[1].(int64)
+			if count != rg[3].(int64) || count != cm[5].(int64) {
+				return file, fmt.Errorf("%s: %d values in a row group of %d rows", name, count, rg[3])
+			}
+			values, err := decodePlain(plain, kinds[name], count)
+			if err != nil {
+				return file, fmt.Errorf("%s: %v", name, err)
+			}
+			file.values[name] = append(file.values[name], values...)
+		}
+	}
+	return file, nil
+}
+
+func decodePlain(b []byte, kind, count int64) ([]interface{}, error) {
+	values := []interface{}{}
+	for i := int64(0); i < count; i++ {
+		switch kind {
+		case parquetInt32:
+			if len(b) < 4 {
+				return nil, errCompactTruncated
+			}
+			values = append(values, int64(int32(binary.LittleEndian.Uint32(b))))
+			b = b[4:]
+		case parquetInt64:
+			if len(b) < 8 {
+				return nil, errCompactTruncated
+			}
+			values = append(values, int64(binary.LittleEndian.Uint64(b)))
+			b = b[8:]
+		case parquetByteArray:
+			if len(b) < 4 || int(binary.LittleEndian.Uint32(b)) > len(b)-4 {
+				return nil, errCompactTruncated
+			}
+			n := int(binary.LittleEndian.Uint32(b))
+			values = append(values, string(b[4:4+n]))
+			b = b[4+n:]
+		default:
+			return nil, fmt.Errorf("unsupported type %d", kind)
+		}
+	}
+	if len(b) != 0 {
+		return nil, fmt.Errorf("%d bytes after the last value", len(b))
+	}
+	return values, nil
+}
+
+// parquetRow is how a log reads back from an export
+func parquetRow(l LogEntry) map[string]interface{} {
+	return map[string]interface{}{
+		"id":               l.ID,
+		"timestamp":        l.Timestamp.UnixMicro(),
+		"level":            l.Level,
+		"urgency":          int64(l.Urgency),
+		"rule":             l.Rule,
+		"category":         l.category(),
+		"source_ip":        l.SourceIP,
+		"source_port":      int64(l.SourcePort),
+		"destination_ip":   l.DestinationIP,
+		"destination_port": int64(l.DestinationPort),
+		"user":             l.User,
+		"trace_id":         l.TraceID,
+		"event":            l.Event,
+		"description":      l.Description,
+		"tags":             strings.Join(l.Tags, ","),
+	}
+}
+
+func expectParquetRows(t *testing.T, file parquetFile, logs []LogEntry) {
+	t.Helper()
+	if file.numRows != int64(len(logs)) {
+		t.Fatalf("file has %d rows, want %d", file.numRows, len(logs))
+	}
+	for i, l := range logs {
+		want := parquetRow(l)
+		got := map[string]interface{}{}
+		for _, name := range file.columns {
+			got[name] = file.values[name][i]
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("row %d = %v, want %v", i, got, want)
+		}
+	}
+}
+
+func TestParquetRoundTrip(t *testing.T) {
+	at := time.Date(2025, 1, 6, 9, 0, 0, 123456000, time.UTC)
+	logs := []LogEntry{
+		{ID: 1, Timestamp: at, Level: "ERROR", Rule: "Brute Force Login", SourceIP: "203.0.113.9", SourcePort: 52100,
+			DestinationIP: "10.0.0.5", DestinationPort: 22, User: "root", Event: "Failed login", Description: "Failed password", Urgency: 3,
+			TraceID: "4bf92f3577b34da6", Tags: []string{"ssh", "scanner"}},
+		{ID: 2, Timestamp: at.Add(time.Second), Level: "INFO", Event: "Café ünïcode ✓"},
+		// Every optional field empty
+		{ID: -3, Timestamp: time.Unix(0, 0).UTC()},
+	}
+	var buf bytes.Buffer
+	pw, err := newParquetWriter(&buf, logParquetColumns)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Two row groups and an empty one, which is skipped
+	for _, group := range [][]LogEntry{logs[:2], nil, logs[2:]} {
+		if err := pw.writeRowGroup(group); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := pw.close(); err != nil {
+		t.Fatal(err)
+	}
+
+	file, err := readParquet(buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	columns := make([]string, len(logParquetColumns))
+	for i, col := range logParquetColumns {
+		columns[i] = col.name
+	}
+	expect(t, "columns", file.columns, columns)
+	expectParquetRows(t, file, logs)
+}
+
+func TestParquetEmpty(t *testing.T) {
+	var buf bytes.Buffer
+	pw, err := newParquetWriter(&buf, logParquetColumns)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := pw.close(); err != nil {
+		t.Fatal(err)
+	}
+	file, err := readParquet(buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectParquetRows(t, file, nil)
+}
+
+// exportParquet runs GET /api/logs/export and returns the status and body
+func (h *harness) exportParquet(query string) (int, []byte) {
+	h.t.Helper()
+	req, err := http.NewRequest(http.MethodGet, h.url+"/api/logs/export?format=parquet&"+query, nil)
+	if err != nil {
+		h.t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer "+h.adminKey)
+	resp, err := h.server.Client().Do(req)
+	if err != nil {
+		h.t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		h.t.Fatal(err)
+	}
+	return resp.StatusCode, body
+}
+
+func TestExportParquetHandler(t *testing.T) {
+	h := newHarness(t)
+	h.ingest(
+		LogEntry{Level: "ERROR", SourceIP: "203.0.113.9", Event: "Failed login", Tags: []string{"ssh"}},
+		LogEntry{Level: "INFO", SourceIP: "10.0.0.1", Event: "Login"},
+		LogEntry{Level: "ERROR", SourceIP: "198.51.100.7", Event: "Disk full"},
+	)
+	status, body := h.exportParquet("level=ERROR")
+	expect(t, "status", status, http.StatusOK)
+	file, err := readParquet(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	logs := h.search(map[string][]string{"level": {"ERROR"}})
+	// The export is in ingestion order
+	slices.SortFunc(logs, func(a, b LogEntry) int { return cmp.Compare(a.ID, b.ID) })
+	expectParquetRows(t, file, logs)
+
+	// A failure part way through is an error, not a truncated file
+	if _, err := h.db.db.Exec(`DROP TABLE log_tags`); err != nil {
+		t.Fatal(err)
+	}
+	status, body = h.exportParquet("")
+	if status != http.StatusInternalServerError || !bytes.Contains(body, []byte("Failed to export logs")) {
+		t.Fatalf("export after a failure: %d %s", status, body)
+	}
+}
@@ -0,0 +1,132 @@
+package logserver
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Pivot is a pre-built related query around a log entry or notable
+type Pivot struct {
+	Name   string    `json:"name"`
+	Label  string    `json:"label"`
+	Filter LogFilter `json:"filter"`
+	From   time.Time `json:"from"`
+	To     time.Time `json:"to"`
+	Count  int       `json:"count"`
+	// Search is the /api/logs URL that runs the pivot
+	Search string `json:"search"`
+}
+
+// pivotAnchor is the event being investigated
+type pivotAnchor struct {
+	Kind        string    `json:"kind"` // log, notable
+	ID          int64     `json:"id"`
+	Timestamp   time.Time `json:"timestamp"`
+	SourceIP    string    `json:"sourceIP"`
+	Destination string    `json:"destination"`
+	Rule        string    `json:"rule"`
+}
+
+// Pivot windows, relative to the anchor's timestamp
+const (
+	pivotSourceWindow  = 30 * time.Minute
+	pivotHistoryWindow = 24 * time.Hour
+)
+
+// GetPivots returns the related queries for an anchor and how many logs each matches
+func (d *Database) GetPivots(a pivotAnchor) ([]Pivot, error) {
+	// The history windows include the anchor itself; to is exclusive
+	until := a.Timestamp.Add(time.Second)
+	var candidates []Pivot
+	if a.SourceIP != "" {
+		candidates = append(candidates, Pivot{
+			Name:   "same_source",
+			Label:  "Same source IP within 30 minutes",
+			Filter: LogFilter{Source: stringList{a.SourceIP}, From: a.Timestamp.Add(-pivotSourceWindow), To: a.Timestamp.Add(pivotSourceWindow)},
+		})
+	}
+	if a.Rule != "" {
+		candidates = append(candidates, Pivot{
+			Name:   "same_rule",
+			Label:  "Same rule in the preceding 24 hours",
+			Filter: LogFilter{Rule: stringList{a.Rule}, From: a.Timestamp.Add(-pivotHistoryWindow), To: until},
+		})
+	}
+	if a.Destination != "" {
+		candidates = append(candidates, Pivot{
+			Name:   "same_destination",
+			Label:  "Same destination in the preceding 24 hours",
+			Filter: LogFilter{Destination: stringList{a.Destination}, From: a.Timestamp.Add(-pivotHistoryWindow), To: until},
+		})
+	}
+
+	pivots := []Pivot{}
+	for _, p := range candidates {
+		// Search URLs carry RFC3339 seconds, so widen the window to whole seconds
+		p.Filter.From = p.Filter.From.Truncate(time.Second)
+		if to := p.Filter.To.Truncate(time.Second); to.Before(p.Filter.To) {
+			p.Filter.To = to.Add(time.Second)
+		}
+		count, err := d.CountLogs(p.Filter)
+		if err != nil {
+			return nil, err
+		}
+		p.Count = count
+		p.From, p.To = p.Filter.From.UTC(), p.Filter.To.UTC()
+		p.Search = searchURL(p.Filter)
+		pivots = append(pivots, p)
+	}
+	return pivots, nil
+}
+
+// GET /api/pivot?log={id} or /api/pivot?notable={id} - related queries and their counts
+func pivotHandlerDB(w http.ResponseWriter, r *http.Request, db *Database) {
+	enableCORS(w)
+	w.Header().Set("Content-Type", "application/json")
+	q := r.URL.Query()
+	var anchor pivotAnchor
+	var err error
+	switch {
+	case q.Get("log") != "":
+		anchor.Kind = "log"
+		if anchor.ID, err = strconv.ParseInt(q.Get("log"), 10, 64); err != nil {
+			writeJSONError(w, http.StatusBadRequest, "Invalid log id")
+			return
+		}
+		var entry LogEntry
+		if entry, err = db.GetLog(anchor.ID); err == nil {
+			anchor.Timestamp, anchor.SourceIP, anchor.Destination, anchor.Rule = entry.Timestamp, entry.SourceIP, entry.DestinationIP, entry.Rule
+		}
+	case q.Get("notable") != "":
+		anchor.Kind = "notable"
+		if anchor.ID, err = strconv.ParseInt(q.Get("notable"), 10, 64); err != nil {
+			writeJSONError(w, http.StatusBadRequest, "Invalid notable id")
+			return
+		}
+		var n NotableEvent
+		if n, err = db.GetNotable(anchor.ID); err == nil {
+			anchor.Timestamp, anchor.SourceIP, anchor.Destination, anchor.Rule = n.Timestamp, n.SourceIP, n.Destination, n.RuleName
+		}
+	default:
+		writeJSONError(w, http.StatusBadRequest, "log or notable is required")
+		return
+	}
+	if errors.Is(err, sql.ErrNoRows) {
+		writeJSONError(w, http.StatusNotFound, "Not found")
+		return
+	}
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "Failed to fetch "+anchor.Kind)
+		return
+	}
+
+	pivots, err := db.GetPivots(anchor)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "Failed to count pivots")
+		return
+	}
+	writeEncoded(w, r, map[string]interface{}{"anchor": anchor, "pivots": pivots})
+}
@@ -0,0 +1,173 @@
+package logserver
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Long polling: GET /api/logs/poll blocks until logs newer than after_id
+// are stored, for clients that cannot use a streaming connection.
+
+// Poll wait bounds and the default and maximum batch sizes
+const (
+	pollDefaultWait  = 30 * time.Second
+	pollMaxWait      = 60 * time.Second
+	pollDefaultLimit = 100
+	pollMaxLimit     = 1000
+)
+
+// logSignal wakes every waiting poll when logs are stored
+type logSignal struct {
+	mu sync.Mutex
+	ch chan struct{}
+}
+
+// wait returns a channel that is closed by the next notify
+func (s *logSignal) wait() <-chan struct{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.ch == nil {
+		s.ch = make(chan struct{})
+	}
+	return s.ch
+}
+
+func (s *logSignal) notify() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.ch != nil {
+		close(s.ch)
+		s.ch = nil
+	}
+}
+
+// LogsAfter returns up to limit logs matching the filter with an ID above
+// afterID, oldest first
+func (d *Database) LogsAfter(filter LogFilter, afterID int64, limit int) ([]LogEntry, error) {
+	clause, args := filter.where()
+	rows, err := d.db.Query(`SELECT `+logColumns+` FROM logs WHERE id > ?`+clause+` ORDER BY id LIMIT ?`,
+		append(append([]interface{}{afterID}, args...), limit)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return d.scanLogs(rows)
+}
+
+// waitForLogs returns the logs LogsAfter finds, waiting up to wait for the
+// first of them to be stored. It returns an empty list when none arrive in
+// time, and early when ctx is done.
+func (d *Database) waitForLogs(ctx context.Context, filter LogFilter, afterID int64, limit int, wait time.Duration) ([]LogEntry, error) {
+	timeout := time.NewTimer(wait)
+	defer timeout.Stop()
+	for {
+		// Take the signal before querying so a log stored in between still wakes us
+		stored := d.newLogs.wait()
+		logs, err := d.LogsAfter(filter, afterID, limit)
+		if err != nil || len(logs) > 0 {
+			return logs, err
+		}
+		select {
+		case <-stored:
+		case <-timeout.C:
+			return []LogEntry{}, nil
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// parsePollWait parses a wait parameter, defaulting to pollDefaultWait
+func parsePollWait(v string) (time.Duration, error) {
+	if v == "" {
+		return pollDefaultWait, nil
+	}
+	wait, err := time.ParseDuration(v)
+	if err != nil || wait < 0 || wait > pollMaxWait {
+		return 0, errors.New("wait must be a duration between 0s and 60s")
+	}
+	return wait, nil
+}
+
+// parsePollLimit parses a limit parameter, defaulting to pollDefaultLimit
+func parsePollLimit(v string) (int, error) {
+	if v == "" {
+		return pollDefaultLimit, nil
+	}
+	limit, err := strconv.Atoi(v)
+	if err != nil || limit < 1 || limit > pollMaxLimit {
+		return 0, errors.New("limit must be between 1 and 1000")
+	}
+	return limit, nil
+}
+
+// LatestLogID returns the highest stored log ID, or 0 when there are none
+func (d *Database) LatestLogID() (int64, error) {
+	var id int64
+	err := d.db.QueryRow(`SELECT COALESCE(MAX(id), 0) FROM logs`).Scan(&id)
+	return id, err
+}
+
+// GET /api/logs/poll?after_id=&wait=30s&limit= - wait for new logs
+// matching the search filters. Without after_id it waits for logs newer
+// than the latest one. The response's lastId is the after_id of the next poll.
+func logPollHandlerDB(w http.ResponseWriter, r *http.Request, db *Database) {
+	enableCORS(w)
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if r.Method != http.MethodGet {
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	q := r.URL.Query()
+	filter, err := parseLogFilter(q, db.now())
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	filter = scopedFilter(r, filter)
+	masks := requestMasks(r)
+	if param, ok := masks.revealingParam(q); ok {
+		writeMaskedParam(w, param)
+		return
+	}
+	wait, err := parsePollWait(q.Get("wait"))
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	limit, err := parsePollLimit(q.Get("limit"))
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	var afterID int64
+	if v := q.Get("after_id"); v != "" {
+		if afterID, err = strconv.ParseInt(v, 10, 64); err != nil || afterID < 0 {
+			writeJSONError(w, http.StatusBadRequest, "Invalid after_id")
+			return
+		}
+	} else if afterID, err = db.LatestLogID(); err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "Failed to poll logs")
+		return
+	}
+
+	logs, err := db.waitForLogs(r.Context(), filter, afterID, limit, wait)
+	if r.Context().Err() != nil {
+		return
+	}
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "Failed to poll logs")
+		return
+	}
+	lastID := afterID
+	if len(logs) > 0 {
+		lastID = logs[len(logs)-1].ID
+	}
+	masks.apply(logs)
+	writeEncoded(w, r, map[string]interface{}{"logs": logs, "lastId": lastID})
+}
@@ -0,0 +1,69 @@
+package logserver
+
+import (
+	"fmt"
+	"strings"
+)
+
+// logFields maps each projectable field, by its JSON name, to its value
+var logFields = map[string]func(LogEntry) interface{}{
+	"id":              func(l LogEntry) interface{} { return l.ID },
+	"timestamp":       func(l LogEntry) interface{} { return l.Timestamp },
+	"level":           func(l LogEntry) interface{} { return l.Level },
+	"rule":            func(l LogEntry) interface{} { return l.Rule },
+	"sourceIP":        func(l LogEntry) interface{} { return l.SourceIP },
+	"destinationIP":   func(l LogEntry) interface{} { return l.DestinationIP },
+	"event":           func(l LogEntry) interface{} { return l.Event },
+	"description":     func(l LogEntry) interface{} { return l.Description },
+	"urgency":         func(l LogEntry) interface{} { return l.Urgency },
+	"user":            func(l LogEntry) interface{} { return l.User },
+	"traceId":         func(l LogEntry) interface{} { return l.TraceID },
+	"sourcePort":      func(l LogEntry) interface{} { return l.SourcePort },
+	"destinationPort": func(l LogEntry) interface{} { return l.DestinationPort },
+	"category":        func(l LogEntry) interface{} { return l.category() },
+	"tags":            func(l LogEntry) interface{} { return l.Tags },
+	"annotations":     func(l LogEntry) interface{} { return l.Annotations },
+	"archive":         func(l LogEntry) interface{} { return l.Archive },
+}
+
+// parseFields validates a comma-separated fields parameter; an empty value selects all fields
+func parseFields(param string) ([]string, error) {
+	if param == "" {
+		return nil, nil
+	}
+	var fields []string
+	for _, f := range strings.Split(param, ",") {
+		f = strings.TrimSpace(f)
+		if _, ok := logFields[f]; !ok {
+			return nil, fmt.Errorf("Unknown field %q", f)
+		}
+		fields = append(fields, f)
+	}
+	return fields, nil
+}
+
+// hasField reports whether fields selects name; nil fields select everything
+func hasField(fields []string, name string) bool {
+	if fields == nil {
+		return true
+	}
+	for _, f := range fields {
+		if f == name {
+			return true
+		}
+	}
+	return false
+}
+
+// projectLogs keeps only the selected fields of each log
+func projectLogs(logs []LogEntry, fields []string) []map[string]interface{} {
+	projected := make([]map[string]interface{}, len(logs))
+	for i, l := range logs {
+		row := make(map[string]interface{}, len(fields))
+		for _, f := range fields {
+			row[f] = logFields[f](l)
+		}
+		projected[i] = row
+	}
+	return projected
+}
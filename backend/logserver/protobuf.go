@@ -0,0 +1,206 @@
+package logserver
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+)
+
+// A minimal protobuf decoder for the LogBatch message in proto/logger.proto,
+// so agents can send compact batches without a protobuf dependency here.
+// Unknown fields are skipped, as the protobuf spec requires.
+
+// contentTypeProtobuf selects protobuf ingest on POST /api/logs
+const contentTypeProtobuf = "application/x-protobuf"
+
+// Protobuf wire types
+const (
+	wireVarint  = 0
+	wireFixed64 = 1
+	wireBytes   = 2
+	wireFixed32 = 5
+)
+
+var errProtobufTruncated = errors.New("truncated protobuf message")
+
+// protoReader walks the fields of one protobuf message
+type protoReader struct {
+	buf []byte
+}
+
+func (p *protoReader) varint() (uint64, error) {
+	v, n := binary.Uvarint(p.buf)
+	if n <= 0 {
+		return 0, errProtobufTruncated
+	}
+	p.buf = p.buf[n:]
+	return v, nil
+}
+
+// next returns the next field's number and wire type
+func (p *protoReader) next() (int, int, error) {
+	key, err := p.varint()
+	if err != nil {
+		return 0, 0, err
+	}
+	return int(key >> 3), int(key & 7), nil
+}
+
+func (p *protoReader) bytes() ([]byte, error) {
+	n, err := p.varint()
+	if err != nil {
+		return nil, err
+	}
+	if n > uint64(len(p.buf)) {
+		return nil, errProtobufTruncated
+	}
+	b := p.buf[:n]
+	p.buf = p.buf[n:]
+	return b, nil
+}
+
+// skip discards a field of an unknown number
+func (p *protoReader) skip(wire int) error {
+	size := 0
+	switch wire {
+	case wireVarint:
+		_, err := p.varint()
+		return err
+	case wireBytes:
+		_, err := p.bytes()
+		return err
+	case wireFixed64:
+		size = 8
+	case wireFixed32:
+		size = 4
+	default:
+		return fmt.Errorf("unsupported protobuf wire type %d", wire)
+	}
+	if len(p.buf) < size {
+		return errProtobufTruncated
+	}
+	p.buf = p.buf[size:]
+	return nil
+}
+
+// decodeLogBatch decodes a LogBatch, appending its log entries to entries.
+// The batch is counted first so entries grows at most once.
+func decodeLogBatch(data []byte, entries []LogEntry) ([]LogEntry, error) {
+	entries = slices.Grow(entries, countLogBatch(data))
+	start := len(entries)
+	p := protoReader{buf: data}
+	for len(p.buf) > 0 {
+		field, wire, err := p.next()
+		if err != nil {
+			return nil, err
+		}
+		if field != 1 || wire != wireBytes {
+			if err := p.skip(wire); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		msg, err := p.bytes()
+		if err != nil {
+			return nil, err
+		}
+		entry, err := decodeLogEntry(msg)
+		if err != nil {
+			return nil, fmt.Errorf("log %d: %w", len(entries)-start, err)
+		}
+		if storeRawPayloads {
+			// msg points into the request body, which is reused
+			entry.Raw, entry.RawFormat = bytes.Clone(msg), rawProtobuf
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// countLogBatch counts the log entries in a LogBatch without decoding them.
+// Malformed input stops the count; decodeLogBatch reports the error.
+func countLogBatch(data []byte) int {
+	p := protoReader{buf: data}
+	n := 0
+	for len(p.buf) > 0 {
+		field, wire, err := p.next()
+		if err != nil || p.skip(wire) != nil {
+			break
+		}
+		if field == 1 && wire == wireBytes {
+			n++
+		}
+	}
+	return n
+}
+
+func decodeLogEntry(data []byte) (LogEntry, error) {
+	var entry LogEntry
+	p := protoReader{buf: data}
+	for len(p.buf) > 0 {
+		field, wire, err := p.next()
+		if err != nil {
+			return entry, err
+		}
+		var str *string
+		switch field {
+		case 2:
+			str = &entry.Level
+		case 3:
+			str = &entry.Rule
+		case 4:
+			str = &entry.SourceIP
+		case 5:
+			str = &entry.DestinationIP
+		case 6:
+			str = &entry.Event
+		case 7:
+			str = &entry.Description
+		case 9:
+			str = &entry.User
+		case 12:
+			str = &entry.Category
+		case 14:
+			str = &entry.TraceID
+		}
+		switch {
+		case str != nil && wire == wireBytes:
+			b, err := p.bytes()
+			if err != nil {
+				return entry, err
+			}
+			*str = string(b)
+		case field == 13 && wire == wireBytes:
+			b, err := p.bytes()
+			if err != nil {
+				return entry, err
+			}
+			entry.Tags = append(entry.Tags, string(b))
+		case (field == 1 || field == 8 || field == 10 || field == 11) && wire == wireVarint:
+			v, err := p.varint()
+			if err != nil {
+				return entry, err
+			}
+			switch field {
+			case 1:
+				if v != 0 {
+					entry.Timestamp = time.Unix(0, int64(v))
+				}
+			case 8:
+				entry.Urgency = int(int32(v))
+			case 10:
+				entry.SourcePort = int(v)
+			case 11:
+				entry.DestinationPort = int(v)
+			}
+		default:
+			if err := p.skip(wire); err != nil {
+				return entry, err
+			}
+		}
+	}
+	return entry, nil
+}
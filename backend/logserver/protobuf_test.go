@@ -0,0 +1,167 @@
+package logserver
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// encodeLogBatch encodes logs as a LogBatch from proto/logger.proto, as an
+// agent's generated code would
+func encodeLogBatch(logs []LogEntry) []byte {
+	var out []byte
+	for _, l := range logs {
+		var msg []byte
+		if !l.Timestamp.IsZero() {
+			msg = appendProtoVarint(msg, 1, uint64(l.Timestamp.UnixNano()))
+		}
+		for _, f := range []struct {
+			field int
+			value string
+		}{
+			{2, l.Level}, {3, l.Rule}, {4, l.SourceIP}, {5, l.DestinationIP}, {6, l.Event},
+			{7, l.Description}, {9, l.User}, {12, l.Category}, {14, l.TraceID},
+		} {
+			msg = appendProtoBytes(msg, f.field, []byte(f.value))
+		}
+		msg = appendProtoVarint(msg, 8, uint64(int64(int32(l.Urgency))))
+		msg = appendProtoVarint(msg, 10, uint64(l.SourcePort))
+		msg = appendProtoVarint(msg, 11, uint64(l.DestinationPort))
+		for _, tag := range l.Tags {
+			msg = appendProtoBytes(msg, 13, []byte(tag))
+		}
+		// An empty log is still a log, so its field is written regardless
+		out = binary.AppendUvarint(out, 1<<3|wireBytes)
+		out = binary.AppendUvarint(out, uint64(len(msg)))
+		out = append(out, msg...)
+	}
+	return out
+}
+
+// appendProtoVarint appends a varint field, leaving out zero as proto3 does
+func appendProtoVarint(buf []byte, field int, v uint64) []byte {
+	if v == 0 {
+		return buf
+	}
+	buf = binary.AppendUvarint(buf, uint64(field)<<3|wireVarint)
+	return binary.AppendUvarint(buf, v)
+}
+
+// appendProtoBytes appends a length-delimited field, leaving out empty ones
+func appendProtoBytes(buf []byte, field int, b []byte) []byte {
+	if len(b) == 0 {
+		return buf
+	}
+	buf = binary.AppendUvarint(buf, uint64(field)<<3|wireBytes)
+	buf = binary.AppendUvarint(buf, uint64(len(b)))
+	return append(buf, b...)
+}
+
+func TestLogBatchRoundTrip(t *testing.T) {
+	logs := []LogEntry{
+		{
+			Timestamp: time.Date(2025, 1, 6, 9, 30, 15, 123456789, time.UTC), Level: "ERROR", Rule: "Brute Force Login",
+			SourceIP: "10.0.0.5", DestinationIP: "192.168.1.10", Event: "Failed login", Description: "Failed password for root",
+			Urgency: 4, User: "root", SourcePort: 51234, DestinationPort: 22, Category: "access",
+			Tags: []string{"ssh", "prod"}, TraceID: "4bf92f3577b34da6a3ce929d0e0e4736",
+		},
+		// Only some fields set, and a negative urgency the server rejects later
+		{Level: "INFO", Event: "Heartbeat", Urgency: -1},
+		{},
+	}
+	got, err := decodeLogBatch(encodeLogBatch(logs), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != len(logs) {
+		t.Fatalf("decoded %d logs, want %d", len(got), len(logs))
+	}
+	for i := range got {
+		if !got[i].Timestamp.Equal(logs[i].Timestamp) {
+			t.Fatalf("log %d: timestamp %v, want %v", i, got[i].Timestamp, logs[i].Timestamp)
+		}
+		// time.Time compares by its representation, not its instant
+		got[i].Timestamp = logs[i].Timestamp
+		got[i].Raw, got[i].RawFormat = nil, ""
+	}
+	if !reflect.DeepEqual(got, logs) {
+		t.Fatalf("round trip:\ngot  %+v\nwant %+v", got, logs)
+	}
+	if n := countLogBatch(encodeLogBatch(logs)); n != len(logs) {
+		t.Fatalf("countLogBatch = %d, want %d", n, len(logs))
+	}
+}
+
+func TestLogBatchSkipsUnknownFields(t *testing.T) {
+	msg := appendProtoBytes(nil, 2, []byte("WARN"))
+	// Fields a newer schema might add: a varint, a fixed64, a fixed32 and
+	// a nested message
+	msg = appendProtoVarint(msg, 40, 300)
+	msg = binary.AppendUvarint(msg, 41<<3|wireFixed64)
+	msg = append(msg, 1, 2, 3, 4, 5, 6, 7, 8)
+	msg = binary.AppendUvarint(msg, 42<<3|wireFixed32)
+	msg = append(msg, 1, 2, 3, 4)
+	msg = appendProtoBytes(msg, 43, []byte{0x08, 0x01})
+	msg = appendProtoBytes(msg, 6, []byte("Disk full"))
+	batch := appendProtoBytes(nil, 1, msg)
+	batch = appendProtoVarint(batch, 2, 7)
+
+	got, err := decodeLogBatch(batch, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || got[0].Level != "WARN" || got[0].Event != "Disk full" {
+		t.Fatalf("got %+v, want one WARN log with event Disk full", got)
+	}
+}
+
+func TestLogBatchRejectsTruncatedInput(t *testing.T) {
+	batch := encodeLogBatch([]LogEntry{{Level: "ERROR", Event: "Failed login", Description: "Failed password"}})
+	for n := 1; n < len(batch); n++ {
+		if _, err := decodeLogBatch(batch[:n], nil); err == nil {
+			t.Fatalf("decoding the first %d of %d bytes: want an error", n, len(batch))
+		}
+	}
+}
+
+// benchBatch is the ingest batch the decode benchmarks share, so JSON and
+// protobuf decode the same logs
+func benchBatch(b *testing.B) []LogEntry {
+	logs := benchLogs(benchIngestBatch, 2)
+	for i := range logs {
+		logs[i].Tags = []string{"bench"}
+	}
+	return logs
+}
+
+// BenchmarkDecodeJSON and BenchmarkDecodeProtobuf decode the same batch of
+// benchIngestBatch logs as POST /api/logs does, reporting the body size
+func BenchmarkDecodeJSON(b *testing.B) {
+	body, err := json.Marshal(benchBatch(b))
+	if err != nil {
+		b.Fatal(err)
+	}
+	benchDecode(b, "application/json", body)
+}
+
+func BenchmarkDecodeProtobuf(b *testing.B) {
+	benchDecode(b, contentTypeProtobuf, encodeLogBatch(benchBatch(b)))
+}
+
+func benchDecode(b *testing.B, contentType string, body []byte) {
+	b.ReportAllocs()
+	b.SetBytes(int64(len(body)))
+	var entries []LogEntry
+	for i := 0; i < b.N; i++ {
+		var err error
+		if entries, err = decodeIngestBody(contentType, body, entries[:0]); err != nil {
+			b.Fatal(err)
+		}
+		if len(entries) != benchIngestBatch {
+			b.Fatalf("decoded %d logs, want %d", len(entries), benchIngestBatch)
+		}
+	}
+	b.ReportMetric(float64(len(body))/benchIngestBatch, "bytes/log")
+}
@@ -0,0 +1,240 @@
+package logserver
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"math"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Query history. Every log search is remembered per client, the credential
+// it presents or else its IP as for query limits, so the filter bar can offer
+// recently used searches and filters. Only search filters are kept, not
+// paging, sorting or output options.
+
+const (
+	// queryHistorySize is how many distinct queries each client keeps
+	queryHistorySize = 200
+	// suggestionHalfLife is how long it takes a query's weight to halve
+	suggestionHalfLife = 7 * 24 * time.Hour
+)
+
+// RecentQuery is a query a client has run, how often and when last
+type RecentQuery struct {
+	Query    string    `json:"query"`
+	Count    int       `json:"count"`
+	LastUsed time.Time `json:"lastUsed"`
+}
+
+// QuerySuggestion is a whole query ("query") or one of its filters
+// ("filter"), ranked by score
+type QuerySuggestion struct {
+	Text     string    `json:"text"`
+	Kind     string    `json:"kind"`
+	Count    int       `json:"count"`
+	LastUsed time.Time `json:"lastUsed"`
+	Score    float64   `json:"score"`
+}
+
+func createQueryHistoryTables(db *sql.DB) error {
+	_, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS query_history (
+			client TEXT NOT NULL,
+			query TEXT NOT NULL,
+			count INTEGER NOT NULL,
+			last_used DATETIME NOT NULL,
+			PRIMARY KEY (client, query)
+		)
+	`)
+	return err
+}
+
+// historyQuery is the part of a search's parameters worth remembering, in
+// a canonical order
+func historyQuery(q url.Values) string {
+	kept := url.Values{}
+	for key, values := range q {
+		if searchParamKeys[key] || key == "from" || key == "to" || key == "case_sensitive" {
+			for _, v := range values {
+				if v != "" {
+					kept.Add(key, v)
+				}
+			}
+		}
+	}
+	return kept.Encode()
+}
+
+// RecordQuery remembers that client ran query, keeping the client's
+// queryHistorySize most recent
+func (d *Database) RecordQuery(client, query string, at time.Time) error {
+	if query == "" {
+		return nil
+	}
+	_, err := d.db.Exec(`
+		INSERT INTO query_history (client, query, count, last_used) VALUES (?, ?, 1, ?)
+		ON CONFLICT (client, query) DO UPDATE SET count = count + 1, last_used = excluded.last_used
+	`, client, query, at.UTC())
+	if err != nil {
+		return err
+	}
+	_, err = d.db.Exec(`
+		DELETE FROM query_history WHERE client = ? AND query NOT IN (
+			SELECT query FROM query_history WHERE client = ? ORDER BY last_used DESC LIMIT ?
+		)
+	`, client, client, queryHistorySize)
+	return err
+}
+
+// recordSearch records a search request's query, logging rather than
+// failing the search on error
+func (d *Database) recordSearch(r *http.Request, q url.Values) {
+	if err := d.RecordQuery(d.queryClient(r), historyQuery(q), d.now()); err != nil {
+		log.Printf("query history: %v", err)
+	}
+}
+
+// RecentQueries returns a client's queries, most recently used first
+func (d *Database) RecentQueries(client string, limit int) ([]RecentQuery, error) {
+	rows, err := d.db.Query(`
+		SELECT query, count, last_used FROM query_history WHERE client = ? ORDER BY last_used DESC LIMIT ?
+	`, client, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	queries := []RecentQuery{}
+	for rows.Next() {
+		var q RecentQuery
+		if err := rows.Scan(&q.Query, &q.Count, &q.LastUsed); err != nil {
+			return nil, err
+		}
+		queries = append(queries, q)
+	}
+	return queries, rows.Err()
+}
+
+// ClearQueries forgets a client's history
+func (d *Database) ClearQueries(client string) error {
+	_, err := d.db.Exec(`DELETE FROM query_history WHERE client = ?`, client)
+	return err
+}
+
+// SuggestQueries ranks a client's queries and the filters in them that
+// start with prefix, unescaped; a filter also matches on its value alone.
+// A suggestion scores its use count, weighted by how recently it was last
+// used, halving every suggestionHalfLife.
+func (d *Database) SuggestQueries(client, prefix string, limit int, now time.Time) ([]QuerySuggestion, error) {
+	recent, err := d.RecentQueries(client, queryHistorySize)
+	if err != nil {
+		return nil, err
+	}
+	prefix = strings.ToLower(prefix)
+	candidates := map[string]*QuerySuggestion{}
+	consider := func(text, kind string, q RecentQuery, matches ...string) {
+		matched := false
+		for _, m := range matches {
+			matched = matched || strings.HasPrefix(strings.ToLower(m), prefix)
+		}
+		if !matched {
+			return
+		}
+		s, ok := candidates[kind+"\x00"+text]
+		if !ok {
+			s = &QuerySuggestion{Text: text, Kind: kind}
+			candidates[kind+"\x00"+text] = s
+		}
+		s.Count += q.Count
+		if q.LastUsed.After(s.LastUsed) {
+			s.LastUsed = q.LastUsed
+		}
+	}
+	for _, q := range recent {
+		values, err := url.ParseQuery(q.Query)
+		if err != nil {
+			continue
+		}
+		unescaped, _ := url.QueryUnescape(q.Query)
+		consider(q.Query, "query", q, unescaped)
+		for key, list := range values {
+			for _, v := range list {
+				consider(url.Values{key: {v}}.Encode(), "filter", q, key+"="+v, v)
+			}
+		}
+	}
+
+	suggestions := make([]QuerySuggestion, 0, len(candidates))
+	for _, s := range candidates {
+		age := now.Sub(s.LastUsed)
+		s.Score = math.Round(float64(s.Count)*math.Pow(0.5, age.Hours()/suggestionHalfLife.Hours())*1000) / 1000
+		suggestions = append(suggestions, *s)
+	}
+	sort.Slice(suggestions, func(i, j int) bool {
+		a, b := suggestions[i], suggestions[j]
+		if a.Score != b.Score {
+			return a.Score > b.Score
+		}
+		return a.Text < b.Text
+	})
+	if len(suggestions) > limit {
+		suggestions = suggestions[:limit]
+	}
+	return suggestions, nil
+}
+
+// queryListLimit reads limit=, default 20, max queryHistorySize
+func queryListLimit(q url.Values) int {
+	if l, err := strconv.Atoi(q.Get("limit")); err == nil && l > 0 && l <= queryHistorySize {
+		return l
+	}
+	return 20
+}
+
+// GET /api/queries/recent?limit= - the caller's recent searches
+// DELETE /api/queries/recent - forget them
+func recentQueriesHandlerDB(w http.ResponseWriter, r *http.Request, db *Database) {
+	enableCORS(w)
+	w.Header().Set("Content-Type", "application/json")
+	client := db.queryClient(r)
+	switch r.Method {
+	case http.MethodGet:
+		queries, err := db.RecentQueries(client, queryListLimit(r.URL.Query()))
+		if err != nil {
+			writeJSONError(w, http.StatusInternalServerError, "Failed to fetch recent queries")
+			return
+		}
+		json.NewEncoder(w).Encode(queries)
+	case http.MethodDelete:
+		if err := db.ClearQueries(client); err != nil {
+			writeJSONError(w, http.StatusInternalServerError, "Failed to clear recent queries")
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+	}
+}
+
+// GET /api/queries/suggest?prefix=&limit= - ranked completions from the
+// caller's history
+func querySuggestionsHandlerDB(w http.ResponseWriter, r *http.Request, db *Database) {
+	enableCORS(w)
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != http.MethodGet {
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	q := r.URL.Query()
+	suggestions, err := db.SuggestQueries(db.queryClient(r), q.Get("prefix"), queryListLimit(q), db.now())
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "Failed to suggest queries")
+		return
+	}
+	json.NewEncoder(w).Encode(suggestions)
+}
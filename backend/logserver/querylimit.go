@@ -0,0 +1,193 @@
+package logserver
+
+import (
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Heavy read endpoints (search, export, SQL and the larger aggregations)
+// run under a server-wide and a per-client concurrency cap. Requests over
+// either cap wait in a short queue; when a slot frees it goes to the
+// waiting client with the fewest queries running, so one client firing
+// many searches cannot starve the others. Ingest and the dashboard
+// endpoints are never limited.
+
+var (
+	queryConcurrency          = envInt("QUERY_CONCURRENCY", 2*runtime.GOMAXPROCS(0))
+	queryConcurrencyPerClient = envInt("QUERY_CONCURRENCY_PER_CLIENT", 2)
+	queryQueueSize            = envInt("QUERY_QUEUE", 32)
+	queryQueueTimeout         = envDuration("QUERY_QUEUE_TIMEOUT", 10*time.Second)
+)
+
+var (
+	errQueryQueueFull    = errors.New("too many queries are waiting")
+	errQueryQueueTimeout = errors.New("timed out waiting for a query slot")
+)
+
+func envInt(key string, fallback int) int {
+	if n, err := strconv.Atoi(os.Getenv(key)); err == nil && n > 0 {
+		return n
+	}
+	return fallback
+}
+
+// queryWaiter is a request waiting for a slot; ready is closed when it gets one
+type queryWaiter struct {
+	client string
+	ready  chan struct{}
+}
+
+type queryLimiter struct {
+	mu        sync.Mutex
+	max       int
+	perClient int
+	queue     int
+	running   map[string]int
+	total     int
+	waiting   []*queryWaiter
+	rejected  int64
+}
+
+func newQueryLimiter(max, perClient, queue int) *queryLimiter {
+	return &queryLimiter{max: max, perClient: perClient, queue: queue, running: map[string]int{}}
+}
+
+// acquire waits for a slot for client, for at most timeout, and returns
+// the function that releases it
+func (l *queryLimiter) acquire(client string, timeout time.Duration, cancel <-chan struct{}) (func(), error) {
+	l.mu.Lock()
+	// grant leaves no waiter that could run while slots are free, so a
+	// client under both caps goes ahead of the queue without jumping it
+	if l.total < l.max && l.running[client] < l.perClient {
+		l.start(client)
+		l.mu.Unlock()
+		return func() { l.release(client) }, nil
+	}
+	if len(l.waiting) >= l.queue {
+		l.rejected++
+		l.mu.Unlock()
+		return nil, errQueryQueueFull
+	}
+	w := &queryWaiter{client: client, ready: make(chan struct{})}
+	l.waiting = append(l.waiting, w)
+	l.mu.Unlock()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	var err error
+	select {
+	case <-w.ready:
+		return func() { l.release(client) }, nil
+	case <-timer.C:
+		err = errQueryQueueTimeout
+	case <-cancel:
+		err = errors.New("request cancelled")
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for i, other := range l.waiting {
+		if other == w {
+			l.waiting = append(l.waiting[:i], l.waiting[i+1:]...)
+			if err == errQueryQueueTimeout {
+				l.rejected++
+			}
+			return nil, err
+		}
+	}
+	// The slot was granted just as the wait ended
+	return func() { l.release(client) }, nil
+}
+
+// stats returns the running and waiting queries and how many were rejected
+func (l *queryLimiter) stats() (running, waiting int, rejected int64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.total, len(l.waiting), l.rejected
+}
+
+func (l *queryLimiter) start(client string) {
+	l.running[client]++
+	l.total++
+}
+
+func (l *queryLimiter) finish(client string) {
+	l.total--
+	if l.running[client]--; l.running[client] <= 0 {
+		delete(l.running, client)
+	}
+	l.grant()
+}
+
+func (l *queryLimiter) release(client string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.finish(client)
+}
+
+// grant hands free slots to waiters: each time to the oldest waiter of the
+// client with the fewest running queries, skipping clients at their cap
+func (l *queryLimiter) grant() {
+	for l.total < l.max {
+		best := -1
+		for i, w := range l.waiting {
+			n := l.running[w.client]
+			if n < l.perClient && (best < 0 || n < l.running[l.waiting[best].client]) {
+				best = i
+			}
+		}
+		if best < 0 {
+			return
+		}
+		w := l.waiting[best]
+		l.waiting = append(l.waiting[:best], l.waiting[best+1:]...)
+		l.start(w.client)
+		close(w.ready)
+	}
+}
+
+// queryClient identifies who a query is counted against: the API key or
+// admin token it presents, otherwise its IP address
+func (d *Database) queryClient(r *http.Request) string {
+	token := r.Header.Get("X-Admin-Token")
+	if token == "" {
+		token = strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	}
+	switch {
+	case token == "":
+	case adminToken != "" && subtle.ConstantTimeCompare([]byte(token), []byte(adminToken)) == 1:
+		return "admin"
+	case d.validAPIKey(token):
+		return "key:" + hashAPIKey(token)[:16]
+	}
+	return "ip:" + clientIP(r)
+}
+
+// withQueryLimit runs h once the client gets a query slot, or answers 503
+// with Retry-After when the queue is full or the wait times out
+func (d *Database) withQueryLimit(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodOptions {
+			h(w, r)
+			return
+		}
+		release, err := d.queries.acquire(d.queryClient(r), queryQueueTimeout, r.Context().Done())
+		if err != nil {
+			if r.Context().Err() != nil {
+				return
+			}
+			enableCORS(w)
+			w.Header().Set("Retry-After", "1")
+			writeJSONError(w, http.StatusServiceUnavailable, "Server busy: "+err.Error())
+			return
+		}
+		defer release()
+		h(w, r)
+	}
+}
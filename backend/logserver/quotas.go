@@ -0,0 +1,181 @@
+package logserver
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"sync"
+	"time"
+)
+
+// Ingest quotas. An ingest token can have a daily quota of logs, counted per
+// UTC day; ingest past it is rejected with 429 until the day ends. As a
+// token's usage crosses each of quotaWarnPercents, an ingest.quota event is
+// sent to webhook subscribers, so the producer's owners are warned before
+// rejection starts. Usage is kept in memory and written once a minute.
+
+// quotaWarnPercents are the shares of a daily quota that send a warning
+var quotaWarnPercents = []int64{80, 100}
+
+const quotaFlushInterval = time.Minute
+
+// quotaRetentionDays is how many days of usage are kept
+const quotaRetentionDays = 7
+
+var errQuotaExceeded = errors.New("daily ingest quota exceeded")
+
+// QuotaWarning is the data of an ingest.quota event
+type QuotaWarning struct {
+	TokenID    int64  `json:"tokenId"`
+	Name       string `json:"name"`
+	Day        string `json:"day"`
+	DailyQuota int64  `json:"dailyQuota"`
+	Used       int64  `json:"used"`
+	Percent    int64  `json:"percent"`
+}
+
+// quotaUsage is a token's count of logs on day
+type quotaUsage struct {
+	day   string
+	used  int64
+	dirty bool
+}
+
+// quotaCounter holds each token's usage today
+type quotaCounter struct {
+	mu    sync.Mutex
+	usage map[int64]*quotaUsage
+}
+
+func createQuotaTables(db *sql.DB) error {
+	_, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS ingest_quota_usage (
+			token_id INTEGER NOT NULL,
+			day TEXT NOT NULL,
+			used INTEGER NOT NULL,
+			PRIMARY KEY (token_id, day)
+		)
+	`)
+	return err
+}
+
+func quotaDay(t time.Time) string {
+	return t.UTC().Format("2006-01-02")
+}
+
+// loadQuotaUsage reads today's usage, so a restart does not reset it
+func (d *Database) loadQuotaUsage() error {
+	rows, err := d.db.Query(`SELECT token_id, used FROM ingest_quota_usage WHERE day = ?`, quotaDay(d.now()))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	usage := make(map[int64]*quotaUsage)
+	for rows.Next() {
+		u := &quotaUsage{day: quotaDay(d.now())}
+		var id int64
+		if err := rows.Scan(&id, &u.used); err != nil {
+			return err
+		}
+		usage[id] = u
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	d.quotas.mu.Lock()
+	d.quotas.usage = usage
+	d.quotas.mu.Unlock()
+	return nil
+}
+
+// quotaUsedToday returns how many logs a token has sent today
+func (d *Database) quotaUsedToday(tokenID int64, now time.Time) int64 {
+	d.quotas.mu.Lock()
+	defer d.quotas.mu.Unlock()
+	if u := d.quotas.usage[tokenID]; u != nil && u.day == quotaDay(now) {
+		return u.used
+	}
+	return 0
+}
+
+// admitIngest counts n logs against the token's daily quota, refusing all
+// of them if they do not fit, and warns as usage crosses a threshold
+func (d *Database) admitIngest(token IngestToken, n int, now time.Time) error {
+	if token.DailyQuota <= 0 {
+		return nil
+	}
+	day := quotaDay(now)
+	d.quotas.mu.Lock()
+	u := d.quotas.usage[token.ID]
+	if u == nil || u.day != day {
+		u = &quotaUsage{day: day}
+		d.quotas.usage[token.ID] = u
+	}
+	if u.used+int64(n) > token.DailyQuota {
+		d.quotas.mu.Unlock()
+		return errQuotaExceeded
+	}
+	before := u.used
+	u.used += int64(n)
+	u.dirty = true
+	after := u.used
+	d.quotas.mu.Unlock()
+
+	for _, percent := range quotaWarnPercents {
+		threshold := (token.DailyQuota*percent + 99) / 100
+		if before < threshold && after >= threshold {
+			d.emitEvent(eventIngestQuota, QuotaWarning{
+				TokenID: token.ID, Name: token.Name, Day: day,
+				DailyQuota: token.DailyQuota, Used: after, Percent: percent,
+			})
+		}
+	}
+	return nil
+}
+
+// untilNextQuotaDay is how long until quotas reset
+func untilNextQuotaDay(now time.Time) time.Duration {
+	y, m, day := now.UTC().Date()
+	return time.Date(y, m, day+1, 0, 0, 0, 0, time.UTC).Sub(now)
+}
+
+// flushQuotaUsage writes the usage that changed since the last flush and
+// forgets days past quotaRetentionDays
+func (d *Database) flushQuotaUsage() error {
+	type row struct {
+		id   int64
+		day  string
+		used int64
+	}
+	var rows []row
+	d.quotas.mu.Lock()
+	for id, u := range d.quotas.usage {
+		if u.dirty {
+			rows = append(rows, row{id, u.day, u.used})
+			u.dirty = false
+		}
+	}
+	d.quotas.mu.Unlock()
+	for _, r := range rows {
+		if _, err := d.db.Exec(`INSERT OR REPLACE INTO ingest_quota_usage (token_id, day, used) VALUES (?, ?, ?)`, r.id, r.day, r.used); err != nil {
+			return err
+		}
+	}
+	_, err := d.db.Exec(`DELETE FROM ingest_quota_usage WHERE day < ?`, quotaDay(d.now().AddDate(0, 0, -quotaRetentionDays)))
+	return err
+}
+
+func (d *Database) flushQuotaUsageLoop() {
+	ticker := time.NewTicker(quotaFlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := d.flushQuotaUsage(); err != nil {
+				log.Printf("quotas: failed to record usage: %v", err)
+			}
+		case <-d.done:
+			return
+		}
+	}
+}
@@ -0,0 +1,137 @@
+package logserver
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Raw payloads. Each ingested log keeps the bytes it was parsed from in
+// log_raw, away from the logs table, so search never reads them. They are
+// fetched one log at a time with GET /api/logs/raw, and let logs be parsed
+// again after a parsing bug is fixed.
+
+var storeRawPayloads = envOr("STORE_RAW_PAYLOADS", "true") == "true"
+
+// Raw payload formats
+const (
+	rawJSON     = "json"     // one JSON log object
+	rawProtobuf = "protobuf" // one LogEntry message (see proto/logger.proto)
+	rawWebhook  = "webhook"  // an inbound webhook body
+)
+
+func createRawTables(db *sql.DB) error {
+	_, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS log_raw (
+			log_id INTEGER PRIMARY KEY,
+			format TEXT NOT NULL,
+			payload BLOB NOT NULL
+		)
+	`)
+	return err
+}
+
+// GetRawPayload returns a log's raw payload and its format
+func (d *Database) GetRawPayload(id int64) (string, []byte, error) {
+	var format string
+	var payload []byte
+	err := d.db.QueryRow(`SELECT format, payload FROM log_raw WHERE log_id = ?`, id).Scan(&format, &payload)
+	return format, payload, err
+}
+
+// attachRaw fills in the raw payload and format of each log that has one
+func (d *Database) attachRaw(logs []LogEntry) error {
+	if len(logs) == 0 {
+		return nil
+	}
+	args := make([]interface{}, len(logs))
+	index := make(map[int64]int, len(logs))
+	for i, l := range logs {
+		args[i] = l.ID
+		index[l.ID] = i
+	}
+	rows, err := d.db.Query(`SELECT log_id, format, payload FROM log_raw WHERE log_id IN (?`+strings.Repeat(`, ?`, len(logs)-1)+`)`, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id int64
+		var format string
+		var payload []byte
+		if err := rows.Scan(&id, &format, &payload); err != nil {
+			return err
+		}
+		if i, ok := index[id]; ok {
+			logs[i].RawFormat, logs[i].Raw = format, payload
+		}
+	}
+	return rows.Err()
+}
+
+// logVisible reports whether a log exists and matches scope
+func (d *Database) logVisible(id int64, scope LogFilter) (bool, error) {
+	clause, args := scope.where()
+	var visible bool
+	err := d.db.QueryRow(`SELECT EXISTS (SELECT 1 FROM logs WHERE id = ?`+clause+`)`, append([]interface{}{id}, args...)...).Scan(&visible)
+	return visible, err
+}
+
+// rawContentType is the media type a raw payload is served as
+func rawContentType(format string, payload []byte) string {
+	switch {
+	case format == rawProtobuf:
+		return contentTypeProtobuf
+	case json.Valid(payload):
+		return "application/json"
+	default:
+		return "text/plain; charset=utf-8"
+	}
+}
+
+// GET /api/logs/raw?id= - the payload a log was parsed from, as received
+func rawPayloadHandlerDB(w http.ResponseWriter, r *http.Request, db *Database) {
+	enableCORS(w)
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != http.MethodGet {
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	id, err := strconv.ParseInt(r.URL.Query().Get("id"), 10, 64)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, "Invalid id")
+		return
+	}
+	// A log outside the caller's data scope does not exist for them
+	if scope, scoped := requestScope(r); scoped {
+		visible, err := db.logVisible(id, scope)
+		if err != nil {
+			writeJSONError(w, http.StatusInternalServerError, "Failed to fetch raw payload")
+			return
+		}
+		if !visible {
+			writeJSONError(w, http.StatusNotFound, "No raw payload stored for this log")
+			return
+		}
+	}
+	format, payload, err := db.GetRawPayload(id)
+	if errors.Is(err, sql.ErrNoRows) {
+		writeJSONError(w, http.StatusNotFound, "No raw payload stored for this log")
+		return
+	}
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "Failed to fetch raw payload")
+		return
+	}
+	payload, ok := requestMasks(r).maskRaw(format, payload)
+	if !ok {
+		writeJSONError(w, http.StatusForbidden, "This raw payload cannot be masked")
+		return
+	}
+	w.Header().Set("Content-Type", rawContentType(format, payload))
+	w.Header().Set("X-Raw-Format", format)
+	w.Write(payload)
+}
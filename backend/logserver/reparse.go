@@ -0,0 +1,124 @@
+package logserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Re-parsing. A bulk job with action "reparse" parses the stored raw
+// payloads of the selected logs again with the current parser and tag
+// rules, and rewrites their parsed fields in place. Category, an override
+// set by bulk updates, is kept.
+
+// parseRaw parses a raw payload the way ingest did, before defaults;
+// received dates BSD syslog timestamps, which have no year
+func parseRaw(format string, payload []byte, received time.Time) (LogEntry, error) {
+	var entry LogEntry
+	var err error
+	switch format {
+	case rawJSON:
+		err = json.Unmarshal(payload, &entry)
+	case rawProtobuf:
+		entry, err = decodeLogEntry(payload)
+	case rawSyslog:
+		var m syslogMessage
+		if m, err = parseSyslog(payload, received); err == nil {
+			entry = m.entry("")
+		}
+	case rawGELF:
+		entry, err = parseGELF(payload, "")
+	default:
+		// Webhook logs were built from request headers as well as the body
+		err = fmt.Errorf("%s payloads cannot be parsed again", format)
+	}
+	return entry, err
+}
+
+// reparseBatch re-parses the logs in ids in a single transaction. It
+// returns how many it skipped: logs without a raw payload, webhook logs,
+// and logs whose payload no longer parses or validates.
+func (d *Database) reparseBatch(ids []int64) (int, error) {
+	in := `(?` + strings.Repeat(`, ?`, len(ids)-1) + `)`
+	idArgs := make([]interface{}, len(ids))
+	for i, id := range ids {
+		idArgs[i] = id
+	}
+
+	tx, err := d.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	before, err := countedLogs(tx, in, idArgs)
+	if err != nil {
+		return 0, err
+	}
+	old := make(map[int64]LogEntry, len(before))
+	for _, l := range before {
+		old[l.ID] = l
+	}
+	rows, err := tx.Query(`SELECT log_id, format, payload FROM log_raw WHERE log_id IN `+in, idArgs...)
+	if err != nil {
+		return 0, err
+	}
+	var parsed []LogEntry
+	for rows.Next() {
+		var id int64
+		var format string
+		var payload []byte
+		if err := rows.Scan(&id, &format, &payload); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		entry, err := parseRaw(format, payload, old[id].Timestamp)
+		if err != nil {
+			continue
+		}
+		// A payload without a timestamp was stamped on receipt, which is
+		// not now
+		if entry.Timestamp.IsZero() {
+			entry.Timestamp = old[id].Timestamp
+		}
+		// Neither is the address a syslog or GELF message came from
+		if (format == rawSyslog || format == rawGELF) && entry.SourceIP == "" {
+			entry.SourceIP = old[id].SourceIP
+		}
+		if prepareLogEntry(&entry, d.now()) != nil {
+			continue
+		}
+		entry.ID, entry.Category = id, old[id].Category
+		parsed = append(parsed, entry)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	for _, l := range parsed {
+		_, err := tx.Exec(`
+			UPDATE logs SET timestamp = ?, level = ?, rule = ?, source_ip = ?, destination_ip = ?, event = ?,
+				description = ?, description_z = NULL, urgency = ?, user_name = ?, source_port = ?, destination_port = ?, trace_id = ?
+			WHERE id = ?
+		`, l.Timestamp.UTC(), l.Level, l.Rule, l.SourceIP, l.DestinationIP, l.Event, l.Description, l.Urgency, l.User,
+			l.SourcePort, l.DestinationPort, l.TraceID, l.ID)
+		if err != nil {
+			return 0, err
+		}
+		// Tags are only added, since some may have been added by hand
+		if err := tagLog(tx, l.ID, append(l.Tags, d.ingestTags(l)...)); err != nil {
+			return 0, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	for _, l := range parsed {
+		d.counters.add(old[l.ID], -1)
+		d.counters.add(l, 1)
+	}
+	d.logsChanged()
+	return len(ids) - len(parsed), nil
+}
@@ -0,0 +1,392 @@
+package logserver
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Replay re-ingests a captured time range for incident drills and rule
+// tuning. The logs are read from the store or the archive, oldest first,
+// and ingested again through the full pipeline with their timestamps moved
+// to the present, keeping the original gaps divided by the replay speed.
+// Each replayed log carries a sandbox tag so drill rules and searches can
+// select it, and production views can exclude it.
+
+// Replay sources and statuses
+const (
+	replayFromLogs    = "logs"
+	replayFromArchive = "archive"
+
+	replayRunning  = "running"
+	replayDone     = "done"
+	replayFailed   = "failed"
+	replayCanceled = "canceled"
+)
+
+// defaultReplayTag marks replayed logs unless a replay names another tag
+const defaultReplayTag = "sandbox"
+
+// replayMaxLogs caps how many logs one replay may hold in memory
+var replayMaxLogs = envInt("REPLAY_MAX_LOGS", 100000)
+
+var errReplayTooLarge = errors.New("the range holds too many logs to replay")
+
+// replayProgressInterval is how often a running replay records progress
+const replayProgressInterval = time.Second
+
+// ReplayRequest is the body of POST /api/replays; the logs are selected by
+// the search parameters in the query string, which must include from or since
+type ReplayRequest struct {
+	Source string `json:"source"`
+	// Speed divides the gaps between logs: 1 replays at the original pace,
+	// 60 replays an hour in a minute
+	Speed float64 `json:"speed"`
+	Tag   string  `json:"tag"`
+	Actor string  `json:"actor"`
+}
+
+// ReplayJob tracks a replay running in the background
+type ReplayJob struct {
+	ID         string     `json:"id"`
+	Query      string     `json:"query"`
+	Source     string     `json:"source"`
+	Speed      float64    `json:"speed"`
+	Tag        string     `json:"tag"`
+	Actor      string     `json:"actor,omitempty"`
+	Status     string     `json:"status"`
+	Matched    int        `json:"matched"`
+	Replayed   int        `json:"replayed"`
+	Error      string     `json:"error,omitempty"`
+	StartedAt  time.Time  `json:"startedAt"`
+	FinishedAt *time.Time `json:"finishedAt,omitempty"`
+}
+
+// jobCancels holds a channel per running replay or bulk job, closed to stop it
+type jobCancels struct {
+	mu   sync.Mutex
+	byID map[string]chan struct{}
+}
+
+// start registers a job and returns the channel that cancel closes
+func (c *jobCancels) start(id string) chan struct{} {
+	cancel := make(chan struct{})
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.byID == nil {
+		c.byID = make(map[string]chan struct{})
+	}
+	c.byID[id] = cancel
+	return cancel
+}
+
+// finish forgets a job that has stopped
+func (c *jobCancels) finish(id string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.byID, id)
+}
+
+// cancel stops a running job, reporting whether there was one
+func (c *jobCancels) cancel(id string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	cancel, ok := c.byID[id]
+	if ok {
+		close(cancel)
+		delete(c.byID, id)
+	}
+	return ok
+}
+
+func createReplayTables(db *sql.DB) error {
+	_, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS replay_jobs (
+			id TEXT PRIMARY KEY,
+			query TEXT NOT NULL,
+			source TEXT NOT NULL,
+			speed REAL NOT NULL,
+			tag TEXT NOT NULL,
+			actor TEXT NOT NULL DEFAULT '',
+			status TEXT NOT NULL,
+			matched INTEGER NOT NULL,
+			replayed INTEGER NOT NULL DEFAULT 0,
+			error TEXT NOT NULL DEFAULT '',
+			started_at DATETIME NOT NULL,
+			finished_at DATETIME
+		)
+	`)
+	return err
+}
+
+// validate checks the request and fills in its defaults
+func (req *ReplayRequest) validate(filter LogFilter, archive *archiveStore) error {
+	if filter.From.IsZero() {
+		return errors.New("a replay needs a time range; set from or since")
+	}
+	switch req.Source {
+	case "":
+		req.Source = replayFromLogs
+	case replayFromLogs:
+	case replayFromArchive:
+		if archive == nil {
+			return errors.New("ARCHIVE_URL is not configured")
+		}
+	default:
+		return errors.New("source must be logs or archive")
+	}
+	var err error
+	req.Speed, req.Tag, err = replayPace(req.Speed, req.Tag, defaultReplayTag)
+	return err
+}
+
+// replayPace validates a replay's speed and tag, defaulting them to 1 and
+// defaultTag
+func replayPace(speed float64, tag, defaultTag string) (float64, string, error) {
+	if speed == 0 {
+		speed = 1
+	}
+	if speed < 0 {
+		return 0, "", errors.New("speed must be positive")
+	}
+	if tag == "" {
+		tag = defaultTag
+	}
+	tags, err := normalizeTags([]string{tag})
+	if err != nil {
+		return 0, "", err
+	}
+	return speed, tags[0], nil
+}
+
+// replayLogs reads the logs to replay, oldest first
+func (d *Database) replayLogs(source string, filter LogFilter) ([]LogEntry, error) {
+	order := logSort{Field: "timestamp", Asc: true}
+	// One more than the cap tells a full range from one that overflows it
+	limit := replayMaxLogs + 1
+	var logs []LogEntry
+	var err error
+	if source == replayFromArchive {
+		logs, _, _, err = d.archive.search(filter, order, limit)
+	} else {
+		logs, err = d.SearchLogs(filter, order, limit)
+		if err == nil {
+			err = d.attachTags(logs)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(logs) > replayMaxLogs {
+		return nil, fmt.Errorf("%w: more than %d; narrow it", errReplayTooLarge, replayMaxLogs)
+	}
+	return logs, nil
+}
+
+// StartReplay reads the logs to replay, records a job and replays them in
+// the background
+func (d *Database) StartReplay(req ReplayRequest, filter LogFilter) (ReplayJob, error) {
+	logs, err := d.replayLogs(req.Source, filter)
+	if err != nil {
+		return ReplayJob{}, err
+	}
+	return d.startReplayJob(ReplayJob{
+		Query:  filter.values().Encode(),
+		Source: req.Source,
+		Speed:  req.Speed,
+		Tag:    req.Tag,
+		Actor:  req.Actor,
+	}, logs)
+}
+
+// startReplayJob records a job for logs, sorted oldest first, and replays
+// them in the background
+func (d *Database) startReplayJob(job ReplayJob, logs []LogEntry) (ReplayJob, error) {
+	job.ID = randomHex(8)
+	job.Status = replayRunning
+	job.Matched = len(logs)
+	job.StartedAt = d.now().UTC()
+	_, err := d.db.Exec(`
+		INSERT INTO replay_jobs (id, query, source, speed, tag, actor, status, matched, started_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, job.ID, job.Query, job.Source, job.Speed, job.Tag, job.Actor, job.Status, job.Matched, job.StartedAt)
+	if err != nil {
+		return job, err
+	}
+	go d.runReplay(job, logs, d.replayCancels.start(job.ID))
+	return job, nil
+}
+
+// runReplay ingests each log once its moved timestamp comes due on the
+// database's clock
+func (d *Database) runReplay(job ReplayJob, logs []LogEntry, cancel chan struct{}) {
+	defer d.replayCancels.finish(job.ID)
+	start := d.now()
+	lastProgress := start
+	for i, entry := range logs {
+		offset := time.Duration(float64(entry.Timestamp.Sub(logs[0].Timestamp)) / job.Speed)
+		if wait := start.Add(offset).Sub(d.now()); wait > 0 {
+			select {
+			case <-d.clock.After(wait):
+			case <-cancel:
+				d.finishReplay(job.ID, replayCanceled, "", i)
+				return
+			case <-d.done:
+				d.finishReplay(job.ID, replayFailed, "Interrupted by shutdown", i)
+				return
+			}
+		}
+		select {
+		case <-cancel:
+			d.finishReplay(job.ID, replayCanceled, "", i)
+			return
+		default:
+		}
+		entry.ID = 0
+		entry.Timestamp = start.Add(offset)
+		entry.Tags = append(entry.Tags[:len(entry.Tags):len(entry.Tags)], job.Tag)
+		entry.Annotations, entry.Archive = nil, ""
+		if err := d.InsertLog(entry); err != nil {
+			log.Printf("replay: job %s failed: %v", job.ID, err)
+			d.finishReplay(job.ID, replayFailed, err.Error(), i)
+			return
+		}
+		if now := d.now(); now.Sub(lastProgress) >= replayProgressInterval {
+			lastProgress = now
+			if _, err := d.db.Exec(`UPDATE replay_jobs SET replayed = ? WHERE id = ?`, i+1, job.ID); err != nil {
+				log.Printf("replay: job %s: failed to record progress: %v", job.ID, err)
+			}
+		}
+	}
+	d.finishReplay(job.ID, replayDone, "", len(logs))
+}
+
+func (d *Database) finishReplay(id, status, message string, replayed int) {
+	_, err := d.db.Exec(`UPDATE replay_jobs SET status = ?, error = ?, replayed = ?, finished_at = ? WHERE id = ?`,
+		status, message, replayed, d.now().UTC(), id)
+	if err != nil {
+		log.Printf("replay: job %s: failed to record %s: %v", id, status, err)
+	}
+}
+
+// failInterruptedReplays marks replays that were running when the server
+// stopped; they are not resumed
+func (d *Database) failInterruptedReplays() error {
+	_, err := d.db.Exec(`UPDATE replay_jobs SET status = ?, error = ?, finished_at = ? WHERE status = ?`,
+		replayFailed, "Interrupted by a restart", d.now().UTC(), replayRunning)
+	return err
+}
+
+// CancelReplay stops a running replay; the logs already replayed stay
+func (d *Database) CancelReplay(id string) bool {
+	return d.replayCancels.cancel(id)
+}
+
+const replayJobColumns = `id, query, source, speed, tag, actor, status, matched, replayed, error, started_at, finished_at`
+
+func scanReplayJob(scan func(...interface{}) error) (ReplayJob, error) {
+	var job ReplayJob
+	var finished sql.NullTime
+	err := scan(&job.ID, &job.Query, &job.Source, &job.Speed, &job.Tag, &job.Actor, &job.Status, &job.Matched,
+		&job.Replayed, &job.Error, &job.StartedAt, &finished)
+	if finished.Valid {
+		job.FinishedAt = &finished.Time
+	}
+	return job, err
+}
+
+func (d *Database) GetReplay(id string) (ReplayJob, error) {
+	return scanReplayJob(d.db.QueryRow(`SELECT `+replayJobColumns+` FROM replay_jobs WHERE id = ?`, id).Scan)
+}
+
+func (d *Database) GetReplays(limit int) ([]ReplayJob, error) {
+	rows, err := d.db.Query(`SELECT `+replayJobColumns+` FROM replay_jobs ORDER BY started_at DESC LIMIT ?`, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	jobs := []ReplayJob{}
+	for rows.Next() {
+		job, err := scanReplayJob(rows.Scan)
+		if err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, job)
+	}
+	return jobs, rows.Err()
+}
+
+// GET/POST /api/replays?<search filters> - list replays or start one
+// GET/DELETE /api/replays/{id} - follow or cancel a replay (admin only)
+func replaysHandlerDB(w http.ResponseWriter, r *http.Request, db *Database) {
+	enableCORS(w)
+	w.Header().Set("Content-Type", "application/json")
+	if !db.requireAdmin(w, r) {
+		return
+	}
+	id := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/replays"), "/")
+	switch {
+	case id != "" && r.Method == http.MethodGet:
+		job, err := db.GetReplay(id)
+		if errors.Is(err, sql.ErrNoRows) {
+			writeJSONError(w, http.StatusNotFound, "Replay not found")
+			return
+		}
+		if err != nil {
+			writeJSONError(w, http.StatusInternalServerError, "Failed to fetch replay")
+			return
+		}
+		json.NewEncoder(w).Encode(job)
+	case id != "" && r.Method == http.MethodDelete:
+		if !db.CancelReplay(id) {
+			writeJSONError(w, http.StatusNotFound, "No running replay "+id)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	case id == "" && r.Method == http.MethodGet:
+		jobs, err := db.GetReplays(100)
+		if err != nil {
+			writeJSONError(w, http.StatusInternalServerError, "Failed to fetch replays")
+			return
+		}
+		json.NewEncoder(w).Encode(jobs)
+	case id == "" && r.Method == http.MethodPost:
+		filter, err := parseLogFilter(r.URL.Query(), db.now())
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		var req ReplayRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeJSONError(w, http.StatusBadRequest, "Invalid JSON")
+			return
+		}
+		if err := req.validate(filter, db.archive); err != nil {
+			writeJSONError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if req.Actor == "" {
+			req.Actor = r.Header.Get("X-Actor")
+		}
+		job, err := db.StartReplay(req, filter)
+		if errors.Is(err, errReplayTooLarge) {
+			writeJSONError(w, http.StatusBadRequest, err.Error())
+			return
+		} else if err != nil {
+			log.Printf("replay: failed to start: %v", err)
+			writeJSONError(w, http.StatusInternalServerError, "Failed to start replay")
+			return
+		}
+		w.Header().Set("Location", "/api/replays/"+url.PathEscape(job.ID))
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(job)
+	default:
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+	}
+}
@@ -0,0 +1,554 @@
+package logserver
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Response action kinds
+const (
+	responseBlockIP     = "block_ip"
+	responseDisableUser = "disable_user"
+	responseScript      = "script"
+)
+
+// Execution triggers and outcomes recorded in the audit trail
+const (
+	triggerManual = "manual"
+	triggerAuto   = "auto"
+
+	executionSucceeded = "succeeded"
+	executionFailed    = "failed"
+	executionDryRun    = "dry_run"
+)
+
+// responseTimeout bounds a single action run; responseOutputLimit caps the audited output
+const (
+	responseTimeout     = 30 * time.Second
+	responseOutputLimit = 4096
+)
+
+// ResponseAction is a configured response. Block-IP actions POST to URL,
+// disable-user actions call URL with {user} substituted, and script actions
+// run Command with Args. Notables from AutoRules trigger the action automatically.
+type ResponseAction struct {
+	ID        int64             `json:"id"`
+	Name      string            `json:"name"`
+	Kind      string            `json:"kind"`
+	URL       string            `json:"url,omitempty"`
+	Method    string            `json:"method,omitempty"`
+	Headers   map[string]string `json:"headers,omitempty"`
+	Command   string            `json:"command,omitempty"`
+	Args      []string          `json:"args,omitempty"`
+	AutoRules []string          `json:"autoRules"`
+	DryRun    bool              `json:"dryRun"`
+	CreatedAt time.Time         `json:"createdAt"`
+}
+
+// ResponseTarget is what an action acts on
+type ResponseTarget struct {
+	IP      string       `json:"ip,omitempty"`
+	User    string       `json:"user,omitempty"`
+	Notable NotableEvent `json:"notable"`
+}
+
+// ResponseExecution is one audited run of an action
+type ResponseExecution struct {
+	ID         int64     `json:"id"`
+	ActionID   int64     `json:"actionId"`
+	ActionName string    `json:"actionName"`
+	NotableID  string    `json:"notableId"`
+	Target     string    `json:"target"`
+	Trigger    string    `json:"trigger"`
+	Actor      string    `json:"actor,omitempty"`
+	DryRun     bool      `json:"dryRun"`
+	Status     string    `json:"status"`
+	Output     string    `json:"output,omitempty"`
+	Error      string    `json:"error,omitempty"`
+	StartedAt  time.Time `json:"startedAt"`
+	FinishedAt time.Time `json:"finishedAt"`
+}
+
+// responseRunner implements one action kind
+type responseRunner interface {
+	validate(a ResponseAction) error
+	// target returns the identifier the action acts on, or an error if it is missing
+	target(t ResponseTarget) (string, error)
+	// describe says what run would do, for dry runs
+	describe(a ResponseAction, t ResponseTarget) string
+	run(ctx context.Context, a ResponseAction, t ResponseTarget) (string, error)
+}
+
+var responseRunners = map[string]responseRunner{
+	responseBlockIP:     blockIPRunner{},
+	responseDisableUser: disableUserRunner{},
+	responseScript:      scriptRunner{},
+}
+
+func (a ResponseAction) validate() error {
+	if a.Name == "" {
+		return errors.New("name is required")
+	}
+	runner, ok := responseRunners[a.Kind]
+	if !ok {
+		return fmt.Errorf("unknown kind %q", a.Kind)
+	}
+	return runner.validate(a)
+}
+
+func (a ResponseAction) triggeredBy(ruleName string) bool {
+	for _, r := range a.AutoRules {
+		if strings.EqualFold(r, ruleName) {
+			return true
+		}
+	}
+	return false
+}
+
+type blockIPRunner struct{}
+
+func (blockIPRunner) validate(a ResponseAction) error {
+	if a.URL == "" {
+		return errors.New("url is required")
+	}
+	return nil
+}
+
+func (blockIPRunner) target(t ResponseTarget) (string, error) {
+	if t.IP == "" {
+		return "", errors.New("no IP to block")
+	}
+	return t.IP, nil
+}
+
+func (blockIPRunner) describe(a ResponseAction, t ResponseTarget) string {
+	return fmt.Sprintf("would POST block request for %s to %s", t.IP, a.URL)
+}
+
+func (blockIPRunner) run(ctx context.Context, a ResponseAction, t ResponseTarget) (string, error) {
+	body, _ := json.Marshal(map[string]interface{}{"action": "block", "ip": t.IP, "notable": t.Notable})
+	return responseHTTP(ctx, a, http.MethodPost, a.URL, body)
+}
+
+type disableUserRunner struct{}
+
+func (disableUserRunner) validate(a ResponseAction) error {
+	if !strings.Contains(a.URL, "{user}") {
+		return errors.New("url must contain {user}")
+	}
+	return nil
+}
+
+func (disableUserRunner) target(t ResponseTarget) (string, error) {
+	if t.User == "" {
+		return "", errors.New("no user to disable")
+	}
+	return t.User, nil
+}
+
+func (r disableUserRunner) describe(a ResponseAction, t ResponseTarget) string {
+	return fmt.Sprintf("would %s %s", r.method(a), r.url(a, t))
+}
+
+func (r disableUserRunner) run(ctx context.Context, a ResponseAction, t ResponseTarget) (string, error) {
+	return responseHTTP(ctx, a, r.method(a), r.url(a, t), nil)
+}
+
+func (disableUserRunner) method(a ResponseAction) string {
+	if a.Method == "" {
+		return http.MethodPost
+	}
+	return strings.ToUpper(a.Method)
+}
+
+func (disableUserRunner) url(a ResponseAction, t ResponseTarget) string {
+	return strings.ReplaceAll(a.URL, "{user}", url.PathEscape(t.User))
+}
+
+type scriptRunner struct{}
+
+func (scriptRunner) validate(a ResponseAction) error {
+	if a.Command == "" {
+		return errors.New("command is required")
+	}
+	return nil
+}
+
+func (scriptRunner) target(t ResponseTarget) (string, error) {
+	if t.IP != "" {
+		return t.IP, nil
+	}
+	return t.User, nil
+}
+
+func (scriptRunner) describe(a ResponseAction, t ResponseTarget) string {
+	return fmt.Sprintf("would run %s %s with LOGGER_TARGET_IP=%s LOGGER_TARGET_USER=%s",
+		a.Command, strings.Join(a.Args, " "), t.IP, t.User)
+}
+
+func (scriptRunner) run(ctx context.Context, a ResponseAction, t ResponseTarget) (string, error) {
+	cmd := exec.CommandContext(ctx, a.Command, a.Args...)
+	cmd.Env = append(os.Environ(),
+		"LOGGER_TARGET_IP="+t.IP,
+		"LOGGER_TARGET_USER="+t.User,
+		"LOGGER_NOTABLE_ID="+t.Notable.ID,
+		"LOGGER_RULE_NAME="+t.Notable.RuleName,
+	)
+	out, err := cmd.CombinedOutput()
+	return string(out), err
+}
+
+// responseHTTP sends an action request with the action's headers and returns the response body
+func responseHTTP(ctx context.Context, a ResponseAction, method, target string, body []byte) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, method, target, bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	for k, v := range a.Headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := notifyClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	var buf bytes.Buffer
+	buf.ReadFrom(resp.Body)
+	if resp.StatusCode >= 300 {
+		return buf.String(), fmt.Errorf("%s %s: %s", method, target, resp.Status)
+	}
+	return buf.String(), nil
+}
+
+func createResponseTables(db *sql.DB) error {
+	_, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS response_actions (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			name TEXT NOT NULL UNIQUE,
+			kind TEXT NOT NULL,
+			config TEXT NOT NULL,
+			auto_rules TEXT NOT NULL,
+			dry_run BOOLEAN NOT NULL DEFAULT 0,
+			created_at DATETIME NOT NULL
+		)
+	`)
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS response_executions (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			action_id INTEGER NOT NULL,
+			action_name TEXT NOT NULL,
+			notable_id TEXT NOT NULL,
+			target TEXT NOT NULL,
+			trigger TEXT NOT NULL,
+			actor TEXT NOT NULL DEFAULT '',
+			dry_run BOOLEAN NOT NULL,
+			status TEXT NOT NULL,
+			output TEXT NOT NULL DEFAULT '',
+			error TEXT NOT NULL DEFAULT '',
+			started_at DATETIME NOT NULL,
+			finished_at DATETIME NOT NULL
+		)
+	`)
+	return err
+}
+
+// responseConfig holds the kind-specific fields stored in the config column
+type responseConfig struct {
+	URL     string            `json:"url,omitempty"`
+	Method  string            `json:"method,omitempty"`
+	Headers map[string]string `json:"headers,omitempty"`
+	Command string            `json:"command,omitempty"`
+	Args    []string          `json:"args,omitempty"`
+}
+
+func (d *Database) CreateResponseAction(a ResponseAction) (ResponseAction, error) {
+	if a.AutoRules == nil {
+		a.AutoRules = []string{}
+	}
+	config, _ := json.Marshal(responseConfig{URL: a.URL, Method: a.Method, Headers: a.Headers, Command: a.Command, Args: a.Args})
+	autoRules, _ := json.Marshal(a.AutoRules)
+	a.CreatedAt = d.now().UTC()
+	res, err := d.db.Exec(`
+		INSERT INTO response_actions (name, kind, config, auto_rules, dry_run, created_at) VALUES (?, ?, ?, ?, ?, ?)
+	`, a.Name, a.Kind, string(config), string(autoRules), a.DryRun, a.CreatedAt)
+	if err != nil {
+		return a, err
+	}
+	a.ID, err = res.LastInsertId()
+	return a, err
+}
+
+func (d *Database) DeleteResponseAction(id int64) error {
+	_, err := d.db.Exec(`DELETE FROM response_actions WHERE id = ?`, id)
+	return err
+}
+
+func scanResponseAction(scan func(dest ...interface{}) error) (ResponseAction, error) {
+	var a ResponseAction
+	var config, autoRules string
+	if err := scan(&a.ID, &a.Name, &a.Kind, &config, &autoRules, &a.DryRun, &a.CreatedAt); err != nil {
+		return a, err
+	}
+	var c responseConfig
+	if err := json.Unmarshal([]byte(config), &c); err != nil {
+		return a, err
+	}
+	a.URL, a.Method, a.Headers, a.Command, a.Args = c.URL, c.Method, c.Headers, c.Command, c.Args
+	return a, json.Unmarshal([]byte(autoRules), &a.AutoRules)
+}
+
+const responseActionColumns = `id, name, kind, config, auto_rules, dry_run, created_at`
+
+func (d *Database) GetResponseAction(name string) (ResponseAction, error) {
+	return scanResponseAction(d.db.QueryRow(`SELECT `+responseActionColumns+` FROM response_actions WHERE name = ?`, name).Scan)
+}
+
+func (d *Database) GetResponseActions() ([]ResponseAction, error) {
+	rows, err := d.db.Query(`SELECT ` + responseActionColumns + ` FROM response_actions ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	actions := []ResponseAction{}
+	for rows.Next() {
+		a, err := scanResponseAction(rows.Scan)
+		if err != nil {
+			return nil, err
+		}
+		actions = append(actions, a)
+	}
+	return actions, rows.Err()
+}
+
+// RunResponseAction runs (or dry-runs) an action against a target and records the execution
+func (d *Database) RunResponseAction(a ResponseAction, t ResponseTarget, trigger, actor string, dryRun bool) (ResponseExecution, error) {
+	runner := responseRunners[a.Kind]
+	run := ResponseExecution{
+		ActionID:   a.ID,
+		ActionName: a.Name,
+		NotableID:  t.Notable.ID,
+		Trigger:    trigger,
+		Actor:      actor,
+		DryRun:     dryRun || a.DryRun,
+		StartedAt:  d.now().UTC(),
+	}
+	target, err := runner.target(t)
+	run.Target = target
+	switch {
+	case err != nil:
+		run.Status, run.Error = executionFailed, err.Error()
+	case run.DryRun:
+		run.Status, run.Output = executionDryRun, runner.describe(a, t)
+	default:
+		ctx, cancel := context.WithTimeout(context.Background(), responseTimeout)
+		run.Output, err = runner.run(ctx, a, t)
+		cancel()
+		run.Status = executionSucceeded
+		if err != nil {
+			run.Status, run.Error = executionFailed, err.Error()
+		}
+	}
+	if len(run.Output) > responseOutputLimit {
+		run.Output = run.Output[:responseOutputLimit]
+	}
+	run.FinishedAt = d.now().UTC()
+
+	res, err := d.db.Exec(`
+		INSERT INTO response_executions (action_id, action_name, notable_id, target, trigger, actor, dry_run, status, output, error, started_at, finished_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, run.ActionID, run.ActionName, run.NotableID, run.Target, run.Trigger, run.Actor, run.DryRun,
+		run.Status, run.Output, run.Error, run.StartedAt, run.FinishedAt)
+	if err != nil {
+		return run, err
+	}
+	run.ID, err = res.LastInsertId()
+	return run, err
+}
+
+// GetResponseExecutions returns the audit trail, newest first, optionally for one notable
+func (d *Database) GetResponseExecutions(notableID string, limit int) ([]ResponseExecution, error) {
+	query := `
+		SELECT id, action_id, action_name, notable_id, target, trigger, actor, dry_run, status, output, error, started_at, finished_at
+		FROM response_executions`
+	args := []interface{}{}
+	if notableID != "" {
+		query += ` WHERE notable_id = ?`
+		args = append(args, notableID)
+	}
+	query += ` ORDER BY id DESC LIMIT ?`
+	args = append(args, limit)
+	rows, err := d.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	executions := []ResponseExecution{}
+	for rows.Next() {
+		var e ResponseExecution
+		if err := rows.Scan(&e.ID, &e.ActionID, &e.ActionName, &e.NotableID, &e.Target, &e.Trigger, &e.Actor,
+			&e.DryRun, &e.Status, &e.Output, &e.Error, &e.StartedAt, &e.FinishedAt); err != nil {
+			return nil, err
+		}
+		executions = append(executions, e)
+	}
+	return executions, rows.Err()
+}
+
+// autoRespond runs every action whose AutoRules include the notable's rule.
+// It is only called for notables the server generates itself; notables
+// posted to the API name their own rule and targets.
+func (d *Database) autoRespond(n NotableEvent) {
+	actions, err := d.GetResponseActions()
+	if err != nil {
+		log.Printf("responses: failed to load actions: %v", err)
+		return
+	}
+	for _, a := range actions {
+		if !a.triggeredBy(n.RuleName) {
+			continue
+		}
+		t := ResponseTarget{IP: n.SourceIP, User: n.User, Notable: n}
+		if _, err := d.RunResponseAction(a, t, triggerAuto, "", false); err != nil {
+			log.Printf("responses: failed to record %s: %v", a.Name, err)
+		}
+	}
+}
+
+// GET/POST/DELETE /api/responses/actions - manage response actions (admin only)
+func responseActionsHandlerDB(w http.ResponseWriter, r *http.Request, db *Database) {
+	enableCORS(w)
+	w.Header().Set("Content-Type", "application/json")
+	if !db.requireAdmin(w, r) {
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		actions, err := db.GetResponseActions()
+		if err != nil {
+			writeJSONError(w, http.StatusInternalServerError, "Failed to fetch response actions")
+			return
+		}
+		json.NewEncoder(w).Encode(actions)
+	case http.MethodPost:
+		var a ResponseAction
+		if err := json.NewDecoder(r.Body).Decode(&a); err != nil {
+			writeJSONError(w, http.StatusBadRequest, "Invalid JSON")
+			return
+		}
+		if err := a.validate(); err != nil {
+			writeJSONError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		a, err := db.CreateResponseAction(a)
+		if err != nil {
+			writeJSONError(w, http.StatusInternalServerError, "Failed to create response action")
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(a)
+	case http.MethodDelete:
+		id, err := strconv.ParseInt(r.URL.Query().Get("id"), 10, 64)
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, "Invalid id")
+			return
+		}
+		if err := db.DeleteResponseAction(id); err != nil {
+			writeJSONError(w, http.StatusInternalServerError, "Failed to delete response action")
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+	}
+}
+
+// GET /api/responses/executions?notable=&limit= - response audit trail (admin only)
+func responseExecutionsHandlerDB(w http.ResponseWriter, r *http.Request, db *Database) {
+	enableCORS(w)
+	w.Header().Set("Content-Type", "application/json")
+	if !db.requireAdmin(w, r) {
+		return
+	}
+	limit := 100
+	if l, err := strconv.Atoi(r.URL.Query().Get("limit")); err == nil && l > 0 && l <= 1000 {
+		limit = l
+	}
+	executions, err := db.GetResponseExecutions(r.URL.Query().Get("notable"), limit)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "Failed to fetch response executions")
+		return
+	}
+	json.NewEncoder(w).Encode(executions)
+}
+
+// respondToNotable handles POST /api/notables/{id}/respond (admin only)
+func respondToNotable(w http.ResponseWriter, r *http.Request, db *Database, id int64) {
+	if !db.requireAdmin(w, r) {
+		return
+	}
+	var req struct {
+		Action string `json:"action"`
+		IP     string `json:"ip"`
+		User   string `json:"user"`
+		Actor  string `json:"actor"`
+		DryRun bool   `json:"dryRun"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Action == "" {
+		writeJSONError(w, http.StatusBadRequest, "action is required")
+		return
+	}
+	n, err := db.GetNotable(id)
+	if errors.Is(err, sql.ErrNoRows) {
+		writeJSONError(w, http.StatusNotFound, "Notable not found")
+		return
+	}
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "Failed to fetch notable")
+		return
+	}
+	a, err := db.GetResponseAction(req.Action)
+	if errors.Is(err, sql.ErrNoRows) {
+		writeJSONError(w, http.StatusNotFound, "Response action not found")
+		return
+	}
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "Failed to fetch response action")
+		return
+	}
+	t := ResponseTarget{IP: n.SourceIP, User: n.User, Notable: n}
+	if req.IP != "" {
+		t.IP = req.IP
+	}
+	if req.User != "" {
+		t.User = req.User
+	}
+	if req.Actor == "" {
+		req.Actor = r.Header.Get("X-Actor")
+	}
+	run, err := db.RunResponseAction(a, t, triggerManual, req.Actor, req.DryRun)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "Failed to record response execution")
+		return
+	}
+	json.NewEncoder(w).Encode(run)
+}
@@ -0,0 +1,410 @@
+package logserver
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"math"
+	"net/http"
+	"time"
+)
+
+// RiskWeights weight each factor in a notable's risk score; they need not sum to 1
+type RiskWeights struct {
+	Urgency          float64 `json:"urgency"`
+	AssetCriticality float64 `json:"assetCriticality"`
+	ThreatIntel      float64 `json:"threatIntel"`
+	Recurrence       float64 `json:"recurrence"`
+}
+
+var defaultRiskWeights = RiskWeights{Urgency: 0.4, AssetCriticality: 0.25, ThreatIntel: 0.2, Recurrence: 0.15}
+
+// RiskFactors are the normalized (0-1) inputs to a notable's risk score
+type RiskFactors struct {
+	Urgency          float64 `json:"urgency"`
+	AssetCriticality float64 `json:"assetCriticality"`
+	ThreatIntel      float64 `json:"threatIntel"`
+	Recurrence       float64 `json:"recurrence"`
+}
+
+// Asset assigns a criticality to an IP address, hostname or user
+type Asset struct {
+	Value       string `json:"value"`
+	Criticality string `json:"criticality"` // low, medium, high, critical
+}
+
+// ThreatIndicator is a known-bad IP address, hostname or user
+type ThreatIndicator struct {
+	Indicator string    `json:"indicator"`
+	Source    string    `json:"source"`
+	AddedAt   time.Time `json:"addedAt"`
+}
+
+// severityLevels maps asset criticality names to a 0-1 factor
+var severityLevels = map[string]float64{
+	"low":      0.25,
+	"medium":   0.5,
+	"high":     0.75,
+	"critical": 1,
+}
+
+// recurrenceWindow and recurrenceSaturation: a rule firing for the same source
+// recurrenceSaturation times within the window maxes out the recurrence factor
+const (
+	recurrenceWindow     = 24 * time.Hour
+	recurrenceSaturation = 10
+)
+
+func createRiskTables(db *sql.DB) error {
+	for _, stmt := range []string{
+		`CREATE TABLE IF NOT EXISTS risk_settings (
+			id INTEGER PRIMARY KEY CHECK (id = 1),
+			weights TEXT NOT NULL
+		)`,
+		`CREATE TABLE IF NOT EXISTS assets (
+			value TEXT PRIMARY KEY,
+			criticality TEXT NOT NULL
+		)`,
+		`CREATE TABLE IF NOT EXISTS threat_indicators (
+			indicator TEXT PRIMARY KEY,
+			source TEXT NOT NULL DEFAULT '',
+			added_at DATETIME NOT NULL
+		)`,
+	} {
+		if _, err := db.Exec(stmt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (w RiskWeights) validate() error {
+	for _, v := range []float64{w.Urgency, w.AssetCriticality, w.ThreatIntel, w.Recurrence} {
+		if v < 0 || math.IsNaN(v) || math.IsInf(v, 0) {
+			return errors.New("weights must be non-negative numbers")
+		}
+	}
+	if w.Urgency+w.AssetCriticality+w.ThreatIntel+w.Recurrence == 0 {
+		return errors.New("at least one weight must be positive")
+	}
+	return nil
+}
+
+// score combines the factors into a 0-100 risk score
+func (w RiskWeights) score(f RiskFactors) float64 {
+	total := w.Urgency + w.AssetCriticality + w.ThreatIntel + w.Recurrence
+	sum := w.Urgency*f.Urgency + w.AssetCriticality*f.AssetCriticality + w.ThreatIntel*f.ThreatIntel + w.Recurrence*f.Recurrence
+	return math.Round(sum/total*1000) / 10
+}
+
+func (d *Database) GetRiskWeights() (RiskWeights, error) {
+	var raw string
+	err := d.db.QueryRow(`SELECT weights FROM risk_settings WHERE id = 1`).Scan(&raw)
+	if errors.Is(err, sql.ErrNoRows) {
+		return defaultRiskWeights, nil
+	}
+	if err != nil {
+		return RiskWeights{}, err
+	}
+	var w RiskWeights
+	return w, json.Unmarshal([]byte(raw), &w)
+}
+
+// SetRiskWeights stores new weights and rescores every open notable from its stored factors
+func (d *Database) SetRiskWeights(w RiskWeights) error {
+	if err := storeRiskWeights(d.db, w); err != nil {
+		return err
+	}
+	return d.rescoreNotables(w)
+}
+
+func storeRiskWeights(ex execer, w RiskWeights) error {
+	raw, _ := json.Marshal(w)
+	_, err := ex.Exec(`
+		INSERT INTO risk_settings (id, weights) VALUES (1, ?)
+		ON CONFLICT(id) DO UPDATE SET weights = excluded.weights
+	`, string(raw))
+	return err
+}
+
+func (d *Database) rescoreNotables(w RiskWeights) error {
+	rows, err := d.db.Query(`SELECT id, risk_factors, risk_score FROM notables WHERE status != ?`, notableStatusResolved)
+	if err != nil {
+		return err
+	}
+	// Only notables whose score moves are updated
+	scores := map[int64]float64{}
+	for rows.Next() {
+		var id int64
+		var raw string
+		var current float64
+		if err := rows.Scan(&id, &raw, &current); err != nil {
+			rows.Close()
+			return err
+		}
+		var f RiskFactors
+		if err := json.Unmarshal([]byte(raw), &f); err != nil {
+			rows.Close()
+			return err
+		}
+		if score := w.score(f); score != current {
+			scores[id] = score
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	tx, err := d.db.Begin()
+	if err != nil {
+		return err
+	}
+	for id, score := range scores {
+		if _, err := tx.Exec(`UPDATE notables SET risk_score = ? WHERE id = ?`, score, id); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	for id := range scores {
+		d.recordNotableChange(id, changeRescored)
+	}
+	return nil
+}
+
+// riskFactors evaluates the scoring inputs for a notable that is about to be created
+func (d *Database) riskFactors(n NotableEvent) (RiskFactors, error) {
+	f := RiskFactors{Urgency: d.levels.urgencyFactor(n.Urgency)}
+	entities := []interface{}{n.SourceIP, n.Destination, n.User}
+
+	var criticality sql.NullString
+	err := d.db.QueryRow(`
+		SELECT criticality FROM assets WHERE value IN (?, ?, ?) AND value != ''
+		ORDER BY CASE criticality WHEN 'critical' THEN 4 WHEN 'high' THEN 3 WHEN 'medium' THEN 2 ELSE 1 END DESC
+		LIMIT 1
+	`, entities...).Scan(&criticality)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return f, err
+	}
+	f.AssetCriticality = severityLevels[criticality.String]
+
+	var hits int
+	if err := d.db.QueryRow(`
+		SELECT COUNT(*) FROM threat_indicators WHERE indicator IN (?, ?, ?) AND indicator != ''
+	`, entities...).Scan(&hits); err != nil {
+		return f, err
+	}
+	if hits > 0 {
+		f.ThreatIntel = 1
+	}
+
+	var recurrences int
+	if err := d.db.QueryRow(`
+		SELECT COUNT(*) FROM notables WHERE rule_name = ? AND source_ip = ? AND created_at >= ?
+	`, n.RuleName, n.SourceIP, n.Timestamp.UTC().Add(-recurrenceWindow)).Scan(&recurrences); err != nil {
+		return f, err
+	}
+	f.Recurrence = math.Min(float64(recurrences), recurrenceSaturation) / recurrenceSaturation
+	return f, nil
+}
+
+// scoreNotable fills in the notable's risk factors and score
+func (d *Database) scoreNotable(n *NotableEvent) error {
+	weights, err := d.GetRiskWeights()
+	if err != nil {
+		return err
+	}
+	f, err := d.riskFactors(*n)
+	if err != nil {
+		return err
+	}
+	n.RiskFactors = &f
+	n.RiskScore = weights.score(f)
+	return nil
+}
+
+func (d *Database) UpsertAsset(a Asset) error {
+	_, err := d.db.Exec(`
+		INSERT INTO assets (value, criticality) VALUES (?, ?)
+		ON CONFLICT(value) DO UPDATE SET criticality = excluded.criticality
+	`, a.Value, a.Criticality)
+	return err
+}
+
+func (d *Database) DeleteAsset(value string) error {
+	_, err := d.db.Exec(`DELETE FROM assets WHERE value = ?`, value)
+	return err
+}
+
+func (d *Database) GetAssets() ([]Asset, error) {
+	rows, err := d.db.Query(`SELECT value, criticality FROM assets ORDER BY value`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	assets := []Asset{}
+	for rows.Next() {
+		var a Asset
+		if err := rows.Scan(&a.Value, &a.Criticality); err != nil {
+			return nil, err
+		}
+		assets = append(assets, a)
+	}
+	return assets, rows.Err()
+}
+
+func (d *Database) AddThreatIndicator(t ThreatIndicator) (ThreatIndicator, error) {
+	t.AddedAt = d.now().UTC()
+	_, err := d.db.Exec(`
+		INSERT INTO threat_indicators (indicator, source, added_at) VALUES (?, ?, ?)
+		ON CONFLICT(indicator) DO UPDATE SET source = excluded.source
+	`, t.Indicator, t.Source, t.AddedAt)
+	return t, err
+}
+
+func (d *Database) DeleteThreatIndicator(indicator string) error {
+	_, err := d.db.Exec(`DELETE FROM threat_indicators WHERE indicator = ?`, indicator)
+	return err
+}
+
+func (d *Database) GetThreatIndicators() ([]ThreatIndicator, error) {
+	rows, err := d.db.Query(`SELECT indicator, source, added_at FROM threat_indicators ORDER BY indicator`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	indicators := []ThreatIndicator{}
+	for rows.Next() {
+		var t ThreatIndicator
+		if err := rows.Scan(&t.Indicator, &t.Source, &t.AddedAt); err != nil {
+			return nil, err
+		}
+		indicators = append(indicators, t)
+	}
+	return indicators, rows.Err()
+}
+
+// GET/PUT /api/risk/weights - read or replace the risk scoring weights (admin only)
+func riskWeightsHandlerDB(w http.ResponseWriter, r *http.Request, db *Database) {
+	enableCORS(w)
+	w.Header().Set("Content-Type", "application/json")
+	if !db.requireAdmin(w, r) {
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		weights, err := db.GetRiskWeights()
+		if err != nil {
+			writeJSONError(w, http.StatusInternalServerError, "Failed to fetch risk weights")
+			return
+		}
+		json.NewEncoder(w).Encode(weights)
+	case http.MethodPut:
+		var weights RiskWeights
+		if err := json.NewDecoder(r.Body).Decode(&weights); err != nil {
+			writeJSONError(w, http.StatusBadRequest, "Invalid JSON")
+			return
+		}
+		if err := weights.validate(); err != nil {
+			writeJSONError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if err := db.SetRiskWeights(weights); err != nil {
+			writeJSONError(w, http.StatusInternalServerError, "Failed to update risk weights")
+			return
+		}
+		json.NewEncoder(w).Encode(weights)
+	default:
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+	}
+}
+
+// GET/POST/DELETE /api/risk/assets - manage asset criticality (admin only)
+func assetsHandlerDB(w http.ResponseWriter, r *http.Request, db *Database) {
+	enableCORS(w)
+	w.Header().Set("Content-Type", "application/json")
+	if !db.requireAdmin(w, r) {
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		assets, err := db.GetAssets()
+		if err != nil {
+			writeJSONError(w, http.StatusInternalServerError, "Failed to fetch assets")
+			return
+		}
+		json.NewEncoder(w).Encode(assets)
+	case http.MethodPost:
+		var a Asset
+		if err := json.NewDecoder(r.Body).Decode(&a); err != nil {
+			writeJSONError(w, http.StatusBadRequest, "Invalid JSON")
+			return
+		}
+		if _, ok := severityLevels[a.Criticality]; a.Value == "" || !ok {
+			writeJSONError(w, http.StatusBadRequest, "value and criticality (low, medium, high, critical) are required")
+			return
+		}
+		if err := db.UpsertAsset(a); err != nil {
+			writeJSONError(w, http.StatusInternalServerError, "Failed to save asset")
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(a)
+	case http.MethodDelete:
+		if err := db.DeleteAsset(r.URL.Query().Get("value")); err != nil {
+			writeJSONError(w, http.StatusInternalServerError, "Failed to delete asset")
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+	}
+}
+
+// GET/POST/DELETE /api/risk/intel - manage threat-intel indicators (admin only)
+func threatIntelHandlerDB(w http.ResponseWriter, r *http.Request, db *Database) {
+	enableCORS(w)
+	w.Header().Set("Content-Type", "application/json")
+	if !db.requireAdmin(w, r) {
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		indicators, err := db.GetThreatIndicators()
+		if err != nil {
+			writeJSONError(w, http.StatusInternalServerError, "Failed to fetch indicators")
+			return
+		}
+		json.NewEncoder(w).Encode(indicators)
+	case http.MethodPost:
+		var t ThreatIndicator
+		if err := json.NewDecoder(r.Body).Decode(&t); err != nil {
+			writeJSONError(w, http.StatusBadRequest, "Invalid JSON")
+			return
+		}
+		if t.Indicator == "" {
+			writeJSONError(w, http.StatusBadRequest, "indicator is required")
+			return
+		}
+		t, err := db.AddThreatIndicator(t)
+		if err != nil {
+			writeJSONError(w, http.StatusInternalServerError, "Failed to save indicator")
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(t)
+	case http.MethodDelete:
+		if err := db.DeleteThreatIndicator(r.URL.Query().Get("indicator")); err != nil {
+			writeJSONError(w, http.StatusInternalServerError, "Failed to delete indicator")
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+	}
+}
@@ -0,0 +1,291 @@
+package logserver
+
+import (
+	"database/sql"
+	"sync"
+	"time"
+)
+
+// Dimensions tracked by the unique-count rollups
+const (
+	uniqueSourceIPs = "source_ip"
+	uniqueUsers     = "user"
+	uniqueRules     = "rule"
+)
+
+const rollupFlushInterval = 30 * time.Second
+
+type rollupKey struct {
+	bucket    int64 // unix seconds at the start of the hour
+	dimension string
+}
+
+// uniqueRollups caches the hourly HLL sketches being written to so that
+// ingest doesn't round-trip to SQLite for every entry
+type uniqueRollups struct {
+	mu       sync.Mutex
+	sketches map[rollupKey]*HyperLogLog
+	dirty    map[rollupKey]bool
+}
+
+// UniqueBucket holds cardinality estimates for one time bucket
+type UniqueBucket struct {
+	Start     time.Time `json:"start"`
+	SourceIPs int       `json:"sourceIPs"`
+	Users     int       `json:"users"`
+	Rules     int       `json:"rules"`
+	// Search lists the logs of the hours merged into the bucket
+	Search string `json:"search"`
+}
+
+// UniqueCounts is the response of the unique-counts aggregation
+type UniqueCounts struct {
+	Buckets []UniqueBucket `json:"buckets"`
+	Total   UniqueBucket   `json:"total"`
+}
+
+func createRollupTables(db *sql.DB) error {
+	_, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS unique_rollups (
+			bucket INTEGER NOT NULL,
+			dimension TEXT NOT NULL,
+			registers BLOB NOT NULL,
+			PRIMARY KEY (bucket, dimension)
+		)
+	`)
+	return err
+}
+
+// backfillRollups builds sketches for logs ingested before rollups existed
+func (d *Database) backfillRollups() error {
+	var existing int
+	if err := d.db.QueryRow(`SELECT COUNT(*) FROM unique_rollups`).Scan(&existing); err != nil {
+		return err
+	}
+	if existing > 0 || d.rowCount.Load() == 0 {
+		return nil
+	}
+	rows, err := d.db.Query(`SELECT timestamp, source_ip, user_name, rule FROM logs`)
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var log LogEntry
+		if err := rows.Scan(&log.Timestamp, &log.SourceIP, &log.User, &log.Rule); err != nil {
+			rows.Close()
+			return err
+		}
+		if err := d.recordUniques(log); err != nil {
+			rows.Close()
+			return err
+		}
+	}
+	rows.Close()
+	return d.flushRollups()
+}
+
+// recordUniques adds the entry's source IP, user and rule to its hourly sketches
+func (d *Database) recordUniques(log LogEntry) error {
+	bucket := log.Timestamp.UTC().Truncate(time.Hour).Unix()
+	values := map[string]string{
+		uniqueSourceIPs: log.SourceIP,
+		uniqueUsers:     log.User,
+		uniqueRules:     log.Rule,
+	}
+	d.uniques.mu.Lock()
+	defer d.uniques.mu.Unlock()
+	for dimension, value := range values {
+		if value == "" {
+			continue
+		}
+		key := rollupKey{bucket: bucket, dimension: dimension}
+		sketch, ok := d.uniques.sketches[key]
+		if !ok {
+			var err error
+			sketch, err = d.loadSketch(key)
+			if err != nil {
+				return err
+			}
+			d.uniques.sketches[key] = sketch
+		}
+		sketch.Add(value)
+		d.uniques.dirty[key] = true
+	}
+	return nil
+}
+
+// recountUniques rebuilds the sketches of the given hours from the logs
+// still stored, after logs in them were deleted
+func (d *Database) recountUniques(hours map[int64]bool) error {
+	for bucket := range hours {
+		start := time.Unix(bucket, 0).UTC()
+		sketches := map[string]*HyperLogLog{
+			uniqueSourceIPs: NewHyperLogLog(),
+			uniqueUsers:     NewHyperLogLog(),
+			uniqueRules:     NewHyperLogLog(),
+		}
+		// Ingest waits on the lock, so no log of the hour is missed while
+		// its sketches are swapped
+		d.uniques.mu.Lock()
+		err := func() error {
+			rows, err := d.db.Query(`SELECT source_ip, user_name, rule FROM logs WHERE timestamp >= ? AND timestamp < ?`,
+				start, start.Add(time.Hour))
+			if err != nil {
+				return err
+			}
+			defer rows.Close()
+			for rows.Next() {
+				var sourceIP, user, rule string
+				if err := rows.Scan(&sourceIP, &user, &rule); err != nil {
+					return err
+				}
+				for dimension, value := range map[string]string{uniqueSourceIPs: sourceIP, uniqueUsers: user, uniqueRules: rule} {
+					if value != "" {
+						sketches[dimension].Add(value)
+					}
+				}
+			}
+			return rows.Err()
+		}()
+		if err == nil {
+			for dimension, sketch := range sketches {
+				key := rollupKey{bucket: bucket, dimension: dimension}
+				d.uniques.sketches[key] = sketch
+				d.uniques.dirty[key] = true
+			}
+		}
+		d.uniques.mu.Unlock()
+		if err != nil {
+			return err
+		}
+	}
+	return d.flushRollups()
+}
+
+func (d *Database) loadSketch(key rollupKey) (*HyperLogLog, error) {
+	var registers []byte
+	err := d.db.QueryRow(`SELECT registers FROM unique_rollups WHERE bucket = ? AND dimension = ?`, key.bucket, key.dimension).Scan(&registers)
+	if err == sql.ErrNoRows {
+		return NewHyperLogLog(), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return hyperLogLogFromBytes(registers), nil
+}
+
+// flushRollups persists dirty sketches and evicts buckets older than two hours
+func (d *Database) flushRollups() error {
+	d.uniques.mu.Lock()
+	defer d.uniques.mu.Unlock()
+	for key := range d.uniques.dirty {
+		_, err := d.db.Exec(`
+			INSERT INTO unique_rollups (bucket, dimension, registers) VALUES (?, ?, ?)
+			ON CONFLICT(bucket, dimension) DO UPDATE SET registers = excluded.registers
+		`, key.bucket, key.dimension, d.uniques.sketches[key].Bytes())
+		if err != nil {
+			return err
+		}
+		delete(d.uniques.dirty, key)
+	}
+	cutoff := d.now().Add(-2 * time.Hour).Unix()
+	for key := range d.uniques.sketches {
+		if key.bucket < cutoff {
+			delete(d.uniques.sketches, key)
+		}
+	}
+	return nil
+}
+
+func (d *Database) flushRollupsLoop() {
+	ticker := time.NewTicker(rollupFlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			d.flushRollups()
+		case <-d.done:
+			return
+		}
+	}
+}
+
+// GetUniqueCounts merges hourly sketches into buckets of the given size
+func (d *Database) GetUniqueCounts(from, to time.Time, interval time.Duration) (UniqueCounts, error) {
+	result := UniqueCounts{Buckets: []UniqueBucket{}}
+	if err := d.flushRollups(); err != nil {
+		return result, err
+	}
+	rows, err := d.db.Query(`
+		SELECT bucket, dimension, registers
+		FROM unique_rollups
+		WHERE bucket >= ? AND bucket < ?
+		ORDER BY bucket
+	`, from.UTC().Truncate(time.Hour).Unix(), to.Unix())
+	if err != nil {
+		return result, err
+	}
+	defer rows.Close()
+
+	type merged map[string]*HyperLogLog
+	buckets := map[int64]merged{}
+	var order []int64
+	total := merged{}
+	for rows.Next() {
+		var bucket int64
+		var dimension string
+		var registers []byte
+		if err := rows.Scan(&bucket, &dimension, &registers); err != nil {
+			return result, err
+		}
+		sketch := hyperLogLogFromBytes(registers)
+		start := time.Unix(bucket, 0).UTC().Truncate(interval).Unix()
+		if _, ok := buckets[start]; !ok {
+			buckets[start] = merged{}
+			order = append(order, start)
+		}
+		for _, m := range []merged{buckets[start], total} {
+			if m[dimension] == nil {
+				m[dimension] = NewHyperLogLog()
+			}
+			m[dimension].Merge(sketch)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return result, err
+	}
+
+	// The merged sketches cover whole hours
+	first := from.UTC().Truncate(time.Hour)
+	last := to.Truncate(time.Hour)
+	if last.Before(to) {
+		last = last.Add(time.Hour)
+	}
+	estimate := func(start, end time.Time, m merged) UniqueBucket {
+		f := LogFilter{From: start, To: end}
+		if f.From.Before(first) {
+			f.From = first
+		}
+		if f.To.After(last) {
+			f.To = last
+		}
+		b := UniqueBucket{Start: start, Search: searchURL(f)}
+		if s := m[uniqueSourceIPs]; s != nil {
+			b.SourceIPs = s.Estimate()
+		}
+		if s := m[uniqueUsers]; s != nil {
+			b.Users = s.Estimate()
+		}
+		if s := m[uniqueRules]; s != nil {
+			b.Rules = s.Estimate()
+		}
+		return b
+	}
+	for _, start := range order {
+		bucket := time.Unix(start, 0).UTC()
+		result.Buckets = append(result.Buckets, estimate(bucket, bucket.Add(interval), buckets[start]))
+	}
+	result.Total = estimate(first, last, total)
+	result.Total.Start = from.UTC()
+	return result, nil
+}
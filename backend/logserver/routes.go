@@ -0,0 +1,295 @@
+package logserver
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Route decides where matching logs go: whether they are kept in the local
+// store and which outputs they are forwarded to. Routes are tried in
+// Position order and the first match wins; logs matching no route are
+// stored and offered to every output.
+type Route struct {
+	ID       int64     `json:"id"`
+	Name     string    `json:"name"`
+	Position int       `json:"position"`
+	Filter   LogFilter `json:"filter"`
+	// Store keeps matching logs in the local store
+	Store bool `json:"store"`
+	// Outputs names the outputs matching logs are forwarded to, each still
+	// subject to the output's own filter
+	Outputs   []string  `json:"outputs"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// IngestedLog is the message published on topicLogs: a log and where its
+// route sends it
+type IngestedLog struct {
+	Entry LogEntry
+	// Route is the name of the matching route, "" when none matched
+	Route string
+	Store bool
+	// Outputs is nil when every output may take the log
+	Outputs map[string]bool
+
+	// batch is set while an ingest batch commit publishes the log
+	batch *batchPosition
+}
+
+// forwardsTo reports whether the log may go to the named output
+func (m *IngestedLog) forwardsTo(output string) bool {
+	return m.Outputs == nil || m.Outputs[output]
+}
+
+// routeCache holds the routes so ingest does not query them per log
+type routeCache struct {
+	mu     sync.RWMutex
+	routes []Route
+}
+
+func createRouteTables(db *sql.DB) error {
+	_, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS routes (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			name TEXT NOT NULL UNIQUE,
+			position INTEGER NOT NULL DEFAULT 0,
+			filter TEXT NOT NULL,
+			store BOOLEAN NOT NULL,
+			outputs TEXT NOT NULL,
+			created_at DATETIME NOT NULL
+		)
+	`)
+	return err
+}
+
+func (rt *Route) validate(d *Database) error {
+	if !iacExternalID.MatchString(rt.Name) {
+		return errors.New("name must be 1-128 letters, digits, '.', '_' or '-'")
+	}
+	if _, err := parseLogFilter(rt.Filter.values(), d.now()); err != nil {
+		return fmt.Errorf("filter: %v", err)
+	}
+	if rt.Outputs == nil {
+		rt.Outputs = []string{}
+	}
+	outputs, err := d.GetOutputs()
+	if err != nil {
+		return err
+	}
+	known := make(map[string]bool, len(outputs))
+	for _, o := range outputs {
+		known[o.Name] = true
+	}
+	for _, name := range rt.Outputs {
+		if !known[name] {
+			return fmt.Errorf("unknown output %q", name)
+		}
+	}
+	return nil
+}
+
+func (d *Database) loadRoutes() error {
+	rows, err := d.db.Query(`SELECT id, name, position, filter, store, outputs, created_at FROM routes ORDER BY position, id`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	routes := []Route{}
+	for rows.Next() {
+		var rt Route
+		var filter, outputs string
+		if err := rows.Scan(&rt.ID, &rt.Name, &rt.Position, &filter, &rt.Store, &outputs, &rt.CreatedAt); err != nil {
+			return err
+		}
+		if err := json.Unmarshal([]byte(filter), &rt.Filter); err != nil {
+			return err
+		}
+		if err := json.Unmarshal([]byte(outputs), &rt.Outputs); err != nil {
+			return err
+		}
+		routes = append(routes, rt)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	d.routes.mu.Lock()
+	d.routes.routes = routes
+	d.routes.mu.Unlock()
+	return nil
+}
+
+func (d *Database) GetRoutes() []Route {
+	d.routes.mu.RLock()
+	defer d.routes.mu.RUnlock()
+	return append([]Route{}, d.routes.routes...)
+}
+
+func (d *Database) CreateRoute(rt Route) (Route, error) {
+	rt, err := d.createRoute(d.db, rt)
+	if err != nil {
+		return rt, err
+	}
+	return rt, d.loadRoutes()
+}
+
+// createRoute stores a route without reloading the routes ingest applies
+func (d *Database) createRoute(ex execer, rt Route) (Route, error) {
+	filter, _ := json.Marshal(rt.Filter)
+	outputs, _ := json.Marshal(rt.Outputs)
+	rt.CreatedAt = d.now().UTC()
+	res, err := ex.Exec(`INSERT INTO routes (name, position, filter, store, outputs, created_at) VALUES (?, ?, ?, ?, ?, ?)`,
+		rt.Name, rt.Position, string(filter), rt.Store, string(outputs), rt.CreatedAt)
+	if err != nil {
+		return rt, err
+	}
+	rt.ID, err = res.LastInsertId()
+	return rt, err
+}
+
+func (d *Database) UpdateRoute(rt Route) error {
+	if err := updateRoute(d.db, rt); err != nil {
+		return err
+	}
+	return d.loadRoutes()
+}
+
+func updateRoute(ex execer, rt Route) error {
+	filter, _ := json.Marshal(rt.Filter)
+	outputs, _ := json.Marshal(rt.Outputs)
+	res, err := ex.Exec(`UPDATE routes SET name = ?, position = ?, filter = ?, store = ?, outputs = ? WHERE id = ?`,
+		rt.Name, rt.Position, string(filter), rt.Store, string(outputs), rt.ID)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+func (d *Database) DeleteRoute(id int64) error {
+	if err := deleteRoute(d.db, id); err != nil {
+		return err
+	}
+	return d.loadRoutes()
+}
+
+func deleteRoute(ex execer, id int64) error {
+	_, err := ex.Exec(`DELETE FROM routes WHERE id = ?`, id)
+	return err
+}
+
+// route fills in where the log goes from the first matching route
+func (d *Database) route(m *IngestedLog) {
+	m.Route, m.Store, m.Outputs = "", true, nil
+	// loadRoutes swaps in a new slice rather than changing this one, so it
+	// is safe to range over without the copy GetRoutes makes
+	d.routes.mu.RLock()
+	routes := d.routes.routes
+	d.routes.mu.RUnlock()
+	for _, rt := range routes {
+		if !rt.Filter.matches(m.Entry) {
+			continue
+		}
+		m.Route, m.Store = rt.Name, rt.Store
+		m.Outputs = make(map[string]bool, len(rt.Outputs))
+		for _, name := range rt.Outputs {
+			m.Outputs[name] = true
+		}
+		return
+	}
+}
+
+// GET/POST/PUT/DELETE /api/routes - manage ingest routes (admin only)
+// POST /api/routes/test - show where a log would be routed
+func routesHandlerDB(w http.ResponseWriter, r *http.Request, db *Database) {
+	enableCORS(w)
+	w.Header().Set("Content-Type", "application/json")
+	if !db.requireAdmin(w, r) {
+		return
+	}
+	if strings.TrimSuffix(r.URL.Path, "/") == "/api/routes/test" {
+		if r.Method != http.MethodPost {
+			writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+			return
+		}
+		var m IngestedLog
+		if err := json.NewDecoder(r.Body).Decode(&m.Entry); err != nil {
+			writeJSONError(w, http.StatusBadRequest, "Invalid JSON")
+			return
+		}
+		db.route(&m)
+		outputs := []string{}
+		all, err := db.GetOutputs()
+		if err != nil {
+			writeJSONError(w, http.StatusInternalServerError, "Failed to fetch outputs")
+			return
+		}
+		for _, o := range all {
+			if m.forwardsTo(o.Name) && o.Filter.matches(m.Entry) {
+				outputs = append(outputs, o.Name)
+			}
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"route":   m.Route,
+			"store":   m.Store,
+			"outputs": outputs,
+		})
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		json.NewEncoder(w).Encode(db.GetRoutes())
+	case http.MethodPost, http.MethodPut:
+		var rt Route
+		if err := json.NewDecoder(r.Body).Decode(&rt); err != nil {
+			writeJSONError(w, http.StatusBadRequest, "Invalid JSON")
+			return
+		}
+		if err := rt.validate(db); err != nil {
+			writeJSONError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if r.Method == http.MethodPut {
+			err := db.UpdateRoute(rt)
+			if errors.Is(err, sql.ErrNoRows) {
+				writeJSONError(w, http.StatusNotFound, "Route not found")
+				return
+			}
+			if err != nil {
+				writeJSONError(w, http.StatusConflict, "A route with that name already exists")
+				return
+			}
+			json.NewEncoder(w).Encode(rt)
+			return
+		}
+		rt, err := db.CreateRoute(rt)
+		if err != nil {
+			writeJSONError(w, http.StatusConflict, "A route with that name already exists")
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(rt)
+	case http.MethodDelete:
+		id, err := strconv.ParseInt(r.URL.Query().Get("id"), 10, 64)
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, "Invalid id")
+			return
+		}
+		if err := db.DeleteRoute(id); err != nil {
+			writeJSONError(w, http.StatusInternalServerError, "Failed to delete route")
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+	}
+}
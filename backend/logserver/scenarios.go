@@ -0,0 +1,236 @@
+package logserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// Attack scenarios. Each scenario scripts the logs of one attack, with the
+// rule names, categories and pacing that exercise the alert rules and the
+// access, network, threat and UBA tiles end to end. A scenario is played
+// through the replay engine, so it is paced, tagged and tracked like a
+// replay of real traffic.
+
+// defaultScenarioTag marks scenario logs unless a request names another tag
+const defaultScenarioTag = "scenario"
+
+// replayFromScenario is the source of a replay that plays a scenario
+const replayFromScenario = "scenario"
+
+// Scenario describes a scripted attack
+type Scenario struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	// Minutes is how long the attack takes at speed 1
+	Minutes int `json:"minutes"`
+
+	generate func(rng *rand.Rand) []LogEntry
+}
+
+// ScenarioRequest is the body of POST /api/scenarios. The same Seed
+// generates the same logs.
+type ScenarioRequest struct {
+	Scenario string  `json:"scenario"`
+	Speed    float64 `json:"speed"`
+	Tag      string  `json:"tag"`
+	Seed     int64   `json:"seed"`
+	Actor    string  `json:"actor"`
+}
+
+var scenarios = []Scenario{
+	{
+		Name:        "brute_force",
+		Description: "Failed SSH logins from one address that ramp up across several accounts and end in a successful login",
+		Minutes:     15,
+		generate:    bruteForceScenario,
+	},
+	{
+		Name:        "lateral_movement",
+		Description: "A compromised account scans and logs in to hosts across three internal ranges, then reaches the domain controller",
+		Minutes:     20,
+		generate:    lateralMovementScenario,
+	},
+	{
+		Name:        "data_exfil",
+		Description: "Routine outbound traffic from a workstation, then a burst of large uploads to an unfamiliar external address",
+		Minutes:     10,
+		generate:    dataExfilScenario,
+	},
+}
+
+func findScenario(name string) (Scenario, bool) {
+	for _, s := range scenarios {
+		if s.Name == name {
+			return s, true
+		}
+	}
+	return Scenario{}, false
+}
+
+// scenarioLog is a scenario log at offset from the start of the attack
+func scenarioLog(offset time.Duration, level, rule, src, dst, event, description string, urgency int) LogEntry {
+	return LogEntry{
+		Timestamp:     time.Unix(0, 0).UTC().Add(offset),
+		Level:         level,
+		Rule:          rule,
+		SourceIP:      src,
+		DestinationIP: dst,
+		Event:         event,
+		Description:   description,
+		Urgency:       urgency,
+	}
+}
+
+func bruteForceScenario(rng *rand.Rand) []LogEntry {
+	attacker := fmt.Sprintf("203.0.113.%d", 2+rng.Intn(250))
+	target := fmt.Sprintf("10.0.0.%d", 2+rng.Intn(250))
+	users := []string{"admin", "root", "jsmith", "svc-backup", "oracle"}
+	// Attempts per minute in each five-minute phase
+	phases := []struct{ perMinute, urgency int }{{1, 1}, {6, 2}, {30, 3}}
+	var logs []LogEntry
+	for p, phase := range phases {
+		for i := 0; i < 5*phase.perMinute; i++ {
+			offset := time.Duration(p)*5*time.Minute + time.Duration(i)*time.Minute/time.Duration(phase.perMinute) +
+				time.Duration(rng.Intn(1000))*time.Millisecond
+			user := users[rng.Intn(len(users))]
+			l := scenarioLog(offset, "WARN", "Brute Force Login", attacker, target, "Failed login",
+				fmt.Sprintf("Failed password for %s from %s ssh2", user, attacker), phase.urgency)
+			l.User, l.SourcePort, l.DestinationPort = user, 40000+rng.Intn(25000), 22
+			logs = append(logs, l)
+		}
+	}
+	l := scenarioLog(15*time.Minute, "ERROR", "Brute Force Login", attacker, target, "Successful login after failures",
+		"Accepted password for svc-backup from "+attacker+" ssh2", 4)
+	l.User, l.SourcePort, l.DestinationPort = "svc-backup", 40000+rng.Intn(25000), 22
+	return append(logs, l)
+}
+
+func lateralMovementScenario(rng *rand.Rand) []LogEntry {
+	user := []string{"jdoe", "mchen", "apatel"}[rng.Intn(3)]
+	host := fmt.Sprintf("10.0.1.%d", 10+rng.Intn(200))
+	var logs []LogEntry
+	offset := time.Duration(0)
+	for _, subnet := range []string{"10.0.1", "10.0.2", "10.0.3"} {
+		// Probe the range for SMB, then log in to one of the hosts that answered
+		var next string
+		for i := 0; i < 12; i++ {
+			peer := fmt.Sprintf("%s.%d", subnet, 2+rng.Intn(250))
+			l := scenarioLog(offset, "INFO", "Internal Network Traffic", host, peer, "SMB connection",
+				"Connection from "+host+" to "+peer+":445", 1)
+			l.User, l.SourcePort, l.DestinationPort = user, 49152+rng.Intn(16000), 445
+			logs = append(logs, l)
+			offset += time.Duration(2+rng.Intn(8)) * time.Second
+			if next == "" || rng.Intn(4) == 0 {
+				next = peer
+			}
+		}
+		offset += time.Duration(2+rng.Intn(3)) * time.Minute
+		l := scenarioLog(offset, "WARN", "Lateral Movement Behavior", host, next, "Remote service login",
+			user+" logged in to "+next+" over RDP from "+host, 3)
+		l.User, l.SourcePort, l.DestinationPort = user, 49152+rng.Intn(16000), 3389
+		logs = append(logs, l)
+		host = next
+		offset += time.Duration(3+rng.Intn(2)) * time.Minute
+	}
+	dc := "10.10.0.5"
+	l := scenarioLog(offset, "WARN", "Lateral Movement Behavior", host, dc, "Remote service login",
+		user+" logged in to "+dc+" over WinRM from "+host, 3)
+	l.User, l.SourcePort, l.DestinationPort = user, 49152+rng.Intn(16000), 5985
+	logs = append(logs, l)
+	l = scenarioLog(offset+time.Minute, "ERROR", "Credential Dumping Behavior", dc, "", "LSASS memory access",
+		"Process run by "+user+" read LSASS memory on "+dc, 4)
+	l.User = user
+	return append(logs, l)
+}
+
+func dataExfilScenario(rng *rand.Rand) []LogEntry {
+	host := fmt.Sprintf("10.0.4.%d", 10+rng.Intn(200))
+	user := []string{"rlopez", "kwong", "tbaker"}[rng.Intn(3)]
+	saas := []string{"52.96.0.10", "142.250.74.46", "13.107.42.14"}
+	exfil := fmt.Sprintf("198.51.100.%d", 2+rng.Intn(250))
+	var logs []LogEntry
+	// Seven minutes of routine traffic, one small transfer every 30 seconds
+	for i := 0; i < 14; i++ {
+		dst := saas[rng.Intn(len(saas))]
+		l := scenarioLog(time.Duration(i)*30*time.Second, "INFO", "Outbound Network Traffic", host, dst, "Outbound transfer",
+			fmt.Sprintf("Uploaded %d KB to %s", 20+rng.Intn(400), dst), 1)
+		l.User, l.SourcePort, l.DestinationPort = user, 49152+rng.Intn(16000), 443
+		logs = append(logs, l)
+	}
+	// Then forty large uploads to one address, three seconds apart
+	for i := 0; i < 40; i++ {
+		offset := 7*time.Minute + time.Duration(i)*3*time.Second
+		l := scenarioLog(offset, "ERROR", "Data Exfiltration Threat", host, exfil, "Large outbound transfer",
+			fmt.Sprintf("Uploaded %d MB to %s", 50+rng.Intn(200), exfil), 4)
+		l.User, l.SourcePort, l.DestinationPort = user, 49152+rng.Intn(16000), 443
+		logs = append(logs, l)
+	}
+	return logs
+}
+
+// StartScenario generates a scenario's logs and plays them as a replay
+func (d *Database) StartScenario(scenario Scenario, req ScenarioRequest) (ReplayJob, error) {
+	if req.Seed == 0 {
+		req.Seed = time.Now().UnixNano()
+	}
+	logs := scenario.generate(rand.New(rand.NewSource(req.Seed)))
+	sort.SliceStable(logs, func(i, j int) bool { return logs[i].Timestamp.Before(logs[j].Timestamp) })
+	query := url.Values{"scenario": {scenario.Name}, "seed": {strconv.FormatInt(req.Seed, 10)}}
+	return d.startReplayJob(ReplayJob{
+		Query:  query.Encode(),
+		Source: replayFromScenario,
+		Speed:  req.Speed,
+		Tag:    req.Tag,
+		Actor:  req.Actor,
+	}, logs)
+}
+
+// GET /api/scenarios - list attack scenarios (admin only)
+// POST /api/scenarios {"scenario", "speed", "tag", "seed"} - play one; follow
+// it at /api/replays/{id}
+func scenariosHandlerDB(w http.ResponseWriter, r *http.Request, db *Database) {
+	enableCORS(w)
+	w.Header().Set("Content-Type", "application/json")
+	if !db.requireAdmin(w, r) {
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		json.NewEncoder(w).Encode(scenarios)
+	case http.MethodPost:
+		var req ScenarioRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeJSONError(w, http.StatusBadRequest, "Invalid JSON")
+			return
+		}
+		scenario, ok := findScenario(req.Scenario)
+		if !ok {
+			writeJSONError(w, http.StatusBadRequest, "Unknown scenario "+strconv.Quote(req.Scenario))
+			return
+		}
+		var err error
+		if req.Speed, req.Tag, err = replayPace(req.Speed, req.Tag, defaultScenarioTag); err != nil {
+			writeJSONError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if req.Actor == "" {
+			req.Actor = r.Header.Get("X-Actor")
+		}
+		job, err := db.StartScenario(scenario, req)
+		if err != nil {
+			writeJSONError(w, http.StatusInternalServerError, "Failed to start scenario")
+			return
+		}
+		w.Header().Set("Location", "/api/replays/"+url.PathEscape(job.ID))
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(job)
+	default:
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+	}
+}
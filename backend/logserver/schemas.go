@@ -0,0 +1,381 @@
+package logserver
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// LogSchema is the shape a team expects its logs to have. Every ingested
+// log matching Filter is checked: a Required field it lacks is missing, and
+// a field in neither list is unexpected. Fields are JSON keys, so for JSON
+// logs custom keys the parser ignores count too; for other formats only
+// the fields the log ended up with are known.
+type LogSchema struct {
+	ID        int64     `json:"id"`
+	Name      string    `json:"name"`
+	Filter    LogFilter `json:"filter"`
+	Required  []string  `json:"required"`
+	Optional  []string  `json:"optional"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// SchemaReport is how a schema's logs have deviated since startup
+type SchemaReport struct {
+	Schema     LogSchema         `json:"schema"`
+	Checked    int64             `json:"checked"`
+	Deviating  int64             `json:"deviating"`
+	Missing    map[string]int64  `json:"missing"`
+	Unexpected map[string]int64  `json:"unexpected"`
+	Recent     []SchemaDeviation `json:"recent"`
+}
+
+// SchemaDeviation is one log that did not fit its schema
+type SchemaDeviation struct {
+	// LogID is 0 for logs a route kept out of the local store
+	LogID      int64     `json:"logId,omitempty"`
+	At         time.Time `json:"at"`
+	Missing    []string  `json:"missing,omitempty"`
+	Unexpected []string  `json:"unexpected,omitempty"`
+}
+
+const (
+	// schemaRecentDeviations is how many deviating logs a report lists
+	schemaRecentDeviations = 10
+	// maxUnexpectedFields bounds the distinct unexpected field names counted
+	// per schema, and so the metric labels; the rest count as "_other"
+	maxUnexpectedFields = 100
+)
+
+// schemaRegistry holds the schemas so ingest does not query them per log,
+// and their deviation counts, keyed by schema ID
+type schemaRegistry struct {
+	mu      sync.RWMutex
+	schemas []LogSchema
+	reports map[int64]*SchemaReport
+}
+
+func createSchemaTables(db *sql.DB) error {
+	_, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS log_schemas (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			name TEXT NOT NULL UNIQUE,
+			filter TEXT NOT NULL,
+			required TEXT NOT NULL,
+			optional TEXT NOT NULL,
+			created_at DATETIME NOT NULL
+		)
+	`)
+	return err
+}
+
+func (s *LogSchema) validate() error {
+	if !iacExternalID.MatchString(s.Name) {
+		return errors.New("name must be 1-128 letters, digits, '.', '_' or '-'")
+	}
+	if _, err := parseLogFilter(s.Filter.values(), time.Now()); err != nil {
+		return fmt.Errorf("filter: %v", err)
+	}
+	if len(s.Required) == 0 && len(s.Optional) == 0 {
+		return errors.New("required or optional must list at least one field")
+	}
+	for _, field := range append(append([]string{}, s.Required...), s.Optional...) {
+		if field == "" {
+			return errors.New("field names must not be empty")
+		}
+	}
+	if s.Required == nil {
+		s.Required = []string{}
+	}
+	if s.Optional == nil {
+		s.Optional = []string{}
+	}
+	return nil
+}
+
+func (d *Database) loadSchemas() error {
+	rows, err := d.db.Query(`SELECT id, name, filter, required, optional, created_at FROM log_schemas ORDER BY id`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	schemas := []LogSchema{}
+	for rows.Next() {
+		var s LogSchema
+		var filter, required, optional string
+		if err := rows.Scan(&s.ID, &s.Name, &filter, &required, &optional, &s.CreatedAt); err != nil {
+			return err
+		}
+		if err := json.Unmarshal([]byte(filter), &s.Filter); err != nil {
+			return err
+		}
+		if err := json.Unmarshal([]byte(required), &s.Required); err != nil {
+			return err
+		}
+		if err := json.Unmarshal([]byte(optional), &s.Optional); err != nil {
+			return err
+		}
+		schemas = append(schemas, s)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	r := &d.schemas
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	reports := make(map[int64]*SchemaReport, len(schemas))
+	for _, s := range schemas {
+		report := r.reports[s.ID]
+		if report == nil {
+			report = &SchemaReport{Missing: map[string]int64{}, Unexpected: map[string]int64{}, Recent: []SchemaDeviation{}}
+		}
+		report.Schema = s
+		reports[s.ID] = report
+	}
+	r.schemas, r.reports = schemas, reports
+	return nil
+}
+
+func (d *Database) GetSchemas() []LogSchema {
+	d.schemas.mu.RLock()
+	defer d.schemas.mu.RUnlock()
+	return append([]LogSchema{}, d.schemas.schemas...)
+}
+
+func (d *Database) CreateSchema(s LogSchema) (LogSchema, error) {
+	filter, _ := json.Marshal(s.Filter)
+	required, _ := json.Marshal(s.Required)
+	optional, _ := json.Marshal(s.Optional)
+	s.CreatedAt = d.now().UTC()
+	res, err := d.db.Exec(`INSERT INTO log_schemas (name, filter, required, optional, created_at) VALUES (?, ?, ?, ?, ?)`,
+		s.Name, string(filter), string(required), string(optional), s.CreatedAt)
+	if err != nil {
+		return s, err
+	}
+	if s.ID, err = res.LastInsertId(); err != nil {
+		return s, err
+	}
+	return s, d.loadSchemas()
+}
+
+// UpdateSchema replaces a schema's filter and fields; its counts carry on
+func (d *Database) UpdateSchema(s LogSchema) error {
+	filter, _ := json.Marshal(s.Filter)
+	required, _ := json.Marshal(s.Required)
+	optional, _ := json.Marshal(s.Optional)
+	res, err := d.db.Exec(`UPDATE log_schemas SET name = ?, filter = ?, required = ?, optional = ? WHERE id = ?`,
+		s.Name, string(filter), string(required), string(optional), s.ID)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return d.loadSchemas()
+}
+
+func (d *Database) DeleteSchema(id int64) error {
+	if _, err := d.db.Exec(`DELETE FROM log_schemas WHERE id = ?`, id); err != nil {
+		return err
+	}
+	return d.loadSchemas()
+}
+
+// SchemaReports returns a copy of every schema's report
+func (d *Database) SchemaReports() []SchemaReport {
+	r := &d.schemas
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	reports := make([]SchemaReport, 0, len(r.schemas))
+	for _, s := range r.schemas {
+		report := *r.reports[s.ID]
+		report.Missing = copyCounts(report.Missing)
+		report.Unexpected = copyCounts(report.Unexpected)
+		report.Recent = append([]SchemaDeviation{}, report.Recent...)
+		reports = append(reports, report)
+	}
+	return reports
+}
+
+func copyCounts(counts map[string]int64) map[string]int64 {
+	out := make(map[string]int64, len(counts))
+	for k, v := range counts {
+		out[k] = v
+	}
+	return out
+}
+
+// checkSchemas counts a log against every schema whose filter it matches
+func (d *Database) checkSchemas(l LogEntry) {
+	r := &d.schemas
+	r.mu.RLock()
+	matched := false
+	for _, s := range r.schemas {
+		matched = matched || s.Filter.matches(l)
+	}
+	r.mu.RUnlock()
+	if !matched {
+		return
+	}
+
+	present := logFieldNames(l)
+	now := d.now().UTC()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, s := range r.schemas {
+		if !s.Filter.matches(l) {
+			continue
+		}
+		report := r.reports[s.ID]
+		report.Checked++
+		var deviation SchemaDeviation
+		for _, field := range s.Required {
+			if !present[field] {
+				deviation.Missing = append(deviation.Missing, field)
+				report.Missing[field]++
+			}
+		}
+		for field := range present {
+			if slices.Contains(s.Required, field) || slices.Contains(s.Optional, field) {
+				continue
+			}
+			deviation.Unexpected = append(deviation.Unexpected, field)
+			if _, ok := report.Unexpected[field]; !ok && len(report.Unexpected) >= maxUnexpectedFields {
+				field = "_other"
+			}
+			report.Unexpected[field]++
+		}
+		if deviation.Missing == nil && deviation.Unexpected == nil {
+			continue
+		}
+		report.Deviating++
+		sort.Strings(deviation.Unexpected)
+		deviation.LogID, deviation.At = l.ID, now
+		report.Recent = append(report.Recent, deviation)
+		if len(report.Recent) > schemaRecentDeviations {
+			report.Recent = report.Recent[1:]
+		}
+	}
+}
+
+// logFieldNames returns the fields a log arrived with: the keys of its raw
+// JSON object if it has one, and otherwise the fields that are set
+func logFieldNames(l LogEntry) map[string]bool {
+	present := map[string]bool{}
+	if l.RawFormat == rawJSON {
+		var keys map[string]json.RawMessage
+		if json.Unmarshal(l.Raw, &keys) == nil {
+			for key := range keys {
+				present[key] = true
+			}
+			return present
+		}
+	}
+	set := map[string]bool{
+		"timestamp":       !l.Timestamp.IsZero(),
+		"level":           l.Level != "",
+		"rule":            l.Rule != "",
+		"sourceIP":        l.SourceIP != "",
+		"destinationIP":   l.DestinationIP != "",
+		"event":           l.Event != "",
+		"description":     l.Description != "",
+		"urgency":         l.Urgency != 0,
+		"user":            l.User != "",
+		"traceId":         l.TraceID != "",
+		"sourcePort":      l.SourcePort != 0,
+		"destinationPort": l.DestinationPort != 0,
+		"category":        l.Category != "",
+		"tags":            len(l.Tags) > 0,
+	}
+	for field, ok := range set {
+		if ok {
+			present[field] = true
+		}
+	}
+	return present
+}
+
+// GET/POST/PUT/DELETE /api/schemas - manage expected log schemas (admin only)
+func schemasHandlerDB(w http.ResponseWriter, r *http.Request, db *Database) {
+	enableCORS(w)
+	w.Header().Set("Content-Type", "application/json")
+	if !db.requireAdmin(w, r) {
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		json.NewEncoder(w).Encode(db.GetSchemas())
+	case http.MethodPost, http.MethodPut:
+		var s LogSchema
+		if err := json.NewDecoder(r.Body).Decode(&s); err != nil {
+			writeJSONError(w, http.StatusBadRequest, "Invalid JSON")
+			return
+		}
+		if err := s.validate(); err != nil {
+			writeJSONError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if r.Method == http.MethodPut {
+			err := db.UpdateSchema(s)
+			if errors.Is(err, sql.ErrNoRows) {
+				writeJSONError(w, http.StatusNotFound, "Schema not found")
+				return
+			}
+			if err != nil {
+				writeJSONError(w, http.StatusConflict, "A schema with that name already exists")
+				return
+			}
+			for _, stored := range db.GetSchemas() {
+				if stored.ID == s.ID {
+					s = stored
+				}
+			}
+			json.NewEncoder(w).Encode(s)
+			return
+		}
+		s, err := db.CreateSchema(s)
+		if err != nil {
+			writeJSONError(w, http.StatusConflict, "A schema with that name already exists")
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(s)
+	case http.MethodDelete:
+		id, err := strconv.ParseInt(r.URL.Query().Get("id"), 10, 64)
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, "Invalid id")
+			return
+		}
+		if err := db.DeleteSchema(id); err != nil {
+			writeJSONError(w, http.StatusInternalServerError, "Failed to delete schema")
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+	}
+}
+
+// GET /api/schemas/report - how each schema's logs deviated since startup (admin only)
+func schemaReportHandlerDB(w http.ResponseWriter, r *http.Request, db *Database) {
+	enableCORS(w)
+	w.Header().Set("Content-Type", "application/json")
+	if !db.requireAdmin(w, r) {
+		return
+	}
+	if r.Method != http.MethodGet {
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"since":   startTime.UTC(),
+		"schemas": db.SchemaReports(),
+	})
+}
@@ -0,0 +1,328 @@
+package logserver
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Data scopes. Admins attach mandatory filters to a role (viewer or
+// anonymous) or to one API key, and every search by a caller they apply to
+// is narrowed server-side: each scope's filter must match and its exclude
+// filter must not, and logs older than its maxAge are out of reach. Admin
+// callers are never scoped. Scoped callers, and those with field masks (see
+// masks.go), have a restricted view: they can only use the endpoints that
+// apply scopes and masks, and the dashboard aggregates, which read
+// unfiltered counters, answer 403.
+
+// DataScope is a mandatory filter for the callers with Role, or for the API
+// key APIKeyID. MaxAge, such as "7d", limits them to recent logs.
+type DataScope struct {
+	ID        int64     `json:"id"`
+	Role      string    `json:"role,omitempty"`
+	APIKeyID  int64     `json:"apiKeyId,omitempty"`
+	Filter    LogFilter `json:"filter"`
+	Exclude   LogFilter `json:"exclude"`
+	MaxAge    string    `json:"maxAge,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+
+	// maxAge is MaxAge parsed, zero for none
+	maxAge time.Duration
+}
+
+type dataScopeCache struct {
+	mu     sync.RWMutex
+	scopes []DataScope
+}
+
+// scopedPaths are the endpoints open to a restricted view besides
+// ingestion: log searches, which apply scopes and masks, and ones that
+// return no log data
+var scopedPaths = map[string]bool{
+	"/api/logs":            true,
+	"/api/logs/poll":       true,
+	"/api/logs/export":     true,
+	"/api/logs/raw":        true,
+	"/api/ask":             true,
+	"/api/chatops":         true,
+	"/api/chatops/slack":   true,
+	"/api/queries/recent":  true,
+	"/api/queries/suggest": true,
+	"/api/searches":        true,
+	"/api/messages":        true,
+	"/api/levels":          true,
+	"/api/urgencies":       true,
+}
+
+// callerView is what a restricted caller may see: its data scopes, as a
+// LogFilter of Require and Exclude conditions, and its masked fields
+type callerView struct {
+	scope LogFilter
+	masks fieldMasks
+}
+
+func (v callerView) scoped() bool {
+	return len(v.scope.Require)+len(v.scope.Exclude) > 0
+}
+
+func (v callerView) restricted() bool {
+	return v.scoped() || len(v.masks) > 0
+}
+
+// viewContextKey holds a restricted request's callerView
+type viewContextKey struct{}
+
+func createDataScopeTables(db *sql.DB) error {
+	_, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS data_scopes (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			role TEXT NOT NULL DEFAULT '',
+			api_key_id INTEGER NOT NULL DEFAULT 0,
+			filter TEXT NOT NULL,
+			exclude TEXT NOT NULL,
+			created_at DATETIME NOT NULL
+		)
+	`)
+	if err != nil {
+		return err
+	}
+	return addColumnIfMissing(db, "data_scopes", "max_age", `TEXT NOT NULL DEFAULT ''`)
+}
+
+// empty reports whether the filter has no conditions
+func (f LogFilter) empty() bool {
+	clause, _ := f.where()
+	return clause == ""
+}
+
+func (d *Database) validateDataScope(s DataScope) error {
+	switch {
+	case s.Role != "" && s.APIKeyID != 0:
+		return errors.New("A scope applies to a role or an API key, not both")
+	case s.Role == roleAdmin:
+		return errors.New("Admin callers cannot be scoped")
+	case s.Role != "" && s.Role != roleViewer && s.Role != roleAnonymous:
+		return errors.New("role must be viewer or anonymous")
+	case s.APIKeyID != 0:
+		if _, err := d.GetAPIKey(s.APIKeyID); errors.Is(err, sql.ErrNoRows) {
+			return errors.New("Unknown API key " + strconv.FormatInt(s.APIKeyID, 10))
+		} else if err != nil {
+			return err
+		}
+	case s.Role == "":
+		return errors.New("role or apiKeyId is required")
+	}
+	if s.MaxAge != "" {
+		if _, err := parseRelativeDuration(s.MaxAge); err != nil {
+			return errors.New("Invalid maxAge " + strconv.Quote(s.MaxAge))
+		}
+	}
+	if s.Filter.empty() && s.Exclude.empty() && s.MaxAge == "" {
+		return errors.New("A scope needs a filter, an exclude filter or a maxAge")
+	}
+	for _, f := range []LogFilter{s.Filter, s.Exclude} {
+		if _, err := parseLogFilter(f.values(), d.now()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (d *Database) loadDataScopes() error {
+	rows, err := d.db.Query(`SELECT id, role, api_key_id, filter, exclude, max_age, created_at FROM data_scopes ORDER BY id`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	scopes := []DataScope{}
+	for rows.Next() {
+		var s DataScope
+		var filter, exclude string
+		if err := rows.Scan(&s.ID, &s.Role, &s.APIKeyID, &filter, &exclude, &s.MaxAge, &s.CreatedAt); err != nil {
+			return err
+		}
+		if s.MaxAge != "" {
+			if s.maxAge, err = parseRelativeDuration(s.MaxAge); err != nil {
+				return err
+			}
+		}
+		if err := json.Unmarshal([]byte(filter), &s.Filter); err != nil {
+			return err
+		}
+		if err := json.Unmarshal([]byte(exclude), &s.Exclude); err != nil {
+			return err
+		}
+		scopes = append(scopes, s)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	d.dataScopes.mu.Lock()
+	d.dataScopes.scopes = scopes
+	d.dataScopes.mu.Unlock()
+	return nil
+}
+
+func (d *Database) GetDataScopes() []DataScope {
+	d.dataScopes.mu.RLock()
+	defer d.dataScopes.mu.RUnlock()
+	return append([]DataScope{}, d.dataScopes.scopes...)
+}
+
+func (d *Database) CreateDataScope(s DataScope) (DataScope, error) {
+	filter, _ := json.Marshal(s.Filter)
+	exclude, _ := json.Marshal(s.Exclude)
+	s.CreatedAt = d.now().UTC()
+	res, err := d.db.Exec(`INSERT INTO data_scopes (role, api_key_id, filter, exclude, max_age, created_at) VALUES (?, ?, ?, ?, ?, ?)`,
+		s.Role, s.APIKeyID, string(filter), string(exclude), s.MaxAge, s.CreatedAt)
+	if err != nil {
+		return s, err
+	}
+	if s.ID, err = res.LastInsertId(); err != nil {
+		return s, err
+	}
+	return s, d.loadDataScopes()
+}
+
+func (d *Database) DeleteDataScope(id int64) error {
+	res, err := d.db.Exec(`DELETE FROM data_scopes WHERE id = ?`, id)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return d.loadDataScopes()
+}
+
+// callerView returns the data scopes and field masks that apply to the
+// caller. A maxAge becomes a required time bound as of now.
+func (d *Database) callerView(r *http.Request) callerView {
+	role, keyID := d.requestRole(r)
+	var view callerView
+	if role == roleAdmin {
+		return view
+	}
+	now := d.now()
+	for _, s := range d.GetDataScopes() {
+		if s.Role != role && (keyID == 0 || s.APIKeyID != keyID) {
+			continue
+		}
+		if !s.Filter.empty() {
+			view.scope.Require = append(view.scope.Require, s.Filter)
+		}
+		if !s.Exclude.empty() {
+			view.scope.Exclude = append(view.scope.Exclude, s.Exclude)
+		}
+		if s.maxAge > 0 {
+			view.scope.Require = append(view.scope.Require, LogFilter{From: now.Add(-s.maxAge)})
+		}
+	}
+	view.masks = d.roleMasks(role)
+	return view
+}
+
+// scopeAllows reports whether a restricted caller may use the endpoint
+func scopeAllows(r *http.Request) bool {
+	switch {
+	case r.Method == http.MethodOptions:
+		return true
+	case strings.HasPrefix(r.URL.Path, "/api/ingest/"), isNDJSONIngest(r):
+		return true
+	}
+	return scopedPaths[r.URL.Path]
+}
+
+// enforceScopes refuses restricted callers the endpoints that cannot apply
+// their scopes and masks and hands the others their view; see scopedFilter
+// and requestMasks
+func (d *Database) enforceScopes(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		view := d.callerView(r)
+		if !view.restricted() {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if !scopeAllows(r) {
+			enableCORS(w)
+			writeJSONError(w, http.StatusForbidden, "Not available to a restricted view")
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), viewContextKey{}, view)))
+	})
+}
+
+// requestView returns the view of a restricted request
+func requestView(r *http.Request) (callerView, bool) {
+	view, ok := r.Context().Value(viewContextKey{}).(callerView)
+	return view, ok
+}
+
+// requestScope returns the data scope of a scoped request
+func requestScope(r *http.Request) (LogFilter, bool) {
+	view, _ := requestView(r)
+	return view.scope, view.scoped()
+}
+
+// scopedFilter narrows a search filter to the request's data scope
+func scopedFilter(r *http.Request, f LogFilter) LogFilter {
+	if scope, ok := requestScope(r); ok {
+		f.Require = append(f.Require, scope.Require...)
+		f.Exclude = append(f.Exclude, scope.Exclude...)
+	}
+	return f
+}
+
+// GET /api/scopes - list data scopes (admin only)
+// POST /api/scopes {"role"|"apiKeyId", "filter", "exclude", "maxAge"} - add one
+// DELETE /api/scopes?id= - remove one
+func dataScopesHandlerDB(w http.ResponseWriter, r *http.Request, db *Database) {
+	enableCORS(w)
+	w.Header().Set("Content-Type", "application/json")
+	if !db.requireAdmin(w, r) {
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		json.NewEncoder(w).Encode(db.GetDataScopes())
+	case http.MethodPost:
+		var s DataScope
+		if err := json.NewDecoder(r.Body).Decode(&s); err != nil {
+			writeJSONError(w, http.StatusBadRequest, "Invalid JSON")
+			return
+		}
+		if err := db.validateDataScope(s); err != nil {
+			writeJSONError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		s, err := db.CreateDataScope(s)
+		if err != nil {
+			writeJSONError(w, http.StatusInternalServerError, "Failed to create scope")
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(s)
+	case http.MethodDelete:
+		id, err := strconv.ParseInt(r.URL.Query().Get("id"), 10, 64)
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, "Invalid id")
+			return
+		}
+		if err := db.DeleteDataScope(id); errors.Is(err, sql.ErrNoRows) {
+			writeJSONError(w, http.StatusNotFound, "Scope not found")
+			return
+		} else if err != nil {
+			writeJSONError(w, http.StatusInternalServerError, "Failed to delete scope")
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+	}
+}
@@ -0,0 +1,155 @@
+package logserver
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// logSortColumns is the allowlist of row sort keys and the SQL they order
+// by; the level order is configured, so it is read per query
+var logSortColumns = map[string]func(*levelConfig) string{
+	"timestamp": func(*levelConfig) string { return "timestamp" },
+	"urgency":   func(*levelConfig) string { return "urgency" },
+	"level":     (*levelConfig).severityExpr,
+}
+
+// logGroupColumns is the allowlist of group_by dimensions for aggregated search
+var logGroupColumns = map[string]string{
+	"event":       "event",
+	"rule":        "rule",
+	"level":       "level",
+	"source":      "source_ip",
+	"destination": "destination_ip",
+	"user":        "user_name",
+	"src_port":    "source_port",
+	"dst_port":    "destination_port",
+}
+
+// logSort is a validated sort= parameter: field or field:asc|desc. The zero
+// value sorts by timestamp, newest first.
+type logSort struct {
+	Field string
+	Asc   bool
+	// levels ranks levels for sorting by level
+	levels *levelConfig
+}
+
+// parseSort validates param against the row or, when grouped, aggregate sort
+// keys. levels is the level order of a sort by level.
+func parseSort(param string, grouped bool, levels *levelConfig) (logSort, error) {
+	s := logSort{Field: "timestamp", levels: levels}
+	if grouped {
+		s.Field = "count"
+	}
+	if param == "" {
+		return s, nil
+	}
+	field, dir, _ := strings.Cut(param, ":")
+	switch dir {
+	case "", "desc":
+	case "asc":
+		s.Asc = true
+	default:
+		return s, fmt.Errorf("Invalid sort direction %q", dir)
+	}
+	if grouped {
+		if field != "count" && field != "value" {
+			return s, errors.New("Grouped results sort by count or value")
+		}
+	} else if _, ok := logSortColumns[field]; !ok {
+		if field == "count" {
+			return s, errors.New("sort=count requires group_by")
+		}
+		return s, fmt.Errorf("Unknown sort field %q", field)
+	}
+	s.Field = field
+	return s, nil
+}
+
+// orderBy returns the ORDER BY clause for row results; ties break newest first
+func (s logSort) orderBy() string {
+	field := s.Field
+	if field == "" {
+		field = "timestamp"
+	}
+	dir := " DESC"
+	if s.Asc {
+		dir = " ASC"
+	}
+	if field == "timestamp" {
+		return ` ORDER BY timestamp` + dir
+	}
+	return ` ORDER BY ` + logSortColumns[field](s.levels) + dir + `, timestamp DESC`
+}
+
+// less orders rows in Go the same way orderBy does in SQL, for merging
+// results that did not come from a single query
+func (s logSort) less(a, b LogEntry) bool {
+	var x, y int
+	switch s.Field {
+	case "urgency":
+		x, y = a.Urgency, b.Urgency
+	case "level":
+		x, y = s.levels.severity(a.Level), s.levels.severity(b.Level)
+	default:
+		if s.Asc {
+			return a.Timestamp.Before(b.Timestamp)
+		}
+		return a.Timestamp.After(b.Timestamp)
+	}
+	if x == y {
+		return a.Timestamp.After(b.Timestamp)
+	}
+	if s.Asc {
+		return x < y
+	}
+	return x > y
+}
+
+// GroupCount is one bucket of an aggregated search
+type GroupCount struct {
+	Value string `json:"value"`
+	Count int    `json:"count"`
+}
+
+// buildGroupQuery returns the SQL and arguments used by GroupLogs
+func buildGroupQuery(filter LogFilter, groupBy string, sort logSort, limit int) (string, []interface{}) {
+	column := logGroupColumns[groupBy]
+	clause, args := filter.where()
+	dir := " DESC"
+	if sort.Asc {
+		dir = " ASC"
+	}
+	order := `COUNT(*)` + dir + `, value`
+	if sort.Field == "value" {
+		order = `value` + dir
+	}
+	query := `
+		SELECT ` + column + ` AS value, COUNT(*)
+		FROM logs
+		WHERE 1=1` + clause + `
+		GROUP BY ` + column + `
+		ORDER BY ` + order + ` LIMIT ?`
+	return query, append(args, limit)
+}
+
+// GroupLogs counts matching logs per value of the group_by dimension
+func (d *Database) GroupLogs(filter LogFilter, groupBy string, sort logSort, limit int) ([]GroupCount, error) {
+	query, args := buildGroupQuery(filter, groupBy, sort, limit)
+	rows, err := d.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	groups := []GroupCount{}
+	for rows.Next() {
+		var g GroupCount
+		if err := rows.Scan(&g.Value, &g.Count); err != nil {
+			return nil, err
+		}
+		groups = append(groups, g)
+	}
+	return groups, rows.Err()
+}
@@ -0,0 +1,133 @@
+package logserver
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// SavedSearch is a named search. Query holds /api/logs parameters as a
+// query string, e.g. "level=ERROR&since=24h".
+type SavedSearch struct {
+	ID          int64     `json:"id"`
+	Name        string    `json:"name"`
+	Query       string    `json:"query"`
+	Description string    `json:"description,omitempty"`
+	CreatedAt   time.Time `json:"createdAt"`
+}
+
+func createSavedSearchTables(db *sql.DB) error {
+	_, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS saved_searches (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			name TEXT NOT NULL,
+			query TEXT NOT NULL,
+			description TEXT NOT NULL DEFAULT '',
+			created_at DATETIME NOT NULL
+		)
+	`)
+	return err
+}
+
+func (s *SavedSearch) validate() error {
+	s.Name = strings.TrimSpace(s.Name)
+	if s.Name == "" {
+		return errors.New("name is required")
+	}
+	q, err := url.ParseQuery(strings.TrimPrefix(s.Query, "?"))
+	if err != nil {
+		return errors.New("Invalid query: " + err.Error())
+	}
+	if _, err := parseLogFilter(q, time.Now()); err != nil {
+		return errors.New("Invalid query: " + err.Error())
+	}
+	s.Query = strings.TrimPrefix(s.Query, "?")
+	return nil
+}
+
+func scanSavedSearch(scan func(dest ...interface{}) error) (SavedSearch, error) {
+	var s SavedSearch
+	err := scan(&s.ID, &s.Name, &s.Query, &s.Description, &s.CreatedAt)
+	return s, err
+}
+
+func (d *Database) CreateSavedSearch(s SavedSearch) (SavedSearch, error) {
+	return d.createSavedSearch(d.db, s)
+}
+
+func (d *Database) createSavedSearch(ex execer, s SavedSearch) (SavedSearch, error) {
+	s.CreatedAt = d.now().UTC()
+	res, err := ex.Exec(`INSERT INTO saved_searches (name, query, description, created_at) VALUES (?, ?, ?, ?)`,
+		s.Name, s.Query, s.Description, s.CreatedAt)
+	if err != nil {
+		return s, err
+	}
+	s.ID, err = res.LastInsertId()
+	return s, err
+}
+
+func (d *Database) GetSavedSearch(id int64) (SavedSearch, error) {
+	return scanSavedSearch(d.db.QueryRow(`SELECT id, name, query, description, created_at FROM saved_searches WHERE id = ?`, id).Scan)
+}
+
+func (d *Database) GetSavedSearches() ([]SavedSearch, error) {
+	rows, err := d.db.Query(`SELECT id, name, query, description, created_at FROM saved_searches ORDER BY name, id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	searches := []SavedSearch{}
+	for rows.Next() {
+		s, err := scanSavedSearch(rows.Scan)
+		if err != nil {
+			return nil, err
+		}
+		searches = append(searches, s)
+	}
+	return searches, rows.Err()
+}
+
+func (d *Database) UpdateSavedSearch(s SavedSearch) error {
+	return updateSavedSearch(d.db, s)
+}
+
+func updateSavedSearch(ex execer, s SavedSearch) error {
+	res, err := ex.Exec(`UPDATE saved_searches SET name = ?, query = ?, description = ? WHERE id = ?`,
+		s.Name, s.Query, s.Description, s.ID)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+func (d *Database) DeleteSavedSearch(id int64) error {
+	return deleteSavedSearch(d.db, id)
+}
+
+func deleteSavedSearch(ex execer, id int64) error {
+	_, err := ex.Exec(`DELETE FROM saved_searches WHERE id = ?`, id)
+	return err
+}
+
+// GET /api/searches - list saved searches; they are managed through /api/iac/saved_searches
+func savedSearchesHandlerDB(w http.ResponseWriter, r *http.Request, db *Database) {
+	enableCORS(w)
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != http.MethodGet {
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	searches, err := db.GetSavedSearches()
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "Failed to fetch saved searches")
+		return
+	}
+	json.NewEncoder(w).Encode(searches)
+}
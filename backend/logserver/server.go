@@ -0,0 +1,275 @@
+// Package logserver is the log API server. The logger-backend command runs
+// it on its own; other Go programs can host it in their own binary:
+//
+//	db, err := logserver.OpenDatabase("/var/lib/app/logs.db")
+//	...
+//	err = logserver.New(logserver.Config{Store: db, Addr: ":9090"}).Start(ctx)
+//
+// or mount NewRouter(db) on their own mux to serve the API alongside their
+// own routes.
+package logserver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"time"
+)
+
+// shutdownTimeout is how long Start waits for requests in flight once its
+// context is done
+const shutdownTimeout = 10 * time.Second
+
+// Config configures a Server
+type Config struct {
+	// Store is the database to serve. When nil, Start opens logs.db in the
+	// working directory and closes it on return; a Store passed in is left
+	// open for its owner to close.
+	Store *Database
+	// Addr is the address to listen on, ":8080" by default
+	Addr string
+	// UDPAddr, when set, also accepts logs as JSON datagrams there; it
+	// defaults to UDP_INGEST_ADDR. See udp_ingest.go.
+	UDPAddr string
+	// SyslogAddr, when set, also accepts syslog there over UDP and TCP; it
+	// defaults to SYSLOG_ADDR. See syslog_ingest.go.
+	SyslogAddr string
+	// GELFAddr, when set, also accepts GELF datagrams there; it defaults
+	// to GELF_UDP_ADDR. See gelf_ingest.go.
+	GELFAddr string
+	// SocketPath, when set, also serves the ingest endpoints on a Unix
+	// socket there; it defaults to INGEST_SOCKET. See ingest_socket.go.
+	SocketPath string
+	// UI, when set, serves every path outside /api/ and /metrics, such as
+	// the dashboard's built files
+	UI http.Handler
+}
+
+// Server serves the log API on one database
+type Server struct {
+	config Config
+}
+
+// New returns a Server for config; it does nothing until Start
+func New(config Config) *Server {
+	if config.Addr == "" {
+		config.Addr = ":8080"
+	}
+	if config.UDPAddr == "" {
+		config.UDPAddr = udpIngestAddr
+	}
+	if config.SyslogAddr == "" {
+		config.SyslogAddr = syslogAddr
+	}
+	if config.GELFAddr == "" {
+		config.GELFAddr = gelfUDPAddr
+	}
+	if config.SocketPath == "" {
+		config.SocketPath = ingestSocketPath
+	}
+	return &Server{config: config}
+}
+
+// Handler returns the server's routes: the API and, if configured, the UI
+func (s *Server) Handler(db *Database) http.Handler {
+	api := NewRouter(db)
+	if s.config.UI == nil {
+		return api
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/api/", api)
+	mux.Handle("/metrics", api)
+	mux.Handle("/", s.config.UI)
+	return mux
+}
+
+// Start serves on config.Addr, and on config.UDPAddr, config.SyslogAddr,
+// config.GELFAddr and config.SocketPath if set, with the alert evaluator and ticket sync running, until ctx is
+// done or a listener fails. Once ctx is done it lets requests in flight
+// finish and returns nil.
+func (s *Server) Start(ctx context.Context) error {
+	if err := loadMessageCatalogs(); err != nil {
+		return err
+	}
+	if err := loadPlugins(pluginDir); err != nil {
+		return err
+	}
+	db := s.config.Store
+	if db == nil {
+		var err error
+		if db, err = NewDatabase(); err != nil {
+			return err
+		}
+		defer db.Close()
+	}
+	if err := enforceAirGap(db); err != nil {
+		return fmt.Errorf("air-gapped mode: %w", err)
+	}
+	if s.config.UDPAddr != "" {
+		udp, err := listenUDP(s.config.UDPAddr, db)
+		if err != nil {
+			return fmt.Errorf("udp ingest: %w", err)
+		}
+		defer udp.Close()
+		log.Printf("Accepting UDP logs on %s", s.config.UDPAddr)
+	}
+	if s.config.SyslogAddr != "" {
+		syslog, err := listenSyslog(s.config.SyslogAddr, db)
+		if err != nil {
+			return fmt.Errorf("syslog ingest: %w", err)
+		}
+		defer syslog.Close()
+		log.Printf("Accepting syslog on %s (UDP and TCP)", s.config.SyslogAddr)
+	}
+	if s.config.GELFAddr != "" {
+		gelf, err := listenGELF(s.config.GELFAddr, db)
+		if err != nil {
+			return fmt.Errorf("gelf ingest: %w", err)
+		}
+		defer gelf.Close()
+		log.Printf("Accepting GELF datagrams on %s", s.config.GELFAddr)
+	}
+	var socket net.Listener
+	if s.config.SocketPath != "" {
+		var err error
+		if socket, err = listenIngestSocket(s.config.SocketPath); err != nil {
+			return fmt.Errorf("ingest socket: %w", err)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go startAlertEvaluator(ctx, db)
+	go startTicketSync(ctx, db)
+
+	server := &http.Server{Addr: s.config.Addr, Handler: s.Handler(db)}
+	failed := make(chan error, 2)
+	go func() {
+		failed <- server.ListenAndServe()
+	}()
+	servers := []*http.Server{server}
+	if socket != nil {
+		socketServer := newIngestSocketServer(db)
+		go func() {
+			failed <- socketServer.Serve(socket)
+		}()
+		servers = append(servers, socketServer)
+		log.Printf("Accepting logs on Unix socket %s", s.config.SocketPath)
+	}
+	log.Printf("Server started on %s", s.config.Addr)
+	var err error
+	select {
+	case err = <-failed:
+	case <-ctx.Done():
+	}
+	shutdownCtx, done := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer done()
+	for _, server := range servers {
+		if serr := server.Shutdown(shutdownCtx); serr != nil && !errors.Is(serr, http.ErrServerClosed) && err == nil {
+			err = serr
+		}
+	}
+	return err
+}
+
+// NewRouter routes every API endpoint to db, counting usage and applying
+// data scopes
+func NewRouter(db *Database) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/summary", func(w http.ResponseWriter, r *http.Request) { summaryStatsHandlerDB(w, r, db) })
+	mux.HandleFunc("/api/urgency", func(w http.ResponseWriter, r *http.Request) { urgencyDataHandlerDB(w, r, db) })
+	mux.HandleFunc("/api/timeline", func(w http.ResponseWriter, r *http.Request) { timelineDataHandlerDB(w, r, db) })
+	mux.HandleFunc("/api/timeline/annotations", func(w http.ResponseWriter, r *http.Request) { timelineAnnotationsHandlerDB(w, r, db) })
+	mux.HandleFunc("/api/timeline/deploys", db.withQueryLimit(func(w http.ResponseWriter, r *http.Request) { deployReportHandlerDB(w, r, db) }))
+	mux.HandleFunc("/api/top-events", func(w http.ResponseWriter, r *http.Request) { topEventsHandlerDB(w, r, db) })
+	mux.HandleFunc("/api/top-sources", func(w http.ResponseWriter, r *http.Request) { topSourcesHandlerDB(w, r, db) })
+	mux.HandleFunc("/api/top-destinations", func(w http.ResponseWriter, r *http.Request) { topDestinationsHandlerDB(w, r, db) })
+	mux.HandleFunc("/api/overview", func(w http.ResponseWriter, r *http.Request) { overviewHandlerDB(w, r, db) })
+	mux.HandleFunc("/api/queries/recent", func(w http.ResponseWriter, r *http.Request) { recentQueriesHandlerDB(w, r, db) })
+	mux.HandleFunc("/api/queries/suggest", func(w http.ResponseWriter, r *http.Request) { querySuggestionsHandlerDB(w, r, db) })
+	mux.HandleFunc("/api/ask", db.withQueryLimit(func(w http.ResponseWriter, r *http.Request) { askHandlerDB(w, r, db) }))
+	mux.HandleFunc("/api/chatops", db.withQueryLimit(func(w http.ResponseWriter, r *http.Request) { chatOpsHandlerDB(w, r, db) }))
+	mux.HandleFunc("/api/chatops/slack", db.withQueryLimit(func(w http.ResponseWriter, r *http.Request) { chatOpsSlackHandlerDB(w, r, db) }))
+	mux.HandleFunc("/api/unique", func(w http.ResponseWriter, r *http.Request) { uniqueCountsHandlerDB(w, r, db) })
+	mux.HandleFunc("/api/histogram", db.withQueryLimit(func(w http.ResponseWriter, r *http.Request) { histogramHandlerDB(w, r, db) }))
+	mux.HandleFunc("/api/heatmap", db.withQueryLimit(func(w http.ResponseWriter, r *http.Request) { heatmapHandlerDB(w, r, db) }))
+	mux.HandleFunc("/api/graph", db.withQueryLimit(func(w http.ResponseWriter, r *http.Request) { graphHandlerDB(w, r, db) }))
+	mux.HandleFunc("/api/alerts/rules", func(w http.ResponseWriter, r *http.Request) { alertRulesHandlerDB(w, r, db) })
+	mux.HandleFunc("/api/alerts/expression", func(w http.ResponseWriter, r *http.Request) { alertExpressionHandlerDB(w, r, db) })
+	mux.HandleFunc("/api/alerts/mutes", func(w http.ResponseWriter, r *http.Request) { muteWindowsHandlerDB(w, r, db) })
+	mux.HandleFunc("/api/alerts/history", func(w http.ResponseWriter, r *http.Request) { alertHistoryHandlerDB(w, r, db) })
+	mux.HandleFunc("/api/alerts/history/", func(w http.ResponseWriter, r *http.Request) { alertActionHandlerDB(w, r, db) })
+	mux.HandleFunc("/api/storage", func(w http.ResponseWriter, r *http.Request) { storageStatsHandlerDB(w, r, db) })
+	mux.HandleFunc("/api/notables", func(w http.ResponseWriter, r *http.Request) { notablesHandlerDB(w, r, db) })
+	mux.HandleFunc("/api/changes", func(w http.ResponseWriter, r *http.Request) { changesHandlerDB(w, r, db) })
+	mux.HandleFunc("/api/notables/", func(w http.ResponseWriter, r *http.Request) { notableHandlerDB(w, r, db) })
+	mux.HandleFunc("/api/notables/promotion", func(w http.ResponseWriter, r *http.Request) { notablePromotionHandlerDB(w, r, db) })
+	mux.HandleFunc("/api/sql", db.withQueryLimit(func(w http.ResponseWriter, r *http.Request) { sqlHandlerDB(w, r, db) }))
+	mux.HandleFunc("/api/webhooks", func(w http.ResponseWriter, r *http.Request) { webhooksHandlerDB(w, r, db) })
+	mux.HandleFunc("/api/webhooks/inbound", func(w http.ResponseWriter, r *http.Request) { inboundWebhooksHandlerDB(w, r, db) })
+	mux.HandleFunc("/api/scopes", func(w http.ResponseWriter, r *http.Request) { dataScopesHandlerDB(w, r, db) })
+	mux.HandleFunc("/api/masks", func(w http.ResponseWriter, r *http.Request) { fieldMasksHandlerDB(w, r, db) })
+	mux.HandleFunc("/api/credentials", func(w http.ResponseWriter, r *http.Request) { credentialsHandlerDB(w, r, db) })
+	mux.HandleFunc("/api/credentials/", func(w http.ResponseWriter, r *http.Request) { credentialsHandlerDB(w, r, db) })
+	mux.HandleFunc("/api/ingest/tokens", func(w http.ResponseWriter, r *http.Request) { ingestTokensHandlerDB(w, r, db) })
+	mux.HandleFunc("/api/ingest/summaries", func(w http.ResponseWriter, r *http.Request) { summariesHandlerDB(w, r, db) })
+	mux.HandleFunc("/api/summaries", func(w http.ResponseWriter, r *http.Request) { summariesHandlerDB(w, r, db) })
+	mux.HandleFunc("/api/ingest/batches/", func(w http.ResponseWriter, r *http.Request) { ingestBatchHandlerDB(w, r, db) })
+	mux.HandleFunc("/api/ingest/gelf", func(w http.ResponseWriter, r *http.Request) { gelfIngestHandlerDB(w, r, db) })
+	mux.HandleFunc("/api/ingest/webhooks/", func(w http.ResponseWriter, r *http.Request) { inboundWebhookIngestHandlerDB(w, r, db) })
+	mux.HandleFunc("/api/admin/export", func(w http.ResponseWriter, r *http.Request) { configExportHandlerDB(w, r, db) })
+	mux.HandleFunc("/api/admin/import", func(w http.ResponseWriter, r *http.Request) { configImportHandlerDB(w, r, db) })
+	mux.HandleFunc("/api/outputs", func(w http.ResponseWriter, r *http.Request) { outputsHandlerDB(w, r, db) })
+	mux.HandleFunc("/api/routes", func(w http.ResponseWriter, r *http.Request) { routesHandlerDB(w, r, db) })
+	mux.HandleFunc("/api/routes/", func(w http.ResponseWriter, r *http.Request) { routesHandlerDB(w, r, db) })
+	mux.HandleFunc("/api/processors", func(w http.ResponseWriter, r *http.Request) { wasmProcessorsHandlerDB(w, r, db) })
+	mux.HandleFunc("/api/processors/", func(w http.ResponseWriter, r *http.Request) { wasmProcessorsHandlerDB(w, r, db) })
+	mux.HandleFunc("/api/admin/usage", func(w http.ResponseWriter, r *http.Request) { usageHandlerDB(w, r, db) })
+	mux.HandleFunc("/api/admin/airgap", func(w http.ResponseWriter, r *http.Request) { airGapHandlerDB(w, r, db) })
+	mux.HandleFunc("/api/admin/audit", func(w http.ResponseWriter, r *http.Request) { auditHandlerDB(w, r, db) })
+	mux.HandleFunc("/api/admin/extensions", func(w http.ResponseWriter, r *http.Request) { extensionsHandlerDB(w, r, db) })
+	mux.HandleFunc("/api/iac/", func(w http.ResponseWriter, r *http.Request) { iacHandlerDB(w, r, db) })
+	mux.HandleFunc("/api/searches", func(w http.ResponseWriter, r *http.Request) { savedSearchesHandlerDB(w, r, db) })
+	mux.HandleFunc("/api/tags", func(w http.ResponseWriter, r *http.Request) { tagsHandlerDB(w, r, db) })
+	mux.HandleFunc("/api/tags/rules", func(w http.ResponseWriter, r *http.Request) { tagRulesHandlerDB(w, r, db) })
+	mux.HandleFunc("/api/levels", func(w http.ResponseWriter, r *http.Request) { levelsHandlerDB(w, r, db) })
+	mux.HandleFunc("/api/urgencies", func(w http.ResponseWriter, r *http.Request) { urgenciesHandlerDB(w, r, db) })
+	mux.HandleFunc("/api/messages", messagesHandler)
+	mux.HandleFunc("/api/schemas", func(w http.ResponseWriter, r *http.Request) { schemasHandlerDB(w, r, db) })
+	mux.HandleFunc("/api/schemas/report", func(w http.ResponseWriter, r *http.Request) { schemaReportHandlerDB(w, r, db) })
+	mux.HandleFunc("/api/logs/bulk", func(w http.ResponseWriter, r *http.Request) { bulkHandlerDB(w, r, db) })
+	mux.HandleFunc("/api/logs/bulk/", func(w http.ResponseWriter, r *http.Request) { bulkHandlerDB(w, r, db) })
+	mux.HandleFunc("/api/replays", func(w http.ResponseWriter, r *http.Request) { replaysHandlerDB(w, r, db) })
+	mux.HandleFunc("/api/replays/", func(w http.ResponseWriter, r *http.Request) { replaysHandlerDB(w, r, db) })
+	mux.HandleFunc("/api/scenarios", func(w http.ResponseWriter, r *http.Request) { scenariosHandlerDB(w, r, db) })
+	mux.HandleFunc("/api/logs/export", db.withQueryLimit(func(w http.ResponseWriter, r *http.Request) { exportHandlerDB(w, r, db) }))
+	mux.HandleFunc("/api/consumers", func(w http.ResponseWriter, r *http.Request) { consumersHandlerDB(w, r, db) })
+	mux.HandleFunc("/api/consumers/", func(w http.ResponseWriter, r *http.Request) { consumersHandlerDB(w, r, db) })
+	mux.HandleFunc("/api/logs/poll", func(w http.ResponseWriter, r *http.Request) { logPollHandlerDB(w, r, db) })
+	mux.HandleFunc("/api/logs/tags", func(w http.ResponseWriter, r *http.Request) { logTagsHandlerDB(w, r, db) })
+	mux.HandleFunc("/api/logs/raw", func(w http.ResponseWriter, r *http.Request) { rawPayloadHandlerDB(w, r, db) })
+	mux.HandleFunc("/api/share", func(w http.ResponseWriter, r *http.Request) { shareHandlerDB(w, r, db) })
+	mux.HandleFunc("/api/share/", func(w http.ResponseWriter, r *http.Request) { shareHandlerDB(w, r, db) })
+	mux.HandleFunc("/api/annotations", func(w http.ResponseWriter, r *http.Request) { annotationsHandlerDB(w, r, db) })
+	mux.HandleFunc("/api/pivot", db.withQueryLimit(func(w http.ResponseWriter, r *http.Request) { pivotHandlerDB(w, r, db) }))
+	mux.HandleFunc("/api/geoip", func(w http.ResponseWriter, r *http.Request) { geoIPHandlerDB(w, r, db) })
+	mux.HandleFunc("/api/entities/", db.withQueryLimit(func(w http.ResponseWriter, r *http.Request) { entityTimelineHandlerDB(w, r, db) }))
+	mux.HandleFunc("/api/risk/weights", func(w http.ResponseWriter, r *http.Request) { riskWeightsHandlerDB(w, r, db) })
+	mux.HandleFunc("/api/risk/assets", func(w http.ResponseWriter, r *http.Request) { assetsHandlerDB(w, r, db) })
+	mux.HandleFunc("/api/risk/intel", func(w http.ResponseWriter, r *http.Request) { threatIntelHandlerDB(w, r, db) })
+	mux.HandleFunc("/api/responses/actions", func(w http.ResponseWriter, r *http.Request) { responseActionsHandlerDB(w, r, db) })
+	mux.HandleFunc("/api/responses/executions", func(w http.ResponseWriter, r *http.Request) { responseExecutionsHandlerDB(w, r, db) })
+	mux.HandleFunc("/api/logs", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			logIngestHandlerDB(w, r, db)
+		} else {
+			db.withQueryLimit(func(w http.ResponseWriter, r *http.Request) { logSearchHandlerDB(w, r, db) })(w, r)
+		}
+	})
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) { metricsHandlerDB(w, r, db) })
+	mux.HandleFunc("/", handleOptions)
+	return db.trackUsage(mux, db.auditRequests(mux, db.enforceScopes(mux)))
+}
@@ -0,0 +1,164 @@
+package logserver
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// SharedView is a stored search snapshot addressed by a short token. Frozen
+// views keep the results from when they were shared; others re-run the search.
+type SharedView struct {
+	Token     string     `json:"token"`
+	URL       string     `json:"url"`
+	Filter    LogFilter  `json:"filter"`
+	From      *time.Time `json:"from,omitempty"`
+	To        time.Time  `json:"to"`
+	Limit     int        `json:"limit"`
+	Frozen    bool       `json:"frozen"`
+	CreatedBy string     `json:"createdBy,omitempty"`
+	CreatedAt time.Time  `json:"createdAt"`
+	Results   []LogEntry `json:"results,omitempty"`
+}
+
+const shareTokenBytes = 6
+
+func createShareTables(db *sql.DB) error {
+	_, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS shared_views (
+			token TEXT PRIMARY KEY,
+			query TEXT NOT NULL,
+			row_limit INTEGER NOT NULL,
+			frozen BOOLEAN NOT NULL,
+			results TEXT,
+			created_by TEXT NOT NULL DEFAULT '',
+			created_at DATETIME NOT NULL
+		)
+	`)
+	return err
+}
+
+func (v *SharedView) filter() LogFilter {
+	f := v.Filter
+	if v.From != nil {
+		f.From = *v.From
+	}
+	f.To = v.To
+	return f
+}
+
+// CreateSharedView stores the view, pinning an open-ended range to now and
+// capturing results when the view is frozen
+func (d *Database) CreateSharedView(v SharedView) (SharedView, error) {
+	v.CreatedAt = d.now().UTC()
+	if v.To.IsZero() {
+		// Ranges are stored with second precision and to is exclusive
+		v.To = v.CreatedAt.Truncate(time.Second).Add(time.Second)
+	}
+	var results sql.NullString
+	if v.Frozen {
+		logs, err := d.SearchLogs(v.filter(), logSort{}, v.Limit)
+		if err != nil {
+			return v, err
+		}
+		if logs == nil {
+			logs = []LogEntry{}
+		}
+		raw, _ := json.Marshal(logs)
+		results = sql.NullString{String: string(raw), Valid: true}
+		v.Results = logs
+	}
+	v.Token = randomHex(shareTokenBytes)
+	v.URL = dashboardURL + "/?share=" + v.Token
+	_, err := d.db.Exec(`
+		INSERT INTO shared_views (token, query, row_limit, frozen, results, created_by, created_at) VALUES (?, ?, ?, ?, ?, ?, ?)
+	`, v.Token, v.filter().values().Encode(), v.Limit, v.Frozen, results, v.CreatedBy, v.CreatedAt)
+	return v, err
+}
+
+// GetSharedView loads a view and its results, re-running the search unless frozen
+func (d *Database) GetSharedView(token string) (SharedView, error) {
+	v := SharedView{Token: token, URL: dashboardURL + "/?share=" + token}
+	var query string
+	var results sql.NullString
+	err := d.db.QueryRow(`
+		SELECT query, row_limit, frozen, results, created_by, created_at FROM shared_views WHERE token = ?
+	`, token).Scan(&query, &v.Limit, &v.Frozen, &results, &v.CreatedBy, &v.CreatedAt)
+	if err != nil {
+		return v, err
+	}
+	values, err := url.ParseQuery(query)
+	if err != nil {
+		return v, err
+	}
+	f, err := parseLogFilter(values, d.now())
+	if err != nil {
+		return v, err
+	}
+	if !f.From.IsZero() {
+		from := f.From
+		v.From = &from
+	}
+	v.To = f.To
+	f.From, f.To = time.Time{}, time.Time{}
+	v.Filter = f
+
+	if v.Frozen {
+		err = json.Unmarshal([]byte(results.String), &v.Results)
+	} else {
+		v.Results, err = d.SearchLogs(v.filter(), logSort{}, v.Limit)
+	}
+	if err != nil {
+		return v, err
+	}
+	return v, d.attachAnnotations(v.Results)
+}
+
+// POST /api/share creates a shared view; GET /api/share/{token} opens one
+func shareHandlerDB(w http.ResponseWriter, r *http.Request, db *Database) {
+	enableCORS(w)
+	w.Header().Set("Content-Type", "application/json")
+	token := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/share"), "/")
+	switch {
+	case token == "" && r.Method == http.MethodPost:
+		var v SharedView
+		if err := json.NewDecoder(r.Body).Decode(&v); err != nil {
+			writeJSONError(w, http.StatusBadRequest, "Invalid JSON")
+			return
+		}
+		if v.Limit <= 0 || v.Limit > 1000 {
+			v.Limit = 100
+		}
+		if v.From != nil && !v.To.IsZero() && !v.From.Before(v.To) {
+			writeJSONError(w, http.StatusBadRequest, "'from' must be before 'to'")
+			return
+		}
+		if v.CreatedBy == "" {
+			v.CreatedBy = r.Header.Get("X-Actor")
+		}
+		v, err := db.CreateSharedView(v)
+		if err != nil {
+			writeJSONError(w, http.StatusInternalServerError, "Failed to share view")
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(v)
+	case token != "" && r.Method == http.MethodGet:
+		v, err := db.GetSharedView(token)
+		if errors.Is(err, sql.ErrNoRows) {
+			writeJSONError(w, http.StatusNotFound, "Shared view not found")
+			return
+		}
+		if err != nil {
+			writeJSONError(w, http.StatusInternalServerError, "Failed to open shared view")
+			return
+		}
+		json.NewEncoder(w).Encode(v)
+	default:
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+	}
+}
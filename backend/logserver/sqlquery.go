@@ -0,0 +1,221 @@
+package logserver
+
+import (
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Ad-hoc SQL limits; SQL_TIMEOUT overrides the default time limit
+const (
+	sqlDefaultRows = 1000
+	sqlMaxRows     = 10000
+)
+
+var sqlTimeout = envDuration("SQL_TIMEOUT", 10*time.Second)
+
+// SQLRequest is the body of POST /api/sql
+type SQLRequest struct {
+	Query  string `json:"query"`
+	Limit  int    `json:"limit"`
+	Format string `json:"format"` // json (default) or csv
+}
+
+// SQLResult is the JSON response of an ad-hoc query
+type SQLResult struct {
+	Columns   []string        `json:"columns"`
+	Rows      [][]interface{} `json:"rows"`
+	Truncated bool            `json:"truncated"`
+	ElapsedMs int64           `json:"elapsedMs"`
+}
+
+// openReadOnly opens a second connection pool that SQLite itself refuses
+// to write through, used for ad-hoc queries
+func openReadOnly(path string) (*sql.DB, error) {
+	return sql.Open("sqlite3", "file:"+path+"?mode=ro&_query_only=true")
+}
+
+// checkReadOnlyQuery accepts a single SELECT, WITH or EXPLAIN statement
+func checkReadOnlyQuery(query string) (string, error) {
+	query = strings.TrimRight(strings.TrimSpace(query), "; \t\n")
+	if query == "" {
+		return "", errors.New("query is required")
+	}
+	if statementSeparator(query) {
+		return "", errors.New("only a single statement is allowed")
+	}
+	keyword := strings.ToUpper(strings.Fields(query)[0])
+	switch keyword {
+	case "SELECT", "WITH", "EXPLAIN":
+		return query, nil
+	default:
+		return "", fmt.Errorf("only SELECT, WITH and EXPLAIN statements are allowed, not %s", keyword)
+	}
+}
+
+// statementSeparator reports whether query has a semicolon outside its
+// string literals, quoted identifiers and comments
+func statementSeparator(query string) bool {
+	for i := 0; i < len(query); i++ {
+		switch c := query[i]; c {
+		case ';':
+			return true
+		case '\'', '"', '`':
+			// A doubled quote escapes itself, and reads as a closing quote
+			// followed by an opening one
+			if end := strings.IndexByte(query[i+1:], c); end >= 0 {
+				i += end + 1
+			} else {
+				return false
+			}
+		case '[':
+			if end := strings.IndexByte(query[i+1:], ']'); end >= 0 {
+				i += end + 1
+			} else {
+				return false
+			}
+		case '-':
+			if strings.HasPrefix(query[i:], "--") {
+				end := strings.IndexByte(query[i:], '\n')
+				if end < 0 {
+					return false
+				}
+				i += end
+			}
+		case '/':
+			if strings.HasPrefix(query[i:], "/*") {
+				end := strings.Index(query[i+2:], "*/")
+				if end < 0 {
+					return false
+				}
+				i += end + 3
+			}
+		}
+	}
+	return false
+}
+
+// RunSQL runs a read-only query, stopping after limit rows or sqlTimeout
+func (d *Database) RunSQL(query string, limit int) (SQLResult, error) {
+	result := SQLResult{Rows: [][]interface{}{}}
+	ctx, cancel := context.WithTimeout(context.Background(), sqlTimeout)
+	defer cancel()
+	start := time.Now()
+
+	rows, err := d.readOnly.QueryContext(ctx, query)
+	if err != nil {
+		return result, sqlTimeoutError(ctx, err)
+	}
+	defer rows.Close()
+	if result.Columns, err = rows.Columns(); err != nil {
+		return result, err
+	}
+	for rows.Next() {
+		if len(result.Rows) == limit {
+			result.Truncated = true
+			break
+		}
+		values := make([]interface{}, len(result.Columns))
+		ptrs := make([]interface{}, len(values))
+		for i := range values {
+			ptrs[i] = &values[i]
+		}
+		if err := rows.Scan(ptrs...); err != nil {
+			return result, err
+		}
+		for i, v := range values {
+			if b, ok := v.([]byte); ok {
+				values[i] = string(b)
+			}
+		}
+		result.Rows = append(result.Rows, values)
+	}
+	if err := rows.Err(); err != nil {
+		return result, sqlTimeoutError(ctx, err)
+	}
+	result.ElapsedMs = time.Since(start).Milliseconds()
+	return result, nil
+}
+
+// sqlTimeoutError replaces SQLite's interrupt error when the time limit was hit
+func sqlTimeoutError(ctx context.Context, err error) error {
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return fmt.Errorf("query exceeded the %s time limit", sqlTimeout)
+	}
+	return err
+}
+
+func writeSQLCSV(w http.ResponseWriter, result SQLResult) {
+	w.Header().Set("Content-Type", "text/csv")
+	if result.Truncated {
+		w.Header().Set("X-Truncated", "true")
+	}
+	out := csv.NewWriter(w)
+	out.Write(result.Columns)
+	record := make([]string, len(result.Columns))
+	for _, row := range result.Rows {
+		for i, v := range row {
+			switch v := v.(type) {
+			case nil:
+				record[i] = ""
+			case time.Time:
+				record[i] = v.UTC().Format(time.RFC3339Nano)
+			default:
+				record[i] = fmt.Sprint(v)
+			}
+		}
+		out.Write(record)
+	}
+	out.Flush()
+}
+
+// POST /api/sql - run a read-only SQL query against the log store (admin
+// only). Queries run on SQLite. DuckDB and querying exported Parquet
+// segments are not supported; see the README.
+func sqlHandlerDB(w http.ResponseWriter, r *http.Request, db *Database) {
+	enableCORS(w)
+	w.Header().Set("Content-Type", "application/json")
+	if !db.requireAdmin(w, r) {
+		return
+	}
+	if r.Method != http.MethodPost {
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	var req SQLRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "Invalid JSON")
+		return
+	}
+	if req.Format != "" && req.Format != "json" && req.Format != "csv" {
+		writeJSONError(w, http.StatusBadRequest, "format must be json or csv")
+		return
+	}
+	if req.Limit <= 0 {
+		req.Limit = sqlDefaultRows
+	}
+	if req.Limit > sqlMaxRows {
+		req.Limit = sqlMaxRows
+	}
+	query, err := checkReadOnlyQuery(req.Query)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	result, err := db.RunSQL(query, req.Limit)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if req.Format == "csv" {
+		writeSQLCSV(w, result)
+		return
+	}
+	json.NewEncoder(w).Encode(result)
+}
@@ -0,0 +1,40 @@
+package logserver
+
+import "testing"
+
+func TestCheckReadOnlyQuery(t *testing.T) {
+	for _, c := range []struct {
+		query, want string
+		ok          bool
+	}{
+		{"SELECT * FROM logs", "SELECT * FROM logs", true},
+		{"  select 1;  ", "select 1", true},
+		{"SELECT 1;;\n", "SELECT 1", true},
+		{"WITH t AS (SELECT 1) SELECT * FROM t", "WITH t AS (SELECT 1) SELECT * FROM t", true},
+		{"EXPLAIN QUERY PLAN SELECT * FROM logs", "EXPLAIN QUERY PLAN SELECT * FROM logs", true},
+		// Semicolons inside literals, identifiers and comments are not separators
+		{"SELECT * FROM logs WHERE description LIKE '%;%'", "SELECT * FROM logs WHERE description LIKE '%;%'", true},
+		{"SELECT 'it''s; fine'", "SELECT 'it''s; fine'", true},
+		{`SELECT "a;b" FROM logs`, `SELECT "a;b" FROM logs`, true},
+		{"SELECT [a;b], `c;d` FROM logs", "SELECT [a;b], `c;d` FROM logs", true},
+		{"SELECT 1 -- one; two\n", "SELECT 1 -- one; two", true},
+		{"SELECT /* ; */ 1", "SELECT /* ; */ 1", true},
+		{"SELECT 1 - 2", "SELECT 1 - 2", true},
+		{"SELECT 4 / 2", "SELECT 4 / 2", true},
+		// Everything else is refused
+		{"", "", false},
+		{" ; ", "", false},
+		{"SELECT 1; DELETE FROM logs", "", false},
+		{"SELECT ';'; DROP TABLE logs", "", false},
+		{"SELECT /* ; */ 1; SELECT 2", "", false},
+		{"SELECT 1 -- x\n; SELECT 2", "", false},
+		{"DELETE FROM logs", "", false},
+		{"PRAGMA writable_schema = 1", "", false},
+		{"ATTACH DATABASE 'x.db' AS x", "", false},
+	} {
+		got, err := checkReadOnlyQuery(c.query)
+		if (err == nil) != c.ok || got != c.want {
+			t.Errorf("checkReadOnlyQuery(%q) = %q, %v; want %q, ok %v", c.query, got, err, c.want, c.ok)
+		}
+	}
+}
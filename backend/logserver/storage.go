@@ -0,0 +1,242 @@
+package logserver
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+// storageSampleRetention is how long storage samples are kept for forecasting
+const storageSampleRetention = 7 * 24 * time.Hour
+
+// Built-in forecast alert thresholds, overridable through the environment
+var (
+	diskFullAlertHours       = envFloat("DISK_FULL_ALERT_HOURS", 72)
+	volumeGrowthAlertPercent = envFloat("VOLUME_GROWTH_ALERT_PERCENT", 50)
+	builtinAlertWebhookURL   = os.Getenv("BUILTIN_ALERT_WEBHOOK_URL")
+)
+
+// Built-in rules use negative IDs so they never collide with user rules
+var (
+	diskFullRule = AlertRule{
+		ID:              -1,
+		Name:            "Disk full forecast",
+		WebhookURL:      builtinAlertWebhookURL,
+		RenotifySeconds: 6 * 3600,
+		Enabled:         true,
+	}
+	volumeGrowthRule = AlertRule{
+		ID:              -2,
+		Name:            "Daily volume growth forecast",
+		WebhookURL:      builtinAlertWebhookURL,
+		RenotifySeconds: 6 * 3600,
+		Enabled:         true,
+	}
+)
+
+// StorageStats describes database size, disk headroom and ingest volume trends
+type StorageStats struct {
+	DatabaseBytes       int64    `json:"databaseBytes"`
+	DiskFreeBytes       uint64   `json:"diskFreeBytes"`
+	DiskTotalBytes      uint64   `json:"diskTotalBytes"`
+	Rows                int64    `json:"rows"`
+	GrowthBytesPerHour  *float64 `json:"growthBytesPerHour,omitempty"`
+	HoursUntilFull      *float64 `json:"hoursUntilFull,omitempty"`
+	LogsToday           int      `json:"logsToday"`
+	LogsYesterday       int      `json:"logsYesterday"`
+	ProjectedLogsToday  *int     `json:"projectedLogsToday,omitempty"`
+	VolumeGrowthPercent *float64 `json:"volumeGrowthPercent,omitempty"`
+}
+
+func envFloat(key string, fallback float64) float64 {
+	if v, err := strconv.ParseFloat(os.Getenv(key), 64); err == nil {
+		return v
+	}
+	return fallback
+}
+
+func createStorageTables(db *sql.DB) error {
+	_, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS storage_samples (
+			sampled_at DATETIME PRIMARY KEY,
+			database_bytes INTEGER NOT NULL,
+			disk_free_bytes INTEGER NOT NULL,
+			row_count INTEGER NOT NULL
+		)
+	`)
+	return err
+}
+
+// databaseBytes is the on-disk size of the database including WAL and shared memory files
+func (d *Database) databaseBytes() int64 {
+	var total int64
+	for _, suffix := range []string{"", "-wal", "-shm"} {
+		if info, err := os.Stat(d.path + suffix); err == nil {
+			total += info.Size()
+		}
+	}
+	return total
+}
+
+// recordStorageSample stores the current size and free space, pruning old samples
+func (d *Database) recordStorageSample(now time.Time) error {
+	// Unsupported platforms still get size samples, just without free space
+	free, _, _ := diskUsage(filepath.Dir(d.path))
+	_, err := d.db.Exec(`
+		INSERT OR REPLACE INTO storage_samples (sampled_at, database_bytes, disk_free_bytes, row_count)
+		VALUES (?, ?, ?, ?)
+	`, now.UTC(), d.databaseBytes(), free, d.rowCount.Load())
+	if err != nil {
+		return err
+	}
+	_, err = d.db.Exec(`DELETE FROM storage_samples WHERE sampled_at < ?`, now.Add(-storageSampleRetention).UTC())
+	return err
+}
+
+// growthBytesPerHour fits a least-squares line through the last day of size samples
+func (d *Database) growthBytesPerHour(now time.Time) (*float64, error) {
+	rows, err := d.db.Query(`
+		SELECT sampled_at, database_bytes FROM storage_samples
+		WHERE sampled_at >= ? ORDER BY sampled_at
+	`, now.Add(-24*time.Hour).UTC())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var xs, ys []float64
+	for rows.Next() {
+		var at time.Time
+		var size int64
+		if err := rows.Scan(&at, &size); err != nil {
+			return nil, err
+		}
+		xs = append(xs, at.Sub(now).Hours())
+		ys = append(ys, float64(size))
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	// Need at least ten minutes of history for a meaningful slope
+	if len(xs) < 2 || xs[len(xs)-1]-xs[0] < 1.0/6 {
+		return nil, nil
+	}
+	var meanX, meanY float64
+	for i := range xs {
+		meanX += xs[i]
+		meanY += ys[i]
+	}
+	meanX /= float64(len(xs))
+	meanY /= float64(len(ys))
+	var num, den float64
+	for i := range xs {
+		num += (xs[i] - meanX) * (ys[i] - meanY)
+		den += (xs[i] - meanX) * (xs[i] - meanX)
+	}
+	if den == 0 {
+		return nil, nil
+	}
+	slope := num / den
+	return &slope, nil
+}
+
+func (d *Database) GetStorageStats(now time.Time) (StorageStats, error) {
+	stats := StorageStats{DatabaseBytes: d.databaseBytes(), Rows: d.rowCount.Load()}
+	free, total, err := diskUsage(filepath.Dir(d.path))
+	if err == nil {
+		stats.DiskFreeBytes, stats.DiskTotalBytes = free, total
+	}
+	growth, err := d.growthBytesPerHour(now)
+	if err != nil {
+		return stats, err
+	}
+	stats.GrowthBytesPerHour = growth
+	if growth != nil && *growth > 0 && stats.DiskTotalBytes > 0 {
+		hours := float64(stats.DiskFreeBytes) / *growth
+		stats.HoursUntilFull = &hours
+	}
+
+	today := now.UTC().Truncate(24 * time.Hour)
+	yesterday := today.Add(-24 * time.Hour)
+	if stats.LogsToday, err = d.CountLogs(LogFilter{From: today, To: now}); err != nil {
+		return stats, err
+	}
+	if stats.LogsYesterday, err = d.CountLogs(LogFilter{From: yesterday, To: today}); err != nil {
+		return stats, err
+	}
+	// Extrapolating from less than an hour of data is too noisy
+	if elapsed := now.Sub(today); elapsed >= time.Hour {
+		projected := int(float64(stats.LogsToday) * float64(24*time.Hour) / float64(elapsed))
+		stats.ProjectedLogsToday = &projected
+		if stats.LogsYesterday > 0 {
+			growth := (float64(projected) - float64(stats.LogsYesterday)) / float64(stats.LogsYesterday) * 100
+			stats.VolumeGrowthPercent = &growth
+		}
+	}
+	return stats, nil
+}
+
+// evaluateBuiltinAlerts samples storage and runs the disk-full and volume-growth forecasts
+func (d *Database) evaluateBuiltinAlerts(now time.Time) error {
+	if err := d.recordStorageSample(now); err != nil {
+		return err
+	}
+	stats, err := d.GetStorageStats(now)
+	if err != nil {
+		return err
+	}
+
+	diskRule := diskFullRule
+	diskRule.Threshold = int(diskFullAlertHours)
+	diskFires := stats.HoursUntilFull != nil && *stats.HoursUntilFull < diskFullAlertHours
+	diskResult := ConditionResult{Matched: diskFires}
+	details := ""
+	if diskFires {
+		diskResult.Count = int(*stats.HoursUntilFull)
+		details = fmt.Sprintf("disk projected full in %.1fh (%.0f bytes/h growth, %d bytes free)",
+			*stats.HoursUntilFull, *stats.GrowthBytesPerHour, stats.DiskFreeBytes)
+	}
+	if err := d.stepBuiltinAlert(diskRule, diskFires, diskResult, details, now); err != nil {
+		return err
+	}
+
+	volumeRule := volumeGrowthRule
+	volumeRule.Threshold = int(volumeGrowthAlertPercent)
+	volumeFires := stats.VolumeGrowthPercent != nil && *stats.VolumeGrowthPercent > volumeGrowthAlertPercent
+	volumeResult := ConditionResult{Matched: volumeFires}
+	details = ""
+	if volumeFires {
+		volumeResult.Count = *stats.ProjectedLogsToday
+		details = fmt.Sprintf("projected %d logs today vs %d yesterday (+%.0f%%)",
+			*stats.ProjectedLogsToday, stats.LogsYesterday, *stats.VolumeGrowthPercent)
+	}
+	return d.stepBuiltinAlert(volumeRule, volumeFires, volumeResult, details, now)
+}
+
+func (d *Database) stepBuiltinAlert(rule AlertRule, fired bool, result ConditionResult, details string, now time.Time) error {
+	state, err := d.getAlertRuleState(rule.ID)
+	if err != nil {
+		return err
+	}
+	if err := d.stepAlertRule(rule, &state, fired, []ConditionResult{result}, nil, nil, details, now); err != nil {
+		return err
+	}
+	return d.saveAlertRuleState(state)
+}
+
+// GET /api/storage - database size, disk headroom and volume forecasts
+func storageStatsHandlerDB(w http.ResponseWriter, r *http.Request, db *Database) {
+	enableCORS(w)
+	w.Header().Set("Content-Type", "application/json")
+	stats, err := db.GetStorageStats(db.now())
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "Failed to fetch storage stats")
+		return
+	}
+	json.NewEncoder(w).Encode(stats)
+}
@@ -0,0 +1,14 @@
+//go:build unix
+
+package logserver
+
+import "syscall"
+
+// diskUsage returns the free (available to us) and total bytes of the filesystem holding path
+func diskUsage(path string) (free, total uint64, err error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(path, &st); err != nil {
+		return 0, 0, err
+	}
+	return uint64(st.Bavail) * uint64(st.Bsize), uint64(st.Blocks) * uint64(st.Bsize), nil
+}
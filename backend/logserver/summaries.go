@@ -0,0 +1,237 @@
+package logserver
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// LogSummary counts the logs of one level and rule that an edge agent saw
+// in one minute, shipped instead of the logs themselves
+type LogSummary struct {
+	Minute time.Time `json:"minute"`
+	Host   string    `json:"host"`
+	Level  string    `json:"level"`
+	Rule   string    `json:"rule"`
+	Count  int64     `json:"count"`
+}
+
+// summaryMaxBytes caps a summary upload; maxSummaryRows caps a listing
+const (
+	summaryMaxBytes = 4 << 20
+	maxSummaryRows  = 10000
+)
+
+func createSummaryTables(db *sql.DB) error {
+	for _, stmt := range []string{
+		`CREATE TABLE IF NOT EXISTS log_summaries (
+			minute DATETIME NOT NULL,
+			host TEXT NOT NULL,
+			level TEXT NOT NULL,
+			rule TEXT NOT NULL,
+			count INTEGER NOT NULL,
+			PRIMARY KEY (minute, host, level, rule)
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_log_summaries_minute ON log_summaries(minute)`,
+	} {
+		if _, err := db.Exec(stmt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// AddSummaries adds the counts to the stored ones, so an agent may ship a
+// minute in several parts
+func (d *Database) AddSummaries(summaries []LogSummary) error {
+	tx, err := d.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	stmt, err := tx.Prepare(`
+		INSERT INTO log_summaries (minute, host, level, rule, count) VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT (minute, host, level, rule) DO UPDATE SET count = count + excluded.count
+	`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	for _, s := range summaries {
+		if _, err := stmt.Exec(s.Minute.UTC().Truncate(time.Minute), s.Host, s.Level, s.Rule, s.Count); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// GetSummaries returns the stored counts in [from, to), oldest first;
+// zero bounds and empty host, level and rule are open
+func (d *Database) GetSummaries(from, to time.Time, host, level, rule string) ([]LogSummary, error) {
+	query := `SELECT minute, host, level, rule, count FROM log_summaries WHERE 1=1`
+	args := []interface{}{}
+	if !from.IsZero() {
+		query += ` AND minute >= ?`
+		args = append(args, from.UTC())
+	}
+	if !to.IsZero() {
+		query += ` AND minute < ?`
+		args = append(args, to.UTC())
+	}
+	for column, value := range map[string]string{"host": host, "level": level, "rule": rule} {
+		if value != "" {
+			query += ` AND ` + column + ` = ?`
+			args = append(args, value)
+		}
+	}
+	query += ` ORDER BY minute, host, level, rule LIMIT ?`
+	rows, err := d.db.Query(query, append(args, maxSummaryRows)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	summaries := []LogSummary{}
+	for rows.Next() {
+		var s LogSummary
+		if err := rows.Scan(&s.Minute, &s.Host, &s.Level, &s.Rule, &s.Count); err != nil {
+			return nil, err
+		}
+		summaries = append(summaries, s)
+	}
+	return summaries, rows.Err()
+}
+
+// POST /api/ingest/summaries - store per-minute counts from an edge agent
+// GET /api/summaries?from=&to=&host=&level=&rule= - list stored counts
+func summariesHandlerDB(w http.ResponseWriter, r *http.Request, db *Database) {
+	enableCORS(w)
+	w.Header().Set("Content-Type", "application/json")
+	switch r.Method {
+	case http.MethodGet:
+		q := r.URL.Query()
+		var from, to time.Time
+		var err error
+		if v := q.Get("from"); v != "" {
+			if from, err = time.Parse(time.RFC3339, v); err != nil {
+				writeJSONError(w, http.StatusBadRequest, "Invalid from")
+				return
+			}
+		}
+		if v := q.Get("to"); v != "" {
+			if to, err = time.Parse(time.RFC3339, v); err != nil {
+				writeJSONError(w, http.StatusBadRequest, "Invalid to")
+				return
+			}
+		}
+		summaries, err := db.GetSummaries(from, to, q.Get("host"), q.Get("level"), q.Get("rule"))
+		if err != nil {
+			writeJSONError(w, http.StatusInternalServerError, "Failed to fetch summaries")
+			return
+		}
+		var total int64
+		for _, s := range summaries {
+			total += s.Count
+		}
+		writeEncoded(w, r, map[string]interface{}{"total": total, "summaries": summaries})
+	case http.MethodPost:
+		body, err := io.ReadAll(io.LimitReader(r.Body, summaryMaxBytes+1))
+		if err != nil || len(body) > summaryMaxBytes {
+			writeJSONError(w, http.StatusRequestEntityTooLarge, "Payload is too large")
+			return
+		}
+		if _, err := db.authenticateIngest(r, body, db.now()); err != nil {
+			status := http.StatusUnauthorized
+			if errors.Is(err, errReplay) {
+				status = http.StatusConflict
+			}
+			writeJSONError(w, status, err.Error())
+			return
+		}
+		var summaries []LogSummary
+		if err := json.Unmarshal(body, &summaries); err != nil {
+			writeJSONError(w, http.StatusBadRequest, "Invalid JSON")
+			return
+		}
+		remote, _, _ := net.SplitHostPort(r.RemoteAddr)
+		for i := range summaries {
+			s := &summaries[i]
+			if s.Minute.IsZero() || s.Count < 1 {
+				writeJSONError(w, http.StatusBadRequest, "Each summary needs a minute and a positive count")
+				return
+			}
+			if s.Host == "" {
+				s.Host = remote
+			}
+			s.Level = strings.ToUpper(s.Level)
+			if s.Level == "" {
+				s.Level = "INFO"
+			}
+		}
+		if err := db.AddSummaries(summaries); err != nil {
+			writeJSONError(w, http.StatusInternalServerError, "Failed to store summaries")
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(map[string]int{"accepted": len(summaries)})
+	default:
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+	}
+}
+
+// summaryCounter aggregates logs into per-minute summaries on an edge
+type summaryCounter struct {
+	mu     sync.Mutex
+	counts map[LogSummary]int64
+}
+
+func (c *summaryCounter) add(entry LogEntry) {
+	key := LogSummary{Minute: entry.Timestamp.UTC().Truncate(time.Minute), Level: strings.ToUpper(entry.Level), Rule: entry.Rule}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.counts == nil {
+		c.counts = make(map[LogSummary]int64)
+	}
+	c.counts[key]++
+}
+
+// take returns the counted summaries, labelled with host, and resets the counts
+func (c *summaryCounter) take(host string) []LogSummary {
+	c.mu.Lock()
+	counts := c.counts
+	c.counts = nil
+	c.mu.Unlock()
+	summaries := make([]LogSummary, 0, len(counts))
+	for key, n := range counts {
+		key.Host, key.Count = host, n
+		summaries = append(summaries, key)
+	}
+	return summaries
+}
+
+// edgeHost names this instance in the summaries it ships
+var edgeHost = func() string {
+	if host := os.Getenv("EDGE_HOST"); host != "" {
+		return host
+	}
+	host, _ := os.Hostname()
+	return host
+}()
+
+func (c *levelConfig) parseSummaryLevel(level string) (string, error) {
+	level = strings.ToUpper(level)
+	if level == "" || c.knownLevel(level) {
+		return level, nil
+	}
+	var names []string
+	for _, l := range c.levelList() {
+		names = append(names, l.Name)
+	}
+	return "", errors.New("aggregateBelow must be one of " + strings.Join(names, ", "))
+}
@@ -0,0 +1,363 @@
+package logserver
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Syslog parsing, for RFC 5424 messages and the BSD format RFC 3164
+// describes. RFC 3164 was written after the fact and devices vary, so the
+// BSD parser is lenient: a message whose header does not parse is kept
+// whole as the message.
+
+// rawSyslog is the raw payload format of logs received as syslog
+const rawSyslog = "syslog"
+
+// syslogMessage is a parsed syslog message; empty fields were absent
+type syslogMessage struct {
+	Facility  int
+	Severity  int
+	Timestamp time.Time
+	Hostname  string
+	AppName   string
+	ProcID    string
+	MsgID     string
+	// StructuredData maps each SD-ID to its parameters (RFC 5424 only)
+	StructuredData map[string]map[string]string
+	Message        string
+}
+
+var errSyslogPriority = errors.New("syslog message must start with a <priority>")
+
+// syslogFacilities names the facility codes
+var syslogFacilities = []string{
+	"kern", "user", "mail", "daemon", "auth", "syslog", "lpr", "news",
+	"uucp", "cron", "authpriv", "ftp", "ntp", "security", "console", "solaris-cron",
+	"local0", "local1", "local2", "local3", "local4", "local5", "local6", "local7",
+}
+
+// syslogLevels maps severities to levels: emergency, alert and critical
+// are CRITICAL, notice and informational are INFO
+var syslogLevels = []string{"CRITICAL", "CRITICAL", "CRITICAL", "ERROR", "WARN", "INFO", "INFO", "DEBUG"}
+
+// parseSyslog parses one message; now dates BSD timestamps, which carry no
+// year or zone and are taken as local time
+func parseSyslog(data []byte, now time.Time) (syslogMessage, error) {
+	var m syslogMessage
+	s := strings.TrimRight(string(data), "\r\n\x00")
+	if !strings.HasPrefix(s, "<") {
+		return m, errSyslogPriority
+	}
+	end := strings.IndexByte(s, '>')
+	if end < 2 || end > 4 {
+		return m, errSyslogPriority
+	}
+	pri, err := strconv.Atoi(s[1:end])
+	if err != nil || pri < 0 || pri > 191 {
+		return m, errSyslogPriority
+	}
+	m.Facility, m.Severity = pri/8, pri%8
+	s = s[end+1:]
+	if rest, ok := strings.CutPrefix(s, "1 "); ok {
+		err = m.parse5424(rest)
+	} else {
+		m.parse3164(s, now)
+	}
+	if !utf8.ValidString(m.Message) {
+		m.Message = strings.ToValidUTF8(m.Message, "\ufffd")
+	}
+	return m, err
+}
+
+// parse5424 parses what follows "<pri>1 "
+func (m *syslogMessage) parse5424(s string) error {
+	fields := make([]string, 5)
+	for i := range fields {
+		field, rest, ok := strings.Cut(s, " ")
+		if !ok {
+			return errors.New("syslog message header is incomplete")
+		}
+		if field != "-" {
+			fields[i] = field
+		}
+		s = rest
+	}
+	if fields[0] != "" {
+		ts, err := time.Parse(time.RFC3339Nano, fields[0])
+		if err != nil {
+			return errors.New("syslog timestamp is not RFC 3339")
+		}
+		m.Timestamp = ts
+	}
+	m.Hostname, m.AppName, m.ProcID, m.MsgID = fields[1], fields[2], fields[3], fields[4]
+	if rest, ok := strings.CutPrefix(s, "-"); ok {
+		s = rest
+	} else {
+		var err error
+		if s, err = m.parseStructuredData(s); err != nil {
+			return err
+		}
+	}
+	s = strings.TrimPrefix(s, " ")
+	m.Message = strings.TrimPrefix(s, "\ufeff")
+	return nil
+}
+
+// parseStructuredData parses the SD-ELEMENTs at the start of s and returns
+// the rest
+func (m *syslogMessage) parseStructuredData(s string) (string, error) {
+	errSD := errors.New("syslog structured data is malformed")
+	m.StructuredData = make(map[string]map[string]string)
+	for strings.HasPrefix(s, "[") {
+		s = s[1:]
+		i := strings.IndexAny(s, " ]")
+		if i < 1 {
+			return "", errSD
+		}
+		params := make(map[string]string)
+		m.StructuredData[s[:i]] = params
+		s = s[i:]
+		for strings.HasPrefix(s, " ") {
+			name, rest, ok := strings.Cut(s[1:], `="`)
+			if !ok || name == "" {
+				return "", errSD
+			}
+			var value strings.Builder
+			closed := false
+			for i := 0; i < len(rest); i++ {
+				c := rest[i]
+				if c == '\\' && i+1 < len(rest) && strings.IndexByte(`"\]`, rest[i+1]) >= 0 {
+					value.WriteByte(rest[i+1])
+					i++
+					continue
+				}
+				if c == '"' {
+					rest, closed = rest[i+1:], true
+					break
+				}
+				value.WriteByte(c)
+			}
+			if !closed {
+				return "", errSD
+			}
+			params[name] = value.String()
+			s = rest
+		}
+		if !strings.HasPrefix(s, "]") {
+			return "", errSD
+		}
+		s = s[1:]
+	}
+	if len(m.StructuredData) == 0 {
+		return "", errSD
+	}
+	return s, nil
+}
+
+// parse3164 parses what follows "<pri>" in a BSD message:
+// "Mmm dd hh:mm:ss hostname tag[pid]: message"
+func (m *syslogMessage) parse3164(s string, now time.Time) {
+	m.Message = s
+	if len(s) < 16 || s[15] != ' ' {
+		return
+	}
+	ts, err := time.ParseInLocation(time.Stamp, s[:15], time.Local)
+	if err != nil {
+		return
+	}
+	ts = ts.AddDate(now.Year(), 0, 0)
+	// Allowing a day of clock skew, December's messages read in January
+	// belong to last year, and January's read in December to next year
+	switch {
+	case ts.After(now.Add(24 * time.Hour)):
+		ts = ts.AddDate(-1, 0, 0)
+	case ts.AddDate(1, 0, 0).Before(now.Add(24 * time.Hour)):
+		ts = ts.AddDate(1, 0, 0)
+	}
+	host, rest, ok := strings.Cut(s[16:], " ")
+	if !ok || host == "" {
+		return
+	}
+	m.Timestamp, m.Hostname, m.Message = ts, host, rest
+	// The tag is up to 32 alphanumerics, '-', '_', '.' or '/', optionally
+	// followed by [pid], then a colon
+	tag := strings.IndexFunc(rest, func(r rune) bool {
+		return !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || strings.ContainsRune("-_./", r))
+	})
+	if tag < 1 || tag > 32 {
+		return
+	}
+	app, rest := rest[:tag], rest[tag:]
+	var pid string
+	if strings.HasPrefix(rest, "[") {
+		end := strings.IndexByte(rest, ']')
+		if end < 0 {
+			return
+		}
+		pid, rest = rest[1:end], rest[end+1:]
+	}
+	rest, ok = strings.CutPrefix(rest, ":")
+	if !ok {
+		return
+	}
+	m.AppName, m.ProcID, m.Message = app, pid, strings.TrimPrefix(rest, " ")
+}
+
+// facilityName names m's facility
+func (m *syslogMessage) facilityName() string {
+	return syslogFacilities[m.Facility]
+}
+
+// entry turns m into a log: the app name becomes the rule, the message the
+// event, and the severity the level. The source is the hostname when it is
+// an address, and otherwise remote, the address the message came from. The
+// structured data becomes the description, so it is kept without the raw
+// payload.
+func (m *syslogMessage) entry(remote string) LogEntry {
+	entry := LogEntry{
+		Timestamp: m.Timestamp,
+		Level:     syslogLevels[m.Severity],
+		Rule:      "syslog",
+		Event:     m.Message,
+		SourceIP:  remote,
+		Tags:      []string{"syslog", "facility:" + m.facilityName()},
+	}
+	if m.AppName != "" {
+		entry.Rule = "syslog:" + m.AppName
+	}
+	if entry.Event == "" {
+		entry.Event = m.MsgID
+	}
+	if net.ParseIP(m.Hostname) != nil {
+		entry.SourceIP = m.Hostname
+	}
+	entry.Description = m.structuredDataText()
+	return entry
+}
+
+// sdEscaper escapes the characters RFC 5424 escapes in PARAM-VALUEs
+var sdEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, `]`, `\]`)
+
+// structuredDataText writes m's structured data back out as SD-ELEMENTs,
+// sorted by SD-ID and parameter name, or "" when there is none
+func (m *syslogMessage) structuredDataText() string {
+	var b strings.Builder
+	for _, id := range sortedKeys(m.StructuredData) {
+		params := m.StructuredData[id]
+		b.WriteString("[" + id)
+		for _, name := range sortedKeys(params) {
+			b.WriteString(" " + name + `="` + sdEscaper.Replace(params[name]) + `"`)
+		}
+		b.WriteString("]")
+	}
+	return b.String()
+}
+
+// sortedKeys returns the keys of m in order
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// isSyslogRule reports whether rule is one syslog logs are given
+func isSyslogRule(rule string) bool {
+	return rule == "syslog" || strings.HasPrefix(rule, "syslog:")
+}
+
+// meta is what expressions see as meta for a syslog log
+func (m *syslogMessage) meta() map[string]interface{} {
+	meta := map[string]interface{}{
+		"facility": m.facilityName(),
+		"severity": float64(m.Severity),
+		"message":  m.Message,
+	}
+	for key, value := range map[string]string{"hostname": m.Hostname, "appName": m.AppName, "procId": m.ProcID, "msgId": m.MsgID} {
+		if value != "" {
+			meta[key] = value
+		}
+	}
+	if sd := m.structuredDataMeta(); sd != nil {
+		meta["structuredData"] = sd
+	}
+	return meta
+}
+
+// syslogEntryMeta is what expressions see as meta for a syslog log stored
+// without its raw payload: the app name, the event as the message, and the
+// structured data read back from the description
+func syslogEntryMeta(entry LogEntry) map[string]interface{} {
+	meta := map[string]interface{}{"message": entry.Event}
+	if app, ok := strings.CutPrefix(entry.Rule, "syslog:"); ok {
+		meta["appName"] = app
+	}
+	var m syslogMessage
+	if strings.HasPrefix(entry.Description, "[") {
+		if rest, err := m.parseStructuredData(entry.Description); err == nil && rest == "" {
+			meta["structuredData"] = m.structuredDataMeta()
+		}
+	}
+	return meta
+}
+
+// structuredDataMeta is m's structured data as expressions see it, or nil
+func (m *syslogMessage) structuredDataMeta() map[string]interface{} {
+	if len(m.StructuredData) == 0 {
+		return nil
+	}
+	sd := make(map[string]interface{}, len(m.StructuredData))
+	for id, params := range m.StructuredData {
+		values := make(map[string]interface{}, len(params))
+		for name, value := range params {
+			values[name] = value
+		}
+		sd[id] = values
+	}
+	return sd
+}
+
+// splitSyslogFrame returns the first message framed in data, for
+// bufio.Scanner on a TCP stream. RFC 6587 allows octet counting ("<len>
+// <message>") and newline-terminated messages; the first byte tells them
+// apart, since a message itself starts with '<'.
+func splitSyslogFrame(data []byte, atEOF bool) (int, []byte, error) {
+	if len(data) == 0 {
+		return 0, nil, nil
+	}
+	if data[0] >= '1' && data[0] <= '9' {
+		space := bytes.IndexByte(data, ' ')
+		if space < 0 {
+			if atEOF || len(data) > 10 {
+				return 0, nil, errors.New("syslog frame length is malformed")
+			}
+			return 0, nil, nil
+		}
+		n, err := strconv.Atoi(string(data[:space]))
+		if err != nil {
+			return 0, nil, errors.New("syslog frame length is malformed")
+		}
+		if len(data) < space+1+n {
+			if atEOF {
+				return 0, nil, errors.New("syslog frame is truncated")
+			}
+			return 0, nil, nil
+		}
+		return space + 1 + n, data[space+1 : space+1+n], nil
+	}
+	if i := bytes.IndexAny(data, "\n\x00"); i >= 0 {
+		return i + 1, bytes.TrimRight(data[:i], "\r"), nil
+	}
+	if atEOF {
+		return len(data), data, nil
+	}
+	return 0, nil, nil
+}
@@ -0,0 +1,232 @@
+package logserver
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"log"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+)
+
+// Syslog ingest. Network gear that can only emit syslog sends it straight
+// to the logger, over UDP or TCP on the same address, without a relay.
+// Both RFC 5424 and BSD (RFC 3164) messages are parsed (see syslog.go);
+// TCP takes octet-counted and newline-terminated framing. Like UDP ingest,
+// messages carry no ingest token, and a datagram that finds the queue full
+// is dropped. A TCP sender is made to wait instead.
+
+var (
+	// syslogAddr is where Server listens for syslog when Config.SyslogAddr
+	// is not set; empty turns syslog ingest off
+	syslogAddr = os.Getenv("SYSLOG_ADDR")
+	// syslogMaxMessage is the longest message accepted, in bytes
+	syslogMaxMessage = min(envInt("SYSLOG_MAX_BYTES", 8192), 65507)
+	// syslogQueueSize is how many messages may wait for the worker
+	syslogQueueSize = envInt("SYSLOG_QUEUE", 4096)
+)
+
+var syslogDropReasons = []string{dropOversize, dropInvalid, dropQueueFull, dropStoreFailed}
+
+// syslogStats counts messages for /metrics
+type syslogStats struct {
+	udp     atomic.Int64
+	tcp     atomic.Int64
+	stored  atomic.Int64
+	dropped dropCounter
+}
+
+// syslogPacket is a received message and the address it came from
+type syslogPacket struct {
+	data   []byte
+	remote string
+}
+
+// syslogListener reads syslog on a UDP socket and a TCP listener sharing an
+// address, and stores it in db
+type syslogListener struct {
+	udp      net.PacketConn
+	tcp      net.Listener
+	db       *Database
+	messages chan syslogPacket
+	readers  sync.WaitGroup
+	done     chan struct{}
+
+	mu     sync.Mutex
+	conns  map[net.Conn]struct{}
+	closed bool
+}
+
+// listenSyslog starts accepting syslog on addr over UDP and TCP
+func listenSyslog(addr string, db *Database) (*syslogListener, error) {
+	if ingestTokensRequired {
+		return nil, errors.New("syslog cannot carry ingest tokens; unset SYSLOG_ADDR or INGEST_TOKENS_REQUIRED")
+	}
+	udp, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	if conn, ok := udp.(*net.UDPConn); ok {
+		conn.SetReadBuffer(udpReadBuffer)
+	}
+	tcp, err := net.Listen("tcp", addr)
+	if err != nil {
+		udp.Close()
+		return nil, err
+	}
+	l := &syslogListener{
+		udp:      udp,
+		tcp:      tcp,
+		db:       db,
+		messages: make(chan syslogPacket, syslogQueueSize),
+		done:     make(chan struct{}),
+		conns:    make(map[net.Conn]struct{}),
+	}
+	l.readers.Add(2)
+	go l.readUDP()
+	go l.accept()
+	go l.store()
+	return l, nil
+}
+
+// readUDP queues datagrams until the socket is closed; as in udp_ingest.go
+// the buffer has a byte to spare so longer datagrams show up as oversize
+func (l *syslogListener) readUDP() {
+	defer l.readers.Done()
+	buf := make([]byte, syslogMaxMessage+1)
+	for {
+		n, addr, err := l.udp.ReadFrom(buf)
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				log.Printf("syslog: udp read failed: %v", err)
+			}
+			return
+		}
+		l.db.syslogStats.udp.Add(1)
+		if n > syslogMaxMessage {
+			l.db.syslogStats.dropped.add(dropOversize)
+			continue
+		}
+		select {
+		case l.messages <- syslogPacket{data: bytes.Clone(buf[:n]), remote: hostOf(addr)}:
+		default:
+			l.db.syslogStats.dropped.add(dropQueueFull)
+		}
+	}
+}
+
+// accept reads each TCP connection until the listener is closed
+func (l *syslogListener) accept() {
+	defer l.readers.Done()
+	for {
+		conn, err := l.tcp.Accept()
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				log.Printf("syslog: tcp accept failed: %v", err)
+			}
+			return
+		}
+		l.mu.Lock()
+		if l.closed {
+			l.mu.Unlock()
+			conn.Close()
+			return
+		}
+		l.conns[conn] = struct{}{}
+		l.readers.Add(1)
+		l.mu.Unlock()
+		go l.readTCP(conn)
+	}
+}
+
+// readTCP queues the messages framed on conn until it is closed. A message
+// longer than the limit loses the frame boundary, so it ends the connection.
+func (l *syslogListener) readTCP(conn net.Conn) {
+	defer l.readers.Done()
+	defer func() {
+		l.mu.Lock()
+		delete(l.conns, conn)
+		l.mu.Unlock()
+		conn.Close()
+	}()
+	remote := hostOf(conn.RemoteAddr())
+	scanner := bufio.NewScanner(conn)
+	scanner.Buffer(make([]byte, 0, 4096), syslogMaxMessage+16)
+	scanner.Split(splitSyslogFrame)
+	for scanner.Scan() {
+		frame := scanner.Bytes()
+		if len(bytes.TrimSpace(frame)) == 0 {
+			continue
+		}
+		l.db.syslogStats.tcp.Add(1)
+		if len(frame) > syslogMaxMessage {
+			l.db.syslogStats.dropped.add(dropOversize)
+			continue
+		}
+		l.messages <- syslogPacket{data: bytes.Clone(frame), remote: remote}
+	}
+	if err := scanner.Err(); err != nil && !errors.Is(err, net.ErrClosed) {
+		if errors.Is(err, bufio.ErrTooLong) {
+			l.db.syslogStats.dropped.add(dropOversize)
+		}
+		log.Printf("syslog: closing connection from %s: %v", remote, err)
+	}
+}
+
+// store stores queued messages until the readers stop and the queue is
+// drained
+func (l *syslogListener) store() {
+	defer close(l.done)
+	for packet := range l.messages {
+		now := l.db.now()
+		m, err := parseSyslog(packet.data, now)
+		if err != nil {
+			l.db.syslogStats.dropped.add(dropInvalid)
+			continue
+		}
+		entry := m.entry(packet.remote)
+		if storeRawPayloads {
+			entry.Raw, entry.RawFormat = packet.data, rawSyslog
+		}
+		if err := prepareLogEntry(&entry, now); err != nil {
+			l.db.syslogStats.dropped.add(dropInvalid)
+			continue
+		}
+		if err := l.db.InsertLog(entry); err != nil {
+			l.db.syslogStats.dropped.add(dropStoreFailed)
+			log.Printf("syslog: failed to store log: %v", err)
+			continue
+		}
+		l.db.syslogStats.stored.Add(1)
+	}
+}
+
+// Close stops listening, ends open connections and waits for the queued
+// messages to be stored
+func (l *syslogListener) Close() error {
+	err := l.udp.Close()
+	if terr := l.tcp.Close(); err == nil {
+		err = terr
+	}
+	l.mu.Lock()
+	l.closed = true
+	for conn := range l.conns {
+		conn.Close()
+	}
+	l.mu.Unlock()
+	l.readers.Wait()
+	close(l.messages)
+	<-l.done
+	return err
+}
+
+// hostOf is the IP of a UDP or TCP address
+func hostOf(addr net.Addr) string {
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return addr.String()
+	}
+	return host
+}
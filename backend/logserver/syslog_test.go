@@ -0,0 +1,269 @@
+package logserver
+
+import (
+	"bufio"
+	"strings"
+	"testing"
+	"time"
+)
+
+// syslogTestNow is the time BSD timestamps are dated against
+var syslogTestNow = time.Date(2025, 3, 10, 12, 0, 0, 0, time.Local)
+
+// FuzzSyslog parses syslog messages, and TCP streams of them, into logs
+func FuzzSyslog(f *testing.F) {
+	addCorpus(f, "syslog", func(data []byte) { f.Add(data) })
+	f.Fuzz(func(t *testing.T, input []byte) {
+		scanner := bufio.NewScanner(strings.NewReader(string(input)))
+		scanner.Split(splitSyslogFrame)
+		for scanner.Scan() {
+			m, err := parseSyslog(scanner.Bytes(), syslogTestNow)
+			if err != nil {
+				continue
+			}
+			entry := m.entry("10.0.0.1")
+			m.meta()
+			syslogEntryMeta(entry)
+		}
+	})
+}
+
+func TestParseSyslog5424(t *testing.T) {
+	for _, tc := range []struct {
+		name, input string
+		want        syslogMessage
+	}{
+		{
+			"full header",
+			`<165>1 2025-03-10T11:59:58.003Z host.example.com evntslog 42 ID47 - An application event`,
+			syslogMessage{Facility: 20, Severity: 5, Timestamp: time.Date(2025, 3, 10, 11, 59, 58, 3e6, time.UTC),
+				Hostname: "host.example.com", AppName: "evntslog", ProcID: "42", MsgID: "ID47", Message: "An application event"},
+		},
+		{
+			"nil fields",
+			"<34>1 - - - - - - \ufeffsu root failed",
+			syslogMessage{Facility: 4, Severity: 2, Message: "su root failed"},
+		},
+		{
+			"no message",
+			"<13>1 - 10.0.0.5 app - - -",
+			syslogMessage{Facility: 1, Severity: 5, Hostname: "10.0.0.5", AppName: "app"},
+		},
+		{
+			"structured data",
+			`<14>1 - - - - - [exampleSDID@32473 iut="3" eventSource="Application"][origin ip="10.0.0.9"] started`,
+			syslogMessage{Facility: 1, Severity: 6, Message: "started", StructuredData: map[string]map[string]string{
+				"exampleSDID@32473": {"iut": "3", "eventSource": "Application"},
+				"origin":            {"ip": "10.0.0.9"},
+			}},
+		},
+		{
+			"escaped values",
+			`<14>1 - - - - - [x@1 path="C:\\temp" quote="say \"hi\"" bracket="a\]b" other="\n"]`,
+			syslogMessage{Facility: 1, Severity: 6, StructuredData: map[string]map[string]string{
+				"x@1": {"path": `C:\temp`, "quote": `say "hi"`, "bracket": "a]b", "other": `\n`},
+			}},
+		},
+		{
+			"element without parameters",
+			"<14>1 - - - - - [meta] text",
+			syslogMessage{Facility: 1, Severity: 6, Message: "text", StructuredData: map[string]map[string]string{"meta": {}}},
+		},
+		{
+			"trailing newline",
+			"<14>1 - - - - - - text\r\n",
+			syslogMessage{Facility: 1, Severity: 6, Message: "text"},
+		},
+	} {
+		got, err := parseSyslog([]byte(tc.input), syslogTestNow)
+		if err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+			continue
+		}
+		expect(t, tc.name, got, tc.want)
+	}
+}
+
+func TestParseSyslogErrors(t *testing.T) {
+	for _, input := range []string{
+		"",
+		"no priority",
+		"<>1 - - - - - -",
+		"<192>1 - - - - - -",
+		"<-1>1 - - - - - -",
+		"<12345>1 - - - - - -",
+		"<14>1 - - -",
+		"<14>1 yesterday - - - - -",
+		"<14>1 - - - - - [unclosed",
+		`<14>1 - - - - - [x a="b]`,
+		`<14>1 - - - - - [x ="b"]`,
+		"<14>1 - - - - - []",
+		"<14>1 - - - - - garbage",
+	} {
+		if _, err := parseSyslog([]byte(input), syslogTestNow); err == nil {
+			t.Errorf("%q parsed", input)
+		}
+	}
+}
+
+func TestParseSyslog3164(t *testing.T) {
+	local := func(month time.Month, day, hour, minute, second int) time.Time {
+		return time.Date(2025, month, day, hour, minute, second, 0, time.Local)
+	}
+	for _, tc := range []struct {
+		name, input string
+		now         time.Time
+		want        syslogMessage
+	}{
+		{
+			"tag and pid",
+			"<38>Mar  9 22:14:15 gateway sshd[4721]: Failed password for root",
+			syslogTestNow,
+			syslogMessage{Facility: 4, Severity: 6, Timestamp: local(3, 9, 22, 14, 15),
+				Hostname: "gateway", AppName: "sshd", ProcID: "4721", Message: "Failed password for root"},
+		},
+		{
+			"tag without pid",
+			"<13>Mar 10 11:00:00 10.0.0.7 cron: job done",
+			syslogTestNow,
+			syslogMessage{Facility: 1, Severity: 5, Timestamp: local(3, 10, 11, 0, 0),
+				Hostname: "10.0.0.7", AppName: "cron", Message: "job done"},
+		},
+		{
+			"no tag",
+			"<13>Mar 10 11:00:00 router link down",
+			syslogTestNow,
+			syslogMessage{Facility: 1, Severity: 5, Timestamp: local(3, 10, 11, 0, 0), Hostname: "router", Message: "link down"},
+		},
+		{
+			"December read in January",
+			"<13>Dec 31 23:59:00 host app: late",
+			time.Date(2025, 1, 1, 0, 1, 0, 0, time.Local),
+			syslogMessage{Facility: 1, Severity: 5, Timestamp: time.Date(2024, 12, 31, 23, 59, 0, 0, time.Local),
+				Hostname: "host", AppName: "app", Message: "late"},
+		},
+		{
+			"January read in December",
+			"<13>Jan  1 00:00:30 host app: early",
+			time.Date(2024, 12, 31, 23, 59, 0, 0, time.Local),
+			syslogMessage{Facility: 1, Severity: 5, Timestamp: time.Date(2025, 1, 1, 0, 0, 30, 0, time.Local),
+				Hostname: "host", AppName: "app", Message: "early"},
+		},
+		{
+			"header that does not parse",
+			"<13>link flapped on port 3",
+			syslogTestNow,
+			syslogMessage{Facility: 1, Severity: 5, Message: "link flapped on port 3"},
+		},
+		{
+			"unterminated pid",
+			"<13>Mar 10 11:00:00 host app[12 oops",
+			syslogTestNow,
+			syslogMessage{Facility: 1, Severity: 5, Timestamp: local(3, 10, 11, 0, 0), Hostname: "host", Message: "app[12 oops"},
+		},
+		{
+			"invalid UTF-8",
+			"<13>caf\xe9",
+			syslogTestNow,
+			syslogMessage{Facility: 1, Severity: 5, Message: "caf\ufffd"},
+		},
+	} {
+		got, err := parseSyslog([]byte(tc.input), tc.now)
+		if err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+			continue
+		}
+		expect(t, tc.name, got, tc.want)
+	}
+}
+
+func TestSyslogEntry(t *testing.T) {
+	m, err := parseSyslog([]byte(`<11>1 - 10.0.0.5 sshd - - [x@1 b="2" a="q\"]"][origin ip="10.0.0.9"] login failed`), syslogTestNow)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry := m.entry("192.0.2.1")
+	expect(t, "level", entry.Level, "ERROR")
+	expect(t, "rule", entry.Rule, "syslog:sshd")
+	expect(t, "event", entry.Event, "login failed")
+	expect(t, "source", entry.SourceIP, "10.0.0.5")
+	expect(t, "tags", entry.Tags, []string{"syslog", "facility:user"})
+	expect(t, "description", entry.Description, `[origin ip="10.0.0.9"][x@1 a="q\"\]" b="2"]`)
+
+	// The description parses back to the same structured data
+	var back syslogMessage
+	rest, err := back.parseStructuredData(entry.Description)
+	if err != nil || rest != "" {
+		t.Fatalf("description does not parse back: %q, %v", rest, err)
+	}
+	expect(t, "structured data read back", back.StructuredData, m.StructuredData)
+
+	// A hostname that is not an address leaves the sender as the source
+	m.Hostname = "gateway"
+	expect(t, "source of a named host", m.entry("192.0.2.1").SourceIP, "192.0.2.1")
+}
+
+// Logs stored without their raw payload keep the structured data in the
+// description, where expressions still find it
+func TestSyslogStructuredDataWithoutRaw(t *testing.T) {
+	m, err := parseSyslog([]byte(`<14>1 - host app - - [origin@123 ip="10.0.0.9"] started`), syslogTestNow)
+	if err != nil {
+		t.Fatal(err)
+	}
+	withoutRaw := m.entry("192.0.2.1")
+	withRaw := withoutRaw
+	withRaw.Raw, withRaw.RawFormat = []byte(`<14>1 - host app - - [origin@123 ip="10.0.0.9"] started`), rawSyslog
+	for _, source := range []string{
+		`meta.structuredData["origin@123"].ip == "10.0.0.9"`,
+		`meta.appName == "app" && meta.message == "started"`,
+	} {
+		expr, err := parseLogExpr(source)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for name, entry := range map[string]LogEntry{"with raw": withRaw, "without raw": withoutRaw} {
+			ok, err := expr.match(entry)
+			if err != nil || !ok {
+				t.Errorf("%s: %s: got %v, %v", name, source, ok, err)
+			}
+		}
+	}
+
+	// Other logs' descriptions are not read as structured data
+	expr, err := parseLogExpr(`meta.structuredData == nil`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other := LogEntry{Rule: "Brute Force Login", Description: `[origin@123 ip="10.0.0.9"]`}
+	if ok, err := expr.match(other); err != nil || !ok {
+		t.Errorf("structured data read from a non-syslog log: %v, %v", ok, err)
+	}
+}
+
+func TestSplitSyslogFrame(t *testing.T) {
+	for _, tc := range []struct {
+		name, stream string
+		want         []string
+		err          bool
+	}{
+		{"octet counted", "11 <14>1 - - a5 <14>b", []string{"<14>1 - - a", "<14>b"}, false},
+		{"newline terminated", "<14>a\r\n<14>b\n<14>c", []string{"<14>a", "<14>b", "<14>c"}, false},
+		{"NUL terminated", "<14>a\x00<14>b\x00", []string{"<14>a", "<14>b"}, false},
+		{"mixed", "5 <14>a<14>b\n", []string{"<14>a", "<14>b"}, false},
+		{"counted frame holding a newline", "11 <14>a\n<14>b", []string{"<14>a\n<14>b"}, false},
+		{"truncated", "20 <14>a", nil, true},
+		{"malformed length", "12x <14>a", nil, true},
+		{"length without a space", "12345678901", nil, true},
+	} {
+		scanner := bufio.NewScanner(strings.NewReader(tc.stream))
+		scanner.Split(splitSyslogFrame)
+		var got []string
+		for scanner.Scan() {
+			got = append(got, scanner.Text())
+		}
+		expect(t, tc.name, got, tc.want)
+		if (scanner.Err() != nil) != tc.err {
+			t.Errorf("%s: error %v", tc.name, scanner.Err())
+		}
+	}
+}
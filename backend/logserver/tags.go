@@ -0,0 +1,532 @@
+package logserver
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TagRule tags every ingested log that matches Filter
+type TagRule struct {
+	ID        int64     `json:"id"`
+	Tag       string    `json:"tag"`
+	Filter    LogFilter `json:"filter"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// TagCount is a tag and how many logs and notables carry it
+type TagCount struct {
+	Tag      string `json:"tag"`
+	Logs     int    `json:"logs"`
+	Notables int    `json:"notables"`
+}
+
+// tagRuleCache holds the ingest tag rules so InsertLog does not query them per log
+type tagRuleCache struct {
+	mu    sync.RWMutex
+	rules []TagRule
+}
+
+const maxTagLength = 64
+
+func createTagTables(db *sql.DB) error {
+	for _, stmt := range []string{
+		`CREATE TABLE IF NOT EXISTS log_tags (
+			log_id INTEGER NOT NULL,
+			tag TEXT NOT NULL,
+			PRIMARY KEY (log_id, tag)
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_log_tags_tag ON log_tags(tag)`,
+		`CREATE TABLE IF NOT EXISTS notable_tags (
+			notable_id INTEGER NOT NULL,
+			tag TEXT NOT NULL,
+			PRIMARY KEY (notable_id, tag)
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_notable_tags_tag ON notable_tags(tag)`,
+		`CREATE TABLE IF NOT EXISTS tag_rules (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			tag TEXT NOT NULL,
+			filter TEXT NOT NULL,
+			created_at DATETIME NOT NULL
+		)`,
+	} {
+		if _, err := db.Exec(stmt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// normalizeTags trims, deduplicates and validates tags
+func normalizeTags(tags []string) ([]string, error) {
+	seen := map[string]bool{}
+	normalized := []string{}
+	for _, tag := range tags {
+		tag = strings.TrimSpace(tag)
+		if tag == "" || len(tag) > maxTagLength || strings.Contains(tag, ",") {
+			return nil, errors.New("tags must be 1-64 characters without commas")
+		}
+		if !seen[tag] {
+			seen[tag] = true
+			normalized = append(normalized, tag)
+		}
+	}
+	return normalized, nil
+}
+
+// matches applies the filter's non-time conditions to a single entry in Go
+func (f LogFilter) matches(l LogEntry) bool {
+	if f.IP != "" && !f.matchText(l.SourceIP, f.IP) && !f.matchText(l.DestinationIP, f.IP) {
+		return false
+	}
+	if f.Event != "" && !f.matchText(l.Event, f.Event) {
+		return false
+	}
+	return f.Level.contains(l.Level) &&
+		f.Source.contains(l.SourceIP) &&
+		f.Destination.contains(l.DestinationIP) &&
+		f.Rule.contains(l.Rule) &&
+		f.Category.contains(l.category()) &&
+		f.SourcePort.contains(strconv.Itoa(l.SourcePort)) &&
+		f.DestinationPort.contains(strconv.Itoa(l.DestinationPort)) &&
+		f.User.contains(l.User) &&
+		f.TraceID.contains(l.TraceID) &&
+		f.Urgency.contains(strconv.Itoa(l.Urgency)) &&
+		len(f.Tag) == 0 &&
+		f.matchesScope(l)
+}
+
+// matchesScope applies Require and Exclude, including their time bounds.
+// Their tag conditions are checked against the entry's own tags, which
+// archived entries carry.
+func (f LogFilter) matchesScope(l LogEntry) bool {
+	matches := func(sub LogFilter) bool {
+		tags := sub.Tag
+		sub.Tag = nil
+		return sub.matches(l) && (len(tags) == 0 || hasAnyTag(l.Tags, tags)) &&
+			(sub.From.IsZero() || !l.Timestamp.Before(sub.From)) &&
+			(sub.To.IsZero() || l.Timestamp.Before(sub.To))
+	}
+	for _, sub := range f.Require {
+		if !matches(sub) {
+			return false
+		}
+	}
+	for _, sub := range f.Exclude {
+		if matches(sub) {
+			return false
+		}
+	}
+	return true
+}
+
+// contains reports whether value is in the list; an empty list matches everything
+func (l stringList) contains(value string) bool {
+	if len(l) == 0 {
+		return true
+	}
+	for _, v := range l {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func (d *Database) loadTagRules() error {
+	rows, err := d.db.Query(`SELECT id, tag, filter, created_at FROM tag_rules ORDER BY id`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	rules := []TagRule{}
+	for rows.Next() {
+		var rule TagRule
+		var filter string
+		if err := rows.Scan(&rule.ID, &rule.Tag, &filter, &rule.CreatedAt); err != nil {
+			return err
+		}
+		if err := json.Unmarshal([]byte(filter), &rule.Filter); err != nil {
+			return err
+		}
+		rules = append(rules, rule)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	d.tagRules.mu.Lock()
+	d.tagRules.rules = rules
+	d.tagRules.mu.Unlock()
+	return nil
+}
+
+func (d *Database) GetTagRules() []TagRule {
+	d.tagRules.mu.RLock()
+	defer d.tagRules.mu.RUnlock()
+	return append([]TagRule{}, d.tagRules.rules...)
+}
+
+func (d *Database) CreateTagRule(rule TagRule) (TagRule, error) {
+	rule, err := d.createTagRule(d.db, rule)
+	if err != nil {
+		return rule, err
+	}
+	return rule, d.loadTagRules()
+}
+
+// createTagRule stores a rule without reloading the rules ingest applies
+func (d *Database) createTagRule(ex execer, rule TagRule) (TagRule, error) {
+	filter, _ := json.Marshal(rule.Filter)
+	rule.CreatedAt = d.now().UTC()
+	res, err := ex.Exec(`INSERT INTO tag_rules (tag, filter, created_at) VALUES (?, ?, ?)`, rule.Tag, string(filter), rule.CreatedAt)
+	if err != nil {
+		return rule, err
+	}
+	rule.ID, err = res.LastInsertId()
+	return rule, err
+}
+
+func (d *Database) GetTagRule(id int64) (TagRule, error) {
+	for _, rule := range d.GetTagRules() {
+		if rule.ID == id {
+			return rule, nil
+		}
+	}
+	return TagRule{}, sql.ErrNoRows
+}
+
+func (d *Database) UpdateTagRule(rule TagRule) error {
+	filter, _ := json.Marshal(rule.Filter)
+	res, err := d.db.Exec(`UPDATE tag_rules SET tag = ?, filter = ? WHERE id = ?`, rule.Tag, string(filter), rule.ID)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return d.loadTagRules()
+}
+
+func (d *Database) DeleteTagRule(id int64) error {
+	if err := deleteTagRule(d.db, id); err != nil {
+		return err
+	}
+	return d.loadTagRules()
+}
+
+func deleteTagRule(ex execer, id int64) error {
+	_, err := ex.Exec(`DELETE FROM tag_rules WHERE id = ?`, id)
+	return err
+}
+
+// ingestTags returns the entry's own tags plus those of every matching tag rule
+func (d *Database) ingestTags(l LogEntry) []string {
+	// Clipping the capacity makes a matching rule copy l.Tags instead of
+	// writing into its backing array; a log no rule matches costs nothing
+	tags := l.Tags[:len(l.Tags):len(l.Tags)]
+	d.tagRules.mu.RLock()
+	rules := d.tagRules.rules
+	d.tagRules.mu.RUnlock()
+	for _, rule := range rules {
+		if rule.Filter.matches(l) {
+			tags = append(tags, rule.Tag)
+		}
+	}
+	return tags
+}
+
+func tagLog(tx *sql.Tx, id int64, tags []string) error {
+	for _, tag := range tags {
+		if _, err := tx.Exec(`INSERT OR IGNORE INTO log_tags (log_id, tag) VALUES (?, ?)`, id, tag); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// TagLogs adds tags to every log matching filter in a single transaction and
+// returns how many logs matched
+func (d *Database) TagLogs(filter LogFilter, tags []string) (int64, error) {
+	clause, args := filter.where()
+	tx, err := d.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	var matched int64
+	if err := tx.QueryRow(`SELECT COUNT(*) FROM logs WHERE 1=1`+clause, args...).Scan(&matched); err != nil {
+		return 0, err
+	}
+	for _, tag := range tags {
+		_, err := tx.Exec(`INSERT OR IGNORE INTO log_tags (log_id, tag) SELECT id, ? FROM logs WHERE 1=1`+clause,
+			append([]interface{}{tag}, args...)...)
+		if err != nil {
+			return 0, err
+		}
+	}
+	return matched, tx.Commit()
+}
+
+// UntagLogs removes tags from every log matching filter in a single transaction
+func (d *Database) UntagLogs(filter LogFilter, tags []string) (int64, error) {
+	clause, args := filter.where()
+	tx, err := d.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	var removed int64
+	for _, tag := range tags {
+		res, err := tx.Exec(`DELETE FROM log_tags WHERE tag = ? AND log_id IN (SELECT id FROM logs WHERE 1=1`+clause+`)`,
+			append([]interface{}{tag}, args...)...)
+		if err != nil {
+			return 0, err
+		}
+		n, _ := res.RowsAffected()
+		removed += n
+	}
+	return removed, tx.Commit()
+}
+
+func (d *Database) TagNotable(id int64, tags []string) error {
+	for _, tag := range tags {
+		if _, err := d.db.Exec(`INSERT OR IGNORE INTO notable_tags (notable_id, tag) VALUES (?, ?)`, id, tag); err != nil {
+			return err
+		}
+	}
+	d.recordNotableChange(id, changeTagged)
+	return nil
+}
+
+func (d *Database) UntagNotable(id int64, tags []string) error {
+	for _, tag := range tags {
+		if _, err := d.db.Exec(`DELETE FROM notable_tags WHERE notable_id = ? AND tag = ?`, id, tag); err != nil {
+			return err
+		}
+	}
+	d.recordNotableChange(id, changeUntagged)
+	return nil
+}
+
+// notableTags returns the tags of each notable ID
+func (d *Database) notableTags(ids []int64) (map[int64][]string, error) {
+	return d.tagsFor(`notable_tags`, `notable_id`, ids)
+}
+
+// tagsFor loads the tags of ids from a log_tags or notable_tags table
+func (d *Database) tagsFor(table, column string, ids []int64) (map[int64][]string, error) {
+	tags := map[int64][]string{}
+	if len(ids) == 0 {
+		return tags, nil
+	}
+	args := make([]interface{}, len(ids))
+	for i, id := range ids {
+		args[i] = id
+	}
+	rows, err := d.db.Query(`SELECT `+column+`, tag FROM `+table+` WHERE `+column+` IN (?`+strings.Repeat(`, ?`, len(ids)-1)+`) ORDER BY tag`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id int64
+		var tag string
+		if err := rows.Scan(&id, &tag); err != nil {
+			return nil, err
+		}
+		tags[id] = append(tags[id], tag)
+	}
+	return tags, rows.Err()
+}
+
+// attachTags fills in the tags of each log
+func (d *Database) attachTags(logs []LogEntry) error {
+	ids := make([]int64, len(logs))
+	for i, l := range logs {
+		ids[i] = l.ID
+	}
+	tags, err := d.tagsFor(`log_tags`, `log_id`, ids)
+	if err != nil {
+		return err
+	}
+	for i := range logs {
+		logs[i].Tags = tags[logs[i].ID]
+	}
+	return nil
+}
+
+// GetTagCounts lists every tag with its log and notable counts
+func (d *Database) GetTagCounts() ([]TagCount, error) {
+	counts := map[string]*TagCount{}
+	for _, q := range []struct {
+		query string
+		logs  bool
+	}{
+		{`SELECT tag, COUNT(*) FROM log_tags GROUP BY tag`, true},
+		{`SELECT tag, COUNT(*) FROM notable_tags GROUP BY tag`, false},
+	} {
+		rows, err := d.db.Query(q.query)
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			var tag string
+			var n int
+			if err := rows.Scan(&tag, &n); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			if counts[tag] == nil {
+				counts[tag] = &TagCount{Tag: tag}
+			}
+			if q.logs {
+				counts[tag].Logs = n
+			} else {
+				counts[tag].Notables = n
+			}
+		}
+		rows.Close()
+	}
+	result := make([]TagCount, 0, len(counts))
+	for _, c := range counts {
+		result = append(result, *c)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		ti, tj := result[i].Logs+result[i].Notables, result[j].Logs+result[j].Notables
+		if ti != tj {
+			return ti > tj
+		}
+		return result[i].Tag < result[j].Tag
+	})
+	return result, nil
+}
+
+// decodeTags reads {"tags": [...]} from a request body
+func decodeTags(r *http.Request) ([]string, error) {
+	tags, _, err := decodeTagRequest(r)
+	return tags, err
+}
+
+// decodeTagRequest reads {"tags": [...], "all": true} from a request body;
+// all opts a search-wide change into matching every log
+func decodeTagRequest(r *http.Request) ([]string, bool, error) {
+	var req struct {
+		Tags []string `json:"tags"`
+		All  bool     `json:"all"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return nil, false, errors.New("Invalid JSON")
+	}
+	if len(req.Tags) == 0 {
+		return nil, false, errors.New("tags is required")
+	}
+	tags, err := normalizeTags(req.Tags)
+	return tags, req.All, err
+}
+
+// GET /api/tags - every tag with log and notable counts
+func tagsHandlerDB(w http.ResponseWriter, r *http.Request, db *Database) {
+	enableCORS(w)
+	w.Header().Set("Content-Type", "application/json")
+	counts, err := db.GetTagCounts()
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "Failed to fetch tags")
+		return
+	}
+	json.NewEncoder(w).Encode(counts)
+}
+
+// POST/DELETE /api/logs/tags?<search filters> - add or remove tags on every
+// matching log (admin only). As with bulk jobs, a search without filters
+// must set all.
+func logTagsHandlerDB(w http.ResponseWriter, r *http.Request, db *Database) {
+	enableCORS(w)
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method == http.MethodOptions {
+		return
+	}
+	if !db.requireAdmin(w, r) {
+		return
+	}
+	filter, err := parseLogFilter(r.URL.Query(), db.now())
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	tags, all, err := decodeTagRequest(r)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if len(filter.values()) == 0 && !all {
+		writeJSONError(w, http.StatusBadRequest, "no filters given; set all to tag every log")
+		return
+	}
+	var n int64
+	switch r.Method {
+	case http.MethodPost:
+		n, err = db.TagLogs(filter, tags)
+	case http.MethodDelete:
+		n, err = db.UntagLogs(filter, tags)
+	default:
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "Failed to update tags")
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"tags": tags, "affected": n})
+}
+
+// GET/POST/DELETE /api/tags/rules - manage ingest tag rules (admin only)
+func tagRulesHandlerDB(w http.ResponseWriter, r *http.Request, db *Database) {
+	enableCORS(w)
+	w.Header().Set("Content-Type", "application/json")
+	if !db.requireAdmin(w, r) {
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		json.NewEncoder(w).Encode(db.GetTagRules())
+	case http.MethodPost:
+		var rule TagRule
+		if err := json.NewDecoder(r.Body).Decode(&rule); err != nil {
+			writeJSONError(w, http.StatusBadRequest, "Invalid JSON")
+			return
+		}
+		tags, err := normalizeTags([]string{rule.Tag})
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		rule.Tag = tags[0]
+		rule, err = db.CreateTagRule(rule)
+		if err != nil {
+			writeJSONError(w, http.StatusInternalServerError, "Failed to create tag rule")
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(rule)
+	case http.MethodDelete:
+		id, err := strconv.ParseInt(r.URL.Query().Get("id"), 10, 64)
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, "Invalid id")
+			return
+		}
+		if err := db.DeleteTagRule(id); err != nil {
+			writeJSONError(w, http.StatusInternalServerError, "Failed to delete tag rule")
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+	}
+}
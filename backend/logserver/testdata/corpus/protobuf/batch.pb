@@ -0,0 +1,4 @@
+
+e܂쏚ERRORBrute Force Login"10.0.0.1*
+10.0.0.1002Failed login@JalicePXjauthrabc123
+#INFO2Login successbaccessjajb
\ No newline at end of file
@@ -0,0 +1,329 @@
+package logserver
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// TicketProvider creates and tracks external tickets for notables
+type TicketProvider interface {
+	// Create opens a ticket from the mapped fields and returns its key and browse URL
+	Create(fields map[string]string) (key, url string, err error)
+	// IsResolved reports whether the ticket is in a done/resolved state
+	IsResolved(key string) (bool, error)
+	// Resolve moves the ticket to a done/resolved state
+	Resolve(key string) error
+}
+
+// defaultTicketFields maps ticket fields to templates over a NotableEvent
+var defaultTicketFields = map[string]map[string]string{
+	"jira": {
+		"summary":     "[{{.Urgency}}] {{.RuleName}} from {{.SourceIP}}",
+		"description": "{{.Description}}\n\nCategory: {{.Category}}\nSource: {{.SourceIP}}\nDestination: {{.Destination}}\nCount: {{.Count}}\nNotable: {{.ID}}",
+	},
+	"servicenow": {
+		"short_description": "[{{.Urgency}}] {{.RuleName}} from {{.SourceIP}}",
+		"description":       "{{.Description}}\n\nCategory: {{.Category}}\nSource: {{.SourceIP}}\nDestination: {{.Destination}}\nCount: {{.Count}}\nNotable: {{.ID}}",
+	},
+}
+
+var ticketSyncInterval = envDuration("TICKET_SYNC_INTERVAL", 5*time.Minute)
+
+func envDuration(key string, fallback time.Duration) time.Duration {
+	if d, err := time.ParseDuration(os.Getenv(key)); err == nil && d > 0 {
+		return d
+	}
+	return fallback
+}
+
+// newTicketProvider builds the provider selected by TICKET_PROVIDER, or nil when unset
+func newTicketProvider() (TicketProvider, map[string]string, error) {
+	name := os.Getenv("TICKET_PROVIDER")
+	var provider TicketProvider
+	switch name {
+	case "":
+		return nil, nil, nil
+	case "jira":
+		provider = &jiraProvider{
+			baseURL:   strings.TrimRight(os.Getenv("JIRA_URL"), "/"),
+			user:      os.Getenv("JIRA_USER"),
+			token:     os.Getenv("JIRA_API_TOKEN"),
+			project:   os.Getenv("JIRA_PROJECT"),
+			issueType: envOr("JIRA_ISSUE_TYPE", "Task"),
+		}
+	case "servicenow":
+		provider = &serviceNowProvider{
+			baseURL:  strings.TrimRight(os.Getenv("SERVICENOW_URL"), "/"),
+			user:     os.Getenv("SERVICENOW_USER"),
+			password: os.Getenv("SERVICENOW_PASSWORD"),
+		}
+	default:
+		return nil, nil, fmt.Errorf("unknown TICKET_PROVIDER %q", name)
+	}
+	fields := defaultTicketFields[name]
+	if mapping := os.Getenv("TICKET_FIELD_MAPPING"); mapping != "" {
+		fields = map[string]string{}
+		if err := json.Unmarshal([]byte(mapping), &fields); err != nil {
+			return nil, nil, fmt.Errorf("invalid TICKET_FIELD_MAPPING: %v", err)
+		}
+	}
+	return provider, fields, nil
+}
+
+// renderTicketFields executes each field template against the notable
+func renderTicketFields(mapping map[string]string, n NotableEvent) (map[string]string, error) {
+	fields := make(map[string]string, len(mapping))
+	for field, text := range mapping {
+		tmpl, err := template.New(field).Option("missingkey=zero").Parse(text)
+		if err != nil {
+			return nil, fmt.Errorf("field %s: %v", field, err)
+		}
+		var buf bytes.Buffer
+		if err := tmpl.Execute(&buf, n); err != nil {
+			return nil, fmt.Errorf("field %s: %v", field, err)
+		}
+		fields[field] = buf.String()
+	}
+	return fields, nil
+}
+
+// ticketJSON sends a JSON request with basic auth and decodes a JSON response into out
+func ticketJSON(method, url, user, password string, body, out interface{}) error {
+	var reader *bytes.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(b)
+	} else {
+		reader = bytes.NewReader(nil)
+	}
+	req, err := http.NewRequest(method, url, reader)
+	if err != nil {
+		return err
+	}
+	req.SetBasicAuth(user, password)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+	resp, err := notifyClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("%s %s: %s", method, url, resp.Status)
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+type jiraProvider struct {
+	baseURL, user, token, project, issueType string
+}
+
+func (j *jiraProvider) Create(fields map[string]string) (string, string, error) {
+	payload := map[string]interface{}{
+		"project":   map[string]string{"key": j.project},
+		"issuetype": map[string]string{"name": j.issueType},
+	}
+	for k, v := range fields {
+		payload[k] = v
+	}
+	var resp struct {
+		Key string `json:"key"`
+	}
+	if err := ticketJSON(http.MethodPost, j.baseURL+"/rest/api/2/issue", j.user, j.token, map[string]interface{}{"fields": payload}, &resp); err != nil {
+		return "", "", err
+	}
+	return resp.Key, j.baseURL + "/browse/" + resp.Key, nil
+}
+
+func (j *jiraProvider) IsResolved(key string) (bool, error) {
+	var resp struct {
+		Fields struct {
+			Status struct {
+				StatusCategory struct {
+					Key string `json:"key"`
+				} `json:"statusCategory"`
+			} `json:"status"`
+		} `json:"fields"`
+	}
+	if err := ticketJSON(http.MethodGet, j.baseURL+"/rest/api/2/issue/"+key+"?fields=status", j.user, j.token, nil, &resp); err != nil {
+		return false, err
+	}
+	return resp.Fields.Status.StatusCategory.Key == "done", nil
+}
+
+func (j *jiraProvider) Resolve(key string) error {
+	var resp struct {
+		Transitions []struct {
+			ID string `json:"id"`
+			To struct {
+				StatusCategory struct {
+					Key string `json:"key"`
+				} `json:"statusCategory"`
+			} `json:"to"`
+		} `json:"transitions"`
+	}
+	url := j.baseURL + "/rest/api/2/issue/" + key + "/transitions"
+	if err := ticketJSON(http.MethodGet, url, j.user, j.token, nil, &resp); err != nil {
+		return err
+	}
+	for _, t := range resp.Transitions {
+		if t.To.StatusCategory.Key == "done" {
+			return ticketJSON(http.MethodPost, url, j.user, j.token, map[string]interface{}{"transition": map[string]string{"id": t.ID}}, nil)
+		}
+	}
+	return errors.New("no transition to a done status available for " + key)
+}
+
+type serviceNowProvider struct {
+	baseURL, user, password string
+}
+
+// ServiceNow incident states 6 and 7 are Resolved and Closed
+var serviceNowResolvedStates = map[string]bool{"6": true, "7": true}
+
+func (s *serviceNowProvider) Create(fields map[string]string) (string, string, error) {
+	var resp struct {
+		Result struct {
+			SysID string `json:"sys_id"`
+		} `json:"result"`
+	}
+	if err := ticketJSON(http.MethodPost, s.baseURL+"/api/now/table/incident", s.user, s.password, fields, &resp); err != nil {
+		return "", "", err
+	}
+	return resp.Result.SysID, s.baseURL + "/nav_to.do?uri=incident.do?sys_id=" + resp.Result.SysID, nil
+}
+
+func (s *serviceNowProvider) IsResolved(key string) (bool, error) {
+	var resp struct {
+		Result struct {
+			State string `json:"state"`
+		} `json:"result"`
+	}
+	if err := ticketJSON(http.MethodGet, s.baseURL+"/api/now/table/incident/"+key+"?sysparm_fields=state", s.user, s.password, nil, &resp); err != nil {
+		return false, err
+	}
+	return serviceNowResolvedStates[resp.Result.State], nil
+}
+
+func (s *serviceNowProvider) Resolve(key string) error {
+	return ticketJSON(http.MethodPatch, s.baseURL+"/api/now/table/incident/"+key, s.user, s.password, map[string]string{
+		"state":       "6",
+		"close_code":  "Solved (Permanently)",
+		"close_notes": "Resolved in logger",
+	}, nil)
+}
+
+// CreateTicket opens an external ticket for the notable and stores its key
+func (d *Database) CreateTicket(id int64) (NotableEvent, error) {
+	if d.tickets == nil {
+		return NotableEvent{}, errTicketsDisabled
+	}
+	n, err := d.GetNotable(id)
+	if err != nil {
+		return n, err
+	}
+	if n.TicketKey != "" {
+		return n, nil
+	}
+	fields, err := renderTicketFields(d.ticketFields, n)
+	if err != nil {
+		return n, err
+	}
+	key, url, err := d.tickets.Create(fields)
+	if err != nil {
+		return n, err
+	}
+	if _, err := d.db.Exec(`UPDATE notables SET ticket_key = ?, ticket_url = ?, updated_at = ? WHERE id = ?`, key, url, d.now().UTC(), id); err != nil {
+		return n, err
+	}
+	if n, err = d.GetNotable(id); err == nil {
+		d.recordChange(changeNotable, n.ID, changeTicketLinked, n)
+	}
+	return n, err
+}
+
+var errTicketsDisabled = errors.New("ticket integration is not configured")
+
+// syncTickets mirrors resolution between notables and their tickets in both directions
+func (d *Database) syncTickets() error {
+	rows, err := d.db.Query(`SELECT id, status, ticket_key FROM notables WHERE ticket_key != '' AND ticket_synced = 0`)
+	if err != nil {
+		return err
+	}
+	type linked struct {
+		id     int64
+		status string
+		key    string
+	}
+	var notables []linked
+	for rows.Next() {
+		var l linked
+		if err := rows.Scan(&l.id, &l.status, &l.key); err != nil {
+			rows.Close()
+			return err
+		}
+		notables = append(notables, l)
+	}
+	rows.Close()
+
+	for _, l := range notables {
+		resolved, err := d.tickets.IsResolved(l.key)
+		if err != nil {
+			log.Printf("tickets: %s: %v", l.key, err)
+			continue
+		}
+		switch {
+		case resolved && l.status != notableStatusResolved:
+			if _, err := d.ResolveNotable(l.id); err != nil {
+				return err
+			}
+		case !resolved && l.status == notableStatusResolved:
+			if err := d.tickets.Resolve(l.key); err != nil {
+				log.Printf("tickets: %s: %v", l.key, err)
+				continue
+			}
+		case !resolved:
+			continue
+		}
+		// Both sides are resolved; stop polling this ticket
+		if _, err := d.db.Exec(`UPDATE notables SET ticket_synced = 1 WHERE id = ?`, l.id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// startTicketSync syncs notable tickets every ticketSyncInterval until ctx
+// is done
+func startTicketSync(ctx context.Context, db *Database) {
+	if db.tickets == nil {
+		return
+	}
+	ticker := time.NewTicker(ticketSyncInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := db.syncTickets(); err != nil {
+				log.Printf("ticket sync failed: %v", err)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,333 @@
+package logserver
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+)
+
+// Timeline annotations mark events of interest, such as deploys,
+// maintenance and incidents, so charts can overlay "deploy v2.3.1" on the
+// log volume it caused. CI/CD pipelines post them as they run; analysts
+// post them by hand. An annotation is a point in time, or a range while it
+// has an end. Unlike the annotations on log entries, they are not tied to
+// any log.
+
+// TimelineAnnotation is an event of interest on the timeline
+type TimelineAnnotation struct {
+	ID          int64      `json:"id"`
+	Kind        string     `json:"kind"`
+	Label       string     `json:"label"`
+	Description string     `json:"description,omitempty"`
+	Start       time.Time  `json:"start"`
+	End         *time.Time `json:"end,omitempty"`
+	Tags        []string   `json:"tags"`
+	URL         string     `json:"url,omitempty"`
+	Author      string     `json:"author,omitempty"`
+	CreatedAt   time.Time  `json:"createdAt"`
+}
+
+// TimelineAnnotationFilter selects the annotations overlapping From..To;
+// zero values match everything
+type TimelineAnnotationFilter struct {
+	From, To time.Time
+	Kind     string
+	Tag      string
+	Limit    int
+}
+
+const (
+	annotationDeploy      = "deploy"
+	annotationMaintenance = "maintenance"
+	annotationIncident    = "incident"
+	annotationOther       = "other"
+)
+
+var annotationKinds = []string{annotationDeploy, annotationMaintenance, annotationIncident, annotationOther}
+
+// maxChartAnnotations caps the annotations returned with chart data
+const maxChartAnnotations = 200
+
+func createTimelineAnnotationTables(db *sql.DB) error {
+	_, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS timeline_annotations (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			kind TEXT NOT NULL,
+			label TEXT NOT NULL,
+			description TEXT NOT NULL DEFAULT '',
+			start_at DATETIME NOT NULL,
+			end_at DATETIME,
+			tags TEXT NOT NULL,
+			url TEXT NOT NULL DEFAULT '',
+			author TEXT NOT NULL DEFAULT '',
+			created_at DATETIME NOT NULL
+		)
+	`)
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec(`CREATE INDEX IF NOT EXISTS idx_timeline_annotations_start ON timeline_annotations(start_at)`)
+	return err
+}
+
+func (a TimelineAnnotation) validate() error {
+	switch {
+	case !slices.Contains(annotationKinds, a.Kind):
+		return errors.New("kind must be one of deploy, maintenance, incident, other")
+	case a.Label == "":
+		return errors.New("label is required")
+	case len(a.Label) > 200:
+		return errors.New("label must be at most 200 characters")
+	case a.End != nil && a.End.Before(a.Start):
+		return errors.New("end must not be before start")
+	}
+	return nil
+}
+
+// normalize fills in the defaults of a posted annotation
+func (a *TimelineAnnotation) normalize(now time.Time) {
+	if a.Kind == "" {
+		a.Kind = annotationOther
+	}
+	if a.Start.IsZero() {
+		a.Start = now
+	}
+	a.Start = a.Start.UTC()
+	if a.End != nil {
+		end := a.End.UTC()
+		a.End = &end
+	}
+	if a.Tags == nil {
+		a.Tags = []string{}
+	}
+}
+
+func (d *Database) CreateTimelineAnnotation(a TimelineAnnotation) (TimelineAnnotation, error) {
+	tags, _ := json.Marshal(a.Tags)
+	a.CreatedAt = d.now().UTC()
+	res, err := d.db.Exec(`
+		INSERT INTO timeline_annotations (kind, label, description, start_at, end_at, tags, url, author, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, a.Kind, a.Label, a.Description, a.Start, nullableTime(a.End), string(tags), a.URL, a.Author, a.CreatedAt)
+	if err != nil {
+		return a, err
+	}
+	// The timeline's ETag must change with its annotations
+	d.logsChanged()
+	a.ID, err = res.LastInsertId()
+	return a, err
+}
+
+// UpdateTimelineAnnotation replaces an annotation, such as to end an
+// incident; its author and creation time are kept
+func (d *Database) UpdateTimelineAnnotation(a TimelineAnnotation) (TimelineAnnotation, error) {
+	tags, _ := json.Marshal(a.Tags)
+	res, err := d.db.Exec(`
+		UPDATE timeline_annotations SET kind = ?, label = ?, description = ?, start_at = ?, end_at = ?, tags = ?, url = ?
+		WHERE id = ?
+	`, a.Kind, a.Label, a.Description, a.Start, nullableTime(a.End), string(tags), a.URL, a.ID)
+	if err := requireOneRow(res, err); err != nil {
+		return a, err
+	}
+	d.logsChanged()
+	return d.GetTimelineAnnotation(a.ID)
+}
+
+func (d *Database) DeleteTimelineAnnotation(id int64) error {
+	res, err := d.db.Exec(`DELETE FROM timeline_annotations WHERE id = ?`, id)
+	if err := requireOneRow(res, err); err != nil {
+		return err
+	}
+	d.logsChanged()
+	return nil
+}
+
+func (d *Database) GetTimelineAnnotation(id int64) (TimelineAnnotation, error) {
+	annotations, err := d.queryTimelineAnnotations(`WHERE id = ?`, id)
+	if err != nil {
+		return TimelineAnnotation{}, err
+	}
+	if len(annotations) == 0 {
+		return TimelineAnnotation{}, sql.ErrNoRows
+	}
+	return annotations[0], nil
+}
+
+// GetTimelineAnnotations returns the annotations matching filter, newest
+// first. A point annotation overlaps the range it falls in.
+func (d *Database) GetTimelineAnnotations(filter TimelineAnnotationFilter) ([]TimelineAnnotation, error) {
+	where := `WHERE 1=1`
+	args := []interface{}{}
+	if !filter.To.IsZero() {
+		where += ` AND start_at < ?`
+		args = append(args, filter.To.UTC())
+	}
+	if !filter.From.IsZero() {
+		where += ` AND COALESCE(end_at, start_at) >= ?`
+		args = append(args, filter.From.UTC())
+	}
+	if filter.Kind != "" {
+		where += ` AND kind = ?`
+		args = append(args, filter.Kind)
+	}
+	if filter.Tag != "" {
+		where += ` AND EXISTS (SELECT 1 FROM json_each(timeline_annotations.tags) WHERE value = ?)`
+		args = append(args, filter.Tag)
+	}
+	where += ` ORDER BY start_at DESC, id DESC LIMIT ?`
+	args = append(args, filter.Limit)
+	return d.queryTimelineAnnotations(where, args...)
+}
+
+// chartAnnotations returns the annotations to overlay on a chart of from..to
+func (d *Database) chartAnnotations(from, to time.Time) ([]TimelineAnnotation, error) {
+	return d.GetTimelineAnnotations(TimelineAnnotationFilter{From: from, To: to, Limit: maxChartAnnotations})
+}
+
+func (d *Database) queryTimelineAnnotations(where string, args ...interface{}) ([]TimelineAnnotation, error) {
+	rows, err := d.db.Query(`
+		SELECT id, kind, label, description, start_at, end_at, tags, url, author, created_at
+		FROM timeline_annotations `+where, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	annotations := []TimelineAnnotation{}
+	for rows.Next() {
+		var a TimelineAnnotation
+		var end sql.NullTime
+		var tags string
+		if err := rows.Scan(&a.ID, &a.Kind, &a.Label, &a.Description, &a.Start, &end, &tags, &a.URL, &a.Author, &a.CreatedAt); err != nil {
+			return nil, err
+		}
+		if end.Valid {
+			a.End = &end.Time
+		}
+		if err := json.Unmarshal([]byte(tags), &a.Tags); err != nil {
+			return nil, err
+		}
+		annotations = append(annotations, a)
+	}
+	return annotations, rows.Err()
+}
+
+// nullableTime returns t in UTC, or nil to store NULL
+func nullableTime(t *time.Time) interface{} {
+	if t == nil {
+		return nil
+	}
+	return t.UTC()
+}
+
+// GET/POST/PUT/DELETE /api/timeline/annotations - deploys, maintenance and
+// incidents on the timeline (changes admin only). The author recorded is the
+// caller's credential.
+func timelineAnnotationsHandlerDB(w http.ResponseWriter, r *http.Request, db *Database) {
+	enableCORS(w)
+	w.Header().Set("Content-Type", "application/json")
+	// Incident and deploy markers are evidence, so only admins may add,
+	// change or remove them
+	if r.Method != http.MethodGet && !db.requireAdmin(w, r) {
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		q := r.URL.Query()
+		filter := TimelineAnnotationFilter{Kind: q.Get("kind"), Tag: q.Get("tag"), Limit: 100}
+		if l, err := strconv.Atoi(q.Get("limit")); err == nil && l > 0 && l <= 1000 {
+			filter.Limit = l
+		}
+		if filter.Kind != "" && !slices.Contains(annotationKinds, filter.Kind) {
+			writeJSONError(w, http.StatusBadRequest, "kind must be one of deploy, maintenance, incident, other")
+			return
+		}
+		if q.Has("from") || q.Has("to") || q.Has("since") || q.Has("range") {
+			from, to, err := parseTimeRange(r, db.now(), 24*time.Hour)
+			if err != nil {
+				writeJSONError(w, http.StatusBadRequest, err.Error())
+				return
+			}
+			filter.From, filter.To = from, to
+		}
+		annotations, err := db.GetTimelineAnnotations(filter)
+		if err != nil {
+			writeJSONError(w, http.StatusInternalServerError, "Failed to fetch timeline annotations")
+			return
+		}
+		json.NewEncoder(w).Encode(annotations)
+	case http.MethodPost:
+		var a TimelineAnnotation
+		if err := json.NewDecoder(r.Body).Decode(&a); err != nil {
+			writeJSONError(w, http.StatusBadRequest, "Invalid JSON")
+			return
+		}
+		a.normalize(db.now())
+		if err := a.validate(); err != nil {
+			writeJSONError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		a.Author = db.requestActor(r)
+		a, err := db.CreateTimelineAnnotation(a)
+		if err != nil {
+			writeJSONError(w, http.StatusInternalServerError, "Failed to create timeline annotation")
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(a)
+	case http.MethodPut:
+		id, err := strconv.ParseInt(r.URL.Query().Get("id"), 10, 64)
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, "Invalid id")
+			return
+		}
+		a, err := db.GetTimelineAnnotation(id)
+		if errors.Is(err, sql.ErrNoRows) {
+			writeJSONError(w, http.StatusNotFound, "Timeline annotation not found")
+			return
+		} else if err != nil {
+			writeJSONError(w, http.StatusInternalServerError, "Failed to fetch timeline annotation")
+			return
+		}
+		// Fields left out keep their values, so {"end": ...} ends an incident
+		if err := json.NewDecoder(r.Body).Decode(&a); err != nil {
+			writeJSONError(w, http.StatusBadRequest, "Invalid JSON")
+			return
+		}
+		a.ID = id
+		a.normalize(db.now())
+		if err := a.validate(); err != nil {
+			writeJSONError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		a, err = db.UpdateTimelineAnnotation(a)
+		if errors.Is(err, sql.ErrNoRows) {
+			writeJSONError(w, http.StatusNotFound, "Timeline annotation not found")
+			return
+		} else if err != nil {
+			writeJSONError(w, http.StatusInternalServerError, "Failed to update timeline annotation")
+			return
+		}
+		json.NewEncoder(w).Encode(a)
+	case http.MethodDelete:
+		id, err := strconv.ParseInt(r.URL.Query().Get("id"), 10, 64)
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, "Invalid id")
+			return
+		}
+		if err := db.DeleteTimelineAnnotation(id); errors.Is(err, sql.ErrNoRows) {
+			writeJSONError(w, http.StatusNotFound, "Timeline annotation not found")
+			return
+		} else if err != nil {
+			writeJSONError(w, http.StatusInternalServerError, "Failed to delete timeline annotation")
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+	}
+}
@@ -0,0 +1,37 @@
+package logserver
+
+import (
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// Anyone can read timeline annotations, but only admins change them, and
+// the author is the credential that posted one rather than what it claims
+func TestE2ETimelineAnnotationsAuth(t *testing.T) {
+	h := newHarness(t)
+	deploy := TimelineAnnotation{Kind: annotationDeploy, Label: "deploy v2.3.1", Author: "mallory"}
+	expect(t, "anonymous post", h.anonymous(http.MethodPost, "/api/timeline/annotations", deploy), http.StatusForbidden)
+
+	var created TimelineAnnotation
+	h.do(http.MethodPost, "/api/timeline/annotations", deploy, &created)
+	expect(t, "author", created.Author, "harness")
+	expect(t, "start", created.Start, harnessStart)
+
+	path := "/api/timeline/annotations?id=" + strconv.FormatInt(created.ID, 10)
+	end := harnessStart.Add(time.Hour)
+	expect(t, "anonymous put", h.anonymous(http.MethodPut, path, TimelineAnnotation{End: &end}), http.StatusForbidden)
+	expect(t, "anonymous delete", h.anonymous(http.MethodDelete, path, nil), http.StatusForbidden)
+	expect(t, "anonymous get", h.anonymous(http.MethodGet, "/api/timeline/annotations", nil), http.StatusOK)
+
+	var listed []TimelineAnnotation
+	h.do(http.MethodGet, "/api/timeline/annotations", nil, &listed)
+	expect(t, "annotations after anonymous changes", listed, []TimelineAnnotation{created})
+
+	var updated TimelineAnnotation
+	h.do(http.MethodPut, path, map[string]interface{}{"end": end, "author": "mallory"}, &updated)
+	expect(t, "updated end", *updated.End, end)
+	expect(t, "updated author", updated.Author, "harness")
+	expect(t, "delete", h.do(http.MethodDelete, path, nil, nil), http.StatusNoContent)
+}
@@ -0,0 +1,118 @@
+package logserver
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+	// Embed the zone database so tz= works in minimal containers
+	_ "time/tzdata"
+)
+
+// relativeRanges resolve a named range in the requester's location; to is
+// now for ranges that are still in progress
+var relativeRanges = map[string]func(now time.Time) (time.Time, time.Time){
+	"today": func(now time.Time) (time.Time, time.Time) {
+		return startOfDay(now), now
+	},
+	"yesterday": func(now time.Time) (time.Time, time.Time) {
+		today := startOfDay(now)
+		return today.AddDate(0, 0, -1), today
+	},
+	"this_week": func(now time.Time) (time.Time, time.Time) {
+		return startOfWeek(now), now
+	},
+	"last_week": func(now time.Time) (time.Time, time.Time) {
+		week := startOfWeek(now)
+		return week.AddDate(0, 0, -7), week
+	},
+	"this_month": func(now time.Time) (time.Time, time.Time) {
+		return startOfMonth(now), now
+	},
+	"last_month": func(now time.Time) (time.Time, time.Time) {
+		month := startOfMonth(now)
+		return month.AddDate(0, -1, 0), month
+	},
+}
+
+func startOfDay(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+}
+
+// startOfWeek returns the most recent Monday midnight
+func startOfWeek(t time.Time) time.Time {
+	return startOfDay(t).AddDate(0, 0, -(int(t.Weekday())+6)%7)
+}
+
+func startOfMonth(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, t.Location())
+}
+
+// parseRelativeDuration accepts Go durations plus whole days ("7d") and weeks ("2w")
+func parseRelativeDuration(value string) (time.Duration, error) {
+	var d time.Duration
+	var err error
+	switch {
+	case strings.HasSuffix(value, "d"), strings.HasSuffix(value, "w"):
+		var n int
+		n, err = strconv.Atoi(value[:len(value)-1])
+		d = time.Duration(n) * 24 * time.Hour
+		if strings.HasSuffix(value, "w") {
+			d *= 7
+		}
+	default:
+		d, err = time.ParseDuration(value)
+	}
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("Invalid 'since' duration %q", value)
+	}
+	return d, nil
+}
+
+// requestLocation returns the tz= location, default UTC
+func requestLocation(q url.Values) (*time.Location, error) {
+	tz := q.Get("tz")
+	if tz == "" {
+		return time.UTC, nil
+	}
+	loc, err := time.LoadLocation(tz)
+	if err != nil {
+		return nil, fmt.Errorf("Unknown timezone %q", tz)
+	}
+	return loc, nil
+}
+
+// relativeRange resolves since= or range= (in the tz= location, default UTC)
+// against now. ok is false when neither is present.
+func relativeRange(q url.Values, now time.Time) (from, to time.Time, ok bool, err error) {
+	since, named := q.Get("since"), q.Get("range")
+	if since == "" && named == "" {
+		return from, to, false, nil
+	}
+	if since != "" && named != "" {
+		return from, to, false, errors.New("Use either 'since' or 'range', not both")
+	}
+	if q.Get("from") != "" || q.Get("to") != "" {
+		return from, to, false, errors.New("'since' and 'range' cannot be combined with 'from' or 'to'")
+	}
+	loc, err := requestLocation(q)
+	if err != nil {
+		return from, to, false, err
+	}
+	now = now.In(loc)
+	if since != "" {
+		d, err := parseRelativeDuration(since)
+		if err != nil {
+			return from, to, false, err
+		}
+		return now.Add(-d), now, true, nil
+	}
+	resolve, known := relativeRanges[named]
+	if !known {
+		return from, to, false, fmt.Errorf("Unknown range %q", named)
+	}
+	from, to = resolve(now)
+	return from, to, true, nil
+}
@@ -0,0 +1,93 @@
+package logserver
+
+import (
+	"sort"
+	"sync"
+)
+
+// topKCapacity is the number of counters kept per tracker; it must be well
+// above the 10 rows the dashboard shows so the reported order is stable
+const topKCapacity = 200
+
+// topKExactThreshold is the row count below which top-N queries run exactly
+// against SQLite instead of reading from the streaming trackers
+const topKExactThreshold = 50000
+
+// topKCounter is a monitored key in the space-saving algorithm. Err is the
+// maximum overestimation inherited from the key it evicted.
+type topKCounter struct {
+	Key   string
+	Count int
+	Err   int
+}
+
+// SpaceSaving tracks approximate heavy hitters in a fixed amount of memory
+type SpaceSaving struct {
+	mu       sync.Mutex
+	capacity int
+	counters map[string]*topKCounter
+}
+
+func NewSpaceSaving(capacity int) *SpaceSaving {
+	return &SpaceSaving{
+		capacity: capacity,
+		counters: make(map[string]*topKCounter, capacity),
+	}
+}
+
+// Add increments key by n, evicting the smallest counter when full
+func (s *SpaceSaving) Add(key string, n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if c, ok := s.counters[key]; ok {
+		c.Count += n
+		return
+	}
+	if len(s.counters) < s.capacity {
+		s.counters[key] = &topKCounter{Key: key, Count: n}
+		return
+	}
+	var min *topKCounter
+	for _, c := range s.counters {
+		if min == nil || c.Count < min.Count {
+			min = c
+		}
+	}
+	delete(s.counters, min.Key)
+	s.counters[key] = &topKCounter{Key: key, Count: min.Count + n, Err: min.Count}
+}
+
+// Remove takes n off key when it is tracked, such as when its logs are
+// deleted, and stops tracking it once nothing is left
+func (s *SpaceSaving) Remove(key string, n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c, ok := s.counters[key]
+	if !ok {
+		return
+	}
+	c.Count -= n
+	if c.Count <= 0 {
+		delete(s.counters, key)
+	}
+}
+
+// Top returns the k keys with the highest estimated counts
+func (s *SpaceSaving) Top(k int) []topKCounter {
+	s.mu.Lock()
+	result := make([]topKCounter, 0, len(s.counters))
+	for _, c := range s.counters {
+		result = append(result, *c)
+	}
+	s.mu.Unlock()
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Count != result[j].Count {
+			return result[i].Count > result[j].Count
+		}
+		return result[i].Key < result[j].Key
+	})
+	if len(result) > k {
+		result = result[:k]
+	}
+	return result
+}
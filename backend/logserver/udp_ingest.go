@@ -0,0 +1,179 @@
+package logserver
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"log"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+)
+
+// UDP ingest. Clients that cannot afford a connection per log, such as game
+// servers and embedded devices, send each log as one JSON object in one
+// datagram. Delivery is best effort: nothing is acknowledged, and datagrams
+// that are too large, do not parse or find the queue full are dropped and
+// counted on /metrics. The reader never waits on the database; one worker
+// stores what it queues.
+
+var (
+	// udpIngestAddr is where Server listens for datagrams when
+	// Config.UDPAddr is not set; empty turns UDP ingest off
+	udpIngestAddr = os.Getenv("UDP_INGEST_ADDR")
+	// udpMaxDatagram is the largest datagram accepted, in bytes
+	udpMaxDatagram = min(envInt("UDP_INGEST_MAX_BYTES", 8192), 65507)
+	// udpQueueSize is how many datagrams may wait for the worker
+	udpQueueSize = envInt("UDP_INGEST_QUEUE", 4096)
+)
+
+// udpReadBuffer is the socket receive buffer requested from the kernel, so
+// bursts are not dropped before the reader gets to them
+const udpReadBuffer = 4 << 20
+
+// Reasons a UDP or syslog message is dropped
+const (
+	dropOversize    = "oversize"
+	dropInvalid     = "invalid"
+	dropQueueFull   = "queue_full"
+	dropStoreFailed = "store_failed"
+)
+
+var udpDropReasons = []string{dropOversize, dropInvalid, dropQueueFull, dropStoreFailed}
+
+// udpStats counts datagrams for /metrics
+type udpStats struct {
+	received atomic.Int64
+	stored   atomic.Int64
+	dropped  dropCounter
+}
+
+// dropCounter counts the messages a listener dropped, by reason
+type dropCounter struct {
+	mu     sync.Mutex
+	counts map[string]int64
+}
+
+func (c *dropCounter) add(reason string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.counts == nil {
+		c.counts = make(map[string]int64)
+	}
+	c.counts[reason]++
+}
+
+// snapshot returns the count of each of reasons, zero included
+func (c *dropCounter) snapshot(reasons []string) map[string]int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	counts := make(map[string]int64, len(reasons))
+	for _, reason := range reasons {
+		counts[reason] = c.counts[reason]
+	}
+	return counts
+}
+
+// udpListener reads datagrams from conn and stores them in db
+type udpListener struct {
+	conn    net.PacketConn
+	db      *Database
+	packets chan []byte
+	done    chan struct{}
+}
+
+// listenUDP starts accepting datagrams on addr. Datagrams carry no ingest
+// token, so UDP ingest cannot be combined with INGEST_TOKENS_REQUIRED.
+func listenUDP(addr string, db *Database) (*udpListener, error) {
+	if ingestTokensRequired {
+		return nil, errors.New("UDP ingest cannot carry ingest tokens; unset UDP_INGEST_ADDR or INGEST_TOKENS_REQUIRED")
+	}
+	conn, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	if udp, ok := conn.(*net.UDPConn); ok {
+		udp.SetReadBuffer(udpReadBuffer)
+	}
+	l := &udpListener{
+		conn:    conn,
+		db:      db,
+		packets: make(chan []byte, udpQueueSize),
+		done:    make(chan struct{}),
+	}
+	go l.read()
+	go l.store()
+	return l, nil
+}
+
+// read queues datagrams until the connection is closed. The buffer has room
+// for one byte more than the limit, so a longer datagram shows up as a
+// full buffer rather than being silently cut.
+func (l *udpListener) read() {
+	defer close(l.packets)
+	buf := make([]byte, udpMaxDatagram+1)
+	for {
+		n, _, err := l.conn.ReadFrom(buf)
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				log.Printf("udp ingest: read failed: %v", err)
+			}
+			return
+		}
+		l.db.udpStats.received.Add(1)
+		if n > udpMaxDatagram {
+			l.db.udpStats.dropped.add(dropOversize)
+			continue
+		}
+		select {
+		case l.packets <- bytes.Clone(buf[:n]):
+		default:
+			l.db.udpStats.dropped.add(dropQueueFull)
+		}
+	}
+}
+
+// store stores queued datagrams until read stops and the queue is drained
+func (l *udpListener) store() {
+	defer close(l.done)
+	for packet := range l.packets {
+		entry, err := decodeUDPLog(packet)
+		if err == nil {
+			err = prepareLogEntry(&entry, l.db.now())
+		}
+		if err != nil {
+			l.db.udpStats.dropped.add(dropInvalid)
+			continue
+		}
+		if err := l.db.InsertLog(entry); err != nil {
+			l.db.udpStats.dropped.add(dropStoreFailed)
+			log.Printf("udp ingest: failed to store log: %v", err)
+			continue
+		}
+		l.db.udpStats.stored.Add(1)
+	}
+}
+
+// decodeUDPLog decodes a datagram holding one JSON log
+func decodeUDPLog(packet []byte) (LogEntry, error) {
+	var entry LogEntry
+	packet = bytes.TrimSpace(packet)
+	if len(packet) == 0 || packet[0] != '{' {
+		return entry, errors.New("datagram is not a JSON object")
+	}
+	if err := json.Unmarshal(packet, &entry); err != nil {
+		return entry, err
+	}
+	if storeRawPayloads {
+		entry.Raw, entry.RawFormat = packet, rawJSON
+	}
+	return entry, nil
+}
+
+// Close stops reading and waits for the queued datagrams to be stored
+func (l *udpListener) Close() error {
+	err := l.conn.Close()
+	<-l.done
+	return err
+}
@@ -0,0 +1,348 @@
+package logserver
+
+import (
+	"database/sql"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// API usage analytics. Every request is counted per endpoint (the route
+// pattern it matched), method and client in hourly rollups, with its latency
+// and whether it failed, so operators can see who uses which parts of the
+// API and plan capacity. Counts are kept in memory and written once a
+// minute, like credential last-use times.
+
+// usageRetention is how long hourly usage rollups are kept
+var usageRetention = envDuration("USAGE_RETENTION", 90*24*time.Hour)
+
+const usageFlushInterval = time.Minute
+
+// usageKey identifies one hourly rollup row
+type usageKey struct {
+	hour     int64
+	endpoint string
+	method   string
+	client   string
+}
+
+// usageCounts accumulates the requests of one rollup row
+type usageCounts struct {
+	requests     int64
+	clientErrors int64
+	serverErrors int64
+	totalMs      float64
+	maxMs        float64
+}
+
+// apiUsage holds counts not yet written to the database
+type apiUsage struct {
+	mu      sync.Mutex
+	pending map[usageKey]*usageCounts
+}
+
+// UsageRow is the usage of an endpoint by a client over a time range; the
+// grouping leaves out Endpoint and Method, or Client and KeyName
+type UsageRow struct {
+	Endpoint     string  `json:"endpoint,omitempty"`
+	Method       string  `json:"method,omitempty"`
+	Client       string  `json:"client,omitempty"`
+	KeyName      string  `json:"keyName,omitempty"`
+	Requests     int64   `json:"requests"`
+	ClientErrors int64   `json:"clientErrors"`
+	ServerErrors int64   `json:"serverErrors"`
+	ErrorRate    float64 `json:"errorRate"`
+	AvgMs        float64 `json:"avgMs"`
+	MaxMs        float64 `json:"maxMs"`
+}
+
+// UsageHour is the request and error count of one hour
+type UsageHour struct {
+	Hour     time.Time `json:"hour"`
+	Requests int64     `json:"requests"`
+	Errors   int64     `json:"errors"`
+}
+
+// UsageReport is the response of GET /api/admin/usage
+type UsageReport struct {
+	From   time.Time   `json:"from"`
+	To     time.Time   `json:"to"`
+	Rows   []UsageRow  `json:"rows"`
+	Hourly []UsageHour `json:"hourly"`
+}
+
+// usageGroups maps a group_by value to the columns it groups on
+var usageGroups = map[string][]string{
+	"endpoint_client": {"endpoint", "method", "client"},
+	"endpoint":        {"endpoint", "method"},
+	"client":          {"client"},
+}
+
+func createUsageTables(db *sql.DB) error {
+	_, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS api_usage (
+			hour DATETIME NOT NULL,
+			endpoint TEXT NOT NULL,
+			method TEXT NOT NULL,
+			client TEXT NOT NULL,
+			requests INTEGER NOT NULL,
+			client_errors INTEGER NOT NULL,
+			server_errors INTEGER NOT NULL,
+			total_ms REAL NOT NULL,
+			max_ms REAL NOT NULL,
+			PRIMARY KEY (hour, endpoint, method, client)
+		)
+	`)
+	return err
+}
+
+// usageClient names the caller for usage: "admin" for the admin token,
+// "key:<id>" for an API key, otherwise "anonymous". Unlike queryClient it
+// leaves out IP addresses, which would make a row per visitor.
+func (d *Database) usageClient(r *http.Request) string {
+	switch role, keyID := d.requestRole(r); {
+	case keyID != 0:
+		return "key:" + strconv.FormatInt(keyID, 10)
+	case role == roleAdmin:
+		return roleAdmin
+	}
+	return roleAnonymous
+}
+
+func (d *Database) recordUsage(key usageKey, status int, elapsed time.Duration) {
+	ms := float64(elapsed) / float64(time.Millisecond)
+	d.usage.mu.Lock()
+	defer d.usage.mu.Unlock()
+	if d.usage.pending == nil {
+		d.usage.pending = make(map[usageKey]*usageCounts)
+	}
+	c := d.usage.pending[key]
+	if c == nil {
+		c = &usageCounts{}
+		d.usage.pending[key] = c
+	}
+	c.requests++
+	switch {
+	case status >= 500:
+		c.serverErrors++
+	case status >= 400:
+		c.clientErrors++
+	}
+	c.totalMs += ms
+	c.maxMs = math.Max(c.maxMs, ms)
+}
+
+// statusRecorder remembers the status a handler wrote
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusRecorder) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statusRecorder) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *statusRecorder) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *statusRecorder) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// trackUsage counts every request but CORS preflights against the route
+// pattern mux matches it to
+func (d *Database) trackUsage(mux *http.ServeMux, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodOptions {
+			next.ServeHTTP(w, r)
+			return
+		}
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+		_, pattern := mux.Handler(r)
+		key := usageKey{
+			hour:     start.Unix() / 3600 * 3600,
+			endpoint: pattern,
+			method:   r.Method,
+			client:   d.usageClient(r),
+		}
+		d.recordUsage(key, rec.status, time.Since(start))
+	})
+}
+
+// flushUsage adds the pending counts to the hourly rollups
+func (d *Database) flushUsage() error {
+	d.usage.mu.Lock()
+	pending := d.usage.pending
+	d.usage.pending = nil
+	d.usage.mu.Unlock()
+	if len(pending) == 0 {
+		return nil
+	}
+	tx, err := d.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for k, c := range pending {
+		_, err := tx.Exec(`
+			INSERT INTO api_usage (hour, endpoint, method, client, requests, client_errors, server_errors, total_ms, max_ms)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+			ON CONFLICT (hour, endpoint, method, client) DO UPDATE SET
+				requests = requests + excluded.requests,
+				client_errors = client_errors + excluded.client_errors,
+				server_errors = server_errors + excluded.server_errors,
+				total_ms = total_ms + excluded.total_ms,
+				max_ms = MAX(max_ms, excluded.max_ms)
+		`, time.Unix(k.hour, 0).UTC(), k.endpoint, k.method, k.client, c.requests, c.clientErrors, c.serverErrors, c.totalMs, c.maxMs)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (d *Database) flushUsageLoop() {
+	ticker := time.NewTicker(usageFlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := d.flushUsage(); err != nil {
+				log.Printf("usage: failed to record API usage: %v", err)
+			}
+			cutoff := d.now().Add(-usageRetention).UTC()
+			if _, err := d.db.Exec(`DELETE FROM api_usage WHERE hour < ?`, cutoff); err != nil {
+				log.Printf("usage: prune failed: %v", err)
+			}
+		case <-d.done:
+			return
+		}
+	}
+}
+
+// GetUsage reports API usage in the hours overlapping [from, to), grouped
+// by a usageGroups key, busiest first, along with the hourly totals
+func (d *Database) GetUsage(from, to time.Time, groupBy string) (UsageReport, error) {
+	report := UsageReport{From: from, To: to, Rows: []UsageRow{}, Hourly: []UsageHour{}}
+	if err := d.flushUsage(); err != nil {
+		return report, err
+	}
+	start := time.Unix(from.Unix()/3600*3600, 0).UTC()
+	columns := strings.Join(usageGroups[groupBy], ", ")
+	rows, err := d.db.Query(`
+		SELECT `+columns+`, SUM(requests), SUM(client_errors), SUM(server_errors), SUM(total_ms), MAX(max_ms)
+		FROM api_usage WHERE hour >= ? AND hour < ?
+		GROUP BY `+columns, start, to.UTC())
+	if err != nil {
+		return report, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var u UsageRow
+		var totalMs float64
+		var dest []interface{}
+		for _, column := range usageGroups[groupBy] {
+			switch column {
+			case "endpoint":
+				dest = append(dest, &u.Endpoint)
+			case "method":
+				dest = append(dest, &u.Method)
+			case "client":
+				dest = append(dest, &u.Client)
+			}
+		}
+		if err := rows.Scan(append(dest, &u.Requests, &u.ClientErrors, &u.ServerErrors, &totalMs, &u.MaxMs)...); err != nil {
+			return report, err
+		}
+		u.ErrorRate = math.Round(float64(u.ClientErrors+u.ServerErrors)/float64(u.Requests)*1000) / 1000
+		u.AvgMs = math.Round(totalMs/float64(u.Requests)*10) / 10
+		u.MaxMs = math.Round(u.MaxMs*10) / 10
+		report.Rows = append(report.Rows, u)
+	}
+	if err := rows.Err(); err != nil {
+		return report, err
+	}
+	for i, u := range report.Rows {
+		if id, ok := strings.CutPrefix(u.Client, "key:"); ok {
+			if n, err := strconv.ParseInt(id, 10, 64); err == nil {
+				if k, err := d.GetAPIKey(n); err == nil {
+					report.Rows[i].KeyName = k.Name
+				}
+			}
+		}
+	}
+	sort.SliceStable(report.Rows, func(i, j int) bool { return report.Rows[i].Requests > report.Rows[j].Requests })
+
+	hours, err := d.db.Query(`
+		SELECT hour, SUM(requests), SUM(client_errors + server_errors) FROM api_usage
+		WHERE hour >= ? AND hour < ? GROUP BY hour ORDER BY hour
+	`, start, to.UTC())
+	if err != nil {
+		return report, err
+	}
+	defer hours.Close()
+	for hours.Next() {
+		var h UsageHour
+		if err := hours.Scan(&h.Hour, &h.Requests, &h.Errors); err != nil {
+			return report, err
+		}
+		report.Hourly = append(report.Hourly, h)
+	}
+	return report, hours.Err()
+}
+
+// GET /api/admin/usage?since=7d&group_by=endpoint_client|endpoint|client -
+// API requests, error rates and latencies per endpoint and client (admin only)
+func usageHandlerDB(w http.ResponseWriter, r *http.Request, db *Database) {
+	enableCORS(w)
+	w.Header().Set("Content-Type", "application/json")
+	if !db.requireAdmin(w, r) {
+		return
+	}
+	if r.Method != http.MethodGet {
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	from, to, err := parseTimeRange(r, db.now(), 24*time.Hour)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	groupBy := r.URL.Query().Get("group_by")
+	if groupBy == "" {
+		groupBy = "endpoint_client"
+	}
+	if _, ok := usageGroups[groupBy]; !ok {
+		writeJSONError(w, http.StatusBadRequest, "group_by must be endpoint_client, endpoint or client")
+		return
+	}
+	report, err := db.GetUsage(from, to, groupBy)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "Failed to fetch usage")
+		return
+	}
+	writeEncoded(w, r, report)
+}
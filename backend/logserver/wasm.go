@@ -0,0 +1,1031 @@
+package logserver
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+)
+
+// A small WebAssembly interpreter for user-defined ingest processors, so the
+// server can run uploaded modules without a WASM runtime dependency. It
+// covers the MVP instruction set plus sign extension, saturating truncation
+// and memory.copy/fill, which current compilers emit by default. Modules
+// are checked for structure when they are loaded; operand types are not
+// validated, so a module that gets them wrong traps when it runs rather
+// than being rejected up front.
+
+// WASM value types
+const (
+	wasmI32     = 0x7f
+	wasmI64     = 0x7e
+	wasmF32     = 0x7d
+	wasmF64     = 0x7c
+	wasmFuncRef = 0x70
+)
+
+// Section IDs
+const (
+	wasmSectionCustom    = 0
+	wasmSectionType      = 1
+	wasmSectionImport    = 2
+	wasmSectionFunction  = 3
+	wasmSectionTable     = 4
+	wasmSectionMemory    = 5
+	wasmSectionGlobal    = 6
+	wasmSectionExport    = 7
+	wasmSectionStart     = 8
+	wasmSectionElement   = 9
+	wasmSectionCode      = 10
+	wasmSectionData      = 11
+	wasmSectionDataCount = 12
+)
+
+// Export kinds
+const (
+	wasmExternFunc   = 0
+	wasmExternTable  = 1
+	wasmExternMemory = 2
+	wasmExternGlobal = 3
+)
+
+const (
+	wasmPageSize = 65536
+	// wasmMaxTable bounds a table so a declared size cannot exhaust memory
+	wasmMaxTable = 1 << 16
+	// wasmMaxLocals bounds the locals of one function
+	wasmMaxLocals = 1 << 14
+)
+
+// Opcodes that take the 0xFC prefix are numbered wasmOpPrefixFC + sub-opcode
+const wasmOpPrefixFC = 0x100
+
+const (
+	wasmOpUnreachable  = 0x00
+	wasmOpNop          = 0x01
+	wasmOpBlock        = 0x02
+	wasmOpLoop         = 0x03
+	wasmOpIf           = 0x04
+	wasmOpElse         = 0x05
+	wasmOpEnd          = 0x0b
+	wasmOpBr           = 0x0c
+	wasmOpBrIf         = 0x0d
+	wasmOpBrTable      = 0x0e
+	wasmOpReturn       = 0x0f
+	wasmOpCall         = 0x10
+	wasmOpCallIndirect = 0x11
+	wasmOpDrop         = 0x1a
+	wasmOpSelect       = 0x1b
+	wasmOpSelectT      = 0x1c
+	wasmOpLocalGet     = 0x20
+	wasmOpLocalSet     = 0x21
+	wasmOpLocalTee     = 0x22
+	wasmOpGlobalGet    = 0x23
+	wasmOpGlobalSet    = 0x24
+	wasmOpMemorySize   = 0x3f
+	wasmOpMemoryGrow   = 0x40
+	wasmOpI32Const     = 0x41
+	wasmOpI64Const     = 0x42
+	wasmOpF32Const     = 0x43
+	wasmOpF64Const     = 0x44
+	wasmOpRefNull      = 0xd0
+	wasmOpRefFunc      = 0xd2
+	wasmOpMemoryCopy   = wasmOpPrefixFC + 10
+	wasmOpMemoryFill   = wasmOpPrefixFC + 11
+)
+
+var errWasmTruncated = errors.New("truncated module")
+
+type wasmFuncType struct {
+	params, results []byte
+}
+
+func (t wasmFuncType) equal(o wasmFuncType) bool {
+	return string(t.params) == string(o.params) && string(t.results) == string(o.results)
+}
+
+func (t wasmFuncType) String() string {
+	names := func(types []byte) string {
+		s := ""
+		for i, v := range types {
+			if i > 0 {
+				s += " "
+			}
+			s += map[byte]string{wasmI32: "i32", wasmI64: "i64", wasmF32: "f32", wasmF64: "f64"}[v]
+		}
+		return s
+	}
+	return "(" + names(t.params) + ") -> (" + names(t.results) + ")"
+}
+
+// wasmInstr is one decoded instruction. Blocks carry their arity in a and
+// b and the index of their end in c, and an if the index of its else in
+// imm; br_table's targets are in the function's brTables at [a, a+b).
+type wasmInstr struct {
+	op   uint16
+	a, b uint32
+	c    uint32
+	imm  uint64
+}
+
+type wasmFunc struct {
+	typ      uint32
+	locals   int
+	code     []wasmInstr
+	brTables []uint32
+	// host is set for imported functions
+	host *wasmHostFunc
+}
+
+type wasmGlobal struct {
+	typ     byte
+	mutable bool
+	init    uint64
+}
+
+type wasmExport struct {
+	kind  byte
+	index uint32
+}
+
+type wasmSegment struct {
+	offset uint32
+	funcs  []uint32
+	data   []byte
+}
+
+// wasmModule is a decoded module, shared by all of its instances
+type wasmModule struct {
+	types     []wasmFuncType
+	funcs     []wasmFunc
+	hasTable  bool
+	tableMin  uint32
+	hasMemory bool
+	memMin    uint32
+	// memMax is 0 when the module sets no maximum
+	memMax  uint32
+	globals []wasmGlobal
+	exports map[string]wasmExport
+	start   int64
+	elems   []wasmSegment
+	data    []wasmSegment
+}
+
+// wasmReader reads the binary format
+type wasmReader struct {
+	buf []byte
+}
+
+func (r *wasmReader) byte() (byte, error) {
+	if len(r.buf) == 0 {
+		return 0, errWasmTruncated
+	}
+	b := r.buf[0]
+	r.buf = r.buf[1:]
+	return b, nil
+}
+
+func (r *wasmReader) bytes(n uint32) ([]byte, error) {
+	if uint64(n) > uint64(len(r.buf)) {
+		return nil, errWasmTruncated
+	}
+	b := r.buf[:n]
+	r.buf = r.buf[n:]
+	return b, nil
+}
+
+func (r *wasmReader) u32() (uint32, error) {
+	var v uint64
+	for shift := uint(0); shift < 35; shift += 7 {
+		b, err := r.byte()
+		if err != nil {
+			return 0, err
+		}
+		v |= uint64(b&0x7f) << shift
+		if b&0x80 == 0 {
+			if v > math.MaxUint32 {
+				return 0, errors.New("integer too large")
+			}
+			return uint32(v), nil
+		}
+	}
+	return 0, errors.New("integer representation too long")
+}
+
+// signed reads a signed LEB128 of at most bits bits
+func (r *wasmReader) signed(bits uint) (int64, error) {
+	var v int64
+	var shift uint
+	for {
+		b, err := r.byte()
+		if err != nil {
+			return 0, err
+		}
+		if shift >= bits+7 {
+			return 0, errors.New("integer representation too long")
+		}
+		v |= int64(b&0x7f) << shift
+		shift += 7
+		if b&0x80 == 0 {
+			if shift < 64 && b&0x40 != 0 {
+				v |= -1 << shift
+			}
+			return v, nil
+		}
+	}
+}
+
+func (r *wasmReader) name() (string, error) {
+	n, err := r.u32()
+	if err != nil {
+		return "", err
+	}
+	b, err := r.bytes(n)
+	return string(b), err
+}
+
+func (r *wasmReader) valType() (byte, error) {
+	t, err := r.byte()
+	if err != nil {
+		return 0, err
+	}
+	switch t {
+	case wasmI32, wasmI64, wasmF32, wasmF64:
+		return t, nil
+	}
+	return 0, fmt.Errorf("unsupported value type 0x%02x", t)
+}
+
+// limits reads a table or memory's limits; max is 0 when there is none
+func (r *wasmReader) limits() (min, max uint32, err error) {
+	flag, err := r.byte()
+	if err != nil {
+		return 0, 0, err
+	}
+	if flag > 1 {
+		return 0, 0, fmt.Errorf("unsupported limits flag 0x%02x", flag)
+	}
+	if min, err = r.u32(); err != nil || flag == 0 {
+		return min, 0, err
+	}
+	if max, err = r.u32(); err == nil && max < min {
+		err = errors.New("limits maximum below minimum")
+	}
+	return min, max, err
+}
+
+// decodeWasmModule decodes and checks a module, linking its function
+// imports against host
+func decodeWasmModule(b []byte, host map[string]*wasmHostFunc) (*wasmModule, error) {
+	if len(b) < 8 || string(b[:4]) != "\x00asm" {
+		return nil, errors.New("not a WebAssembly module")
+	}
+	if binary.LittleEndian.Uint32(b[4:8]) != 1 {
+		return nil, errors.New("unsupported WebAssembly version")
+	}
+	m := &wasmModule{exports: map[string]wasmExport{}, start: -1}
+	r := &wasmReader{buf: b[8:]}
+	var declared []uint32
+	var last byte
+	for len(r.buf) > 0 {
+		id, err := r.byte()
+		if err != nil {
+			return nil, err
+		}
+		size, err := r.u32()
+		if err != nil {
+			return nil, err
+		}
+		body, err := r.bytes(size)
+		if err != nil {
+			return nil, err
+		}
+		if id != wasmSectionCustom {
+			// The data count section sits between the element and code sections
+			order := id
+			if id == wasmSectionDataCount {
+				order = wasmSectionElement + 1
+			} else if id >= wasmSectionCode {
+				order = id + 1
+			}
+			if order <= last {
+				return nil, fmt.Errorf("section %d out of order", id)
+			}
+			last = order
+		}
+		s := &wasmReader{buf: body}
+		switch id {
+		case wasmSectionCustom, wasmSectionDataCount:
+			continue
+		case wasmSectionType:
+			err = m.decodeTypes(s)
+		case wasmSectionImport:
+			err = m.decodeImports(s, host)
+		case wasmSectionFunction:
+			declared, err = decodeU32s(s)
+		case wasmSectionTable:
+			err = m.decodeTables(s)
+		case wasmSectionMemory:
+			err = m.decodeMemories(s)
+		case wasmSectionGlobal:
+			err = m.decodeGlobals(s)
+		case wasmSectionExport:
+			err = m.decodeExports(s, len(declared))
+		case wasmSectionStart:
+			var start uint32
+			if start, err = s.u32(); err == nil {
+				m.start = int64(start)
+			}
+		case wasmSectionElement:
+			err = m.decodeElements(s, len(declared))
+		case wasmSectionCode:
+			err = m.decodeCode(s, declared)
+		case wasmSectionData:
+			err = m.decodeData(s)
+		default:
+			err = fmt.Errorf("unknown section %d", id)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("section %d: %w", id, err)
+		}
+		if len(s.buf) > 0 {
+			return nil, fmt.Errorf("section %d: trailing bytes", id)
+		}
+	}
+	if len(declared) > 0 && len(m.funcs) == m.imports() {
+		return nil, errors.New("function section without code section")
+	}
+	if m.start >= 0 {
+		if m.start >= int64(len(m.funcs)) {
+			return nil, errors.New("start function out of range")
+		}
+		if t := m.types[m.funcs[m.start].typ]; len(t.params) > 0 || len(t.results) > 0 {
+			return nil, errors.New("start function must take and return nothing")
+		}
+	}
+	return m, nil
+}
+
+// imports counts the imported functions, which come first in funcs
+func (m *wasmModule) imports() int {
+	n := 0
+	for _, f := range m.funcs {
+		if f.host != nil {
+			n++
+		}
+	}
+	return n
+}
+
+func decodeU32s(r *wasmReader) ([]uint32, error) {
+	n, err := r.u32()
+	if err != nil {
+		return nil, err
+	}
+	if uint64(n) > uint64(len(r.buf)) {
+		return nil, errWasmTruncated
+	}
+	out := make([]uint32, n)
+	for i := range out {
+		if out[i], err = r.u32(); err != nil {
+			return nil, err
+		}
+	}
+	return out, nil
+}
+
+func (m *wasmModule) decodeTypes(r *wasmReader) error {
+	n, err := r.u32()
+	if err != nil {
+		return err
+	}
+	for i := uint32(0); i < n; i++ {
+		form, err := r.byte()
+		if err != nil {
+			return err
+		}
+		if form != 0x60 {
+			return fmt.Errorf("unsupported type form 0x%02x", form)
+		}
+		var t wasmFuncType
+		for _, list := range []*[]byte{&t.params, &t.results} {
+			count, err := r.u32()
+			if err != nil {
+				return err
+			}
+			if uint64(count) > uint64(len(r.buf)) {
+				return errWasmTruncated
+			}
+			*list = make([]byte, count)
+			for j := range *list {
+				if (*list)[j], err = r.valType(); err != nil {
+					return err
+				}
+			}
+		}
+		m.types = append(m.types, t)
+	}
+	return nil
+}
+
+func (m *wasmModule) decodeImports(r *wasmReader, host map[string]*wasmHostFunc) error {
+	n, err := r.u32()
+	if err != nil {
+		return err
+	}
+	for i := uint32(0); i < n; i++ {
+		module, err := r.name()
+		if err != nil {
+			return err
+		}
+		name, err := r.name()
+		if err != nil {
+			return err
+		}
+		kind, err := r.byte()
+		if err != nil {
+			return err
+		}
+		if kind != wasmExternFunc {
+			return fmt.Errorf("import %s.%s: only functions can be imported", module, name)
+		}
+		typ, err := r.u32()
+		if err != nil {
+			return err
+		}
+		if typ >= uint32(len(m.types)) {
+			return fmt.Errorf("import %s.%s: type out of range", module, name)
+		}
+		f := host[module+"."+name]
+		if f == nil {
+			return fmt.Errorf("unknown import %s.%s", module, name)
+		}
+		if !m.types[typ].equal(f.typ) {
+			return fmt.Errorf("import %s.%s: type %v, want %v", module, name, m.types[typ], f.typ)
+		}
+		m.funcs = append(m.funcs, wasmFunc{typ: typ, host: f})
+	}
+	return nil
+}
+
+func (m *wasmModule) decodeTables(r *wasmReader) error {
+	n, err := r.u32()
+	if err != nil {
+		return err
+	}
+	if n > 1 {
+		return errors.New("at most one table is supported")
+	}
+	if n == 1 {
+		elem, err := r.byte()
+		if err != nil {
+			return err
+		}
+		if elem != wasmFuncRef {
+			return errors.New("only funcref tables are supported")
+		}
+		if m.tableMin, _, err = r.limits(); err != nil {
+			return err
+		}
+		if m.tableMin > wasmMaxTable {
+			return fmt.Errorf("table of %d elements is over the limit of %d", m.tableMin, wasmMaxTable)
+		}
+		m.hasTable = true
+	}
+	return nil
+}
+
+func (m *wasmModule) decodeMemories(r *wasmReader) error {
+	n, err := r.u32()
+	if err != nil {
+		return err
+	}
+	if n > 1 {
+		return errors.New("at most one memory is supported")
+	}
+	if n == 1 {
+		if m.memMin, m.memMax, err = r.limits(); err != nil {
+			return err
+		}
+		if m.memMin > 65536 || m.memMax > 65536 {
+			return errors.New("memory over 4 GiB")
+		}
+		m.hasMemory = true
+	}
+	return nil
+}
+
+// constExpr evaluates an initializer: a constant or an earlier global
+func (m *wasmModule) constExpr(r *wasmReader) (uint64, error) {
+	op, err := r.byte()
+	if err != nil {
+		return 0, err
+	}
+	var v uint64
+	switch op {
+	case wasmOpI32Const:
+		n, err := r.signed(32)
+		if err != nil {
+			return 0, err
+		}
+		v = uint64(uint32(n))
+	case wasmOpI64Const:
+		n, err := r.signed(64)
+		if err != nil {
+			return 0, err
+		}
+		v = uint64(n)
+	case wasmOpF32Const:
+		b, err := r.bytes(4)
+		if err != nil {
+			return 0, err
+		}
+		v = uint64(binary.LittleEndian.Uint32(b))
+	case wasmOpF64Const:
+		b, err := r.bytes(8)
+		if err != nil {
+			return 0, err
+		}
+		v = binary.LittleEndian.Uint64(b)
+	case wasmOpGlobalGet:
+		i, err := r.u32()
+		if err != nil {
+			return 0, err
+		}
+		if i >= uint32(len(m.globals)) {
+			return 0, errors.New("global out of range")
+		}
+		v = m.globals[i].init
+	default:
+		return 0, fmt.Errorf("unsupported constant expression 0x%02x", op)
+	}
+	if end, err := r.byte(); err != nil || end != wasmOpEnd {
+		return 0, errors.New("constant expression not terminated")
+	}
+	return v, nil
+}
+
+func (m *wasmModule) decodeGlobals(r *wasmReader) error {
+	n, err := r.u32()
+	if err != nil {
+		return err
+	}
+	for i := uint32(0); i < n; i++ {
+		var g wasmGlobal
+		if g.typ, err = r.valType(); err != nil {
+			return err
+		}
+		mut, err := r.byte()
+		if err != nil {
+			return err
+		}
+		g.mutable = mut == 1
+		if g.init, err = m.constExpr(r); err != nil {
+			return err
+		}
+		m.globals = append(m.globals, g)
+	}
+	return nil
+}
+
+func (m *wasmModule) decodeExports(r *wasmReader, declared int) error {
+	n, err := r.u32()
+	if err != nil {
+		return err
+	}
+	for i := uint32(0); i < n; i++ {
+		name, err := r.name()
+		if err != nil {
+			return err
+		}
+		var e wasmExport
+		if e.kind, err = r.byte(); err != nil {
+			return err
+		}
+		if e.index, err = r.u32(); err != nil {
+			return err
+		}
+		var count int
+		switch e.kind {
+		case wasmExternFunc:
+			count = len(m.funcs) + declared
+		case wasmExternTable:
+			count = btoi(m.hasTable)
+		case wasmExternMemory:
+			count = btoi(m.hasMemory)
+		case wasmExternGlobal:
+			count = len(m.globals)
+		default:
+			return fmt.Errorf("export %q: unknown kind %d", name, e.kind)
+		}
+		if int64(e.index) >= int64(count) {
+			return fmt.Errorf("export %q out of range", name)
+		}
+		if _, dup := m.exports[name]; dup {
+			return fmt.Errorf("duplicate export %q", name)
+		}
+		m.exports[name] = e
+	}
+	return nil
+}
+
+func btoi(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// decodeElements keeps the active segments for table 0; passive and
+// declarative segments only matter to instructions this interpreter does
+// not run
+func (m *wasmModule) decodeElements(r *wasmReader, declared int) error {
+	n, err := r.u32()
+	if err != nil {
+		return err
+	}
+	total := uint32(len(m.funcs) + declared)
+	for i := uint32(0); i < n; i++ {
+		flags, err := r.u32()
+		if err != nil {
+			return err
+		}
+		if flags > 7 {
+			return fmt.Errorf("unknown element segment flags %d", flags)
+		}
+		active := flags&1 == 0
+		var seg wasmSegment
+		if active {
+			if flags&2 != 0 {
+				table, err := r.u32()
+				if err != nil {
+					return err
+				}
+				if table != 0 {
+					return errors.New("element segment for a table other than 0")
+				}
+			}
+			offset, err := m.constExpr(r)
+			if err != nil {
+				return err
+			}
+			seg.offset = uint32(offset)
+		}
+		if flags&3 != 0 {
+			// elemkind or reftype, always funcref here
+			if _, err := r.byte(); err != nil {
+				return err
+			}
+		}
+		count, err := r.u32()
+		if err != nil {
+			return err
+		}
+		if uint64(count) > uint64(len(r.buf)) {
+			return errWasmTruncated
+		}
+		for j := uint32(0); j < count; j++ {
+			var f uint32
+			if flags&4 == 0 {
+				if f, err = r.u32(); err != nil {
+					return err
+				}
+			} else {
+				op, err := r.byte()
+				if err != nil {
+					return err
+				}
+				switch op {
+				case wasmOpRefFunc:
+					if f, err = r.u32(); err != nil {
+						return err
+					}
+				case wasmOpRefNull:
+					if _, err := r.byte(); err != nil {
+						return err
+					}
+					f = math.MaxUint32
+				default:
+					return fmt.Errorf("unsupported element expression 0x%02x", op)
+				}
+				if end, err := r.byte(); err != nil || end != wasmOpEnd {
+					return errors.New("element expression not terminated")
+				}
+			}
+			if f != math.MaxUint32 && f >= total {
+				return errors.New("element function out of range")
+			}
+			seg.funcs = append(seg.funcs, f)
+		}
+		if active {
+			if !m.hasTable {
+				return errors.New("element segment without a table")
+			}
+			m.elems = append(m.elems, seg)
+		}
+	}
+	return nil
+}
+
+func (m *wasmModule) decodeData(r *wasmReader) error {
+	n, err := r.u32()
+	if err != nil {
+		return err
+	}
+	for i := uint32(0); i < n; i++ {
+		flags, err := r.u32()
+		if err != nil {
+			return err
+		}
+		var seg wasmSegment
+		switch flags {
+		case 0, 2:
+			if flags == 2 {
+				mem, err := r.u32()
+				if err != nil {
+					return err
+				}
+				if mem != 0 {
+					return errors.New("data segment for a memory other than 0")
+				}
+			}
+			offset, err := m.constExpr(r)
+			if err != nil {
+				return err
+			}
+			seg.offset = uint32(offset)
+		case 1:
+		default:
+			return fmt.Errorf("unknown data segment flags %d", flags)
+		}
+		size, err := r.u32()
+		if err != nil {
+			return err
+		}
+		if seg.data, err = r.bytes(size); err != nil {
+			return err
+		}
+		if flags != 1 {
+			if !m.hasMemory {
+				return errors.New("data segment without a memory")
+			}
+			m.data = append(m.data, seg)
+		}
+	}
+	return nil
+}
+
+func (m *wasmModule) decodeCode(r *wasmReader, declared []uint32) error {
+	n, err := r.u32()
+	if err != nil {
+		return err
+	}
+	if int(n) != len(declared) {
+		return errors.New("function and code section counts differ")
+	}
+	base := len(m.funcs)
+	for _, typ := range declared {
+		if typ >= uint32(len(m.types)) {
+			return errors.New("function type out of range")
+		}
+		m.funcs = append(m.funcs, wasmFunc{typ: typ})
+	}
+	for i := range declared {
+		size, err := r.u32()
+		if err != nil {
+			return err
+		}
+		body, err := r.bytes(size)
+		if err != nil {
+			return err
+		}
+		if err := m.compile(&m.funcs[base+i], &wasmReader{buf: body}); err != nil {
+			return fmt.Errorf("function %d: %w", base+i, err)
+		}
+	}
+	return nil
+}
+
+// blockType returns the params and results of a block, loop or if
+func (m *wasmModule) blockType(r *wasmReader) (uint32, uint32, error) {
+	if len(r.buf) > 0 && r.buf[0] == 0x40 {
+		r.buf = r.buf[1:]
+		return 0, 0, nil
+	}
+	if len(r.buf) > 0 && r.buf[0]&0xc0 == 0x40 {
+		if _, err := r.valType(); err != nil {
+			return 0, 0, err
+		}
+		return 0, 1, nil
+	}
+	i, err := r.signed(33)
+	if err != nil {
+		return 0, 0, err
+	}
+	if i < 0 || i >= int64(len(m.types)) {
+		return 0, 0, errors.New("block type out of range")
+	}
+	t := m.types[i]
+	return uint32(len(t.params)), uint32(len(t.results)), nil
+}
+
+// compile decodes a function body into instructions, matching each block
+// to its else and end and checking every index it uses
+func (m *wasmModule) compile(f *wasmFunc, r *wasmReader) error {
+	t := m.types[f.typ]
+	groups, err := r.u32()
+	if err != nil {
+		return err
+	}
+	locals := uint64(len(t.params))
+	for i := uint32(0); i < groups; i++ {
+		count, err := r.u32()
+		if err != nil {
+			return err
+		}
+		if _, err := r.valType(); err != nil {
+			return err
+		}
+		locals += uint64(count)
+		if locals > wasmMaxLocals {
+			return fmt.Errorf("more than %d locals", wasmMaxLocals)
+		}
+		f.locals += int(count)
+	}
+	// open holds the index of each enclosing block; -1 is the function
+	open := []int{-1}
+	for len(open) > 0 {
+		b, err := r.byte()
+		if err != nil {
+			return err
+		}
+		in := wasmInstr{op: uint16(b)}
+		switch {
+		case b == wasmOpBlock || b == wasmOpLoop || b == wasmOpIf:
+			if in.a, in.b, err = m.blockType(r); err != nil {
+				return err
+			}
+			open = append(open, len(f.code))
+		case b == wasmOpElse:
+			top := open[len(open)-1]
+			if top < 0 || f.code[top].op != wasmOpIf || f.code[top].imm != 0 {
+				return errors.New("else without if")
+			}
+			f.code[top].imm = uint64(len(f.code))
+		case b == wasmOpEnd:
+			top := open[len(open)-1]
+			open = open[:len(open)-1]
+			if top >= 0 {
+				f.code[top].c = uint32(len(f.code))
+				if e := f.code[top].imm; f.code[top].op == wasmOpIf && e != 0 {
+					f.code[e].c = uint32(len(f.code))
+				}
+			}
+		case b == wasmOpBr || b == wasmOpBrIf:
+			if in.a, err = r.u32(); err != nil {
+				return err
+			}
+			if in.a >= uint32(len(open)) {
+				return errors.New("branch depth out of range")
+			}
+		case b == wasmOpBrTable:
+			targets, err := decodeU32s(r)
+			if err != nil {
+				return err
+			}
+			def, err := r.u32()
+			if err != nil {
+				return err
+			}
+			targets = append(targets, def)
+			for _, d := range targets {
+				if d >= uint32(len(open)) {
+					return errors.New("branch depth out of range")
+				}
+			}
+			in.a, in.b = uint32(len(f.brTables)), uint32(len(targets))
+			f.brTables = append(f.brTables, targets...)
+		case b == wasmOpCall:
+			if in.a, err = r.u32(); err != nil {
+				return err
+			}
+			// Functions declared after this one are appended before any
+			// body is compiled, so the whole index space is known
+			if in.a >= uint32(len(m.funcs)) {
+				return errors.New("call target out of range")
+			}
+		case b == wasmOpCallIndirect:
+			if in.a, err = r.u32(); err != nil {
+				return err
+			}
+			if in.b, err = r.u32(); err != nil {
+				return err
+			}
+			if in.a >= uint32(len(m.types)) || in.b != 0 || !m.hasTable {
+				return errors.New("call_indirect type or table out of range")
+			}
+		case b == wasmOpSelectT:
+			types, err := decodeU32s(r)
+			if err != nil {
+				return err
+			}
+			if len(types) != 1 {
+				return errors.New("select must have one type")
+			}
+			in.op = wasmOpSelect
+		case b >= wasmOpLocalGet && b <= wasmOpLocalTee:
+			if in.a, err = r.u32(); err != nil {
+				return err
+			}
+			if uint64(in.a) >= locals {
+				return errors.New("local out of range")
+			}
+		case b == wasmOpGlobalGet || b == wasmOpGlobalSet:
+			if in.a, err = r.u32(); err != nil {
+				return err
+			}
+			if in.a >= uint32(len(m.globals)) {
+				return errors.New("global out of range")
+			}
+			if b == wasmOpGlobalSet && !m.globals[in.a].mutable {
+				return errors.New("global is immutable")
+			}
+		case b >= 0x28 && b <= 0x3e:
+			if _, err := r.u32(); err != nil { // alignment, a hint only
+				return err
+			}
+			if in.a, err = r.u32(); err != nil {
+				return err
+			}
+			if !m.hasMemory {
+				return errors.New("memory access without a memory")
+			}
+		case b == wasmOpMemorySize || b == wasmOpMemoryGrow:
+			if mem, err := r.byte(); err != nil || mem != 0 {
+				return errors.New("memory index must be 0")
+			}
+			if !m.hasMemory {
+				return errors.New("memory instruction without a memory")
+			}
+		case b == wasmOpI32Const:
+			v, err := r.signed(32)
+			if err != nil {
+				return err
+			}
+			in.imm = uint64(uint32(v))
+		case b == wasmOpI64Const:
+			v, err := r.signed(64)
+			if err != nil {
+				return err
+			}
+			in.imm = uint64(v)
+		case b == wasmOpF32Const:
+			c, err := r.bytes(4)
+			if err != nil {
+				return err
+			}
+			in.imm = uint64(binary.LittleEndian.Uint32(c))
+		case b == wasmOpF64Const:
+			c, err := r.bytes(8)
+			if err != nil {
+				return err
+			}
+			in.imm = binary.LittleEndian.Uint64(c)
+		case b == 0xfc:
+			sub, err := r.u32()
+			if err != nil {
+				return err
+			}
+			in.op = uint16(wasmOpPrefixFC + sub)
+			switch {
+			case sub <= 7:
+			case in.op == wasmOpMemoryCopy || in.op == wasmOpMemoryFill:
+				indices := 2
+				if in.op == wasmOpMemoryFill {
+					indices = 1
+				}
+				for ; indices > 0; indices-- {
+					if mem, err := r.byte(); err != nil || mem != 0 {
+						return errors.New("memory index must be 0")
+					}
+				}
+				if !m.hasMemory {
+					return errors.New("memory instruction without a memory")
+				}
+			default:
+				return fmt.Errorf("unsupported instruction 0xfc %d", sub)
+			}
+		case b <= wasmOpNop, b == wasmOpReturn, b == wasmOpDrop, b == wasmOpSelect,
+			b >= 0x45 && b <= 0xc4:
+		default:
+			return fmt.Errorf("unsupported instruction 0x%02x", b)
+		}
+		if b != wasmOpEnd || len(open) > 0 {
+			f.code = append(f.code, in)
+		}
+	}
+	if len(r.buf) > 0 {
+		return errors.New("code after the end of the function")
+	}
+	return nil
+}
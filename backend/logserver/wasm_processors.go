@@ -0,0 +1,651 @@
+package logserver
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// WASM processors are uploaded modules that see every log in the ingest
+// pipeline, after the registered Go processors. A module exports
+//
+//	process() -> i32
+//
+// which returns 0 to keep the log, 1 to drop it and anything else to fail
+// it, and reads and changes the log through the functions it imports from
+// the "logger" module (see wasmHostFuncs). Each call runs under a fuel
+// budget of instructions and a memory cap, so a module cannot stall ingest
+// or exhaust the server's memory.
+
+// WasmProcessor is a stored module and how failures are handled
+type WasmProcessor struct {
+	ID       int64  `json:"id"`
+	Name     string `json:"name"`
+	Position int    `json:"position"`
+	Enabled  bool   `json:"enabled"`
+	// OnError is what happens to a log when the module traps, runs out of
+	// fuel or fails it: keep it unchanged, drop it or reject the ingest
+	OnError string `json:"onError"`
+	// Module is the binary, sent base64 encoded on upload and not returned
+	Module    []byte             `json:"module,omitempty"`
+	SHA256    string             `json:"sha256"`
+	Size      int                `json:"size"`
+	Stats     WasmProcessorStats `json:"stats"`
+	CreatedAt time.Time          `json:"createdAt"`
+}
+
+// WasmProcessorStats counts what an enabled processor did since it was
+// loaded; disabling it or replacing its module starts the counts over
+type WasmProcessorStats struct {
+	Processed int64  `json:"processed"`
+	Dropped   int64  `json:"dropped"`
+	Failed    int64  `json:"failed"`
+	LastError string `json:"lastError,omitempty"`
+}
+
+const (
+	wasmOnErrorKeep   = "keep"
+	wasmOnErrorDrop   = "drop"
+	wasmOnErrorReject = "reject"
+)
+
+// Limits on each module
+var (
+	wasmProcessorFuel     = int64(envInt("WASM_PROCESSOR_FUEL", 1_000_000))
+	wasmProcessorMaxPages = uint32(envInt("WASM_PROCESSOR_MEMORY_PAGES", 256))
+	wasmProcessorMaxBytes = envInt("WASM_PROCESSOR_MAX_BYTES", 4<<20)
+)
+
+// wasmLogLimit truncates what a module writes with log
+const wasmLogLimit = 512
+
+// Return codes of the host functions
+const (
+	wasmFieldUnknown = -1
+	wasmFieldInvalid = -2
+)
+
+var wasmProcessFunc = wasmFuncType{results: []byte{wasmI32}}
+
+// wasmCall is the log a module is processing, which host functions read
+// and change
+type wasmCall struct {
+	processor string
+	entry     *LogEntry
+}
+
+// wasmHostFuncs is the API modules import, as "logger.<name>":
+//
+//	field_get(name_ptr, name_len, buf_ptr, buf_cap i32) -> i32
+//	field_set(name_ptr, name_len, val_ptr, val_len i32) -> i32
+//	raw_get(buf_ptr, buf_cap i32) -> i32
+//	log(ptr, len i32)
+//
+// Fields are named as in the log's JSON: level, rule, sourceIP,
+// destinationIP, event, description, user, traceId, urgency,
+// sourcePort, destinationPort, timestamp (RFC 3339) and tags (comma
+// separated). field_get and raw_get copy up to buf_cap bytes and return the
+// full length, so a module can retry with a bigger buffer. field_set
+// returns 0, -1 for an unknown field or -2 for a value the field cannot
+// take.
+var wasmHostFuncs = map[string]*wasmHostFunc{
+	"logger.field_get": {
+		typ: wasmFuncType{params: []byte{wasmI32, wasmI32, wasmI32, wasmI32}, results: []byte{wasmI32}},
+		fn: func(in *wasmInstance, args []uint64) uint64 {
+			name := string(in.memory(uint32(args[0]), 0, uint64(uint32(args[1]))))
+			value, ok := wasmFieldGet(in.host.(*wasmCall).entry, name)
+			if !ok {
+				return wasmResult(wasmFieldUnknown)
+			}
+			return uint64(in.copyOut(uint32(args[2]), uint32(args[3]), []byte(value)))
+		},
+	},
+	"logger.field_set": {
+		typ: wasmFuncType{params: []byte{wasmI32, wasmI32, wasmI32, wasmI32}, results: []byte{wasmI32}},
+		fn: func(in *wasmInstance, args []uint64) uint64 {
+			name := string(in.memory(uint32(args[0]), 0, uint64(uint32(args[1]))))
+			value := string(in.memory(uint32(args[2]), 0, uint64(uint32(args[3]))))
+			return wasmResult(wasmFieldSet(in.host.(*wasmCall).entry, name, value))
+		},
+	},
+	"logger.raw_get": {
+		typ: wasmFuncType{params: []byte{wasmI32, wasmI32}, results: []byte{wasmI32}},
+		fn: func(in *wasmInstance, args []uint64) uint64 {
+			return uint64(in.copyOut(uint32(args[0]), uint32(args[1]), in.host.(*wasmCall).entry.Raw))
+		},
+	},
+	"logger.log": {
+		typ: wasmFuncType{params: []byte{wasmI32, wasmI32}},
+		fn: func(in *wasmInstance, args []uint64) uint64 {
+			msg := in.memory(uint32(args[0]), 0, uint64(uint32(args[1])))
+			if len(msg) > wasmLogLimit {
+				msg = msg[:wasmLogLimit]
+			}
+			log.Printf("processor %s: %s", in.host.(*wasmCall).processor, msg)
+			return 0
+		},
+	},
+}
+
+// wasmResult encodes a host function's i32 result
+func wasmResult(v int32) uint64 {
+	return uint64(uint32(v))
+}
+
+// copyOut copies up to limit bytes of b to memory at ptr and returns len(b)
+func (in *wasmInstance) copyOut(ptr, limit uint32, b []byte) uint32 {
+	n := uint32(len(b))
+	if n < limit {
+		limit = n
+	}
+	copy(in.memory(ptr, 0, uint64(limit)), b)
+	return n
+}
+
+func wasmFieldGet(e *LogEntry, name string) (string, bool) {
+	switch name {
+	case "level":
+		return e.Level, true
+	case "rule":
+		return e.Rule, true
+	case "sourceIP":
+		return e.SourceIP, true
+	case "destinationIP":
+		return e.DestinationIP, true
+	case "event":
+		return e.Event, true
+	case "description":
+		return e.Description, true
+	case "user":
+		return e.User, true
+	case "traceId":
+		return e.TraceID, true
+	case "urgency":
+		return strconv.Itoa(e.Urgency), true
+	case "sourcePort":
+		return strconv.Itoa(e.SourcePort), true
+	case "destinationPort":
+		return strconv.Itoa(e.DestinationPort), true
+	case "timestamp":
+		return e.Timestamp.Format(time.RFC3339Nano), true
+	case "tags":
+		return strings.Join(e.Tags, ","), true
+	}
+	return "", false
+}
+
+func wasmFieldSet(e *LogEntry, name, value string) int32 {
+	text := map[string]*string{
+		"level":         &e.Level,
+		"rule":          &e.Rule,
+		"sourceIP":      &e.SourceIP,
+		"destinationIP": &e.DestinationIP,
+		"event":         &e.Event,
+		"description":   &e.Description,
+		"user":          &e.User,
+		"traceId":       &e.TraceID,
+	}
+	if field, ok := text[name]; ok {
+		*field = value
+		return 0
+	}
+	switch name {
+	case "urgency", "sourcePort", "destinationPort":
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return wasmFieldInvalid
+		}
+		switch name {
+		case "urgency":
+			e.Urgency = n
+		case "sourcePort", "destinationPort":
+			if !validPort(n) {
+				return wasmFieldInvalid
+			}
+			if name == "sourcePort" {
+				e.SourcePort = n
+			} else {
+				e.DestinationPort = n
+			}
+		}
+	case "timestamp":
+		t, err := time.Parse(time.RFC3339Nano, value)
+		if err != nil {
+			return wasmFieldInvalid
+		}
+		e.Timestamp = t
+	case "tags":
+		var tags []string
+		if value != "" {
+			tags = strings.Split(value, ",")
+		}
+		normalized, err := normalizeTags(tags)
+		if err != nil {
+			return wasmFieldInvalid
+		}
+		e.Tags = normalized
+	default:
+		return wasmFieldUnknown
+	}
+	return 0
+}
+
+// wasmRuntime is a loaded processor. Instances are pooled, so a module may
+// see leftover memory from an earlier log but must not rely on it.
+type wasmRuntime struct {
+	WasmProcessor
+	module  *wasmModule
+	process uint32
+	pool    sync.Pool
+
+	processed, dropped, failed atomic.Int64
+	lastError                  atomic.Value
+}
+
+// loadWasmRuntime decodes a module and checks that it can be instantiated
+// within the limits
+func loadWasmRuntime(p WasmProcessor) (*wasmRuntime, error) {
+	m, err := decodeWasmModule(p.Module, wasmHostFuncs)
+	if err != nil {
+		return nil, err
+	}
+	process, err := m.export("process", wasmProcessFunc)
+	if err != nil {
+		return nil, err
+	}
+	rt := &wasmRuntime{WasmProcessor: p, module: m, process: process}
+	in, err := rt.instance()
+	if err != nil {
+		return nil, err
+	}
+	rt.pool.Put(in)
+	return rt, nil
+}
+
+func (rt *wasmRuntime) instance() (*wasmInstance, error) {
+	if in, ok := rt.pool.Get().(*wasmInstance); ok {
+		return in, nil
+	}
+	in, err := rt.module.instantiate(wasmProcessorMaxPages, wasmProcessorFuel)
+	if err != nil {
+		return nil, err
+	}
+	return in, nil
+}
+
+// run calls the module's process on entry. It returns the module's result
+// and the fuel used; entry is left as the module changed it even when the
+// call fails.
+func (rt *wasmRuntime) run(entry *LogEntry) (int32, int64, error) {
+	in, err := rt.instance()
+	if err != nil {
+		return 0, 0, err
+	}
+	in.host = &wasmCall{processor: rt.WasmProcessor.Name, entry: entry}
+	results, err := in.run(rt.process, nil, wasmProcessorFuel)
+	used := in.fuelUsed(wasmProcessorFuel)
+	in.host = nil
+	if err != nil {
+		// A trapped instance may be in any state, so it is not reused
+		return 0, used, err
+	}
+	rt.pool.Put(in)
+	return int32(results[0]), used, nil
+}
+
+func (rt *wasmRuntime) Name() string { return "wasm:" + rt.WasmProcessor.Name }
+
+func (rt *wasmRuntime) Process(entry *LogEntry) error {
+	rt.processed.Add(1)
+	original := *entry
+	result, _, err := rt.run(entry)
+	if err == nil {
+		switch result {
+		case 0:
+			return nil
+		case 1:
+			rt.dropped.Add(1)
+			return ErrDropLog
+		}
+		err = fmt.Errorf("process returned %d", result)
+	}
+	rt.failed.Add(1)
+	rt.lastError.Store(err.Error())
+	*entry = original
+	switch rt.OnError {
+	case wasmOnErrorDrop:
+		rt.dropped.Add(1)
+		return ErrDropLog
+	case wasmOnErrorReject:
+		return err
+	}
+	return nil
+}
+
+func (rt *wasmRuntime) stats() WasmProcessorStats {
+	s := WasmProcessorStats{Processed: rt.processed.Load(), Dropped: rt.dropped.Load(), Failed: rt.failed.Load()}
+	s.LastError, _ = rt.lastError.Load().(string)
+	return s
+}
+
+// wasmProcessorCache holds the enabled processors, loaded, in position order
+type wasmProcessorCache struct {
+	mu         sync.RWMutex
+	processors []*wasmRuntime
+}
+
+func createWasmProcessorTables(db *sql.DB) error {
+	_, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS wasm_processors (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			name TEXT NOT NULL UNIQUE,
+			position INTEGER NOT NULL DEFAULT 0,
+			enabled BOOLEAN NOT NULL,
+			on_error TEXT NOT NULL,
+			module BLOB NOT NULL,
+			sha256 TEXT NOT NULL,
+			created_at DATETIME NOT NULL
+		)
+	`)
+	return err
+}
+
+func (p *WasmProcessor) validate(requireModule bool) error {
+	if !iacExternalID.MatchString(p.Name) {
+		return errors.New("name must be 1-128 letters, digits, '.', '_' or '-'")
+	}
+	switch p.OnError {
+	case "":
+		p.OnError = wasmOnErrorKeep
+	case wasmOnErrorKeep, wasmOnErrorDrop, wasmOnErrorReject:
+	default:
+		return errors.New("onError must be keep, drop or reject")
+	}
+	if len(p.Module) == 0 {
+		if requireModule {
+			return errors.New("module is required")
+		}
+		return nil
+	}
+	if len(p.Module) > wasmProcessorMaxBytes {
+		return fmt.Errorf("module is over %d bytes", wasmProcessorMaxBytes)
+	}
+	if _, err := loadWasmRuntime(*p); err != nil {
+		return fmt.Errorf("module: %v", err)
+	}
+	sum := sha256.Sum256(p.Module)
+	p.SHA256, p.Size = hex.EncodeToString(sum[:]), len(p.Module)
+	return nil
+}
+
+// loadWasmProcessors loads the enabled processors, keeping the counters of
+// those already loaded. A stored module that no longer loads is skipped.
+func (d *Database) loadWasmProcessors() error {
+	rows, err := d.db.Query(`SELECT id, name, position, enabled, on_error, module, sha256, created_at FROM wasm_processors WHERE enabled ORDER BY position, id`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	previous := map[int64]*wasmRuntime{}
+	for _, rt := range d.loadedWasmProcessors() {
+		previous[rt.ID] = rt
+	}
+	processors := []*wasmRuntime{}
+	for rows.Next() {
+		var p WasmProcessor
+		if err := rows.Scan(&p.ID, &p.Name, &p.Position, &p.Enabled, &p.OnError, &p.Module, &p.SHA256, &p.CreatedAt); err != nil {
+			return err
+		}
+		p.Size = len(p.Module)
+		rt, err := loadWasmRuntime(p)
+		if err != nil {
+			log.Printf("processor %s: not loaded: %v", p.Name, err)
+			continue
+		}
+		if old := previous[p.ID]; old != nil && old.SHA256 == p.SHA256 {
+			s := old.stats()
+			rt.processed.Store(s.Processed)
+			rt.dropped.Store(s.Dropped)
+			rt.failed.Store(s.Failed)
+			rt.lastError.Store(s.LastError)
+		}
+		processors = append(processors, rt)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	d.wasmProcessors.mu.Lock()
+	d.wasmProcessors.processors = processors
+	d.wasmProcessors.mu.Unlock()
+	return nil
+}
+
+func (d *Database) loadedWasmProcessors() []*wasmRuntime {
+	d.wasmProcessors.mu.RLock()
+	defer d.wasmProcessors.mu.RUnlock()
+	return d.wasmProcessors.processors
+}
+
+// wasmIngestProcessors returns the loaded processors for processIngest
+func (d *Database) wasmIngestProcessors() []IngestProcessor {
+	loaded := d.loadedWasmProcessors()
+	if len(loaded) == 0 {
+		return nil
+	}
+	processors := make([]IngestProcessor, len(loaded))
+	for i, rt := range loaded {
+		processors[i] = rt
+	}
+	return processors
+}
+
+func (d *Database) GetWasmProcessors() ([]WasmProcessor, error) {
+	rows, err := d.db.Query(`SELECT id, name, position, enabled, on_error, length(module), sha256, created_at FROM wasm_processors ORDER BY position, id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	loaded := map[int64]*wasmRuntime{}
+	for _, rt := range d.loadedWasmProcessors() {
+		loaded[rt.ID] = rt
+	}
+	processors := []WasmProcessor{}
+	for rows.Next() {
+		var p WasmProcessor
+		if err := rows.Scan(&p.ID, &p.Name, &p.Position, &p.Enabled, &p.OnError, &p.Size, &p.SHA256, &p.CreatedAt); err != nil {
+			return nil, err
+		}
+		if rt := loaded[p.ID]; rt != nil {
+			p.Stats = rt.stats()
+		}
+		processors = append(processors, p)
+	}
+	return processors, rows.Err()
+}
+
+func (d *Database) CreateWasmProcessor(p WasmProcessor) (WasmProcessor, error) {
+	p.CreatedAt = d.now().UTC()
+	res, err := d.db.Exec(`INSERT INTO wasm_processors (name, position, enabled, on_error, module, sha256, created_at) VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		p.Name, p.Position, p.Enabled, p.OnError, p.Module, p.SHA256, p.CreatedAt)
+	if err != nil {
+		return p, err
+	}
+	if p.ID, err = res.LastInsertId(); err != nil {
+		return p, err
+	}
+	p.Module = nil
+	return p, d.loadWasmProcessors()
+}
+
+// UpdateWasmProcessor changes a processor, replacing its module only when
+// p carries one
+func (d *Database) UpdateWasmProcessor(p WasmProcessor) error {
+	var res sql.Result
+	var err error
+	if len(p.Module) > 0 {
+		res, err = d.db.Exec(`UPDATE wasm_processors SET name = ?, position = ?, enabled = ?, on_error = ?, module = ?, sha256 = ? WHERE id = ?`,
+			p.Name, p.Position, p.Enabled, p.OnError, p.Module, p.SHA256, p.ID)
+	} else {
+		res, err = d.db.Exec(`UPDATE wasm_processors SET name = ?, position = ?, enabled = ?, on_error = ? WHERE id = ?`,
+			p.Name, p.Position, p.Enabled, p.OnError, p.ID)
+	}
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return d.loadWasmProcessors()
+}
+
+func (d *Database) DeleteWasmProcessor(id int64) error {
+	if _, err := d.db.Exec(`DELETE FROM wasm_processors WHERE id = ?`, id); err != nil {
+		return err
+	}
+	return d.loadWasmProcessors()
+}
+
+// wasmProcessorModule returns a stored processor with its module
+func (d *Database) wasmProcessorModule(id int64) (WasmProcessor, error) {
+	var p WasmProcessor
+	err := d.db.QueryRow(`SELECT id, name, position, enabled, on_error, module, sha256, created_at FROM wasm_processors WHERE id = ?`, id).
+		Scan(&p.ID, &p.Name, &p.Position, &p.Enabled, &p.OnError, &p.Module, &p.SHA256, &p.CreatedAt)
+	p.Size = len(p.Module)
+	return p, err
+}
+
+// wasmProcessorTest is the body of POST /api/processors/test: a stored
+// processor's ID or a module to try, and the log to run it on
+type wasmProcessorTest struct {
+	ID     int64    `json:"id"`
+	Module []byte   `json:"module"`
+	Entry  LogEntry `json:"entry"`
+	// Raw is offered to the module as the log's raw payload
+	Raw string `json:"raw"`
+}
+
+// GET/POST/PUT/DELETE /api/processors - manage WASM ingest processors (admin only)
+// POST /api/processors/test - run a module on a log without storing either
+func wasmProcessorsHandlerDB(w http.ResponseWriter, r *http.Request, db *Database) {
+	enableCORS(w)
+	w.Header().Set("Content-Type", "application/json")
+	if !db.requireAdmin(w, r) {
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, int64(wasmProcessorMaxBytes)*2)
+	if strings.TrimSuffix(r.URL.Path, "/") == "/api/processors/test" {
+		if r.Method != http.MethodPost {
+			writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+			return
+		}
+		var test wasmProcessorTest
+		if err := json.NewDecoder(r.Body).Decode(&test); err != nil {
+			writeJSONError(w, http.StatusBadRequest, "Invalid JSON")
+			return
+		}
+		p := WasmProcessor{Name: "test", Module: test.Module}
+		if test.ID != 0 {
+			stored, err := db.wasmProcessorModule(test.ID)
+			if errors.Is(err, sql.ErrNoRows) {
+				writeJSONError(w, http.StatusNotFound, "Processor not found")
+				return
+			}
+			if err != nil {
+				writeJSONError(w, http.StatusInternalServerError, "Failed to fetch processor")
+				return
+			}
+			p = stored
+		}
+		if len(p.Module) > wasmProcessorMaxBytes {
+			writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("module is over %d bytes", wasmProcessorMaxBytes))
+			return
+		}
+		rt, err := loadWasmRuntime(p)
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, "module: "+err.Error())
+			return
+		}
+		entry := test.Entry
+		if test.Raw != "" {
+			entry.Raw, entry.RawFormat = []byte(test.Raw), rawJSON
+		}
+		result, fuel, err := rt.run(&entry)
+		outcome := map[string]interface{}{"fuelUsed": fuel, "entry": entry}
+		switch {
+		case err != nil:
+			outcome["result"], outcome["error"] = "error", err.Error()
+		case result == 0:
+			outcome["result"] = "keep"
+		case result == 1:
+			outcome["result"] = "drop"
+		default:
+			outcome["result"], outcome["error"] = "error", fmt.Sprintf("process returned %d", result)
+		}
+		json.NewEncoder(w).Encode(outcome)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		processors, err := db.GetWasmProcessors()
+		if err != nil {
+			writeJSONError(w, http.StatusInternalServerError, "Failed to fetch processors")
+			return
+		}
+		json.NewEncoder(w).Encode(processors)
+	case http.MethodPost, http.MethodPut:
+		p := WasmProcessor{Enabled: true}
+		if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
+			writeJSONError(w, http.StatusBadRequest, "Invalid JSON")
+			return
+		}
+		if err := p.validate(r.Method == http.MethodPost); err != nil {
+			writeJSONError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if r.Method == http.MethodPut {
+			err := db.UpdateWasmProcessor(p)
+			if errors.Is(err, sql.ErrNoRows) {
+				writeJSONError(w, http.StatusNotFound, "Processor not found")
+				return
+			}
+			if err != nil {
+				writeJSONError(w, http.StatusConflict, "A processor with that name already exists")
+				return
+			}
+			p.Module = nil
+			json.NewEncoder(w).Encode(p)
+			return
+		}
+		p, err := db.CreateWasmProcessor(p)
+		if err != nil {
+			writeJSONError(w, http.StatusConflict, "A processor with that name already exists")
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(p)
+	case http.MethodDelete:
+		id, err := strconv.ParseInt(r.URL.Query().Get("id"), 10, 64)
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, "Invalid id")
+			return
+		}
+		if err := db.DeleteWasmProcessor(id); err != nil {
+			writeJSONError(w, http.StatusInternalServerError, "Failed to delete processor")
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+	}
+}
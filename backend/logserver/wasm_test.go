@@ -0,0 +1,217 @@
+package logserver
+
+import (
+	"encoding/binary"
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// FuzzWasm decodes processor modules and runs them on one log
+func FuzzWasm(f *testing.F) {
+	addCorpus(f, "wasm", func(data []byte) { f.Add(data) })
+	f.Fuzz(func(t *testing.T, input []byte) {
+		rt, err := loadWasmRuntime(WasmProcessor{Name: "fuzz", Module: input})
+		if err != nil {
+			return
+		}
+		entry := LogEntry{Level: "INFO", Event: "Failed login", User: "alice", Raw: []byte(`{"event":"Failed login"}`), RawFormat: rawJSON}
+		rt.run(&entry)
+	})
+}
+
+// wasmSection appends a module section with its id and size
+func wasmSection(b []byte, id byte, content []byte) []byte {
+	b = append(b, id)
+	b = binary.AppendUvarint(b, uint64(len(content)))
+	return append(b, content...)
+}
+
+// wasmI32Const is an i32.const instruction, its operand signed LEB128
+func wasmI32Const(v int32) []byte {
+	b := []byte{0x41}
+	for {
+		c := byte(v & 0x7f)
+		v >>= 7
+		if (v == 0 && c&0x40 == 0) || (v == -1 && c&0x40 != 0) {
+			return append(b, c)
+		}
+		b = append(b, c|0x80)
+	}
+}
+
+// testWasmModule assembles a module of functions of type () -> i32 with the
+// given bodies, which the end opcode is added to. The first is exported as
+// process. memory is the module's memory limits, none when nil.
+func testWasmModule(memory []byte, bodies ...[]byte) []byte {
+	b := []byte("\x00asm\x01\x00\x00\x00")
+	b = wasmSection(b, 1, []byte{0x01, 0x60, 0x00, 0x01, 0x7f})
+	funcs := []byte{byte(len(bodies))}
+	for range bodies {
+		funcs = append(funcs, 0x00)
+	}
+	b = wasmSection(b, 3, funcs)
+	if memory != nil {
+		b = wasmSection(b, 5, append([]byte{0x01}, memory...))
+	}
+	b = wasmSection(b, 7, []byte("\x01\x07process\x00\x00"))
+	code := []byte{byte(len(bodies))}
+	for _, body := range bodies {
+		fn := append(append([]byte{0x00}, body...), 0x0b)
+		code = binary.AppendUvarint(code, uint64(len(fn)))
+		code = append(code, fn...)
+	}
+	return wasmSection(b, 10, code)
+}
+
+// runTestWasm loads module and runs process once on a log
+func runTestWasm(t *testing.T, module []byte) (int32, int64, error) {
+	t.Helper()
+	rt, err := loadWasmRuntime(WasmProcessor{Name: "test", Module: module})
+	if err != nil {
+		t.Fatalf("loading module: %v", err)
+	}
+	entry := LogEntry{Level: "INFO", Event: "Failed login"}
+	return rt.run(&entry)
+}
+
+func TestWasmRun(t *testing.T) {
+	result, used, err := runTestWasm(t, testWasmModule(nil, wasmI32Const(1)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect(t, "result", result, int32(1))
+	if used <= 0 || used > 10 {
+		t.Errorf("fuel used = %d, want a few instructions", used)
+	}
+}
+
+func TestWasmFuelExhausted(t *testing.T) {
+	// (loop (br 0)) (i32.const 0)
+	spin := append([]byte{0x03, 0x40, 0x0c, 0x00, 0x0b}, wasmI32Const(0)...)
+	_, used, err := runTestWasm(t, testWasmModule(nil, spin))
+	if !errors.Is(err, errWasmFuel) {
+		t.Fatalf("err = %v, want %v", err, errWasmFuel)
+	}
+	expect(t, "fuel used", used, wasmProcessorFuel)
+}
+
+func TestWasmMemoryGrow(t *testing.T) {
+	grow := func(pages int32) []byte { return append(wasmI32Const(pages), 0x40, 0x00) }
+	// Growing within the cap returns the old size in pages
+	result, _, err := runTestWasm(t, testWasmModule([]byte{0x00, 0x01}, grow(1)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect(t, "grow within the cap", result, int32(1))
+
+	// Growing past the cap fails with -1, as if the host were out of memory,
+	// even when the module declares a higher maximum
+	for _, memory := range [][]byte{{0x00, 0x01}, {0x01, 0x01, 0x80, 0x80, 0x04}} {
+		result, _, err := runTestWasm(t, testWasmModule(memory, grow(int32(wasmProcessorMaxPages))))
+		if err != nil {
+			t.Fatal(err)
+		}
+		expect(t, "grow past the cap", result, int32(-1))
+	}
+
+	// A module whose minimum is over the cap does not load
+	over := binary.AppendUvarint([]byte{0x00}, uint64(wasmProcessorMaxPages)+1)
+	_, err = loadWasmRuntime(WasmProcessor{Name: "test", Module: testWasmModule(over, wasmI32Const(0))})
+	if err == nil || !strings.Contains(err.Error(), "over the limit") {
+		t.Fatalf("loading a module over the memory cap: err = %v", err)
+	}
+}
+
+func TestWasmOutOfBounds(t *testing.T) {
+	// i32.load at an address, with no offset
+	load := func(addr int32) []byte { return append(wasmI32Const(addr), 0x28, 0x02, 0x00) }
+	// i32.store of 0 at an address, with an offset of 8
+	store := func(addr int32) []byte {
+		return append(append(append(wasmI32Const(addr), wasmI32Const(0)...), 0x36, 0x02, 0x08), wasmI32Const(0)...)
+	}
+	for _, tc := range []struct {
+		name string
+		body []byte
+		ok   bool
+	}{
+		{"load of the last word", load(wasmPageSize - 4), true},
+		{"load across the end", load(wasmPageSize - 3), false},
+		{"load at a negative address", load(-1), false},
+		{"store of the last word", store(wasmPageSize - 12), true},
+		{"store past the end by its offset", store(wasmPageSize - 8), false},
+	} {
+		_, _, err := runTestWasm(t, testWasmModule([]byte{0x00, 0x01}, tc.body))
+		switch {
+		case tc.ok && err != nil:
+			t.Errorf("%s: %v", tc.name, err)
+		case !tc.ok && (err == nil || err.Error() != "out of bounds memory access"):
+			t.Errorf("%s: err = %v, want out of bounds memory access", tc.name, err)
+		}
+	}
+}
+
+func TestWasmCallDepth(t *testing.T) {
+	// process calls itself until the call stack runs out
+	_, _, err := runTestWasm(t, testWasmModule(nil, []byte{0x10, 0x00}))
+	if err == nil || err.Error() != "call stack exhausted" {
+		t.Fatalf("err = %v, want call stack exhausted", err)
+	}
+	// Recursion within the limit is fine: process calls a function that
+	// returns 7
+	result, _, err := runTestWasm(t, testWasmModule(nil, []byte{0x10, 0x01}, wasmI32Const(7)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect(t, "result", result, int32(7))
+}
+
+func TestWasmTraps(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		body []byte
+		want string
+	}{
+		{"unreachable", []byte{0x00}, "unreachable"},
+		{"divide by zero", append(append(wasmI32Const(1), wasmI32Const(0)...), 0x6d), "integer divide by zero"},
+		{"signed overflow", append(append(wasmI32Const(-1<<31), wasmI32Const(-1)...), 0x6d), "integer overflow"},
+	} {
+		_, _, err := runTestWasm(t, testWasmModule(nil, tc.body))
+		if err == nil || err.Error() != tc.want {
+			t.Errorf("%s: err = %v, want %s", tc.name, err, tc.want)
+		}
+	}
+}
+
+func TestWasmMalformedModules(t *testing.T) {
+	valid := testWasmModule([]byte{0x00, 0x01}, wasmI32Const(0))
+	if _, err := loadWasmRuntime(WasmProcessor{Name: "test", Module: valid}); err != nil {
+		t.Fatalf("valid module: %v", err)
+	}
+	header := "\x00asm\x01\x00\x00\x00"
+	for _, tc := range []struct {
+		name   string
+		module []byte
+		want   string
+	}{
+		{"empty", nil, "not a WebAssembly module"},
+		{"wrong magic", []byte("\x00wasm\x01\x00\x00"), "not a WebAssembly module"},
+		{"wrong version", []byte("\x00asm\x02\x00\x00\x00"), "unsupported WebAssembly version"},
+		{"truncated", valid[:len(valid)-1], "truncated"},
+		{"section size past the end", []byte(header + "\x01\x7f\x00"), "truncated"},
+		{"sections out of order", []byte(header + "\x03\x01\x00\x01\x01\x00"), "out of order"},
+		{"unknown section", []byte(header + "\x0e\x00"), "unknown section"},
+		{"no process export", []byte(header), `does not export a function "process"`},
+		{"process of the wrong type", []byte(header + "\x01\x04\x01\x60\x00\x00\x03\x02\x01\x00\x07\x0b\x01\x07process\x00\x00\x0a\x04\x01\x02\x00\x0b"), `export "process" is`},
+		{"unknown import", []byte(header + "\x01\x04\x01\x60\x00\x00\x02\x0b\x01\x03env\x03foo\x00\x00"), "unknown import env.foo"},
+		{"functions without code", []byte(header + "\x01\x05\x01\x60\x00\x01\x7f\x03\x02\x01\x00"), "function section without code section"},
+		{"data past the memory", wasmSection(slices.Clip(valid), 11, []byte("\x01\x00\x41\xfe\xff\x03\x0b\x03abc")), "data segment out of memory bounds"},
+		{"memory over 4 GiB", []byte(header + "\x05\x05\x01\x00\x81\x80\x04"), "memory over 4 GiB"},
+	} {
+		_, err := loadWasmRuntime(WasmProcessor{Name: "test", Module: tc.module})
+		if err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%s: err = %v, want %q", tc.name, err, tc.want)
+		}
+	}
+}
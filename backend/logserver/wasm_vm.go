@@ -0,0 +1,851 @@
+package logserver
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"math/bits"
+)
+
+const (
+	// wasmStackSize bounds the operand stack, locals included, of an instance
+	wasmStackSize = 1 << 14
+	// wasmMaxCallDepth bounds recursion
+	wasmMaxCallDepth = 512
+)
+
+var errWasmFuel = errors.New("fuel exhausted")
+
+// wasmTrap stops execution; run recovers it, and any runtime error a
+// misbehaving module causes, as an error
+type wasmTrap struct {
+	err error
+}
+
+func trap(format string, args ...interface{}) {
+	panic(wasmTrap{fmt.Errorf(format, args...)})
+}
+
+// wasmHostFunc is a function the host provides to modules
+type wasmHostFunc struct {
+	typ wasmFuncType
+	// fn gets the arguments and returns the result, if the type has one
+	fn func(in *wasmInstance, args []uint64) uint64
+}
+
+type wasmLabel struct {
+	cont   uint32
+	arity  uint32
+	height uint32
+	loop   bool
+}
+
+// wasmInstance is a module's memory, globals and table, plus the limits it
+// runs under. An instance is not safe for concurrent use.
+type wasmInstance struct {
+	module  *wasmModule
+	mem     []byte
+	globals []uint64
+	table   []uint32
+	stack   []uint64
+	sp      int
+	labels  []wasmLabel
+	depth   int
+	// fuel is the instructions left before the current call is stopped
+	fuel     int64
+	maxPages uint32
+	// host is the per-call state host functions use
+	host interface{}
+}
+
+// instantiate allocates an instance and runs the module's start function.
+// maxPages caps the memory, whatever the module declares.
+func (m *wasmModule) instantiate(maxPages uint32, fuel int64) (*wasmInstance, error) {
+	if m.memMax > 0 && m.memMax < maxPages {
+		maxPages = m.memMax
+	}
+	if m.memMin > maxPages {
+		return nil, fmt.Errorf("module needs %d memory pages, over the limit of %d", m.memMin, maxPages)
+	}
+	in := &wasmInstance{
+		module:   m,
+		mem:      make([]byte, int(m.memMin)*wasmPageSize),
+		globals:  make([]uint64, len(m.globals)),
+		table:    make([]uint32, m.tableMin),
+		stack:    make([]uint64, wasmStackSize),
+		maxPages: maxPages,
+	}
+	for i, g := range m.globals {
+		in.globals[i] = g.init
+	}
+	for i := range in.table {
+		in.table[i] = math.MaxUint32
+	}
+	for _, seg := range m.elems {
+		if uint64(seg.offset)+uint64(len(seg.funcs)) > uint64(len(in.table)) {
+			return nil, errors.New("element segment out of table bounds")
+		}
+		copy(in.table[seg.offset:], seg.funcs)
+	}
+	for _, seg := range m.data {
+		if uint64(seg.offset)+uint64(len(seg.data)) > uint64(len(in.mem)) {
+			return nil, errors.New("data segment out of memory bounds")
+		}
+		copy(in.mem[seg.offset:], seg.data)
+	}
+	if m.start >= 0 {
+		if _, err := in.run(uint32(m.start), nil, fuel); err != nil {
+			return nil, fmt.Errorf("start function: %w", err)
+		}
+	}
+	return in, nil
+}
+
+// export returns the index of an exported function of type t
+func (m *wasmModule) export(name string, t wasmFuncType) (uint32, error) {
+	e, ok := m.exports[name]
+	if !ok || e.kind != wasmExternFunc {
+		return 0, fmt.Errorf("module does not export a function %q", name)
+	}
+	if got := m.types[m.funcs[e.index].typ]; !got.equal(t) {
+		return 0, fmt.Errorf("export %q is %v, want %v", name, got, t)
+	}
+	return e.index, nil
+}
+
+// run calls function fn with args, stopping it once it has run fuel
+// instructions
+func (in *wasmInstance) run(fn uint32, args []uint64, fuel int64) (results []uint64, err error) {
+	in.sp, in.labels, in.depth, in.fuel = 0, in.labels[:0], 0, fuel
+	defer func() {
+		if r := recover(); r != nil {
+			if t, ok := r.(wasmTrap); ok {
+				err = t.err
+				return
+			}
+			if e, ok := r.(error); ok {
+				err = fmt.Errorf("trap: %v", e)
+				return
+			}
+			panic(r)
+		}
+	}()
+	for _, a := range args {
+		in.push(a)
+	}
+	in.call(fn)
+	n := len(in.module.types[in.module.funcs[fn].typ].results)
+	return append([]uint64(nil), in.stack[in.sp-n:in.sp]...), nil
+}
+
+// fuelUsed reports how much of the last run's fuel was spent
+func (in *wasmInstance) fuelUsed(fuel int64) int64 {
+	if in.fuel < 0 {
+		return fuel
+	}
+	return fuel - in.fuel
+}
+
+func (in *wasmInstance) push(v uint64) {
+	in.stack[in.sp] = v
+	in.sp++
+}
+
+func (in *wasmInstance) pop() uint64 {
+	in.sp--
+	return in.stack[in.sp]
+}
+
+func (in *wasmInstance) pushBool(b bool) {
+	if b {
+		in.push(1)
+	} else {
+		in.push(0)
+	}
+}
+
+func (in *wasmInstance) pop32() uint32   { return uint32(in.pop()) }
+func (in *wasmInstance) popF32() float32 { return math.Float32frombits(uint32(in.pop())) }
+func (in *wasmInstance) popF64() float64 { return math.Float64frombits(in.pop()) }
+func (in *wasmInstance) push32(v uint32) { in.push(uint64(v)) }
+func (in *wasmInstance) pushF32(v float32) {
+	in.push(uint64(math.Float32bits(v)))
+}
+func (in *wasmInstance) pushF64(v float64) { in.push(math.Float64bits(v)) }
+
+// memory returns n bytes of memory at base+offset, trapping when they are
+// out of bounds
+func (in *wasmInstance) memory(base uint32, offset uint32, n uint64) []byte {
+	ea := uint64(base) + uint64(offset)
+	if ea+n > uint64(len(in.mem)) {
+		trap("out of bounds memory access")
+	}
+	return in.mem[ea : ea+n]
+}
+
+func (in *wasmInstance) call(fn uint32) {
+	m := in.module
+	f := &m.funcs[fn]
+	t := m.types[f.typ]
+	if f.host != nil {
+		args := make([]uint64, len(t.params))
+		in.sp -= len(t.params)
+		copy(args, in.stack[in.sp:])
+		v := f.host.fn(in, args)
+		if len(t.results) > 0 {
+			in.push(v)
+		}
+		return
+	}
+	if in.depth++; in.depth > wasmMaxCallDepth {
+		trap("call stack exhausted")
+	}
+	defer func() { in.depth-- }()
+
+	base := in.sp - len(t.params)
+	if base < 0 {
+		trap("operand stack underflow")
+	}
+	for i := 0; i < f.locals; i++ {
+		in.push(0)
+	}
+	labelBase := len(in.labels)
+	in.labels = append(in.labels, wasmLabel{cont: uint32(len(f.code)), arity: uint32(len(t.results)), height: uint32(in.sp)})
+	code := f.code
+	pc := 0
+	for pc < len(code) {
+		if in.fuel--; in.fuel < 0 {
+			panic(wasmTrap{errWasmFuel})
+		}
+		ins := &code[pc]
+		pc++
+		switch ins.op {
+		case wasmOpUnreachable:
+			trap("unreachable")
+		case wasmOpNop:
+		case wasmOpBlock:
+			in.labels = append(in.labels, wasmLabel{cont: ins.c + 1, arity: ins.b, height: uint32(in.sp) - ins.a})
+		case wasmOpLoop:
+			in.labels = append(in.labels, wasmLabel{cont: uint32(pc), arity: ins.a, height: uint32(in.sp) - ins.a, loop: true})
+		case wasmOpIf:
+			cond := in.pop32()
+			switch {
+			case cond != 0:
+				in.labels = append(in.labels, wasmLabel{cont: ins.c + 1, arity: ins.b, height: uint32(in.sp) - ins.a})
+			case ins.imm != 0:
+				in.labels = append(in.labels, wasmLabel{cont: ins.c + 1, arity: ins.b, height: uint32(in.sp) - ins.a})
+				pc = int(ins.imm) + 1
+			default:
+				pc = int(ins.c) + 1
+			}
+		case wasmOpElse:
+			// The then branch is done; the end pops its label
+			pc = int(ins.c)
+		case wasmOpEnd:
+			in.labels = in.labels[:len(in.labels)-1]
+		case wasmOpBr:
+			pc = in.branch(ins.a)
+		case wasmOpBrIf:
+			if in.pop32() != 0 {
+				pc = in.branch(ins.a)
+			}
+		case wasmOpBrTable:
+			i := in.pop32()
+			if i >= ins.b-1 {
+				i = ins.b - 1
+			}
+			pc = in.branch(f.brTables[ins.a+i])
+		case wasmOpReturn:
+			pc = in.branch(uint32(len(in.labels) - labelBase - 1))
+		case wasmOpCall:
+			in.call(ins.a)
+		case wasmOpCallIndirect:
+			i := in.pop32()
+			if i >= uint32(len(in.table)) {
+				trap("undefined table element")
+			}
+			target := in.table[i]
+			if target == math.MaxUint32 {
+				trap("uninitialized table element")
+			}
+			if !m.types[m.funcs[target].typ].equal(m.types[ins.a]) {
+				trap("indirect call type mismatch")
+			}
+			in.call(target)
+		case wasmOpDrop:
+			in.sp--
+		case wasmOpSelect:
+			cond := in.pop32()
+			b := in.pop()
+			if cond == 0 {
+				in.stack[in.sp-1] = b
+			}
+		case wasmOpLocalGet:
+			in.push(in.stack[base+int(ins.a)])
+		case wasmOpLocalSet:
+			in.stack[base+int(ins.a)] = in.pop()
+		case wasmOpLocalTee:
+			in.stack[base+int(ins.a)] = in.stack[in.sp-1]
+		case wasmOpGlobalGet:
+			in.push(in.globals[ins.a])
+		case wasmOpGlobalSet:
+			in.globals[ins.a] = in.pop()
+		case wasmOpMemorySize:
+			in.push32(uint32(len(in.mem) / wasmPageSize))
+		case wasmOpMemoryGrow:
+			in.push32(in.grow(in.pop32()))
+		case wasmOpI32Const, wasmOpI64Const, wasmOpF32Const, wasmOpF64Const:
+			in.push(ins.imm)
+		case wasmOpMemoryCopy:
+			n := in.pop32()
+			src := in.pop32()
+			dst := in.pop32()
+			in.charge(n)
+			copy(in.memory(dst, 0, uint64(n)), in.memory(src, 0, uint64(n)))
+		case wasmOpMemoryFill:
+			n := in.pop32()
+			v := byte(in.pop32())
+			dst := in.pop32()
+			in.charge(n)
+			b := in.memory(dst, 0, uint64(n))
+			for i := range b {
+				b[i] = v
+			}
+		default:
+			if ins.op >= 0x28 && ins.op <= 0x3e {
+				in.memoryOp(ins)
+			} else {
+				in.numeric(ins.op)
+			}
+		}
+	}
+	// Move the results down over the locals
+	n := len(t.results)
+	copy(in.stack[base:], in.stack[in.sp-n:in.sp])
+	in.sp = base + n
+	in.labels = in.labels[:labelBase]
+}
+
+// branch unwinds to the label depth levels out and returns where execution
+// continues
+func (in *wasmInstance) branch(depth uint32) int {
+	i := len(in.labels) - 1 - int(depth)
+	l := in.labels[i]
+	copy(in.stack[l.height:], in.stack[in.sp-int(l.arity):in.sp])
+	in.sp = int(l.height + l.arity)
+	if l.loop {
+		in.labels = in.labels[:i+1]
+	} else {
+		in.labels = in.labels[:i]
+	}
+	return int(l.cont)
+}
+
+// charge takes fuel for bulk memory instructions, one per 64 bytes
+func (in *wasmInstance) charge(n uint32) {
+	if in.fuel -= int64(n / 64); in.fuel < 0 {
+		panic(wasmTrap{errWasmFuel})
+	}
+}
+
+// grow adds pages to memory, returning the old size in pages or -1 when
+// that would pass the limit
+func (in *wasmInstance) grow(pages uint32) uint32 {
+	old := uint32(len(in.mem) / wasmPageSize)
+	if uint64(old)+uint64(pages) > uint64(in.maxPages) {
+		return math.MaxUint32
+	}
+	in.charge(pages * 64)
+	in.mem = append(in.mem, make([]byte, int(pages)*wasmPageSize)...)
+	return old
+}
+
+func (in *wasmInstance) memoryOp(ins *wasmInstr) {
+	le := binary.LittleEndian
+	switch ins.op {
+	case 0x28: // i32.load
+		in.push(uint64(le.Uint32(in.memory(in.pop32(), ins.a, 4))))
+	case 0x29: // i64.load
+		in.push(le.Uint64(in.memory(in.pop32(), ins.a, 8)))
+	case 0x2a: // f32.load
+		in.push(uint64(le.Uint32(in.memory(in.pop32(), ins.a, 4))))
+	case 0x2b: // f64.load
+		in.push(le.Uint64(in.memory(in.pop32(), ins.a, 8)))
+	case 0x2c: // i32.load8_s
+		in.push32(uint32(int32(int8(in.memory(in.pop32(), ins.a, 1)[0]))))
+	case 0x2d: // i32.load8_u
+		in.push(uint64(in.memory(in.pop32(), ins.a, 1)[0]))
+	case 0x2e: // i32.load16_s
+		in.push32(uint32(int32(int16(le.Uint16(in.memory(in.pop32(), ins.a, 2))))))
+	case 0x2f: // i32.load16_u
+		in.push(uint64(le.Uint16(in.memory(in.pop32(), ins.a, 2))))
+	case 0x30: // i64.load8_s
+		in.push(uint64(int64(int8(in.memory(in.pop32(), ins.a, 1)[0]))))
+	case 0x31: // i64.load8_u
+		in.push(uint64(in.memory(in.pop32(), ins.a, 1)[0]))
+	case 0x32: // i64.load16_s
+		in.push(uint64(int64(int16(le.Uint16(in.memory(in.pop32(), ins.a, 2))))))
+	case 0x33: // i64.load16_u
+		in.push(uint64(le.Uint16(in.memory(in.pop32(), ins.a, 2))))
+	case 0x34: // i64.load32_s
+		in.push(uint64(int64(int32(le.Uint32(in.memory(in.pop32(), ins.a, 4))))))
+	case 0x35: // i64.load32_u
+		in.push(uint64(le.Uint32(in.memory(in.pop32(), ins.a, 4))))
+	default:
+		v := in.pop()
+		addr := in.pop32()
+		switch ins.op {
+		case 0x36, 0x38: // i32.store, f32.store
+			le.PutUint32(in.memory(addr, ins.a, 4), uint32(v))
+		case 0x37, 0x39: // i64.store, f64.store
+			le.PutUint64(in.memory(addr, ins.a, 8), v)
+		case 0x3a, 0x3c: // i32.store8, i64.store8
+			in.memory(addr, ins.a, 1)[0] = byte(v)
+		case 0x3b, 0x3d: // i32.store16, i64.store16
+			le.PutUint16(in.memory(addr, ins.a, 2), uint16(v))
+		case 0x3e: // i64.store32
+			le.PutUint32(in.memory(addr, ins.a, 4), uint32(v))
+		}
+	}
+}
+
+// numeric runs the comparison, arithmetic and conversion instructions
+func (in *wasmInstance) numeric(op uint16) {
+	switch op {
+	// i32 comparisons
+	case 0x45:
+		in.pushBool(in.pop32() == 0)
+	case 0x46, 0x47, 0x48, 0x49, 0x4a, 0x4b, 0x4c, 0x4d, 0x4e, 0x4f:
+		b, a := in.pop32(), in.pop32()
+		in.pushBool(compareInts(op-0x46, int64(int32(a)), int64(int32(b)), uint64(a), uint64(b)))
+	// i64 comparisons
+	case 0x50:
+		in.pushBool(in.pop() == 0)
+	case 0x51, 0x52, 0x53, 0x54, 0x55, 0x56, 0x57, 0x58, 0x59, 0x5a:
+		b, a := in.pop(), in.pop()
+		in.pushBool(compareInts(op-0x51, int64(a), int64(b), a, b))
+	// float comparisons
+	case 0x5b, 0x5c, 0x5d, 0x5e, 0x5f, 0x60:
+		b, a := in.popF32(), in.popF32()
+		in.pushBool(compareFloats(op-0x5b, float64(a), float64(b)))
+	case 0x61, 0x62, 0x63, 0x64, 0x65, 0x66:
+		b, a := in.popF64(), in.popF64()
+		in.pushBool(compareFloats(op-0x61, a, b))
+
+	// i32 arithmetic
+	case 0x67:
+		in.push32(uint32(bits.LeadingZeros32(in.pop32())))
+	case 0x68:
+		in.push32(uint32(bits.TrailingZeros32(in.pop32())))
+	case 0x69:
+		in.push32(uint32(bits.OnesCount32(in.pop32())))
+	case 0x6a, 0x6b, 0x6c, 0x6d, 0x6e, 0x6f, 0x70, 0x71, 0x72, 0x73, 0x74, 0x75, 0x76, 0x77, 0x78:
+		b, a := in.pop32(), in.pop32()
+		in.push32(i32Binary(op, a, b))
+
+	// i64 arithmetic
+	case 0x79:
+		in.push(uint64(bits.LeadingZeros64(in.pop())))
+	case 0x7a:
+		in.push(uint64(bits.TrailingZeros64(in.pop())))
+	case 0x7b:
+		in.push(uint64(bits.OnesCount64(in.pop())))
+	case 0x7c, 0x7d, 0x7e, 0x7f, 0x80, 0x81, 0x82, 0x83, 0x84, 0x85, 0x86, 0x87, 0x88, 0x89, 0x8a:
+		b, a := in.pop(), in.pop()
+		in.push(i64Binary(op, a, b))
+
+	// f32 arithmetic
+	case 0x8b, 0x8c, 0x8d, 0x8e, 0x8f, 0x90, 0x91:
+		x := in.popF32()
+		switch op {
+		case 0x8b:
+			in.push32(math.Float32bits(x) &^ (1 << 31))
+		case 0x8c:
+			in.push32(math.Float32bits(x) ^ (1 << 31))
+		case 0x91:
+			in.pushF32(float32(math.Sqrt(float64(x))))
+		default:
+			in.pushF32(float32(floatRound(op-0x8d, float64(x))))
+		}
+	case 0x92, 0x93, 0x94, 0x95, 0x96, 0x97, 0x98:
+		b, a := in.popF32(), in.popF32()
+		switch op {
+		case 0x92:
+			in.pushF32(a + b)
+		case 0x93:
+			in.pushF32(a - b)
+		case 0x94:
+			in.pushF32(a * b)
+		case 0x95:
+			in.pushF32(a / b)
+		case 0x96:
+			in.pushF32(float32(floatMin(float64(a), float64(b))))
+		case 0x97:
+			in.pushF32(float32(floatMax(float64(a), float64(b))))
+		case 0x98:
+			in.push32(math.Float32bits(a)&^(1<<31) | math.Float32bits(b)&(1<<31))
+		}
+
+	// f64 arithmetic
+	case 0x99, 0x9a, 0x9b, 0x9c, 0x9d, 0x9e, 0x9f:
+		x := in.popF64()
+		switch op {
+		case 0x99:
+			in.push(math.Float64bits(x) &^ (1 << 63))
+		case 0x9a:
+			in.push(math.Float64bits(x) ^ (1 << 63))
+		case 0x9f:
+			in.pushF64(math.Sqrt(x))
+		default:
+			in.pushF64(floatRound(op-0x9b, x))
+		}
+	case 0xa0, 0xa1, 0xa2, 0xa3, 0xa4, 0xa5, 0xa6:
+		b, a := in.popF64(), in.popF64()
+		switch op {
+		case 0xa0:
+			in.pushF64(a + b)
+		case 0xa1:
+			in.pushF64(a - b)
+		case 0xa2:
+			in.pushF64(a * b)
+		case 0xa3:
+			in.pushF64(a / b)
+		case 0xa4:
+			in.pushF64(floatMin(a, b))
+		case 0xa5:
+			in.pushF64(floatMax(a, b))
+		case 0xa6:
+			in.push(math.Float64bits(a)&^(1<<63) | math.Float64bits(b)&(1<<63))
+		}
+
+	// conversions
+	case 0xa7: // i32.wrap_i64
+		in.push32(uint32(in.pop()))
+	case 0xa8: // i32.trunc_f32_s
+		in.push32(uint32(int32(truncFloat(float64(in.popF32()), -1<<31, 1<<31))))
+	case 0xa9: // i32.trunc_f32_u
+		in.push32(uint32(truncFloat(float64(in.popF32()), -1, 1<<32)))
+	case 0xaa: // i32.trunc_f64_s
+		in.push32(uint32(int32(truncFloat(in.popF64(), -1<<31, 1<<31))))
+	case 0xab: // i32.trunc_f64_u
+		in.push32(uint32(truncFloat(in.popF64(), -1, 1<<32)))
+	case 0xac: // i64.extend_i32_s
+		in.push(uint64(int64(int32(in.pop32()))))
+	case 0xad: // i64.extend_i32_u
+		in.push(uint64(in.pop32()))
+	case 0xae: // i64.trunc_f32_s
+		in.push(uint64(int64(truncFloat(float64(in.popF32()), -1<<63, 1<<63))))
+	case 0xaf: // i64.trunc_f32_u
+		in.push(truncFloatU64(float64(in.popF32())))
+	case 0xb0: // i64.trunc_f64_s
+		in.push(uint64(int64(truncFloat(in.popF64(), -1<<63, 1<<63))))
+	case 0xb1: // i64.trunc_f64_u
+		in.push(truncFloatU64(in.popF64()))
+	case 0xb2: // f32.convert_i32_s
+		in.pushF32(float32(int32(in.pop32())))
+	case 0xb3: // f32.convert_i32_u
+		in.pushF32(float32(in.pop32()))
+	case 0xb4: // f32.convert_i64_s
+		in.pushF32(float32(int64(in.pop())))
+	case 0xb5: // f32.convert_i64_u
+		in.pushF32(float32(in.pop()))
+	case 0xb6: // f32.demote_f64
+		in.pushF32(float32(in.popF64()))
+	case 0xb7: // f64.convert_i32_s
+		in.pushF64(float64(int32(in.pop32())))
+	case 0xb8: // f64.convert_i32_u
+		in.pushF64(float64(in.pop32()))
+	case 0xb9: // f64.convert_i64_s
+		in.pushF64(float64(int64(in.pop())))
+	case 0xba: // f64.convert_i64_u
+		in.pushF64(float64(in.pop()))
+	case 0xbb: // f64.promote_f32
+		in.pushF64(float64(in.popF32()))
+	case 0xbc, 0xbd, 0xbe, 0xbf:
+		// Reinterpretations keep the bits as they are
+	case 0xc0: // i32.extend8_s
+		in.push32(uint32(int32(int8(in.pop32()))))
+	case 0xc1: // i32.extend16_s
+		in.push32(uint32(int32(int16(in.pop32()))))
+	case 0xc2: // i64.extend8_s
+		in.push(uint64(int64(int8(in.pop()))))
+	case 0xc3: // i64.extend16_s
+		in.push(uint64(int64(int16(in.pop()))))
+	case 0xc4: // i64.extend32_s
+		in.push(uint64(int64(int32(in.pop()))))
+
+	// saturating truncation
+	case wasmOpPrefixFC + 0:
+		in.push32(uint32(int32(satFloat(float64(in.popF32()), math.MinInt32, math.MaxInt32))))
+	case wasmOpPrefixFC + 1:
+		in.push32(uint32(satFloat(float64(in.popF32()), 0, math.MaxUint32)))
+	case wasmOpPrefixFC + 2:
+		in.push32(uint32(int32(satFloat(in.popF64(), math.MinInt32, math.MaxInt32))))
+	case wasmOpPrefixFC + 3:
+		in.push32(uint32(satFloat(in.popF64(), 0, math.MaxUint32)))
+	case wasmOpPrefixFC + 4:
+		in.push(uint64(satFloatI64(float64(in.popF32()))))
+	case wasmOpPrefixFC + 5:
+		in.push(satFloatU64(float64(in.popF32())))
+	case wasmOpPrefixFC + 6:
+		in.push(uint64(satFloatI64(in.popF64())))
+	case wasmOpPrefixFC + 7:
+		in.push(satFloatU64(in.popF64()))
+	default:
+		trap("unsupported instruction 0x%x", op)
+	}
+}
+
+// compareInts runs the integer comparison at index op of eq, ne, lt_s,
+// lt_u, gt_s, gt_u, le_s, le_u, ge_s, ge_u
+func compareInts(op uint16, sa, sb int64, ua, ub uint64) bool {
+	switch op {
+	case 0:
+		return ua == ub
+	case 1:
+		return ua != ub
+	case 2:
+		return sa < sb
+	case 3:
+		return ua < ub
+	case 4:
+		return sa > sb
+	case 5:
+		return ua > ub
+	case 6:
+		return sa <= sb
+	case 7:
+		return ua <= ub
+	case 8:
+		return sa >= sb
+	default:
+		return ua >= ub
+	}
+}
+
+// compareFloats runs the comparison at index op of eq, ne, lt, gt, le, ge
+func compareFloats(op uint16, a, b float64) bool {
+	switch op {
+	case 0:
+		return a == b
+	case 1:
+		return a != b
+	case 2:
+		return a < b
+	case 3:
+		return a > b
+	case 4:
+		return a <= b
+	default:
+		return a >= b
+	}
+}
+
+func i32Binary(op uint16, a, b uint32) uint32 {
+	switch op {
+	case 0x6a:
+		return a + b
+	case 0x6b:
+		return a - b
+	case 0x6c:
+		return a * b
+	case 0x6d:
+		if b == 0 {
+			trap("integer divide by zero")
+		}
+		if int32(a) == math.MinInt32 && int32(b) == -1 {
+			trap("integer overflow")
+		}
+		return uint32(int32(a) / int32(b))
+	case 0x6e:
+		if b == 0 {
+			trap("integer divide by zero")
+		}
+		return a / b
+	case 0x6f:
+		if b == 0 {
+			trap("integer divide by zero")
+		}
+		if int32(b) == -1 {
+			return 0
+		}
+		return uint32(int32(a) % int32(b))
+	case 0x70:
+		if b == 0 {
+			trap("integer divide by zero")
+		}
+		return a % b
+	case 0x71:
+		return a & b
+	case 0x72:
+		return a | b
+	case 0x73:
+		return a ^ b
+	case 0x74:
+		return a << (b & 31)
+	case 0x75:
+		return uint32(int32(a) >> (b & 31))
+	case 0x76:
+		return a >> (b & 31)
+	case 0x77:
+		return bits.RotateLeft32(a, int(b&31))
+	default:
+		return bits.RotateLeft32(a, -int(b&31))
+	}
+}
+
+func i64Binary(op uint16, a, b uint64) uint64 {
+	switch op {
+	case 0x7c:
+		return a + b
+	case 0x7d:
+		return a - b
+	case 0x7e:
+		return a * b
+	case 0x7f:
+		if b == 0 {
+			trap("integer divide by zero")
+		}
+		if int64(a) == math.MinInt64 && int64(b) == -1 {
+			trap("integer overflow")
+		}
+		return uint64(int64(a) / int64(b))
+	case 0x80:
+		if b == 0 {
+			trap("integer divide by zero")
+		}
+		return a / b
+	case 0x81:
+		if b == 0 {
+			trap("integer divide by zero")
+		}
+		if int64(b) == -1 {
+			return 0
+		}
+		return uint64(int64(a) % int64(b))
+	case 0x82:
+		if b == 0 {
+			trap("integer divide by zero")
+		}
+		return a % b
+	case 0x83:
+		return a & b
+	case 0x84:
+		return a | b
+	case 0x85:
+		return a ^ b
+	case 0x86:
+		return a << (b & 63)
+	case 0x87:
+		return uint64(int64(a) >> (b & 63))
+	case 0x88:
+		return a >> (b & 63)
+	case 0x89:
+		return bits.RotateLeft64(a, int(b&63))
+	default:
+		return bits.RotateLeft64(a, -int(b&63))
+	}
+}
+
+// floatRound runs the rounding at index op of ceil, floor, trunc, nearest
+func floatRound(op uint16, x float64) float64 {
+	switch op {
+	case 0:
+		return math.Ceil(x)
+	case 1:
+		return math.Floor(x)
+	case 2:
+		return math.Trunc(x)
+	default:
+		return math.RoundToEven(x)
+	}
+}
+
+// floatMin and floatMax follow WASM: NaN wins, and -0 is below +0
+func floatMin(a, b float64) float64 {
+	if math.IsNaN(a) || math.IsNaN(b) {
+		return math.NaN()
+	}
+	if a == 0 && b == 0 {
+		if math.Signbit(a) {
+			return a
+		}
+		return b
+	}
+	return math.Min(a, b)
+}
+
+func floatMax(a, b float64) float64 {
+	if math.IsNaN(a) || math.IsNaN(b) {
+		return math.NaN()
+	}
+	if a == 0 && b == 0 {
+		if math.Signbit(a) {
+			return b
+		}
+		return a
+	}
+	return math.Max(a, b)
+}
+
+// truncFloat truncates x, trapping unless lo < result < hi for unsigned
+// targets (lo = -1) or lo <= result < hi for signed ones
+func truncFloat(x float64, lo, hi float64) int64 {
+	if math.IsNaN(x) {
+		trap("invalid conversion to integer")
+	}
+	t := math.Trunc(x)
+	if t >= hi || t < lo || (lo == -1 && t == -1) {
+		trap("integer overflow")
+	}
+	if lo == -1 {
+		return int64(uint32(t))
+	}
+	return int64(t)
+}
+
+func truncFloatU64(x float64) uint64 {
+	if math.IsNaN(x) {
+		trap("invalid conversion to integer")
+	}
+	t := math.Trunc(x)
+	if t <= -1 || t >= 1<<64 {
+		trap("integer overflow")
+	}
+	return uint64(t)
+}
+
+func satFloat(x float64, lo, hi float64) int64 {
+	switch {
+	case math.IsNaN(x):
+		return 0
+	case x <= lo:
+		return int64(lo)
+	case x >= hi:
+		return int64(hi)
+	}
+	return int64(math.Trunc(x))
+}
+
+func satFloatI64(x float64) int64 {
+	switch {
+	case math.IsNaN(x):
+		return 0
+	case x <= math.MinInt64:
+		return math.MinInt64
+	case x >= 1<<63:
+		return math.MaxInt64
+	}
+	return int64(x)
+}
+
+func satFloatU64(x float64) uint64 {
+	switch {
+	case math.IsNaN(x), x <= 0:
+		return 0
+	case x >= 1<<64:
+		return math.MaxUint64
+	}
+	return uint64(x)
+}
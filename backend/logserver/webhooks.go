@@ -0,0 +1,252 @@
+package logserver
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Outbound event types
+const (
+	eventNotableCreated  = "notable.created"
+	eventNotableAssigned = "notable.assigned"
+	eventNotableResolved = "notable.resolved"
+	eventAuthLockout     = "auth.lockout"
+	eventIngestQuota     = "ingest.quota"
+)
+
+var knownEventTypes = map[string]bool{
+	eventNotableCreated:  true,
+	eventNotableAssigned: true,
+	eventNotableResolved: true,
+	eventAuthLockout:     true,
+	eventIngestQuota:     true,
+}
+
+// webhookMaxAttempts and webhookInitialBackoff control delivery retries;
+// the backoff doubles after every failed attempt
+const (
+	webhookMaxAttempts    = 5
+	webhookInitialBackoff = 2 * time.Second
+)
+
+// WebhookSubscription receives a signed POST for every event in Events
+// (all events when empty)
+type WebhookSubscription struct {
+	ID        int64     `json:"id"`
+	URL       string    `json:"url"`
+	Secret    string    `json:"secret,omitempty"`
+	Events    []string  `json:"events"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// WebhookEvent is the JSON body delivered to subscribers
+type WebhookEvent struct {
+	ID        string      `json:"id"`
+	Type      string      `json:"type"`
+	CreatedAt time.Time   `json:"createdAt"`
+	Data      interface{} `json:"data"`
+}
+
+func createWebhookTables(db *sql.DB) error {
+	_, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS webhook_subscriptions (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			url TEXT NOT NULL,
+			secret TEXT NOT NULL,
+			events TEXT NOT NULL,
+			created_at DATETIME NOT NULL
+		)
+	`)
+	return err
+}
+
+func (d *Database) CreateWebhookSubscription(sub WebhookSubscription) (WebhookSubscription, error) {
+	if sub.Events == nil {
+		sub.Events = []string{}
+	}
+	events, _ := json.Marshal(sub.Events)
+	sub.CreatedAt = d.now().UTC()
+	res, err := d.db.Exec(`
+		INSERT INTO webhook_subscriptions (url, secret, events, created_at) VALUES (?, ?, ?, ?)
+	`, sub.URL, sub.Secret, string(events), sub.CreatedAt)
+	if err != nil {
+		return sub, err
+	}
+	sub.ID, err = res.LastInsertId()
+	return sub, err
+}
+
+func (d *Database) DeleteWebhookSubscription(id int64) error {
+	_, err := d.db.Exec(`DELETE FROM webhook_subscriptions WHERE id = ?`, id)
+	return err
+}
+
+func (d *Database) GetWebhookSubscriptions() ([]WebhookSubscription, error) {
+	rows, err := d.db.Query(`SELECT id, url, secret, events, created_at FROM webhook_subscriptions ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	subs := []WebhookSubscription{}
+	for rows.Next() {
+		var sub WebhookSubscription
+		var events string
+		if err := rows.Scan(&sub.ID, &sub.URL, &sub.Secret, &events, &sub.CreatedAt); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal([]byte(events), &sub.Events); err != nil {
+			return nil, err
+		}
+		subs = append(subs, sub)
+	}
+	return subs, rows.Err()
+}
+
+func (sub WebhookSubscription) wants(eventType string) bool {
+	if len(sub.Events) == 0 {
+		return true
+	}
+	for _, e := range sub.Events {
+		if e == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+// emitEvent delivers an event to every matching subscription in the background
+func (d *Database) emitEvent(eventType string, data interface{}) {
+	subs, err := d.GetWebhookSubscriptions()
+	if err != nil {
+		log.Printf("webhooks: failed to load subscriptions: %v", err)
+		return
+	}
+	event := WebhookEvent{ID: randomHex(16), Type: eventType, CreatedAt: d.now().UTC(), Data: data}
+	body, err := json.Marshal(event)
+	if err != nil {
+		log.Printf("webhooks: failed to encode %s: %v", eventType, err)
+		return
+	}
+	for _, sub := range subs {
+		if sub.wants(eventType) {
+			go deliverWebhook(sub, event, body)
+		}
+	}
+}
+
+// signPayload returns the hex HMAC-SHA256 of body keyed with secret
+func signPayload(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// deliverWebhook POSTs body with exponential backoff until a 2xx response
+func deliverWebhook(sub WebhookSubscription, event WebhookEvent, body []byte) {
+	backoff := webhookInitialBackoff
+	for attempt := 1; attempt <= webhookMaxAttempts; attempt++ {
+		req, err := http.NewRequest(http.MethodPost, sub.URL, bytes.NewReader(body))
+		if err != nil {
+			log.Printf("webhooks: subscription %d: %v", sub.ID, err)
+			return
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Logger-Event", event.Type)
+		req.Header.Set("X-Logger-Delivery", event.ID)
+		if sub.Secret != "" {
+			req.Header.Set("X-Logger-Signature", "sha256="+signPayload(sub.Secret, body))
+		}
+		resp, err := notifyClient.Do(req)
+		if err == nil {
+			resp.Body.Close()
+			if resp.StatusCode < 300 {
+				return
+			}
+			err = errors.New(resp.Status)
+		}
+		log.Printf("webhooks: subscription %d: %s attempt %d/%d failed: %v", sub.ID, event.Type, attempt, webhookMaxAttempts, err)
+		if attempt < webhookMaxAttempts {
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+	}
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// GET/POST/DELETE /api/webhooks - manage outbound webhook subscriptions (admin only)
+func webhooksHandlerDB(w http.ResponseWriter, r *http.Request, db *Database) {
+	enableCORS(w)
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method == http.MethodOptions {
+		return
+	}
+	if !db.requireAdmin(w, r) {
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		subs, err := db.GetWebhookSubscriptions()
+		if err != nil {
+			writeJSONError(w, http.StatusInternalServerError, "Failed to fetch webhooks")
+			return
+		}
+		// Secrets are write-only
+		for i := range subs {
+			subs[i].Secret = ""
+		}
+		json.NewEncoder(w).Encode(subs)
+	case http.MethodPost:
+		var sub WebhookSubscription
+		if err := json.NewDecoder(r.Body).Decode(&sub); err != nil {
+			writeJSONError(w, http.StatusBadRequest, "Invalid JSON")
+			return
+		}
+		if sub.URL == "" {
+			writeJSONError(w, http.StatusBadRequest, "url is required")
+			return
+		}
+		for _, e := range sub.Events {
+			if !knownEventTypes[e] {
+				writeJSONError(w, http.StatusBadRequest, "Unknown event type "+e)
+				return
+			}
+		}
+		sub, err := db.CreateWebhookSubscription(sub)
+		if err != nil {
+			writeJSONError(w, http.StatusInternalServerError, "Failed to create webhook")
+			return
+		}
+		sub.Secret = ""
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(sub)
+	case http.MethodDelete:
+		id, err := strconv.ParseInt(r.URL.Query().Get("id"), 10, 64)
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, "Invalid id")
+			return
+		}
+		if err := db.DeleteWebhookSubscription(id); err != nil {
+			writeJSONError(w, http.StatusInternalServerError, "Failed to delete webhook")
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+	}
+}
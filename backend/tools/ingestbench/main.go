@@ -0,0 +1,209 @@
+// Command ingestbench compares JSON and protobuf batches for POST /api/logs.
+//
+// It always reports payload sizes, plain and gzipped, and encode times for
+// the same synthetic logs. With -url it also posts them to a running server
+// in both encodings and reports the throughput of each, and the server's heap
+// allocations per log from logger_go_mallocs_total on /metrics. -rate paces
+// the posts, so allocations can be compared at a steady ingest rate:
+//
+//	go run ./tools/ingestbench -logs 100000 -batch 500 -url http://localhost:8080
+//	go run ./tools/ingestbench -logs 100000 -batch 100 -rate 10000 -url http://localhost:8080
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// entry mirrors the JSON fields of the server's LogEntry
+type entry struct {
+	Timestamp       time.Time `json:"timestamp"`
+	Level           string    `json:"level"`
+	Rule            string    `json:"rule"`
+	SourceIP        string    `json:"sourceIP"`
+	DestinationIP   string    `json:"destinationIP"`
+	Event           string    `json:"event"`
+	Description     string    `json:"description"`
+	Urgency         int       `json:"urgency"`
+	User            string    `json:"user,omitempty"`
+	SourcePort      int       `json:"sourcePort,omitempty"`
+	DestinationPort int       `json:"destinationPort,omitempty"`
+	Tags            []string  `json:"tags,omitempty"`
+}
+
+func main() {
+	count := flag.Int("logs", 10000, "number of logs")
+	batch := flag.Int("batch", 500, "logs per request")
+	url := flag.String("url", "", "server to post to, e.g. http://localhost:8080")
+	token := flag.String("token", os.Getenv("INGEST_TOKEN"), "ingest token")
+	rate := flag.Int("rate", 0, "logs per second to post at, 0 for as fast as possible")
+	flag.Parse()
+
+	logs := synthesize(*count)
+	fmt.Printf("%d logs, %d per batch\n\n", *count, *batch)
+	fmt.Printf("%-9s %12s %12s %12s\n", "encoding", "bytes", "gzipped", "encode")
+	for _, enc := range encodings {
+		start := time.Now()
+		var size, zipped int
+		for _, b := range batches(logs, *batch) {
+			body := enc.encode(b)
+			size += len(body)
+			zipped += gzipSize(body)
+		}
+		fmt.Printf("%-9s %12d %12d %12s\n", enc.name, size, zipped, time.Since(start).Round(time.Millisecond))
+	}
+	if *url == "" {
+		return
+	}
+
+	fmt.Printf("\n%-9s %12s %12s %12s\n", "encoding", "elapsed", "logs/s", "allocs/log")
+	for _, enc := range encodings {
+		mallocs := serverMallocs(*url)
+		start := time.Now()
+		for i, b := range batches(logs, *batch) {
+			if *rate > 0 {
+				due := start.Add(time.Duration(i**batch) * time.Second / time.Duration(*rate))
+				time.Sleep(time.Until(due))
+			}
+			req, _ := http.NewRequest(http.MethodPost, *url+"/api/logs", bytes.NewReader(enc.encode(b)))
+			req.Header.Set("Content-Type", enc.contentType)
+			if *token != "" {
+				req.Header.Set("X-Ingest-Token", *token)
+			}
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				log.Fatal(err)
+			}
+			msg, _ := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			if resp.StatusCode != http.StatusCreated {
+				log.Fatalf("%s: %s: %s", enc.name, resp.Status, msg)
+			}
+		}
+		elapsed := time.Since(start)
+		allocs := float64(serverMallocs(*url)-mallocs) / float64(len(logs))
+		fmt.Printf("%-9s %12s %12.0f %12.1f\n", enc.name, elapsed.Round(time.Millisecond), float64(len(logs))/elapsed.Seconds(), allocs)
+	}
+}
+
+// serverMallocs reads logger_go_mallocs_total from the server's /metrics.
+// The count includes other requests the server handles meanwhile.
+func serverMallocs(url string) uint64 {
+	resp, err := http.Get(url + "/metrics")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	for _, line := range strings.Split(string(body), "\n") {
+		if v, ok := strings.CutPrefix(line, "logger_go_mallocs_total "); ok {
+			n, _ := strconv.ParseUint(v, 10, 64)
+			return n
+		}
+	}
+	log.Fatal("no logger_go_mallocs_total on /metrics")
+	return 0
+}
+
+var encodings = []struct {
+	name, contentType string
+	encode            func([]entry) []byte
+}{
+	{"json", "application/json", func(logs []entry) []byte {
+		body, _ := json.Marshal(logs)
+		return body
+	}},
+	{"protobuf", "application/x-protobuf", encodeBatch},
+}
+
+func synthesize(n int) []entry {
+	rng := rand.New(rand.NewSource(1))
+	levels := []string{"DEBUG", "INFO", "INFO", "INFO", "WARN", "ERROR"}
+	rules := []string{"Failed Login", "Port Scan", "Malware Detected", "Firewall Block", "Privilege Escalation"}
+	start := time.Now().Add(-time.Hour)
+	logs := make([]entry, n)
+	for i := range logs {
+		rule := rules[rng.Intn(len(rules))]
+		logs[i] = entry{
+			Timestamp:       start.Add(time.Duration(i) * time.Millisecond),
+			Level:           levels[rng.Intn(len(levels))],
+			Rule:            rule,
+			SourceIP:        fmt.Sprintf("10.0.%d.%d", rng.Intn(256), rng.Intn(256)),
+			DestinationIP:   fmt.Sprintf("192.168.1.%d", rng.Intn(256)),
+			Event:           rule,
+			Description:     fmt.Sprintf("%s from host %d", rule, rng.Intn(1000)),
+			Urgency:         rng.Intn(5) + 1,
+			User:            fmt.Sprintf("user%d", rng.Intn(50)),
+			SourcePort:      1024 + rng.Intn(60000),
+			DestinationPort: []int{22, 80, 443, 3389}[rng.Intn(4)],
+		}
+	}
+	return logs
+}
+
+func batches(logs []entry, size int) [][]entry {
+	var out [][]entry
+	for len(logs) > 0 {
+		n := min(size, len(logs))
+		out = append(out, logs[:n])
+		logs = logs[n:]
+	}
+	return out
+}
+
+func gzipSize(body []byte) int {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	zw.Write(body)
+	zw.Close()
+	return buf.Len()
+}
+
+// encodeBatch encodes a LogBatch as defined in proto/logger.proto
+func encodeBatch(logs []entry) []byte {
+	var out, msg []byte
+	for _, l := range logs {
+		msg = msg[:0]
+		msg = appendVarintField(msg, 1, uint64(l.Timestamp.UnixNano()))
+		for field, s := range map[int]string{2: l.Level, 3: l.Rule, 4: l.SourceIP, 5: l.DestinationIP, 6: l.Event, 7: l.Description, 9: l.User} {
+			msg = appendBytesField(msg, field, []byte(s))
+		}
+		msg = appendVarintField(msg, 8, uint64(int64(l.Urgency)))
+		msg = appendVarintField(msg, 10, uint64(l.SourcePort))
+		msg = appendVarintField(msg, 11, uint64(l.DestinationPort))
+		for _, tag := range l.Tags {
+			msg = appendBytesField(msg, 13, []byte(tag))
+		}
+		out = appendBytesField(out, 1, msg)
+	}
+	return out
+}
+
+func appendVarintField(buf []byte, field int, v uint64) []byte {
+	if v == 0 {
+		return buf
+	}
+	buf = binary.AppendUvarint(buf, uint64(field)<<3)
+	return binary.AppendUvarint(buf, v)
+}
+
+func appendBytesField(buf []byte, field int, b []byte) []byte {
+	if len(b) == 0 {
+		return buf
+	}
+	buf = binary.AppendUvarint(buf, uint64(field)<<3|2)
+	buf = binary.AppendUvarint(buf, uint64(len(b)))
+	return append(buf, b...)
+}
@@ -0,0 +1,6 @@
+"""Thin client for the Go Logger Application API."""
+
+from .client import LoggerClient, LoggerError
+from .edge import EdgeAggregator
+
+__all__ = ["EdgeAggregator", "LoggerClient", "LoggerError"]
@@ -0,0 +1,243 @@
+"""HTTP client for the logger backend.
+
+Only the standard library is required. The ``*_df`` methods return pandas
+DataFrames and need the ``pandas`` extra (``pip install logger-client[pandas]``).
+``msgpack=True`` asks for MessagePack responses and needs the ``msgpack`` extra.
+"""
+
+import json
+import urllib.error
+import urllib.parse
+import urllib.request
+
+
+class LoggerError(Exception):
+    """An error response from the API."""
+
+    def __init__(self, status, message):
+        super().__init__("%d: %s" % (status, message))
+        self.status = status
+        self.message = message
+
+
+def _params(filters):
+    """Encode search filters; list values become repeated parameters."""
+    query = []
+    for key, value in filters.items():
+        if value is None:
+            continue
+        if isinstance(value, bool):
+            value = "true" if value else "false"
+        if isinstance(value, (list, tuple, set)):
+            query.extend((key, str(v)) for v in value)
+        else:
+            query.append((key, str(value)))
+    return urllib.parse.urlencode(query)
+
+
+def _frame(rows, time_columns=("timestamp",)):
+    import pandas as pd
+
+    df = pd.DataFrame(rows)
+    for column in time_columns:
+        if column in df.columns:
+            df[column] = pd.to_datetime(df[column], utc=True, format="ISO8601")
+    return df
+
+
+class LoggerClient:
+    """Client for one logger backend.
+
+    Search methods take the same filters as ``GET /api/logs`` as keyword
+    arguments, for example ``level=["ERROR", "WARN"], since="24h"``.
+    """
+
+    def __init__(self, base_url="http://localhost:8080", admin_token=None, actor=None, timeout=60, msgpack=False):
+        self.base_url = base_url.rstrip("/")
+        self.admin_token = admin_token
+        self.actor = actor
+        self.timeout = timeout
+        self.msgpack = msgpack
+
+    def _request(self, method, path, params=None, body=None, raw=False):
+        url = self.base_url + path
+        if params:
+            url += "?" + _params(params)
+        headers = {}
+        data = None
+        if body is not None:
+            data = json.dumps(body).encode()
+            headers["Content-Type"] = "application/json"
+        if self.admin_token:
+            headers["X-Admin-Token"] = self.admin_token
+        if self.actor:
+            headers["X-Actor"] = self.actor
+        if self.msgpack and not raw:
+            headers["Accept"] = "application/msgpack, application/json"
+        req = urllib.request.Request(url, data=data, headers=headers, method=method)
+        try:
+            with urllib.request.urlopen(req, timeout=self.timeout) as resp:
+                payload = resp.read()
+                content_type = resp.headers.get("Content-Type", "")
+        except urllib.error.HTTPError as e:
+            message = e.read().decode(errors="replace")
+            try:
+                message = json.loads(message).get("error", message)
+            except (ValueError, AttributeError):
+                pass
+            raise LoggerError(e.code, message) from None
+        if raw:
+            return payload
+        if not payload:
+            return None
+        if content_type.startswith("application/msgpack"):
+            import msgpack
+
+            return msgpack.unpackb(payload, raw=False)
+        return json.loads(payload)
+
+    # Ingest
+
+    def ingest(self, **entry):
+        """Ingest one log entry, e.g. ``ingest(level="ERROR", rule="...", sourceIP="...")``."""
+        self._request("POST", "/api/logs", body=entry, raw=True)
+
+    def ingest_summaries(self, summaries):
+        """Ingest per-minute counts, dicts of ``minute``, ``host``, ``level``, ``rule`` and ``count``."""
+        return self._request("POST", "/api/ingest/summaries", body=list(summaries))
+
+    def summaries(self, **filters):
+        """Stored counts filtered by ``from``, ``to``, ``host``, ``level`` and ``rule``."""
+        return self._request("GET", "/api/summaries", filters)
+
+    def edge(self, threshold="WARN", host=None, flush_interval=60):
+        """An ``EdgeAggregator`` that sends logs at or above ``threshold`` and counts the rest."""
+        from .edge import EdgeAggregator
+
+        return EdgeAggregator(self, threshold=threshold, host=host, flush_interval=flush_interval)
+
+    # Search
+
+    def search(self, limit=100, **filters):
+        """Matching logs as a list of dicts."""
+        return self._request("GET", "/api/logs", dict(filters, limit=limit)) or []
+
+    def search_df(self, limit=100, **filters):
+        return _frame(self.search(limit=limit, **filters))
+
+    def count(self, **filters):
+        return self._request("GET", "/api/logs", dict(filters, count_only=True))
+
+    def exists(self, **filters):
+        return self._request("GET", "/api/logs", dict(filters, exists=True))
+
+    def poll(self, after_id=None, wait="30s", limit=100, **filters):
+        """Wait up to ``wait`` for logs newer than ``after_id``; returns ``{"logs", "lastId"}``."""
+        return self._request("GET", "/api/logs/poll", dict(filters, after_id=after_id, wait=wait, limit=limit))
+
+    def follow(self, after_id=None, wait="30s", **filters):
+        """Yield matching logs as they are stored, forever."""
+        while True:
+            batch = self.poll(after_id=after_id, wait=wait, **filters)
+            after_id = batch["lastId"]
+            yield from batch["logs"]
+
+    def group(self, by, limit=100, **filters):
+        """Counts per value of ``by`` (event, rule, level, source, ...)."""
+        return self._request("GET", "/api/logs", dict(filters, group_by=by, limit=limit)) or []
+
+    def group_df(self, by, limit=100, **filters):
+        df = _frame(self.group(by, limit=limit, **filters), time_columns=())
+        return df.rename(columns={"value": by})
+
+    def export_parquet(self, path, **filters):
+        """Download matching logs to a parquet file at ``path``."""
+        data = self._request("GET", "/api/logs/export", dict(filters, format="parquet"), raw=True)
+        with open(path, "wb") as f:
+            f.write(data)
+        return path
+
+    def export_df(self, **filters):
+        """Matching logs loaded through the parquet export, for large result sets."""
+        import io
+
+        import pandas as pd
+
+        data = self._request("GET", "/api/logs/export", dict(filters, format="parquet"), raw=True)
+        return pd.read_parquet(io.BytesIO(data))
+
+    # Aggregations
+
+    def histogram(self, **params):
+        return self._request("GET", "/api/histogram", params)
+
+    def histogram_df(self, **params):
+        return _frame(self.histogram(**params)["buckets"], time_columns=("start",))
+
+    def unique_counts_df(self, **params):
+        return _frame(self._request("GET", "/api/unique", params)["buckets"], time_columns=("start",))
+
+    def top_events(self):
+        return self._request("GET", "/api/top-events")
+
+    def top_sources(self):
+        return self._request("GET", "/api/top-sources")
+
+    def top_destinations(self):
+        return self._request("GET", "/api/top-destinations")
+
+    # Consumers
+
+    def create_consumer(self, name, start="latest", **filter):
+        """Register a consumer (admin); ``start`` is "earliest", "latest" or a log ID."""
+        return self._request("POST", "/api/consumers", body={"name": name, "start": start, "filter": filter})
+
+    def consumer_logs(self, name, limit=100, wait=None):
+        """Logs after the consumer's checkpoint: ``{"checkpoint", "next", "logs"}``."""
+        return self._request("GET", "/api/consumers/%s/logs" % urllib.parse.quote(name), {"limit": limit, "wait": wait})
+
+    def commit(self, name, checkpoint, next):
+        """Move the checkpoint; raises ``LoggerError`` with status 409 if it has moved on."""
+        body = {"checkpoint": checkpoint, "next": next}
+        return self._request("POST", "/api/consumers/%s/commit" % urllib.parse.quote(name), body=body)
+
+    def consume(self, name, handle, limit=100, wait="30s"):
+        """Pass each batch of logs to ``handle`` and commit after it returns, forever."""
+        while True:
+            batch = self.consumer_logs(name, limit=limit, wait=wait)
+            if batch["logs"]:
+                handle(batch["logs"])
+                self.commit(name, batch["checkpoint"], batch["next"])
+
+    # Notables and tags
+
+    def notables(self, **params):
+        return self._request("GET", "/api/notables", params) or []
+
+    def notables_df(self, **params):
+        return _frame(self.notables(**params), time_columns=("timestamp", "createdAt", "updatedAt"))
+
+    def changes(self, since=0, kind=None, limit=100, wait=None):
+        """Notable and alert changes after ``since``: ``{"changes", "next"}``."""
+        return self._request("GET", "/api/changes", {"since": since, "kind": kind, "limit": limit, "wait": wait})
+
+    def tags(self):
+        return self._request("GET", "/api/tags") or []
+
+    def tag_logs(self, tags, all_logs=False, **filters):
+        """Tag every log matching the filters (admin token required); returns the
+        number affected. Tagging without filters needs ``all_logs=True``."""
+        body = {"tags": list(tags), "all": all_logs}
+        return self._request("POST", "/api/logs/tags", filters, body=body)["affected"]
+
+    # Admin
+
+    def sql(self, query, limit=1000):
+        """Run a read-only SQL query (admin token required)."""
+        return self._request("POST", "/api/sql", body={"query": query, "limit": limit})
+
+    def sql_df(self, query, limit=1000):
+        import pandas as pd
+
+        result = self.sql(query, limit=limit)
+        return pd.DataFrame(result["rows"], columns=result["columns"])
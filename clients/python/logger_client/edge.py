@@ -0,0 +1,100 @@
+"""Edge aggregation for chatty log producers.
+
+Logs at or above a severity threshold are sent as they are; the rest are
+only counted per minute, level and rule, and shipped as compact summaries
+to ``POST /api/ingest/summaries``.
+"""
+
+import datetime
+import socket
+import threading
+
+_SEVERITY = {"DEBUG": 0, "INFO": 1, "WARN": 2, "WARNING": 2, "ERROR": 3, "CRITICAL": 4, "FATAL": 4}
+
+
+def _severity(level):
+    return _SEVERITY.get(str(level).upper(), 1)
+
+
+def _minute(timestamp):
+    if timestamp is None:
+        moment = datetime.datetime.now(datetime.timezone.utc)
+    elif isinstance(timestamp, datetime.datetime):
+        moment = timestamp.astimezone(datetime.timezone.utc)
+    else:
+        moment = datetime.datetime.fromisoformat(str(timestamp).replace("Z", "+00:00")).astimezone(datetime.timezone.utc)
+    return moment.replace(second=0, microsecond=0).strftime("%Y-%m-%dT%H:%M:%SZ")
+
+
+class EdgeAggregator:
+    """Sends logs at or above ``threshold`` and counts the others.
+
+    Counts are shipped by ``flush()``, and automatically every
+    ``flush_interval`` seconds when it is set. Use it as a context manager
+    to flush on exit.
+    """
+
+    def __init__(self, client, threshold="WARN", host=None, flush_interval=60):
+        self.client = client
+        self.threshold = _severity(threshold)
+        self.host = host or socket.gethostname()
+        self.flush_interval = flush_interval
+        self._counts = {}
+        self._lock = threading.Lock()
+        self._timer = None
+        if flush_interval:
+            self._schedule()
+
+    def log(self, **entry):
+        """Send the entry, or count it when it is below the threshold."""
+        level = entry.get("level", "INFO")
+        if _severity(level) >= self.threshold:
+            self.client.ingest(**entry)
+            return
+        key = (_minute(entry.get("timestamp")), str(level).upper(), entry.get("rule", ""))
+        with self._lock:
+            self._counts[key] = self._counts.get(key, 0) + 1
+
+    def flush(self):
+        """Ship the counted summaries; they are kept for the next flush if that fails."""
+        with self._lock:
+            counts, self._counts = self._counts, {}
+        if not counts:
+            return 0
+        summaries = [
+            {"minute": minute, "host": self.host, "level": level, "rule": rule, "count": count}
+            for (minute, level, rule), count in counts.items()
+        ]
+        try:
+            self.client.ingest_summaries(summaries)
+        except Exception:
+            with self._lock:
+                for key, count in counts.items():
+                    self._counts[key] = self._counts.get(key, 0) + count
+            raise
+        return len(summaries)
+
+    def close(self):
+        if self._timer:
+            self._timer.cancel()
+            self._timer = None
+        self.flush()
+
+    def __enter__(self):
+        return self
+
+    def __exit__(self, *exc):
+        self.close()
+
+    def _schedule(self):
+        self._timer = threading.Timer(self.flush_interval, self._tick)
+        self._timer.daemon = True
+        self._timer.start()
+
+    def _tick(self):
+        try:
+            self.flush()
+        except Exception:
+            pass
+        if self._timer:
+            self._schedule()
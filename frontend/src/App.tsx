@@ -1,11 +1,14 @@
 import React from 'react';
 import { Dashboard } from './components/Dashboard';
+import { I18nProvider } from './i18n';
 import './App.css';
 
 function App() {
   return (
     <div className="App">
-      <Dashboard />
+      <I18nProvider>
+        <Dashboard />
+      </I18nProvider>
     </div>
   );
 }
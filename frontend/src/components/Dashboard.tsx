@@ -6,13 +6,24 @@ import { TopEventsTable } from './TopEventsTable';
 import { TopSourcesTable } from './TopSourcesTable';
 import { LogSearch } from './LogSearch';
 import { api } from '../services/api';
-import { SummaryStats, UrgencyData, TimelineData, TopEvent, TopSource, LogEntry } from '../types';
+import { useI18n } from '../i18n';
+import { SummaryStats, UniqueCounts, UniqueBucket, UrgencyData, TimelineData, TopEvent, TopSource, LogEntry } from '../types';
 import { Shield, Activity, AlertTriangle, Users } from 'lucide-react';
 
 const REFRESH_OPTIONS = [5, 10, 15, 30];
 
+// Change between the two most recent hourly buckets for a unique-count field
+const uniqueDelta = (counts: UniqueCounts | null, field: keyof Omit<UniqueBucket, 'start'>): number => {
+  if (!counts || counts.buckets.length < 2) return 0;
+  const last = counts.buckets[counts.buckets.length - 1];
+  const prev = counts.buckets[counts.buckets.length - 2];
+  return last[field] - prev[field];
+};
+
 export const Dashboard: React.FC = () => {
+  const { t, lang, languages, setLang } = useI18n();
   const [summaryStats, setSummaryStats] = useState<SummaryStats | null>(null);
+  const [uniqueCounts, setUniqueCounts] = useState<UniqueCounts | null>(null);
   const [urgencyData, setUrgencyData] = useState<UrgencyData | null>(null);
   const [timelineData, setTimelineData] = useState<TimelineData | null>(null);
   const [topEvents, setTopEvents] = useState<TopEvent[]>([]);
@@ -36,7 +47,7 @@ export const Dashboard: React.FC = () => {
       const logs = await api.searchLogs(ip, event);
       setSearchResults(logs);
     } catch (err) {
-      setSearchError('Failed to search logs');
+      setSearchError(t('search.failed'));
     } finally {
       setSearchLoading(false);
     }
@@ -45,15 +56,16 @@ export const Dashboard: React.FC = () => {
   useEffect(() => {
     const fetchData = async () => {
       try {
-        setLoading(true);
         const [
           stats,
+          uniques,
           urgency,
           timeline,
           events,
           sources
         ] = await Promise.all([
           api.getSummaryStats(),
+          api.getUniqueCounts(),
           api.getUrgencyData(),
           api.getTimelineData(),
           api.getTopEvents(),
@@ -61,13 +73,14 @@ export const Dashboard: React.FC = () => {
         ]);
 
         setSummaryStats(stats);
+        setUniqueCounts(uniques);
         setUrgencyData(urgency);
         setTimelineData(timeline);
         setTopEvents(events);
         setTopSources(sources);
         setError(null);
       } catch (err) {
-        setError('Failed to load dashboard data. Please check if the backend server is running.');
+        setError('dashboard.loadError');
         console.error('Dashboard data fetch error:', err);
       } finally {
         setLoading(false);
@@ -90,7 +103,7 @@ export const Dashboard: React.FC = () => {
   if (loading) {
     return (
       <div className="min-h-screen bg-splunk-dark flex items-center justify-center">
-        <div className="text-white text-xl">Loading dashboard...</div>
+        <div className="text-white text-xl">{t('dashboard.loading')}</div>
       </div>
     );
   }
@@ -99,13 +112,13 @@ export const Dashboard: React.FC = () => {
     return (
       <div className="min-h-screen bg-splunk-dark flex items-center justify-center">
         <div className="text-red-400 text-xl text-center max-w-md">
-          {error}
+          {t(error)}
           <br />
           <button 
             onClick={() => window.location.reload()} 
             className="mt-4 px-4 py-2 bg-blue-600 text-white rounded hover:bg-blue-700"
           >
-            Retry
+            {t('dashboard.retry')}
           </button>
         </div>
       </div>
@@ -120,20 +133,20 @@ export const Dashboard: React.FC = () => {
           <div className="flex items-center justify-between h-16">
             <div className="flex items-center space-x-4">
               <Shield className="h-8 w-8 text-blue-400 mr-3" />
-              <h1 className="text-xl font-bold text-white">Security Dashboard</h1>
+              <h1 className="text-xl font-bold text-white">{t('dashboard.title')}</h1>
               <button
                 onClick={handleHome}
                 className="ml-4 px-3 py-1 bg-splunk-gray text-white rounded hover:bg-splunk-light-gray border border-splunk-light-gray"
               >
-                Home
+                {t('dashboard.home')}
               </button>
             </div>
             <div className="flex items-center space-x-4">
               <div className="text-sm text-gray-400">
-                Last updated: {new Date().toLocaleTimeString()}
+                {t('dashboard.lastUpdated', { time: new Date().toLocaleTimeString(lang) })}
               </div>
               <div className="flex items-center">
-                <span className="text-xs text-gray-400 mr-2">Refresh:</span>
+                <span className="text-xs text-gray-400 mr-2">{t('dashboard.refresh')}</span>
                 <select
                   className="bg-splunk-gray text-white border border-splunk-light-gray rounded px-2 py-1 text-xs"
                   value={refreshInterval}
@@ -144,6 +157,20 @@ export const Dashboard: React.FC = () => {
                   ))}
                 </select>
               </div>
+              {languages.length > 1 && (
+                <div className="flex items-center">
+                  <span className="text-xs text-gray-400 mr-2">{t('dashboard.language')}</span>
+                  <select
+                    className="bg-splunk-gray text-white border border-splunk-light-gray rounded px-2 py-1 text-xs"
+                    value={lang}
+                    onChange={e => setLang(e.target.value)}
+                  >
+                    {languages.map(l => (
+                      <option key={l} value={l}>{l}</option>
+                    ))}
+                  </select>
+                </div>
+              )}
             </div>
           </div>
         </div>
@@ -168,31 +195,53 @@ export const Dashboard: React.FC = () => {
         {/* Summary Stats */}
         <div className="grid grid-cols-1 md:grid-cols-2 lg:grid-cols-4 gap-6 mb-8">
           <StatTile
-            title="Access Notables"
+            title={t('tiles.accessNotables')}
             total={summaryStats?.accessNotables.total || 0}
             delta={summaryStats?.accessNotables.delta || 0}
             color="blue"
           />
           <StatTile
-            title="Network Notables"
+            title={t('tiles.networkNotables')}
             total={summaryStats?.networkNotables.total || 0}
             delta={summaryStats?.networkNotables.delta || 0}
             color="green"
           />
           <StatTile
-            title="Threat Notables"
+            title={t('tiles.threatNotables')}
             total={summaryStats?.threatNotables.total || 0}
             delta={summaryStats?.threatNotables.delta || 0}
             color="red"
           />
           <StatTile
-            title="UBA Notables"
+            title={t('tiles.ubaNotables')}
             total={summaryStats?.ubaNotables.total || 0}
             delta={summaryStats?.ubaNotables.delta || 0}
             color="purple"
           />
         </div>
 
+        {/* Unique Counts (last 24h) */}
+        <div className="grid grid-cols-1 md:grid-cols-3 gap-6 mb-8">
+          <StatTile
+            title={t('tiles.uniqueSourceIPs')}
+            total={uniqueCounts?.total.sourceIPs || 0}
+            delta={uniqueDelta(uniqueCounts, 'sourceIPs')}
+            color="blue"
+          />
+          <StatTile
+            title={t('tiles.uniqueUsers')}
+            total={uniqueCounts?.total.users || 0}
+            delta={uniqueDelta(uniqueCounts, 'users')}
+            color="purple"
+          />
+          <StatTile
+            title={t('tiles.uniqueRules')}
+            total={uniqueCounts?.total.rules || 0}
+            delta={uniqueDelta(uniqueCounts, 'rules')}
+            color="green"
+          />
+        </div>
+
         {/* Charts */}
         <div className="grid grid-cols-1 lg:grid-cols-2 gap-6 mb-8">
           <UrgencyChart data={urgencyData!} />
@@ -1,5 +1,7 @@
-import React from 'react';
+import React, { useEffect, useState } from 'react';
 import { LogEntry } from '../types';
+import { api } from '../services/api';
+import { useI18n } from '../i18n';
 
 interface LogSearchProps {
   ip: string;
@@ -22,6 +24,26 @@ export const LogSearch: React.FC<LogSearchProps> = ({
   error,
   onSearch,
 }) => {
+  const { t, lang } = useI18n();
+  const [recentIps, setRecentIps] = useState<string[]>([]);
+  const [recentEvents, setRecentEvents] = useState<string[]>([]);
+
+  // Offer the IPs and events of recent searches, best ranked first; reload
+  // after each search so the one just run is included
+  useEffect(() => {
+    api.getQuerySuggestions()
+      .then((suggestions) => {
+        const values = (key: string) => suggestions
+          .filter((s) => s.kind === 'filter')
+          .map((s) => new URLSearchParams(s.text))
+          .filter((p) => p.has(key))
+          .map((p) => p.get(key) as string);
+        setRecentIps(values('ip'));
+        setRecentEvents(values('event'));
+      })
+      .catch(() => undefined);
+  }, [results]);
+
   const handleSubmit = (e: React.FormEvent) => {
     e.preventDefault();
     onSearch(ip, event);
@@ -30,35 +52,43 @@ export const LogSearch: React.FC<LogSearchProps> = ({
   return (
     <div className="bg-splunk-gray rounded-lg border border-splunk-light-gray mb-8">
       <div className="px-6 py-4 border-b border-splunk-light-gray">
-        <h3 className="text-lg font-semibold text-white">Search Logs</h3>
+        <h3 className="text-lg font-semibold text-white">{t('search.title')}</h3>
       </div>
       <form className="px-6 py-4 flex flex-col md:flex-row gap-4 items-end" onSubmit={handleSubmit}>
         <div className="flex flex-col">
-          <label className="text-gray-400 text-xs mb-1">Source IP</label>
+          <label className="text-gray-400 text-xs mb-1">{t('search.sourceIP')}</label>
           <input
             type="text"
             className="bg-splunk-darker border border-splunk-light-gray rounded px-3 py-2 text-white"
             value={ip}
             onChange={e => setIp(e.target.value)}
-            placeholder="e.g. 192.168.1.100"
+            placeholder={t('search.sourceIPPlaceholder')}
+            list="recent-ips"
           />
+          <datalist id="recent-ips">
+            {recentIps.map(v => <option key={v} value={v} />)}
+          </datalist>
         </div>
         <div className="flex flex-col">
-          <label className="text-gray-400 text-xs mb-1">Event/Rule Name</label>
+          <label className="text-gray-400 text-xs mb-1">{t('search.event')}</label>
           <input
             type="text"
             className="bg-splunk-darker border border-splunk-light-gray rounded px-3 py-2 text-white"
             value={event}
             onChange={e => setEvent(e.target.value)}
-            placeholder="e.g. Suspicious Login"
+            placeholder={t('search.eventPlaceholder')}
+            list="recent-events"
           />
+          <datalist id="recent-events">
+            {recentEvents.map(v => <option key={v} value={v} />)}
+          </datalist>
         </div>
         <button
           type="submit"
           className="bg-blue-600 hover:bg-blue-700 text-white px-6 py-2 rounded font-semibold"
           disabled={loading}
         >
-          {loading ? 'Searching...' : 'Search'}
+          {loading ? t('search.searching') : t('search.submit')}
         </button>
       </form>
       {error && <div className="px-6 pb-4 text-red-400">{error}</div>}
@@ -67,17 +97,17 @@ export const LogSearch: React.FC<LogSearchProps> = ({
           <table className="w-full mt-4">
             <thead className="bg-splunk-darker">
               <tr>
-                <th className="px-3 py-2 text-xs text-gray-400">Timestamp</th>
-                <th className="px-3 py-2 text-xs text-gray-400">Level</th>
-                <th className="px-3 py-2 text-xs text-gray-400">Rule Name</th>
-                <th className="px-3 py-2 text-xs text-gray-400">Source IP</th>
-                <th className="px-3 py-2 text-xs text-gray-400">Message</th>
+                <th className="px-3 py-2 text-xs text-gray-400">{t('columns.timestamp')}</th>
+                <th className="px-3 py-2 text-xs text-gray-400">{t('columns.level')}</th>
+                <th className="px-3 py-2 text-xs text-gray-400">{t('columns.ruleName')}</th>
+                <th className="px-3 py-2 text-xs text-gray-400">{t('columns.sourceIP')}</th>
+                <th className="px-3 py-2 text-xs text-gray-400">{t('columns.message')}</th>
               </tr>
             </thead>
             <tbody className="divide-y divide-splunk-light-gray">
               {results.map((log, idx) => (
                 <tr key={idx} className="hover:bg-splunk-darker">
-                  <td className="px-3 py-2 text-sm text-white font-mono">{new Date(log.timestamp).toLocaleString()}</td>
+                  <td className="px-3 py-2 text-sm text-white font-mono">{new Date(log.timestamp).toLocaleString(lang)}</td>
                   <td className="px-3 py-2 text-sm text-white">{log.level}</td>
                   <td className="px-3 py-2 text-sm text-white">{log.ruleName}</td>
                   <td className="px-3 py-2 text-sm text-white font-mono">{log.sourceIP}</td>
@@ -87,7 +117,7 @@ export const LogSearch: React.FC<LogSearchProps> = ({
             </tbody>
           </table>
         ) : (
-          <div className="text-gray-400 mt-4">No results found.</div>
+          <div className="text-gray-400 mt-4">{t('search.noResults')}</div>
         )}
       </div>
     </div>
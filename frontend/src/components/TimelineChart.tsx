@@ -11,6 +11,7 @@ import {
 } from 'chart.js';
 import { Line } from 'react-chartjs-2';
 import { TimelineData } from '../types';
+import { useI18n } from '../i18n';
 
 ChartJS.register(
   CategoryScale,
@@ -27,6 +28,7 @@ interface TimelineChartProps {
 }
 
 export const TimelineChart: React.FC<TimelineChartProps> = ({ data }) => {
+  const { t } = useI18n();
   const chartData = {
     labels: data.labels,
     datasets: data.series.map(series => ({
@@ -54,7 +56,7 @@ export const TimelineChart: React.FC<TimelineChartProps> = ({ data }) => {
       },
       title: {
         display: true,
-        text: 'Notable Events Over Time',
+        text: t('charts.timelineTitle'),
         color: '#ffffff',
         font: {
           size: 16,
@@ -3,6 +3,7 @@ import { TopEvent } from '../types';
 import { SparklineChart } from './SparklineChart';
 import { api } from '../services/api';
 import { LogEntry } from '../types';
+import { useI18n } from '../i18n';
 
 interface TopEventsTableProps {
   events: TopEvent[];
@@ -12,6 +13,7 @@ const DrilldownModal: React.FC<{
   ruleName: string;
   onClose: () => void;
 }> = ({ ruleName, onClose }) => {
+  const { t, lang } = useI18n();
   const [logs, setLogs] = useState<LogEntry[] | null>(null);
   const [loading, setLoading] = useState(true);
   const [error, setError] = useState<string | null>(null);
@@ -21,7 +23,7 @@ const DrilldownModal: React.FC<{
     setError(null);
     api.searchLogs(undefined, ruleName)
       .then(setLogs)
-      .catch(() => setError('Failed to fetch logs'))
+      .catch(() => setError('drilldown.failed'))
       .finally(() => setLoading(false));
   }, [ruleName]);
 
@@ -29,27 +31,27 @@ const DrilldownModal: React.FC<{
     <div className="fixed inset-0 z-50 flex items-center justify-center bg-black bg-opacity-60">
       <div className="bg-splunk-gray rounded-lg shadow-lg w-full max-w-3xl max-h-[80vh] overflow-y-auto">
         <div className="flex justify-between items-center px-6 py-4 border-b border-splunk-light-gray">
-          <h2 className="text-lg font-bold text-white">Logs for: {ruleName}</h2>
+          <h2 className="text-lg font-bold text-white">{t('drilldown.title', { rule: ruleName })}</h2>
           <button onClick={onClose} className="text-gray-400 hover:text-white text-2xl">&times;</button>
         </div>
         <div className="px-6 py-4">
-          {loading && <div className="text-white">Loading...</div>}
-          {error && <div className="text-red-400">{error}</div>}
-          {logs && logs.length === 0 && <div className="text-gray-400">No logs found for this event.</div>}
+          {loading && <div className="text-white">{t('drilldown.loading')}</div>}
+          {error && <div className="text-red-400">{t(error)}</div>}
+          {logs && logs.length === 0 && <div className="text-gray-400">{t('drilldown.empty')}</div>}
           {logs && logs.length > 0 && (
             <table className="w-full text-sm mt-2">
               <thead className="bg-splunk-darker">
                 <tr>
-                  <th className="px-2 py-2 text-xs text-gray-400">Timestamp</th>
-                  <th className="px-2 py-2 text-xs text-gray-400">Level</th>
-                  <th className="px-2 py-2 text-xs text-gray-400">Source IP</th>
-                  <th className="px-2 py-2 text-xs text-gray-400">Message</th>
+                  <th className="px-2 py-2 text-xs text-gray-400">{t('columns.timestamp')}</th>
+                  <th className="px-2 py-2 text-xs text-gray-400">{t('columns.level')}</th>
+                  <th className="px-2 py-2 text-xs text-gray-400">{t('columns.sourceIP')}</th>
+                  <th className="px-2 py-2 text-xs text-gray-400">{t('columns.message')}</th>
                 </tr>
               </thead>
               <tbody className="divide-y divide-splunk-light-gray">
                 {logs.map((log, idx) => (
                   <tr key={idx} className="hover:bg-splunk-darker">
-                    <td className="px-2 py-2 text-white font-mono">{new Date(log.timestamp).toLocaleString()}</td>
+                    <td className="px-2 py-2 text-white font-mono">{new Date(log.timestamp).toLocaleString(lang)}</td>
                     <td className="px-2 py-2 text-white">{log.level}</td>
                     <td className="px-2 py-2 text-white font-mono">{log.sourceIP}</td>
                     <td className="px-2 py-2 text-white">{log.message}</td>
@@ -65,6 +67,7 @@ const DrilldownModal: React.FC<{
 };
 
 export const TopEventsTable: React.FC<TopEventsTableProps> = ({ events }) => {
+  const { t } = useI18n();
   const [currentPage, setCurrentPage] = useState(1);
   const itemsPerPage = 5;
   const totalPages = Math.ceil(events.length / itemsPerPage);
@@ -101,7 +104,7 @@ export const TopEventsTable: React.FC<TopEventsTableProps> = ({ events }) => {
   return (
     <div className="bg-splunk-gray rounded-lg border border-splunk-light-gray">
       <div className="px-6 py-4 border-b border-splunk-light-gray">
-        <h3 className="text-lg font-semibold text-white">Top Notable Events</h3>
+        <h3 className="text-lg font-semibold text-white">{t('topEvents.title')}</h3>
       </div>
       
       <div className="overflow-x-auto">
@@ -109,16 +112,16 @@ export const TopEventsTable: React.FC<TopEventsTableProps> = ({ events }) => {
           <thead className="bg-splunk-darker">
             <tr>
               <th className="px-6 py-3 text-left text-xs font-medium text-gray-400 uppercase tracking-wider">
-                Rule Name
+                {t('columns.ruleName')}
               </th>
               <th className="px-6 py-3 text-left text-xs font-medium text-gray-400 uppercase tracking-wider">
-                Trend
+                {t('columns.trend')}
               </th>
               <th className="px-6 py-3 text-left text-xs font-medium text-gray-400 uppercase tracking-wider">
-                Count
+                {t('columns.count')}
               </th>
               <th className="px-6 py-3 text-left text-xs font-medium text-gray-400 uppercase tracking-wider">
-                Urgency
+                {t('columns.urgency')}
               </th>
             </tr>
           </thead>
@@ -151,7 +154,7 @@ export const TopEventsTable: React.FC<TopEventsTableProps> = ({ events }) => {
         <div className="px-6 py-4 border-t border-splunk-light-gray">
           <div className="flex items-center justify-between">
             <div className="text-sm text-gray-400">
-              Showing {startIndex + 1} to {Math.min(endIndex, events.length)} of {events.length} results
+              {t('pagination.showing', { start: startIndex + 1, end: Math.min(endIndex, events.length), total: events.length })}
             </div>
             <div className="flex space-x-2">
               <button
@@ -159,17 +162,17 @@ export const TopEventsTable: React.FC<TopEventsTableProps> = ({ events }) => {
                 disabled={currentPage === 1}
                 className="px-3 py-1 text-sm bg-splunk-darker text-white rounded border border-splunk-light-gray disabled:opacity-50 disabled:cursor-not-allowed hover:bg-splunk-light-gray"
               >
-                Previous
+                {t('pagination.previous')}
               </button>
               <span className="px-3 py-1 text-sm text-white">
-                {currentPage} of {totalPages}
+                {t('pagination.page', { page: currentPage, pages: totalPages })}
               </span>
               <button
                 onClick={() => setCurrentPage(Math.min(totalPages, currentPage + 1))}
                 disabled={currentPage === totalPages}
                 className="px-3 py-1 text-sm bg-splunk-darker text-white rounded border border-splunk-light-gray disabled:opacity-50 disabled:cursor-not-allowed hover:bg-splunk-light-gray"
               >
-                Next
+                {t('pagination.next')}
               </button>
             </div>
           </div>
@@ -1,12 +1,14 @@
 import React, { useState } from 'react';
 import { TopSource } from '../types';
 import { SparklineChart } from './SparklineChart';
+import { useI18n } from '../i18n';
 
 interface TopSourcesTableProps {
   sources: TopSource[];
 }
 
 export const TopSourcesTable: React.FC<TopSourcesTableProps> = ({ sources }) => {
+  const { t } = useI18n();
   const [currentPage, setCurrentPage] = useState(1);
   const itemsPerPage = 5;
   const totalPages = Math.ceil(sources.length / itemsPerPage);
@@ -42,7 +44,7 @@ export const TopSourcesTable: React.FC<TopSourcesTableProps> = ({ sources }) =>
   return (
     <div className="bg-splunk-gray rounded-lg border border-splunk-light-gray">
       <div className="px-6 py-4 border-b border-splunk-light-gray">
-        <h3 className="text-lg font-semibold text-white">Top Event Sources</h3>
+        <h3 className="text-lg font-semibold text-white">{t('topSources.title')}</h3>
       </div>
       
       <div className="overflow-x-auto">
@@ -50,16 +52,16 @@ export const TopSourcesTable: React.FC<TopSourcesTableProps> = ({ sources }) =>
           <thead className="bg-splunk-darker">
             <tr>
               <th className="px-6 py-3 text-left text-xs font-medium text-gray-400 uppercase tracking-wider">
-                Source IP
+                {t('columns.sourceIP')}
               </th>
               <th className="px-6 py-3 text-left text-xs font-medium text-gray-400 uppercase tracking-wider">
-                Trend
+                {t('columns.trend')}
               </th>
               <th className="px-6 py-3 text-left text-xs font-medium text-gray-400 uppercase tracking-wider">
-                Count
+                {t('columns.count')}
               </th>
               <th className="px-6 py-3 text-left text-xs font-medium text-gray-400 uppercase tracking-wider">
-                Category
+                {t('columns.category')}
               </th>
             </tr>
           </thead>
@@ -88,7 +90,7 @@ export const TopSourcesTable: React.FC<TopSourcesTableProps> = ({ sources }) =>
         <div className="px-6 py-4 border-t border-splunk-light-gray">
           <div className="flex items-center justify-between">
             <div className="text-sm text-gray-400">
-              Showing {startIndex + 1} to {Math.min(endIndex, sources.length)} of {sources.length} results
+              {t('pagination.showing', { start: startIndex + 1, end: Math.min(endIndex, sources.length), total: sources.length })}
             </div>
             <div className="flex space-x-2">
               <button
@@ -96,17 +98,17 @@ export const TopSourcesTable: React.FC<TopSourcesTableProps> = ({ sources }) =>
                 disabled={currentPage === 1}
                 className="px-3 py-1 text-sm bg-splunk-darker text-white rounded border border-splunk-light-gray disabled:opacity-50 disabled:cursor-not-allowed hover:bg-splunk-light-gray"
               >
-                Previous
+                {t('pagination.previous')}
               </button>
               <span className="px-3 py-1 text-sm text-white">
-                {currentPage} of {totalPages}
+                {t('pagination.page', { page: currentPage, pages: totalPages })}
               </span>
               <button
                 onClick={() => setCurrentPage(Math.min(totalPages, currentPage + 1))}
                 disabled={currentPage === totalPages}
                 className="px-3 py-1 text-sm bg-splunk-darker text-white rounded border border-splunk-light-gray disabled:opacity-50 disabled:cursor-not-allowed hover:bg-splunk-light-gray"
               >
-                Next
+                {t('pagination.next')}
               </button>
             </div>
           </div>
@@ -9,7 +9,8 @@ import {
   Legend,
 } from 'chart.js';
 import { Bar } from 'react-chartjs-2';
-import { UrgencyData } from '../types';
+import { UrgencyCount, UrgencyData } from '../types';
+import { useI18n } from '../i18n';
 
 ChartJS.register(
   CategoryScale,
@@ -24,25 +25,27 @@ interface UrgencyChartProps {
   data: UrgencyData;
 }
 
+// The urgencies of backends that do not send their configuration
+const defaultUrgencies = (data: UrgencyData): UrgencyCount[] => [
+  { value: 4, name: 'critical', color: '#EF4444', count: data.critical },
+  { value: 3, name: 'high', color: '#F59E0B', count: data.high },
+  { value: 2, name: 'medium', color: '#3B82F6', count: data.medium },
+  { value: 1, name: 'low', color: '#22C55E', count: data.low },
+];
+
+const capitalize = (name: string) => name.charAt(0).toUpperCase() + name.slice(1);
+
 export const UrgencyChart: React.FC<UrgencyChartProps> = ({ data }) => {
+  const { t } = useI18n();
+  const urgencies = data.urgencies ?? defaultUrgencies(data);
   const chartData = {
-    labels: ['Critical', 'High', 'Medium', 'Low'],
+    labels: urgencies.map((u) => capitalize(u.name)),
     datasets: [
       {
-        label: 'Notable Events',
-        data: [data.critical, data.high, data.medium, data.low],
-        backgroundColor: [
-          'rgba(239, 68, 68, 0.8)', // Red for critical
-          'rgba(245, 158, 11, 0.8)', // Orange for high
-          'rgba(59, 130, 246, 0.8)', // Blue for medium
-          'rgba(34, 197, 94, 0.8)',  // Green for low
-        ],
-        borderColor: [
-          'rgba(239, 68, 68, 1)',
-          'rgba(245, 158, 11, 1)',
-          'rgba(59, 130, 246, 1)',
-          'rgba(34, 197, 94, 1)',
-        ],
+        label: t('charts.notableEvents'),
+        data: urgencies.map((u) => u.count),
+        backgroundColor: urgencies.map((u) => `${u.color}CC`),
+        borderColor: urgencies.map((u) => u.color),
         borderWidth: 1,
       },
     ],
@@ -57,7 +60,7 @@ export const UrgencyChart: React.FC<UrgencyChartProps> = ({ data }) => {
       },
       title: {
         display: true,
-        text: 'Notable Events by Urgency',
+        text: t('charts.urgencyTitle'),
         color: '#ffffff',
         font: {
           size: 16,
@@ -0,0 +1,70 @@
+import React, { createContext, useCallback, useContext, useEffect, useState } from 'react';
+import { api } from './services/api';
+import { MessageCatalog } from './types';
+
+const LANG_KEY = 'lang';
+
+interface I18n {
+  lang: string;
+  languages: string[];
+  setLang: (lang: string) => void;
+  t: (id: string, params?: Record<string, string | number>) => string;
+}
+
+const I18nContext = createContext<I18n | null>(null);
+
+// Fills {name} placeholders; an unknown id is shown as is so gaps are visible
+const format = (catalog: MessageCatalog, id: string, params?: Record<string, string | number>): string => {
+  const text = catalog.messages[id] ?? id;
+  return params ? text.replace(/\{(\w+)\}/g, (match, name) => String(params[name] ?? match)) : text;
+};
+
+// Loads the message catalog from the backend in the stored language, or the
+// browser's when none was picked
+export const I18nProvider: React.FC<{ children: React.ReactNode }> = ({ children }) => {
+  const [lang, setLangState] = useState<string | undefined>(() => localStorage.getItem(LANG_KEY) ?? undefined);
+  const [catalog, setCatalog] = useState<MessageCatalog | null>(null);
+  const [failed, setFailed] = useState(false);
+
+  useEffect(() => {
+    api.getMessages(lang)
+      .then((c) => {
+        setCatalog(c);
+        setFailed(false);
+        document.documentElement.lang = c.lang;
+      })
+      .catch(() => setFailed(true));
+  }, [lang]);
+
+  const setLang = useCallback((next: string) => {
+    localStorage.setItem(LANG_KEY, next);
+    setLangState(next);
+  }, []);
+
+  if (!catalog) {
+    // Without a catalog there is nothing to translate this with
+    return (
+      <div className="min-h-screen bg-splunk-dark flex items-center justify-center">
+        <div className={failed ? 'text-red-400 text-xl' : 'text-white text-xl'}>
+          {failed ? 'Failed to reach the backend server.' : 'Loading...'}
+        </div>
+      </div>
+    );
+  }
+
+  const value: I18n = {
+    lang: catalog.lang,
+    languages: catalog.languages,
+    setLang,
+    t: (id, params) => format(catalog, id, params),
+  };
+  return <I18nContext.Provider value={value}>{children}</I18nContext.Provider>;
+};
+
+export const useI18n = (): I18n => {
+  const i18n = useContext(I18nContext);
+  if (!i18n) {
+    throw new Error('useI18n must be used inside I18nProvider');
+  }
+  return i18n;
+};
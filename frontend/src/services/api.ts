@@ -1,46 +1,56 @@
-import { SummaryStats, UrgencyData, TimelineData, TopEvent, TopSource, LogEntry } from '../types';
+import { SummaryStats, UniqueCounts, UrgencyData, TimelineData, TopEvent, TopSource, LogEntry, MessageCatalog, QuerySuggestion } from '../types';
 
 const API_BASE_URL = '/api';
 
+// The last response of each dashboard endpoint and its ETag. An unchanged
+// endpoint answers 304 and the cached object is returned as is, so React
+// sees the same state and skips re-rendering.
+const dashboardCache = new Map<string, { etag: string; data: unknown }>();
+
+async function fetchDashboard<T>(path: string, error: string): Promise<T> {
+  const url = `${API_BASE_URL}${path}`;
+  const cached = dashboardCache.get(url);
+  const response = await fetch(url, {
+    cache: 'no-store',
+    headers: cached ? { 'If-None-Match': cached.etag } : undefined,
+  });
+  if (response.status === 304 && cached) {
+    return cached.data as T;
+  }
+  if (!response.ok) {
+    throw new Error(error);
+  }
+  const data = await response.json();
+  const etag = response.headers.get('ETag');
+  if (etag) {
+    dashboardCache.set(url, { etag, data });
+  }
+  return data;
+}
+
 export const api = {
   async getSummaryStats(): Promise<SummaryStats> {
-    const response = await fetch(`${API_BASE_URL}/summary`);
-    if (!response.ok) {
-      throw new Error('Failed to fetch summary stats');
-    }
-    return response.json();
+    return fetchDashboard<SummaryStats>('/summary', 'Failed to fetch summary stats');
+  },
+
+  async getUniqueCounts(): Promise<UniqueCounts> {
+    return fetchDashboard<UniqueCounts>('/unique', 'Failed to fetch unique counts');
   },
 
   async getUrgencyData(): Promise<UrgencyData> {
-    const response = await fetch(`${API_BASE_URL}/urgency`);
-    if (!response.ok) {
-      throw new Error('Failed to fetch urgency data');
-    }
-    return response.json();
+    return fetchDashboard<UrgencyData>('/urgency', 'Failed to fetch urgency data');
   },
 
   async getTimelineData(): Promise<TimelineData> {
-    const response = await fetch(`${API_BASE_URL}/timeline`);
-    if (!response.ok) {
-      throw new Error('Failed to fetch timeline data');
-    }
-    return response.json();
+    return fetchDashboard<TimelineData>('/timeline', 'Failed to fetch timeline data');
   },
 
   async getTopEvents(): Promise<TopEvent[]> {
-    const response = await fetch(`${API_BASE_URL}/top-events`);
-    if (!response.ok) {
-      throw new Error('Failed to fetch top events');
-    }
-    return response.json();
+    return fetchDashboard<TopEvent[]>('/top-events', 'Failed to fetch top events');
   },
 
   async getTopSources(): Promise<TopSource[]> {
-    const response = await fetch(`${API_BASE_URL}/top-sources`);
-    if (!response.ok) {
-      throw new Error('Failed to fetch top sources');
-    }
-    return response.json();
+    return fetchDashboard<TopSource[]>('/top-sources', 'Failed to fetch top sources');
   },
 
   async searchLogs(ip?: string, event?: string): Promise<LogEntry[]> {
@@ -54,6 +64,26 @@ export const api = {
     return response.json();
   },
 
+  // Ranked searches and filters from this client's history
+  async getQuerySuggestions(prefix = '', limit = 50): Promise<QuerySuggestion[]> {
+    const params = new URLSearchParams({ prefix, limit: String(limit) });
+    const response = await fetch(`${API_BASE_URL}/queries/suggest?${params.toString()}`);
+    if (!response.ok) {
+      throw new Error('Failed to fetch query suggestions');
+    }
+    return response.json();
+  },
+
+  // lang is optional; without it the server goes by Accept-Language
+  async getMessages(lang?: string): Promise<MessageCatalog> {
+    const query = lang ? `?lang=${encodeURIComponent(lang)}` : '';
+    const response = await fetch(`${API_BASE_URL}/messages${query}`);
+    if (!response.ok) {
+      throw new Error('Failed to fetch messages');
+    }
+    return response.json();
+  },
+
   async ingestLog(entry: LogEntry): Promise<void> {
     const response = await fetch(`${API_BASE_URL}/logs`, {
       method: 'POST',
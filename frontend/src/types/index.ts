@@ -10,11 +10,32 @@ export interface SummaryStats {
   ubaNotables: StatTile;
 }
 
+export interface UniqueBucket {
+  start: string;
+  sourceIPs: number;
+  users: number;
+  rules: number;
+}
+
+export interface UniqueCounts {
+  buckets: UniqueBucket[];
+  total: UniqueBucket;
+}
+
+export interface UrgencyCount {
+  value: number;
+  name: string;
+  color: string;
+  count: number;
+}
+
 export interface UrgencyData {
   critical: number;
   high: number;
   medium: number;
   low: number;
+  // Every configured urgency, most urgent first
+  urgencies?: UrgencyCount[];
 }
 
 export interface TimelineSeries {
@@ -25,6 +46,8 @@ export interface TimelineSeries {
 
 export interface TimelineData {
   labels: string[];
+  times?: string[];
+  tz?: string;
   series: TimelineSeries[];
 }
 
@@ -49,4 +72,17 @@ export interface LogEntry {
   ruleName: string;
   sourceIP: string;
   metadata: Record<string, string>;
-} 
\ No newline at end of file
+} 
+export interface MessageCatalog {
+  lang: string;
+  languages: string[];
+  messages: Record<string, string>;
+}
+
+export interface QuerySuggestion {
+  text: string;
+  kind: 'query' | 'filter';
+  count: number;
+  lastUsed: string;
+  score: number;
+}
@@ -1,9 +1,12 @@
 package main
 
 import (
+	"crypto/rand"
+	"encoding/base64"
 	"encoding/json"
 	"log"
 	"net/http"
+	"os"
 	"strconv"
 	"strings"
 	"sync"
@@ -22,11 +25,27 @@ type LogEntry struct {
 type InMemoryDB struct {
 	logs []LogEntry
 	mu   sync.RWMutex
+	// levels and minutes are kept up to date by Add so the stats and
+	// metrics endpoints do not walk every log
+	levels  map[string]int
+	minutes [statsMinutes]minuteCount
+}
+
+// statsMinutes is how far back per-minute counts are kept, the day
+// statsAPIHandler reports on
+const statsMinutes = 24 * 60
+
+// minuteCount counts the logs in one Unix minute; each minute has the slot
+// at its index modulo statsMinutes
+type minuteCount struct {
+	minute int64
+	count  int
 }
 
 func NewInMemoryDB() *InMemoryDB {
 	return &InMemoryDB{
-		logs: make([]LogEntry, 0),
+		logs:   make([]LogEntry, 0),
+		levels: make(map[string]int),
 	}
 }
 
@@ -34,6 +53,19 @@ func (db *InMemoryDB) Add(entry LogEntry) {
 	db.mu.Lock()
 	defer db.mu.Unlock()
 	db.logs = append(db.logs, entry)
+	db.levels[entry.Level]++
+	minute := entry.Timestamp.Unix() / 60
+	now := time.Now().Unix() / 60
+	if minute <= now-statsMinutes || minute > now {
+		return
+	}
+	slot := &db.minutes[minute%statsMinutes]
+	if slot.minute < minute {
+		*slot = minuteCount{minute: minute}
+	}
+	if slot.minute == minute {
+		slot.count++
+	}
 }
 
 func (db *InMemoryDB) GetAll() []LogEntry {
@@ -44,7 +76,51 @@ func (db *InMemoryDB) GetAll() []LogEntry {
 	return logsCopy
 }
 
-func (db *InMemoryDB) Filter(level, keyword string, from, to time.Time) []LogEntry {
+// Count returns the number of stored logs and the count per level
+func (db *InMemoryDB) Count() (int, map[string]int) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	levels := make(map[string]int, len(db.levels))
+	for level, n := range db.levels {
+		levels[level] = n
+	}
+	return len(db.logs), levels
+}
+
+// PerMinute calls fn with the start and count of each minute of the last
+// day that has logs
+func (db *InMemoryDB) PerMinute(fn func(start time.Time, count int)) {
+	now := time.Now().Unix() / 60
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	for minute := now - statsMinutes + 1; minute <= now; minute++ {
+		if slot := db.minutes[minute%statsMinutes]; slot.minute == minute && slot.count > 0 {
+			fn(time.Unix(minute*60, 0), slot.count)
+		}
+	}
+}
+
+// TextMatch controls how the keyword filter compares against Message
+type TextMatch struct {
+	Mode          string // contains (default), exact or prefix
+	CaseSensitive bool
+}
+
+func (m TextMatch) matches(text, pattern string) bool {
+	if !m.CaseSensitive {
+		text, pattern = strings.ToLower(text), strings.ToLower(pattern)
+	}
+	switch m.Mode {
+	case "exact":
+		return text == pattern
+	case "prefix":
+		return strings.HasPrefix(text, pattern)
+	default:
+		return strings.Contains(text, pattern)
+	}
+}
+
+func (db *InMemoryDB) Filter(level, keyword string, match TextMatch, from, to time.Time) []LogEntry {
 	db.mu.RLock()
 	defer db.mu.RUnlock()
 	var filtered []LogEntry
@@ -58,7 +134,7 @@ func (db *InMemoryDB) Filter(level, keyword string, from, to time.Time) []LogEnt
 		if !to.IsZero() && log.Timestamp.After(to) {
 			continue
 		}
-		if keyword != "" && !strings.Contains(log.Message, keyword) {
+		if keyword != "" && !match.matches(log.Message, keyword) {
 			continue
 		}
 		filtered = append(filtered, log)
@@ -125,7 +201,23 @@ func logsAPIHandler(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 	}
-	logs := db.Filter(level, keyword, from, to)
+	match := TextMatch{Mode: r.URL.Query().Get("match")}
+	switch match.Mode {
+	case "", "contains", "exact", "prefix":
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("match must be exact, contains or prefix"))
+		return
+	}
+	if v := r.URL.Query().Get("case_sensitive"); v != "" {
+		match.CaseSensitive, err = strconv.ParseBool(v)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("case_sensitive must be true or false"))
+			return
+		}
+	}
+	logs := db.Filter(level, keyword, match, from, to)
 	json.NewEncoder(w).Encode(logs)
 }
 
@@ -137,24 +229,17 @@ func logsStreamHandler(w http.ResponseWriter, r *http.Request) {
 
 func statsAPIHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
-	logs := db.GetAll()
 	// Logs per minute (last hour)
 	perMinute := make(map[string]int)
 	perHour := make(map[string]int)
-	levelCounts := make(map[string]int)
 	cutoffHour := time.Now().Add(-1 * time.Hour)
-	cutoffDay := time.Now().Add(-24 * time.Hour)
-	for _, log := range logs {
-		if log.Timestamp.After(cutoffHour) {
-			min := log.Timestamp.Format("15:04")
-			perMinute[min]++
-		}
-		if log.Timestamp.After(cutoffDay) {
-			hour := log.Timestamp.Format("Jan 2 15:00")
-			perHour[hour]++
+	db.PerMinute(func(start time.Time, count int) {
+		if start.After(cutoffHour) {
+			perMinute[start.Format("15:04")] += count
 		}
-		levelCounts[log.Level]++
-	}
+		perHour[start.Format("Jan 2 15:00")] += count
+	})
+	_, levelCounts := db.Count()
 	json.NewEncoder(w).Encode(map[string]interface{}{
 		"perMinute":   perMinute,
 		"perHour":     perHour,
@@ -164,12 +249,7 @@ func statsAPIHandler(w http.ResponseWriter, r *http.Request) {
 
 func metricsHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
-	logs := db.GetAll()
-	total := len(logs)
-	levelCounts := make(map[string]int)
-	for _, log := range logs {
-		levelCounts[log.Level]++
-	}
+	total, levelCounts := db.Count()
 	uptime := int(time.Since(startTime).Seconds())
 	w.Write([]byte("# HELP logger_logs_total Total number of logs ingested\n"))
 	w.Write([]byte("# TYPE logger_logs_total counter\n"))
@@ -190,7 +270,7 @@ const htmlPage = `
 <head>
     <meta charset="UTF-8">
     <title>Logger UI</title>
-    <style>
+    <style nonce="{{nonce}}">
         body { font-family: Arial, sans-serif; margin: 2em; }
         table { border-collapse: collapse; width: 100%; }
         th, td { border: 1px solid #ccc; padding: 8px; text-align: left; }
@@ -199,7 +279,7 @@ const htmlPage = `
         .charts { display: flex; gap: 2em; margin-bottom: 2em; }
         .chart-container { width: 400px; }
     </style>
-    <script src="https://cdn.jsdelivr.net/npm/chart.js"></script>
+    <script nonce="{{nonce}}" src="{{chartjs}}"></script>
 </head>
 <body>
     <h1>Log Viewer</h1>
@@ -230,7 +310,7 @@ const htmlPage = `
         <label>To:
             <input type="datetime-local" id="toFilter">
         </label>
-        <button onclick="loadLogs()">Search</button>
+        <button id="searchButton">Search</button>
     </div>
     <table id="logsTable">
         <thead>
@@ -243,7 +323,7 @@ const htmlPage = `
         </thead>
         <tbody></tbody>
     </table>
-    <script>
+    <script nonce="{{nonce}}">
         let pollInterval = null;
         function toRFC3339Local(dt) {
             if (!dt) return '';
@@ -270,7 +350,11 @@ const htmlPage = `
             tbody.innerHTML = '';
             logs.forEach(log => {
                 const tr = document.createElement('tr');
-                tr.innerHTML = '<td>' + new Date(log.timestamp).toLocaleString() + '</td><td>' + log.level + '</td><td>' + log.message + '</td><td>' + JSON.stringify(log.metadata) + '</td>';
+                [new Date(log.timestamp).toLocaleString(), log.level, log.message, JSON.stringify(log.metadata)].forEach(text => {
+                    const td = document.createElement('td');
+                    td.textContent = text;
+                    tr.appendChild(td);
+                });
                 tbody.appendChild(tr);
             });
         }
@@ -309,6 +393,7 @@ const htmlPage = `
                 await loadCharts();
             }, 2000);
         }
+        document.getElementById('searchButton').addEventListener('click', loadLogs);
         window.onload = function() {
             loadLogs();
             loadCharts();
@@ -319,9 +404,74 @@ const htmlPage = `
 </html>
 `
 
+// chartJSCDN is where the UI loads Chart.js from unless CHARTJS_PATH points
+// to a local copy, which is then served from chartJSLocalURL
+const (
+	chartJSCDN      = "https://cdn.jsdelivr.net/npm/chart.js"
+	chartJSLocalURL = "/static/chart.js"
+)
+
+var chartJSPath = os.Getenv("CHARTJS_PATH")
+
+// airGapped refuses to serve a UI that would load anything from the internet
+var airGapped = os.Getenv("AIR_GAPPED") == "true"
+
+// chartJS is the bundled Chart.js, read once at startup
+var chartJS []byte
+
+// setSecurityHeaders forbids framing, MIME sniffing and referrers on every UI response
+func setSecurityHeaders(w http.ResponseWriter) {
+	w.Header().Set("X-Frame-Options", "DENY")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Referrer-Policy", "no-referrer")
+}
+
+// contentSecurityPolicy only allows the page's own nonce-tagged scripts and
+// styles, Chart.js from its CDN or this server, and requests back to this server
+func contentSecurityPolicy(nonce string) string {
+	scriptSrc := "'self'"
+	if chartJS == nil {
+		scriptSrc = "https://cdn.jsdelivr.net"
+	}
+	return "default-src 'none'; " +
+		"script-src 'nonce-" + nonce + "' " + scriptSrc + "; " +
+		"style-src 'nonce-" + nonce + "'; " +
+		"img-src 'self' data:; " +
+		"connect-src 'self'; " +
+		"base-uri 'none'; form-action 'self'; frame-ancestors 'none'"
+}
+
+func newNonce() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(b), nil
+}
+
 func uiHandler(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "text/html")
-	w.Write([]byte(htmlPage))
+	nonce, err := newNonce()
+	if err != nil {
+		http.Error(w, "Failed to render page", http.StatusInternalServerError)
+		return
+	}
+	chartSrc := chartJSCDN
+	if chartJS != nil {
+		chartSrc = chartJSLocalURL
+	}
+	setSecurityHeaders(w)
+	w.Header().Set("Content-Security-Policy", contentSecurityPolicy(nonce))
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	page := strings.NewReplacer("{{nonce}}", nonce, "{{chartjs}}", chartSrc).Replace(htmlPage)
+	w.Write([]byte(page))
+}
+
+func chartJSHandler(w http.ResponseWriter, r *http.Request) {
+	setSecurityHeaders(w)
+	w.Header().Set("Content-Type", "text/javascript; charset=utf-8")
+	w.Header().Set("Cache-Control", "public, max-age=86400")
+	w.Write(chartJS)
 }
 
 func startLogIngestServer() {
@@ -334,6 +484,9 @@ func startLogIngestServer() {
 
 func startWebUIServer() {
 	http.HandleFunc("/", uiHandler)
+	if chartJS != nil {
+		http.HandleFunc(chartJSLocalURL, chartJSHandler)
+	}
 	http.HandleFunc("/api/logs", logsAPIHandler)
 	http.HandleFunc("/api/logs/stream", logsStreamHandler)
 	http.HandleFunc("/api/stats", statsAPIHandler)
@@ -345,6 +498,15 @@ func startWebUIServer() {
 }
 
 func main() {
+	if chartJSPath != "" {
+		var err error
+		if chartJS, err = os.ReadFile(chartJSPath); err != nil {
+			log.Fatalf("Failed to read CHARTJS_PATH: %v", err)
+		}
+		log.Printf("Serving Chart.js from %s", chartJSPath)
+	} else if airGapped {
+		log.Fatal("AIR_GAPPED requires CHARTJS_PATH so that the UI does not load Chart.js from the CDN")
+	}
 	go startLogIngestServer()
 	go startWebUIServer()
 	log.Println("Logger application starting...")